@@ -0,0 +1,211 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: dhkalign/translate/v1/translate.proto
+
+package translatev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TranslateService_Translate_FullMethodName      = "/dhkalign.translate.v1.TranslateService/Translate"
+	TranslateService_BatchTranslate_FullMethodName = "/dhkalign.translate.v1.TranslateService/BatchTranslate"
+	TranslateService_Health_FullMethodName         = "/dhkalign.translate.v1.TranslateService/Health"
+)
+
+// TranslateServiceClient is the client API for TranslateService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TranslateService is the gRPC face of backend-go. It shares the HTTP server's translate,
+// cache and upstream path, so answers match /go/translate and /go/translate/batch.
+type TranslateServiceClient interface {
+	// Translate mirrors GET/POST /go/translate.
+	Translate(ctx context.Context, in *TranslateRequest, opts ...grpc.CallOption) (*TranslateResponse, error)
+	// BatchTranslate mirrors POST /go/translate/batch: per-item failures are reported in the
+	// results, request-level problems (no items, too many, duplicate ids) fail the call.
+	BatchTranslate(ctx context.Context, in *BatchTranslateRequest, opts ...grpc.CallOption) (*BatchTranslateResponse, error)
+	// Health mirrors /go/health and needs no API key.
+	Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error)
+}
+
+type translateServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTranslateServiceClient(cc grpc.ClientConnInterface) TranslateServiceClient {
+	return &translateServiceClient{cc}
+}
+
+func (c *translateServiceClient) Translate(ctx context.Context, in *TranslateRequest, opts ...grpc.CallOption) (*TranslateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TranslateResponse)
+	err := c.cc.Invoke(ctx, TranslateService_Translate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *translateServiceClient) BatchTranslate(ctx context.Context, in *BatchTranslateRequest, opts ...grpc.CallOption) (*BatchTranslateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchTranslateResponse)
+	err := c.cc.Invoke(ctx, TranslateService_BatchTranslate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *translateServiceClient) Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HealthResponse)
+	err := c.cc.Invoke(ctx, TranslateService_Health_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TranslateServiceServer is the server API for TranslateService service.
+// All implementations must embed UnimplementedTranslateServiceServer
+// for forward compatibility.
+//
+// TranslateService is the gRPC face of backend-go. It shares the HTTP server's translate,
+// cache and upstream path, so answers match /go/translate and /go/translate/batch.
+type TranslateServiceServer interface {
+	// Translate mirrors GET/POST /go/translate.
+	Translate(context.Context, *TranslateRequest) (*TranslateResponse, error)
+	// BatchTranslate mirrors POST /go/translate/batch: per-item failures are reported in the
+	// results, request-level problems (no items, too many, duplicate ids) fail the call.
+	BatchTranslate(context.Context, *BatchTranslateRequest) (*BatchTranslateResponse, error)
+	// Health mirrors /go/health and needs no API key.
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+	mustEmbedUnimplementedTranslateServiceServer()
+}
+
+// UnimplementedTranslateServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTranslateServiceServer struct{}
+
+func (UnimplementedTranslateServiceServer) Translate(context.Context, *TranslateRequest) (*TranslateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Translate not implemented")
+}
+func (UnimplementedTranslateServiceServer) BatchTranslate(context.Context, *BatchTranslateRequest) (*BatchTranslateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchTranslate not implemented")
+}
+func (UnimplementedTranslateServiceServer) Health(context.Context, *HealthRequest) (*HealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Health not implemented")
+}
+func (UnimplementedTranslateServiceServer) mustEmbedUnimplementedTranslateServiceServer() {}
+func (UnimplementedTranslateServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeTranslateServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TranslateServiceServer will
+// result in compilation errors.
+type UnsafeTranslateServiceServer interface {
+	mustEmbedUnimplementedTranslateServiceServer()
+}
+
+func RegisterTranslateServiceServer(s grpc.ServiceRegistrar, srv TranslateServiceServer) {
+	// If the following call pancis, it indicates UnimplementedTranslateServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TranslateService_ServiceDesc, srv)
+}
+
+func _TranslateService_Translate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TranslateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TranslateServiceServer).Translate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TranslateService_Translate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TranslateServiceServer).Translate(ctx, req.(*TranslateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TranslateService_BatchTranslate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchTranslateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TranslateServiceServer).BatchTranslate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TranslateService_BatchTranslate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TranslateServiceServer).BatchTranslate(ctx, req.(*BatchTranslateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TranslateService_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TranslateServiceServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TranslateService_Health_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TranslateServiceServer).Health(ctx, req.(*HealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TranslateService_ServiceDesc is the grpc.ServiceDesc for TranslateService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TranslateService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dhkalign.translate.v1.TranslateService",
+	HandlerType: (*TranslateServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Translate",
+			Handler:    _TranslateService_Translate_Handler,
+		},
+		{
+			MethodName: "BatchTranslate",
+			Handler:    _TranslateService_BatchTranslate_Handler,
+		},
+		{
+			MethodName: "Health",
+			Handler:    _TranslateService_Health_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "dhkalign/translate/v1/translate.proto",
+}
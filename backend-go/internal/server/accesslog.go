@@ -0,0 +1,180 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats, ACCESS_LOG_FORMAT. common and combined are Apache's, for GoAccess and the
+// like, with the response time in microseconds (Apache's %D) appended; json is the request log
+// line's fields as a line of their own.
+const (
+	accessLogOff      = "off"
+	accessLogJSON     = "json"
+	accessLogCommon   = "common"
+	accessLogCombined = "combined"
+)
+
+// clfTime is the timestamp layout of the common log format, without its brackets.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// accessLog writes one line per request to ACCESS_LOG_FILE, or to stdout without one, apart from
+// the request line in the service log. A config reload reopens the file, so it can be rotated by
+// moving it aside and sending SIGHUP.
+type accessLog struct {
+	format string
+	path   string
+
+	mu sync.Mutex
+	w  io.Writer
+	f  *os.File // nil when writing to stdout
+}
+
+func openAccessLog(format, path string) (*accessLog, error) {
+	a := &accessLog{format: format, path: path, w: os.Stdout}
+	if path == "" {
+		return a, nil
+	}
+	if err := a.reopen(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// reopen starts writing to a fresh handle on the path, closing the old one.
+func (a *accessLog) reopen() error {
+	if a.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("access log: %w", err)
+	}
+	a.mu.Lock()
+	old := a.f
+	a.f, a.w = f, f
+	a.mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+	return nil
+}
+
+func (a *accessLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+func (a *accessLog) write(e accessEntry) {
+	line := e.line(a.format)
+	a.mu.Lock()
+	_, err := a.w.Write(line)
+	a.mu.Unlock()
+	if err != nil {
+		slog.Warn("access log write failed", "err", err)
+	}
+}
+
+// accessEntry is what an access log line says about a request. Path is without the query,
+// which holds the text being translated, as the request log line is.
+type accessEntry struct {
+	Start     time.Time
+	RemoteIP  string
+	User      string // the API key id
+	Method    string
+	Path      string
+	Proto     string
+	Status    int
+	Bytes     int
+	Referer   string
+	UserAgent string
+	Duration  time.Duration
+	RequestID string
+}
+
+// line is e in format, newline included:
+//
+//	common:   203.0.113.7 - acme [14/Oct/2026:07:57:14 +0000] "GET /go/translate HTTP/1.1" 200 176 2232
+//	combined: 203.0.113.7 - acme [14/Oct/2026:07:57:14 +0000] "GET /go/translate HTTP/1.1" 200 176 "-" "curl/8.5.0" 2232
+func (e accessEntry) line(format string) []byte {
+	if format == accessLogJSON {
+		b, _ := json.Marshal(map[string]any{
+			"ts":          e.Start.UTC().Format(time.RFC3339Nano),
+			"request_id":  e.RequestID,
+			"remote_ip":   e.RemoteIP,
+			"key_id":      e.User,
+			"method":      e.Method,
+			"path":        e.Path,
+			"proto":       e.Proto,
+			"status":      e.Status,
+			"bytes":       e.Bytes,
+			"referer":     e.Referer,
+			"user_agent":  e.UserAgent,
+			"duration_us": e.Duration.Microseconds(),
+		})
+		return append(b, '\n')
+	}
+	var b strings.Builder
+	b.WriteString(clfField(e.RemoteIP))
+	b.WriteString(" - ")
+	b.WriteString(clfField(e.User))
+	b.WriteString(" [")
+	b.WriteString(e.Start.UTC().Format(clfTime))
+	b.WriteString(`] "`)
+	b.WriteString(clfEscape(e.Method + " " + e.Path + " " + e.Proto))
+	b.WriteString(`" `)
+	b.WriteString(strconv.Itoa(e.Status))
+	b.WriteByte(' ')
+	if e.Bytes > 0 {
+		b.WriteString(strconv.Itoa(e.Bytes))
+	} else {
+		b.WriteByte('-')
+	}
+	if format == accessLogCombined {
+		b.WriteString(` "`)
+		b.WriteString(clfEscape(cmp.Or(e.Referer, "-")))
+		b.WriteString(`" "`)
+		b.WriteString(clfEscape(cmp.Or(e.UserAgent, "-")))
+		b.WriteByte('"')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(e.Duration.Microseconds(), 10))
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// clfField is an unquoted field: "-" when empty, and escaped with any space taken out, so the
+// line still splits on spaces.
+func clfField(s string) string {
+	return strings.ReplaceAll(clfEscape(cmp.Or(s, "-")), " ", `\x20`)
+}
+
+// clfEscape escapes s the way Apache escapes logged values: backslash and double quote with a
+// backslash, and control and non-ASCII bytes as \xhh.
+func clfEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	stdflag "flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = stdflag.Bool("update", false, "rewrite the golden files under testdata from the current output")
+
+// golden compares got with testdata/name, or with -update writes got there.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s differs (run with -update if the change is intended):\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// accessEntries are requests worth a line each: a plain one, one with nothing optional, and
+// ones whose fields need escaping.
+var accessEntries = []accessEntry{
+	{
+		Start: time.Date(2026, time.October, 14, 7, 57, 14, 120e6, time.UTC), RemoteIP: "203.0.113.7", User: "acme",
+		Method: "GET", Path: "/go/translate", Proto: "HTTP/1.1", Status: 200, Bytes: 176,
+		Referer: "https://dhkalign.example/", UserAgent: "curl/8.5.0", Duration: 2232 * time.Microsecond, RequestID: "req-1",
+	},
+	{
+		Start: time.Date(2026, time.October, 14, 7, 57, 15, 0, time.FixedZone("MVT", 5*3600)), RemoteIP: "2001:db8::1",
+		Method: "HEAD", Path: "/go/health", Proto: "HTTP/2.0", Status: 204, Duration: 41 * time.Microsecond, RequestID: "req-2",
+	},
+	{
+		Start: time.Date(2026, time.October, 14, 7, 57, 16, 0, time.UTC), RemoteIP: "198.51.100.2", User: "key with space",
+		Method: "POST", Path: `/go/admin/"keys"\ގ`, Proto: "HTTP/1.1", Status: 401, Bytes: 61,
+		UserAgent: "agent \"quoted\"\tand tabbed", Duration: 1500 * time.Millisecond, RequestID: "req-3",
+	},
+}
+
+func TestAccessLogGolden(t *testing.T) {
+	for _, format := range []string{accessLogCommon, accessLogCombined, accessLogJSON} {
+		t.Run(format, func(t *testing.T) {
+			var got []byte
+			for _, e := range accessEntries {
+				got = append(got, e.line(format)...)
+			}
+			golden(t, filepath.Join("accesslog", format+".golden"), got)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Adaptive upstream timeouts. UPSTREAM_TIMEOUT has to allow for the longest input, so a short
+// phrase whose call has stalled waits as long as a paragraph would before it is retried. With
+// UPSTREAM_ADAPTIVE_TIMEOUT each upstream member keeps the latency of its recent calls in three
+// input length classes over a five-minute window, and the first attempt of a call gets its
+// class's UPSTREAM_ADAPTIVE_QUANTILE latency times UPSTREAM_ADAPTIVE_MULTIPLIER, no less than
+// UPSTREAM_ADAPTIVE_FLOOR and never more than UPSTREAM_TIMEOUT. A class with fewer than
+// UPSTREAM_ADAPTIVE_MIN_SAMPLES calls in the window, or any call while the adaptive_timeout flag
+// is off, gets UPSTREAM_TIMEOUT. Retries always do, and an attempt cut off by an adaptive
+// timeout counts as a call that took that long, so a backend that has slowed down pulls the
+// quantile up instead of timing out against an old one. Each upstream stage of a provenance
+// says which timeout its call got; /go/admin/upstreams shows what each class would get now.
+
+// Input length classes, by characters of normalized input.
+const (
+	lengthShort  = "short"  // up to lengthShortMax
+	lengthMedium = "medium" // up to lengthMediumMax
+	lengthLong   = "long"
+)
+
+const (
+	lengthShortMax  = 32
+	lengthMediumMax = 256
+)
+
+var lengthClasses = [...]string{lengthShort, lengthMedium, lengthLong}
+
+// lengthClassOf is q's index in lengthClasses.
+func lengthClassOf(q string) int {
+	switch n := utf8.RuneCountInString(q); {
+	case n <= lengthShortMax:
+		return 0
+	case n <= lengthMediumMax:
+		return 1
+	}
+	return 2
+}
+
+// Timeout bases.
+const (
+	timeoutStatic   = "static"   // UPSTREAM_TIMEOUT: adaptive off, or too few samples
+	timeoutAdaptive = "adaptive" // from the class's latency
+)
+
+// adaptiveTimeoutOpts is UPSTREAM_ADAPTIVE_*, with UPSTREAM_TIMEOUT as the ceiling.
+type adaptiveTimeoutOpts struct {
+	Ceiling    time.Duration
+	Floor      time.Duration
+	Quantile   float64
+	Factor     float64
+	MinSamples int
+}
+
+// upstreamTimeout is the timeout one call got, and why.
+type upstreamTimeout struct {
+	Limit   time.Duration `json:"-"`
+	MS      float64       `json:"ms"`
+	Class   string        `json:"class"`
+	Basis   string        `json:"basis"`
+	Samples uint64        `json:"samples"` // the class's calls in the window
+}
+
+// adaptiveTimeouts is one upstream member's latency windows, one per length class. A nil
+// *adaptiveTimeouts picks nothing and records nothing.
+type adaptiveTimeouts struct {
+	opts  adaptiveTimeoutOpts
+	flags *featureFlags // adaptive_timeout off picks the ceiling
+	clock Clock
+
+	mu      sync.Mutex
+	windows [len(lengthClasses)][upstreamWindowSlots]windowSlot
+}
+
+func newAdaptiveTimeouts(opts adaptiveTimeoutOpts, flags *featureFlags, clk Clock) *adaptiveTimeouts {
+	return &adaptiveTimeouts{opts: opts, flags: flags, clock: clk}
+}
+
+// observe records a call for input q that took d.
+func (a *adaptiveTimeouts) observe(q string, d time.Duration) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	s := windowSlotAt(&a.windows[lengthClassOf(q)], a.clock.Now())
+	s.calls++
+	s.latency.observe(d)
+	a.mu.Unlock()
+}
+
+// pick is the timeout for a call translating q.
+func (a *adaptiveTimeouts) pick(q string) upstreamTimeout {
+	if a == nil {
+		return upstreamTimeout{}
+	}
+	return a.forClass(lengthClassOf(q))
+}
+
+func (a *adaptiveTimeouts) forClass(c int) upstreamTimeout {
+	t := upstreamTimeout{Limit: a.opts.Ceiling, Class: lengthClasses[c], Basis: timeoutStatic}
+	var merged latencyHistogram
+	a.mu.Lock()
+	oldest := a.clock.Now().Unix()/60 - upstreamWindowSlots + 1
+	for i := range a.windows[c] {
+		s := &a.windows[c][i]
+		if s.minute < oldest {
+			continue
+		}
+		t.Samples += s.calls
+		for b := range s.latency.buckets {
+			merged.buckets[b].Add(s.latency.buckets[b].Load())
+		}
+	}
+	a.mu.Unlock()
+	if a.flags.on(flagAdaptiveTimeout) && t.Samples >= uint64(a.opts.MinSamples) {
+		qms := merged.quantiles(a.opts.Quantile)[0]
+		d := time.Duration(qms * a.opts.Factor * float64(time.Millisecond))
+		t.Limit, t.Basis = min(max(d, a.opts.Floor), a.opts.Ceiling), timeoutAdaptive
+	}
+	t.MS = ms(t.Limit)
+	return t
+}
+
+// current is what each class would get now, for /go/admin/upstreams; nil when adaptive is off.
+func (a *adaptiveTimeouts) current() map[string]upstreamTimeout {
+	if a == nil {
+		return nil
+	}
+	out := make(map[string]upstreamTimeout, len(lengthClasses))
+	for c, name := range lengthClasses {
+		out[name] = a.forClass(c)
+	}
+	return out
+}
+
+type upstreamTimeoutKey struct{}
+
+// withUpstreamTimeout has the upstream client give the first attempt of calls made with ctx t's
+// limit, when t is adaptive.
+func withUpstreamTimeout(ctx context.Context, t upstreamTimeout) context.Context {
+	if t.Basis != timeoutAdaptive {
+		return ctx
+	}
+	return context.WithValue(ctx, upstreamTimeoutKey{}, t.Limit)
+}
+
+// adaptiveLimit is the first-attempt limit on ctx, 0 for none.
+func adaptiveLimit(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(upstreamTimeoutKey{}).(time.Duration)
+	return d
+}
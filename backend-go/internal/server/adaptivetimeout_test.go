@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// quantileOf is the q quantile of samples, as the histogram ranks it.
+func quantileOf(samples []time.Duration, q float64) time.Duration {
+	s := slices.Clone(samples)
+	slices.Sort(s)
+	return s[int(math.Ceil(q*float64(len(s))))-1]
+}
+
+// TestAdaptiveTimeouts feeds each length class a synthetic latency distribution and checks the
+// timeout follows its p99 times the multiplier, within the histogram's 10%, held between the
+// floor and the ceiling; that it follows a class that slows down and forgets calls older than
+// the window; and that too few samples or the flag off give the ceiling.
+func TestAdaptiveTimeouts(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	flags := newFeatureFlags(clk.Now())
+	opts := adaptiveTimeoutOpts{Ceiling: 10 * time.Second, Floor: 50 * time.Millisecond, Quantile: 0.99, Factor: 3, MinSamples: 100}
+	a := newAdaptiveTimeouts(opts, flags, clk)
+	short, medium, long := strings.Repeat("a", lengthShortMax), strings.Repeat("a", lengthShortMax+1), strings.Repeat("a", lengthMediumMax+1)
+
+	rnd := rand.New(rand.NewSource(1))
+	uniform := func(lo, hi time.Duration) func() time.Duration {
+		return func() time.Duration { return lo + time.Duration(rnd.Int63n(int64(hi-lo))) }
+	}
+	feed := func(q string, n int, draw func() time.Duration) []time.Duration {
+		out := make([]time.Duration, n)
+		for i := range out {
+			out[i] = draw()
+			a.observe(q, out[i])
+		}
+		return out
+	}
+	check := func(q string, samples []time.Duration, class string) {
+		t.Helper()
+		got := a.pick(q)
+		want := time.Duration(float64(quantileOf(samples, opts.Quantile)) * opts.Factor)
+		want = min(max(want, opts.Floor), opts.Ceiling)
+		if got.Class != class || got.Basis != timeoutAdaptive || got.Samples != uint64(len(samples)) ||
+			got.Limit < want || got.Limit > min(want*11/10+time.Millisecond, opts.Ceiling) {
+			t.Fatalf("%s: %+v, want an adaptive %v over %d samples", class, got, want, len(samples))
+		}
+	}
+
+	// Below MinSamples a class keeps the ceiling.
+	for _, q := range []string{short, medium, long} {
+		if got := a.pick(q); got.Limit != opts.Ceiling || got.Basis != timeoutStatic {
+			t.Fatalf("%d chars with no samples: %+v, want the static ceiling", len(q), got)
+		}
+	}
+	shortS := feed(short, opts.MinSamples-1, uniform(20*time.Millisecond, 80*time.Millisecond))
+	if got := a.pick(short); got.Basis != timeoutStatic || got.Samples != uint64(opts.MinSamples-1) {
+		t.Fatalf("one sample short of the minimum: %+v", got)
+	}
+
+	// Uniform 20-80ms for short, exponential around 300ms for medium, seconds for long.
+	shortS = append(shortS, feed(short, 1000, uniform(20*time.Millisecond, 80*time.Millisecond))...)
+	mediumS := feed(medium, 1000, func() time.Duration { return time.Duration(rnd.ExpFloat64() * float64(300*time.Millisecond)) })
+	longS := feed(long, 200, uniform(2*time.Second, 5*time.Second))
+	check(short, shortS, lengthShort)
+	check(medium, mediumS, lengthMedium)
+	check(long, longS, lengthLong)
+	if got := a.pick(long); got.Limit != opts.Ceiling {
+		t.Fatalf("long, p99 about 5s: %v, want held to the %v ceiling", got.Limit, opts.Ceiling)
+	}
+	if s, m := a.pick(short).Limit, a.pick(medium).Limit; s >= m {
+		t.Fatalf("short %v, medium %v: longer inputs should get longer", s, m)
+	}
+
+	// The short class slows to 300-400ms; the timeout rises with it.
+	clk.Advance(time.Minute)
+	shortS = append(shortS, feed(short, 1000, uniform(300*time.Millisecond, 400*time.Millisecond))...)
+	check(short, shortS, lengthShort)
+	if got := a.pick(short).Limit; got < 900*time.Millisecond {
+		t.Fatalf("short after slowing to 400ms: %v", got)
+	}
+
+	// Five minutes on, only what came since counts: 1ms calls, held to the floor.
+	clk.Advance(upstreamWindowSlots * time.Minute)
+	fast := feed(short, opts.MinSamples, func() time.Duration { return time.Millisecond })
+	check(short, fast, lengthShort)
+	if got := a.pick(short).Limit; got != opts.Floor {
+		t.Fatalf("1ms calls: %v, want the %v floor", got, opts.Floor)
+	}
+	if got := a.pick(medium); got.Basis != timeoutStatic || got.Samples != 0 {
+		t.Fatalf("medium after the window passed: %+v, want its calls forgotten", got)
+	}
+
+	flags.set(map[flag]bool{flagAdaptiveTimeout: false}, "test", clk.Now())
+	for _, tm := range a.current() {
+		if tm.Basis != timeoutStatic || tm.Limit != opts.Ceiling {
+			t.Fatalf("flag off: %+v, want the static ceiling", tm)
+		}
+	}
+	var none *adaptiveTimeouts
+	if got := none.pick(short); got != (upstreamTimeout{}) || none.current() != nil {
+		t.Fatalf("nil timeouts picked %+v", got)
+	}
+}
+
+// TestLengthClasses checks the class bounds count characters, not bytes.
+func TestLengthClasses(t *testing.T) {
+	for _, tt := range []struct {
+		q    string
+		want string
+	}{
+		{"", lengthShort},
+		{strings.Repeat("a", lengthShortMax), lengthShort},
+		{strings.Repeat("ހ", lengthShortMax), lengthShort},
+		{strings.Repeat("a", lengthShortMax+1), lengthMedium},
+		{strings.Repeat("ހ", lengthMediumMax), lengthMedium},
+		{strings.Repeat("a", lengthMediumMax+1), lengthLong},
+	} {
+		if got := lengthClasses[lengthClassOf(tt.q)]; got != tt.want {
+			t.Errorf("%d bytes, %d chars: %s, want %s", len(tt.q), len([]rune(tt.q)), got, tt.want)
+		}
+	}
+}
+
+// TestAdaptiveTimeoutUpstream warms a member's short class on quick calls, then sends one whose
+// first attempt stalls: the adaptive floor cuts it, the retry under UPSTREAM_TIMEOUT answers,
+// and the upstream stage of the provenance and /go/admin/upstreams show the timeout picked.
+func TestAdaptiveTimeoutUpstream(t *testing.T) {
+	var stalls atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "stall" && stalls.Add(1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "data": map[string]string{"tgt": "T"}})
+	}))
+	defer up.Close()
+	h := newTestServer(t, map[string]string{
+		"UPSTREAM_URL":                  up.URL,
+		"UPSTREAM_TIMEOUT":              "5s",
+		"UPSTREAM_MAX_ATTEMPTS":         "2",
+		"UPSTREAM_ADAPTIVE_TIMEOUT":     "true",
+		"UPSTREAM_ADAPTIVE_MIN_SAMPLES": "3",
+		"UPSTREAM_ADAPTIVE_FLOOR":       "100ms",
+	}, Deps{}).Handler()
+	upstreamStage := func(q string) provenanceStage {
+		t.Helper()
+		w := serve(h, "GET", "/go/translate?src=dv&dst=en&debug=1&q="+q, "", "X-API-Key", testProKey)
+		var out struct {
+			Provenance provenanceView `json:"provenance"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &out)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", q, w.Code, w.Body.String())
+		}
+		i := slices.IndexFunc(out.Provenance.Stages, func(s provenanceStage) bool { return s.Layer == "upstream" })
+		if i < 0 || out.Provenance.Stages[i].Timeout == nil {
+			t.Fatalf("%s: no upstream stage with a timeout: %s", q, w.Body.String())
+		}
+		return out.Provenance.Stages[i]
+	}
+
+	if st := upstreamStage(consonantWord(1)); *st.Timeout != (upstreamTimeout{MS: 5000, Class: lengthShort, Basis: timeoutStatic}) {
+		t.Fatalf("first call: timeout %+v, want the static 5s", st.Timeout)
+	}
+	upstreamStage(consonantWord(2))
+	upstreamStage(consonantWord(3))
+	start := time.Now()
+	st := upstreamStage("stall")
+	if took := time.Since(start); st.Attempts != 2 || took > 2*time.Second {
+		t.Fatalf("stalled call: %d attempts in %v, want cut off and retried", st.Attempts, took)
+	}
+	if *st.Timeout != (upstreamTimeout{MS: 100, Class: lengthShort, Basis: timeoutAdaptive, Samples: 3}) {
+		t.Fatalf("stalled call: timeout %+v, want the adaptive 100ms floor over 3 samples", st.Timeout)
+	}
+
+	var list struct {
+		Upstreams []struct {
+			Timeouts map[string]upstreamTimeout `json:"timeouts"`
+		} `json:"upstreams"`
+	}
+	json.Unmarshal(serve(h, "GET", "/go/admin/upstreams", "", "Authorization", "Bearer "+testAdmin).Body.Bytes(), &list)
+	if len(list.Upstreams) != 1 {
+		t.Fatalf("upstreams %+v", list)
+	}
+	// Both attempts count, the cut-off one as taking the 100ms limit, so the class left the floor.
+	if tm := list.Upstreams[0].Timeouts; tm[lengthShort].Samples != 5 || tm[lengthShort].MS < 300 || tm[lengthMedium].Basis != timeoutStatic {
+		t.Fatalf("/go/admin/upstreams timeouts %+v", tm)
+	}
+}
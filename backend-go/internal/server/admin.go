@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// adminFrom returns the id of the admin token that authenticated the request.
+func adminFrom(ctx context.Context) string {
+	id, _ := ctx.Value(adminCtxKey).(string)
+	return id
+}
+
+// requireAdmin accepts "Authorization: Bearer <token>" for a configured ADMIN_TOKEN. Admin tokens
+// are a separate set from client API keys, so a leaked client key can't reach these routes.
+func requireAdmin(ks *keyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, msg := adminToken(ks, r)
+			if msg != "" {
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, msg)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminCtxKey, id)))
+		})
+	}
+}
+
+// adminToken returns the id of the admin token on r, or why there isn't a valid one.
+func adminToken(ks *keyStore, r *http.Request) (id, msg string) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", "missing admin token"
+	}
+	k, ok := ks.lookup(token)
+	if !ok || k.refusal(ks.clock.Now()) != "" {
+		return "", "invalid admin token"
+	}
+	return k.id, ""
+}
+
+// adminRoutes mounts under /go/admin; New only calls it when ADMIN_TOKEN is set. ks holds the
+// admin tokens and keys the client API keys; seedPath is CACHE_SEED_PATH. packs is nil with
+// neither PACK_DIR nor the embedded packs, upstream in stub mode, tm without TM_DB_PATH and jobs
+// without JOBS_DB_PATH, faults with ENV=production. pg signs the list cursors. Every route is audited, reads included.
+func adminRoutes(ks, keys *keyStore, ct *cachedTranslator, seedPath string, packs *packSet, upstream *failoverTranslator, mirror *mirrorTranslator, faults *faultInjector, usage *usageMeter, keyConc *keyConcurrency, maint *maintenanceMode, audit *auditLog, live *liveConfig, svc *translateService, glossaries *clientGlossaries, tm *translationMemory, jobs *jobStore, arts *artifactStore, pg *pager, inflight *inflightRegistry, warm warmOpts, check packCheckOpts) func(chi.Router) {
+	return func(r chi.Router) {
+		r.Use(requireAdmin(ks))
+		r.With(audit.audited("cache.invalidate")).Delete("/cache", cacheInvalidateHandler(ct))
+		r.With(audit.audited("cache.export")).Get("/cache/export", cacheExportHandler(ct, arts))
+		r.With(audit.audited("exports.download")).Get("/exports/{id}", arts.downloadHandler)
+		r.With(audit.audited("cache.snapshot")).Get("/cache/snapshot", cacheSnapshotHandler(ct, seedPath))
+		r.With(audit.audited("cache.warm"), routeTimeout(warm.Timeout, warm.Floor), limitBody(warm.MaxBodyBytes)).Post("/cache/warm", cacheWarmHandler(svc, warm))
+		r.With(audit.audited("usage.read")).Get("/usage", adminUsageHandler(usage, keyConc))
+		r.With(audit.audited("inflight.list")).Get("/inflight", inflightListHandler(inflight))
+		r.With(audit.audited("inflight.cancel")).Delete("/inflight/{request_id}", inflightCancelHandler(inflight))
+		r.With(audit.audited("faults.read")).Get("/faults", faultsHandler(faults))
+		r.With(audit.audited("faults.set")).Put("/faults", faultsPutHandler(faults))
+		if usage.store != nil {
+			r.With(audit.audited("usage.report")).Get("/reports", usageReportHandler(usage.store, arts))
+		}
+		r.With(audit.audited("maintenance.set")).Post("/maintenance", maintenanceHandler(maint))
+		r.With(audit.audited("flags.read")).Get("/flags", flagsHandler(svc.flags))
+		r.With(audit.audited("flags.set")).Patch("/flags", flagsPatchHandler(svc.flags, svc.clock))
+		r.With(audit.audited("config.read")).Get("/config", configHandler(live))
+		r.With(audit.audited("config.reload")).Post("/reload", reloadHandler(live))
+		r.With(audit.audited("audit.read")).Get("/audit", auditHandler(audit, pg))
+		r.With(audit.audited("keys.list")).Get("/keys", keyListHandler(keys, pg))
+		r.With(audit.audited("keys.create")).Post("/keys", keyMintHandler(keys))
+		r.With(audit.audited("keys.scopes")).Patch("/keys/{id}", keyPatchHandler(keys))
+		r.With(audit.audited("keys.revoke")).Delete("/keys/{id}", keyRevokeHandler(keys))
+		r.With(audit.audited("keys.glossary.read")).Get("/keys/{id}/glossary", glossaryGetHandler(glossaries, adminGlossaryKey(keys)))
+		r.With(audit.audited("keys.glossary.set")).Put("/keys/{id}/glossary", glossaryPutHandler(glossaries, adminGlossaryKey(keys)))
+		r.With(audit.audited("keys.glossary.delete")).Delete("/keys/{id}/glossary", glossaryDeleteHandler(glossaries, adminGlossaryKey(keys)))
+		if packs != nil {
+			r.With(audit.audited("packs.reload")).Post("/packs/reload", packReloadHandler(packs))
+			r.With(audit.audited("packs.status")).Get("/packs/status", packSyncStatusHandler(packs.sync))
+		}
+		if packs != nil || tm != nil {
+			r.With(audit.audited("packs.check"), limitBody(check.MaxBodyBytes)).Post("/packs/check", packCheckHandler(svc, packs, tm, check))
+		}
+		if jobs != nil {
+			r.With(audit.audited("jobs.list")).Get("/jobs", jobListHandler(jobs, pg))
+		}
+		if tm != nil {
+			r.With(audit.audited("tm.list")).Get("/tm", tmListHandler(tm, pg))
+			r.With(audit.audited("tm.correct")).Put("/tm", tmCorrectHandler(tm, ct))
+			r.With(audit.audited("tm.delete")).Delete("/tm", tmDeleteHandler(tm, ct))
+			r.With(audit.audited("tm.export")).Get("/tm/export", tmExportHandler(tm, arts))
+		}
+		if upstream != nil {
+			r.With(audit.audited("upstreams.list")).Get("/upstreams", upstreamListHandler(upstream))
+			r.With(audit.audited("upstreams.weights")).Put("/upstreams/weights", upstreamWeightsHandler(upstream))
+			r.With(audit.audited("mirror.report")).Get("/mirror", mirrorReportHandler(mirror))
+		}
+	}
+}
+
+// packReloadHandler serves POST /go/admin/packs/reload. A pack that fails to parse is a 422 and
+// leaves the previous packs serving.
+func packReloadHandler(packs *packSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := packs.reload("admin:" + adminFrom(r.Context()))
+		if err != nil {
+			auditParam(r.Context(), "error", err.Error())
+			writeError(w, http.StatusUnprocessableEntity, codeRejected, "pack reload rejected", "detail", err.Error(), "entries", res.OldEntries)
+			return
+		}
+		auditParam(r.Context(), "entries", res.NewEntries)
+		j(w, http.StatusOK, map[string]any{
+			"old_entries": res.OldEntries,
+			"new_entries": res.NewEntries,
+			"files":       res.Files,
+			"duration_ms": float64(res.Duration.Microseconds()) / 1000,
+		})
+	}
+}
+
+// cacheInvalidateHandler serves DELETE /go/admin/cache. With ?q= (and optional src/dst, and
+// extended for the entry pro keys are served) it drops that one entry; without it, it flushes the
+// whole cache. Both tiers are cleared.
+func cacheInvalidateHandler(ct *cachedTranslator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var (
+			scope = "all"
+			rm    removal
+			err   error
+		)
+		if q.Has("q") {
+			if strings.TrimSpace(q.Get("q")) == "" {
+				writeError(w, http.StatusBadRequest, codeMissingQuery, "empty query param 'q'")
+				return
+			}
+			scope = "key"
+			rm, err = ct.invalidate(r.Context(), translateReq{Q: q.Get("q"), Src: q.Get("src"), Dst: q.Get("dst"), Extended: queryBool(q.Get("extended"))})
+		} else if q.Has("src") || q.Has("dst") || q.Has("extended") {
+			writeError(w, http.StatusBadRequest, codeMissingQuery, "src/dst/extended need 'q'; omit them all to flush")
+			return
+		} else {
+			rm, err = ct.flush(r.Context())
+		}
+		auditParam(r.Context(), "scope", scope)
+		auditParam(r.Context(), "removed", rm.Cache)
+
+		attrs := []any{
+			"request_id", middleware.GetReqID(r.Context()),
+			"admin_id", adminFrom(r.Context()),
+			"scope", scope,
+			"removed", rm.Cache,
+			"removed_persistent", rm.Store,
+			"removed_errors", rm.Errors,
+		}
+		if err != nil {
+			slog.Error("admin cache invalidate failed", append(attrs, "err", err)...)
+			writeError(w, http.StatusBadGateway, codeInternal, "cache invalidation failed", "detail", err.Error())
+			return
+		}
+		slog.Info("admin cache invalidate", attrs...)
+		out := map[string]any{"scope": scope, "removed": rm.Cache}
+		if ct.store != nil {
+			out["removed_persistent"] = rm.Store
+		}
+		if ct.errors != nil {
+			out["removed_errors"] = rm.Errors
+		}
+		j(w, http.StatusOK, out)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestAdminCacheInvalidate drops one cached phrase and then the whole cache through DELETE
+// /go/admin/cache, checking the counts reported and logged and that only what was removed goes
+// back to the upstream.
+func TestAdminCacheInvalidate(t *testing.T) {
+	up := &echoUpstream{}
+	h := newTestServer(t, nil, Deps{Upstream: up}).Handler()
+	translate := func(q string) {
+		t.Helper()
+		if w := serve(h, "GET", "/go/translate?src=dv&dst=en&q="+q, "", "X-API-Key", testProKey); w.Code != http.StatusOK {
+			t.Fatalf("translate %s: status %d: %s", q, w.Code, w.Body.String())
+		}
+	}
+	calls := func(want int64) {
+		t.Helper()
+		if got := up.calls.Load(); got != want {
+			t.Fatalf("%d upstream calls, want %d", got, want)
+		}
+	}
+	invalidate := func(query string, wantScope string, wantRemoved int) {
+		t.Helper()
+		mark := suiteLog.mark()
+		w := serve(h, "DELETE", "/go/admin/cache"+query, "", "Authorization", "Bearer "+testAdmin)
+		var res struct {
+			Scope   string `json:"scope"`
+			Removed int    `json:"removed"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK || res.Scope != wantScope || res.Removed != wantRemoved {
+			t.Fatalf("DELETE %s: status %d: %s; want scope %s, %d removed", query, w.Code, w.Body.String(), wantScope, wantRemoved)
+		}
+		logged := suiteLog.since(mark)
+		if !strings.Contains(logged, `"msg":"admin cache invalidate"`) || !strings.Contains(logged, `"admin_id":"key_`) {
+			t.Fatalf("invalidation not logged with the admin token's id:\n%s", logged)
+		}
+	}
+
+	translate("one")
+	translate("two")
+	translate("one")
+	translate("two")
+	calls(2)
+
+	invalidate("?q=one&src=dv&dst=en", "key", 0) // pro keys are served the extended entry
+	invalidate("?q=one&src=dv&dst=en&extended=1", "key", 1)
+	translate("one")
+	translate("two")
+	calls(3) // only the invalidated phrase went back upstream
+
+	invalidate("", "all", 2)
+	translate("one")
+	translate("two")
+	calls(5)
+	invalidate("?q=never+cached&src=dv&dst=en", "key", 0)
+
+	refusals := []struct {
+		name    string
+		target  string
+		headers []string
+		status  int
+	}{
+		{"no token", "/go/admin/cache", nil, http.StatusUnauthorized},
+		{"client key as token", "/go/admin/cache", []string{"Authorization", "Bearer " + testProKey}, http.StatusUnauthorized},
+		{"src without q", "/go/admin/cache?src=dv", []string{"Authorization", "Bearer " + testAdmin}, http.StatusBadRequest},
+		{"extended without q", "/go/admin/cache?extended=1", []string{"Authorization", "Bearer " + testAdmin}, http.StatusBadRequest},
+		{"blank q", "/go/admin/cache?q=+", []string{"Authorization", "Bearer " + testAdmin}, http.StatusBadRequest},
+	}
+	for _, tt := range refusals {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "DELETE", tt.target, "", tt.headers...)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			checkEnvelope(t, w)
+		})
+	}
+	calls(5)
+}
+
+// TestAdminRoutesNeedToken checks /go/admin isn't mounted at all without ADMIN_TOKEN.
+func TestAdminRoutesNeedToken(t *testing.T) {
+	h := newTestServer(t, map[string]string{"ADMIN_TOKEN": ""}, Deps{}).Handler()
+	if w := serve(h, "DELETE", "/go/admin/cache", "", "Authorization", "Bearer "+testAdmin); w.Code != http.StatusNotFound {
+		t.Fatalf("status %d without ADMIN_TOKEN, want 404: %s", w.Code, w.Body.String())
+	}
+}
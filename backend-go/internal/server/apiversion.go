@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// API versions. Every route is registered once, at its unversioned /go/ path; apiVersions routes
+// /go/v1/... and /go/v2/... to the same handlers and wraps them in the version's response
+// adapter. v1 is the API as it has always answered. v2 changes the error envelope (the status
+// next to the code, everything else under "details") and moves where a translation came from
+// under "provenance". The bare /go/ paths are aliases of v1 that carry Deprecation and Sunset
+// headers until they are removed at LEGACY_API_SUNSET. Health, metrics, admin and the other
+// operational routes aren't versioned and stay where they are.
+const (
+	apiV1     = "v1"
+	apiV2     = "v2"
+	apiLegacy = "legacy" // the unversioned aliases, as the request log records them
+)
+
+// legacyDeprecated is when the unversioned paths were deprecated, for the Deprecation header.
+var legacyDeprecated = time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC)
+
+// unversionedRoutes are the routes, and the trees under them, that live only at /go/.
+var unversionedRoutes = []string{
+	"/go/health", "/go/ready", "/go/metrics", "/go/version", "/go/openapi.json", "/go/docs",
+	"/go/webhooks", "/go/admin", "/go/stats", "/go/debug",
+}
+
+// splitAPIVersion says which version path asks for and the route it names: "/go/v2/translate"
+// is v2's /go/translate, and "/go/translate" the legacy alias of v1's. The version is empty for
+// operational routes, at their own paths or under a prefix (where they don't exist), and for
+// anything outside /go/.
+func splitAPIVersion(path string) (version, route string) {
+	rest, ok := strings.CutPrefix(path, "/go/")
+	if !ok {
+		return "", path
+	}
+	version = apiLegacy
+	if v, r, ok := strings.Cut(rest, "/"); ok && (v == apiV1 || v == apiV2) {
+		version, route = v, "/go/"+r
+	} else {
+		route = path
+	}
+	for _, u := range unversionedRoutes {
+		if route == u || strings.HasPrefix(route, u+"/") {
+			return "", path
+		}
+	}
+	return version, route
+}
+
+// apiPath is the route path names with any version prefix taken off, for middleware that picks
+// out routes by path.
+func apiPath(path string) string {
+	_, route := splitAPIVersion(path)
+	return route
+}
+
+// apiVersions routes a versioned path to its route's handler, records the version for the
+// request log, and applies the version: deprecation headers for the legacy aliases, the v2
+// envelope for v2. It changes only chi's routing path, so r.URL.Path stays what the client sent
+// and edge signatures and idempotency fingerprints cover the version too. sunset is when the
+// legacy aliases go away; zero leaves Sunset out.
+func apiVersions(sunset time.Time) func(http.Handler) http.Handler {
+	deprecation := "@" + strconv.FormatInt(legacyDeprecated.Unix(), 10)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version, route := splitAPIVersion(r.URL.Path)
+			if version == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if m := requestMetaFrom(r.Context()); m != nil {
+				m.APIVersion = version
+			}
+			switch version {
+			case apiLegacy:
+				h := w.Header()
+				h.Set("Deprecation", deprecation)
+				if !sunset.IsZero() {
+					h.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+				}
+				h.Add("Link", "</go/"+apiV1+strings.TrimPrefix(route, "/go")+`>; rel="successor-version"`)
+			default:
+				if rctx := chi.RouteContext(r.Context()); rctx != nil {
+					rctx.RoutePath = route
+				}
+			}
+			// A WebSocket upgrade has no response body to adapt, and needs w's Hijacker.
+			if version == apiV2 && r.Header.Get("Upgrade") == "" {
+				ew := &v2Envelope{ResponseWriter: w}
+				defer ew.finish()
+				w = ew
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// v2Envelope rewrites JSON responses into the v2 shape, holding each one back until the handler
+// returns, the way plainErrors does. Streams, NDJSON and everything else that isn't JSON pass
+// through untouched and keep the v1 shape. finish must run once the handler returns.
+type v2Envelope struct {
+	http.ResponseWriter
+	code int
+	buf  *bytes.Buffer // set while a JSON response is being held back
+}
+
+func (w *v2Envelope) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	if mt, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mt == mediaJSON && code != http.StatusNotModified {
+		w.buf = &bytes.Buffer{}
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *v2Envelope) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buf != nil {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *v2Envelope) finish() {
+	if w.buf == nil {
+		return
+	}
+	dec := json.NewDecoder(w.buf)
+	dec.UseNumber()
+	var body any
+	if err := dec.Decode(&body); err != nil {
+		// Not something this adapter understands; better sent as it was than not at all.
+		w.ResponseWriter.WriteHeader(w.code)
+		_, _ = w.ResponseWriter.Write(w.buf.Bytes())
+		return
+	}
+	j(w.ResponseWriter, w.code, v2Body(w.code, body))
+}
+
+func (w *v2Envelope) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// v2Body is a v1 response body in the v2 shape: an error envelope is rebuilt, and a translation,
+// on its own or in a batch's or a job's results, gets its provenance.
+func v2Body(status int, body any) any {
+	obj, ok := body.(map[string]any)
+	if !ok {
+		return body
+	}
+	if e, ok := obj["error"].(map[string]any); ok && len(obj) == 1 {
+		return map[string]any{"error": v2Error(status, e)}
+	}
+	withProvenance(obj)
+	switch results := obj["results"].(type) {
+	case []any:
+		for _, item := range results {
+			if m, ok := item.(map[string]any); ok {
+				withProvenance(m)
+			}
+		}
+	case map[string]any:
+		for _, item := range results {
+			if m, ok := item.(map[string]any); ok {
+				withProvenance(m)
+			}
+		}
+	}
+	return obj
+}
+
+// v2Error is a v1 error's fields with the details writeError added next to the message moved
+// under "details", so clients can tell the envelope from what a particular error carries.
+func v2Error(status int, e map[string]any) map[string]any {
+	out := map[string]any{"code": e["code"], "message": e["message"], "status": status}
+	if id, ok := e["request_id"]; ok {
+		out["request_id"] = id
+	}
+	details := map[string]any{}
+	for k, v := range e {
+		switch k {
+		case "code", "message", "request_id":
+		default:
+			details[k] = v
+		}
+	}
+	if len(details) > 0 {
+		out["details"] = details
+	}
+	return out
+}
+
+// provenanceFields are the v1 translation fields that say where an answer came from, and their
+// names under v2's "provenance".
+var provenanceFields = map[string]string{
+	"src":             "source",
+	"pack":            "pack",
+	"match":           "match",
+	"glossary":        "glossary",
+	"glossary_client": "glossary_client",
+	"cached":          "cached",
+	"age":             "age",
+	"cached_at":       "cached_at",
+	"stale":           "stale",
+}
+
+// withProvenance moves a translation's provenance fields under "provenance", with v1's own
+// provenance, the ?debug=1 account of the pipeline, as its "trace". Objects that aren't
+// translations, and translations ?fields= left without any, are unchanged.
+func withProvenance(m map[string]any) {
+	if _, ok := m["translation"]; !ok {
+		return
+	}
+	prov := map[string]any{}
+	if trace, ok := m["provenance"]; ok {
+		prov["trace"] = trace
+		delete(m, "provenance")
+	}
+	for v1, v2 := range provenanceFields {
+		if v, ok := m[v1]; ok {
+			prov[v2] = v
+			delete(m, v1)
+		}
+	}
+	if len(prov) > 0 {
+		m["provenance"] = prov
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var routeParam = regexp.MustCompile(`\{[^}]*\}|\*$`)
+
+// routePath is a path route matches, with every parameter and wildcard filled in.
+func routePath(route string) string { return routeParam.ReplaceAllString(route, "x") }
+
+// TestLegacyAliases walks every route: each versioned one answers at /go/v1 as at its bare
+// path, and only the bare path carries Deprecation, Sunset and the successor Link;
+// operational routes exist only at /go/.
+func TestLegacyAliases(t *testing.T) {
+	sunset := time.Date(2027, time.April, 14, 0, 0, 0, 0, time.UTC)
+	s := fullTestServer(t)
+	h := s.Handler()
+	deprecation := "@" + strconv.FormatInt(legacyDeprecated.Unix(), 10)
+	for _, key := range goRoutes(t, s) {
+		method, route, _ := strings.Cut(key, " ")
+		if method == http.MethodGet && strings.HasSuffix(route, "/stream") || route == "/go/ws" {
+			continue // long-lived; their headers come from the same middleware
+		}
+		t.Run(key, func(t *testing.T) {
+			path := routePath(route)
+			v1 := "/go/" + apiV1 + strings.TrimPrefix(path, "/go")
+			bare := serve(h, method, path, "")
+			alias := serve(h, method, v1, "")
+			if version, _ := splitAPIVersion(route); version != apiLegacy {
+				if got := bare.Header().Get("Deprecation"); got != "" {
+					t.Errorf("operational route deprecated: %q", got)
+				}
+				if alias.Code != http.StatusNotFound {
+					t.Errorf("%s answered %d, want 404", v1, alias.Code)
+				}
+				return
+			}
+			if bare.Code != alias.Code {
+				t.Errorf("bare path %d, %s %d", bare.Code, v1, alias.Code)
+			}
+			want := map[string]string{
+				"Deprecation": deprecation,
+				"Sunset":      sunset.Format(http.TimeFormat),
+				"Link":        "<" + routePath("/go/"+apiV1+strings.TrimPrefix(route, "/go")) + `>; rel="successor-version"`,
+			}
+			for name, v := range want {
+				if got := bare.Header().Get(name); got != v {
+					t.Errorf("bare %s %q, want %q", name, got, v)
+				}
+				if got := alias.Header().Get(name); got != "" {
+					t.Errorf("%s carries %s %q", v1, name, got)
+				}
+			}
+		})
+	}
+}
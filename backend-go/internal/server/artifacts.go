@@ -0,0 +1,456 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Export artifacts. The admin exports (cache, tm, usage reports) and job results are written
+// to a file in EXPORT_SPOOL_DIR and sent from there with Range, If-Range and a strong ETag, so
+// a dropped download of a few hundred megabytes resumes (curl -C -, or a browser's retry)
+// instead of starting over. An export written before it passes EXPORT_INLINE_MAX_BYTES is the
+// response, as before, and its file is removed once sent. A larger one answers 202 with a
+// download URL as soon as it passes, and goes on being written in the background; the URL is
+// a 202 too until the file is complete.
+//
+// A finished artifact is <id>.data beside its <id>.json, which is only written once the data
+// is complete; one being written is <id>.part. Artifacts are kept for EXPORT_RETENTION and
+// then removed by a janitor. At startup the spool directory is rescanned: finished artifacts
+// are kept until they expire, and what a restart interrupted is removed.
+
+// Artifact states.
+const (
+	artifactWriting = "writing"
+	artifactReady   = "ready"
+	artifactFailed  = "failed"
+)
+
+// artifactAdmin owns the admin exports, which any admin may download.
+const artifactAdmin = "admin"
+
+// artifactRetryAfter is how long a 202 asks the client to wait before fetching the download.
+const artifactRetryAfter = 5 * time.Second
+
+// artifactSweep is how often the janitor looks for expired artifacts.
+const artifactSweep = time.Minute
+
+// artifactMeta is an artifact as its <id>.json records it.
+type artifactMeta struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`  // cache, tm, usage or job
+	Owner       string    `json:"owner"` // admin, or the key id that owns the job
+	Name        string    `json:"name"`  // the file name the download is offered as
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Rows        int       `json:"rows"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// artifact is one export, being written or done. state, err and the size fields of
+// artifactMeta are set by the writer before done is closed, under artifactStore.mu.
+type artifact struct {
+	artifactMeta
+	state   string
+	err     error
+	written atomic.Int64
+	big     chan struct{} // closed once written passes the inline threshold
+	done    chan struct{} // closed once the artifact is ready or failed
+	cancel  context.CancelFunc
+}
+
+// artifactSpec is an export for serve to write: what it is, who may fetch it, and how to write
+// its rows.
+type artifactSpec struct {
+	id          string // a job's results are kept under the job's id; a new one when empty
+	kind        string
+	owner       string
+	name        string
+	contentType string
+	url         string // where the download is fetched, for the 202; the admin download route when empty
+	write       func(ctx context.Context, w io.Writer) (rows int, err error)
+}
+
+// artifactStore is the spool directory and the artifacts in it.
+type artifactStore struct {
+	dir       string
+	inlineMax int64
+	ttl       time.Duration
+	clock     Clock
+
+	ctx     context.Context // ends the writers at shutdown
+	quit    context.CancelFunc
+	writers sync.WaitGroup
+
+	mu    sync.Mutex
+	items map[string]*artifact
+}
+
+// openArtifacts creates dir if need be and rescans it.
+func openArtifacts(dir string, inlineMax int64, ttl time.Duration, clk Clock) (*artifactStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	ctx, quit := context.WithCancel(context.Background())
+	s := &artifactStore{dir: dir, inlineMax: inlineMax, ttl: ttl, clock: clk, ctx: ctx, quit: quit, items: make(map[string]*artifact)}
+	if err := s.rescan(s.clock.Now()); err != nil {
+		quit()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *artifactStore) path(id, ext string) string { return filepath.Join(s.dir, id+ext) }
+
+// rescan registers the finished, unexpired artifacts in the spool directory and removes the
+// rest of its files: expired artifacts, and the writes a restart cut short. Files it doesn't
+// name are left alone.
+func (s *artifactStore) rescan(now time.Time) error {
+	ents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	kept, removed := 0, 0
+	for _, e := range ents {
+		id, ext, _ := strings.Cut(e.Name(), ".")
+		switch ext {
+		case "json":
+			m, err := s.readMeta(id)
+			if err != nil || !now.Before(m.ExpiresAt) {
+				s.removeFiles(id)
+				removed++
+				continue
+			}
+			a := &artifact{artifactMeta: m, state: artifactReady, big: make(chan struct{}), done: make(chan struct{}), cancel: func() {}}
+			close(a.big)
+			close(a.done)
+			s.items[id] = a
+			kept++
+		case "data":
+			if _, err := os.Stat(s.path(id, ".json")); errors.Is(err, os.ErrNotExist) {
+				os.Remove(s.path(id, ".data"))
+				removed++
+			}
+		case "part", "json.tmp":
+			os.Remove(filepath.Join(s.dir, e.Name()))
+			removed++
+		}
+	}
+	slog.Info("export artifacts rescanned", "dir", s.dir, "kept", kept, "removed", removed)
+	return nil
+}
+
+// readMeta reads id's sidecar, checking its data file is all there.
+func (s *artifactStore) readMeta(id string) (artifactMeta, error) {
+	var m artifactMeta
+	b, err := os.ReadFile(s.path(id, ".json"))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, err
+	}
+	fi, err := os.Stat(s.path(id, ".data"))
+	switch {
+	case err != nil:
+		return m, err
+	case m.ID != id || fi.Size() != m.Size:
+		return m, errors.New("artifact " + id + " doesn't match its data")
+	}
+	return m, nil
+}
+
+func (s *artifactStore) removeFiles(id string) {
+	for _, ext := range []string{".part", ".data", ".json"} {
+		os.Remove(s.path(id, ext))
+	}
+}
+
+// run removes expired artifacts until ctx is done.
+func (s *artifactStore) run(ctx context.Context) {
+	t := s.clock.NewTicker(artifactSweep)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C():
+			s.sweep(now)
+		}
+	}
+}
+
+// sweep removes the artifacts that have expired by now; one still being written waits for
+// the next sweep.
+func (s *artifactStore) sweep(now time.Time) {
+	s.mu.Lock()
+	var expired []string
+	for id, a := range s.items {
+		if a.state != artifactWriting && !now.Before(a.ExpiresAt) {
+			delete(s.items, id)
+			expired = append(expired, id)
+		}
+	}
+	s.mu.Unlock()
+	for _, id := range expired {
+		s.removeFiles(id)
+	}
+	if len(expired) > 0 {
+		slog.Info("export artifacts expired", "count", len(expired))
+	}
+}
+
+// stop ends the writes in progress and waits for them to remove their files, within ctx.
+func (s *artifactStore) stop(ctx context.Context) error {
+	s.quit()
+	done := make(chan struct{})
+	go func() {
+		s.writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func newArtifactID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "exp_" + hex.EncodeToString(b)
+}
+
+// start begins writing spec, or returns the artifact already written or being written under
+// its id; a failed one is written again.
+func (s *artifactStore) start(spec artifactSpec, requestID string) (*artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.items[spec.id]; ok && spec.id != "" && a.state != artifactFailed {
+		return a, nil
+	}
+	id := cmp.Or(spec.id, newArtifactID())
+	f, err := os.OpenFile(s.path(id, ".part"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, exportMaxDuration)
+	now := s.clock.Now()
+	a := &artifact{
+		artifactMeta: artifactMeta{ID: id, Kind: spec.kind, Owner: spec.owner, Name: spec.name, ContentType: spec.contentType, CreatedAt: now, ExpiresAt: now.Add(s.ttl)},
+		state:        artifactWriting,
+		big:          make(chan struct{}),
+		done:         make(chan struct{}),
+		cancel:       cancel,
+	}
+	s.items[id] = a
+	s.writers.Add(1)
+	go s.write(ctx, a, f, spec.write, requestID)
+	return a, nil
+}
+
+// write runs fn into f, then makes f a finished artifact, or removes it.
+func (s *artifactStore) write(ctx context.Context, a *artifact, f *os.File, fn func(context.Context, io.Writer) (int, error), requestID string) {
+	defer s.writers.Done()
+	defer a.cancel()
+	sum := sha256.New()
+	bw := bufio.NewWriterSize(io.MultiWriter(f, sum, &artifactCounter{a: a, limit: s.inlineMax}), 64<<10)
+	rows, err := fn(ctx, bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	m := a.artifactMeta
+	m.Size, m.SHA256, m.Rows = a.written.Load(), hex.EncodeToString(sum.Sum(nil)), rows
+	if err == nil {
+		err = os.Rename(s.path(a.ID, ".part"), s.path(a.ID, ".data"))
+	}
+	if err == nil {
+		err = s.writeMeta(m)
+	}
+	attrs := []any{"request_id", requestID, "export", a.Kind, "id", a.ID, "rows", rows}
+	s.mu.Lock()
+	if err != nil {
+		a.state, a.err, a.ExpiresAt = artifactFailed, err, s.clock.Now().Add(s.ttl)
+		s.mu.Unlock()
+		s.removeFiles(a.ID)
+		slog.Warn("export failed", append(attrs, "err", err)...)
+	} else {
+		// Only the size fields: the rest of artifactMeta is read without the lock.
+		a.Size, a.SHA256, a.Rows, a.state = m.Size, m.SHA256, m.Rows, artifactReady
+		s.mu.Unlock()
+		slog.Info("export written", append(attrs, "bytes", m.Size)...)
+	}
+	close(a.done)
+}
+
+// writeMeta writes m's sidecar by renaming it into place, so a rescan never reads half of one.
+func (s *artifactStore) writeMeta(m artifactMeta) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := s.path(m.ID, ".json.tmp")
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(m.ID, ".json"))
+}
+
+// artifactCounter counts the bytes written to an artifact and closes big past limit.
+type artifactCounter struct {
+	a     *artifact
+	limit int64
+	once  sync.Once
+}
+
+func (c *artifactCounter) Write(p []byte) (int, error) {
+	if c.a.written.Add(int64(len(p))) > c.limit {
+		c.once.Do(func() { close(c.a.big) })
+	}
+	return len(p), nil
+}
+
+// get returns id's artifact when owner may have it.
+func (s *artifactStore) get(id, owner string) (*artifact, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.items[id]
+	return a, ok && a.Owner == owner
+}
+
+// serve answers an export request with spec's artifact: the file itself when it is written
+// before it passes the inline threshold, or when it is a job's, already written; otherwise a
+// 202 with where to fetch it. A client that leaves while it is still being written takes the
+// export with it.
+func (s *artifactStore) serve(w http.ResponseWriter, r *http.Request, spec artifactSpec) {
+	ctx := r.Context()
+	a, err := s.start(spec, middleware.GetReqID(ctx))
+	if err != nil {
+		slog.Warn("export failed", "request_id", middleware.GetReqID(ctx), "export", spec.kind, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, spec.kind+" export failed")
+		return
+	}
+	auditParam(ctx, "export_id", a.ID)
+	select {
+	case <-a.done:
+	case <-a.big:
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.Canceled) {
+			a.cancel()
+			return
+		}
+	}
+	s.mu.Lock()
+	state, size, rows := a.state, a.Size, a.Rows
+	s.mu.Unlock()
+	switch {
+	case state == artifactFailed:
+		writeError(w, http.StatusInternalServerError, codeInternal, spec.kind+" export failed")
+	case state == artifactReady && (spec.id != "" || size <= s.inlineMax):
+		auditParam(ctx, "rows", rows)
+		s.send(w, r, a, spec.id == "")
+	default:
+		s.accepted(w, a, cmp.Or(spec.url, "/go/admin/exports/"+a.ID))
+	}
+}
+
+// send serves a's file, which must be ready, with its ETag, for Range and If-Range requests;
+// with once set it is removed as soon as it is open.
+func (s *artifactStore) send(w http.ResponseWriter, r *http.Request, a *artifact, once bool) {
+	f, err := os.Open(s.path(a.ID, ".data"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, a.Kind+" export unreadable", "detail", err.Error())
+		return
+	}
+	defer f.Close()
+	if once {
+		s.mu.Lock()
+		delete(s.items, a.ID)
+		s.mu.Unlock()
+		s.removeFiles(a.ID)
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportMaxDuration))
+	h := w.Header()
+	h.Set("Content-Type", a.ContentType)
+	h.Set("Content-Disposition", `attachment; filename="`+a.Name+`"`)
+	h.Set("Cache-Control", "private, no-cache")
+	h.Set("ETag", `"`+a.SHA256+`"`)
+	http.ServeContent(w, r, a.Name, a.CreatedAt, f)
+}
+
+// accepted is the 202 for an artifact not yet to be sent inline.
+func (s *artifactStore) accepted(w http.ResponseWriter, a *artifact, url string) {
+	w.Header().Set("Location", url)
+	w.Header().Set("Retry-After", strconv.Itoa(int(artifactRetryAfter.Seconds())))
+	j(w, http.StatusAccepted, s.describe(a, url))
+}
+
+// artifactStatus describes an artifact in its 202.
+type artifactStatus struct {
+	ExportID     string    `json:"export_id"`
+	Kind         string    `json:"kind"`
+	Status       string    `json:"status"`
+	BytesWritten int64     `json:"bytes_written"`
+	DownloadURL  string    `json:"download_url"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	SHA256       string    `json:"sha256,omitempty"` // once ready
+}
+
+func (s *artifactStore) describe(a *artifact, url string) artifactStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := artifactStatus{ExportID: a.ID, Kind: a.Kind, Status: a.state, BytesWritten: a.written.Load(), DownloadURL: url, ExpiresAt: a.ExpiresAt.UTC()}
+	if a.state == artifactReady {
+		st.BytesWritten, st.SHA256 = a.Size, a.SHA256
+	}
+	return st
+}
+
+// downloadHandler serves GET /go/admin/exports/{id}: the admin export's file once it is
+// written, resumably, and a 202 until then.
+func (s *artifactStore) downloadHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	a, ok := s.get(id, artifactAdmin)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "no export "+id+"; it may have expired")
+		return
+	}
+	s.mu.Lock()
+	state, err := a.state, a.err
+	s.mu.Unlock()
+	switch state {
+	case artifactWriting:
+		s.accepted(w, a, r.URL.Path)
+	case artifactFailed:
+		writeError(w, http.StatusInternalServerError, codeInternal, a.Kind+" export failed", "detail", err.Error())
+	default:
+		s.send(w, r, a, false)
+	}
+}
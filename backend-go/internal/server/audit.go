@@ -0,0 +1,300 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// auditEntry is one line of AUDIT_LOG_PATH.
+type auditEntry struct {
+	Time      time.Time      `json:"ts"`
+	Action    string         `json:"action"`
+	Actor     string         `json:"actor"`
+	Params    map[string]any `json:"params,omitempty"`
+	Outcome   string         `json:"outcome"` // "ok", or "error" for any 4xx/5xx answer
+	Status    int            `json:"status"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// auditLog appends admin actions to a JSON-lines file, fsynced per entry and rotated by size to
+// path.1 … path.<keep>. Without a path it only logs them.
+type auditLog struct {
+	path     string
+	maxBytes int64
+	keep     int
+	clock    Clock // what the entries are stamped from
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openAuditLog(path string, maxBytes int64, keep int, clk Clock) (*auditLog, error) {
+	a := &auditLog{path: path, maxBytes: maxBytes, keep: keep, clock: clk}
+	if path == "" {
+		return a, nil
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit log: %w", err)
+	}
+	a.f, a.size = f, st.Size()
+	return nil
+}
+
+// record appends e and syncs it to disk before returning.
+func (a *auditLog) record(e auditEntry) error {
+	slog.Info("audit", "action", e.Action, "actor", e.Actor, "outcome", e.Outcome, "status", e.Status, "request_id", e.RequestID)
+	if a.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil { // a failed rotation left no file open; try again
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+	if a.size > 0 && a.size+int64(len(line)) > a.maxBytes {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	if err == nil {
+		err = a.f.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	return nil
+}
+
+// rotate shifts path.N-1 → path.N … path → path.1 and starts a new file. Called with mu held.
+func (a *auditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		slog.Warn("audit log close failed", "err", err)
+	}
+	a.f = nil
+	for i := a.keep - 1; i >= 1; i-- {
+		if err := os.Rename(a.rotated(i), a.rotated(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("audit log rotate: %w", err)
+		}
+	}
+	if a.keep > 0 {
+		if err := os.Rename(a.path, a.rotated(1)); err != nil {
+			return fmt.Errorf("audit log rotate: %w", err)
+		}
+	} else if err := os.Truncate(a.path, 0); err != nil {
+		return fmt.Errorf("audit log rotate: %w", err)
+	}
+	return a.open()
+}
+
+func (a *auditLog) rotated(i int) string { return a.path + "." + strconv.Itoa(i) }
+
+// auditPageKey is an entry's position in GET /go/admin/audit.
+func auditPageKey(e auditEntry) pageKey {
+	return pageKey{At: e.Time.UnixNano(), ID: []string{e.RequestID, e.Action}}
+}
+
+// page returns the page req asks for of the entries match admits, newest first, and the next
+// page's cursor. Files are read newest first until one leaves enough entries for the page: older
+// files hold older entries.
+func (a *auditLog) page(pg *pager, req pageReq, match func(auditEntry) bool) ([]auditEntry, string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []auditEntry
+	for i := 0; i <= a.keep && len(out) <= req.Limit; i++ {
+		name := a.path
+		if i > 0 {
+			name = a.rotated(i)
+		}
+		data, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			var e auditEntry
+			if json.Unmarshal(sc.Bytes(), &e) != nil || !match(e) {
+				continue
+			}
+			if k := auditPageKey(e); (req.After == nil || k.compare(*req.After) < 0) && !e.Time.Before(req.Since) {
+				out = append(out, e)
+			}
+		}
+	}
+	out, next := pageOf(pg, req, out, auditPageKey)
+	return out, next, nil
+}
+
+// auditParamsKey holds the request's *auditParams, for handlers to add body fields to.
+type auditParamsKey struct{}
+
+type auditParams struct {
+	mu sync.Mutex
+	m  map[string]any
+}
+
+// auditParam adds key=value to the audit entry of the admin request in ctx.
+func auditParam(ctx context.Context, key string, value any) {
+	if p, ok := ctx.Value(auditParamsKey{}).(*auditParams); ok {
+		p.mu.Lock()
+		p.m[key] = value
+		p.mu.Unlock()
+	}
+}
+
+// audited records every request to the route as action. The entry is written when the handler
+// commits its status, before any of the body, and carries the query parameters (text to
+// translate only as LOG_CONTENT allows) plus whatever the handler added with auditParam. If it
+// can't be written the handler's response is replaced by a 500, so an action never goes
+// unrecorded quietly.
+func (a *auditLog) audited(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params := &auditParams{m: map[string]any{}}
+			for k, vs := range r.URL.Query() {
+				if slices.Contains(contentParams, k) {
+					params.m[k] = describeContent(strings.Join(vs, ","))
+				} else if len(vs) == 1 {
+					params.m[k] = vs[0]
+				} else {
+					params.m[k] = vs
+				}
+			}
+			aw := &auditWriter{ResponseWriter: w, commit: func(status int) error {
+				params.mu.Lock()
+				defer params.mu.Unlock()
+				e := auditEntry{
+					Time:      a.clock.Now().UTC(),
+					Action:    action,
+					Actor:     adminFrom(r.Context()),
+					Outcome:   "ok",
+					Status:    status,
+					RequestID: middleware.GetReqID(r.Context()),
+				}
+				if len(params.m) > 0 {
+					e.Params = params.m
+				}
+				if status >= 400 {
+					e.Outcome = "error"
+				}
+				return a.record(e)
+			}}
+			next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), auditParamsKey{}, params)))
+			if aw.status == 0 {
+				aw.WriteHeader(http.StatusOK)
+			}
+		})
+	}
+}
+
+// auditWriter runs commit at the first WriteHeader. When commit fails, the handler's response is
+// dropped and a 500 goes out in its place.
+type auditWriter struct {
+	http.ResponseWriter
+	commit func(status int) error
+	status int
+	failed bool
+}
+
+func (w *auditWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if err := w.commit(code); err != nil {
+		slog.Error("audit write failed", "err", err)
+		w.failed = true
+		h := w.Header()
+		for _, k := range []string{"Content-Length", "Content-Disposition", "Content-Type"} {
+			h.Del(k)
+		}
+		writeError(w.ResponseWriter, http.StatusInternalServerError, codeInternal, "audit log write failed; the action may have been applied")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.failed {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.failed {
+		_ = http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+func (w *auditWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// auditHandler serves GET /go/admin/audit, newest first, paged (see paging.go) and filtered by
+// action, actor and outcome.
+func auditHandler(a *auditLog, pg *pager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.path == "" {
+			writeError(w, http.StatusNotImplemented, codeNotImplemented, "audit entries are only logged; set AUDIT_LOG_PATH to keep them")
+			return
+		}
+		req, herr := pg.readPage(r, "audit", "action", "actor", "outcome")
+		if herr != nil {
+			herr.write(w)
+			return
+		}
+		q := r.URL.Query()
+		entries, next, err := a.page(pg, req, func(e auditEntry) bool {
+			return matchParam(q.Get("action"), e.Action) && matchParam(q.Get("actor"), e.Actor) && matchParam(q.Get("outcome"), e.Outcome)
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "audit log read failed", "detail", err.Error())
+			return
+		}
+		j(w, http.StatusOK, pageBody(map[string]any{"entries": entries, "count": len(entries)}, next))
+	}
+}
@@ -0,0 +1,414 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// identity is the authenticated caller attached to the request context.
+type identity struct {
+	KeyID  string
+	Tier   string
+	Scopes *keyScopes // nil when unrestricted; see scopes.go
+}
+
+// API key tiers. Pro keys get the extended upstream and packs, larger batches and a higher rate
+// limit; unauthenticated callers (no API_KEYS configured) count as free.
+const (
+	tierFree = "free"
+	tierPro  = "pro"
+)
+
+// tierFrom returns the caller's tier, tierFree when there is no identity.
+func tierFrom(ctx context.Context) string {
+	if id, ok := identityFrom(ctx); ok && id.Tier != "" {
+		return id.Tier
+	}
+	return tierFree
+}
+
+type ctxKey int
+
+const (
+	identityCtxKey ctxKey = iota
+	requestMetaCtxKey
+	adminCtxKey
+	timingCtxKey
+	upstreamCallCtxKey
+	keyLeaseCtxKey
+)
+
+// withIdentity attaches id to ctx and records the key id for the request log line.
+func withIdentity(ctx context.Context, id identity) context.Context {
+	if m := requestMetaFrom(ctx); m != nil {
+		m.KeyID, m.Tier = id.KeyID, id.Tier
+	}
+	noteInflightClient(ctx, id.KeyID)
+	return context.WithValue(ctx, identityCtxKey, id)
+}
+
+// identityFrom returns the caller identity set by the auth middleware, if any.
+func identityFrom(ctx context.Context) (identity, bool) {
+	id, ok := ctx.Value(identityCtxKey).(identity)
+	return id, ok
+}
+
+// apiKey is a configured key, held as a SHA-256 digest so comparisons are fixed-length.
+type apiKey struct {
+	id       string
+	tier     string
+	client   string
+	disabled bool
+	revoked  bool
+	created  time.Time // zero when unknown
+	expires  time.Time // zero for keys that don't expire
+	scopes   *keyScopes
+	fromFile bool
+	digest   [sha256.Size]byte
+}
+
+// refusal is why k can't be used at now: "disabled", "revoked", "expired", or "" when it can.
+func (k apiKey) refusal(now time.Time) string {
+	switch {
+	case k.revoked:
+		return "revoked"
+	case !k.expires.IsZero() && !now.Before(k.expires):
+		return "expired"
+	case k.disabled:
+		return "disabled"
+	}
+	return ""
+}
+
+// keyFileEntry is one object in the API key file. The file may also hold plain key strings,
+// the original format, which load as free keys. Keys are written back only as their hash, so
+// after the first rewrite the file holds no key material.
+type keyFileEntry struct {
+	Key            string     `json:"key,omitempty"`
+	KeyHash        string     `json:"key_hash,omitempty"` // hex SHA-256 of the key
+	ID             string     `json:"id"`
+	Client         string     `json:"client,omitempty"`
+	Tier           string     `json:"tier"`
+	Disabled       bool       `json:"disabled"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Revoked        bool       `json:"revoked,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	StripeCustomer string     `json:"stripe_customer,omitempty"` // set by the Stripe webhook
+	WebhookSecret  string     `json:"webhook_secret,omitempty"`  // signs job callbacks to this client
+	Scopes         *keyScopes `json:"scopes,omitempty"`
+}
+
+// digest returns the SHA-256 of the entry's key, from key_hash when the plaintext is gone.
+func (e keyFileEntry) digest() [sha256.Size]byte {
+	if e.Key == "" {
+		var d [sha256.Size]byte
+		hex.Decode(d[:], []byte(e.KeyHash))
+		return d
+	}
+	return sha256.Sum256([]byte(e.Key))
+}
+
+// apiKey returns the in-memory form of e.
+func (e keyFileEntry) apiKey(fromFile bool) apiKey {
+	k := apiKey{id: e.ID, tier: e.Tier, client: e.Client, disabled: e.Disabled, revoked: e.Revoked, scopes: e.Scopes, fromFile: fromFile, digest: e.digest()}
+	if e.CreatedAt != nil {
+		k.created = *e.CreatedAt
+	}
+	if e.ExpiresAt != nil {
+		k.expires = *e.ExpiresAt
+	}
+	return k
+}
+
+// keyStore validates x-api-key values against the configured set. Keys in the key file can be
+// minted, revoked and re-tiered at runtime (see mint, revoke, setTier); the file is rewritten so
+// changes survive restarts.
+type keyStore struct {
+	mu    sync.RWMutex
+	keys  []apiKey
+	file  string         // API_KEYS_FILE, empty when keys come only from API_KEYS
+	rows  []keyFileEntry // the file's contents, rewritten on change
+	clock Clock          // what expiry and the created and revoked stamps are read from
+}
+
+// loadKeyStore reads keys from API_KEYS (comma-separated, each "key" or "id:key", all free tier)
+// and/or a JSON file: a list of keyFileEntry objects or plain key strings. Keys without an
+// explicit id get one derived from their hash.
+func loadKeyStore(list, file string, clk Clock) (*keyStore, error) {
+	var raw []keyFileEntry
+	nFile := 0
+	for _, k := range strings.Split(list, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		id, key, ok := strings.Cut(k, ":")
+		if !ok {
+			id, key = "", k
+		}
+		raw = append(raw, keyFileEntry{Key: key, ID: id})
+	}
+	if file != "" {
+		fromFile, err := readKeyFile(file)
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, fromFile...)
+		nFile = len(fromFile)
+	}
+
+	ks := &keyStore{file: file, clock: clk}
+	for i, k := range raw {
+		d := k.digest()
+		if k.ID == "" {
+			k.ID = "key_" + hex.EncodeToString(d[:4])
+		}
+		fromFile := i >= len(raw)-nFile
+		ks.keys = append(ks.keys, k.apiKey(fromFile))
+		if fromFile {
+			k.Key, k.KeyHash = "", hex.EncodeToString(d[:])
+			ks.rows = append(ks.rows, k)
+		}
+	}
+	return ks, nil
+}
+
+func readKeyFile(file string) ([]keyFileEntry, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("api key file: %w", err)
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("api key file %s: %w", file, err)
+	}
+	out := make([]keyFileEntry, 0, len(items))
+	for i, it := range items {
+		var e keyFileEntry
+		var s string
+		if err := json.Unmarshal(it, &s); err == nil {
+			if id, key, ok := strings.Cut(s, ":"); ok {
+				e.ID, e.Key = id, key
+			} else {
+				e.Key = s
+			}
+		} else if err := json.Unmarshal(it, &e); err != nil {
+			return nil, fmt.Errorf("api key file %s entry %d: %w", file, i, err)
+		}
+		switch e.Tier {
+		case "":
+			e.Tier = tierFree
+		case tierFree, tierPro:
+		default:
+			return nil, fmt.Errorf("api key file %s entry %d: unknown tier %q", file, i, e.Tier)
+		}
+		scopes, err := e.Scopes.normalized()
+		if err != nil {
+			return nil, fmt.Errorf("api key file %s entry %d: %w", file, i, err)
+		}
+		e.Scopes = scopes
+		if e.Key == "" && e.KeyHash == "" {
+			return nil, fmt.Errorf("api key file %s entry %d: missing key", file, i)
+		}
+		if e.Key == "" {
+			if b, err := hex.DecodeString(e.KeyHash); err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("api key file %s entry %d: key_hash must be a hex SHA-256", file, i)
+			}
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (ks *keyStore) Len() int {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return len(ks.keys)
+}
+
+// errKeyNotFound is returned for an id that isn't in the key file.
+var errKeyNotFound = errors.New("no such api key in the key file")
+
+// setTier changes the tier of the file-backed key id, recording its Stripe customer when one is
+// given, and rewrites the key file. Keys from API_KEYS can't be changed.
+func (ks *keyStore) setTier(id, tier, customer string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	row := -1
+	for i := range ks.rows {
+		if ks.rows[i].ID == id {
+			row = i
+		}
+	}
+	if row < 0 {
+		return errKeyNotFound
+	}
+	rows := slices.Clone(ks.rows)
+	rows[row].Tier = tier
+	if customer != "" {
+		rows[row].StripeCustomer = customer
+	}
+	if err := writeKeyFile(ks.file, rows); err != nil {
+		return err
+	}
+	ks.rows = rows
+	for i := range ks.keys {
+		if ks.keys[i].id == id {
+			ks.keys[i].tier = tier
+		}
+	}
+	return nil
+}
+
+// webhookSecret returns the webhook_secret the key file holds for key id.
+func (ks *keyStore) webhookSecret(id string) (string, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, r := range ks.rows {
+		if r.ID == id && r.WebhookSecret != "" {
+			return r.WebhookSecret, true
+		}
+	}
+	return "", false
+}
+
+// keyForCustomer returns the id of the key recorded for a Stripe customer.
+func (ks *keyStore) keyForCustomer(customer string) (string, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, r := range ks.rows {
+		if customer != "" && r.StripeCustomer == customer {
+			return r.ID, true
+		}
+	}
+	return "", false
+}
+
+// writeKeyFile replaces file with rows in the object format, via rename so readers never see a
+// partial file. Legacy string entries are written back as objects, with key_hash for the key.
+func writeKeyFile(file string, rows []keyFileEntry) error {
+	b, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return fmt.Errorf("api key file: %w", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return fmt.Errorf("api key file: %w", err)
+	}
+	return nil
+}
+
+// lookup returns the configured entry for key. Every configured key is compared in constant time,
+// with no early exit. Callers must check refusal themselves, so expiry and revocation apply
+// from the next request on.
+func (ks *keyStore) lookup(key string) (apiKey, bool) {
+	d := sha256.Sum256([]byte(key))
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	var found apiKey
+	for _, k := range ks.keys {
+		if subtle.ConstantTimeCompare(d[:], k.digest[:]) == 1 {
+			found = k
+		}
+	}
+	return found, found.id != ""
+}
+
+// identity returns the caller identity for k.
+func (k apiKey) identity() identity {
+	tier := k.tier
+	if tier == "" {
+		tier = tierFree
+	}
+	return identity{KeyID: k.id, Tier: tier, Scopes: k.scopes}
+}
+
+// requireAPIKey rejects requests without a valid x-api-key and attaches the key id to the context.
+// Browsers can't set headers on a WebSocket handshake, so upgrades may pass ?api_key= instead.
+// While the store holds no keys at all, requests pass unauthenticated.
+//
+// With JWT_JWKS_URL or JWT_PUBLIC_KEY, an Authorization: Bearer token is accepted too (or
+// ?access_token= on an upgrade), and wins when a request carries both; a bad token is refused
+// even alongside a good key, so a client's broken token setup doesn't go unnoticed. A caller
+// already identified by its client certificate needs neither. A key with scopes is refused
+// routes and times they don't cover.
+func requireAPIKey(ks *keyStore, jv *jwtVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := identityFrom(r.Context()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			upgrade := strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+			if jv != nil {
+				tok := bearerToken(r)
+				if tok == "" && upgrade {
+					tok = r.URL.Query().Get("access_token")
+				}
+				if tok != "" {
+					id, err := jv.identity(r.Context(), tok)
+					switch {
+					case errors.Is(err, errJWTKeysUnavailable):
+						writeError(w, http.StatusServiceUnavailable, codeInternal, err.Error())
+						return
+					case err != nil:
+						writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+						return
+					}
+					next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), id)))
+					return
+				}
+			} else if ks.Len() == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.Header.Get("x-api-key")
+			if key == "" && upgrade {
+				key = r.URL.Query().Get("api_key")
+			}
+			if key == "" {
+				msg := "missing api key"
+				if jv != nil {
+					msg = "missing api key or bearer token"
+				}
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, msg)
+				return
+			}
+			k, ok := ks.lookup(key)
+			if !ok {
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "invalid api key")
+				return
+			}
+			switch k.refusal(ks.clock.Now()) {
+			case "disabled":
+				writeError(w, http.StatusForbidden, codeForbidden, "api key disabled")
+				return
+			case "revoked":
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "api key revoked")
+				return
+			case "expired":
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "api key expired")
+				return
+			}
+			if err := k.scopes.permits(routeScope(r.URL.Path), ks.clock.Now()); err != nil {
+				err.write(w)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), k.identity())))
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestRequireAPIKey checks the translate route wants a key from API_KEYS or the key file, refuses
+// a missing or unknown one with a 401 envelope, and logs the accepted key's id, while health and
+// version stay open.
+func TestRequireAPIKey(t *testing.T) {
+	h := newTestServer(t, map[string]string{"API_KEYS": "ops:k-ops, k-anon"}, Deps{}).Handler()
+	tests := []struct {
+		name   string
+		target string
+		key    string
+		status int
+		msg    string // the error message, for a refusal
+		keyID  string // the id logged, for a translation
+	}{
+		{"no key", "/go/translate?q=salaam", "", http.StatusUnauthorized, "missing api key", ""},
+		{"unknown key", "/go/translate?q=salaam", "k-nope", http.StatusUnauthorized, "invalid api key", ""},
+		{"key differing in case", "/go/translate?q=salaam", "K-OPS", http.StatusUnauthorized, "invalid api key", ""},
+		{"env key with id", "/go/translate?q=salaam", "k-ops", http.StatusOK, "", "ops"},
+		{"env key without id", "/go/translate?q=salaam", "k-anon", http.StatusOK, "", "key_"},
+		{"file key", "/go/translate?q=salaam", testProKey, http.StatusOK, "", "acme"},
+		{"health open", "/go/health", "", http.StatusOK, "", ""},
+		{"version open", "/go/version", "", http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.key != "" {
+				headers = []string{"X-API-Key", tt.key}
+			}
+			mark := suiteLog.mark()
+			w := serve(h, "GET", tt.target, "", headers...)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.msg != "" {
+				checkEnvelope(t, w)
+				var res struct {
+					Error struct {
+						Code    errorCode `json:"code"`
+						Message string    `json:"message"`
+					} `json:"error"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &res)
+				if res.Error.Code != codeUnauthorized || res.Error.Message != tt.msg {
+					t.Fatalf("error %+v, want %s %q", res.Error, codeUnauthorized, tt.msg)
+				}
+			}
+			if tt.keyID != "" && !strings.Contains(suiteLog.since(mark), `"key_id":"`+tt.keyID) {
+				t.Fatalf("no request logged with key_id %q", tt.keyID)
+			}
+		})
+	}
+}
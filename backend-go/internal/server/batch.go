@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchReq is the POST /go/translate/batch body. Batch-level src/dst apply to items that omit them.
+type batchReq struct {
+	Src       string      `json:"src"`
+	Dst       string      `json:"dst"`
+	Extended  bool        `json:"extended"`
+	AllowBidi bool        `json:"allow_bidi"` // for every item; see translateReq
+	Items     []batchItem `json:"items"`
+}
+
+type batchItem struct {
+	ID  string `json:"id"`
+	Q   string `json:"q"`
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// batchOpts bounds a batch: item count per tier and worker pool size. Body size is capped by
+// limitBody on the route.
+type batchOpts struct {
+	MaxItems    int
+	MaxItemsPro int
+	Workers     int
+}
+
+// batchResult is one item's outcome: a translation, or an error message.
+type batchResult struct {
+	Translation    string   `json:"translation,omitempty"`
+	Src            string   `json:"src,omitempty"`
+	DetectedScript string   `json:"detected_script,omitempty"`
+	SrcLang        string   `json:"src_lang,omitempty"`
+	DstLang        string   `json:"dst_lang,omitempty"`
+	Pack           string   `json:"pack,omitempty"`
+	Glossary       []string `json:"glossary,omitempty"`
+	GlossaryClient []string `json:"glossary_client,omitempty"` // the terms in glossary from the caller's own glossary
+	DetectedSrc    string   `json:"detected_src,omitempty"`
+	DetectionConf  float64  `json:"detection_confidence,omitempty"`
+	Cached         bool     `json:"cached,omitempty"`
+	Stale          bool     `json:"stale,omitempty"`
+	Match          string   `json:"match,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// batchResultFields is what ?fields= can pick from each batch item.
+var batchResultFields = jsonFieldNames(reflect.TypeFor[batchResult]())
+
+// batchHandler translates every item concurrently on a bounded pool. Individual failures are
+// reported per item and the batch still returns 200; the request context bounds the whole batch.
+func batchHandler(svc *translateService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req batchReq
+		if herr := decodeJSONBody(r, &req); herr != nil {
+			herr.write(w)
+			return
+		}
+		sel, err := parseFields(r.URL.Query(), batchResultFields)
+		if err != nil {
+			writeTranslateError(r.Context(), w, err)
+			return
+		}
+		results, err := svc.Batch(r.Context(), req)
+		if err != nil {
+			writeTranslateError(r.Context(), w, err)
+			return
+		}
+		if clientGone(r) {
+			return // nobody to encode a large batch for
+		}
+		var items any = results
+		if sel != nil {
+			picked := make(map[string]any, len(results))
+			for id, res := range results {
+				picked[id] = sel.project(res, "error") // an item's error is kept whatever was asked for
+			}
+			items = picked
+		}
+		j(w, http.StatusOK, map[string]any{
+			"results": items,
+			"count":   len(results),
+			"ts":      svc.clock.Now().UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+func validateBatch(req batchReq, maxItems int) *httpError {
+	if len(req.Items) == 0 {
+		return &httpError{http.StatusBadRequest, codeBadRequest, "missing field 'items'"}
+	}
+	if len(req.Items) > maxItems {
+		return &httpError{http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("too many items (max %d)", maxItems)}
+	}
+	seen := make(map[string]struct{}, len(req.Items))
+	for _, it := range req.Items {
+		if it.ID == "" {
+			return &httpError{http.StatusBadRequest, codeBadRequest, "every item needs an 'id'"}
+		}
+		if _, dup := seen[it.ID]; dup {
+			return &httpError{http.StatusBadRequest, codeBadRequest, fmt.Sprintf("duplicate item id %q", it.ID)}
+		}
+		seen[it.ID] = struct{}{}
+	}
+	return nil
+}
+
+// translateBatch fans items out to at most workers goroutines, fewer if the caller's key hasn't
+// the slots free, and returns results keyed by item id. Items not started before ctx is done are
+// reported with the context error.
+func translateBatch(ctx context.Context, t Translator, req batchReq, workers int) map[string]batchResult {
+	if workers < 1 {
+		workers = 1
+	}
+	workers = keyLeaseFrom(ctx).widen(min(workers, len(req.Items)))
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]batchResult, len(req.Items))
+		jobs    = make(chan batchItem)
+	)
+	set := func(id string, v batchResult) {
+		mu.Lock()
+		results[id] = v
+		mu.Unlock()
+	}
+
+	for range min(workers, len(req.Items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range jobs {
+				set(it.ID, translateItem(ctx, t, req, it))
+			}
+		}()
+	}
+
+	for i, it := range req.Items {
+		select {
+		case jobs <- it:
+		case <-ctx.Done():
+			for _, rest := range req.Items[i:] {
+				set(rest.ID, batchResult{Error: ctxErrMsg(ctx.Err())})
+			}
+			close(jobs)
+			wg.Wait()
+			return results
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func translateItem(ctx context.Context, t Translator, req batchReq, it batchItem) batchResult {
+	if strings.TrimSpace(it.Q) == "" {
+		return batchResult{Error: "missing field 'q'"}
+	}
+	if err := ctx.Err(); err != nil {
+		return batchResult{Error: ctxErrMsg(err)}
+	}
+	tr := translateReq{Q: it.Q, Src: it.Src, Dst: it.Dst, Extended: req.Extended, AllowBidi: req.AllowBidi}
+	if tr.Src == "" {
+		tr.Src = req.Src
+	}
+	if tr.Dst == "" {
+		tr.Dst = req.Dst
+	}
+	res, err := t.Translate(ctx, tr)
+	if err != nil {
+		return batchResult{Error: err.Error()}
+	}
+	return batchResult{
+		Translation: res.Translation, Src: res.Src, DetectedScript: res.Script, SrcLang: res.Pair.Src, DstLang: res.Pair.Dst, Cached: res.Cached, Stale: res.Stale, Match: res.Match, Pack: res.Pack, Glossary: res.Glossary, GlossaryClient: res.GlossaryClient,
+		DetectedSrc: res.DetectedSrc, DetectionConf: res.DetectionConfidence,
+	}
+}
+
+func ctxErrMsg(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "canceled"
+}
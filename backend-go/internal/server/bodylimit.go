@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type origBodyKey struct{}
+
+// bodyLimit is what limitBody keeps in the context: the body as the client sent it, and the
+// limit in force on it.
+type bodyLimit struct {
+	orig io.ReadCloser
+	n    int64
+}
+
+// limitBody caps the request body at n bytes. It is mounted once at the root with the default
+// limit and can be mounted again on a route to override it: the original body is kept in the
+// context so the override re-wraps it rather than nesting inside the smaller limit.
+// On a route with an override, a declared Content-Length over the limit is rejected before the
+// handler reads anything. The root runs before the route is known, so it doesn't: it can't tell
+// whether an override will allow more, and its limit is enforced as the body is read.
+func limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bl, ok := r.Context().Value(origBodyKey{}).(*bodyLimit)
+			if !ok {
+				bl = &bodyLimit{orig: r.Body}
+				r = r.WithContext(context.WithValue(r.Context(), origBodyKey{}, bl))
+			}
+			if ok && r.ContentLength > n {
+				writeTooLarge(w, n)
+				return
+			}
+			bl.n = n
+			if bl.orig != nil && bl.orig != http.NoBody {
+				r.Body = http.MaxBytesReader(w, bl.orig, n)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bufferBody reads r's body whole for a middleware that must see it before the route's own
+// limitBody runs, up to max rather than the limit in force. The copy takes the original's
+// place, so the limit in force and any route override apply to it as they would have to the
+// body. A read error, past max included, is left at the copy's end for the handler to report.
+func bufferBody(w http.ResponseWriter, r *http.Request, max int64) ([]byte, error) {
+	bl, ok := r.Context().Value(origBodyKey{}).(*bodyLimit)
+	if !ok || bl.orig == nil || bl.orig == http.NoBody {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+		} else {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		return body, err
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, bl.orig, max))
+	if err != nil {
+		bl.orig = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+	} else {
+		bl.orig = io.NopCloser(bytes.NewReader(body))
+	}
+	r.Body = http.MaxBytesReader(w, bl.orig, bl.n)
+	return body, err
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+// writeTooLarge is the structured 413 for bodies over the limit.
+func writeTooLarge(w http.ResponseWriter, limit int64) {
+	w.Header().Set("Connection", "close") // the rest of the body is not going to be read
+	writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("request body too large (limit %d bytes)", limit), "limit_bytes", limit)
+}
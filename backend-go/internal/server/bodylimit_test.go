@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingBody is a body of n bytes of JSON string filler, counting what is read of it.
+type countingBody struct {
+	r    io.Reader
+	read atomic.Int64
+}
+
+func newCountingBody(n int) *countingBody {
+	return &countingBody{r: io.MultiReader(strings.NewReader(`{"q":"`), strings.NewReader(strings.Repeat("x", n)), strings.NewReader(`"}`))}
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read.Add(int64(n))
+	return n, err
+}
+
+func (b *countingBody) Close() error { return nil }
+
+func TestBodyLimitOnRoutes(t *testing.T) {
+	h := newTestServer(t, nil, Deps{}).Handler()
+	tests := []struct {
+		name     string
+		target   string
+		size     int
+		declared bool // send Content-Length
+		status   int
+		maxRead  int64 // of the body, at most
+	}{
+		{"under the default", "/go/translate", 1 << 10, false, http.StatusOK, 2 << 10},
+		{"over the default", "/go/translate", 10 << 20, false, http.StatusRequestEntityTooLarge, 64<<10 + 32<<10},
+		{"batch override allows more", "/go/translate/batch", 96 << 10, false, http.StatusBadRequest, 97 << 10}, // read whole; not a batch
+		{"declared over the batch override", "/go/translate/batch", 10 << 20, true, http.StatusRequestEntityTooLarge, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := newCountingBody(tt.size)
+			r := httptest.NewRequest("POST", tt.target, body)
+			r.ContentLength = -1
+			if tt.declared {
+				r.ContentLength = int64(tt.size + 8)
+			}
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-API-Key", testProKey)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if n := body.read.Load(); n > tt.maxRead {
+				t.Fatalf("read %d bytes of the body, want at most %d", n, tt.maxRead)
+			}
+			if tt.status == http.StatusRequestEntityTooLarge && !strings.Contains(w.Body.String(), `"code":"`+string(codePayloadTooLarge)+`"`) {
+				t.Fatalf("413 body %s", w.Body.String())
+			}
+		})
+	}
+}
+
+// TestLimitBodyOverride nests a route's limit in the root's: the route's applies, whether it is
+// larger or smaller.
+func TestLimitBodyOverride(t *testing.T) {
+	read := func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				writeTooLarge(w, mbe.Limit)
+				return
+			}
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name        string
+		root, route int64
+		size        int
+		status      int
+	}{
+		{"larger route limit", 10, 100, 50, http.StatusOK},
+		{"smaller route limit", 100, 10, 50, http.StatusRequestEntityTooLarge},
+		{"root limit alone", 10, 0, 50, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h http.Handler = http.HandlerFunc(read)
+			if tt.route > 0 {
+				h = limitBody(tt.route)(h)
+			}
+			h = limitBody(tt.root)(h)
+			w := serve(h, "POST", "/", strings.Repeat("x", tt.size))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// breakerOpenError is returned without calling the upstream while the breaker is open.
+type breakerOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *breakerOpenError) Error() string { return "upstream: circuit open" }
+
+// breaker fails fast once the upstream has failed threshold times in a row. After cooldown a single
+// half-open probe is let through: success closes the breaker, failure re-opens it for another
+// cooldown. It sits under the cache, so cache hits are served regardless of its state. With the
+// upstream poller, a successful health poll after the breaker opened ends the cooldown early.
+type breaker struct {
+	next      Translator
+	threshold int
+	cooldown  time.Duration
+	okSince   func() time.Time // last successful upstream health poll; nil without the poller
+	name      string           // the upstream it guards, for logs and the state gauge
+	clock     Clock
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool // a half-open probe is in flight
+}
+
+func newBreaker(name string, next Translator, threshold int, cooldown time.Duration, clk Clock) *breaker {
+	metricBreakerState.WithLabelValues(name).Set(float64(breakerClosed))
+	return &breaker{next: next, threshold: threshold, cooldown: cooldown, name: name, clock: clk}
+}
+
+func (b *breaker) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	if err := b.allow(); err != nil {
+		return translateResult{}, err
+	}
+	res, err := b.next.Translate(ctx, req)
+	b.record(ctx, err)
+	return res, err
+}
+
+// allow admits the call or returns a breakerOpenError saying when to come back.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		wait := b.openedAt.Add(b.cooldown).Sub(b.clock.Now())
+		if wait > 0 && (b.okSince == nil || !b.okSince().After(b.openedAt)) {
+			return &breakerOpenError{RetryAfter: wait}
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+	case breakerHalfOpen:
+		if b.probing {
+			return &breakerOpenError{RetryAfter: time.Second}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// available reports whether a call would be let through now, without claiming the half-open probe.
+func (b *breaker) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		return b.openedAt.Add(b.cooldown).Sub(b.clock.Now()) <= 0 || (b.okSince != nil && b.okSince().After(b.openedAt))
+	case breakerHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// stressed reports whether the breaker is anything but closed, or closed with failures already
+// counted against it.
+func (b *breaker) stressed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state != breakerClosed || b.failures > 0
+}
+
+func (b *breaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// record feeds a call outcome back. Upstream 4xx prove the backend is up and count as success;
+// calls abandoned by their own caller say nothing about the upstream and are ignored.
+func (b *breaker) record(ctx context.Context, err error) {
+	var ue *upstreamError
+	failed := err != nil && !(errors.As(err, &ue) && ue.clientError())
+	neutral := failed && ctx.Err() != nil
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+	switch {
+	case neutral:
+	case !failed:
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+	case b.state == breakerHalfOpen:
+		b.openedAt = b.clock.Now()
+		b.setState(breakerOpen)
+	default:
+		b.failures++
+		if b.state == breakerClosed && b.failures >= b.threshold {
+			b.openedAt = b.clock.Now()
+			b.setState(breakerOpen)
+		}
+	}
+}
+
+// setState must be called with mu held.
+func (b *breaker) setState(s breakerState) {
+	slog.Warn("upstream circuit breaker "+s.String(), "upstream", b.name, "from", b.state.String(), "failures", b.failures)
+	b.state = s
+	metricBreakerState.WithLabelValues(b.name).Set(float64(s))
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type failingUpstream struct{}
+
+func (failingUpstream) Translate(context.Context, translateReq) (translateResult, error) {
+	return translateResult{}, errors.New("down")
+}
+
+func TestBreakerStateGaugePerUpstream(t *testing.T) {
+	a := newBreaker("gauge-a.test", failingUpstream{}, 2, time.Minute, systemClock{})
+	b := newBreaker("gauge-b.test", failingUpstream{}, 2, time.Minute, systemClock{})
+	for range 2 {
+		a.Translate(context.Background(), translateReq{Q: "x"})
+	}
+	b.Translate(context.Background(), translateReq{Q: "x"})
+	tests := []struct {
+		upstream string
+		want     breakerState
+	}{
+		{"gauge-a.test", breakerOpen},
+		{"gauge-b.test", breakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.upstream, func(t *testing.T) {
+			if got := testutil.ToFloat64(metricBreakerState.WithLabelValues(tt.upstream)); got != float64(tt.want) {
+				t.Fatalf("gauge %v, want %v (%s)", got, float64(tt.want), tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerGaugeDropsRemovedUpstreams(t *testing.T) {
+	members := func(hosts ...string) []*upstreamMember {
+		cfg := Config{BreakerFailures: 1, BreakerCooldown: time.Minute, UpstreamTimeout: time.Second}
+		for _, h := range hosts {
+			cfg.UpstreamURLs = append(cfg.UpstreamURLs, &url.URL{Scheme: "http", Host: h})
+			cfg.UpstreamWeights = append(cfg.UpstreamWeights, 0)
+		}
+		return newUpstreamMembers(cfg, nil, nil, nil, nil, nil, systemClock{})
+	}
+	f := newFailoverTranslator(members("reload-a.test", "reload-b.test"), nil, systemClock{})
+	before := testutil.CollectAndCount(metricBreakerState)
+	f.replace(members("reload-a.test"))
+	if got := testutil.CollectAndCount(metricBreakerState); got != before-1 {
+		t.Fatalf("%d breaker series after dropping an upstream, want %d", got, before-1)
+	}
+	// The kept upstream's series is its new breaker's.
+	if got := testutil.ToFloat64(metricBreakerState.WithLabelValues("reload-a.test")); got != float64(breakerClosed) {
+		t.Fatalf("kept upstream gauge %v, want closed", got)
+	}
+}
+
+func TestBreakerCooldownOnTheClock(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	up := &echoUpstream{err: errors.New("down")}
+	b := newBreaker("cooldown.test", up, 1, 30*time.Second, clk)
+	b.Translate(context.Background(), translateReq{Q: "x"})
+	steps := []struct {
+		name    string
+		advance time.Duration
+		retry   time.Duration // 0 is let through
+	}{
+		{"just opened", 0, 30 * time.Second},
+		{"cooling down", 20 * time.Second, 10 * time.Second},
+		{"cooled down", 10 * time.Second, 0},
+	}
+	for _, st := range steps {
+		clk.Advance(st.advance)
+		var open *breakerOpenError
+		err := b.allow()
+		switch {
+		case st.retry == 0 && err != nil:
+			t.Fatalf("%s: %v, want the probe let through", st.name, err)
+		case st.retry > 0 && (!errors.As(err, &open) || open.RetryAfter != st.retry):
+			t.Fatalf("%s: %v, want open with retry after %v", st.name, err, st.retry)
+		}
+	}
+	if got := b.current(); got != breakerHalfOpen {
+		t.Fatalf("state %s after the cooldown, want half-open", got)
+	}
+	up.err = nil
+	b.record(context.Background(), nil)
+	if got := b.current(); got != breakerClosed {
+		t.Fatalf("state %s after a good probe, want closed", got)
+	}
+}
+
+// TestBreakerStates drives a breaker through closed, open, half-open and back, with the
+// upstream failing or answering as each step says.
+func TestBreakerStates(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	up := &echoUpstream{}
+	b := newBreaker("states.test", up, 2, 30*time.Second, clk)
+	down := errors.New("down")
+	steps := []struct {
+		name    string
+		advance time.Duration
+		err     error // what the upstream answers
+		called  bool  // whether the call reached it
+		state   breakerState
+	}{
+		{"healthy", 0, nil, true, breakerClosed},
+		{"one failure", 0, down, true, breakerClosed},
+		{"a success resets the count", 0, nil, true, breakerClosed},
+		{"failure", 0, down, true, breakerClosed},
+		{"threshold", 0, down, true, breakerOpen},
+		{"open fails fast", 10 * time.Second, nil, false, breakerOpen},
+		{"failed probe reopens", 20 * time.Second, down, true, breakerOpen},
+		{"cooldown restarted", 20 * time.Second, nil, false, breakerOpen},
+		{"good probe closes", 10 * time.Second, nil, true, breakerClosed},
+		{"a 4xx is no failure", 0, &upstreamError{Status: 404, Msg: "no"}, true, breakerClosed},
+		{"nor is a second", 0, &upstreamError{Status: 422, Msg: "no"}, true, breakerClosed},
+	}
+	for _, st := range steps {
+		clk.Advance(st.advance)
+		up.err = st.err
+		before := up.calls.Load()
+		_, err := b.Translate(context.Background(), translateReq{Q: "x"})
+		var open *breakerOpenError
+		if called := up.calls.Load() > before; called != st.called || !called && !errors.As(err, &open) {
+			t.Fatalf("%s: called %v (%v), want %v", st.name, called, err, st.called)
+		}
+		if got := b.current(); got != st.state {
+			t.Fatalf("%s: state %s, want %s", st.name, got, st.state)
+		}
+	}
+}
+
+// TestBreakerSingleProbe lets one call through when half-open, refusing the rest until it ends.
+func TestBreakerSingleProbe(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	b := newBreaker("probe.test", failingUpstream{}, 1, time.Second, clk)
+	b.Translate(context.Background(), translateReq{Q: "x"})
+	clk.Advance(time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe refused: %v", err)
+	}
+	var open *breakerOpenError
+	if err := b.allow(); !errors.As(err, &open) {
+		t.Fatalf("second call while probing: %v, want refused", err)
+	}
+	b.record(context.Background(), nil)
+	if err := b.allow(); err != nil {
+		t.Fatalf("after the probe: %v", err)
+	}
+}
+
+// TestBreakerOpenServesCache proxies to an upstream that goes down: once the breaker opens,
+// translations fail fast with a 503 and Retry-After while cached phrases are still served.
+func TestBreakerOpenServesCache(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int64
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"data":{"tgt":"T"}}`))
+	}))
+	defer up.Close()
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := newTestServer(t, map[string]string{
+		"UPSTREAM_URL":          up.URL,
+		"UPSTREAM_MAX_ATTEMPTS": "1",
+		"BREAKER_FAILURES":      "2",
+		"BREAKER_COOLDOWN":      "30s",
+	}, Deps{Clock: clk}).Handler()
+	get := func(q string) *httptest.ResponseRecorder {
+		return serve(h, "GET", "/go/translate?q="+q, "", "X-API-Key", testProKey)
+	}
+	if w := get("cached"); w.Code != http.StatusOK {
+		t.Fatalf("warm: status %d: %s", w.Code, w.Body.String())
+	}
+	failing.Store(true)
+	get("one")
+	get("two")
+	n := calls.Load()
+	w := get("three")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("open: status %d, Retry-After %q: %s", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+	if calls.Load() != n {
+		t.Fatal("open breaker called the upstream")
+	}
+	if w := get("cached"); w.Code != http.StatusOK {
+		t.Fatalf("cached phrase with the breaker open: status %d", w.Code)
+	}
+}
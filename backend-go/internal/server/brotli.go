@@ -0,0 +1,666 @@
+package server
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// A compact brotli (RFC 7932) encoder for response compression. It does greedy LZ77 over a hash
+// chain, with one-step lazy matching at higher qualities, and writes one prefix code per alphabet
+// per meta-block. There is no static dictionary, context modeling or block splitting, so it trails
+// the reference encoder on ratio, but on JSON it still beats gzip at on-the-fly speeds. Everything
+// it allocates lives on the brotliWriter, which compress pools.
+
+const (
+	brotliWindowBits = 18      // WBITS in the stream header; the window must cover brotliHistory+brotliBlock
+	brotliBlock      = 1 << 16 // input bytes per meta-block
+	brotliHistory    = 1 << 16 // bytes kept behind a block for matches into the ones before
+	brotliHashBits   = 15
+	brotliMinMatch   = 4
+	brotliUnused     = 0xff // brotliCommand.dcode when the command carries no distance
+)
+
+// Insert and copy length codes: the smallest length each code covers and its extra bits.
+var (
+	brotliInsBase   = [24]uint32{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+	brotliInsExtra  = [24]uint8{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+	brotliCopyBase  = [24]uint32{2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118}
+	brotliCopyExtra = [24]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24}
+
+	// brotliCells is the first insert-and-copy code of each (insert code / 8, copy code / 8) cell
+	// that reads an explicit distance.
+	brotliCells = [3][3]uint16{{128, 192, 384}, {256, 320, 512}, {448, 576, 640}}
+
+	// The code-length code's lengths are sent in this order, each with a fixed prefix code.
+	brotliCodeLengthOrder = [18]uint8{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	brotliLengthSymbols   = [6]uint64{0, 7, 3, 2, 1, 15}
+	brotliLengthBits      = [6]uint{2, 4, 3, 2, 2, 4}
+)
+
+// brotliCommand is one insert-and-copy: ins literals from lit, then copy bytes from dist back.
+// The last command of a meta-block may copy nothing.
+type brotliCommand struct {
+	lit, ins, copy, dist int
+
+	code         uint16 // insert-and-copy symbol
+	insN, copyN  uint8  // extra bits
+	insX, copyX  uint32
+	dcode, distN uint8 // distance symbol (brotliUnused for none) and its extra bits
+	distX        uint32
+}
+
+// brotliWriter compresses to w. Output comes in meta-blocks of brotliBlock input bytes; Flush
+// ends one early and byte-aligns the stream, and Close finishes it. It is reused through Reset.
+type brotliWriter struct {
+	w     io.Writer
+	chain int  // hash chain candidates tried per position
+	lazy  bool // try a match one byte later before taking one
+
+	started  bool
+	err      error
+	buf      []byte // history, then pending input
+	hist     int    // length of the history in buf
+	lastDist int    // the decoder's last distance, which distance code 0 repeats
+	bw       bitWriter
+
+	head []int32 // by hash: index+1 of the latest position in buf, 0 for none
+	prev []int32 // by position: index+1 of the previous one with the same hash
+	cmds []brotliCommand
+
+	litHist  [256]uint32
+	cmdHist  [704]uint32
+	distHist [64]uint32
+	litDepth [256]uint8
+	cmdDepth [704]uint8
+	dstDepth [64]uint8
+	litCode  [256]uint16
+	cmdCode  [704]uint16
+	dstCode  [64]uint16
+	huff     huffmanScratch
+}
+
+// newBrotliWriter returns an encoder at quality 1 (fastest) to 11.
+func newBrotliWriter(w io.Writer, quality int) *brotliWriter {
+	q := min(max(quality, 1), 11)
+	b := &brotliWriter{
+		chain: 1 << min(q-1, 10),
+		lazy:  q >= 4,
+		head:  make([]int32, 1<<brotliHashBits),
+	}
+	b.Reset(w)
+	return b
+}
+
+// Reset discards any state and starts a new stream to w.
+func (b *brotliWriter) Reset(w io.Writer) {
+	b.w, b.started, b.err = w, false, nil
+	b.buf, b.hist, b.lastDist = b.buf[:0], 0, 4
+	b.bw = bitWriter{out: b.bw.out[:0]}
+}
+
+func (b *brotliWriter) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		k := min(len(p), brotliBlock-(len(b.buf)-b.hist))
+		b.buf = append(b.buf, p[:k]...)
+		p = p[k:]
+		if len(b.buf)-b.hist == brotliBlock {
+			b.metaBlock()
+			if err := b.emit(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush compresses what is pending and pads the stream to a byte boundary with an empty
+// metadata block, so the client can decode everything written so far.
+func (b *brotliWriter) Flush() error {
+	if b.err != nil {
+		return b.err
+	}
+	b.metaBlock()
+	b.header()
+	b.bw.write(1, 0) // ISLAST
+	b.bw.write(2, 3) // MNIBBLES: metadata
+	b.bw.write(1, 0) // reserved
+	b.bw.write(2, 0) // MSKIPBYTES: no metadata bytes
+	b.bw.align()
+	return b.emit()
+}
+
+// Close compresses what is pending and ends the stream. It doesn't close w.
+func (b *brotliWriter) Close() error {
+	if b.err != nil {
+		return b.err
+	}
+	b.metaBlock()
+	b.header()
+	b.bw.write(1, 1) // ISLAST
+	b.bw.write(1, 1) // ISLASTEMPTY
+	b.bw.align()
+	err := b.emit()
+	if err == nil {
+		b.err = io.ErrClosedPipe
+	}
+	return err
+}
+
+// header writes the stream header, once.
+func (b *brotliWriter) header() {
+	if !b.started {
+		b.started = true
+		b.bw.write(1, 1)
+		b.bw.write(3, brotliWindowBits-17)
+	}
+}
+
+// emit writes the whole bytes produced so far to w.
+func (b *brotliWriter) emit() error {
+	if len(b.bw.out) == 0 {
+		return nil
+	}
+	_, b.err = b.w.Write(b.bw.out)
+	b.bw.out = b.bw.out[:0]
+	return b.err
+}
+
+// metaBlock compresses the pending input into one meta-block and moves it into the history.
+func (b *brotliWriter) metaBlock() {
+	data, start := b.buf, b.hist
+	if len(data) == start {
+		return
+	}
+	b.header()
+	mark, lastDist := b.bw.mark(), b.lastDist
+	b.parse(data, start)
+	b.writeCompressed(data)
+	if bitsUsed := b.bw.since(mark); bitsUsed > 8*(len(data)-start)+64 {
+		b.bw.rewind(mark) // incompressible: store it
+		b.lastDist = lastDist
+		b.metaHeader(len(data) - start)
+		b.bw.write(1, 1) // ISUNCOMPRESSED
+		b.bw.align()
+		b.bw.out = append(b.bw.out, data[start:]...)
+	}
+	keep := min(len(data), brotliHistory)
+	copy(b.buf, data[len(data)-keep:])
+	b.buf, b.hist = b.buf[:keep], keep
+}
+
+// metaHeader writes ISLAST=0 and MLEN.
+func (b *brotliWriter) metaHeader(mlen int) {
+	nibbles := 4
+	for nibbles < 6 && mlen-1 >= 1<<(4*nibbles) {
+		nibbles++
+	}
+	b.bw.write(1, 0)
+	b.bw.write(2, uint64(nibbles-4))
+	b.bw.write(uint(4*nibbles), uint64(mlen-1))
+}
+
+func brotliHash(p []byte) uint32 {
+	return (binary.LittleEndian.Uint32(p) * 0x1e35a7bd) >> (32 - brotliHashBits)
+}
+
+// parse splits data[start:] into commands, matching back into all of data.
+func (b *brotliWriter) parse(data []byte, start int) {
+	clear(b.head)
+	if cap(b.prev) < len(data) {
+		b.prev = make([]int32, len(data), brotliHistory+brotliBlock)
+	}
+	b.prev = b.prev[:len(data)]
+	insert := func(i int) {
+		if i+brotliMinMatch <= len(data) {
+			h := brotliHash(data[i:])
+			b.prev[i], b.head[h] = b.head[h], int32(i+1)
+		}
+	}
+	for i := 0; i < start; i++ {
+		insert(i)
+	}
+	b.cmds = b.cmds[:0]
+	lastDist := b.lastDist
+	lit, i := start, start
+	for i+brotliMinMatch <= len(data) {
+		n, dist := b.match(data, i, lastDist)
+		insert(i)
+		if n < brotliMinMatch {
+			i++
+			continue
+		}
+		if b.lazy && i+1+brotliMinMatch <= len(data) {
+			if n2, _ := b.match(data, i+1, lastDist); n2 > n+1 {
+				i++ // data[i] goes out as a literal and the longer match is taken next round
+				continue
+			}
+		}
+		b.cmds = append(b.cmds, brotliCommand{lit: lit, ins: i - lit, copy: n, dist: dist})
+		lastDist = dist
+		for k := i + 1; k < i+n; k++ {
+			insert(k)
+		}
+		i += n
+		lit = i
+	}
+	if lit < len(data) {
+		b.cmds = append(b.cmds, brotliCommand{lit: lit, ins: len(data) - lit})
+	}
+}
+
+// match returns the longest match for data[i:] and its distance, trying the last distance first
+// since it is the cheapest to send.
+func (b *brotliWriter) match(data []byte, i, lastDist int) (int, int) {
+	best, dist := 0, 0
+	if lastDist <= i {
+		if n := matchLen(data[i-lastDist:], data[i:]); n >= brotliMinMatch {
+			best, dist = n, lastDist
+		}
+	}
+	cand := int(b.head[brotliHash(data[i:])]) - 1
+	for tries := b.chain; cand >= 0 && cand < i && tries > 0 && i+best < len(data); tries-- {
+		if data[cand+best] == data[i+best] {
+			if n := matchLen(data[cand:], data[i:]); n > best {
+				best, dist = n, i-cand
+			}
+		}
+		cand = int(b.prev[cand]) - 1
+	}
+	return best, dist
+}
+
+// matchLen is the length of the common prefix of a and b, a starting earlier in the same buffer
+// and possibly overlapping b.
+func matchLen(a, b []byte) int {
+	n := 0
+	for n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// writeCompressed writes the meta-block for b.cmds, whose literals are in data.
+func (b *brotliWriter) writeCompressed(data []byte) {
+	clear(b.litHist[:])
+	clear(b.cmdHist[:])
+	clear(b.distHist[:])
+	mlen := 0
+	for ci := range b.cmds {
+		c := &b.cmds[ci]
+		mlen += c.ins + c.copy
+		insCode := brotliLengthCode(brotliInsBase[:], uint32(c.ins))
+		c.insN, c.insX = brotliInsExtra[insCode], uint32(c.ins)-brotliInsBase[insCode]
+		copyLen := max(c.copy, 2) // a trailing insert-only command still names a copy length
+		copyCode := brotliLengthCode(brotliCopyBase[:], uint32(copyLen))
+		c.copyN, c.copyX = brotliCopyExtra[copyCode], uint32(copyLen)-brotliCopyBase[copyCode]
+		c.dcode = brotliUnused
+		switch {
+		case (c.copy == 0 || c.dist == b.lastDist) && insCode < 8 && copyCode < 16:
+			c.code = uint16(insCode&7)<<3 | uint16(copyCode&7) // distance code 0, implied
+			if copyCode >= 8 {
+				c.code += 64
+			}
+		default:
+			c.code = brotliCells[insCode>>3][copyCode>>3] + uint16(insCode&7)<<3 | uint16(copyCode&7)
+			switch {
+			case c.copy == 0:
+			case c.dist == b.lastDist:
+				c.dcode, c.distN, c.distX = 0, 0, 0
+			default:
+				x := uint32(c.dist + 3)
+				nd := uint8(bits.Len32(x) - 2)
+				c.dcode = 16 + 2*(nd-1) + uint8(x>>nd&1)
+				c.distN, c.distX = nd, x&(1<<nd-1)
+			}
+		}
+		if c.dcode != brotliUnused && c.dcode != 0 {
+			b.lastDist = c.dist
+		}
+		b.cmdHist[c.code]++
+		if c.dcode != brotliUnused {
+			b.distHist[c.dcode]++
+		}
+		for _, ch := range data[c.lit : c.lit+c.ins] {
+			b.litHist[ch]++
+		}
+	}
+	b.huff.depths(b.litHist[:], 15, b.litDepth[:])
+	b.huff.depths(b.cmdHist[:], 15, b.cmdDepth[:])
+	b.huff.depths(b.distHist[:], 15, b.dstDepth[:])
+
+	b.metaHeader(mlen)
+	b.bw.write(1, 0) // ISUNCOMPRESSED
+	b.bw.write(1, 0) // NBLTYPESL: one block type each
+	b.bw.write(1, 0)
+	b.bw.write(1, 0)
+	b.bw.write(2, 0) // NPOSTFIX
+	b.bw.write(4, 0) // NDIRECT
+	b.bw.write(2, 0) // the literal context mode, moot with one tree
+	b.bw.write(1, 0) // NTREESL
+	b.bw.write(1, 0) // NTREESD
+	b.storePrefixCode(b.litHist[:], b.litDepth[:], b.litCode[:], 8)
+	b.storePrefixCode(b.cmdHist[:], b.cmdDepth[:], b.cmdCode[:], 10)
+	b.storePrefixCode(b.distHist[:], b.dstDepth[:], b.dstCode[:], 6)
+
+	for _, c := range b.cmds {
+		b.bw.write(uint(b.cmdDepth[c.code]), uint64(b.cmdCode[c.code]))
+		b.bw.write(uint(c.insN), uint64(c.insX))
+		b.bw.write(uint(c.copyN), uint64(c.copyX))
+		for _, ch := range data[c.lit : c.lit+c.ins] {
+			b.bw.write(uint(b.litDepth[ch]), uint64(b.litCode[ch]))
+		}
+		if c.dcode != brotliUnused {
+			b.bw.write(uint(b.dstDepth[c.dcode]), uint64(b.dstCode[c.dcode]))
+			b.bw.write(uint(c.distN), uint64(c.distX))
+		}
+	}
+}
+
+// brotliLengthCode is the code whose range, starting at base[code], holds n.
+func brotliLengthCode(base []uint32, n uint32) int {
+	c := len(base) - 1
+	for base[c] > n {
+		c--
+	}
+	return c
+}
+
+// storePrefixCode writes the code for one alphabet and fills codes from depth. Up to four used
+// symbols go in the simple form, with symbols of alphabetBits each; more use the complex form,
+// whose code lengths are themselves run-length and prefix coded.
+func (b *brotliWriter) storePrefixCode(hist []uint32, depth []uint8, codes []uint16, alphabetBits uint) {
+	var used [4]int
+	n := 0
+	for s, c := range hist {
+		if c > 0 {
+			if n < 4 {
+				used[n] = s
+			}
+			n++
+		}
+	}
+	if n <= 4 {
+		if n <= 1 {
+			n, depth[used[0]] = 1, 0 // one symbol, or none at all, takes no bits
+		}
+		syms := used[:n]
+		slices.SortFunc(syms, func(x, y int) int {
+			if depth[x] != depth[y] {
+				return int(depth[x]) - int(depth[y])
+			}
+			return x - y
+		})
+		b.bw.write(2, 1) // simple
+		b.bw.write(2, uint64(n-1))
+		for _, s := range syms {
+			b.bw.write(alphabetBits, uint64(s))
+		}
+		if n == 4 {
+			b.bw.write(1, uint64(2-depth[syms[0]])) // 1: lengths 1,2,3,3; 0: all 2
+		}
+		canonicalCodes(depth, codes)
+		return
+	}
+	canonicalCodes(depth, codes)
+
+	// Code lengths, run-length coded: 16 repeats the previous non-zero length, 17 repeats zero.
+	tree, extra := b.huff.tree[:0], b.huff.extra[:0]
+	last := len(depth)
+	for last > 0 && depth[last-1] == 0 {
+		last--
+	}
+	prev := uint8(8)
+	for i := 0; i < last; {
+		v, reps := depth[i], 1
+		for i+reps < last && depth[i+reps] == v {
+			reps++
+		}
+		i += reps
+		if v == 0 {
+			tree, extra = rleZeros(tree, extra, reps)
+			continue
+		}
+		tree, extra = rleLengths(tree, extra, prev, v, reps)
+		prev = v
+	}
+	b.huff.tree, b.huff.extra = tree, extra
+
+	var clHist [18]uint32
+	for _, s := range tree {
+		clHist[s]++
+	}
+	var clDepth [18]uint8
+	var clCode [18]uint16
+	b.huff.depths(clHist[:], 5, clDepth[:])
+	canonicalCodes(clDepth[:], clCode[:])
+	distinct := 0
+	for _, c := range clHist {
+		if c > 0 {
+			distinct++
+		}
+	}
+	// The lengths of the code-length code, in brotliCodeLengthOrder, dropping zeros at either end.
+	store := len(brotliCodeLengthOrder)
+	if distinct > 1 {
+		for store > 0 && clDepth[brotliCodeLengthOrder[store-1]] == 0 {
+			store--
+		}
+	}
+	skip := 0
+	if clDepth[brotliCodeLengthOrder[0]] == 0 && clDepth[brotliCodeLengthOrder[1]] == 0 {
+		skip = 2
+		if clDepth[brotliCodeLengthOrder[2]] == 0 {
+			skip = 3
+		}
+	}
+	b.bw.write(2, uint64(skip))
+	for _, s := range brotliCodeLengthOrder[skip:store] {
+		l := clDepth[s]
+		b.bw.write(brotliLengthBits[l], brotliLengthSymbols[l])
+	}
+	if distinct == 1 {
+		clear(clDepth[:]) // a single code length symbol is read with no bits
+	}
+	for i, s := range tree {
+		b.bw.write(uint(clDepth[s]), uint64(clCode[s]))
+		switch s {
+		case 16:
+			b.bw.write(2, uint64(extra[i]))
+		case 17:
+			b.bw.write(3, uint64(extra[i]))
+		}
+	}
+}
+
+// rleZeros appends a run of reps zero lengths. Consecutive 17s multiply, so a long run is
+// written most significant part first.
+func rleZeros(tree, extra []uint8, reps int) ([]uint8, []uint8) {
+	if reps == 11 {
+		tree, extra = append(tree, 0), append(extra, 0)
+		reps--
+	}
+	if reps < 3 {
+		for ; reps > 0; reps-- {
+			tree, extra = append(tree, 0), append(extra, 0)
+		}
+		return tree, extra
+	}
+	start := len(tree)
+	reps -= 3
+	for {
+		tree, extra = append(tree, 17), append(extra, uint8(reps&7))
+		if reps >>= 3; reps == 0 {
+			break
+		}
+		reps--
+	}
+	slices.Reverse(tree[start:])
+	slices.Reverse(extra[start:])
+	return tree, extra
+}
+
+// rleLengths appends a run of reps copies of the non-zero length v, prev being the last
+// non-zero length written.
+func rleLengths(tree, extra []uint8, prev, v uint8, reps int) ([]uint8, []uint8) {
+	if prev != v {
+		tree, extra = append(tree, v), append(extra, 0)
+		reps--
+	}
+	if reps == 7 {
+		tree, extra = append(tree, v), append(extra, 0)
+		reps--
+	}
+	if reps < 3 {
+		for ; reps > 0; reps-- {
+			tree, extra = append(tree, v), append(extra, 0)
+		}
+		return tree, extra
+	}
+	start := len(tree)
+	reps -= 3
+	for {
+		tree, extra = append(tree, 16), append(extra, uint8(reps&3))
+		if reps >>= 2; reps == 0 {
+			break
+		}
+		reps--
+	}
+	slices.Reverse(tree[start:])
+	slices.Reverse(extra[start:])
+	return tree, extra
+}
+
+// canonicalCodes assigns the canonical prefix code for depth, bit-reversed since brotli writes
+// codes least significant bit first.
+func canonicalCodes(depth []uint8, codes []uint16) {
+	var count, next [16]uint16
+	for _, d := range depth {
+		count[d]++
+	}
+	count[0] = 0
+	code := uint16(0)
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, d := range depth {
+		if d > 0 {
+			codes[s] = bits.Reverse16(next[d]) >> (16 - d)
+			next[d]++
+		}
+	}
+}
+
+// huffmanScratch is reused across meta-blocks for building codes.
+type huffmanScratch struct {
+	nodes       []huffmanNode
+	tree, extra []uint8
+}
+
+type huffmanNode struct {
+	count       uint32
+	left, right int32 // children; -1 for a leaf
+	sym         int32
+	depth       uint8
+}
+
+// depths fills depth with Huffman code lengths for hist, none longer than limit. When the code
+// comes out too deep, the smallest counts are raised and it is built again. A single used symbol
+// gets length 1.
+func (h *huffmanScratch) depths(hist []uint32, limit uint8, depth []uint8) {
+	for floor := uint32(1); ; floor *= 2 {
+		clear(depth)
+		h.nodes = h.nodes[:0]
+		for s, c := range hist {
+			if c > 0 {
+				h.nodes = append(h.nodes, huffmanNode{count: max(c, floor), left: -1, right: -1, sym: int32(s)})
+			}
+		}
+		n := len(h.nodes)
+		if n == 0 {
+			return
+		}
+		if n == 1 {
+			depth[h.nodes[0].sym] = 1
+			return
+		}
+		slices.SortFunc(h.nodes, func(a, b huffmanNode) int {
+			if a.count != b.count {
+				if a.count < b.count {
+					return -1
+				}
+				return 1
+			}
+			return int(a.sym - b.sym)
+		})
+		// Two queues: the sorted leaves, and the merged nodes, which come out in count order.
+		leaf, merged := 0, n
+		pick := func() int32 {
+			if leaf < n && (merged >= len(h.nodes) || h.nodes[leaf].count <= h.nodes[merged].count) {
+				leaf++
+				return int32(leaf - 1)
+			}
+			merged++
+			return int32(merged - 1)
+		}
+		for k := 0; k < n-1; k++ {
+			l, r := pick(), pick()
+			h.nodes = append(h.nodes, huffmanNode{count: h.nodes[l].count + h.nodes[r].count, left: l, right: r, sym: -1})
+		}
+		deepest := uint8(0)
+		for k := len(h.nodes) - 1; k >= n; k-- {
+			d := h.nodes[k].depth + 1
+			h.nodes[h.nodes[k].left].depth, h.nodes[h.nodes[k].right].depth = d, d
+		}
+		for _, nd := range h.nodes[:n] {
+			depth[nd.sym] = nd.depth
+			deepest = max(deepest, nd.depth)
+		}
+		if deepest <= limit {
+			return
+		}
+	}
+}
+
+// bitWriter packs values least significant bit first.
+type bitWriter struct {
+	out []byte
+	acc uint64
+	n   uint
+}
+
+func (w *bitWriter) write(n uint, v uint64) {
+	w.acc |= v << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.n -= 8
+	}
+}
+
+// align pads with zero bits to the next byte boundary.
+func (w *bitWriter) align() {
+	if w.n > 0 {
+		w.write(8-w.n, 0)
+	}
+}
+
+type bitMark struct {
+	len int
+	acc uint64
+	n   uint
+}
+
+func (w *bitWriter) mark() bitMark { return bitMark{len(w.out), w.acc, w.n} }
+
+func (w *bitWriter) rewind(m bitMark) { w.out, w.acc, w.n = w.out[:m.len], m.acc, m.n }
+
+// since is the number of bits written after m.
+func (w *bitWriter) since(m bitMark) int { return 8*(len(w.out)-m.len) + int(w.n) - int(m.n) }
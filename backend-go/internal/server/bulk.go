@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// bulkOpts bounds POST /go/translate/bulk.
+type bulkOpts struct {
+	MaxLines     int
+	MaxLineBytes int
+	Workers      int
+}
+
+// bulkLine is one line of bulk output. The last line is either {"done": true, ...} or, when the
+// upload was cut short, an error line without an id.
+type bulkLine struct {
+	ID          string `json:"id,omitempty"`
+	Translation string `json:"translation,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Done        bool   `json:"done,omitempty"`
+	Count       int    `json:"count,omitempty"`
+	Errors      int    `json:"errors,omitempty"`
+}
+
+// bulkHandler serves POST /go/translate/bulk for pro keys. The body is NDJSON, one
+// {"id", "q"[, "src", "dst"]} object per line, and is read as it arrives; each line is answered
+// with an NDJSON line as soon as it's translated, so output order follows completion, not
+// input. ?src= and ?dst= set defaults for lines that omit them. The upload counts as one
+// request against the key's usage; every line's characters count against its quota.
+func bulkHandler(svc *translateService, opts bulkOpts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tierFrom(r.Context()) != tierPro {
+			upgradeRequired("bulk translations").write(w)
+			return
+		}
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/x-ndjson" {
+			writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "content type must be application/x-ndjson")
+			return
+		}
+		svc.usage.request(r.Context())
+		if err := svc.usage.exhausted(r.Context()); err != nil {
+			writeTranslateError(r.Context(), w, err)
+			return
+		}
+
+		// Responses start before the body is fully read, so reads must outlive the first write
+		// and the server's ReadTimeout; the route timeout still bounds both.
+		rc := http.NewResponseController(w)
+		_ = rc.EnableFullDuplex()
+		if dl, ok := r.Context().Deadline(); ok {
+			_ = rc.SetReadDeadline(dl)
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		// Wait for the first byte before answering: a client sending Expect: 100-continue gets
+		// its 100 on that read, and the body would be closed if the 200 went out first.
+		body := bufio.NewReader(r.Body)
+		_, _ = body.Peek(1)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		_ = rc.Flush()
+
+		q := r.URL.Query()
+		defaults := batchReq{Src: q.Get("src"), Dst: q.Get("dst"), AllowBidi: queryBool(q.Get("allow_bidi"))}
+		var (
+			wg      sync.WaitGroup
+			readErr error
+			jobs    = make(chan batchItem)
+			out     = make(chan bulkLine)
+		)
+		send := func(l bulkLine) {
+			select {
+			case out <- l:
+			case <-ctx.Done():
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(jobs)
+			readErr = readBulk(ctx, body, opts, jobs, send)
+		}()
+		for range keyLeaseFrom(ctx).widen(max(opts.Workers, 1)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for it := range jobs {
+					res := translateItem(ctx, batchItems{svc}, defaults, it)
+					send(bulkLine{ID: it.ID, Translation: res.Translation, Error: res.Error})
+				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			close(out)
+		}()
+
+		enc := json.NewEncoder(w)
+		var count, failed int
+		var writeErr error
+		for l := range out {
+			if writeErr != nil {
+				continue // client is gone; drain so the workers can exit
+			}
+			count++
+			if l.Error != "" {
+				failed++
+			}
+			if writeErr = enc.Encode(l); writeErr == nil {
+				writeErr = rc.Flush()
+			}
+			if writeErr != nil {
+				cancel()
+			}
+		}
+		if writeErr != nil {
+			return
+		}
+		switch {
+		case readErr != nil:
+			_ = enc.Encode(bulkLine{Error: readErr.Error(), Count: count, Errors: failed})
+		case r.Context().Err() != nil:
+			_ = enc.Encode(bulkLine{Error: ctxErrMsg(r.Context().Err()), Count: count, Errors: failed})
+		default:
+			_ = enc.Encode(bulkLine{Done: true, Count: count, Errors: failed})
+		}
+	}
+}
+
+// readBulk scans NDJSON lines into jobs. Malformed lines are answered through send and skipped;
+// an overlong line, too many lines or a failed read stops the upload with an error.
+func readBulk(ctx context.Context, body io.Reader, opts bulkOpts, jobs chan<- batchItem, send func(bulkLine)) error {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, min(opts.MaxLineBytes, 64<<10)), opts.MaxLineBytes)
+	n, lines := 0, 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if lines++; lines > opts.MaxLines {
+			return fmt.Errorf("too many lines (max %d)", opts.MaxLines)
+		}
+		var it batchItem
+		if err := json.Unmarshal([]byte(line), &it); err != nil {
+			send(bulkLine{Error: fmt.Sprintf("line %d: invalid JSON", n)})
+			continue
+		}
+		if it.ID == "" {
+			send(bulkLine{Error: fmt.Sprintf("line %d: missing field 'id'", n)})
+			continue
+		}
+		select {
+		case jobs <- it:
+		case <-ctx.Done():
+			return nil // reported by the caller as canceled or timeout
+		}
+	}
+	err := sc.Err()
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil, ctx.Err() != nil:
+		return nil
+	case errors.Is(err, bufio.ErrTooLong):
+		return fmt.Errorf("line %d exceeds %d bytes", n+1, opts.MaxLineBytes)
+	case errors.As(err, &tooLarge):
+		return fmt.Errorf("request body too large (limit %d bytes)", tooLarge.Limit)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return errors.New("timeout")
+	default:
+		return fmt.Errorf("read failed after line %d", n)
+	}
+}
@@ -0,0 +1,575 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Cache stores translate results by normalized key. Implementations must be safe for concurrent use;
+// callers treat errors as misses so a broken backend never fails a request.
+type Cache interface {
+	Get(ctx context.Context, key string) (cacheValue, bool, error)
+	Set(ctx context.Context, key string, res translateResult) error
+	Delete(ctx context.Context, key string) (bool, error)
+	Flush(ctx context.Context) (int, error) // removes every entry, returning how many
+	Stats(ctx context.Context) (cacheStats, error)
+}
+
+// cacheValue is a cached result and when it was stored.
+type cacheValue struct {
+	Result   translateResult
+	StoredAt time.Time
+}
+
+// cacheKey normalizes (q, src, dst) so trivially different inputs share an entry: on q's
+// canonicalKey, or with the fuzzy_keys flag off, on q with its whitespace collapsed. Extended
+// results come from a different upstream route and are kept apart, as are n-best ones, which
+// carry alternatives the plain entry doesn't. A caller with its own glossary gets entries of its
+// own, keyed by the glossary's hash, so nothing translated around one client's terms is served to
+// another.
+func (kf keyFolding) cacheKey(req translateReq) string {
+	q := strings.Join(strings.Fields(req.Q), " ")
+	if kf.fuzzy() {
+		q = kf.canonicalKey(req.Q)
+	}
+	k := strings.ToLower(req.Src) + "\x00" + strings.ToLower(req.Dst) + "\x00" + q
+	if req.Extended {
+		k += "\x00x"
+	}
+	if req.NBest > 0 {
+		k += "\x00n" + strconv.Itoa(req.NBest)
+	}
+	if req.GlossaryHash != "" {
+		k += "\x00g" + req.GlossaryHash
+	}
+	return k
+}
+
+// lruCache is an LRU with a per-entry TTL, safe for concurrent use, bounded both by entry count
+// and by the approximate bytes its entries hold. Entries hold the decoded translateResult, so a
+// hit never re-parses the upstream body.
+type lruCache struct {
+	mu       sync.Mutex
+	max      int
+	maxBytes int64
+	maxEntry int64 // entries larger than this aren't cached at all
+	ttl      time.Duration
+	clock    Clock
+	ll       *list.List // front = most recently used
+	items    map[string]*list.Element
+	bytes    int64 // sum of entry sizes, guarded by mu
+
+	hits, misses, evictions, evictedBytes, oversized atomic.Uint64
+}
+
+type cacheEntry struct {
+	key      string
+	res      translateResult
+	storedAt time.Time
+	size     int64
+	hits     int64 // guarded by lruCache.mu
+}
+
+// cacheEntryOverhead approximates what an entry costs beyond its strings: the entry and result
+// structs, the list element and the map slot.
+const cacheEntryOverhead = 240
+
+// entrySize approximates the memory an entry holds. It only needs to be proportionate, so that
+// a paragraph weighs more than a word.
+func entrySize(key string, res translateResult) int64 {
+	n := cacheEntryOverhead + len(key) + len(res.Translation) + len(res.Src) + len(res.Pack) +
+		len(res.Script) + len(res.DetectedSrc) + len(res.Pair.Src) + len(res.Pair.Dst)
+	for _, g := range res.Glossary {
+		n += len(g) + 16
+	}
+	for _, a := range res.Alternatives {
+		n += len(a.Translation) + 24
+	}
+	return int64(n)
+}
+
+// cacheStats is a point-in-time snapshot of cache counters.
+type cacheStats struct {
+	Backend   string `json:"backend"`
+	Entries   int    `json:"entries"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"` // capacity evictions; Redis evicts on its own and reports 0
+
+	// Memory accounting of the in-memory cache; zero for Redis.
+	Bytes        int64  `json:"bytes"`
+	MaxBytes     int64  `json:"max_bytes"`
+	EvictedBytes uint64 `json:"evicted_bytes"`
+	Oversized    uint64 `json:"oversized"` // results too large to cache, served uncached
+}
+
+// newLRUCache returns a cache holding at most max entries and maxBytes of them. A single entry
+// over maxEntryPercent of maxBytes bypasses the cache instead of evicting everything else.
+func newLRUCache(max int, maxBytes int64, maxEntryPercent int, ttl time.Duration, clk Clock) *lruCache {
+	return &lruCache{
+		max:      max,
+		maxBytes: maxBytes,
+		maxEntry: maxBytes * int64(maxEntryPercent) / 100,
+		ttl:      ttl,
+		clock:    clk,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached value. Expired entries count as misses and are dropped.
+func (c *lruCache) Get(_ context.Context, key string) (cacheValue, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return cacheValue{}, false, nil
+	}
+	e := el.Value.(*cacheEntry)
+	if c.clock.Now().Sub(e.storedAt) > c.ttl {
+		c.removeElement(el)
+		c.misses.Add(1)
+		return cacheValue{}, false, nil
+	}
+	c.ll.MoveToFront(el)
+	c.hits.Add(1)
+	e.hits++
+	return cacheValue{Result: e.res, StoredAt: e.storedAt}, true, nil
+}
+
+// Set stores res under key, evicting least recently used entries until both bounds hold. An
+// entry over the per-entry limit isn't stored, and drops any older value under key.
+func (c *lruCache) Set(_ context.Context, key string, res translateResult) error {
+	size := entrySize(key, res)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if size > c.maxEntry {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+		}
+		c.oversized.Add(1)
+		metricCacheOversized.Inc()
+		return nil
+	}
+	e := &cacheEntry{key: key, res: res, storedAt: c.clock.Now(), size: size}
+	if el, ok := c.items[key]; ok {
+		c.bytes += size - el.Value.(*cacheEntry).size
+		el.Value = e
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(e)
+		c.bytes += size
+	}
+	for c.ll.Len() > c.max || c.bytes > c.maxBytes {
+		back := c.ll.Back()
+		n := back.Value.(*cacheEntry).size
+		c.removeElement(back)
+		c.evictions.Add(1)
+		c.evictedBytes.Add(uint64(n))
+		metricCacheEvictions.Inc()
+		metricCacheEvictedBytes.Add(float64(n))
+	}
+	metricCacheBytes.Set(float64(c.bytes))
+	return nil
+}
+
+func (c *lruCache) Delete(_ context.Context, key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		c.removeElement(el)
+	}
+	return ok, nil
+}
+
+func (c *lruCache) Flush(context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.ll.Len()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+	metricCacheBytes.Set(0)
+	return n, nil
+}
+
+// Export copies the live entries stored at or after since, then hands them to fn outside the
+// lock. The copy is bounded by CACHE_MAX_ENTRIES and CACHE_MAX_BYTES.
+func (c *lruCache) Export(ctx context.Context, since time.Time, fn func(cacheRow) error) error {
+	c.mu.Lock()
+	rows := make([]cacheRow, 0, c.ll.Len())
+	for el := c.ll.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*cacheEntry)
+		if e.storedAt.Before(since) || c.clock.Now().Sub(e.storedAt) > c.ttl {
+			continue
+		}
+		row := rowFromKey(e.key)
+		if e.res.Query != "" {
+			row.Source = e.res.Query
+		}
+		row.Translation, row.Hits, row.CreatedAt = e.res.Translation, e.hits, e.storedAt
+		rows = append(rows, row)
+	}
+	c.mu.Unlock()
+	for _, row := range rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *lruCache) removeElement(el *list.Element) {
+	e := el.Value.(*cacheEntry)
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+	metricCacheBytes.Set(float64(c.bytes))
+}
+
+func (c *lruCache) Stats(context.Context) (cacheStats, error) {
+	c.mu.Lock()
+	n, b := c.ll.Len(), c.bytes
+	c.mu.Unlock()
+	return cacheStats{
+		Backend:      "memory",
+		Entries:      n,
+		Hits:         c.hits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Bytes:        b,
+		MaxBytes:     c.maxBytes,
+		EvictedBytes: c.evictedBytes.Load(),
+		Oversized:    c.oversized.Load(),
+	}, nil
+}
+
+// cachedTranslator serves repeated requests from the cache and only stores successful,
+// non-stub results; errors always reach the caller uncached. The optional store is a
+// persistent second tier consulted after the primary cache. Upstream 4xx rejections are kept
+// in the optional errors cache, which a success for the same key overwrites.
+//
+// How long an entry is served, and whether the cache is used at all, is the policy's rule for
+// the call's route and tier; ttl is the TTL without one.
+//
+// With a stale grace, an entry past its TTL is still served for up to grace longer, marked Stale,
+// while a background refresh replaces it; the backends keep entries for ttl plus twice grace so
+// a failed refresh can extend one once. Past that the entry counts as a miss.
+//
+// Concurrent misses for the same key share one call to next. That call runs on a context
+// detached from whichever request started it (bounded by flightTimeout), so a leader that
+// disconnects doesn't fail the followers still waiting on it; each caller stops waiting when
+// its own context is done, and the call is canceled when the last of them does.
+type cachedTranslator struct {
+	next          Translator
+	cache         Cache
+	store         *sqliteCache // nil when CACHE_DB_PATH is unset
+	errors        *errorCache  // nil when NEGATIVE_CACHE_TTL is 0
+	flight        flightGroup
+	flightTimeout time.Duration
+	ttl, grace    time.Duration // grace 0 disables stale serving
+	policy        cachePolicy   // CACHE_POLICY; nil caches everything for ttl
+	stale         staleSet
+	keys          keyFolding
+	sinks         metricSinks
+	clock         Clock
+}
+
+func (c *cachedTranslator) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	rule := c.policy.rule(cacheRouteFrom(ctx), tierFrom(ctx), c.ttl)
+	if rule.Off {
+		return c.next.Translate(ctx, req)
+	}
+	key := c.keys.cacheKey(req)
+	stage := startStage(ctx, "cache")
+	v, ok, err := c.cache.Get(ctx, key)
+	if err != nil {
+		slog.Warn("cache get failed, falling through", "request_id", middleware.GetReqID(ctx), "err", err)
+	}
+	if ok {
+		switch age := c.clock.Now().Sub(v.StoredAt); {
+		case age <= rule.TTL:
+			c.countLookup(ctx, "hit")
+			stage.end(stageHit, "")
+			v.Result.Cached, v.Result.CachedAt = true, v.StoredAt
+			return withMatch(v.Result, req.Q), nil
+		case c.grace > 0 && c.withinGrace(key, v.StoredAt, age, rule.TTL):
+			c.countLookup(ctx, "stale_hit")
+			stage.end(stageStaleHit, "")
+			c.revalidate(ctx, key, req, v.StoredAt)
+			v.Result.Cached, v.Result.CachedAt, v.Result.Stale = true, v.StoredAt, true
+			return withMatch(v.Result, req.Q), nil
+		}
+	}
+	if c.store != nil && req.NBest == 0 {
+		if res, at, ok := c.store.Get(ctx, key); ok {
+			c.countLookup(ctx, "hit")
+			stage.end(stageHit, "store")
+			c.set(ctx, key, res)
+			res.Cached, res.CachedAt = true, at
+			return res, nil
+		}
+	}
+	if c.errors != nil {
+		if ue, ok := c.errors.get(key); ok {
+			c.countLookup(ctx, "error_hit")
+			stage.end("error_hit", "")
+			return translateResult{}, ue
+		}
+	}
+	c.countLookup(ctx, "miss")
+	stage.end(stageMiss, "")
+	// The leader's fill runs on its context, so its stages are the leader's; a follower's
+	// answer came from another request's.
+	r, err := c.flight.do(ctx, key, c.flightTimeout, func(fctx context.Context) (any, error) {
+		return c.fill(fctx, key, req)
+	})
+	if err != nil {
+		return translateResult{}, &upstreamError{Msg: transportErrMsg(err)}
+	}
+	if r.Shared {
+		metricFlightShared.Inc()
+		if r.Err == nil && !provenanceAnswered(ctx) {
+			startStage(ctx, "flight").end(stageShared, "")
+		}
+	}
+	res, _ := r.Val.(translateResult)
+	return withMatch(res, req.Q), r.Err
+}
+
+// withinGrace reports whether an entry stored at storedAt, now age old, may still be served
+// stale: within grace of ttl, or within a second grace once a failed refresh extended it.
+func (c *cachedTranslator) withinGrace(key string, storedAt time.Time, age, ttl time.Duration) bool {
+	if age <= ttl+c.grace {
+		return true
+	}
+	return age <= ttl+2*c.grace && c.stale.extended(key, storedAt)
+}
+
+// revalidate refreshes a stale entry in the background, once per key however many requests it
+// serves meanwhile. The refresh shares the flight group with synchronous misses for the key.
+func (c *cachedTranslator) revalidate(ctx context.Context, key string, req translateReq, storedAt time.Time) {
+	if !c.stale.start(key) {
+		return
+	}
+	ctx = withoutProvenance(context.WithoutCancel(ctx))
+	go func() {
+		defer c.stale.done(key)
+		r, err := c.flight.do(ctx, key, c.flightTimeout, func(fctx context.Context) (any, error) {
+			return c.fill(fctx, key, req)
+		})
+		if err == nil {
+			err = r.Err
+		}
+		if err == nil {
+			return
+		}
+		metricCacheRefreshFailures.Inc()
+		if c.stale.extend(key, storedAt, c.clock.Now().Add(-c.policy.maxTTL(c.ttl)-2*c.grace)) {
+			slog.Warn("stale cache refresh failed, grace extended", "request_id", middleware.GetReqID(ctx), "grace", c.grace.String(), "err", err)
+			return
+		}
+		slog.Warn("stale cache refresh failed", "request_id", middleware.GetReqID(ctx), "err", err)
+	}()
+}
+
+// countLookup records a lookup's outcome (hit, stale_hit, error_hit or miss) in the metrics,
+// the request's span and its slow-request timing.
+func (c *cachedTranslator) countLookup(ctx context.Context, outcome string) {
+	switch outcome {
+	case "hit":
+		metricCacheHits.Inc()
+	case "stale_hit":
+		metricCacheStaleHits.Inc()
+	case "error_hit":
+		metricCacheErrorHits.Inc()
+	default:
+		metricCacheMisses.Inc()
+	}
+	hit := outcome != "miss"
+	setSpanCacheHit(ctx, hit)
+	noteCache(ctx, hit)
+	c.sinks.count("cache.lookups", 1, "outcome", outcome)
+}
+
+// fill calls next and stores a successful result; it runs once per key among concurrent misses.
+// A result that arrives is stored even if every caller has given up on it by then.
+func (c *cachedTranslator) fill(ctx context.Context, key string, req translateReq) (translateResult, error) {
+	res, err := c.next.Translate(ctx, req)
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		var ue *upstreamError
+		if c.errors != nil && errors.As(err, &ue) && ue.clientError() {
+			c.errors.put(key, ue)
+		}
+		return res, err
+	}
+	res.Query = req.Q
+	if res.Src != "stub" {
+		stored := res
+		stored.Attempts, stored.Upstream = 0, "" // a hit costs no upstream calls
+		c.set(ctx, key, stored)
+		if c.store != nil && req.NBest == 0 { // the store has no column for alternatives
+			c.store.Put(key, req, stored)
+		}
+	}
+	return res, nil
+}
+
+// withMatch marks res as a normalized match when it was translated from another spelling of q.
+// Results from a tier that doesn't keep the original (the persistent store) go unmarked.
+func withMatch(res translateResult, q string) translateResult {
+	if res.Query != "" && res.Query != q {
+		res.Match = matchNormalized
+	}
+	return res
+}
+
+// removal counts entries removed from each tier.
+type removal struct {
+	Cache  int
+	Store  int
+	Errors int
+}
+
+// invalidate drops the entry for req from both tiers.
+func (c *cachedTranslator) invalidate(ctx context.Context, req translateReq) (removal, error) {
+	var rm removal
+	key := c.keys.cacheKey(req)
+	c.stale.forget(key)
+	if c.errors != nil && c.errors.forget(key) {
+		rm.Errors = 1
+	}
+	ok, err := c.cache.Delete(ctx, key)
+	if ok {
+		rm.Cache = 1
+	}
+	if err != nil || c.store == nil {
+		return rm, err
+	}
+	ok, err = c.store.Delete(ctx, key)
+	if ok {
+		rm.Store = 1
+	}
+	return rm, err
+}
+
+// flush empties both tiers and the errors cache.
+func (c *cachedTranslator) flush(ctx context.Context) (removal, error) {
+	var (
+		rm  removal
+		err error
+	)
+	rm.Errors = c.forgetErrors()
+	c.stale.clear()
+	if rm.Cache, err = c.cache.Flush(ctx); err != nil || c.store == nil {
+		return rm, err
+	}
+	rm.Store, err = c.store.Flush(ctx)
+	return rm, err
+}
+
+// forgetErrors empties the errors cache, returning how many rejections it held.
+func (c *cachedTranslator) forgetErrors() int {
+	if c.errors == nil {
+		return 0
+	}
+	return c.errors.clear()
+}
+
+func (c *cachedTranslator) set(ctx context.Context, key string, res translateResult) {
+	if c.errors != nil {
+		c.errors.forget(key)
+	}
+	c.stale.forget(key)
+	if err := c.cache.Set(ctx, key, res); err != nil {
+		slog.Warn("cache set failed", "request_id", middleware.GetReqID(ctx), "err", err)
+	}
+}
+
+// staleSet tracks the stale entries being refreshed, and those whose grace a failed refresh has
+// extended, by the stored time of the entry extended. The zero value is ready to use.
+type staleSet struct {
+	mu         sync.Mutex
+	refreshing map[string]bool
+	extensions map[string]time.Time
+	settled    chan struct{} // closed, and cleared, when a refresh finishes, for tests to wait on
+}
+
+// start claims key's refresh, reporting false when one is already running.
+func (s *staleSet) start(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refreshing[key] {
+		return false
+	}
+	if s.refreshing == nil {
+		s.refreshing = map[string]bool{}
+	}
+	s.refreshing[key] = true
+	return true
+}
+
+func (s *staleSet) done(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.refreshing, key)
+	if s.settled != nil {
+		close(s.settled)
+		s.settled = nil
+	}
+}
+
+// extend marks the entry stored at storedAt as extended, reporting false when it already was.
+// Extensions of entries stored before cutoff are past their second grace and are dropped.
+func (s *staleSet) extend(key string, storedAt, cutoff time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at, ok := s.extensions[key]; ok && at.Equal(storedAt) {
+		return false
+	}
+	for k, at := range s.extensions {
+		if at.Before(cutoff) {
+			delete(s.extensions, k)
+		}
+	}
+	if s.extensions == nil {
+		s.extensions = map[string]time.Time{}
+	}
+	s.extensions[key] = storedAt
+	return true
+}
+
+func (s *staleSet) extended(key string, storedAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, ok := s.extensions[key]
+	return ok && at.Equal(storedAt)
+}
+
+// forget drops key's extension once its entry is replaced or removed.
+func (s *staleSet) forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.extensions, key)
+}
+
+func (s *staleSet) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.extensions = nil
+}
@@ -0,0 +1,456 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// heldUpstream holds every translation until release is closed, counting the calls; err, when
+// set, is returned instead of a translation. Each call is announced on entered, when set.
+type heldUpstream struct {
+	calls   atomic.Int64
+	release chan struct{}
+	entered chan struct{}
+	err     error
+}
+
+func (u *heldUpstream) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	u.calls.Add(1)
+	if u.entered != nil {
+		u.entered <- struct{}{}
+	}
+	select {
+	case <-u.release:
+	case <-ctx.Done():
+		return translateResult{}, ctx.Err()
+	}
+	if u.err != nil {
+		return translateResult{}, u.err
+	}
+	return translateResult{Translation: "EN(" + req.Q + ")", Src: "upstream"}, nil
+}
+
+// countingCache counts the results stored in the Cache it wraps.
+type countingCache struct {
+	Cache
+	sets atomic.Int64
+}
+
+func (c *countingCache) Set(ctx context.Context, key string, res translateResult) error {
+	c.sets.Add(1)
+	return c.Cache.Set(ctx, key, res)
+}
+
+func newFlightTranslator(next Translator) (*cachedTranslator, *countingCache) {
+	clk := systemClock{}
+	cache := &countingCache{Cache: newLRUCache(100, 1<<20, 100, time.Hour, clk)}
+	return &cachedTranslator{next: next, cache: cache, flightTimeout: 5 * time.Second, ttl: time.Hour, clock: clk}, cache
+}
+
+// joins returns how many callers wait on key's call and a channel closed when the next one
+// joins. g.mu is held.
+func (g *flightGroup) joins(key string) (int, <-chan struct{}) {
+	if g.joined == nil {
+		g.joined = make(chan struct{})
+	}
+	var n int
+	if fc := g.calls[key]; fc != nil {
+		n = fc.waiters
+	}
+	return n, g.joined
+}
+
+// waitForWaiters returns once n callers wait on key's shared call.
+func waitForWaiters(t *testing.T, g *flightGroup, key string, n int) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		g.mu.Lock()
+		got, joined := g.joins(key)
+		g.mu.Unlock()
+		if got >= n {
+			return
+		}
+		select {
+		case <-joined:
+		case <-timeout:
+			t.Fatalf("%d callers waiting on the shared call, want %d", got, n)
+		}
+	}
+}
+
+func TestConcurrentMissesShareOneCall(t *testing.T) {
+	const clients = 50
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"result", nil},
+		{"error", &upstreamError{Status: 503, Msg: "Service Unavailable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := &heldUpstream{release: make(chan struct{}), err: tt.err}
+			ct, cache := newFlightTranslator(up)
+			// Spellings of one phrase share a key, and so the call.
+			phrases := []string{"good morning", " good  morning", "good morning "}
+			var wg sync.WaitGroup
+			results := make([]translateResult, clients)
+			errs := make([]error, clients)
+			for i := range clients {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results[i], errs[i] = ct.Translate(context.Background(), translateReq{Q: phrases[i%len(phrases)], Src: "en", Dst: "dv"})
+				}()
+			}
+			waitForWaiters(t, &ct.flight, ct.keys.cacheKey(translateReq{Q: "good morning", Src: "en", Dst: "dv"}), clients)
+			close(up.release)
+			wg.Wait()
+			if got := up.calls.Load(); got != 1 {
+				t.Fatalf("%d upstream calls for %d clients, want 1", got, clients)
+			}
+			for i := range clients {
+				if tt.err != nil {
+					var ue *upstreamError
+					if !errors.As(errs[i], &ue) || ue.Status != 503 {
+						t.Fatalf("client %d: err %v, want the shared 503", i, errs[i])
+					}
+					continue
+				}
+				// Every client has the leader's answer, whichever spelling that asked.
+				if errs[i] != nil || results[i].Translation != results[0].Translation {
+					t.Fatalf("client %d: %+v, %v", i, results[i], errs[i])
+				}
+			}
+			wantSets := int64(1)
+			if tt.err != nil {
+				wantSets = 0
+			}
+			if got := cache.sets.Load(); got != wantSets {
+				t.Fatalf("%d cache sets, want %d", got, wantSets)
+			}
+			// Only a result is served from the cache next time; an error is asked again.
+			ct.Translate(context.Background(), translateReq{Q: "good morning", Src: "en", Dst: "dv"})
+			if got, want := up.calls.Load(), 2-wantSets; got != want {
+				t.Fatalf("%d upstream calls after a repeat, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestCanceledLeaderKeepsSharedCall(t *testing.T) {
+	up := &heldUpstream{release: make(chan struct{})}
+	ct, _ := newFlightTranslator(up)
+	req := translateReq{Q: "thank you", Src: "en", Dst: "dv"}
+	key := ct.keys.cacheKey(req)
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := ct.Translate(leaderCtx, req)
+		leader <- err
+	}()
+	waitForWaiters(t, &ct.flight, key, 1)
+	follower := make(chan translateResult, 1)
+	go func() {
+		res, _ := ct.Translate(context.Background(), req)
+		follower <- res
+	}()
+	waitForWaiters(t, &ct.flight, key, 2)
+
+	cancelLeader()
+	if err := <-leader; err == nil {
+		t.Fatal("canceled leader got an answer")
+	}
+	close(up.release)
+	if res := <-follower; res.Translation != "EN(thank you)" {
+		t.Fatalf("follower got %+v after the leader left", res)
+	}
+	if got := up.calls.Load(); got != 1 {
+		t.Fatalf("%d upstream calls, want 1", got)
+	}
+}
+
+// returnsUpstream reports each error its Translator returns on returned.
+type returnsUpstream struct {
+	Translator
+	returned chan error
+}
+
+func (u returnsUpstream) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	res, err := u.Translator.Translate(ctx, req)
+	u.returned <- err
+	return res, err
+}
+
+func TestSharedCallCanceledWithLastCaller(t *testing.T) {
+	up := &heldUpstream{release: make(chan struct{}), entered: make(chan struct{}, 2)}
+	returned := make(chan error, 2)
+	ct, cache := newFlightTranslator(returnsUpstream{up, returned})
+	req := translateReq{Q: "goodbye", Src: "en", Dst: "dv"}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ct.Translate(ctx, req)
+	}()
+	<-up.entered
+	cancel()
+	<-done
+	// With nobody left waiting the call is canceled, so a later miss starts its own.
+	if err := <-returned; !errors.Is(err, context.Canceled) {
+		t.Fatalf("upstream call returned %v after its last caller left, want canceled", err)
+	}
+	close(up.release)
+	if res, err := ct.Translate(context.Background(), req); err != nil || res.Translation != "EN(goodbye)" {
+		t.Fatalf("later miss: %+v, %v", res, err)
+	}
+	if got := up.calls.Load(); got != 2 {
+		t.Fatalf("%d upstream calls, want 2", got)
+	}
+	if got := cache.sets.Load(); got != 1 {
+		t.Fatalf("%d cache sets, want 1", got)
+	}
+}
+
+// steppedUpstream answers each call with the next error sent on answers, a nil one being a
+// translation numbered by the call, so a test decides when and how every call ends.
+type steppedUpstream struct {
+	calls   atomic.Int64
+	answers chan error
+}
+
+func (u *steppedUpstream) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	n := u.calls.Add(1)
+	select {
+	case err := <-u.answers:
+		if err != nil {
+			return translateResult{}, err
+		}
+		return translateResult{Translation: "EN(" + req.Q + ") #" + strconv.FormatInt(n, 10), Src: "upstream"}, nil
+	case <-ctx.Done():
+		return translateResult{}, ctx.Err()
+	}
+}
+
+// waitForRefresh returns once no stale refresh of key is running.
+func waitForRefresh(t *testing.T, s *staleSet, key string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		s.mu.Lock()
+		if !s.refreshing[key] {
+			s.mu.Unlock()
+			return
+		}
+		if s.settled == nil {
+			s.settled = make(chan struct{})
+		}
+		settled := s.settled
+		s.mu.Unlock()
+		select {
+		case <-settled:
+		case <-timeout:
+			t.Fatal("stale refresh still running")
+		}
+	}
+}
+
+// TestStaleWhileRevalidate steps a fake clock through an entry's TTL and grace: it is served
+// stale while a refresh runs, fresh once one succeeds, stale for one more grace after a failed
+// refresh, and fetched synchronously past that.
+func TestStaleWhileRevalidate(t *testing.T) {
+	const ttl, grace = time.Minute, 30 * time.Second
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	up := &steppedUpstream{answers: make(chan error)}
+	ct := &cachedTranslator{next: up, cache: newLRUCache(100, 1<<20, 100, time.Hour, clk), flightTimeout: 5 * time.Second, ttl: ttl, grace: grace, clock: clk}
+	req := translateReq{Q: "good night", Src: "en", Dst: "dv"}
+	key := ct.keys.cacheKey(req)
+	refreshFailed := &upstreamError{Status: 503, Msg: "Service Unavailable"}
+	hits, staleHits, failures := testutil.ToFloat64(metricCacheHits), testutil.ToFloat64(metricCacheStaleHits), testutil.ToFloat64(metricCacheRefreshFailures)
+
+	translate := func(want string, stale bool) {
+		t.Helper()
+		res, err := ct.Translate(context.Background(), req)
+		if err != nil || res.Translation != want || res.Stale != stale {
+			t.Fatalf("%+v, %v; want %q with stale %v", res, err, want, stale)
+		}
+	}
+	// fetch is a miss: the call is answered while the caller waits on it.
+	fetch := func(want string) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			translate(want, false)
+		}()
+		up.answers <- nil
+		<-done
+	}
+	// refreshed answers the stale hit's background refresh with err.
+	refreshed := func(err error) {
+		t.Helper()
+		up.answers <- err
+		waitForRefresh(t, &ct.stale, key)
+	}
+
+	fetch("EN(good night) #1")
+	clk.Advance(ttl / 2)
+	translate("EN(good night) #1", false)
+	clk.Advance(ttl/2 + grace/2)
+	translate("EN(good night) #1", true)
+	refreshed(nil)
+	translate("EN(good night) #2", false) // the refresh replaced the entry
+
+	clk.Advance(ttl + grace/2)
+	translate("EN(good night) #2", true)
+	refreshed(refreshFailed)
+	clk.Advance(grace) // past the grace, within the one the failure extended it by
+	translate("EN(good night) #2", true)
+	refreshed(refreshFailed)
+	clk.Advance(grace) // past the extension: a miss
+	fetch("EN(good night) #5")
+
+	for _, m := range []struct {
+		name        string
+		got, before float64
+		want        float64
+	}{
+		{"fresh hits", testutil.ToFloat64(metricCacheHits), hits, 2},
+		{"stale hits", testutil.ToFloat64(metricCacheStaleHits), staleHits, 3},
+		{"refresh failures", testutil.ToFloat64(metricCacheRefreshFailures), failures, 2},
+	} {
+		if got := m.got - m.before; got != m.want {
+			t.Errorf("%s moved by %v, want %v", m.name, got, m.want)
+		}
+	}
+	if got := up.calls.Load(); got != 5 {
+		t.Fatalf("%d upstream calls, want 5", got)
+	}
+}
+
+// TestLRUCacheByteBound stores results from two words to whole paragraphs and checks, after
+// every store, that the bytes held stay under CACHE_MAX_BYTES and match what is in the list.
+func TestLRUCacheByteBound(t *testing.T) {
+	const maxBytes = 16 << 10
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	c := newLRUCache(1000, maxBytes, 25, time.Hour, clk)
+	rng := rand.New(rand.NewPCG(1, 62))
+	ctx := context.Background()
+	for i := range 2000 {
+		n := 2 + rng.IntN(12) // words
+		if rng.IntN(10) == 0 {
+			n = 200 + rng.IntN(1000) // a paragraph, now and then over the per-entry limit
+		}
+		key := "k" + strconv.Itoa(i%300)
+		res := translateResult{Translation: strings.Repeat("word ", n), Src: "upstream"}
+		if err := c.Set(ctx, key, res); err != nil {
+			t.Fatal(err)
+		}
+		c.mu.Lock()
+		var sum int64
+		for el := c.ll.Front(); el != nil; el = el.Next() {
+			sum += el.Value.(*cacheEntry).size
+		}
+		bytes, entries, keys := c.bytes, c.ll.Len(), len(c.items)
+		c.mu.Unlock()
+		if bytes > maxBytes || bytes != sum || entries != keys {
+			t.Fatalf("after %d stores: %d bytes (entries sum to %d), %d entries and %d keys", i+1, bytes, sum, entries, keys)
+		}
+	}
+	st, _ := c.Stats(ctx)
+	if st.Evictions == 0 || st.EvictedBytes == 0 || st.Oversized == 0 {
+		t.Fatalf("stats %+v: want evictions and oversized results from the skewed sizes", st)
+	}
+	if st.Bytes > maxBytes || st.MaxBytes != maxBytes {
+		t.Fatalf("stats %+v over the %d byte bound", st, maxBytes)
+	}
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	word := translateResult{Translation: "hi"}
+	size := entrySize("a", word)
+	tests := []struct {
+		name     string
+		max      int
+		maxBytes int64
+		percent  int
+		sets     []string // keys stored in order, each holding word; "big:" keys hold bigger
+		keep     []string
+		evicted  uint64
+		oversize uint64
+	}{
+		{"under both bounds", 10, 10 * size, 100, []string{"a", "b", "c"}, []string{"a", "b", "c"}, 0, 0},
+		{"entry count", 2, 10 * size, 100, []string{"a", "b", "c"}, []string{"b", "c"}, 1, 0},
+		{"byte bound", 10, 2 * size, 100, []string{"a", "b", "c"}, []string{"b", "c"}, 1, 0},
+		{"big entry evicts several", 10, 4 * size, 100, []string{"a", "b", "c", "big:d"}, []string{"c", "big:d"}, 2, 0},
+		{"oversized bypasses", 10, 4 * size, 40, []string{"a", "b", "c", "big:d"}, []string{"a", "b", "c"}, 0, 1},
+		{"replacing resizes", 10, 3 * size, 100, []string{"a", "b", "a", "c"}, []string{"a", "b", "c"}, 0, 0},
+		{"oversized drops the old value", 10, 4 * size, 40, []string{"a", "b", "big:a"}, []string{"b"}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLRUCache(tt.max, tt.maxBytes, tt.percent, time.Hour, systemClock{})
+			ctx := context.Background()
+			for _, k := range tt.sets {
+				res := word
+				if name, ok := strings.CutPrefix(k, "big:"); ok {
+					k, res = name, translateResult{Translation: strings.Repeat("x", int(2*size))}
+				}
+				c.Set(ctx, k, res)
+			}
+			for _, k := range tt.keep {
+				k = strings.TrimPrefix(k, "big:")
+				if _, ok, _ := c.Get(ctx, k); !ok {
+					t.Fatalf("%q evicted", k)
+				}
+			}
+			st, _ := c.Stats(ctx)
+			if st.Entries != len(tt.keep) || st.Evictions != tt.evicted || st.Oversized != tt.oversize {
+				t.Fatalf("stats %+v, want %d entries, %d evictions, %d oversized", st, len(tt.keep), tt.evicted, tt.oversize)
+			}
+			if st.Bytes > tt.maxBytes {
+				t.Fatalf("%d bytes over the %d bound", st.Bytes, tt.maxBytes)
+			}
+		})
+	}
+}
+
+// BenchmarkCacheHit serves a cached phrase, from the LRU cache alone and through the
+// cachedTranslator in front of the upstream. A hit hands back the stored result as it is, with
+// no JSON to decode or re-encode; the LRU lookup allocates nothing.
+func BenchmarkCacheHit(b *testing.B) {
+	ctx := context.Background()
+	req := translateReq{Q: "good morning", Src: "en", Dst: "dv"}
+	ct, _ := newFlightTranslator(&echoUpstream{})
+	if _, err := ct.Translate(ctx, req); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("lru", func(b *testing.B) {
+		key := ct.keys.cacheKey(req)
+		b.ReportAllocs()
+		for range b.N {
+			if _, ok, _ := ct.cache.Get(ctx, key); !ok {
+				b.Fatal("miss")
+			}
+		}
+	})
+	b.Run("translator", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if res, err := ct.Translate(ctx, req); err != nil || !res.Cached {
+				b.Fatalf("%+v, %v: want a hit", res, err)
+			}
+		}
+	})
+}
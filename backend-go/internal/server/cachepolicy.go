@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Cache policy. CACHE_POLICY sets, per route and optionally per tier, how long a result may be
+// served from a cache or that it isn't cached at all:
+//
+//	CACHE_POLICY=translate.pro=1h,batch=6h,transliterate=off
+//
+// A route alone covers both tiers; route.tier overrides it for one. Values are durations or
+// "off". Translate and batch items read and fill the same translation cache, so an entry
+// another route stored is served while it is younger than the reading route's TTL. Anything
+// left unset keeps CACHE_TTL, and transliterations, which only change at restart, keep theirs
+// until evicted (a TTL of 0). The policy in effect, every combination filled in, is the
+// CACHE_POLICY value at /go/admin/config.
+
+// Routes a cache policy applies to.
+const (
+	cacheRouteTranslate     = "translate"     // /go/translate, its stream and gRPC forms, cache warming
+	cacheRouteBatch         = "batch"         // batch, bulk and job items
+	cacheRouteTransliterate = "transliterate" // /go/transliterate
+)
+
+var (
+	cacheRoutes = [...]string{cacheRouteTranslate, cacheRouteBatch, cacheRouteTransliterate}
+	cacheTiers  = [...]string{tierFree, tierPro}
+)
+
+// cacheRule is the policy for one route and tier.
+type cacheRule struct {
+	Off bool          `json:"off,omitempty"`
+	TTL time.Duration `json:"ttl"` // 0 keeps entries until evicted; transliterations only
+}
+
+func (r cacheRule) String() string {
+	if r.Off {
+		return "off"
+	}
+	return r.TTL.String()
+}
+
+// cachePolicy maps "route.tier" to its rule, for every route and tier. A nil policy caches
+// everything for the caller's default TTL.
+type cachePolicy map[string]cacheRule
+
+// parseCachePolicy reads CACHE_POLICY over the defaults: translations for ttl, transliterations
+// until evicted.
+func parseCachePolicy(raw string, ttl time.Duration) (cachePolicy, error) {
+	p := cachePolicy{}
+	for _, route := range cacheRoutes {
+		def := cacheRule{TTL: ttl}
+		if route == cacheRouteTransliterate {
+			def.TTL = 0
+		}
+		for _, tier := range cacheTiers {
+			p[route+"."+tier] = def
+		}
+	}
+	var perTier []string // applied after the route-wide entries, whatever the order given
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(part, "=")
+		name, val = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(val)
+		if !ok || val == "" {
+			return nil, fmt.Errorf("%s: want route[.tier]=duration|off", part)
+		}
+		route, tier, _ := strings.Cut(name, ".")
+		if !slices.Contains(cacheRoutes[:], route) {
+			return nil, fmt.Errorf("unknown route %q (valid: %s)", route, strings.Join(cacheRoutes[:], ", "))
+		}
+		if tier != "" && tier != tierFree && tier != tierPro {
+			return nil, fmt.Errorf("%s: unknown tier %q (valid: free, pro)", part, tier)
+		}
+		var rule cacheRule
+		if strings.EqualFold(val, "off") {
+			rule.Off = true
+		} else {
+			d, err := time.ParseDuration(val)
+			switch {
+			case err != nil:
+				return nil, fmt.Errorf("%s: %q is not a duration or off", part, val)
+			case d < 0 || d == 0 && route != cacheRouteTransliterate:
+				return nil, fmt.Errorf("%s: must be positive; use off to disable caching", part)
+			}
+			rule.TTL = d
+		}
+		if tier != "" {
+			perTier = append(perTier, name)
+			p[name] = rule
+			continue
+		}
+		for _, t := range cacheTiers {
+			if !slices.Contains(perTier, route+"."+t) {
+				p[route+"."+t] = rule
+			}
+		}
+	}
+	return p, nil
+}
+
+// rule is the policy for route and tier, or def for every cacheable route without a policy.
+func (p cachePolicy) rule(route, tier string, def time.Duration) cacheRule {
+	if r, ok := p[route+"."+tier]; ok {
+		return r
+	}
+	if p == nil {
+		return cacheRule{TTL: def}
+	}
+	return p[route+"."+tierFree]
+}
+
+// maxTTL is the longest a translation entry must be kept for any route to be served it, def
+// without a policy.
+func (p cachePolicy) maxTTL(def time.Duration) time.Duration {
+	if p == nil {
+		return def
+	}
+	longest := def
+	for name, r := range p {
+		if !r.Off && !strings.HasPrefix(name, cacheRouteTransliterate+".") {
+			longest = max(longest, r.TTL)
+		}
+	}
+	return longest
+}
+
+// String lists every rule in route and tier order, as /go/admin/config shows the policy.
+func (p cachePolicy) String() string {
+	var parts []string
+	for _, route := range cacheRoutes {
+		for _, tier := range cacheTiers {
+			name := route + "." + tier
+			if r, ok := p[name]; ok {
+				parts = append(parts, name+"="+r.String())
+			}
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+type cacheRouteKey struct{}
+
+// withCacheRoute marks the translations made under ctx as coming from route. Unmarked ones are
+// cacheRouteTranslate.
+func withCacheRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, cacheRouteKey{}, route)
+}
+
+func cacheRouteFrom(ctx context.Context) string {
+	if r, ok := ctx.Value(cacheRouteKey{}).(string); ok {
+		return r
+	}
+	return cacheRouteTranslate
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCachePolicy(t *testing.T) {
+	const day = "24h0m0s"
+	tests := []struct {
+		name string
+		raw  string
+		want string // the policy's String, or "" for an error
+	}{
+		{"defaults", "", "translate.free=" + day + ",translate.pro=" + day + ",batch.free=" + day + ",batch.pro=" + day + ",transliterate.free=0s,transliterate.pro=0s"},
+		{"route for both tiers", "batch=6h", "translate.free=" + day + ",translate.pro=" + day + ",batch.free=6h0m0s,batch.pro=6h0m0s,transliterate.free=0s,transliterate.pro=0s"},
+		{"tier only", "translate.pro=1h", "translate.free=" + day + ",translate.pro=1h0m0s,batch.free=" + day + ",batch.pro=" + day + ",transliterate.free=0s,transliterate.pro=0s"},
+		{"tier wins in any order", "translate.pro=1h, translate=off", "translate.free=off,translate.pro=1h0m0s,batch.free=" + day + ",batch.pro=" + day + ",transliterate.free=0s,transliterate.pro=0s"},
+		{"case and spaces", " Transliterate.FREE = OFF ", "translate.free=" + day + ",translate.pro=" + day + ",batch.free=" + day + ",batch.pro=" + day + ",transliterate.free=off,transliterate.pro=0s"},
+		{"transliterate kept until evicted", "transliterate=0s", "translate.free=" + day + ",translate.pro=" + day + ",batch.free=" + day + ",batch.pro=" + day + ",transliterate.free=0s,transliterate.pro=0s"},
+		{"zero translate ttl", "translate=0s", ""},
+		{"negative", "batch=-1h", ""},
+		{"unknown route", "stream=1h", ""},
+		{"unknown tier", "translate.gold=1h", ""},
+		{"not a duration", "translate=soon", ""},
+		{"no value", "translate", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parseCachePolicy(tt.raw, 24*time.Hour)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("parsed as %s, want an error", p)
+				}
+				return
+			}
+			if err != nil || p.String() != tt.want {
+				t.Fatalf("%s, %v; want %s", p, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachePolicyRule(t *testing.T) {
+	p, err := parseCachePolicy("translate.pro=1h,batch=off,transliterate.pro=10m", time.Hour*24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var none cachePolicy
+	tests := []struct {
+		name   string
+		p      cachePolicy
+		route  string
+		tier   string
+		rule   cacheRule
+		maxTTL time.Duration
+	}{
+		{"pro", p, cacheRouteTranslate, tierPro, cacheRule{TTL: time.Hour}, 24 * time.Hour},
+		{"free keeps the default", p, cacheRouteTranslate, tierFree, cacheRule{TTL: 24 * time.Hour}, 24 * time.Hour},
+		{"off", p, cacheRouteBatch, tierPro, cacheRule{Off: true}, 24 * time.Hour},
+		{"unknown tier as free", p, cacheRouteTransliterate, "", cacheRule{}, 24 * time.Hour},
+		{"no policy", none, cacheRouteBatch, tierPro, cacheRule{TTL: 5 * time.Minute}, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.rule(tt.route, tt.tier, 5*time.Minute); got != tt.rule {
+				t.Fatalf("rule %+v, want %+v", got, tt.rule)
+			}
+			if got := tt.p.maxTTL(5 * time.Minute); got != tt.maxTTL {
+				t.Fatalf("maxTTL %v, want %v", got, tt.maxTTL)
+			}
+		})
+	}
+}
+
+// TestCachePolicyOnRoutes runs requests through a policy that caches pro translations for an
+// hour, free ones not at all and batch items for ten minutes, counting what reaches the upstream.
+func TestCachePolicyOnRoutes(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	up := &echoUpstream{}
+	h := newTestServer(t, map[string]string{
+		"CACHE_POLICY":       "translate.pro=1h,translate.free=off,batch=10m",
+		"RATE_LIMIT_PER_MIN": "1000",
+	}, Deps{Upstream: up, Clock: clk}).Handler()
+	translate := func(key, q string) *httptest.ResponseRecorder {
+		return serve(h, "GET", "/go/translate?q="+q, "", "X-API-Key", key)
+	}
+	batch := func(key, q string) *httptest.ResponseRecorder {
+		return serve(h, "POST", "/go/translate/batch", `{"items":[{"id":"1","q":"`+q+`"}]}`, "X-API-Key", key, "Content-Type", "application/json")
+	}
+	steps := []struct {
+		name    string
+		send    func() *httptest.ResponseRecorder
+		advance time.Duration // before sending
+		calls   int64         // upstream calls so far
+	}{
+		{"pro miss", func() *httptest.ResponseRecorder { return translate(testProKey, "one") }, 0, 1},
+		{"pro hit", func() *httptest.ResponseRecorder { return translate(testProKey, "one") }, 30 * time.Minute, 1},
+		{"too old for batch", func() *httptest.ResponseRecorder { return batch(testProKey, "one") }, 0, 2},
+		{"batch hit on its own entry", func() *httptest.ResponseRecorder { return batch(testProKey, "one") }, 0, 2},
+		{"pro reads batch's entry", func() *httptest.ResponseRecorder { return translate(testProKey, "one") }, 5 * time.Minute, 2},
+		{"batch expired", func() *httptest.ResponseRecorder { return batch(testProKey, "one") }, 6 * time.Minute, 3},
+		{"free never served from cache", func() *httptest.ResponseRecorder { return translate(testFreeKey, "one") }, 0, 4},
+		{"free still not", func() *httptest.ResponseRecorder { return translate(testFreeKey, "one") }, 0, 5},
+		{"pro expired", func() *httptest.ResponseRecorder { return translate(testProKey, "one") }, 61 * time.Minute, 6},
+	}
+	for _, st := range steps {
+		clk.Advance(st.advance)
+		if w := st.send(); w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", st.name, w.Code, w.Body.String())
+		}
+		if got := up.calls.Load(); got != st.calls {
+			t.Fatalf("%s: %d upstream calls, want %d", st.name, got, st.calls)
+		}
+	}
+	w := serve(h, "GET", "/go/admin/config", "", "Authorization", "Bearer "+testAdmin)
+	if want := "translate.free=off,translate.pro=1h0m0s,batch.free=10m0s,batch.pro=10m0s"; !strings.Contains(w.Body.String(), want) {
+		t.Fatalf("/go/admin/config without %s: %s", want, w.Body.String())
+	}
+}
+
+// TestClientGlossariesDontShareCache gives two keys different glossaries for the same term. The
+// upstream sees the same masked text from both, and neither may be served the other's result.
+func TestClientGlossariesDontShareCache(t *testing.T) {
+	up := &echoUpstream{}
+	h := newTestServer(t, map[string]string{
+		"GLOSSARY_DB_PATH": filepath.Join(t.TempDir(), "glossary.db"),
+		"API_KEYS":         "other:fk2",
+	}, Deps{Upstream: up}).Handler()
+	put := func(key, terms string) {
+		t.Helper()
+		if w := serve(h, "PUT", "/go/keys/self/glossary", terms, "X-API-Key", key, "Content-Type", "application/json"); w.Code != http.StatusOK {
+			t.Fatalf("glossary for %s: status %d: %s", key, w.Code, w.Body.String())
+		}
+	}
+	put(testFreeKey, `{"Malé": "Male"}`)
+	put("fk2", `{"Malé": "the capital"}`)
+	steps := []struct {
+		name        string
+		key         string
+		translation string
+		cached      bool
+		calls       int64
+	}{
+		{"first glossary", testFreeKey, "EN(to Male)", false, 1},
+		{"second glossary", "fk2", "EN(to the capital)", false, 2},
+		{"no glossary", testProKey, "EN(to Malé)", false, 3},
+		{"first again", testFreeKey, "EN(to Male)", true, 3},
+		{"second again", "fk2", "EN(to the capital)", true, 3},
+		{"no glossary again", testProKey, "EN(to Malé)", true, 3},
+	}
+	check := func(name, key, translation string, cached bool, calls int64) {
+		t.Helper()
+		w := serve(h, "GET", "/go/translate?q=to+Mal%C3%A9", "", "X-API-Key", key)
+		var res struct {
+			Translation string `json:"translation"`
+			Cached      bool   `json:"cached"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", name, w.Code, w.Body.String())
+		}
+		if res.Translation != translation || res.Cached != cached {
+			t.Fatalf("%s: %q (cached %v), want %q (cached %v)", name, res.Translation, res.Cached, translation, cached)
+		}
+		if got := up.calls.Load(); got != calls {
+			t.Fatalf("%s: %d upstream calls, want %d", name, got, calls)
+		}
+	}
+	for _, st := range steps {
+		check(st.name, st.key, st.translation, st.cached, st.calls)
+	}
+	put("fk2", `{"Malé": "Malé City"}`)
+	check("changed glossary", "fk2", "EN(to Malé City)", false, 4)
+	check("untouched glossary", testFreeKey, "EN(to Male)", true, 4)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// seedEntry is one line of CACHE_SEED_PATH.
+type seedEntry struct {
+	Q           string     `json:"q"`
+	Src         string     `json:"src"`
+	Dst         string     `json:"dst"`
+	Translation string     `json:"translation"`
+	Extended    bool       `json:"extended,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+}
+
+// seedResult is what a seed load or snapshot did.
+type seedResult struct {
+	Seeded   int // entries stored
+	Skipped  int // malformed, unsupported or expired lines, and lines past the cache capacity
+	Duration time.Duration
+}
+
+// seedCache loads path into cache. Lines are normalized the way translateService normalizes a
+// request and keyed by keys, so they land on the keys real requests look up. At most max entries
+// are stored, the last ones in the file, since a snapshot lists entries least recently used first;
+// lines older than ttl by their created_at, as of clk, are dropped rather than given a fresh TTL. A
+// missing file is reported as os.ErrNotExist, which callers treat as nothing to seed yet.
+func seedCache(ctx context.Context, cache Cache, keys keyFolding, path string, max int, ttl time.Duration, def langPair, clk Clock) (seedResult, error) {
+	start := time.Now()
+	var res seedResult
+	f, err := os.Open(path)
+	if err != nil {
+		return res, fmt.Errorf("cache seed: %w", err)
+	}
+	defer f.Close()
+
+	type seed struct {
+		key string
+		res translateResult
+	}
+	ring := make([]seed, 0, min(max, 1024))
+	next, lines := 0, 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		lines++
+		var e seedEntry
+		if json.Unmarshal(sc.Bytes(), &e) != nil || e.Translation == "" {
+			continue
+		}
+		if e.CreatedAt != nil && clk.Now().Sub(*e.CreatedAt) > ttl {
+			continue
+		}
+		q, ok := normalizeText(e.Q)
+		if !ok || q == "" {
+			continue
+		}
+		pair, err := resolvePair(e.Src, e.Dst, def)
+		if err != nil {
+			continue
+		}
+		s := seed{
+			key: keys.cacheKey(translateReq{Q: q, Src: pair.Src, Dst: pair.Dst, Extended: e.Extended}),
+			res: translateResult{Translation: e.Translation, Src: "upstream"},
+		}
+		if len(ring) < max {
+			ring = append(ring, s)
+			continue
+		}
+		ring[next] = s
+		next = (next + 1) % max
+	}
+	if err := sc.Err(); err != nil {
+		return res, fmt.Errorf("cache seed %s: %w", path, err)
+	}
+	for i := range ring {
+		s := ring[(next+i)%len(ring)]
+		if err := cache.Set(ctx, s.key, s.res); err != nil {
+			return res, fmt.Errorf("cache seed: %w", err)
+		}
+		res.Seeded++
+	}
+	res.Skipped = lines - res.Seeded
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// writeCacheSeed writes src's entries to path in the seed format, least recently used first,
+// via rename so a deploy never starts from a half-written file. Entries kept apart for a client
+// glossary are left out.
+func writeCacheSeed(ctx context.Context, src cacheExporter, path string) (seedResult, error) {
+	start := time.Now()
+	var res seedResult
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return res, fmt.Errorf("cache snapshot: %w", err)
+	}
+	defer os.Remove(tmp) // a no-op once renamed
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	err = src.Export(ctx, time.Time{}, func(row cacheRow) error {
+		if row.Glossary {
+			return nil // a seed has no caller to scope it to, and would serve it to everyone
+		}
+		created := row.CreatedAt.UTC()
+		res.Seeded++
+		return enc.Encode(seedEntry{Q: row.Source, Src: row.Src, Dst: row.Dst, Translation: row.Translation, Extended: row.Extended, CreatedAt: &created})
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		return seedResult{}, fmt.Errorf("cache snapshot: %w", err)
+	}
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// cacheSnapshotHandler serves GET /go/admin/cache/snapshot, writing the cache to
+// CACHE_SEED_PATH for the next start to load. The in-memory cache is preferred, as it holds
+// what is hot right now; with Redis the persistent store stands in.
+func cacheSnapshotHandler(ct *cachedTranslator, path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if path == "" {
+			writeError(w, http.StatusNotImplemented, codeNotImplemented, "set CACHE_SEED_PATH to snapshot the cache")
+			return
+		}
+		var src cacheExporter
+		if e, ok := ct.cache.(cacheExporter); ok {
+			src = e
+		} else if ct.store != nil {
+			src = ct.store
+		} else {
+			writeError(w, http.StatusNotImplemented, codeNotImplemented, "this cache backend can't be exported; set CACHE_DB_PATH")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), exportMaxDuration)
+		defer cancel()
+		res, err := writeCacheSeed(ctx, src, path)
+		attrs := []any{"request_id", middleware.GetReqID(r.Context()), "admin", adminFrom(r.Context()), "path", path}
+		if err != nil {
+			slog.Error("cache snapshot failed", append(attrs, "err", err)...)
+			writeError(w, http.StatusInternalServerError, codeInternal, "cache snapshot failed", "detail", err.Error())
+			return
+		}
+		auditParam(r.Context(), "entries", res.Seeded)
+		slog.Info("cache snapshot written", append(attrs, "entries", res.Seeded, "duration", res.Duration.String())...)
+		j(w, http.StatusOK, map[string]any{
+			"path":        path,
+			"entries":     res.Seeded,
+			"duration_ms": float64(res.Duration.Microseconds()) / 1000,
+		})
+	}
+}
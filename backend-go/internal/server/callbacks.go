@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Job callbacks. A job submitted with callback_url gets a signed JSON summary POSTed there once
+// it is done, failed or canceled. Deliveries are kept in the job DB, so a restart doesn't drop
+// one, and are retried with backoff; every attempt shows up in GET /go/jobs/{id}.
+
+// Headers on a callback. The signature is SignWebhook under the key's webhook_secret.
+const (
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookDeliveryHeader  = "X-Webhook-Delivery" // <job id>.<attempt>
+)
+
+// Callback delivery states on a job.
+const (
+	callbackPending   = "pending"
+	callbackSending   = "sending"
+	callbackDelivered = "delivered"
+	callbackFailed    = "failed"
+)
+
+// callbackOpts controls delivery.
+type callbackOpts struct {
+	MaxAttempts  int
+	RetryBase    time.Duration // waits double from here, up to an hour
+	Timeout      time.Duration // per attempt
+	Workers      int
+	AllowPrivate bool                              // deliver to private and loopback addresses; never in production
+	Secrets      func(keyID string) (string, bool) // the key file's webhook_secret
+}
+
+// SignWebhook returns the hex HMAC-SHA256 sent in X-Webhook-Signature: over the unix-seconds
+// timestamp from X-Webhook-Timestamp and the body, joined by a newline.
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(timestamp + "\n"))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// checkCallbackURL accepts absolute https URLs without credentials, on a name or a public IP.
+// Names are checked, as addresses, when each delivery dials.
+func checkCallbackURL(raw string, allowPrivate bool) error {
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		return errors.New("not a valid URL")
+	case u.Scheme != "https":
+		return errors.New("must be https")
+	case u.Hostname() == "":
+		return errors.New("missing host")
+	case u.User != nil:
+		return errors.New("must not carry credentials")
+	}
+	return callbackPolicy(allowPrivate).checkURL(u)
+}
+
+// newCallbackClient is an HTTP client that refuses to connect to a blocked address, whatever a
+// name resolves to when dialed (see egress.go), ignores proxy settings that would hide the
+// destination, and doesn't follow redirects.
+func newCallbackClient(opts callbackOpts) *http.Client {
+	d := &net.Dialer{Timeout: opts.Timeout}
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         callbackPolicy(opts.AllowPrivate).dialContext(d),
+			TLSHandshakeTimeout: opts.Timeout,
+			MaxIdleConns:        16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// delivery is one recorded callback attempt.
+type delivery struct {
+	Attempt    int       `json:"attempt"`
+	At         time.Time `json:"at"`
+	Status     int       `json:"status,omitempty"` // HTTP status; 0 when no response came back
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// claimCallback marks the job whose callback is due soonest as sending and returns it.
+func (s *jobStore) claimCallback(ctx context.Context) (job, bool, error) {
+	jb, err := s.scan(s.db.QueryRowContext(ctx, `UPDATE jobs SET callback_state = ?
+		WHERE id = (SELECT id FROM jobs WHERE callback_state = ? AND callback_next_at <= ? ORDER BY callback_next_at, id LIMIT 1)
+		RETURNING `+jobColumns, callbackSending, callbackPending, s.clock.Now().Unix()))
+	if errors.Is(err, errJobNotFound) {
+		return jb, false, nil
+	}
+	return jb, err == nil, err
+}
+
+// recordDelivery stores attempt d and moves the callback to state, due again at next.
+func (s *jobStore) recordDelivery(ctx context.Context, id string, d delivery, state string, next time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO job_deliveries (job_id, attempt, at, status, error, duration_ms) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, d.Attempt, d.At.Unix(), d.Status, d.Error, d.DurationMS); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE jobs SET callback_state = ?, callback_next_at = ? WHERE id = ?`, state, next.Unix(), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deliveries returns job id's callback attempts, oldest first.
+func (s *jobStore) deliveries(ctx context.Context, id string) ([]delivery, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT attempt, at, status, error, duration_ms FROM job_deliveries WHERE job_id = ? ORDER BY attempt`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []delivery
+	for rows.Next() {
+		var (
+			d  delivery
+			at int64
+		)
+		if err := rows.Scan(&d.Attempt, &at, &d.Status, &d.Error, &d.DurationMS); err != nil {
+			return nil, err
+		}
+		d.At = time.Unix(at, 0).UTC()
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// callbackPoll is how often the deliverers look for due callbacks.
+const callbackPoll = time.Second
+
+// deliverCallbacks sends due callbacks on up to opts.Workers connections until ctx is done.
+func (jr *jobRunner) deliverCallbacks(ctx context.Context) {
+	opts := jr.opts.Callbacks
+	client := newCallbackClient(opts)
+	var wg sync.WaitGroup
+	for range max(opts.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t := jr.clock.NewTicker(callbackPoll)
+			defer t.Stop()
+			for ctx.Err() == nil {
+				jb, ok, err := jr.store.claimCallback(ctx)
+				if err != nil && ctx.Err() == nil {
+					slog.Warn("job callback claim failed", "err", err)
+				}
+				if ok {
+					jr.deliver(ctx, client, jb)
+					continue
+				}
+				select {
+				case <-ctx.Done():
+				case <-t.C():
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver makes one attempt at jb's callback and schedules the next, if any. A 2xx is
+// delivered; anything else is retried until MaxAttempts.
+func (jr *jobRunner) deliver(ctx context.Context, client *http.Client, jb job) {
+	opts := jr.opts.Callbacks
+	past, err := jr.store.deliveries(ctx, jb.ID)
+	if err != nil {
+		slog.Warn("job callback history read failed", "job", jb.ID, "err", err)
+		return // stays sending until the next restart requeues it
+	}
+	start := time.Now()
+	d := delivery{Attempt: len(past) + 1, At: jr.clock.Now().UTC()}
+	secret, ok := opts.Secrets(jb.Owner)
+	if !ok {
+		d.Error = "api key has no webhook_secret"
+	} else {
+		d.Status, d.Error = jr.post(ctx, client, jb, d.Attempt, []byte(secret))
+	}
+	d.DurationMS = time.Since(start).Milliseconds()
+
+	state, next, outcome := callbackDelivered, time.Time{}, "delivered"
+	switch {
+	case d.Error == "" && d.Status/100 == 2:
+	case d.Attempt >= opts.MaxAttempts || !ok:
+		state, outcome = callbackFailed, "failed"
+	default:
+		state, outcome = callbackPending, "retry"
+		next = jr.clock.Now().Add(min(opts.RetryBase<<(d.Attempt-1), time.Hour))
+	}
+	metricJobCallbacks.WithLabelValues(outcome).Inc()
+	if err := jr.store.recordDelivery(context.WithoutCancel(ctx), jb.ID, d, state, next); err != nil {
+		slog.Warn("job callback record failed", "job", jb.ID, "err", err)
+	}
+	if state == callbackFailed {
+		slog.Warn("job callback given up", "job", jb.ID, "attempts", d.Attempt, "status", d.Status, "err", d.Error)
+	}
+}
+
+// post sends jb's summary, returning the response status or why there was none.
+func (jr *jobRunner) post(ctx context.Context, client *http.Client, jb job, attempt int, secret []byte) (int, string) {
+	done, failed, err := jr.store.progress(ctx, jb.ID)
+	if err != nil {
+		return 0, "job store unavailable"
+	}
+	summary := map[string]any{
+		"job_id":      jb.ID,
+		"status":      jb.Status,
+		"progress":    map[string]int{"total": len(jb.Req.Items), "done": done, "failed": failed},
+		"results_url": "/go/jobs/" + jb.ID + "/results",
+		"finished_at": jb.Updated.UTC().Format(time.RFC3339),
+	}
+	if jb.Error != "" {
+		summary["error"] = jb.Error
+	}
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return 0, err.Error()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, jb.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "invalid callback_url"
+	}
+	ts := strconv.FormatInt(jr.clock.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dhkalign-jobs")
+	req.Header.Set(webhookTimestampHeader, ts)
+	req.Header.Set(webhookSignatureHeader, SignWebhook(secret, ts, body))
+	req.Header.Set(webhookDeliveryHeader, fmt.Sprintf("%s.%d", jb.ID, attempt))
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, errEgressDenied) {
+			return 0, errEgressDenied.Error()
+		}
+		return 0, transportErrMsg(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, http.StatusText(resp.StatusCode)
+	}
+	return resp.StatusCode, ""
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Canonical JSON, for the edge worker to cache and deduplicate origin responses by hash. With
+// CANONICAL_JSON=true the /go/translate* responses are re-encoded the RFC 8785 (JCS) way: object
+// keys sorted by UTF-16 code unit, no whitespace, numbers as ECMAScript prints them and strings
+// escaped only where JSON requires. Two responses with the same content are then byte-identical,
+// whatever struct or map built them. Response signing requires it, so a signature can be
+// recomputed from the content alone.
+
+// canonicalJSON re-encodes the JSON, NDJSON and event-stream responses of the /go/translate
+// routes. JSON bodies are held until the handler returns; NDJSON lines and event data lines are
+// encoded as they pass, so the bulk and stream routes still stream.
+func canonicalJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := apiPath(r.URL.Path); p != "/go/translate" && !strings.HasPrefix(p, "/go/translate/") || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &canonicalWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+// canonicalWriter decides at the status what the body is: held JSON, lines, or neither.
+type canonicalWriter struct {
+	http.ResponseWriter
+	code  int
+	mode  int // canonicalPass, canonicalHold or canonicalLines, from the Content-Type
+	buf   bytes.Buffer
+	wrote bool
+}
+
+const (
+	canonicalPass = iota
+	canonicalHold
+	canonicalLines
+)
+
+func (w *canonicalWriter) WriteHeader(code int) {
+	if w.wrote || w.code != 0 {
+		return
+	}
+	w.code = code
+	mt, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	switch mt {
+	case "application/json":
+		w.mode = canonicalHold
+		return
+	case "application/x-ndjson", "text/event-stream":
+		w.mode = canonicalLines
+		w.Header().Del("Content-Length")
+	}
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *canonicalWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch w.mode {
+	case canonicalHold:
+		return w.buf.Write(b)
+	case canonicalLines:
+		w.buf.Write(b)
+		var out []byte
+		for {
+			i := bytes.IndexByte(w.buf.Bytes(), '\n')
+			if i < 0 {
+				break
+			}
+			out = append(out, canonicalLine(w.buf.Next(i+1))...)
+		}
+		if _, err := w.ResponseWriter.Write(out); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// finish writes a held body, or the last line a line body didn't end.
+func (w *canonicalWriter) finish() {
+	switch w.mode {
+	case canonicalHold:
+		body := w.buf.Bytes()
+		if c, err := canonicalize(body); err == nil {
+			body = c
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.ResponseWriter.WriteHeader(w.code)
+		w.ResponseWriter.Write(body)
+	case canonicalLines:
+		if w.buf.Len() > 0 {
+			w.ResponseWriter.Write(canonicalLine(w.buf.Bytes()))
+		}
+	}
+}
+
+func (w *canonicalWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// canonicalLine re-encodes an NDJSON line, or the JSON of an event's data line. Anything else,
+// and anything that doesn't parse, passes as it is.
+func canonicalLine(line []byte) []byte {
+	body, nl := bytes.CutSuffix(line, []byte("\n"))
+	var prefix []byte
+	if rest, ok := bytes.CutPrefix(body, []byte("data: ")); ok {
+		prefix, body = []byte("data: "), rest
+	}
+	if len(body) == 0 || body[0] != '{' && body[0] != '[' {
+		return line
+	}
+	c, err := canonicalize(body)
+	if err != nil {
+		return line
+	}
+	out := append(prefix, c...)
+	if nl {
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// canonicalize re-encodes the JSON document b canonically.
+func canonicalize(b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, fmt.Errorf("trailing data after the JSON value")
+	}
+	var out bytes.Buffer
+	if err := writeCanonical(&out, v); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func writeCanonical(b *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case string:
+		writeCanonicalString(b, v)
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil || math.IsInf(f, 0) {
+			return fmt.Errorf("number %s has no canonical form", v)
+		}
+		b.WriteString(canonicalNumber(f))
+	case []any:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeCanonical(b, e); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.SortFunc(keys, func(a, c string) int { return slices.Compare(utf16.Encode([]rune(a)), utf16.Encode([]rune(c))) })
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeCanonicalString(b, k)
+			b.WriteByte(':')
+			if err := writeCanonical(b, v[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected %T in decoded JSON", v)
+	}
+	return nil
+}
+
+// writeCanonicalString escapes only the quote, the backslash and control characters, using the
+// short escapes where JSON has them.
+func writeCanonicalString(b *bytes.Buffer, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(b, `\u%04x`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+}
+
+// canonicalNumber prints f as ECMAScript's Number.prototype.toString does: the shortest digits
+// that round-trip, without an exponent from 1e-6 up to 1e21.
+func canonicalNumber(f float64) string {
+	if f == 0 {
+		return "0"
+	}
+	sign := ""
+	if f < 0 {
+		sign, f = "-", -f
+	}
+	// d.ddde±x: the digits, and n, the decimal exponent of the point after them.
+	e := strconv.FormatFloat(f, 'e', -1, 64)
+	mant, exp, _ := strings.Cut(e, "e")
+	digits := strings.Replace(mant, ".", "", 1)
+	x, _ := strconv.Atoi(exp)
+	k, n := len(digits), x+1
+	switch {
+	case k <= n && n <= 21:
+		return sign + digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		return sign + digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		return sign + "0." + strings.Repeat("0", -n) + digits
+	}
+	out := digits[:1]
+	if k > 1 {
+		out += "." + digits[1:]
+	}
+	if n-1 >= 0 {
+		return sign + out + "e+" + strconv.Itoa(n-1)
+	}
+	return sign + out + "e" + strconv.Itoa(n-1)
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalNumber(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want string
+	}{
+		{0, "0"},
+		{math.Copysign(0, -1), "0"},
+		{1, "1"},
+		{-2.5, "-2.5"},
+		{123.456, "123.456"},
+		{0.30000000000000004, "0.30000000000000004"},
+		{1e20, "100000000000000000000"},
+		{1e21, "1e+21"},
+		{123e19, "1.23e+21"},
+		{0.000001, "0.000001"},
+		{0.0000012, "0.0000012"},
+		{1e-7, "1e-7"},
+		{-1.5e-7, "-1.5e-7"},
+		{5e-324, "5e-324"},
+		{math.MaxFloat64, "1.7976931348623157e+308"},
+		{9007199254740993, "9007199254740992"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := canonicalNumber(tt.f); got != tt.want {
+				t.Fatalf("canonicalNumber(%v) = %s, want %s", tt.f, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // "" is an error
+	}{
+		{"keys sorted, whitespace dropped", `{ "b": 1, "a": [ true, null ] }`, `{"a":[true,null],"b":1}`},
+		{"nested objects", `{"z":{"y":1,"x":{"w":2,"v":3}},"a":0}`, `{"a":0,"z":{"x":{"v":3,"w":2},"y":1}}`},
+		{"keys by utf-16 code unit", "{\"\ue000\":1,\"\U0001f600\":2,\"a\":3}", "{\"a\":3,\"\U0001f600\":2,\"\ue000\":1}"},
+		{"numbers reformatted", `[1.0, 1E3, -0, 0.50, 1e-7, 10000000000000000000000]`, `[1,1000,0,0.5,1e-7,1e+22]`},
+		{"escapes only where json needs them", `"<>& \u00e9 \u2028 \/"`, "\"<>& \u00e9 \u2028 /\""},
+		{"short escapes", `"\"\\\b\f\n\r\t\u0001\u001f"`, `"\"\\\b\f\n\r\t\u0001\u001f"`},
+		{"thaana kept", `{"translation":"ރަށް"}`, "{\"translation\":\"ރަށް\"}"},
+		{"out of range", `1e400`, ""},
+		{"trailing data", `{} {}`, ""},
+		{"malformed", `{"a":`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := canonicalize([]byte(tt.in))
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("canonicalized to %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+			if again, _ := canonicalize(got); !bytes.Equal(again, got) {
+				t.Fatalf("not a fixed point: %s then %s", got, again)
+			}
+		})
+	}
+}
+
+// TestCanonicalDeterministic encodes the same logical response 100 times, from maps whose
+// iteration order Go randomizes, and checks every encoding is byte-identical.
+func TestCanonicalDeterministic(t *testing.T) {
+	response := func() map[string]any {
+		alts := []any{}
+		for i := range 5 {
+			alts = append(alts, map[string]any{"translation": strings.Repeat("x", i), "score": 1 / float64(i+3)})
+		}
+		m := map[string]any{"translation": "EN(hello)", "alternatives": alts, "meta": map[string]any{}}
+		for _, k := range []string{"src", "dst_lang", "src_lang", "ts", "cached", "age", "glossary", "segments", "pair"} {
+			m[k] = k + "-value"
+			m["meta"].(map[string]any)[k] = len(k)
+		}
+		return m
+	}
+	h := canonicalJSON(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { j(w, http.StatusOK, response()) }))
+	var first []byte
+	for i := range 100 {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/go/translate", nil))
+		if i == 0 {
+			first = w.Body.Bytes()
+			continue
+		}
+		if !bytes.Equal(w.Body.Bytes(), first) {
+			t.Fatalf("encoding %d differs:\n%s\n%s", i, w.Body.Bytes(), first)
+		}
+	}
+	if c, _ := canonicalize(first); !bytes.Equal(c, first) {
+		t.Fatalf("not canonical: %s", first)
+	}
+}
+
+func TestCanonicalJSONWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		chunks      []string // written in turn, split anywhere
+		want        string
+	}{
+		{"json held and re-encoded", "/go/translate", "application/json", []string{`{"b":`, ` 1, "a": 2}` + "\n"}, `{"a":2,"b":1}`},
+		{"ndjson line by line", "/go/translate/bulk", "application/x-ndjson", []string{`{"id":"1", "q`, `":"a"}` + "\n" + `{"q":"b","id":"2"}` + "\n" + `{"z":0,`, `"y":1}`},
+			`{"id":"1","q":"a"}` + "\n" + `{"id":"2","q":"b"}` + "\n" + `{"y":1,"z":0}`},
+		{"event data lines", "/go/translate/stream", "text/event-stream", []string{"event: token\n", `data: {"t": "a", "i": 0}` + "\n\n", ": keepalive\n\n"},
+			"event: token\n" + `data: {"i":0,"t":"a"}` + "\n\n" + ": keepalive\n\n"},
+		{"not json passes", "/go/translate", "text/plain", []string{"EN(hello) "}, "EN(hello) "},
+		{"malformed json passes", "/go/translate", "application/json", []string{`{"a":`}, `{"a":`},
+		{"other routes pass", "/go/usage", "application/json", []string{`{"b": 1, "a": 2}`}, `{"b": 1, "a": 2}`},
+		{"v2 route", "/go/v2/translate", "application/json", []string{`{"b": 1, "a": 2}`}, `{"a":2,"b":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := canonicalJSON(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", "999")
+				w.WriteHeader(http.StatusAccepted)
+				for _, c := range tt.chunks {
+					io.WriteString(w, c)
+				}
+			}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("status %d", w.Code)
+			}
+			if w.Body.String() != tt.want {
+				t.Fatalf("body %q, want %q", w.Body.String(), tt.want)
+			}
+			if tt.contentType == "application/json" && tt.path != "/go/usage" && w.Header().Get("Content-Length") != strconv.Itoa(len(tt.want)) {
+				t.Fatalf("Content-Length %q for %d bytes", w.Header().Get("Content-Length"), len(tt.want))
+			}
+		})
+	}
+}
+
+// TestCanonicalResponses checks a server with CANONICAL_JSON answers the same translation the
+// same bytes every time, and streams canonical bulk lines.
+func TestCanonicalResponses(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := newTestServer(t, map[string]string{"CANONICAL_JSON": "true", "RATE_LIMIT_PRO_BURST": "200"}, Deps{Clock: clk}).Handler()
+	serve(h, "GET", "/go/translate?q=hello", "", "X-API-Key", testProKey) // cached from here on
+	var first string
+	for i := range 100 {
+		w := serve(h, "GET", "/go/translate?q=hello", "", "X-API-Key", testProKey)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+		if i == 0 {
+			first = w.Body.String()
+			if c, _ := canonicalize(w.Body.Bytes()); string(c) != first {
+				t.Fatalf("not canonical: %s", first)
+			}
+			continue
+		}
+		if w.Body.String() != first {
+			t.Fatalf("response %d differs:\n%s\n%s", i, w.Body.String(), first)
+		}
+	}
+
+	w := serve(h, "POST", "/go/translate/bulk", `{"id":"1","q":"a"}`+"\n"+`{"id":"2","q":"b"}`+"\n", "X-API-Key", testProKey, "Content-Type", "application/x-ndjson")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bulk status %d: %s", w.Code, w.Body.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("bulk body %q", w.Body.String())
+	}
+	for _, line := range lines {
+		var v any
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("bulk line %q: %v", line, err)
+		}
+		if c, _ := canonicalize([]byte(line)); string(c) != line {
+			t.Fatalf("bulk line not canonical: %s", line)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Per-key glossaries. A client keeps its own terminology with PUT /go/keys/self/glossary, in the
+// same term-to-target-or-true form as GLOSSARY_PATH, and its terms are protected after the global
+// glossary's on every translation it asks for; admins manage any key's under
+// /go/admin/keys/{id}/glossary. They live in SQLite (GLOSSARY_DB_PATH) and are compiled into
+// memory when opened and on every change, so edits apply to the next request and translations
+// never touch the database.
+
+// Bounds on one client glossary beyond the term count, which is configured.
+const (
+	clientTermMaxChars   = 100
+	clientTargetMaxChars = 500
+)
+
+// clientGlossary is one key's glossary, compiled and as stored. hash changes with any term or
+// target, and scopes the key's cache entries.
+type clientGlossary struct {
+	g       *glossary
+	terms   map[string]json.RawMessage
+	updated time.Time
+	hash    string
+}
+
+func newClientGlossary(parsed []*glossaryTerm, terms map[string]json.RawMessage, updated time.Time) *clientGlossary {
+	names := make([]string, 0, len(terms))
+	for term := range terms {
+		names = append(names, term)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, term := range names {
+		h.Write([]byte(term + "\x00" + string(terms[term]) + "\x00"))
+	}
+	return &clientGlossary{g: newGlossary(parsed), terms: terms, updated: updated, hash: hex.EncodeToString(h.Sum(nil)[:8])}
+}
+
+// clientGlossaries is the store and its in-memory copy. A nil *clientGlossaries has no glossaries.
+type clientGlossaries struct {
+	db       *sql.DB
+	maxTerms int
+	clock    Clock // what updated_at is stamped from
+
+	mu    sync.RWMutex
+	byKey map[string]*clientGlossary
+}
+
+var errNoGlossaryStore = errors.New("client glossaries need GLOSSARY_DB_PATH")
+
+// openClientGlossaries opens or creates the glossary DB at path and compiles every stored glossary.
+func openClientGlossaries(path string, maxTerms int, clk Clock) (*clientGlossaries, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate(db, "glossary", clk); err != nil {
+		db.Close()
+		return nil, err
+	}
+	c := &clientGlossaries{db: db, maxTerms: maxTerms, clock: clk, byKey: map[string]*clientGlossary{}}
+	rows, err := db.Query(`SELECT key_id, term, target, updated_at FROM client_glossary`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	defer rows.Close()
+	raw := map[string]map[string]json.RawMessage{}
+	updated := map[string]time.Time{}
+	for rows.Next() {
+		var (
+			key, term, target string
+			at                int64
+		)
+		if err := rows.Scan(&key, &term, &target, &at); err != nil {
+			db.Close()
+			return nil, err
+		}
+		if raw[key] == nil {
+			raw[key] = map[string]json.RawMessage{}
+		}
+		raw[key][term] = json.RawMessage(target)
+		if t := time.Unix(at, 0); t.After(updated[key]) {
+			updated[key] = t
+		}
+	}
+	if err := rows.Err(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for key, terms := range raw {
+		parsed, err := parseGlossaryTerms(terms)
+		if err != nil {
+			slog.Warn("stored client glossary skipped", "key_id", key, "err", err)
+			continue
+		}
+		c.byKey[key] = newClientGlossary(parsed, terms, updated[key])
+	}
+	slog.Info("client glossaries loaded", "path", path, "keys", len(c.byKey))
+	return c, nil
+}
+
+func (c *clientGlossaries) Close() error { return c.db.Close() }
+
+func (c *clientGlossaries) Ping(ctx context.Context) error { return c.db.PingContext(ctx) }
+
+// forCaller returns the glossary of the key on ctx, or nil.
+func (c *clientGlossaries) forCaller(ctx context.Context) *clientGlossary {
+	if c == nil {
+		return nil
+	}
+	id, ok := identityFrom(ctx)
+	if !ok {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.byKey[id.KeyID]
+}
+
+func (c *clientGlossaries) get(keyID string) (*clientGlossary, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cg, ok := c.byKey[keyID]
+	return cg, ok
+}
+
+// glossaryInvalid lists everything wrong with a submitted glossary.
+type glossaryInvalid struct{ problems []string }
+
+func (e *glossaryInvalid) Error() string { return "invalid glossary" }
+
+// put replaces keyID's glossary with terms, which must pass validateClientGlossary. An empty
+// glossary is a delete.
+func (c *clientGlossaries) put(ctx context.Context, keyID string, terms map[string]json.RawMessage) (*clientGlossary, error) {
+	if len(terms) == 0 {
+		_, err := c.delete(ctx, keyID)
+		return nil, err
+	}
+	parsed, err := parseGlossaryTerms(terms)
+	if err != nil {
+		return nil, &glossaryInvalid{problems: []string{err.Error()}}
+	}
+	if problems := validateClientGlossary(parsed); len(problems) > 0 {
+		return nil, &glossaryInvalid{problems: problems}
+	}
+	now := c.clock.Now()
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM client_glossary WHERE key_id = ?`, keyID); err != nil {
+		return nil, err
+	}
+	for term, target := range terms {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO client_glossary (key_id, term, target, updated_at) VALUES (?, ?, ?, ?)`,
+			keyID, term, string(target), now.Unix()); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	cg := newClientGlossary(parsed, terms, now)
+	c.mu.Lock()
+	c.byKey[keyID] = cg
+	c.mu.Unlock()
+	return cg, nil
+}
+
+// delete drops keyID's glossary and returns how many terms it had.
+func (c *clientGlossaries) delete(ctx context.Context, keyID string) (int, error) {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM client_glossary WHERE key_id = ?`, keyID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	c.mu.Lock()
+	delete(c.byKey, keyID)
+	c.mu.Unlock()
+	return int(n), nil
+}
+
+// validateClientGlossary checks terms for length, for two spellings of one term ("Atoll
+// Council" and "atoll council"), and for terms that overlap without one containing the other
+// ("atoll council" and "council meeting"), where which applies would depend on the sentence.
+// A term inside a longer one is fine: the longer wins where both match.
+func validateClientGlossary(terms []*glossaryTerm) []string {
+	var problems []string
+	sort.Slice(terms, func(i, j int) bool { return terms[i].name < terms[j].name })
+	for _, t := range terms {
+		if n := len(t.source); n > clientTermMaxChars {
+			problems = append(problems, fmt.Sprintf("term %q is %d characters; the limit is %d", t.name, n, clientTermMaxChars))
+		}
+		if n := utf8.RuneCountInString(t.target); n > clientTargetMaxChars {
+			problems = append(problems, fmt.Sprintf("target for %q is %d characters; the limit is %d", t.name, n, clientTargetMaxChars))
+		}
+	}
+	for i, a := range terms {
+		for _, b := range terms[i+1:] {
+			fold := a.fold || b.fold
+			switch {
+			case len(a.source) == len(b.source) && runesMatch(a.source, b.source, fold):
+				problems = append(problems, fmt.Sprintf("%q and %q are the same term", a.name, b.name))
+			case termsOverlap(a.source, b.source, fold) || termsOverlap(b.source, a.source, fold):
+				problems = append(problems, fmt.Sprintf("%q and %q overlap", a.name, b.name))
+			}
+		}
+	}
+	return problems
+}
+
+// termsOverlap reports whether a ends with whole words that b starts with, as in "atoll
+// council" and "council meeting".
+func termsOverlap(a, b []rune, fold bool) bool {
+	for k := 1; k < len(a) && k < len(b); k++ {
+		if wordRune(a[len(a)-k-1]) || !wordRune(a[len(a)-k]) || wordRune(b[k]) {
+			continue
+		}
+		if runesMatch(a[len(a)-k:], b[:k], fold) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientGlossaryView is the GET and PUT response.
+type clientGlossaryView struct {
+	KeyID     string                     `json:"key_id"`
+	Terms     map[string]json.RawMessage `json:"terms"`
+	Count     int                        `json:"count"`
+	MaxTerms  int                        `json:"max_terms"`
+	UpdatedAt *time.Time                 `json:"updated_at,omitempty"`
+}
+
+func (c *clientGlossaries) view(keyID string, cg *clientGlossary) clientGlossaryView {
+	v := clientGlossaryView{KeyID: keyID, Terms: map[string]json.RawMessage{}, MaxTerms: c.maxTerms}
+	if cg != nil {
+		v.Terms, v.Count, v.UpdatedAt = cg.terms, len(cg.terms), &cg.updated
+	}
+	return v
+}
+
+// glossaryKeyFunc names the key a glossary request is about, or writes the error and returns
+// false.
+type glossaryKeyFunc func(http.ResponseWriter, *http.Request) (string, bool)
+
+// glossaryKey runs keyOf once the store is known to be configured.
+func (c *clientGlossaries) glossaryKey(w http.ResponseWriter, r *http.Request, keyOf glossaryKeyFunc) (string, bool) {
+	if c == nil {
+		writeError(w, http.StatusNotImplemented, codeNotImplemented, errNoGlossaryStore.Error())
+		return "", false
+	}
+	return keyOf(w, r)
+}
+
+// glossaryGetHandler serves GET on a key's glossary: its terms, possibly none.
+func glossaryGetHandler(c *clientGlossaries, keyOf glossaryKeyFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := c.glossaryKey(w, r, keyOf)
+		if !ok {
+			return
+		}
+		cg, _ := c.get(key)
+		j(w, http.StatusOK, c.view(key, cg))
+	}
+}
+
+// glossaryPutHandler serves PUT on a key's glossary. The body replaces it whole, in
+// GLOSSARY_PATH's form; every problem with it is listed in one 400 and nothing changes.
+func glossaryPutHandler(c *clientGlossaries, keyOf glossaryKeyFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := c.glossaryKey(w, r, keyOf)
+		if !ok {
+			return
+		}
+		var terms map[string]json.RawMessage
+		if herr := decodeJSONBody(r, &terms); herr != nil {
+			herr.write(w)
+			return
+		}
+		auditParam(r.Context(), "terms", len(terms))
+		if len(terms) > c.maxTerms {
+			writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("too many terms (max %d)", c.maxTerms))
+			return
+		}
+		cg, err := c.put(r.Context(), key, terms)
+		var invalid *glossaryInvalid
+		switch {
+		case errors.As(err, &invalid):
+			writeError(w, http.StatusBadRequest, codeBadRequest, "invalid glossary", "errors", invalid.problems)
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, codeInternal, "glossary save failed", "detail", err.Error())
+			return
+		}
+		slog.Info("client glossary replaced", "key_id", key, "terms", len(terms))
+		j(w, http.StatusOK, c.view(key, cg))
+	}
+}
+
+// glossaryDeleteHandler serves DELETE on a key's glossary.
+func glossaryDeleteHandler(c *clientGlossaries, keyOf glossaryKeyFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := c.glossaryKey(w, r, keyOf)
+		if !ok {
+			return
+		}
+		n, err := c.delete(r.Context(), key)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "glossary delete failed", "detail", err.Error())
+			return
+		}
+		auditParam(r.Context(), "removed", n)
+		slog.Info("client glossary deleted", "key_id", key, "terms", n)
+		j(w, http.StatusOK, map[string]any{"key_id": key, "removed": n})
+	}
+}
+
+// selfGlossaryKey is keyOf for /go/keys/self/glossary: the calling key.
+func selfGlossaryKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := identityFrom(r.Context())
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "glossaries are kept per API key; none configured")
+		return "", false
+	}
+	return id.KeyID, true
+}
+
+// adminGlossaryKey is keyOf for /go/admin/keys/{id}/glossary: a key ks knows.
+func adminGlossaryKey(ks *keyStore) glossaryKeyFunc {
+	return func(w http.ResponseWriter, r *http.Request) (string, bool) {
+		id := chi.URLParam(r, "id")
+		auditParam(r.Context(), "id", id)
+		if !slices.ContainsFunc(ks.list(), func(k keyInfo) bool { return k.ID == id }) {
+			writeError(w, http.StatusNotFound, codeNotFound, "no api key "+id)
+			return "", false
+		}
+		return id, true
+	}
+}
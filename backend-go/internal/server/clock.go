@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits on it, for Deps.Clock. What expires, refills or is stamped
+// (the caches and their seed, rate limits, quotas, keys, nonces, idempotency keys, jobs and
+// their callbacks, the breakers, the adaptive timeouts' and upstreams' windows, translation
+// memory, client glossaries, migrations, the audit log, signatures, provenance and in-flight
+// ages) reads the Server's rather than the time package, as do the timers and sweepers under
+// them, so an embedder's tests can drive them with a FakeClock instead of sleeping. Latencies
+// measured for logs and metrics, and the deadlines handed to the network, stay on the time
+// package.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a Clock's time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a Clock's time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) NewTimer(d time.Duration) Timer         { return systemTimer{time.NewTimer(d)} }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) NewTicker(d time.Duration) Ticker       { return systemTicker{time.NewTicker(d)} }
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+type systemTicker struct{ *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+func (s *Server) uptime() time.Duration { return s.clock.Now().Sub(s.started) }
+
+// FakeClock is a Clock that only moves when Advance moves it, for tests. Advance fires every
+// timer and ticker due by the new time before it returns, earliest first, with Now reading
+// each one's time as it fires: a timer created before an Advance past it fires exactly once. A
+// channel holds one tick, as time's tickers' do, so a ticker several periods behind delivers
+// one. Stop and Reset leave a tick already sent in the channel, as time's do under go 1.22.
+//
+// A goroutine that makes its timer concurrently with the test may do so after the Advance
+// meant for it; BlockUntil waits for it first.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	added   chan struct{} // closed and replaced when a waiter is added, for BlockUntil
+}
+
+// NewFakeClock returns a FakeClock reading now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, added: make(chan struct{})}
+}
+
+// fakeWaiter is a FakeClock's timer, or with a period, its ticker.
+type fakeWaiter struct {
+	clock  *FakeClock
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer { return fakeTimer{c.wait(d, 0)} }
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time { return c.wait(d, 0).c }
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.wait(d, d)}
+}
+
+func (c *FakeClock) wait(d, period time.Duration) *fakeWaiter {
+	w := &fakeWaiter{clock: c, period: period, c: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedule(w, d)
+	return w
+}
+
+// schedule (re)arms w for d from now; one due already fires at once, as time's do. c.mu is held.
+func (c *FakeClock) schedule(w *fakeWaiter, d time.Duration) {
+	w.at = c.now.Add(d)
+	if d <= 0 && w.period == 0 {
+		w.fire(c.now)
+		return
+	}
+	c.waiters = append(c.waiters, w)
+	close(c.added)
+	c.added = make(chan struct{})
+}
+
+// unschedule disarms w, reporting whether it was armed. c.mu is held.
+func (c *FakeClock) unschedule(w *fakeWaiter) bool {
+	i := slices.Index(c.waiters, w)
+	if i >= 0 {
+		c.waiters = slices.Delete(c.waiters, i, i+1)
+	}
+	return i >= 0
+}
+
+func (w *fakeWaiter) fire(at time.Time) {
+	select {
+	case w.c <- at:
+	default:
+	}
+}
+
+// Advance moves the clock on by d, firing what comes due on the way.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for {
+		i := -1
+		for j, w := range c.waiters {
+			if !w.at.After(end) && (i < 0 || w.at.Before(c.waiters[i].at)) {
+				i = j
+			}
+		}
+		if i < 0 {
+			break
+		}
+		w := c.waiters[i]
+		c.now = w.at
+		w.fire(w.at)
+		if w.period == 0 {
+			c.waiters = slices.Delete(c.waiters, i, i+1)
+			continue
+		}
+		// The ticks between here and end would find the channel full; the next is after end.
+		w.at = w.at.Add(w.period * (end.Sub(w.at)/w.period + 1))
+	}
+	c.now = end
+}
+
+// Waiters is how many timers and tickers are armed.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until at least n timers and tickers are armed, or ctx is done.
+func (c *FakeClock) BlockUntil(ctx context.Context, n int) error {
+	for {
+		c.mu.Lock()
+		armed, added := len(c.waiters), c.added
+		c.mu.Unlock()
+		if armed >= n {
+			return nil
+		}
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type fakeTimer struct{ *fakeWaiter }
+
+func (t fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.unschedule(t.fakeWaiter)
+}
+
+func (t fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	armed := t.clock.unschedule(t.fakeWaiter)
+	t.clock.schedule(t.fakeWaiter, d)
+	return armed
+}
+
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.unschedule(t.fakeWaiter)
+}
+
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.clock.unschedule(t.fakeWaiter)
+	t.period = d
+	t.clock.schedule(t.fakeWaiter, d)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestFakeClock arms timers and a ticker from goroutines, waits for them with BlockUntil, and
+// checks one Advance past them all fires each timer once, at its own time, and the ticker,
+// several periods behind, once.
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := NewFakeClock(start)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	timers := []time.Duration{30 * time.Second, 10 * time.Second, 20 * time.Second}
+	chans := make([]<-chan time.Time, len(timers))
+	armed := make(chan int, len(timers)+1)
+	for i, d := range timers {
+		go func() {
+			if i%2 == 0 {
+				chans[i] = clk.NewTimer(d).C()
+			} else {
+				chans[i] = clk.After(d)
+			}
+			armed <- i
+		}()
+	}
+	var ticker Ticker
+	go func() {
+		ticker = clk.NewTicker(15 * time.Second)
+		armed <- -1
+	}()
+	if err := clk.BlockUntil(ctx, len(timers)+1); err != nil {
+		t.Fatalf("BlockUntil: %v", err)
+	}
+	for range len(timers) + 1 {
+		<-armed // for the race detector: the goroutines' writes are seen
+	}
+
+	clk.Advance(time.Minute)
+	if got, want := clk.Now(), start.Add(time.Minute); !got.Equal(want) {
+		t.Fatalf("Now %v after Advance, want %v", got, want)
+	}
+	for i, d := range timers {
+		select {
+		case at := <-chans[i]:
+			if want := start.Add(d); !at.Equal(want) {
+				t.Fatalf("%v timer fired at %v, want %v", d, at, want)
+			}
+		default:
+			t.Fatalf("%v timer didn't fire", d)
+		}
+	}
+	select {
+	case at := <-ticker.C():
+		if want := start.Add(15 * time.Second); !at.Equal(want) {
+			t.Fatalf("ticker's tick at %v, want the first, %v", at, want)
+		}
+	default:
+		t.Fatal("ticker didn't tick")
+	}
+	// Each fired once, and the ticker's missed ticks are gone rather than queued.
+	for i, c := range append(chans, ticker.C()) {
+		select {
+		case at := <-c:
+			t.Fatalf("channel %d fired again, at %v", i, at)
+		default:
+		}
+	}
+	if n := clk.Waiters(); n != 1 {
+		t.Fatalf("%d waiters armed, want only the ticker", n)
+	}
+
+	// The ticker keeps its phase: the next tick is the first after the Advance.
+	clk.Advance(15 * time.Second)
+	if at, want := <-ticker.C(), start.Add(75*time.Second); !at.Equal(want) {
+		t.Fatalf("next tick at %v, want %v", at, want)
+	}
+	ticker.Stop()
+	clk.Advance(time.Minute)
+	select {
+	case at := <-ticker.C():
+		t.Fatalf("stopped ticker ticked at %v", at)
+	default:
+	}
+}
+
+// TestFakeClockStopReset checks a stopped timer never fires, a reset one fires at its new time,
+// and BlockUntil gives up with its context.
+func TestFakeClockStopReset(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := NewFakeClock(start)
+
+	stopped, reset := clk.NewTimer(time.Second), clk.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Fatal("Stop of an armed timer reported it wasn't armed")
+	}
+	if !reset.Reset(time.Minute) {
+		t.Fatal("Reset of an armed timer reported it wasn't armed")
+	}
+	clk.Advance(30 * time.Second)
+	select {
+	case at := <-stopped.C():
+		t.Fatalf("stopped timer fired at %v", at)
+	case at := <-reset.C():
+		t.Fatalf("reset timer fired at %v, before its new time", at)
+	default:
+	}
+	clk.Advance(30 * time.Second)
+	if at, want := <-reset.C(), start.Add(time.Minute); !at.Equal(want) {
+		t.Fatalf("reset timer fired at %v, want %v", at, want)
+	}
+	if stopped.Stop() || reset.Stop() {
+		t.Fatal("Stop reported a timer that had fired or stopped as armed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := clk.BlockUntil(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("BlockUntil with nothing armed: %v, want canceled", err)
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	stdflag "flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Command-line configuration, for running locally without exporting a dozen variables:
+//
+//	backend-go --port 9090 --upstream http://localhost:8000 --mode stub
+//
+// Every variable a Config field is read from has a flag named after it: PORT is --port,
+// UPSTREAM_URLS is --upstream-urls. --config names a YAML or JSON file of the same settings,
+// keyed by variable or flag name. Flags win over the environment, which wins over that file;
+// CONFIG_FILE, the env file a reload picks edits up from, still wins over the environment. The
+// flags and the --config file are read again on every reload, so flags keep winning.
+
+// flagAliases are shorter names for the flags typed most.
+var flagAliases = map[string]string{
+	"upstream": "UPSTREAM_URL",
+	"mode":     "TRANSLATE_MODE",
+}
+
+// cmdline is what the command line asked for.
+type cmdline struct {
+	vars       map[string]string // settings given as flags, by variable name
+	configPath string            // --config
+	validate   bool              // --validate: check the configuration and exit
+	selftest   bool              // --selftest: run the self-test and exit
+	migrate    bool              // --migrate-only: apply the SQLite migrations and exit
+	migrateDry bool              // --migrate-dry-run: print the migrations and exit
+}
+
+func flagName(env string) string { return strings.ToLower(strings.ReplaceAll(env, "_", "-")) }
+
+// parseCmdline reads args. --help is stdflag.ErrHelp, after printing the options to out.
+func parseCmdline(args []string, out io.Writer) (cmdline, error) {
+	cl := cmdline{vars: map[string]string{}}
+	fs := stdflag.NewFlagSet("backend-go", stdflag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.StringVar(&cl.configPath, "config", "", "")
+	fs.BoolVar(&cl.validate, "validate", false, "")
+	fs.BoolVar(&cl.selftest, "selftest", false, "")
+	fs.BoolVar(&cl.migrate, "migrate-only", false, "")
+	fs.BoolVar(&cl.migrateDry, "migrate-dry-run", false, "")
+	for _, v := range configVars {
+		set := func(s string) error {
+			cl.vars[v.env] = s
+			return nil
+		}
+		names := []string{flagName(v.env)}
+		for alias, env := range flagAliases {
+			if env == v.env {
+				names = append(names, alias)
+			}
+		}
+		for _, name := range names {
+			if v.kind == reflect.Bool {
+				fs.BoolFunc(name, "", set)
+			} else {
+				fs.Func(name, "", set)
+			}
+		}
+	}
+	fs.Usage = func() { printUsage(out) }
+	if err := fs.Parse(args); err != nil {
+		return cl, err
+	}
+	if fs.NArg() > 0 {
+		return cl, fmt.Errorf("unexpected argument %q; settings are flags, e.g. --port 9090", fs.Arg(0))
+	}
+	return cl, nil
+}
+
+// Settings is the configuration the command line, environment and files gave at startup, with
+// what New needs to read them the same way again on a reload.
+type Settings struct {
+	Config   Config
+	Validate bool // --validate: check the configuration and exit
+	SelfTest bool // --selftest: run the self-test, print its report and exit
+	// MigrateOnly and MigrateDryRun are --migrate-only and --migrate-dry-run: apply, or only
+	// print, the SQLite migrations and exit.
+	MigrateOnly   bool
+	MigrateDryRun bool
+
+	cl  cmdline
+	src configSources
+}
+
+// LoadSettings parses args and loads the configuration as cmdline.readConfig does. --help is
+// flag.ErrHelp, after printing the options to out; any other error lists every problem.
+func LoadSettings(args []string, out io.Writer) (Settings, error) {
+	cl, err := parseCmdline(args, out)
+	if err != nil {
+		return Settings{}, err
+	}
+	cfg, src, err := cl.readConfig()
+	if err != nil {
+		return Settings{}, err
+	}
+	return Settings{Config: cfg, Validate: cl.validate, SelfTest: cl.selftest,
+		MigrateOnly: cl.migrate, MigrateDryRun: cl.migrateDry, cl: cl, src: src}, nil
+}
+
+// Fingerprint is the Config's fingerprint, for --validate to print.
+func (s Settings) Fingerprint() string { return s.Config.fingerprint() }
+
+// printUsage lists every flag with its variable and default, from Config's tags.
+func printUsage(out io.Writer) {
+	defaults, _ := LoadConfig(func(string) string { return "" })
+	dv := reflect.ValueOf(defaults)
+	fmt.Fprint(out, "Usage: backend-go [flags]\n\n"+
+		"Each setting is also read from the environment variable named next to it. Flags win over\n"+
+		"the environment, which wins over the --config file.\n\n")
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  --config path\t\tYAML or JSON file of settings, keyed by variable or flag name")
+	fmt.Fprintln(tw, "  --validate\t\tload and validate the configuration, then exit")
+	fmt.Fprintln(tw, "  --selftest\t\tbuild the server, run the self-test, print its JSON report and exit")
+	fmt.Fprintln(tw, "  --migrate-only\t\tapply the SQLite stores' schema migrations, print what was applied and exit")
+	fmt.Fprintln(tw, "  --migrate-dry-run\t\tprint the migrations each SQLite store is due and exit, changing nothing")
+	for _, v := range configVars {
+		name := "--" + flagName(v.env)
+		for alias, env := range flagAliases {
+			if env == v.env {
+				name += ", --" + alias
+			}
+		}
+		if v.kind != reflect.Bool {
+			name += " value"
+		}
+		def := ""
+		switch fv := dv.FieldByIndex(v.index); {
+		case v.secret:
+			def = "secret"
+		case !fv.IsZero() && !(fv.Kind() == reflect.Map && fv.Len() == 0):
+			def = "default " + showValue(fv)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", name, v.env, def)
+	}
+	tw.Flush()
+}
+
+// readConfig loads Config from, highest first: the flags, CONFIG_FILE, the process environment
+// and the --config file. A running process can't have its environment changed, so CONFIG_FILE
+// is what a reload picks edits up from, and its values win over the environment.
+func (cl cmdline) readConfig() (Config, configSources, error) {
+	var layered map[string]string
+	if cl.configPath != "" {
+		var err error
+		if layered, err = readSettingsFile(cl.configPath); err != nil {
+			return Config{}, nil, &configError{problems: []string{"--config: " + err.Error()}}
+		}
+	}
+	lookup := func(key string) (string, string) {
+		if v, ok := cl.vars[key]; ok {
+			return v, "flag"
+		}
+		if v := os.Getenv(key); v != "" {
+			return v, "env"
+		}
+		return layered[key], "config"
+	}
+	var vars map[string]string
+	if path, _ := lookup("CONFIG_FILE"); path != "" {
+		var err error
+		if vars, err = readEnvFile(path); err != nil {
+			return Config{}, nil, &configError{problems: []string{"CONFIG_FILE: " + err.Error()}}
+		}
+	}
+	src := configSources{}
+	cfg, err := LoadConfig(func(key string) string {
+		v, from := lookup(key)
+		if _, flagged := cl.vars[key]; !flagged && key != "CONFIG_FILE" {
+			if fv, ok := vars[key]; ok {
+				v, from = fv, "file"
+			}
+		}
+		if strings.TrimSpace(v) != "" {
+			src[key] = from
+		}
+		return v
+	})
+	return cfg, src, err
+}
+
+// readSettingsFile parses a YAML or JSON object of settings. Keys are variable or flag names
+// (PORT, port, upstream-url, upstream); lists may be sequences or comma-separated strings.
+func readSettingsFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	known := make(map[string]bool, len(configVars))
+	for _, v := range configVars {
+		known[v.env] = true
+	}
+	out := make(map[string]string, len(raw))
+	var problems []string
+	for k, val := range raw {
+		env, ok := flagAliases[k]
+		if !ok {
+			env = strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		}
+		if !known[env] {
+			problems = append(problems, fmt.Sprintf("%q is not a setting", k))
+			continue
+		}
+		switch val := val.(type) {
+		case nil:
+		case []any:
+			items := make([]string, len(val))
+			for i, it := range val {
+				items[i] = fmt.Sprint(it)
+			}
+			out[env] = strings.Join(items, ",")
+		case map[string]any:
+			problems = append(problems, fmt.Sprintf("%s: want a value or a list, not an object", k))
+		default:
+			out[env] = fmt.Sprint(val)
+		}
+	}
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return nil, fmt.Errorf("%s: %s", path, strings.Join(problems, "; "))
+	}
+	return out, nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compress encodes responses with brotli or gzip, whichever the client's Accept-Encoding
+// prefers, brotli winning a tie; brotliQuality 0 leaves brotli out. Output is buffered until
+// minSize bytes so small bodies go out unchanged; handlers that set Content-Encoding themselves
+// are left alone. Encoders are pooled, since brotli's state in particular is costly to set up.
+func compress(level, brotliQuality, minSize int) func(http.Handler) http.Handler {
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() any {
+			gz, _ := gzip.NewWriterLevel(io.Discard, level)
+			return gz
+		}},
+	}
+	if brotliQuality > 0 {
+		pools["br"] = &sync.Pool{New: func() any { return newBrotliWriter(io.Discard, brotliQuality) }}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			enc := pickEncoding(r.Header.Get("Accept-Encoding"), pools["br"] != nil)
+			if enc == "" || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressResponseWriter{ResponseWriter: w, encoding: enc, pool: pools[enc], minSize: minSize}
+			defer cw.finish()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// pickEncoding returns "br", "gzip" or "" (identity) for an Accept-Encoding value: the
+// supported coding with the highest q, "*" standing in for any not named, and q=0 refusing.
+func pickEncoding(header string, brotli bool) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					weight = f
+				}
+			}
+		}
+		q[name] = weight
+	}
+	weight := func(coding string) float64 {
+		if w, ok := q[coding]; ok {
+			return w
+		}
+		return q["*"] // 0 when absent
+	}
+	best, bestQ := "", 0.0
+	if brotli {
+		best, bestQ = "br", weight("br")
+	}
+	if g := weight("gzip"); g > bestQ {
+		best, bestQ = "gzip", g
+	}
+	if bestQ <= 0 {
+		return ""
+	}
+	return best
+}
+
+// encoder is the part of gzip.Writer and brotliWriter a response needs.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressResponseWriter defers the encoding decision until minSize bytes are buffered, the
+// handler flushes, or the response ends.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string // Content-Encoding when compressing
+	pool     *sync.Pool
+	minSize  int
+
+	code    int
+	buf     []byte
+	decided bool
+	enc     encoder // non-nil once compressing
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide commits to compressing (when wanted and allowed) or identity, then writes headers and any buffered bytes.
+// A body served with Accept-Ranges stays identity, since its ranges are offsets into those bytes.
+func (w *compressResponseWriter) decide(want bool) error {
+	w.decided = true
+	h := w.Header()
+	if want && h.Get("Content-Encoding") == "" && h.Get("Accept-Ranges") == "" && w.code != http.StatusNoContent && w.code != http.StatusNotModified {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if tag := h.Get("ETag"); tag != "" && !strings.HasPrefix(tag, "W/") {
+			h.Set("ETag", "W/"+tag) // the strong tag names the identity bytes, not these
+		}
+		w.enc = w.pool.Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// Flush commits to identity encoding if undecided (streams stay uncompressed) and flushes through.
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		_ = w.decide(false)
+	}
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *compressResponseWriter) finish() {
+	if !w.decided {
+		if w.code == 0 && len(w.buf) == 0 {
+			return // handler wrote nothing; let net/http send its default
+		}
+		_ = w.decide(false)
+	}
+	if w.enc != nil {
+		_ = w.enc.Close()
+		w.enc.Reset(io.Discard) // don't keep the response alive from the pool
+		w.pool.Put(w.enc)
+		w.enc = nil
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// benchWords are what benchBatchBody's phrases are made of.
+var benchWords = strings.Fields("kihineh baaru ey dhivehin rah gehaa fen kaan bodu kuda rashu magu " +
+	"hendhunu mendhuru haveeru reggadu miadhu mirey salaam shukuriyyaa ingireysi bas bahuru")
+
+// benchBatchBody is a /go/translate/batch response of n items of a few random words each, as
+// batchHandler writes it.
+func benchBatchBody(n int) []byte {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	items := make(map[string]batchResult, n)
+	for i := range n {
+		words := make([]string, 2+rnd.IntN(6))
+		for j := range words {
+			words[j] = benchWords[rnd.IntN(len(benchWords))]
+		}
+		q := strings.Join(words, " ")
+		items[fmt.Sprint(i)] = batchResult{Translation: "EN(" + q + ")", Src: "upstream", SrcLang: "dv", DstLang: "en", Cached: i%3 == 0}
+	}
+	b, _ := json.Marshal(map[string]any{"ok": true, "results": items})
+	return b
+}
+
+// BenchmarkCompress serves a 100-item batch response through the compression middleware at the
+// default settings, for each encoding a client may ask for, reporting the bytes sent.
+func BenchmarkCompress(b *testing.B) {
+	body := benchBatchBody(100)
+	h := compress(gzip.DefaultCompression, 5, 1024)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	for _, enc := range []string{"identity", "gzip", "br"} {
+		b.Run(enc, func(b *testing.B) {
+			r := httptest.NewRequest("POST", "/go/translate/batch", nil)
+			r.Header.Set("Accept-Encoding", enc)
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			var sent int
+			for range b.N {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if got := w.Header().Get("Content-Encoding"); got != enc && !(enc == "identity" && got == "") {
+					b.Fatalf("Content-Encoding %q, want %s", got, enc)
+				}
+				sent = w.Body.Len()
+			}
+			b.ReportMetric(float64(sent), "sent-bytes")
+			b.ReportMetric(float64(sent)/float64(len(body)), "ratio")
+		})
+	}
+}
@@ -0,0 +1,1215 @@
+package server
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is every setting the service reads, loaded and validated at startup and again on each
+// reload (see reload.go). Each field's config tag names the variable it is read from, and the
+// command-line flag (see cmdline.go), and marks secrets with ",secret". "-" is a field built from
+// several; a struct so tagged has its own fields tagged. An untagged field stops the binary from
+// starting, so no setting goes in without saying whether it is a secret.
+type Config struct {
+	Env            string `config:"ENV"` // development | production
+	Port           string `config:"PORT"`
+	GRPCPort       string `config:"GRPC_PORT"`  // empty: no gRPC listener
+	EnableH2C      bool   `config:"ENABLE_H2C"` // also serve HTTP/2 cleartext on PORT
+	LogLevel       string `config:"LOG_LEVEL"`
+	LogHealthEvery int    `config:"LOG_HEALTH_EVERY"`  // log 1 in N health checks; 0 suppresses
+	LogRequests    bool   `config:"LOG_REQUESTS"`      // a JSON "request" line per request in the service log
+	AccessLog      string `config:"ACCESS_LOG_FORMAT"` // off|json|common|combined: an access log alongside, e.g. for GoAccess
+	AccessLogFile  string `config:"ACCESS_LOG_FILE"`   // where the access log goes; empty is stdout
+
+	LogContent      string `config:"LOG_CONTENT"`       // none|truncated|full: how much of the text to translate logs and error reports may hold
+	LogContentRunes int    `config:"LOG_CONTENT_RUNES"` // what truncated keeps of it
+
+	SlowRequestThreshold time.Duration `config:"SLOW_REQUEST_THRESHOLD"` // 0 disables slow_request lines
+	SlowRequestSample    int           `config:"SLOW_REQUEST_SAMPLE"`    // log 1 in N slow requests
+
+	StatsdAddr   string   `config:"STATSD_ADDR"`   // host:port of a StatsD/DogStatsD agent; empty: no StatsD
+	StatsdPrefix string   `config:"STATSD_PREFIX"` // metric name prefix
+	StatsdTags   []string `config:"STATSD_TAGS"`   // key:value tags on every metric
+	StatsdFlavor string   `config:"STATSD_FLAVOR"` // dogstatsd (tags) | statsd (none)
+	StatsdBuffer int      `config:"STATSD_BUFFER"` // lines queued before dropping
+
+	ListenSocket     string      `config:"LISTEN_SOCKET"`      // Unix socket path to listen on instead of PORT
+	ListenSocketMode os.FileMode `config:"LISTEN_SOCKET_MODE"` // its permissions
+
+	CommitSHA string `config:"COMMIT_SHA"`
+	BuildTime string `config:"BUILD_TIME"`
+
+	ConfigFile string `config:"CONFIG_FILE"` // KEY=VALUE lines read over the environment, again on each reload
+
+	TranslateMode       string        `config:"TRANSLATE_MODE"`        // stub | proxy | pack_only; see the translateMode constants
+	StubMode            bool          `config:"STUB_MODE"`             // echo instead of proxying; allowed in production only when explicit
+	StubOptIn           bool          `config:"STUB_OPT_IN"`           // proxy mode echoes requests sent with X-DHK-Stub: 1 (not in production)
+	FeatureFlags        map[flag]bool `config:"FEATURE_FLAGS"`         // FEATURE_FLAGS overrides of the runtime flags' defaults
+	UpstreamURL         *url.URL      `config:"UPSTREAM_URL"`          // nil in stub mode; the first of UpstreamURLs
+	UpstreamURLs        []*url.URL    `config:"UPSTREAM_URLS"`         // UPSTREAM_URLS in order of preference; UPSTREAM_URL alone is a list of one
+	UpstreamWeights     []int64       `config:"-"`                     // per UpstreamURLs entry, from "url=weight"; all 0 (plain failover) without
+	UpstreamTimeout     time.Duration `config:"UPSTREAM_TIMEOUT"`      // per attempt
+	UpstreamMaxAttempts int           `config:"UPSTREAM_MAX_ATTEMPTS"` // total calls per translate, including the first
+	UpstreamRetryBase   time.Duration `config:"UPSTREAM_RETRY_BASE"`
+	HedgeDelay          time.Duration `config:"HEDGE_DELAY"`            // wait before a second attempt on a slow single translate; 0 disables hedging
+	UpstreamExtended    string        `config:"UPSTREAM_EXTENDED_PATH"` // path under UpstreamURL for pro-tier (extended) calls; empty uses /translate
+	UpstreamStrict      bool          `config:"UPSTREAM_STRICT_SCHEMA"` // unknown fields in an upstream response are a schema violation
+	RecordUpstreamDir   string        `config:"RECORD_UPSTREAM_DIR"`    // write every upstream exchange here as a fixture (development only)
+	ReplayUpstreamDir   string        `config:"REPLAY_UPSTREAM_DIR"`    // answer upstream calls from the fixtures here instead of the network (development only)
+	SharedCallTimeout   time.Duration `config:"SHARED_CALL_TIMEOUT"`    // bound on an upstream call shared by concurrent identical requests
+	DebugHeaders        bool          `config:"DEBUG_HEADERS"`          // expose diagnostics such as X-Upstream-Attempts
+	ProvenancePro       bool          `config:"PROVENANCE_PRO"`         // pro keys get a translation's provenance without asking for it with ?debug=1
+	EnableDebug         bool          `config:"ENABLE_DEBUG"`           // mount pprof and expvar under /go/debug (admin token only)
+	EnableAPIDocs       bool          `config:"ENABLE_API_DOCS"`        // serve Swagger UI at /go/docs; /go/openapi.json is always on
+	LegacyAPISunset     time.Time     `config:"LEGACY_API_SUNSET"`      // when the unversioned /go/ aliases of v1 are removed, for their Sunset header
+	BreakerFailures     int           `config:"BREAKER_FAILURES"`       // consecutive upstream failures that open the breaker; 0 disables it
+	BreakerCooldown     time.Duration `config:"BREAKER_COOLDOWN"`       // how long the breaker stays open before a half-open probe
+
+	AdaptiveTimeout         bool          `config:"UPSTREAM_ADAPTIVE_TIMEOUT"`     // cut an upstream call's timeout to what calls of its input length take lately
+	AdaptiveTimeoutQuantile float64       `config:"UPSTREAM_ADAPTIVE_QUANTILE"`    // the latency quantile it is taken from
+	AdaptiveTimeoutFactor   float64       `config:"UPSTREAM_ADAPTIVE_MULTIPLIER"`  // times that quantile
+	AdaptiveTimeoutSamples  int           `config:"UPSTREAM_ADAPTIVE_MIN_SAMPLES"` // calls a length class needs in the window before it adapts
+	AdaptiveTimeoutFloor    time.Duration `config:"UPSTREAM_ADAPTIVE_FLOOR"`       // the shortest timeout it may pick; UPSTREAM_TIMEOUT is the longest
+
+	MirrorURL             *url.URL      `config:"MIRROR_UPSTREAM_URL"`     // a backend to shadow-test: sampled upstream calls are repeated to it and compared
+	MirrorSampleRate      float64       `config:"MIRROR_SAMPLE_RATE"`      // share of upstream translations mirrored (0..1); 0 mirrors none
+	MirrorTimeout         time.Duration `config:"MIRROR_TIMEOUT"`          // per mirror call; there are no retries
+	MirrorMaxInFlight     int           `config:"MIRROR_MAX_INFLIGHT"`     // mirror calls at once; a sample past it is dropped
+	MirrorMaxConnShare    float64       `config:"MIRROR_MAX_CONN_SHARE"`   // share of outbound requests in flight past which mirroring turns off
+	MirrorReportSamples   int           `config:"MIRROR_REPORT_SAMPLES"`   // recent mirror calls /go/admin/mirror summarizes
+	MirrorAgreeSimilarity float64       `config:"MIRROR_AGREE_SIMILARITY"` // similarity at which a mirror call counts as agreeing
+
+	HealthTimeout    time.Duration `config:"HEALTH_TIMEOUT"` // per-route budgets; streams are bounded by StreamMaxDuration
+	TranslateTimeout time.Duration `config:"TRANSLATE_TIMEOUT"`
+	BatchTimeout     time.Duration `config:"BATCH_TIMEOUT"`
+	TranslitTimeout  time.Duration `config:"TRANSLIT_TIMEOUT"` // /go/transliterate is in-process, so far shorter
+	DeadlineFloor    time.Duration `config:"DEADLINE_FLOOR"`   // the least budget an X-Deadline-Ms header gets; the route's timeout is the most
+
+	MaxConcurrency    int           `config:"MAX_CONCURRENCY"`     // translate requests served at once; the rest queue
+	ConcurrencyQueue  int           `config:"CONCURRENCY_QUEUE"`   // requests allowed to wait for a slot
+	QueueWaitMax      time.Duration `config:"QUEUE_WAIT_MAX"`      // how long a queued request waits for a slot before a 503
+	ConcurrencyWait   time.Duration `config:"CONCURRENCY_WAIT"`    // QUEUE_WAIT_MAX's old name, still its default
+	KeyConcurrency    int           `config:"KEY_CONCURRENCY"`     // translate requests one free key may have in flight; 0 is uncapped
+	KeyConcurrencyPro int           `config:"KEY_CONCURRENCY_PRO"` // the same for a pro key
+
+	Maintenance           bool          `config:"MAINTENANCE"` // start with translate routes returning 503
+	MaintenanceMessage    string        `config:"MAINTENANCE_MESSAGE"`
+	MaintenanceRetryAfter time.Duration `config:"MAINTENANCE_RETRY_AFTER"`
+
+	DefaultSrc     string `config:"DEFAULT_SRC"` // direction when a request names neither language
+	DefaultDst     string `config:"DEFAULT_DST"`
+	DetectMinChars int    `config:"DETECT_MIN_CHARS"` // Latin input shorter than this (in letters) isn't auto-detected
+
+	PackDir       string `config:"PACK_DIR"`       // directory of *.jsonl / *.tsv translation packs, layered over the embedded ones
+	PackWatch     bool   `config:"PACK_WATCH"`     // reload packs when files in PackDir change (SIGHUP always reloads)
+	PacksEmbedded bool   `config:"PACKS_EMBEDDED"` // load the baseline packs built into the binary
+
+	// With a shared backend (CACHE_BACKEND=redis, else CACHE_DB_PATH), instances publish pack
+	// reloads and check for each other's this often; 0 turns it off. InstanceID names this
+	// instance in /go/admin/packs/status, defaulting to FLY_MACHINE_ID or the host name.
+	PackSyncInterval time.Duration `config:"PACK_SYNC_INTERVAL"`
+	InstanceID       string        `config:"INSTANCE_ID"`
+
+	// pack_only misses suggest pack and memory phrases at least this similar (0..1, trigram
+	// Dice), for input with at least SuggestMinChars letters. /go/admin/packs/check reports its
+	// nearest match under the same floor.
+	SuggestMinSimilarity float64 `config:"SUGGEST_MIN_SIMILARITY"`
+	SuggestMinChars      int     `config:"SUGGEST_MIN_CHARS"`
+	PackCheckMaxItems    int     `config:"PACK_CHECK_MAX_ITEMS"` // candidates per /go/admin/packs/check request
+	PackSearch           bool    `config:"PACK_SEARCH"`          // serve /go/packs/search, indexing the packs and memory for it
+
+	GlossaryPath           string `config:"GLOSSARY_PATH"`    // JSON glossary of protected terms; empty disables it
+	GlossaryDBPath         string `config:"GLOSSARY_DB_PATH"` // SQLite file for per-key glossaries; empty disables them
+	ClientGlossaryMaxTerms int    `config:"CLIENT_GLOSSARY_MAX_TERMS"`
+
+	RomanRulesFile string `config:"ROMAN_RULES_FILE"` // replaces the built-in romanize.json spelling rules for cache keys
+
+	TranslitTableFile    string `config:"TRANSLIT_TABLE_FILE"`    // replaces the built-in translit.json Thaana ↔ Latin table
+	TranslitCacheEntries int    `config:"TRANSLIT_CACHE_ENTRIES"` // recent transliterations kept; 0 disables the cache
+
+	MaxBodyBytes      int64  `config:"MAX_BODY_BYTES"`
+	MaxInputChars     int    `config:"MAX_INPUT_CHARS"` // code points in one q, after normalization
+	MaxInputCharsPro  int    `config:"MAX_INPUT_CHARS_PRO"`
+	BatchMaxChars     int    `config:"BATCH_MAX_CHARS"` // code points across a batch's items
+	BatchMaxCharsPro  int    `config:"BATCH_MAX_CHARS_PRO"`
+	NBestMax          int    `config:"NBEST_MAX"`           // cap on ?nbest; 0 turns alternatives off
+	InputControlChars string `config:"INPUT_CONTROL_CHARS"` // strip | reject: C0/C1 control characters in q
+	BatchMaxItems     int    `config:"BATCH_MAX_ITEMS"`
+	BatchMaxItemsPro  int    `config:"BATCH_MAX_ITEMS_PRO"`
+	BatchMaxBodyBytes int64  `config:"BATCH_MAX_BODY_BYTES"`
+	BatchConcurrency  int    `config:"BATCH_CONCURRENCY"`
+	SegmentSentences  bool   `config:"SEGMENT_SENTENCES"` // translate multi-sentence input one sentence at a time
+	SegmentWorkers    int    `config:"SEGMENT_CONCURRENCY"`
+	BulkMaxLines      int    `config:"BULK_MAX_LINES"` // per /go/translate/bulk upload
+	BulkMaxLineBytes  int    `config:"BULK_MAX_LINE_BYTES"`
+
+	JobsDBPath        string        `config:"JOBS_DB_PATH"` // SQLite file for /go/jobs; empty disables the job API
+	JobsWorkers       int           `config:"JOBS_WORKERS"`
+	JobsRetention     time.Duration `config:"JOBS_RETENTION"`   // how long finished jobs and their results are kept
+	JobsMaxItems      int           `config:"JOBS_MAX_ITEMS"`   // per job for pro keys; free keys get BatchMaxItems
+	JobsMaxPending    int           `config:"JOBS_MAX_PENDING"` // queued and running jobs per key
+	JobsMaxBodyBytes  int64         `config:"JOBS_MAX_BODY_BYTES"`
+	JobsInlineResults int           `config:"JOBS_INLINE_RESULTS"`
+	JobsItemTimeout   time.Duration `config:"JOBS_ITEM_TIMEOUT"`
+
+	JobsCallbackMaxAttempts  int           `config:"JOBS_CALLBACK_MAX_ATTEMPTS"`
+	JobsCallbackRetryBase    time.Duration `config:"JOBS_CALLBACK_RETRY_BASE"`
+	JobsCallbackTimeout      time.Duration `config:"JOBS_CALLBACK_TIMEOUT"`
+	JobsCallbackAllowPrivate bool          `config:"JOBS_CALLBACK_ALLOW_PRIVATE"` // lets callbacks reach private and loopback addresses, for testing
+
+	// Exports and job results are spooled to files in ExportSpoolDir and downloaded from there,
+	// resumably. One bigger than ExportInlineMaxBytes answers 202 with a download URL; 0 makes
+	// every export one. Files are removed ExportRetention after they are written.
+	ExportSpoolDir       string        `config:"EXPORT_SPOOL_DIR"`
+	ExportInlineMaxBytes int64         `config:"EXPORT_INLINE_MAX_BYTES"`
+	ExportRetention      time.Duration `config:"EXPORT_RETENTION"`
+
+	StreamMaxDuration time.Duration `config:"STREAM_MAX_DURATION"` // upper bound on one /go/translate/stream response
+	StreamKeepAlive   time.Duration `config:"STREAM_KEEPALIVE"`    // SSE comment interval so idle proxies keep the connection
+
+	WSMaxMessageBytes int64         `config:"WS_MAX_MESSAGE_BYTES"`
+	WSDebounce        time.Duration `config:"WS_DEBOUNCE"`
+	WSPingInterval    time.Duration `config:"WS_PING_INTERVAL"`
+	WSRatePerMin      int           `config:"WS_RATE_LIMIT_PER_MIN"`
+	WSRateBurst       int           `config:"WS_RATE_LIMIT_BURST"`
+
+	CacheBackend          string        `config:"CACHE_BACKEND"` // memory | redis
+	CacheMaxEntries       int           `config:"CACHE_MAX_ENTRIES"`
+	CacheMaxBytes         int64         `config:"CACHE_MAX_BYTES"`
+	CacheMaxEntryPercent  int           `config:"CACHE_MAX_ENTRY_PERCENT"` // share of CacheMaxBytes one entry may take before it bypasses the cache
+	CacheTTL              time.Duration `config:"CACHE_TTL"`
+	CacheStaleGrace       time.Duration `config:"CACHE_STALE_GRACE"` // how long past CACHE_TTL an entry is served stale while it refreshes; 0 disables
+	CachePolicy           cachePolicy   `config:"CACHE_POLICY"`      // per route and tier TTLs, or off; see cachepolicy.go
+	RedisURL              string        `config:"REDIS_URL,secret"`
+	RedisTimeout          time.Duration `config:"REDIS_TIMEOUT"`
+	CacheDBPath           string        `config:"CACHE_DB_PATH"`
+	CacheDBRetention      time.Duration `config:"CACHE_DB_RETENTION"`
+	CacheDBPruneInterval  time.Duration `config:"CACHE_DB_PRUNE_INTERVAL"`
+	CacheDBVacuumInterval time.Duration `config:"CACHE_DB_VACUUM_INTERVAL"`
+	TMDBPath              string        `config:"TM_DB_PATH"`           // SQLite translation memory learned from the upstream; empty disables it
+	WriteQueueSize        int           `config:"WRITE_QUEUE_SIZE"`     // writes each of the cache DB and TM queues holds before dropping
+	WriteBatchMax         int           `config:"WRITE_BATCH_MAX"`      // writes applied per transaction
+	WriteDrainTimeout     time.Duration `config:"WRITE_DRAIN_TIMEOUT"`  // how long shutdown waits for queued writes to land
+	CacheSeedPath         string        `config:"CACHE_SEED_PATH"`      // JSON lines loaded into the cache at startup, and written by /go/admin/cache/snapshot
+	NegativeCacheTTL      time.Duration `config:"NEGATIVE_CACHE_TTL"`   // how long an upstream 4xx is replayed without asking again; 0 disables
+	CacheWarmMaxItems     int           `config:"CACHE_WARM_MAX_ITEMS"` // phrases per /go/admin/cache/warm request
+	CacheWarmConcurrency  int           `config:"CACHE_WARM_CONCURRENCY"`
+	CacheWarmTimeout      time.Duration `config:"CACHE_WARM_TIMEOUT"`
+
+	APIKeys                string        `config:"API_KEYS,secret"`
+	APIKeysFile            string        `config:"API_KEYS_FILE"`
+	EdgeHMACSecret         string        `config:"EDGE_HMAC_SECRET,secret"`
+	EdgeHMACSecretPrevious string        `config:"EDGE_HMAC_SECRET_PREVIOUS,secret"`
+	EdgeMaxSkew            time.Duration `config:"EDGE_MAX_SKEW"`
+	EdgeNonceMax           int           `config:"EDGE_NONCE_MAX"`              // live X-Edge-Nonce values kept in memory before new ones are refused
+	ResponseSigningKey     string        `config:"RESPONSE_SIGNING_KEY,secret"` // HMAC key for X-Origin-Signature on translate responses; empty disables
+	CanonicalJSON          bool          `config:"CANONICAL_JSON"`              // re-encode /go/translate* responses as RFC 8785 JSON; see canonical.go
+	Faults                 faultRules    `config:"FAULTS"`                      // faults to inject for testing, never in production; see faults.go
+	JWTJWKSURL             *url.URL      `config:"JWT_JWKS_URL"`                // JWKS for Authorization: Bearer tokens
+	JWTPublicKey           string        `config:"JWT_PUBLIC_KEY"`              // PEM (inline or a file path) for bearer tokens, instead of a JWKS
+	JWTJWKSRefresh         time.Duration `config:"JWT_JWKS_REFRESH"`            // how often the JWKS is refetched
+	JWTIssuer              string        `config:"JWT_ISSUER"`                  // required "iss"; empty accepts any
+	JWTAudience            []string      `config:"JWT_AUDIENCE"`                // "aud" must name one of these; empty accepts any
+	JWTLeeway              time.Duration `config:"JWT_LEEWAY"`                  // clock skew allowed on exp and nbf
+	JWTTierClaim           string        `config:"JWT_TIER_CLAIM"`              // claim holding the caller's tier
+
+	RateLimitPerMin    int           `config:"RATE_LIMIT_PER_MIN"`
+	RateLimitBurst     int           `config:"RATE_LIMIT_BURST"`
+	RateLimitIdleTTL   time.Duration `config:"RATE_LIMIT_IDLE_TTL"`
+	RateLimitProPerMin int           `config:"RATE_LIMIT_PRO_PER_MIN"` // pro-tier keys; defaults to 10x the free limit
+	RateLimitProBurst  int           `config:"RATE_LIMIT_PRO_BURST"`
+
+	RateLimitIPPerMin     int           `config:"RATE_LIMIT_IP_PER_MIN"` // callers without an API key, per client IP
+	RateLimitIPBurst      int           `config:"RATE_LIMIT_IP_BURST"`
+	RateLimitIPMaxAddrs   int           `config:"RATE_LIMIT_IP_MAX_ADDRS"`   // IP buckets kept; the least recently seen address is dropped first
+	RateLimitHealthPerMin int           `config:"RATE_LIMIT_HEALTH_PER_MIN"` // /go/health, /go/version and the API docs, per client IP; 0 exempts them
+	RateLimitHealthBurst  int           `config:"RATE_LIMIT_HEALTH_BURST"`
+	RateLimitSearchPerMin int           `config:"RATE_LIMIT_SEARCH_PER_MIN"` // /go/packs/search, per key or client IP, instead of the translate limit
+	RateLimitSearchBurst  int           `config:"RATE_LIMIT_SEARCH_BURST"`
+	RetryAfterMax         time.Duration `config:"RETRY_AFTER_MAX"` // cap on the Retry-After of a 429; the body's reset_at stays exact
+
+	UsageFile          string        `config:"USAGE_FILE"` // JSON snapshot of per-key usage; empty keeps it in memory only
+	UsageFlushInterval time.Duration `config:"USAGE_FLUSH_INTERVAL"`
+	UsageDBPath        string        `config:"USAGE_DB_PATH"` // SQLite history behind /go/admin/reports; empty disables the reports
+	UsageRollupEvery   time.Duration `config:"USAGE_ROLLUP_INTERVAL"`
+	UsageDetailKeep    time.Duration `config:"USAGE_DETAIL_RETENTION"` // raw events are pruned after this; daily rows are kept
+	QuotaCharsFree     int           `config:"QUOTA_CHARS_FREE"`       // daily translated characters per key; 0 is unlimited
+	QuotaCharsPro      int           `config:"QUOTA_CHARS_PRO"`
+	QuotaGraceFree     int           `config:"QUOTA_GRACE_FREE"`   // percent past the quota still served, metered as overage; 0 stops at the quota
+	QuotaGracePro      int           `config:"QUOTA_GRACE_PRO"`    // 10 serves a pro key up to 110% of its quota
+	QuotaWarnPercent   int           `config:"QUOTA_WARN_PERCENT"` // share of the quota from which responses carry X-Quota-Warning; 0 never warns
+
+	StripeWebhookSecret string        `config:"STRIPE_WEBHOOK_SECRET,secret"` // whsec_... signing secret; empty disables /go/webhooks/stripe
+	StripeTolerance     time.Duration `config:"STRIPE_TOLERANCE"`             // max age of a signed webhook timestamp
+
+	IdempotencyTTL     time.Duration `config:"IDEMPOTENCY_TTL"`      // how long a POST response is replayed for its Idempotency-Key
+	IdempotencyMaxKeys int           `config:"IDEMPOTENCY_MAX_KEYS"` // keys remembered per caller; the oldest is evicted past this
+
+	CORSAllowedOrigins []string `config:"CORS_ALLOWED_ORIGINS"` // empty: no CORS headers at all
+
+	IPAllowlist []netip.Prefix `config:"IP_ALLOWLIST"` // when set, only these client addresses get through
+	IPDenylist  []netip.Prefix `config:"IP_DENYLIST"`  // refused even when allowlisted
+
+	TrustedProxies []netip.Prefix `config:"TRUSTED_PROXIES"` // peers whose X-Forwarded-For / CF-Connecting-IP are believed
+
+	Security securityHeaders `config:"-"` // SECURITY_* overrides; "off" drops a header
+
+	CompressLevel    int `config:"COMPRESS_LEVEL"`     // gzip level, -1 (default) to 9
+	BrotliQuality    int `config:"BROTLI_QUALITY"`     // brotli quality, 1 to 11; 0 offers gzip only
+	CompressMinBytes int `config:"COMPRESS_MIN_BYTES"` // responses smaller than this are sent uncompressed
+
+	AdminToken        string        `config:"ADMIN_TOKEN,secret"` // comma-separated "token" or "id:token"; empty disables /go/admin
+	MetricsToken      string        `config:"METRICS_TOKEN,secret"`
+	ReadyProbeTimeout time.Duration `config:"READY_PROBE_TIMEOUT"`
+	ReadyCacheTTL     time.Duration `config:"READY_CACHE_TTL"`
+
+	PageCursorSecret string `config:"PAGE_CURSOR_SECRET,secret"` // signs admin list cursors; empty picks one per process (see paging.go)
+
+	// The self-test; see selftest.go.
+	SelftestOnStart bool          `config:"SELFTEST_ON_START"` // run it once built; a failure keeps /go/ready at 503 for good
+	SelftestPhrase  string        `config:"SELFTEST_PHRASE"`   // the canary sent to each upstream
+	SelftestSrc     string        `config:"SELFTEST_SRC"`
+	SelftestDst     string        `config:"SELFTEST_DST"`
+	SelftestExpect  string        `config:"SELFTEST_EXPECT"` // the canary's translation must be this; empty takes any
+	SelftestTimeout time.Duration `config:"SELFTEST_TIMEOUT"`
+
+	UpstreamPollInterval time.Duration `config:"UPSTREAM_POLL_INTERVAL"` // background upstream health polls; 0 probes inline on each check
+	UpstreamPollTimeout  time.Duration `config:"UPSTREAM_POLL_TIMEOUT"`
+	UpstreamPollWindow   int           `config:"UPSTREAM_POLL_WINDOW"` // recent poll results kept for /go/health?verbose=1
+
+	// The outbound transport every upstream, JWKS and error report call shares; see outbound.go.
+	HTTPMaxIdleConnsPerHost   int           `config:"HTTP_MAX_IDLE_CONNS_PER_HOST"`
+	HTTPMaxConnsPerHost       int           `config:"HTTP_MAX_CONNS_PER_HOST"` // 0 is unlimited
+	HTTPIdleConnTimeout       time.Duration `config:"HTTP_IDLE_CONN_TIMEOUT"`
+	HTTPTLSHandshakeTimeout   time.Duration `config:"HTTP_TLS_HANDSHAKE_TIMEOUT"`
+	HTTPResponseHeaderTimeout time.Duration `config:"HTTP_RESPONSE_HEADER_TIMEOUT"` // 0 leaves it to UPSTREAM_TIMEOUT
+	HTTP2                     bool          `config:"HTTP2"`                        // negotiate HTTP/2 with TLS upstreams
+	HTTPDisableKeepAlives     bool          `config:"HTTP_DISABLE_KEEPALIVES"`      // a connection per request, for debugging
+
+	EgressAllowlist []string `config:"EGRESS_ALLOWLIST"` // IPs, CIDRs and host names outbound calls may reach though private; see egress.go
+
+	AuditLogPath     string `config:"AUDIT_LOG_PATH"`      // JSON lines, one per admin action; empty logs them only
+	AuditLogMaxBytes int    `config:"AUDIT_LOG_MAX_BYTES"` // size that rotates the file
+	AuditLogKeep     int    `config:"AUDIT_LOG_KEEP"`      // rotated files kept
+
+	SentryDSN              *url.URL      `config:"SENTRY_DSN,secret"`        // https://<key>@<host>/<project>; panics and upstream 5xx bursts are reported there
+	ErrorWebhookURL        *url.URL      `config:"ERROR_WEBHOOK_URL,secret"` // the same reports as JSON POSTs, for anything that isn't Sentry
+	ErrorReportPerMin      int           `config:"ERROR_REPORT_PER_MIN"`
+	ErrorReportBurst       int           `config:"ERROR_REPORT_BURST"` // upstream 5xx within ErrorReportBurstWindow worth one report
+	ErrorReportBurstWindow time.Duration `config:"ERROR_REPORT_BURST_WINDOW"`
+
+	TLSCertFile      string   `config:"TLS_CERT_FILE"` // with TLSKeyFile, serve HTTPS on PORT
+	TLSKeyFile       string   `config:"TLS_KEY_FILE"`
+	AutocertDomains  []string `config:"AUTOCERT_DOMAINS"`    // get Let's Encrypt certificates for these hosts instead of TLS_CERT_FILE
+	AutocertCacheDir string   `config:"AUTOCERT_CACHE_DIR"`  // where issued certificates and the ACME account key are kept
+	AutocertEmail    string   `config:"AUTOCERT_EMAIL"`      // contact for expiry notices; optional
+	AutocertHTTPPort string   `config:"AUTOCERT_HTTP_PORT"`  // plain-HTTP listener for ACME challenges, redirecting the rest to HTTPS
+	MTLSClientCAFile string   `config:"MTLS_CLIENT_CA_FILE"` // require client certificates issued by these CAs on the HTTPS listener
+	MTLSClientsFile  string   `config:"MTLS_CLIENTS_FILE"`   // JSON list mapping client certificate names to ids and tiers
+	HealthPort       string   `config:"HEALTH_PORT"`         // plain-HTTP listener serving only /go/health and /go/ready
+
+	ShutdownTimeout time.Duration `config:"SHUTDOWN_TIMEOUT"` // max time to drain in-flight requests
+	ShutdownDelay   time.Duration `config:"SHUTDOWN_DELAY"`   // time /go/ready reports draining before listeners close
+}
+
+// Translate modes (TRANSLATE_MODE). Without it the mode is proxy when an upstream is configured
+// and stub otherwise; STUB_MODE=true is the older spelling of stub.
+const (
+	modeStub     = "stub"      // echo the input back
+	modeProxy    = "proxy"     // packs, cache, then the upstream
+	modePackOnly = "pack_only" // packs and cache only; anything else is a 501, and no upstream is ever called
+)
+
+// Production reports whether ENV=production, which turns on the stricter requirements.
+func (c Config) Production() bool { return c.Env == "production" }
+
+// edgeSecrets returns the active edge HMAC secrets, current first.
+func (c Config) edgeSecrets() [][]byte {
+	var out [][]byte
+	for _, s := range []string{c.EdgeHMACSecret, c.EdgeHMACSecretPrevious} {
+		if s != "" {
+			out = append(out, []byte(s))
+		}
+	}
+	return out
+}
+
+// signingKey returns RESPONSE_SIGNING_KEY as bytes; nil leaves responses unsigned.
+func (c Config) signingKey() []byte {
+	if c.ResponseSigningKey == "" {
+		return nil
+	}
+	return []byte(c.ResponseSigningKey)
+}
+
+// redacted is c with the secrets cleared and credentials and queries dropped from URLs, for
+// fingerprint, the reload diff and /go/admin/config.
+func (c Config) redacted() Config {
+	v := reflect.ValueOf(&c).Elem()
+	for _, f := range configFields {
+		if f.secret {
+			v.Field(f.index).SetZero()
+		}
+	}
+	c.UpstreamURLs = slices.Clone(c.UpstreamURLs)
+	for i, u := range c.UpstreamURLs {
+		c.UpstreamURLs[i] = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+	}
+	if len(c.UpstreamURLs) > 0 {
+		c.UpstreamURL = c.UpstreamURLs[0]
+	}
+	if c.JWTJWKSURL != nil {
+		c.JWTJWKSURL = &url.URL{Scheme: c.JWTJWKSURL.Scheme, Host: c.JWTJWKSURL.Host, Path: c.JWTJWKSURL.Path}
+	}
+	if c.MirrorURL != nil {
+		c.MirrorURL = &url.URL{Scheme: c.MirrorURL.Scheme, Host: c.MirrorURL.Host, Path: c.MirrorURL.Path}
+	}
+	return c
+}
+
+// configField is what one Config field's tag says about it.
+type configField struct {
+	index  int
+	name   string // the Go field name
+	env    string // the variable it is read from; "-" when built from several
+	secret bool
+}
+
+// configFields describes Config in declaration order.
+var configFields = newConfigFields()
+
+func newConfigFields() []configField {
+	t := reflect.TypeOf(Config{})
+	out := make([]configField, t.NumField())
+	for i := range out {
+		sf := t.Field(i)
+		env, secret, _ := configTag(sf)
+		out[i] = configField{index: i, name: sf.Name, env: env, secret: secret}
+	}
+	return out
+}
+
+// configVar is one variable a Config field is read from, for the command line.
+type configVar struct {
+	env    string
+	secret bool
+	kind   reflect.Kind
+	index  []int // the field, for reflect.Value.FieldByIndex
+}
+
+// configVars lists every variable in declaration order. A field tagged "-" that is a struct,
+// such as Security, has its own fields tagged.
+var configVars = newConfigVars()
+
+func newConfigVars() []configVar {
+	var out []configVar
+	t := reflect.TypeOf(Config{})
+	for _, f := range configFields {
+		sf := t.Field(f.index)
+		if f.env == "" {
+			continue
+		}
+		if f.env != "-" {
+			out = append(out, configVar{env: f.env, secret: f.secret, kind: sf.Type.Kind(), index: sf.Index})
+			continue
+		}
+		if sf.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for i := 0; i < sf.Type.NumField(); i++ {
+			nf := sf.Type.Field(i)
+			env, secret, ok := configTag(nf)
+			if !ok {
+				continue
+			}
+			out = append(out, configVar{env: env, secret: secret, kind: nf.Type.Kind(), index: []int{f.index, i}})
+		}
+	}
+	return out
+}
+
+// configTag reads sf's config tag, config:"VAR" or config:"VAR,secret", reporting false when it
+// has no valid one. Such a field is read from no variable and redacted as a secret;
+// TestConfigFieldsTagged keeps every setting saying whether it is one.
+func configTag(sf reflect.StructField) (env string, secret, ok bool) {
+	tag, ok := sf.Tag.Lookup("config")
+	env, opt, _ := strings.Cut(tag, ",")
+	if !ok || env == "" || (opt != "" && opt != "secret") {
+		return "", true, false
+	}
+	return env, opt == "secret", true
+}
+
+// fingerprint is a short hash of the non-secret settings, for telling at a glance whether two
+// instances run the same configuration. Secrets, credentials in URLs and the build stamps are
+// left out, so rotating a key doesn't change it and nothing about a secret can be recovered.
+func (c Config) fingerprint() string {
+	c = c.redacted()
+	c.CommitSHA, c.BuildTime = "", ""
+	c.UpstreamURL = nil // the first of UpstreamURLs
+	b, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:6])
+}
+
+// features lists the optional parts of the service this configuration turns on, sorted.
+func (c Config) features() []string {
+	on := map[string]bool{
+		"grpc":              c.GRPCPort != "",
+		"h2c":               c.EnableH2C,
+		"unix_socket":       c.ListenSocket != "",
+		"stub":              c.TranslateMode == modeStub,
+		"stub_opt_in":       c.TranslateMode == modeProxy && c.StubOptIn,
+		"pack_only":         c.TranslateMode == modePackOnly,
+		"failover":          c.TranslateMode == modeProxy && len(c.UpstreamURLs) > 1,
+		"mirror":            c.TranslateMode == modeProxy && c.MirrorURL != nil && c.MirrorSampleRate > 0,
+		"upstream_extended": c.TranslateMode == modeProxy && c.UpstreamExtended != "",
+		"upstream_poll":     c.TranslateMode == modeProxy && c.UpstreamPollInterval > 0,
+		"breaker":           c.TranslateMode == modeProxy && c.BreakerFailures > 0,
+		"hedging":           c.TranslateMode == modeProxy && c.HedgeDelay > 0,
+		"adaptive_timeout":  c.TranslateMode == modeProxy && c.AdaptiveTimeout,
+		"debug":             c.EnableDebug,
+		"debug_headers":     c.DebugHeaders,
+		"provenance_pro":    c.ProvenancePro,
+		"api_docs":          c.EnableAPIDocs,
+		"brotli":            c.BrotliQuality > 0,
+		"packs":             c.PackDir != "" || c.PacksEmbedded,
+		"packs_embedded":    c.PacksEmbedded,
+		"suggestions":       c.TranslateMode == modePackOnly,
+		"pack_search":       (c.PackDir != "" || c.PacksEmbedded || c.TMDBPath != "") && c.PackSearch,
+		"pack_watch":        c.PackDir != "" && c.PackWatch,
+		"pack_sync":         (c.PackDir != "" || c.PacksEmbedded) && c.PackSyncInterval > 0 && (c.CacheBackend == "redis" || c.CacheDBPath != ""),
+		"glossary":          c.GlossaryPath != "",
+		"client_glossaries": c.GlossaryDBPath != "",
+		"segment_sentences": c.SegmentSentences,
+		"nbest":             c.NBestMax > 0,
+		"translit_cache":    c.TranslitCacheEntries > 0,
+		"jobs":              c.JobsDBPath != "",
+		"cache_db":          c.CacheDBPath != "",
+		"cache_seed":        c.CacheSeedPath != "",
+		"tm":                c.TMDBPath != "",
+		"negative_cache":    c.NegativeCacheTTL > 0,
+		"usage_reports":     c.UsageDBPath != "",
+		"upstream_record":   c.RecordUpstreamDir != "",
+		"upstream_replay":   c.ReplayUpstreamDir != "",
+		"stale_cache":       c.CacheStaleGrace > 0,
+		"api_keys":          c.APIKeys != "" || c.APIKeysFile != "",
+		"edge_auth":         c.EdgeHMACSecret != "",
+		"response_signing":  c.ResponseSigningKey != "",
+		"canonical_json":    c.CanonicalJSON,
+		"fault_injection":   len(c.Faults) > 0 && !c.Production(),
+		"jwt":               c.JWTJWKSURL != nil || c.JWTPublicKey != "",
+		"quotas":            c.QuotaCharsFree > 0 || c.QuotaCharsPro > 0,
+		"quota_grace":       c.QuotaCharsFree > 0 && c.QuotaGraceFree > 0 || c.QuotaCharsPro > 0 && c.QuotaGracePro > 0,
+		"shared_limits":     c.CacheBackend == "redis",
+		"selftest_on_start": c.SelftestOnStart,
+		"stripe_webhooks":   c.StripeWebhookSecret != "",
+		"cors":              len(c.CORSAllowedOrigins) > 0,
+		"ip_filter":         len(c.IPAllowlist) > 0 || len(c.IPDenylist) > 0,
+		"tls":               c.TLSCertFile != "",
+		"autocert":          len(c.AutocertDomains) > 0,
+		"mtls":              c.MTLSClientCAFile != "",
+		"admin":             c.AdminToken != "",
+		"audit_log":         c.AuditLogPath != "",
+		"access_log":        c.AccessLog != accessLogOff,
+		"sentry":            c.SentryDSN != nil,
+		"error_webhook":     c.ErrorWebhookURL != nil,
+		"statsd":            c.StatsdAddr != "",
+		"slow_request_log":  c.SlowRequestThreshold > 0,
+	}
+	var out []string
+	for name, ok := range on {
+		if ok {
+			out = append(out, name)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// LoadConfig reads Config from the environment. Every invalid variable is reported, not just the first.
+func LoadConfig(getenv func(string) string) (Config, error) {
+	e := &envReader{getenv: getenv}
+	c := Config{
+		Env:            e.oneOf("ENV", "development", "development", "production"),
+		Port:           e.str("PORT", "8080"),
+		GRPCPort:       e.str("GRPC_PORT", ""),
+		EnableH2C:      e.bool("ENABLE_H2C", false),
+		LogLevel:       e.oneOf("LOG_LEVEL", "info", "debug", "info", "warn", "error"),
+		LogHealthEvery: e.int("LOG_HEALTH_EVERY", 1, 0),
+		LogRequests:    e.bool("LOG_REQUESTS", true),
+		AccessLog:      e.oneOf("ACCESS_LOG_FORMAT", accessLogOff, accessLogOff, accessLogJSON, accessLogCommon, accessLogCombined),
+		AccessLogFile:  e.str("ACCESS_LOG_FILE", ""),
+
+		LogContent:      e.oneOf("LOG_CONTENT", logContentNone, logContentNone, logContentTruncated, logContentFull),
+		LogContentRunes: e.int("LOG_CONTENT_RUNES", 16, 1),
+
+		SlowRequestThreshold: e.durOrZero("SLOW_REQUEST_THRESHOLD", 2*time.Second),
+		SlowRequestSample:    e.int("SLOW_REQUEST_SAMPLE", 1, 1),
+
+		StatsdAddr:   e.str("STATSD_ADDR", ""),
+		StatsdPrefix: e.str("STATSD_PREFIX", "dhk_go."),
+		StatsdTags:   e.list("STATSD_TAGS"),
+		StatsdFlavor: e.oneOf("STATSD_FLAVOR", "dogstatsd", "dogstatsd", "statsd"),
+		StatsdBuffer: e.int("STATSD_BUFFER", 4096, 1),
+
+		ListenSocket:     e.str("LISTEN_SOCKET", ""),
+		ListenSocketMode: e.fileMode("LISTEN_SOCKET_MODE", 0o660),
+
+		CommitSHA: e.str("COMMIT_SHA", ""),
+		BuildTime: e.str("BUILD_TIME", ""),
+
+		ConfigFile: e.str("CONFIG_FILE", ""),
+
+		StubMode:            e.bool("STUB_MODE", false),
+		StubOptIn:           e.bool("STUB_OPT_IN", false),
+		UpstreamURL:         e.url("UPSTREAM_URL"),
+		UpstreamTimeout:     e.dur("UPSTREAM_TIMEOUT", 10*time.Second),
+		UpstreamMaxAttempts: e.int("UPSTREAM_MAX_ATTEMPTS", 3, 1),
+		UpstreamRetryBase:   e.dur("UPSTREAM_RETRY_BASE", 100*time.Millisecond),
+		HedgeDelay:          e.durOrZero("HEDGE_DELAY", 500*time.Millisecond),
+		UpstreamExtended:    e.str("UPSTREAM_EXTENDED_PATH", ""),
+		UpstreamStrict:      e.bool("UPSTREAM_STRICT_SCHEMA", false),
+		RecordUpstreamDir:   e.str("RECORD_UPSTREAM_DIR", ""),
+		ReplayUpstreamDir:   e.str("REPLAY_UPSTREAM_DIR", ""),
+		SharedCallTimeout:   e.dur("SHARED_CALL_TIMEOUT", 30*time.Second),
+		DebugHeaders:        e.bool("DEBUG_HEADERS", false),
+		ProvenancePro:       e.bool("PROVENANCE_PRO", false),
+		EnableDebug:         e.bool("ENABLE_DEBUG", false),
+		EnableAPIDocs:       e.bool("ENABLE_API_DOCS", false),
+		LegacyAPISunset:     e.date("LEGACY_API_SUNSET", time.Date(2027, time.April, 14, 0, 0, 0, 0, time.UTC)),
+		BreakerFailures:     e.int("BREAKER_FAILURES", 5, 0),
+		BreakerCooldown:     e.dur("BREAKER_COOLDOWN", 30*time.Second),
+
+		AdaptiveTimeout:         e.bool("UPSTREAM_ADAPTIVE_TIMEOUT", false),
+		AdaptiveTimeoutQuantile: e.fraction("UPSTREAM_ADAPTIVE_QUANTILE", 0.99),
+		AdaptiveTimeoutFactor:   e.float("UPSTREAM_ADAPTIVE_MULTIPLIER", 3, 1),
+		AdaptiveTimeoutSamples:  e.int("UPSTREAM_ADAPTIVE_MIN_SAMPLES", 100, 1),
+		AdaptiveTimeoutFloor:    e.dur("UPSTREAM_ADAPTIVE_FLOOR", 250*time.Millisecond),
+
+		MirrorURL:             e.url("MIRROR_UPSTREAM_URL"),
+		MirrorSampleRate:      e.fraction("MIRROR_SAMPLE_RATE", 0),
+		MirrorTimeout:         e.dur("MIRROR_TIMEOUT", 10*time.Second),
+		MirrorMaxInFlight:     e.int("MIRROR_MAX_INFLIGHT", 8, 1),
+		MirrorMaxConnShare:    e.fraction("MIRROR_MAX_CONN_SHARE", 0.25),
+		MirrorReportSamples:   e.int("MIRROR_REPORT_SAMPLES", 1000, 1),
+		MirrorAgreeSimilarity: e.fraction("MIRROR_AGREE_SIMILARITY", 0.8),
+
+		HealthTimeout:    e.dur("HEALTH_TIMEOUT", 2*time.Second),
+		TranslateTimeout: e.dur("TRANSLATE_TIMEOUT", 15*time.Second),
+		BatchTimeout:     e.dur("BATCH_TIMEOUT", 60*time.Second),
+		TranslitTimeout:  e.dur("TRANSLIT_TIMEOUT", time.Second),
+		DeadlineFloor:    e.dur("DEADLINE_FLOOR", 200*time.Millisecond),
+
+		MaxConcurrency:    e.int("MAX_CONCURRENCY", 64*runtime.GOMAXPROCS(0), 1),
+		ConcurrencyWait:   e.dur("CONCURRENCY_WAIT", 100*time.Millisecond),
+		KeyConcurrency:    e.int("KEY_CONCURRENCY", 2, 0),
+		KeyConcurrencyPro: e.int("KEY_CONCURRENCY_PRO", 10, 0),
+
+		Maintenance:           e.bool("MAINTENANCE", false),
+		MaintenanceMessage:    e.str("MAINTENANCE_MESSAGE", "translation is offline for maintenance; please retry shortly"),
+		MaintenanceRetryAfter: e.dur("MAINTENANCE_RETRY_AFTER", 5*time.Minute),
+
+		DefaultSrc:     e.oneOf("DEFAULT_SRC", langDhivehi, langDhivehi, langEnglish, langLatin),
+		DefaultDst:     e.oneOf("DEFAULT_DST", langEnglish, langDhivehi, langEnglish),
+		DetectMinChars: e.int("DETECT_MIN_CHARS", 12, 0),
+
+		PackDir:       e.str("PACK_DIR", ""),
+		PackWatch:     e.bool("PACK_WATCH", false),
+		PacksEmbedded: e.bool("PACKS_EMBEDDED", true),
+
+		PackSyncInterval: e.durOrZero("PACK_SYNC_INTERVAL", 15*time.Second),
+		InstanceID:       e.str("INSTANCE_ID", ""),
+
+		SuggestMinSimilarity: e.fraction("SUGGEST_MIN_SIMILARITY", 0.5),
+		SuggestMinChars:      e.int("SUGGEST_MIN_CHARS", 4, 1),
+		PackCheckMaxItems:    e.int("PACK_CHECK_MAX_ITEMS", 10000, 1),
+		PackSearch:           e.bool("PACK_SEARCH", true),
+
+		GlossaryPath:           e.str("GLOSSARY_PATH", ""),
+		GlossaryDBPath:         e.str("GLOSSARY_DB_PATH", ""),
+		ClientGlossaryMaxTerms: e.int("CLIENT_GLOSSARY_MAX_TERMS", 500, 1),
+
+		RomanRulesFile: e.str("ROMAN_RULES_FILE", ""),
+
+		TranslitTableFile:    e.str("TRANSLIT_TABLE_FILE", ""),
+		TranslitCacheEntries: e.int("TRANSLIT_CACHE_ENTRIES", 10_000, 0),
+
+		MaxBodyBytes:      int64(e.int("MAX_BODY_BYTES", 64<<10, 1)),
+		MaxInputChars:     e.int("MAX_INPUT_CHARS", 2000, 1),
+		MaxInputCharsPro:  e.int("MAX_INPUT_CHARS_PRO", 10_000, 1),
+		BatchMaxChars:     e.int("BATCH_MAX_CHARS", 20_000, 1),
+		BatchMaxCharsPro:  e.int("BATCH_MAX_CHARS_PRO", 200_000, 1),
+		NBestMax:          e.int("NBEST_MAX", 5, 0),
+		InputControlChars: e.oneOf("INPUT_CONTROL_CHARS", controlsStrip, controlsStrip, controlsReject),
+		BatchMaxItems:     e.int("BATCH_MAX_ITEMS", 100, 1),
+		BatchMaxItemsPro:  e.int("BATCH_MAX_ITEMS_PRO", 500, 1),
+		BatchMaxBodyBytes: int64(e.int("BATCH_MAX_BODY_BYTES", 1<<20, 1)),
+		BatchConcurrency:  e.int("BATCH_CONCURRENCY", 8, 1),
+		SegmentSentences:  e.bool("SEGMENT_SENTENCES", true),
+		SegmentWorkers:    e.int("SEGMENT_CONCURRENCY", 4, 1),
+		BulkMaxLines:      e.int("BULK_MAX_LINES", 10000, 1),
+		BulkMaxLineBytes:  e.int("BULK_MAX_LINE_BYTES", 8<<10, 64),
+
+		JobsDBPath:        e.str("JOBS_DB_PATH", ""),
+		JobsWorkers:       e.int("JOBS_WORKERS", 2, 1),
+		JobsRetention:     e.dur("JOBS_RETENTION", 24*time.Hour),
+		JobsMaxItems:      e.int("JOBS_MAX_ITEMS", 10000, 1),
+		JobsMaxPending:    e.int("JOBS_MAX_PENDING", 5, 1),
+		JobsMaxBodyBytes:  int64(e.int("JOBS_MAX_BODY_BYTES", 16<<20, 1)),
+		JobsInlineResults: e.int("JOBS_INLINE_RESULTS", 100, 0),
+		JobsItemTimeout:   e.dur("JOBS_ITEM_TIMEOUT", 30*time.Second),
+
+		JobsCallbackMaxAttempts:  e.int("JOBS_CALLBACK_MAX_ATTEMPTS", 6, 1),
+		JobsCallbackRetryBase:    e.dur("JOBS_CALLBACK_RETRY_BASE", 30*time.Second),
+		JobsCallbackTimeout:      e.dur("JOBS_CALLBACK_TIMEOUT", 10*time.Second),
+		JobsCallbackAllowPrivate: e.bool("JOBS_CALLBACK_ALLOW_PRIVATE", false),
+
+		ExportSpoolDir:       e.str("EXPORT_SPOOL_DIR", filepath.Join(os.TempDir(), "dhkalign-exports")),
+		ExportInlineMaxBytes: int64(e.int("EXPORT_INLINE_MAX_BYTES", 16<<20, 0)),
+		ExportRetention:      e.dur("EXPORT_RETENTION", 24*time.Hour),
+
+		StreamMaxDuration: e.dur("STREAM_MAX_DURATION", 5*time.Minute),
+		StreamKeepAlive:   e.dur("STREAM_KEEPALIVE", 15*time.Second),
+
+		WSMaxMessageBytes: int64(e.int("WS_MAX_MESSAGE_BYTES", 4<<10, 1)),
+		WSDebounce:        e.durOrZero("WS_DEBOUNCE", 150*time.Millisecond),
+		WSPingInterval:    e.dur("WS_PING_INTERVAL", 30*time.Second),
+		WSRatePerMin:      e.int("WS_RATE_LIMIT_PER_MIN", 600, 1),
+		WSRateBurst:       e.int("WS_RATE_LIMIT_BURST", 20, 1),
+
+		CacheBackend:          e.oneOf("CACHE_BACKEND", "memory", "memory", "redis"),
+		CacheMaxEntries:       e.int("CACHE_MAX_ENTRIES", 10000, 1),
+		CacheMaxBytes:         int64(e.int("CACHE_MAX_BYTES", 64<<20, 1<<20)),
+		CacheMaxEntryPercent:  e.int("CACHE_MAX_ENTRY_PERCENT", 1, 1),
+		CacheTTL:              e.dur("CACHE_TTL", 24*time.Hour),
+		CacheStaleGrace:       e.durOrZero("CACHE_STALE_GRACE", 0),
+		RedisURL:              e.str("REDIS_URL", ""),
+		RedisTimeout:          e.dur("REDIS_TIMEOUT", 100*time.Millisecond),
+		CacheDBPath:           e.str("CACHE_DB_PATH", ""),
+		CacheDBRetention:      e.dur("CACHE_DB_RETENTION", 30*24*time.Hour),
+		CacheDBPruneInterval:  e.dur("CACHE_DB_PRUNE_INTERVAL", time.Hour),
+		CacheDBVacuumInterval: e.dur("CACHE_DB_VACUUM_INTERVAL", 24*time.Hour),
+		TMDBPath:              e.str("TM_DB_PATH", ""),
+		WriteQueueSize:        e.int("WRITE_QUEUE_SIZE", 1024, 1),
+		WriteBatchMax:         e.int("WRITE_BATCH_MAX", 100, 1),
+		WriteDrainTimeout:     e.dur("WRITE_DRAIN_TIMEOUT", 5*time.Second),
+		CacheSeedPath:         e.str("CACHE_SEED_PATH", ""),
+		NegativeCacheTTL:      e.durOrZero("NEGATIVE_CACHE_TTL", time.Minute),
+		CacheWarmMaxItems:     e.int("CACHE_WARM_MAX_ITEMS", 1000, 1),
+		CacheWarmConcurrency:  e.int("CACHE_WARM_CONCURRENCY", 4, 1),
+		CacheWarmTimeout:      e.dur("CACHE_WARM_TIMEOUT", 2*time.Minute),
+
+		APIKeys:                e.str("API_KEYS", ""),
+		APIKeysFile:            e.str("API_KEYS_FILE", ""),
+		EdgeHMACSecret:         e.str("EDGE_HMAC_SECRET", ""),
+		EdgeHMACSecretPrevious: e.str("EDGE_HMAC_SECRET_PREVIOUS", ""),
+		EdgeMaxSkew:            e.dur("EDGE_MAX_SKEW", 5*time.Minute),
+		EdgeNonceMax:           e.int("EDGE_NONCE_MAX", 1_000_000, 1000),
+		ResponseSigningKey:     e.str("RESPONSE_SIGNING_KEY", ""),
+		CanonicalJSON:          e.bool("CANONICAL_JSON", false),
+		JWTJWKSURL:             e.url("JWT_JWKS_URL"),
+		JWTPublicKey:           e.str("JWT_PUBLIC_KEY", ""),
+		JWTJWKSRefresh:         e.dur("JWT_JWKS_REFRESH", 10*time.Minute),
+		JWTIssuer:              e.str("JWT_ISSUER", ""),
+		JWTAudience:            e.list("JWT_AUDIENCE"),
+		JWTLeeway:              e.durOrZero("JWT_LEEWAY", time.Minute),
+		JWTTierClaim:           e.str("JWT_TIER_CLAIM", "tier"),
+
+		RateLimitPerMin:  e.int("RATE_LIMIT_PER_MIN", 60, 1),
+		RateLimitIdleTTL: e.dur("RATE_LIMIT_IDLE_TTL", 10*time.Minute),
+
+		RateLimitIPMaxAddrs:   e.int("RATE_LIMIT_IP_MAX_ADDRS", 100_000, 1),
+		RateLimitHealthPerMin: e.int("RATE_LIMIT_HEALTH_PER_MIN", 600, 0),
+		RateLimitSearchPerMin: e.int("RATE_LIMIT_SEARCH_PER_MIN", 300, 1),
+		RetryAfterMax:         e.dur("RETRY_AFTER_MAX", time.Hour),
+
+		UsageFile:          e.str("USAGE_FILE", ""),
+		UsageFlushInterval: e.dur("USAGE_FLUSH_INTERVAL", time.Minute),
+		UsageDBPath:        e.str("USAGE_DB_PATH", ""),
+		UsageRollupEvery:   e.dur("USAGE_ROLLUP_INTERVAL", 15*time.Minute),
+		UsageDetailKeep:    e.dur("USAGE_DETAIL_RETENTION", 30*24*time.Hour),
+		QuotaCharsFree:     e.int("QUOTA_CHARS_FREE", 50_000, 0),
+		QuotaCharsPro:      e.int("QUOTA_CHARS_PRO", 1_000_000, 0),
+		QuotaGraceFree:     e.int("QUOTA_GRACE_FREE", 0, 0),
+		QuotaGracePro:      e.int("QUOTA_GRACE_PRO", 10, 0),
+		QuotaWarnPercent:   e.int("QUOTA_WARN_PERCENT", 80, 0),
+
+		StripeWebhookSecret: e.str("STRIPE_WEBHOOK_SECRET", ""),
+		StripeTolerance:     e.dur("STRIPE_TOLERANCE", 5*time.Minute),
+
+		IdempotencyTTL:     e.dur("IDEMPOTENCY_TTL", 24*time.Hour),
+		IdempotencyMaxKeys: e.int("IDEMPOTENCY_MAX_KEYS", 1000, 1),
+
+		CORSAllowedOrigins: e.list("CORS_ALLOWED_ORIGINS"),
+
+		IPAllowlist: e.prefixes("IP_ALLOWLIST"),
+		IPDenylist:  e.prefixes("IP_DENYLIST"),
+
+		CompressLevel:    e.int("COMPRESS_LEVEL", gzip.DefaultCompression, gzip.DefaultCompression),
+		BrotliQuality:    e.int("BROTLI_QUALITY", 5, 0),
+		CompressMinBytes: e.int("COMPRESS_MIN_BYTES", 1024, 0),
+
+		AdminToken:        e.str("ADMIN_TOKEN", ""),
+		MetricsToken:      e.str("METRICS_TOKEN", ""),
+		ReadyProbeTimeout: e.dur("READY_PROBE_TIMEOUT", 2*time.Second),
+		ReadyCacheTTL:     e.dur("READY_CACHE_TTL", 3*time.Second),
+
+		PageCursorSecret: e.str("PAGE_CURSOR_SECRET", ""),
+
+		SelftestOnStart: e.bool("SELFTEST_ON_START", false),
+		SelftestPhrase:  e.str("SELFTEST_PHRASE", "miadhu"),
+		SelftestSrc:     e.oneOf("SELFTEST_SRC", langLatin, langDhivehi, langEnglish, langLatin),
+		SelftestDst:     e.oneOf("SELFTEST_DST", langEnglish, langDhivehi, langEnglish),
+		SelftestExpect:  e.str("SELFTEST_EXPECT", ""),
+		SelftestTimeout: e.dur("SELFTEST_TIMEOUT", 10*time.Second),
+
+		UpstreamPollInterval: e.durOrZero("UPSTREAM_POLL_INTERVAL", 10*time.Second),
+		UpstreamPollTimeout:  e.dur("UPSTREAM_POLL_TIMEOUT", 2*time.Second),
+		UpstreamPollWindow:   e.int("UPSTREAM_POLL_WINDOW", 10, 1),
+
+		HTTPMaxIdleConnsPerHost:   e.int("HTTP_MAX_IDLE_CONNS_PER_HOST", 64, 1),
+		HTTPMaxConnsPerHost:       e.int("HTTP_MAX_CONNS_PER_HOST", 0, 0),
+		HTTPIdleConnTimeout:       e.dur("HTTP_IDLE_CONN_TIMEOUT", 90*time.Second),
+		HTTPTLSHandshakeTimeout:   e.dur("HTTP_TLS_HANDSHAKE_TIMEOUT", 5*time.Second),
+		HTTPResponseHeaderTimeout: e.durOrZero("HTTP_RESPONSE_HEADER_TIMEOUT", 0),
+		HTTP2:                     e.bool("HTTP2", true),
+		HTTPDisableKeepAlives:     e.bool("HTTP_DISABLE_KEEPALIVES", false),
+
+		EgressAllowlist: e.list("EGRESS_ALLOWLIST"),
+
+		AuditLogPath:     e.str("AUDIT_LOG_PATH", ""),
+		AuditLogMaxBytes: e.int("AUDIT_LOG_MAX_BYTES", 10<<20, 1024),
+		AuditLogKeep:     e.int("AUDIT_LOG_KEEP", 5, 0),
+
+		SentryDSN:              e.url("SENTRY_DSN"),
+		ErrorWebhookURL:        e.url("ERROR_WEBHOOK_URL"),
+		ErrorReportPerMin:      e.int("ERROR_REPORT_PER_MIN", 10, 1),
+		ErrorReportBurst:       e.int("ERROR_REPORT_BURST", 5, 1),
+		ErrorReportBurstWindow: e.dur("ERROR_REPORT_BURST_WINDOW", time.Minute),
+
+		TLSCertFile:      e.str("TLS_CERT_FILE", ""),
+		TLSKeyFile:       e.str("TLS_KEY_FILE", ""),
+		AutocertDomains:  e.list("AUTOCERT_DOMAINS"),
+		AutocertCacheDir: e.str("AUTOCERT_CACHE_DIR", "autocert-cache"),
+		AutocertEmail:    e.str("AUTOCERT_EMAIL", ""),
+		AutocertHTTPPort: e.str("AUTOCERT_HTTP_PORT", "80"),
+		MTLSClientCAFile: e.str("MTLS_CLIENT_CA_FILE", ""),
+		MTLSClientsFile:  e.str("MTLS_CLIENTS_FILE", ""),
+		HealthPort:       e.str("HEALTH_PORT", ""),
+
+		ShutdownTimeout: e.dur("SHUTDOWN_TIMEOUT", 15*time.Second),
+		ShutdownDelay:   e.durOrZero("SHUTDOWN_DELAY", 0),
+	}
+	c.Security = securityHeaders{
+		ContentTypeOptions: e.header("SECURITY_CONTENT_TYPE_OPTIONS", "nosniff"),
+		FrameOptions:       e.header("SECURITY_FRAME_OPTIONS", "DENY"),
+		ReferrerPolicy:     e.header("SECURITY_REFERRER_POLICY", "no-referrer"),
+		HSTS:               e.header("SECURITY_HSTS", "max-age=31536000; includeSubDomains"),
+		HSTSAlways:         e.bool("SECURITY_HSTS_BEHIND_HTTPS", c.Production()),
+		CSP:                e.header("SECURITY_CSP", "default-src 'none'; frame-ancestors 'none'"),
+	}
+	switch v := e.str("TRUSTED_PROXIES", ""); strings.ToLower(v) {
+	case "":
+		c.TrustedProxies, _ = parsePrefixes(strings.Join(defaultTrustedProxies, ","))
+	case "none":
+	default:
+		c.TrustedProxies = e.prefixes("TRUSTED_PROXIES")
+	}
+	c.ConcurrencyQueue = e.int("CONCURRENCY_QUEUE", c.MaxConcurrency, 0)
+	c.QueueWaitMax = e.dur("QUEUE_WAIT_MAX", c.ConcurrencyWait)
+	c.RateLimitBurst = e.int("RATE_LIMIT_BURST", c.RateLimitPerMin, 1)
+	c.RateLimitProPerMin = e.int("RATE_LIMIT_PRO_PER_MIN", 10*c.RateLimitPerMin, 1)
+	c.RateLimitProBurst = e.int("RATE_LIMIT_PRO_BURST", c.RateLimitProPerMin, 1)
+	c.RateLimitIPPerMin = e.int("RATE_LIMIT_IP_PER_MIN", c.RateLimitPerMin, 1)
+	c.RateLimitIPBurst = e.int("RATE_LIMIT_IP_BURST", c.RateLimitIPPerMin, 1)
+	c.RateLimitHealthBurst = e.int("RATE_LIMIT_HEALTH_BURST", max(c.RateLimitHealthPerMin, 1), 1)
+	c.RateLimitSearchBurst = e.int("RATE_LIMIT_SEARCH_BURST", max(c.RateLimitSearchPerMin/5, 1), 1)
+
+	if !pairSupported(langPair{c.DefaultSrc, c.DefaultDst}) {
+		e.fail("DEFAULT_DST", fmt.Sprintf("%s→%s is not a supported pair", c.DefaultSrc, c.DefaultDst))
+	}
+	if !pairSupported(langPair{c.SelftestSrc, c.SelftestDst}) {
+		e.fail("SELFTEST_DST", fmt.Sprintf("%s→%s is not a supported pair", c.SelftestSrc, c.SelftestDst))
+	}
+	if c.CompressLevel > gzip.BestCompression {
+		e.fail("COMPRESS_LEVEL", fmt.Sprintf("%d is not a gzip level (-1..9)", c.CompressLevel))
+	}
+	if c.BrotliQuality > 11 {
+		e.fail("BROTLI_QUALITY", fmt.Sprintf("%d is not a brotli quality (0 for off, 1..11)", c.BrotliQuality))
+	}
+	if c.CacheMaxEntryPercent > 100 {
+		e.fail("CACHE_MAX_ENTRY_PERCENT", fmt.Sprintf("%d is not a percentage (1..100)", c.CacheMaxEntryPercent))
+	}
+	if c.QuotaWarnPercent > 100 {
+		e.fail("QUOTA_WARN_PERCENT", fmt.Sprintf("%d is not a percentage (0 for off, 1..100)", c.QuotaWarnPercent))
+	}
+	if c.CacheBackend == "redis" && c.RedisURL == "" {
+		e.fail("REDIS_URL", "required when CACHE_BACKEND=redis")
+	}
+	if c.JWTJWKSURL != nil && c.JWTPublicKey != "" {
+		e.fail("JWT_PUBLIC_KEY", "cannot be combined with JWT_JWKS_URL")
+	}
+	if c.SentryDSN != nil && !validSentryDSN(c.SentryDSN) {
+		e.fail("SENTRY_DSN", "must look like https://<key>@<host>/<project>")
+	}
+	if c.ListenSocket != "" {
+		if e.str("PORT", "") != "" {
+			e.fail("LISTEN_SOCKET", "cannot be combined with PORT; unset PORT to listen only on the socket")
+		}
+		if c.TLSCertFile != "" || len(c.AutocertDomains) > 0 {
+			e.fail("LISTEN_SOCKET", "cannot be combined with TLS; the proxy in front terminates it")
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		e.fail("TLS_CERT_FILE", "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.MTLSClientCAFile != "" && c.TLSCertFile == "" && len(c.AutocertDomains) == 0 {
+		e.fail("MTLS_CLIENT_CA_FILE", "requires TLS_CERT_FILE or AUTOCERT_DOMAINS")
+	}
+	if c.ResponseSigningKey != "" && !c.CanonicalJSON {
+		e.fail("RESPONSE_SIGNING_KEY", "requires CANONICAL_JSON=true, so signatures can be recomputed from the content")
+	}
+	if c.MTLSClientsFile != "" && c.MTLSClientCAFile == "" {
+		e.fail("MTLS_CLIENTS_FILE", "requires MTLS_CLIENT_CA_FILE")
+	}
+	if c.HealthPort != "" && (c.HealthPort == c.Port || (c.HealthPort == c.AutocertHTTPPort && len(c.AutocertDomains) > 0)) {
+		e.fail("HEALTH_PORT", c.HealthPort+" is already in use by PORT or AUTOCERT_HTTP_PORT")
+	}
+	if len(c.AutocertDomains) > 0 && c.TLSCertFile != "" {
+		e.fail("AUTOCERT_DOMAINS", "cannot be combined with TLS_CERT_FILE")
+	}
+	c.UpstreamURLs, c.UpstreamWeights = e.weightedURLs("UPSTREAM_URLS")
+	if c.ReplayUpstreamDir != "" && c.UpstreamURL == nil && len(c.UpstreamURLs) == 0 {
+		// Fixtures match on path and query alone, so replay needs no real host.
+		c.UpstreamURL = &url.URL{Scheme: "http", Host: "upstream.replay"}
+	}
+	if c.RecordUpstreamDir != "" && c.ReplayUpstreamDir != "" {
+		e.fail("RECORD_UPSTREAM_DIR", "cannot be combined with REPLAY_UPSTREAM_DIR")
+	}
+	switch {
+	case len(c.UpstreamURLs) > 0 && c.UpstreamURL != nil:
+		e.fail("UPSTREAM_URLS", "cannot be combined with UPSTREAM_URL")
+	case len(c.UpstreamURLs) > 0:
+		c.UpstreamURL = c.UpstreamURLs[0]
+	case c.UpstreamURL != nil:
+		c.UpstreamURLs, c.UpstreamWeights = []*url.URL{c.UpstreamURL}, []int64{0}
+	}
+	hosts := map[string]bool{}
+	for _, u := range c.UpstreamURLs {
+		if hosts[u.Host] {
+			e.fail("UPSTREAM_URLS", fmt.Sprintf("%s is listed twice", u.Host))
+		}
+		hosts[u.Host] = true
+	}
+	if c.UpstreamURL != nil && c.StubMode {
+		e.fail("STUB_MODE", "cannot be combined with UPSTREAM_URL")
+	}
+	explicitMode := e.getenv("TRANSLATE_MODE") != ""
+	if explicitMode {
+		c.TranslateMode = e.oneOf("TRANSLATE_MODE", modeProxy, modeStub, modeProxy, modePackOnly)
+	}
+	switch {
+	case c.StubMode && explicitMode && c.TranslateMode != modeStub:
+		e.fail("STUB_MODE", "cannot be combined with TRANSLATE_MODE="+c.TranslateMode)
+	case c.TranslateMode == modeProxy && c.UpstreamURL == nil:
+		e.fail("TRANSLATE_MODE", "proxy needs UPSTREAM_URL or UPSTREAM_URLS")
+	case !explicitMode && c.UpstreamURL != nil:
+		c.TranslateMode = modeProxy
+	case !explicitMode:
+		c.TranslateMode = modeStub
+	}
+	if cp, err := parseCachePolicy(e.getenv("CACHE_POLICY"), c.CacheTTL); err != nil {
+		e.fail("CACHE_POLICY", err.Error())
+	} else {
+		c.CachePolicy = cp
+	}
+	if fs, err := parseFaults(e.getenv("FAULTS")); err != nil {
+		e.fail("FAULTS", err.Error())
+	} else {
+		c.Faults = fs
+	}
+	if ff, err := parseFeatureFlags(e.getenv("FEATURE_FLAGS")); err != nil {
+		e.fail("FEATURE_FLAGS", err.Error())
+	} else {
+		c.FeatureFlags = ff
+	}
+	if c.UsageDetailKeep < 24*time.Hour {
+		e.fail("USAGE_DETAIL_RETENTION", "must be at least 24h, so a day's events are rolled up before they go")
+	}
+	if c.StatsdAddr != "" {
+		if _, port, err := net.SplitHostPort(c.StatsdAddr); err != nil || port == "" {
+			e.fail("STATSD_ADDR", fmt.Sprintf("%q is not host:port", c.StatsdAddr))
+		}
+	}
+	if _, _, err := parseEgressAllowlist(c.EgressAllowlist); err != nil {
+		e.fail("EGRESS_ALLOWLIST", err.Error())
+	} else {
+		egress := newEgressPolicy(c)
+		for _, v := range []struct {
+			key string
+			u   *url.URL
+		}{{"JWT_JWKS_URL", c.JWTJWKSURL}, {"SENTRY_DSN", c.SentryDSN}, {"ERROR_WEBHOOK_URL", c.ErrorWebhookURL}} {
+			if v.u == nil {
+				continue
+			}
+			if err := egress.checkURL(v.u); err != nil {
+				e.fail(v.key, err.Error())
+			}
+		}
+	}
+	if c.Production() {
+		if c.UpstreamURL == nil && !c.StubMode && !explicitMode {
+			e.fail("UPSTREAM_URL", "required when ENV=production (or set TRANSLATE_MODE=stub or pack_only)")
+		}
+		if c.JobsCallbackAllowPrivate {
+			e.fail("JOBS_CALLBACK_ALLOW_PRIVATE", "not allowed when ENV=production")
+		}
+		if c.StubOptIn {
+			e.fail("STUB_OPT_IN", "not allowed when ENV=production")
+		}
+		if c.RecordUpstreamDir != "" {
+			e.fail("RECORD_UPSTREAM_DIR", "not allowed when ENV=production")
+		}
+		if c.ReplayUpstreamDir != "" {
+			e.fail("REPLAY_UPSTREAM_DIR", "not allowed when ENV=production")
+		}
+	}
+	return c, e.err()
+}
+
+// configError lists every invalid variable.
+type configError struct {
+	problems []string
+}
+
+func (e *configError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.problems, "\n  ")
+}
+
+// envReader parses typed values and accumulates one message per bad variable.
+type envReader struct {
+	getenv func(string) string
+	errs   []string
+}
+
+func (e *envReader) fail(key, msg string) { e.errs = append(e.errs, key+": "+msg) }
+
+func (e *envReader) err() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return &configError{problems: e.errs}
+}
+
+func (e *envReader) str(key, def string) string {
+	if v := strings.TrimSpace(e.getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// list splits a comma-separated value, dropping empty items.
+// header reads a response header value; "off" turns the header off.
+func (e *envReader) header(key, def string) string {
+	if v := e.str(key, def); v != "off" {
+		return v
+	}
+	return ""
+}
+
+func (e *envReader) list(key string) []string {
+	var out []string
+	for _, v := range strings.Split(e.getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (e *envReader) prefixes(key string) []netip.Prefix {
+	p, err := parsePrefixes(e.getenv(key))
+	if err != nil {
+		e.fail(key, err.Error())
+	}
+	return p
+}
+
+func (e *envReader) oneOf(key, def string, allowed ...string) string {
+	v := strings.ToLower(e.str(key, def))
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	e.fail(key, fmt.Sprintf("%q is not one of %s", v, strings.Join(allowed, "|")))
+	return def
+}
+
+func (e *envReader) int(key string, def, min int) int {
+	raw := e.str(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < min {
+		e.fail(key, fmt.Sprintf("%q is not an integer >= %d", raw, min))
+		return def
+	}
+	return v
+}
+
+// fraction reads a number in (0, 1].
+func (e *envReader) fraction(key string, def float64) float64 {
+	raw := e.str(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v <= 0 || v > 1 {
+		e.fail(key, fmt.Sprintf("%q is not a number in (0, 1]", raw))
+		return def
+	}
+	return v
+}
+
+// float reads a number >= min.
+func (e *envReader) float(key string, def, min float64) float64 {
+	raw := e.str(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || !(v >= min) {
+		e.fail(key, fmt.Sprintf("%q is not a number >= %g", raw, min))
+		return def
+	}
+	return v
+}
+
+func (e *envReader) bool(key string, def bool) bool {
+	raw := e.str(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		e.fail(key, fmt.Sprintf("%q is not a boolean", raw))
+		return def
+	}
+	return v
+}
+
+// fileMode reads octal permissions such as 0660.
+func (e *envReader) fileMode(key string, def os.FileMode) os.FileMode {
+	raw := e.str(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseUint(raw, 8, 32)
+	if err != nil || v > 0o777 {
+		e.fail(key, fmt.Sprintf("%q is not an octal file mode (e.g. 0660)", raw))
+		return def
+	}
+	return os.FileMode(v)
+}
+
+func (e *envReader) dur(key string, def time.Duration) time.Duration {
+	raw := e.str(key, "")
+	if raw == "" {
+		return def
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil || v <= 0 {
+		e.fail(key, fmt.Sprintf("%q is not a positive duration (e.g. 10s, 5m)", raw))
+		return def
+	}
+	return v
+}
+
+// durOrZero is dur but also accepts "0" to disable.
+func (e *envReader) durOrZero(key string, def time.Duration) time.Duration {
+	if e.str(key, "") == "0" {
+		return 0
+	}
+	return e.dur(key, def)
+}
+
+// date reads a date (2027-04-14) or an RFC 3339 time; "off" is the zero time.
+func (e *envReader) date(key string, def time.Time) time.Time {
+	raw := e.str(key, "")
+	switch raw {
+	case "":
+		return def
+	case "off":
+		return time.Time{}
+	}
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if v, err := time.Parse(layout, raw); err == nil {
+			return v
+		}
+	}
+	e.fail(key, fmt.Sprintf("%q is not a date (e.g. 2027-04-14) or an RFC 3339 time", raw))
+	return def
+}
+
+func (e *envReader) url(key string) *url.URL {
+	raw := e.str(key, "")
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e.fail(key, fmt.Sprintf("%q is not an http(s) URL", raw))
+		return nil
+	}
+	return u
+}
+
+// weightedURLs reads a comma-separated list of http(s) URLs, each optionally followed by
+// "=weight". Either every entry has a weight or none does, when all weights are 0.
+func (e *envReader) weightedURLs(key string) ([]*url.URL, []int64) {
+	var (
+		urls     []*url.URL
+		weights  []int64
+		weighted int
+	)
+	for _, raw := range e.list(key) {
+		var wt int64
+		if i := strings.LastIndex(raw, "="); i > 0 {
+			n, err := strconv.ParseInt(raw[i+1:], 10, 64)
+			if err == nil && n >= 0 {
+				raw, wt = raw[:i], n
+				weighted++
+			}
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			e.fail(key, fmt.Sprintf("%q is not an http(s) URL", raw))
+			continue
+		}
+		urls, weights = append(urls, u), append(weights, wt)
+	}
+	if weighted > 0 && weighted != len(urls) {
+		e.fail(key, "give every upstream a weight (url=weight) or none")
+	}
+	return urls, weights
+}
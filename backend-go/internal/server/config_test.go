@@ -0,0 +1,37 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestConfigFieldsTagged holds every Config field, and every field of the structs tagged "-"
+// such as Security, to a config:"VAR" or config:"VAR,secret" tag, so each setting says whether
+// it is a secret and no two read the same variable.
+func TestConfigFieldsTagged(t *testing.T) {
+	seen := map[string]string{}
+	var check func(typ reflect.Type, prefix string)
+	check = func(typ reflect.Type, prefix string) {
+		for i := range typ.NumField() {
+			sf := typ.Field(i)
+			name := prefix + sf.Name
+			env, _, ok := configTag(sf)
+			switch {
+			case !ok:
+				t.Errorf(`%s needs a config:"VAR" or config:"VAR,secret" tag, has %q`, name, sf.Tag.Get("config"))
+			case env == "-":
+				if sf.Type.Kind() == reflect.Struct {
+					check(sf.Type, name+".")
+				}
+			case seen[env] != "":
+				t.Errorf("%s and %s both read %s", seen[env], name, env)
+			default:
+				seen[env] = name
+			}
+		}
+	}
+	check(reflect.TypeOf(Config{}), "Config.")
+	if len(configVars) != len(seen) {
+		t.Errorf("%d variables listed for the command line, want %d", len(configVars), len(seen))
+	}
+}
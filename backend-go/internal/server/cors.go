@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	corsAllowMethods  = "GET, POST, OPTIONS"
+	corsAllowHeaders  = "Content-Type, X-API-Key, X-Request-ID, X-DHK-Stub"
+	corsExposeHeaders = "X-Request-ID, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After, " +
+		"X-Quota-Warning, X-Quota-Remaining, X-Quota-Grace-Remaining, X-Quota-Reset, Warning"
+)
+
+// cors allows browser calls from the listed origins. "*" must be listed explicitly to allow any
+// origin. Origins not on the list get no Access-Control-Allow-Origin at all, and preflight
+// requests are answered here with 204 without reaching the router.
+func cors(origins []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[strings.TrimRight(o, "/")] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			ok := allowed[origin] || allowed["*"]
+			if ok {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			}
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				if ok {
+					h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+					h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+					h.Set("Access-Control-Max-Age", "600")
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
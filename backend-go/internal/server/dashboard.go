@@ -0,0 +1,47 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Dashboard. GET /go/admin/dashboard is one page for operators without Grafana: uptime, request
+// rate, latency, the cache, the upstreams and their breakers, dependencies, packs and flags,
+// refreshed every few seconds. Everything it shows comes from the JSON routes that already exist
+// (/go/stats, /go/health?verbose=1, /go/admin/upstreams and /go/admin/flags), fetched with the
+// admin token, so the page collects nothing of its own; a subsystem that is off shows as such.
+//
+// A browser can't send a bearer token when it navigates, so the page and its assets are served
+// without one: they are static, hold no data, and the page asks for the token, keeping it in the
+// tab's sessionStorage. They are only routed when ADMIN_TOKEN is set, and load nothing from
+// outside the service.
+
+//go:embed dashboard
+var dashboardFS embed.FS
+
+// dashboardCSP allows the page's own script, stylesheet and same-origin fetches, nothing else.
+const dashboardCSP = "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; img-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+// dashboardRoutes serves the page at /go/admin/dashboard and its assets under it.
+func dashboardRoutes(r chi.Router) {
+	files, _ := fs.Sub(dashboardFS, "dashboard")
+	serve := func(w http.ResponseWriter, name string) {
+		b, err := fs.ReadFile(files, name)
+		if err != nil {
+			notFound(w, nil)
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Security-Policy", dashboardCSP)
+		h.Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+		h.Set("Cache-Control", "no-cache")
+		_, _ = w.Write(b)
+	}
+	r.Get("/go/admin/dashboard", func(w http.ResponseWriter, _ *http.Request) { serve(w, "index.html") })
+	r.Get("/go/admin/dashboard/{file}", func(w http.ResponseWriter, r *http.Request) { serve(w, chi.URLParam(r, "file")) })
+}
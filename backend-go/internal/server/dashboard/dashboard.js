@@ -0,0 +1,189 @@
+// The dashboard only reads the existing admin JSON: /go/stats and /go/health?verbose=1 every
+// few seconds, and the audited /go/admin/upstreams and /go/admin/flags less often, so an open
+// page adds an audit entry a minute rather than one per refresh. The token stays in this tab's
+// sessionStorage and goes out as a bearer header, never in a URL.
+"use strict";
+
+const FAST_MS = 5000;
+const SLOW_MS = 30000;
+const TOKEN = "dhk-admin-token";
+
+const $ = (id) => document.getElementById(id);
+let timer = null;
+let lastSlow = 0;
+let prev = null; // the last /go/stats, for requests per second
+let upstreams = null; // the last /go/admin/upstreams; null while unknown, [] without an upstream
+let flags = null;
+
+class Unauthorized extends Error {}
+
+async function get(path, optional) {
+  const res = await fetch(path, {
+    headers: { Authorization: "Bearer " + sessionStorage.getItem(TOKEN), Accept: "application/json" },
+    cache: "no-store",
+  });
+  if (res.status === 401) throw new Unauthorized();
+  if (optional && res.status === 404) return null; // the feature isn't configured
+  if (!res.ok) throw new Error(path + ": HTTP " + res.status);
+  return res.json();
+}
+
+function text(id, value, cls) {
+  const el = $(id);
+  el.textContent = value;
+  el.className = cls || "";
+}
+
+function row(tbody, cells) {
+  const tr = document.createElement("tr");
+  for (const c of cells) {
+    const td = document.createElement("td");
+    if (Array.isArray(c)) {
+      td.textContent = c[0];
+      td.className = c[1];
+    } else {
+      td.textContent = c;
+    }
+    tr.appendChild(td);
+  }
+  tbody.appendChild(tr);
+}
+
+function fill(id, rows) {
+  const tbody = $(id);
+  tbody.replaceChildren();
+  rows.forEach((r) => row(tbody, r));
+}
+
+function duration(s) {
+  const d = Math.floor(s / 86400);
+  const h = Math.floor((s % 86400) / 3600);
+  const m = Math.floor((s % 3600) / 60);
+  return d > 0 ? `${d}d ${h}h` : h > 0 ? `${h}h ${m}m` : `${m}m ${s % 60}s`;
+}
+
+const num = (v, digits) => (typeof v === "number" ? v.toFixed(digits) : "—");
+const ms = (v) => num(v, v < 10 ? 1 : 0);
+const pct = (v) => (typeof v === "number" ? (v * 100).toFixed(1) + "%" : "—");
+
+function renderStats(s) {
+  text("uptime", duration(s.uptime_s));
+  if (prev && s.uptime_s > prev.uptime_s) {
+    const secs = (Date.parse(s.ts) - Date.parse(prev.ts)) / 1000 || FAST_MS / 1000;
+    text("rps", num(Math.max(0, s.requests.total - prev.requests.total) / secs, 1));
+  }
+  prev = s;
+  text("total", s.requests.total + " since start");
+  const l = s.latency_ms || {};
+  text("latency", `${ms(l.p50)} / ${ms(l.p95)} / ${ms(l.p99)} ms`);
+
+  const c = s.cache || {};
+  if (c.error) {
+    text("hits", "unavailable", "bad");
+    text("cache", c.error);
+  } else {
+    const looked = (c.hits || 0) + (c.misses || 0);
+    text("hits", looked > 0 ? pct(c.hits / looked) : "—");
+    text("cache", [c.backend, c.entries != null ? c.entries + " entries" : ""].filter(Boolean).join(", "));
+  }
+  const q = s.concurrency || {};
+  text("inflight", q.limit ? `${q.in_flight} / ${q.limit}` : "—", q.saturated ? "warn" : "");
+  text("shed", q.limit ? `${q.queued} queued, ${q.shed} shed` : "no limit");
+}
+
+function renderHealth(h) {
+  const deps = Object.entries(h.dependencies || {}).sort(([a], [b]) => a.localeCompare(b));
+  const down = deps.filter(([, d]) => !d.ok).length;
+  const m = h.maintenance || {};
+  text("status", m.enabled ? "maintenance" : down > 0 ? `${down} down` : "ok", m.enabled ? "pill warn" : down > 0 ? "pill bad" : "pill ok");
+  text("maint", m.enabled ? m.message || "on" : "off", m.enabled ? "warn" : "");
+  fill("deps", deps.length ? deps.map(([name, d]) => [name, d.ok ? ["ok", "ok"] : [d.error || "down", "bad"], num(d.latency_ms, 1) + " ms"]) : [["none", "", ""]]);
+
+  const p = h.packs || {};
+  if (!p.enabled) text("packs", "disabled", "muted");
+  else text("packs", `${p.entries} entries in ${(p.files || []).length} files` + (p.status && p.status.error ? ` — last reload failed: ${p.status.error}` : ""), p.status && p.status.error ? "warn" : "");
+  renderUpstreams(h.upstream_poll || {});
+}
+
+function renderUpstreams(polls) {
+  if (upstreams === null) return;
+  if (upstreams.length === 0) {
+    fill("upstreams", [["no upstream in this mode", "", "", "", "", "", ""]]);
+    return;
+  }
+  fill("upstreams", upstreams.map((u) => {
+    const poll = polls[u.name];
+    return [
+      u.name,
+      u.healthy ? ["healthy", "ok"] : ["unhealthy", "bad"],
+      u.breaker ? [u.breaker, u.breaker === "closed" ? "ok" : "warn"] : ["off", "muted"],
+      u.weight,
+      u.ok + u.failed > 0 ? pct(u.success_rate) : "—",
+      `${ms(u.latency_ms.p50)} / ${ms(u.latency_ms.p95)}`,
+      !poll ? ["—", "muted"]
+        : poll.paused ? ["paused", "muted"]
+        : poll.ok === undefined ? ["not polled yet", "muted"]
+        : [`${poll.ok ? "ok" : poll.error || "failing"} (${poll.window_ok}/${poll.window})`, poll.ok ? "ok" : "bad"],
+    ];
+  }));
+}
+
+function renderFlags() {
+  if (flags === null) return;
+  const names = Object.keys(flags).sort();
+  fill("flags", names.length ? names.map((n) => [n, flags[n].enabled ? ["on", "ok"] : ["off", "muted"], flags[n].by]) : [["none", "", ""]]);
+}
+
+async function refresh() {
+  try {
+    if (Date.now() - lastSlow >= SLOW_MS) {
+      const [u, f] = await Promise.all([get("/go/admin/upstreams", true), get("/go/admin/flags", true)]);
+      upstreams = u ? u.upstreams : [];
+      flags = f ? f.flags : {};
+      lastSlow = Date.now();
+      renderFlags();
+    }
+    const [s, h] = await Promise.all([get("/go/stats"), get("/go/health?verbose=1")]);
+    renderStats(s);
+    renderHealth(h);
+    text("updated", "updated " + new Date().toLocaleTimeString(), "muted");
+  } catch (err) {
+    if (err instanceof Unauthorized) {
+      signOut("That token was refused.");
+      return;
+    }
+    text("updated", "refresh failed: " + err.message + "; retrying", "bad");
+  }
+  timer = setTimeout(refresh, FAST_MS);
+}
+
+function signOut(why) {
+  clearTimeout(timer);
+  sessionStorage.removeItem(TOKEN);
+  prev = upstreams = flags = null;
+  lastSlow = 0;
+  $("board").hidden = true;
+  $("signout").hidden = true;
+  $("login").hidden = false;
+  text("login-error", why || "", "bad");
+  text("status", "signed out", "pill muted");
+  $("token").focus();
+}
+
+function start() {
+  $("login").hidden = true;
+  $("board").hidden = false;
+  $("signout").hidden = false;
+  refresh();
+}
+
+$("login").addEventListener("submit", (e) => {
+  e.preventDefault();
+  sessionStorage.setItem(TOKEN, $("token").value.trim());
+  $("token").value = "";
+  start();
+});
+$("signout").addEventListener("click", () => signOut(""));
+
+if (sessionStorage.getItem(TOKEN)) start();
+else signOut("");
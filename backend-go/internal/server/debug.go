@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// publishDebugVars adds the service's own numbers next to expvar's cmdline and memstats. New
+// calls it when ENABLE_DEBUG is set; expvar names are process-wide, so they are published once
+// and report on the cache and uptime of the last call.
+func publishDebugVars(cache Cache, uptime func() time.Duration) {
+	debugCache.Store(&cache)
+	debugUptime.Store(&uptime)
+	publishDebugOnce.Do(func() {
+		expvar.Publish("uptime_s", expvar.Func(func() any { return int((*debugUptime.Load())().Seconds()) }))
+		expvar.Publish("upstream_errors", expvar.Func(func() any { return stats.upstreamErrors.Load() }))
+		expvar.Publish("cache", expvar.Func(func() any {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			st, err := (*debugCache.Load()).Stats(ctx)
+			if err != nil {
+				return map[string]any{"error": err.Error()}
+			}
+			return st
+		}))
+	})
+}
+
+var (
+	publishDebugOnce sync.Once
+	debugCache       atomic.Pointer[Cache]
+	debugUptime      atomic.Pointer[func() time.Duration]
+)
+
+// debugRoutes mounts pprof and expvar under /go/debug. Anything without a valid admin token gets
+// the router's plain 404, so the routes look absent rather than protected.
+func debugRoutes(ks *keyStore) func(chi.Router) {
+	return func(r chi.Router) {
+		r.Use(hideUnlessAdmin(ks))
+		r.Get("/vars", expvar.Handler().ServeHTTP)
+		r.Get("/pprof/", pprof.Index)
+		r.Get("/pprof/cmdline", pprof.Cmdline)
+		r.Get("/pprof/profile", longProfile(pprof.Profile))
+		r.Get("/pprof/trace", longProfile(pprof.Trace))
+		r.Get("/pprof/symbol", pprof.Symbol)
+		r.Post("/pprof/symbol", pprof.Symbol)
+		r.Get("/pprof/{name}", func(w http.ResponseWriter, r *http.Request) {
+			pprof.Handler(chi.URLParam(r, "name")).ServeHTTP(w, r)
+		})
+	}
+}
+
+func hideUnlessAdmin(ks *keyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, msg := adminToken(ks, r); msg != "" {
+				notFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// longProfile lets ?seconds= run past the server's 10s WriteTimeout: pprof refuses durations
+// longer than the timeout it finds on the request context, so that is hidden and the write
+// deadline pushed out to cover the requested duration instead.
+func longProfile(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sec, err := strconv.ParseFloat(r.URL.Query().Get("seconds"), 64)
+		if err != nil || sec <= 0 {
+			sec = 30 // profile's default; trace defaults to 1s
+		}
+		d := time.Duration(sec*float64(time.Second)) + 10*time.Second
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d))
+		h(w, r.WithContext(context.WithValue(r.Context(), http.ServerContextKey, nil)))
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestDebugRoutes checks /go/debug is a plain 404 without ENABLE_DEBUG, or without the admin
+// token, and otherwise serves pprof and expvar with the cache, uptime and upstream error vars.
+func TestDebugRoutes(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer up.Close()
+	env := map[string]string{"UPSTREAM_URL": up.URL, "UPSTREAM_MAX_ATTEMPTS": "1"}
+	off := newTestServer(t, env, Deps{}).Handler()
+	env["ENABLE_DEBUG"] = "true"
+	on := newTestServer(t, env, Deps{Upstream: &echoUpstream{}}).Handler()
+	admin := []string{"Authorization", "Bearer " + testAdmin}
+
+	hidden := []struct {
+		name    string
+		h       http.Handler
+		headers []string
+	}{
+		{"flag off", off, admin},
+		{"no token", on, nil},
+		{"client key", on, []string{"Authorization", "Bearer " + testProKey}},
+		{"wrong token", on, []string{"Authorization", "Bearer nope"}},
+	}
+	for _, tt := range hidden {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, target := range []string{"/go/debug/vars", "/go/debug/pprof/", "/go/debug/pprof/heap"} {
+				if w := serve(tt.h, "GET", target, "", tt.headers...); w.Code != http.StatusNotFound {
+					t.Fatalf("%s: status %d, want 404", target, w.Code)
+				}
+			}
+		})
+	}
+
+	before := stats.upstreamErrors.Load()
+	if w := serve(off, "GET", "/go/translate?q=salaam", "", "X-API-Key", testProKey); w.Code != http.StatusBadGateway {
+		t.Fatalf("translate against the failing upstream: status %d", w.Code)
+	}
+	serve(on, "GET", "/go/translate?q=kihineh", "", "X-API-Key", testProKey)
+	serve(on, "GET", "/go/translate?q=salaam", "", "X-API-Key", testProKey)
+
+	w := serve(on, "GET", "/go/debug/vars", "", admin...)
+	var vars struct {
+		Uptime         *int   `json:"uptime_s"`
+		UpstreamErrors uint64 `json:"upstream_errors"`
+		Cache          struct {
+			Entries int `json:"entries"`
+		} `json:"cache"`
+		Memstats map[string]any `json:"memstats"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("/go/debug/vars: status %d, err %v: %s", w.Code, err, w.Body.String())
+	}
+	if vars.Uptime == nil || vars.UpstreamErrors != before+1 || vars.Cache.Entries != 2 || vars.Memstats == nil {
+		t.Fatalf("vars: uptime %v, upstream_errors %d (was %d), %d cache entries, memstats %v; want the failure counted and 2 entries",
+			vars.Uptime, vars.UpstreamErrors, before, vars.Cache.Entries, vars.Memstats != nil)
+	}
+
+	for target, want := range map[string]string{
+		"/go/debug/pprof/":                  "goroutine",
+		"/go/debug/pprof/goroutine?debug=1": "goroutine profile:",
+		"/go/debug/pprof/cmdline":           "",
+	} {
+		w := serve(on, "GET", target, "", admin...)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("%s: status %d, want 200 with %q", target, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Trigrams that are common in one of the two Latin-script languages and rare in the other.
+// Romanized Dhivehi leans on doubled vowels and "-eh"/"-un" endings; English on its function words.
+var (
+	englishTrigrams = trigramSet(
+		" th", "the", "he ", "ing", "ng ", "and", "nd ", " an", "ion", "tio", "ent", "er ", " to",
+		"to ", "of ", " of", "is ", " is", "you", "ou ", "for", " wh", "hat", "es ", "ed ", " it",
+		"it ", "at ", " be", "ere", "ly ", "wit", "ith", "ter", "ght", "are", " ar", "his", "was",
+	)
+	dhivehiTrigrams = trigramSet(
+		"aa ", "aar", "baa", "vaa", "haa", "kaa", "laa", "maa", "raa", "naa", "ee ",
+		"ehe", "eh ", "un ", "kur", "uri", "hun", "dhe", "dhi", "ive", "veh", "ehi", "mee",
+		"ves", "vee", "ge ", "ey ", "eyn", "iya", "yaa", "kob", "oba", "kih", "ihi", "hin",
+		"ine", "neh", "ahu", "adh", "ekk", "kam", "ama", "mak", "ovv", "gai", "ai ",
+	)
+)
+
+func trigramSet(grams ...string) map[string]bool {
+	m := make(map[string]bool, len(grams))
+	for _, g := range grams {
+		m[g] = true
+	}
+	return m
+}
+
+// DetectLanguage guesses the source language of already-normalized text: "dv" for Thaana script,
+// otherwise "latin" (romanized Dhivehi) or "en" from a trigram vote. confidence is the winner's
+// share of the evidence in [0, 1]; 0.5 means a coin toss. Text with no letters returns ("", 0).
+func DetectLanguage(text string) (lang string, confidence float64) {
+	var thaana, latin int
+	for _, r := range text {
+		switch {
+		case r >= 0x0780 && r <= 0x07BF:
+			thaana++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	if thaana+latin == 0 {
+		return "", 0
+	}
+	if thaana >= latin {
+		return langDhivehi, round2(float64(thaana) / float64(thaana+latin))
+	}
+
+	padded := " " + strings.ToLower(text) + " "
+	runes := []rune(padded)
+	var en, dv int
+	for i := 0; i+3 <= len(runes); i++ {
+		g := string(runes[i : i+3])
+		if englishTrigrams[g] {
+			en++
+		}
+		if dhivehiTrigrams[g] {
+			dv++
+		}
+	}
+	switch {
+	case en+dv == 0:
+		return langEnglish, 0.5
+	case dv > en:
+		return langLatin, round2(float64(dv) / float64(en+dv))
+	default:
+		return langEnglish, round2(float64(en) / float64(en+dv))
+	}
+}
+
+func round2(f float64) float64 { return math.Round(f*100) / 100 }
+
+// letterCount counts letters, the unit detectOpts.MinChars is measured in.
+func letterCount(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			n++
+		}
+	}
+	return n
+}
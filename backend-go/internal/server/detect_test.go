@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// detectAccuracy is the share of testdata/detect.tsv DetectLanguage must get right.
+const detectAccuracy = 0.9
+
+func TestDetectLanguageCorpus(t *testing.T) {
+	f, err := os.Open("testdata/detect.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var total, right int
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		want, text, ok := strings.Cut(line, "\t")
+		if !ok {
+			t.Fatalf("malformed sample %q", line)
+		}
+		total++
+		got, confidence := DetectLanguage(text)
+		if got == want {
+			right++
+		} else {
+			t.Logf("%q: detected %s (%.2f), labeled %s", text, got, confidence, want)
+		}
+		if confidence < 0 || confidence > 1 {
+			t.Errorf("%q: confidence %v outside [0, 1]", text, confidence)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if acc := float64(right) / float64(total); acc < detectAccuracy {
+		t.Fatalf("accuracy %.2f (%d of %d), want at least %.2f", acc, right, total, detectAccuracy)
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		lang string
+	}{
+		{"", ""},
+		{"12 345 !?", ""},
+		{"ޝުކުރިއްޔާ", langDhivehi},
+		{"xyz qq", langEnglish}, // no trigram evidence either way
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got, _ := DetectLanguage(tt.text); got != tt.lang {
+				t.Fatalf("DetectLanguage(%q) = %q, want %q", tt.text, got, tt.lang)
+			}
+		})
+	}
+}
+
+func TestDetectedSrcInResponse(t *testing.T) {
+	h := newTestServer(t, nil, Deps{}).Handler()
+	tests := []struct {
+		name     string
+		q        string
+		detected string
+	}{
+		{"thaana", "ކިހިނެއް ތިބެނީ", langDhivehi},
+		{"english", "where is the nearest hospital", langEnglish},
+		{"too short falls back", "hello", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"q": tt.q})
+			w := serve(h, "POST", "/go/translate", string(body), "X-API-Key", testProKey, "Content-Type", "application/json")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+			var res struct {
+				DetectedSrc *string `json:"detected_src"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if res.DetectedSrc != nil {
+				got = *res.DetectedSrc
+			}
+			if got != tt.detected {
+				t.Fatalf("detected_src %q, want %q", got, tt.detected)
+			}
+		})
+	}
+}
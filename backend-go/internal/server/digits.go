@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Numbers, dates and times. Models reorder or reformat figures as readily as words, so the
+// numeric tokens of the input are masked like emoji and URLs (see protectOpaque) and put back as
+// they were written. With digits=latin or digits=thaana they come back in that digit script
+// instead. Thaana has no digits of its own; Dhivehi writes either the Latin ones or the
+// Arabic-Indic ones (٠١٢…), which is what thaana means here.
+
+// Output digit scripts.
+const (
+	digitsLatin  = "latin"
+	digitsThaana = "thaana"
+)
+
+// checkDigits refuses a digits value other than latin, thaana or unset.
+func checkDigits(v string) *httpError {
+	switch v {
+	case "", digitsLatin, digitsThaana:
+		return nil
+	}
+	return &httpError{http.StatusBadRequest, codeBadRequest, "digits must be latin or thaana"}
+}
+
+// numericPattern finds dates (2026-10-14, 14/10/2026), times (9:30, 09:30:15 pm) and numbers
+// (42, -3.5, 1,200, 15%). \d is ASCII-only in RE2, so it is widened to the Arabic-Indic digits
+// convertDigits knows too.
+var numericPattern = regexp.MustCompile(strings.ReplaceAll(`(?i)`+
+	`\d{4}-\d{1,2}-\d{1,2}(?:[T ]\d{1,2}:\d{2}(?::\d{2})?)?`+
+	`|\d{1,2}[/.]\d{1,2}[/.]\d{2,4}`+
+	`|\d{1,2}:\d{2}(?::\d{2})?(?:\s?[ap]\.?m\.?)?`+
+	`|[-+]?\d+(?:[.,]\d+)*%?`, `\d`, `[0-9\x{0660}-\x{0669}\x{06F0}-\x{06F9}]`))
+
+// numericSpans is where numericPattern matches q, skipping matches that run into a letter on
+// either side: the 4 of A4 and the 3 of mp3 are part of a word.
+func numericSpans(q string) [][2]int {
+	var out [][2]int
+	for _, m := range numericPattern.FindAllStringIndex(q, -1) {
+		before, _ := utf8.DecodeLastRuneInString(q[:m[0]])
+		after, _ := utf8.DecodeRuneInString(q[m[1]:])
+		if unicode.IsLetter(before) || unicode.IsLetter(after) {
+			continue
+		}
+		out = append(out, [2]int{m[0], m[1]})
+	}
+	return out
+}
+
+// convertDigits rewrites the digits of s in script, leaving them as they are when it is unset.
+func convertDigits(s, script string) string {
+	switch script {
+	case digitsLatin:
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r >= '٠' && r <= '٩':
+				return '0' + r - '٠'
+			case r >= '۰' && r <= '۹':
+				return '0' + r - '۰'
+			}
+			return r
+		}, s)
+	case digitsThaana:
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r >= '0' && r <= '9':
+				return '٠' + r - '0'
+			case r >= '۰' && r <= '۹':
+				return '٠' + r - '۰'
+			}
+			return r
+		}, s)
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNumericSpans(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"no figures here", nil},
+		{"on 2026-10-14 at 09:30", []string{"2026-10-14", "09:30"}},
+		{"2026-10-14T09:30:15", []string{"2026-10-14T09:30:15"}},
+		{"due 14/10/2026 or 14.10.26", []string{"14/10/2026", "14.10.26"}},
+		{"at 9:30 pm or 9:30am", []string{"9:30 pm", "9:30am"}},
+		{"1,200 rufiyaa, -3.5 degrees, 15% off", []string{"1,200", "-3.5", "15%"}},
+		{"ގަޑި ٩:٣٠", []string{"٩:٣٠"}},
+		{"A4 paper and an mp3", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, s := range numericSpans(tt.q) {
+			got = append(got, tt.q[s[0]:s[1]])
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("numericSpans(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestConvertDigits(t *testing.T) {
+	tests := []struct{ in, script, want string }{
+		{"2026-10-14 9:30", digitsThaana, "٢٠٢٦-١٠-١٤ ٩:٣٠"},
+		{"٢٠٢٦-١٠-١٤ ٩:٣٠", digitsLatin, "2026-10-14 9:30"},
+		{"۱۲۳", digitsLatin, "123"},
+		{"۱۲۳", digitsThaana, "١٢٣"},
+		{"1,200 and ١٢", "", "1,200 and ١٢"},
+	}
+	for _, tt := range tests {
+		if got := convertDigits(tt.in, tt.script); got != tt.want {
+			t.Errorf("convertDigits(%q, %q) = %q, want %q", tt.in, tt.script, got, tt.want)
+		}
+	}
+}
+
+// rewriteUpstream answers with write applied to the masked text it was sent.
+type rewriteUpstream struct{ write func(q string) string }
+
+func (u rewriteUpstream) Translate(_ context.Context, req translateReq) (translateResult, error) {
+	return translateResult{Translation: u.write(req.Q), Src: "upstream"}, nil
+}
+
+// TestDigitsRestored has the upstream move the placeholders round, and drop one, and checks each
+// figure comes back where its placeholder went, in the digit script asked for, and a dropped one
+// is logged as lost rather than failing the request.
+func TestDigitsRestored(t *testing.T) {
+	const q = "pay 1,200 on 2026-10-14 at 9:30"
+	var sent string
+	up := rewriteUpstream{func(masked string) string {
+		sent = masked
+		switch {
+		case strings.HasPrefix(masked, "drop "):
+			return "dropped " + placeholder(0)
+		case strings.Contains(masked, placeholder(2)):
+			return "at " + placeholder(2) + " on " + placeholder(1) + ", " + placeholder(0) + " paid"
+		}
+		return "EN(" + masked + ")"
+	}}
+	h := newTestServer(t, nil, Deps{Upstream: up}).Handler()
+	get := func(q, digits string) (int, string) {
+		t.Helper()
+		w := serve(h, "GET", "/go/translate?q="+url.QueryEscape(q)+"&digits="+digits, "", "X-API-Key", testProKey)
+		var res translateResult
+		json.Unmarshal(w.Body.Bytes(), &res)
+		if res.Translation == "" {
+			return w.Code, w.Body.String()
+		}
+		return w.Code, res.Translation
+	}
+
+	// The cache holds the masked form, so the second and third are converted from the first's.
+	for _, tt := range []struct{ digits, want string }{
+		{"", "at 9:30 on 2026-10-14, 1,200 paid"},
+		{digitsThaana, "at ٩:٣٠ on ٢٠٢٦-١٠-١٤, ١,٢٠٠ paid"},
+		{digitsLatin, "at 9:30 on 2026-10-14, 1,200 paid"},
+	} {
+		if code, got := get(q, tt.digits); code != http.StatusOK || got != tt.want {
+			t.Errorf("digits=%q: status %d, %q, want %q", tt.digits, code, got, tt.want)
+		}
+	}
+	if want := "pay " + placeholder(0) + " on " + placeholder(1) + " at " + placeholder(2); sent != want {
+		t.Fatalf("upstream was sent %q, want %q", sent, want)
+	}
+	if code, got := get("ގަޑި ٩:٣٠ ގައި", digitsLatin); code != http.StatusOK || got != "EN(ގަޑި 9:30 ގައި)" {
+		t.Errorf("arabic-indic in, latin out: status %d, %q", code, got)
+	}
+
+	mark := suiteLog.mark()
+	if code, got := get("drop 5 and 6", ""); code != http.StatusOK || got != "dropped 5" {
+		t.Fatalf("a dropped placeholder: status %d, %q, want the rest put back", code, got)
+	}
+	if logged := suiteLog.since(mark); !strings.Contains(logged, `"msg":"placeholders lost upstream"`) || !strings.Contains(logged, `"lost":1,"of":2`) {
+		t.Fatalf("the lost placeholder wasn't logged:\n%s", logged)
+	}
+
+	if code, got := get("12345", digitsThaana); code != http.StatusOK || got != "١٢٣٤٥" {
+		t.Errorf("passthrough in thaana digits: status %d, %q", code, got)
+	}
+	if code, body := get(q, "roman"); code != http.StatusBadRequest || !strings.Contains(body, "digits must be latin or thaana") {
+		t.Errorf("digits=roman: status %d: %s", code, body)
+	}
+}
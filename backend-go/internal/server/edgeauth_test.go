@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignEdgeRequest(t *testing.T) {
+	// The worker's signature for the same request, computed independently.
+	const want = "0edff6191d91b647b14f58f8398a344ca30659daad89a8be42c1726c095be3b1"
+	if got := SignEdgeRequest([]byte("edge-secret"), "GET", "/go/translate", "q=salaam", "1772366400", "0123456789abcdef"); got != want {
+		t.Fatalf("signature %s, want %s", got, want)
+	}
+}
+
+// TestEdgeSignature sends translate requests signed, or not, as the edge worker would: both the
+// current and the previous secret are accepted within EDGE_MAX_SKEW, anything else is a 403, and
+// a nonce used twice is a 409.
+func TestEdgeSignature(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := newTestServer(t, map[string]string{
+		"EDGE_HMAC_SECRET":          "current-secret",
+		"EDGE_HMAC_SECRET_PREVIOUS": "previous-secret",
+		"EDGE_MAX_SKEW":             "5m",
+	}, Deps{Clock: clk}).Handler()
+	nonces := 0
+	signed := func(secret, query string, skew time.Duration, nonce string) []string {
+		if nonce == "" {
+			nonces++
+			nonce = "nonce-" + strconv.Itoa(1_000_000_000+nonces)
+		}
+		ts := strconv.FormatInt(clk.Now().Add(skew).Unix(), 10)
+		return []string{
+			edgeSignatureHeader, SignEdgeRequest([]byte(secret), "GET", "/go/translate", query, ts, nonce),
+			edgeTimestampHeader, ts,
+			edgeNonceHeader, nonce,
+		}
+	}
+	tests := []struct {
+		name    string
+		query   string
+		headers []string
+		status  int
+		msg     string // the error message, for a refusal
+	}{
+		{"current secret", "q=salaam", signed("current-secret", "q=salaam", 0, "nonce-first-request"), http.StatusOK, ""},
+		{"previous secret", "q=salaam", signed("previous-secret", "q=salaam", 0, ""), http.StatusOK, ""},
+		{"within the skew", "q=salaam", signed("current-secret", "q=salaam", 4*time.Minute, ""), http.StatusOK, ""},
+		{"unsigned", "q=salaam", nil, http.StatusForbidden, "missing edge signature"},
+		{"other secret", "q=salaam", signed("retired-secret", "q=salaam", 0, ""), http.StatusForbidden, "invalid edge signature"},
+		{"query changed", "q=shukuriyyaa", signed("current-secret", "q=salaam", 0, ""), http.StatusForbidden, "invalid edge signature"},
+		{"old timestamp", "q=salaam", signed("current-secret", "q=salaam", -6*time.Minute, ""), http.StatusForbidden, "stale edge timestamp"},
+		{"future timestamp", "q=salaam", signed("current-secret", "q=salaam", 6*time.Minute, ""), http.StatusForbidden, "stale edge timestamp"},
+		{"short nonce", "q=salaam", signed("current-secret", "q=salaam", 0, "short"), http.StatusForbidden, "invalid edge nonce"},
+		{"replayed nonce", "q=salaam", signed("current-secret", "q=salaam", 0, "nonce-first-request"), http.StatusConflict, "edge nonce already used"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := append([]string{"X-API-Key", testProKey}, tt.headers...)
+			w := serve(h, "GET", "/go/translate?"+tt.query, "", headers...)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.msg == "" {
+				return
+			}
+			checkEnvelope(t, w)
+			var res struct {
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if res.Error.Message != tt.msg {
+				t.Fatalf("message %q, want %q", res.Error.Message, tt.msg)
+			}
+		})
+	}
+	if w := serve(h, "GET", "/go/health", ""); w.Code != http.StatusOK {
+		t.Fatalf("/go/health unsigned: status %d", w.Code)
+	}
+}
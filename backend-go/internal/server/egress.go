@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Egress. Every outbound HTTP call goes through an egressPolicy, enforced by the transports
+// themselves rather than by each feature, so a new one can't leave it out:
+//
+//   - the scheme must be https; http only to a loopback host outside ENV=production;
+//   - the addresses a name resolves to are checked when dialing, and the connection goes to an
+//     address that passed, so a name can't resolve to one address for the check and another
+//     for the dial;
+//   - loopback (allowed outside production), private, link-local (cloud metadata lives there),
+//     CGNAT, multicast and unspecified addresses are refused unless EGRESS_ALLOWLIST names the
+//     host or lists a range holding the address;
+//   - a redirect is a new request through the same transport, so every hop is checked the same.
+//
+// The UPSTREAM_URL(S) hosts and ports are trusted whatever their scheme and addresses: the backend
+// usually sits on a private network, and those URLs are the operator's own. Job callbacks,
+// whose URLs come from API clients, have a policy of their own that trusts neither the
+// upstreams nor EGRESS_ALLOWLIST (see newCallbackClient).
+
+var errEgressDenied = errors.New("destination not allowed")
+
+// egressPolicy decides which URLs and addresses an outbound client may reach.
+type egressPolicy struct {
+	name         string // the client label on dhk_go_egress_denied_total
+	dev          bool   // loopback addresses pass, and http to a loopback host
+	allowPrivate bool   // every address passes; the scheme is still checked
+	allow        []netip.Prefix
+	allowHosts   []string
+	trusted      atomic.Pointer[[]string] // upstream host:port
+	lookup       func(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// newEgressPolicy is the policy of the shared outbound transport. Config loading has already
+// checked EGRESS_ALLOWLIST.
+func newEgressPolicy(cfg Config) *egressPolicy {
+	prefixes, hosts, _ := parseEgressAllowlist(cfg.EgressAllowlist)
+	p := &egressPolicy{name: "outbound", dev: !cfg.Production(), allow: prefixes, allowHosts: hosts, lookup: net.DefaultResolver.LookupNetIP}
+	p.trustUpstreams(upstreamHosts(cfg))
+	return p
+}
+
+// upstreamHosts is the URLs of every backend cfg translates with: UPSTREAM_URLS and the mirror.
+func upstreamHosts(cfg Config) []*url.URL {
+	if cfg.MirrorURL == nil {
+		return cfg.UpstreamURLs
+	}
+	return append(slices.Clone(cfg.UpstreamURLs), cfg.MirrorURL)
+}
+
+// callbackPolicy is the policy of job callbacks.
+func callbackPolicy(allowPrivate bool) *egressPolicy {
+	return &egressPolicy{name: "callbacks", allowPrivate: allowPrivate, lookup: net.DefaultResolver.LookupNetIP}
+}
+
+// parseEgressAllowlist splits EGRESS_ALLOWLIST into addresses or CIDRs and host names.
+func parseEgressAllowlist(entries []string) ([]netip.Prefix, []string, error) {
+	var prefixes []netip.Prefix
+	var hosts []string
+	for _, v := range entries {
+		if _, err := netip.ParseAddr(v); err == nil || strings.Contains(v, "/") {
+			p, err := parsePrefixes(v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%q is not an IP, CIDR or host name", v)
+			}
+			prefixes = append(prefixes, p...)
+			continue
+		}
+		if strings.ContainsAny(v, ":@*? ") {
+			return nil, nil, fmt.Errorf("%q is not an IP, CIDR or host name", v)
+		}
+		hosts = append(hosts, normalizeHost(v))
+	}
+	return prefixes, hosts, nil
+}
+
+// trustUpstreams replaces the trusted upstream hosts; an upstream reload calls it.
+func (p *egressPolicy) trustUpstreams(urls []*url.URL) {
+	hosts := make([]string, 0, len(urls))
+	for _, u := range urls {
+		hosts = append(hosts, hostPort(u))
+	}
+	p.trusted.Store(&hosts)
+}
+
+// isTrusted reports whether hostport, host:port as hostPort gives it, is an upstream's.
+func (p *egressPolicy) isTrusted(hostport string) bool {
+	t := p.trusted.Load()
+	return t != nil && slices.Contains(*t, hostport)
+}
+
+// hostPort is u's normalized host and port, the scheme's default port when it has none.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(normalizeHost(u.Hostname()), port)
+}
+
+func normalizeHost(host string) string { return strings.TrimSuffix(strings.ToLower(host), ".") }
+
+func localhostName(host string) bool {
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+// cgnat is the carrier-grade NAT range, private in practice though not in IsPrivate.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// blockedAddr reports addresses outbound calls must not reach by default: loopback, private,
+// link-local (cloud metadata lives there), CGNAT, multicast and unspecified ones.
+func blockedAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || cgnat.Contains(ip)
+}
+
+// allowedAddr reports whether ip may be dialed.
+func (p *egressPolicy) allowedAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if p.allowPrivate || !blockedAddr(ip) || (p.dev && ip.IsLoopback()) {
+		return true
+	}
+	return slices.ContainsFunc(p.allow, func(pr netip.Prefix) bool { return pr.Contains(ip) })
+}
+
+// checkURL checks u's scheme, and its host when that is an address or a localhost name. Other
+// names are checked, as the addresses they resolve to, when dialed.
+func (p *egressPolicy) checkURL(u *url.URL) error {
+	host := normalizeHost(u.Hostname())
+	loopback := localhostName(host)
+	if ip, err := netip.ParseAddr(host); err == nil {
+		loopback = ip.Unmap().IsLoopback()
+	}
+	switch {
+	case host == "":
+		return errors.New("missing host")
+	case p.isTrusted(hostPort(u)):
+		return nil
+	case u.Scheme == "http" && !(p.dev && loopback):
+		return fmt.Errorf("%w: must be https (http only to loopback, outside production)", errEgressDenied)
+	case u.Scheme != "https" && u.Scheme != "http":
+		return fmt.Errorf("%w: scheme %q", errEgressDenied, u.Scheme)
+	case slices.Contains(p.allowHosts, host):
+		return nil
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !p.allowedAddr(ip) {
+		return fmt.Errorf("%w: %s", errEgressDenied, ip)
+	}
+	if localhostName(host) && !p.allowedAddr(netip.IPv6Loopback()) {
+		return fmt.Errorf("%w: %s", errEgressDenied, host)
+	}
+	return nil
+}
+
+// denied counts err against the policy if it is a refusal, and returns it.
+func (p *egressPolicy) denied(err error) error {
+	if errors.Is(err, errEgressDenied) {
+		metricEgressDenied.WithLabelValues(p.name).Inc()
+	}
+	return err
+}
+
+// dialContext wraps d so every address is checked before it is dialed. A name is resolved here,
+// every address it resolves to must pass, and those are dialed in turn; upstreams and
+// allowlisted names are left to d.
+func (p *egressPolicy) dialContext(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if h := normalizeHost(host); p.isTrusted(net.JoinHostPort(h, port)) || slices.Contains(p.allowHosts, h) {
+			return d.DialContext(ctx, network, addr)
+		}
+		ips, err := p.resolve(ctx, network, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if !p.allowedAddr(ip) {
+				return nil, p.denied(fmt.Errorf("%w: %s is %s", errEgressDenied, host, ip))
+			}
+		}
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// resolve is host's addresses for network (tcp, tcp4 or tcp6); an address is its own.
+func (p *egressPolicy) resolve(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, nil
+	}
+	ipNet := "ip"
+	switch network {
+	case "tcp4":
+		ipNet = "ip4"
+	case "tcp6":
+		ipNet = "ip6"
+	}
+	ips, err := p.lookup(ctx, ipNet, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s has no addresses", host)
+	}
+	return ips, nil
+}
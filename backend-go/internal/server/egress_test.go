@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeResolver answers lookups from a table; names not in it don't resolve.
+type fakeResolver map[string][]string
+
+func (f fakeResolver) lookup(_ context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, ok := f[normalizeHost(host)]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var ips []netip.Addr
+	for _, v := range addrs {
+		ip := netip.MustParseAddr(v)
+		if network == "ip4" && !ip.Unmap().Is4() || network == "ip6" && !ip.Is6() {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+var testResolver = fakeResolver{
+	"public.test":   {"203.0.113.10"},
+	"dual.test":     {"203.0.113.10", "2001:db8::10"},
+	"private.test":  {"10.1.2.3"},
+	"metadata.test": {"169.254.169.254"},
+	"cgnat.test":    {"100.64.0.9"},
+	"mixed.test":    {"203.0.113.10", "127.0.0.1"},
+	"mapped.test":   {"::ffff:10.1.2.3"},
+	"ula.test":      {"fd00::1"},
+	"loopback.test": {"127.0.0.1"},
+	"empty.test":    {},
+}
+
+// testEgressPolicy is the shared transport's policy with testResolver in place of DNS.
+func testEgressPolicy(t *testing.T, env map[string]string) *egressPolicy {
+	t.Helper()
+	vars := map[string]string{"ENV": "development"}
+	for k, v := range env {
+		vars[k] = v
+	}
+	cfg, err := LoadConfig(func(k string) string { return vars[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newEgressPolicy(cfg)
+	p.lookup = testResolver.lookup
+	return p
+}
+
+func TestEgressCheckURL(t *testing.T) {
+	dev := testEgressPolicy(t, map[string]string{"UPSTREAM_URL": "http://10.9.9.9:8000"})
+	prod := testEgressPolicy(t, map[string]string{
+		"ENV":              "production",
+		"UPSTREAM_URL":     "http://10.9.9.9:8000",
+		"EGRESS_ALLOWLIST": "192.168.0.0/16,internal.test",
+	})
+	tests := []struct {
+		name   string
+		policy *egressPolicy
+		url    string
+		ok     bool
+	}{
+		{"https name", prod, "https://public.test/x", true},
+		{"https public ip", prod, "https://203.0.113.10/", true},
+		{"http name", prod, "http://public.test/", false},
+		{"http name in dev", dev, "http://public.test/", false},
+		{"http loopback in dev", dev, "http://127.0.0.1:9000/", true},
+		{"http localhost in dev", dev, "http://localhost:9000/", true},
+		{"http loopback in production", prod, "http://127.0.0.1:9000/", false},
+		{"https loopback in production", prod, "https://127.0.0.1/", false},
+		{"https localhost in production", prod, "https://app.localhost/", false},
+		{"mapped loopback in production", prod, "https://[::ffff:127.0.0.1]/", false},
+		{"metadata", dev, "https://169.254.169.254/latest/meta-data", false},
+		{"private", dev, "https://10.1.2.3/", false},
+		{"cgnat", dev, "https://100.64.0.9/", false},
+		{"unique local v6", dev, "https://[fd00::1]/", false},
+		{"unspecified", dev, "https://0.0.0.0/", false},
+		{"allowlisted range", prod, "https://192.168.1.5/", true},
+		{"allowlisted name", prod, "https://Internal.Test./", true},
+		{"upstream over http", prod, "http://10.9.9.9:8000/translate", true},
+		{"upstream host, other port", prod, "http://10.9.9.9:8001/", false},
+		{"other scheme", dev, "ftp://public.test/", false},
+		{"no host", dev, "https:///x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.policy.checkURL(u); (err == nil) != tt.ok {
+				t.Fatalf("err %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+// TestEgressDial dials names through the fake resolver with a canceled context, so an address
+// that passes fails the dial itself rather than reaching anything.
+func TestEgressDial(t *testing.T) {
+	dev := testEgressPolicy(t, nil)
+	prod := testEgressPolicy(t, map[string]string{"ENV": "production", "TRANSLATE_MODE": "stub", "EGRESS_ALLOWLIST": "10.1.0.0/16"})
+	callbacks := callbackPolicy(false)
+	callbacks.lookup = testResolver.lookup
+	tests := []struct {
+		name    string
+		policy  *egressPolicy
+		network string
+		addr    string
+		denied  bool
+	}{
+		{"public name", prod, "tcp", "public.test:443", false},
+		{"dual-stack name", prod, "tcp", "dual.test:443", false},
+		{"name on a private address", dev, "tcp", "private.test:443", true},
+		{"name on metadata", prod, "tcp", "metadata.test:80", true},
+		{"name on cgnat", prod, "tcp", "cgnat.test:443", true},
+		{"public and loopback", prod, "tcp", "mixed.test:443", true},
+		{"public and loopback in dev", dev, "tcp", "mixed.test:443", false},
+		{"mapped private v6", dev, "tcp", "mapped.test:443", true},
+		{"mapped private v6, allowlisted", prod, "tcp", "mapped.test:443", false},
+		{"unique local v6", dev, "tcp", "ula.test:443", true},
+		{"private address", dev, "tcp", "10.1.2.3:443", true},
+		{"allowlisted address", prod, "tcp", "10.1.2.3:443", false},
+		{"loopback name in production", prod, "tcp", "loopback.test:443", true},
+		{"callbacks ignore the allowlist", callbacks, "tcp", "10.1.2.3:443", true},
+		{"callbacks refuse loopback", callbacks, "tcp", "loopback.test:443", true},
+		{"callbacks to a public name", callbacks, "tcp", "public.test:443", false},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counted := metricEgressDenied.WithLabelValues(tt.policy.name)
+			before := testutil.ToFloat64(counted)
+			conn, err := tt.policy.dialContext(&net.Dialer{})(ctx, tt.network, tt.addr)
+			if conn != nil {
+				conn.Close()
+				t.Fatal("dialed with a canceled context")
+			}
+			if errors.Is(err, errEgressDenied) != tt.denied {
+				t.Fatalf("err %v, want denied %v", err, tt.denied)
+			}
+			if want := map[bool]float64{true: 1}[tt.denied]; testutil.ToFloat64(counted)-before != want {
+				t.Fatalf("denials counted %v, want %v", testutil.ToFloat64(counted)-before, want)
+			}
+		})
+	}
+	t.Run("unresolvable", func(t *testing.T) {
+		for _, addr := range []string{"nowhere.test:443", "empty.test:443"} {
+			if _, err := prod.dialContext(&net.Dialer{})(ctx, "tcp", addr); err == nil || errors.Is(err, errEgressDenied) {
+				t.Fatalf("%s: err %v, want a resolution error", addr, err)
+			}
+		}
+	})
+}
+
+// TestEgressRedirects follows redirect chains from a trusted upstream through the shared
+// transport and checks every hop against the policy, whichever way a hop tries to get out.
+func TestEgressRedirects(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { fmt.Fprint(w, "escaped") }))
+	t.Cleanup(other.Close)
+	otherPort := other.Listener.Addr().(*net.TCPAddr).Port
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to := r.URL.Query().Get("to"); to != "" {
+			http.Redirect(w, r, to, http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "upstream")
+	}))
+	t.Cleanup(up.Close)
+	hop := func(to string) string { return up.URL + "/?to=" + url.QueryEscape(to) }
+
+	p := testEgressPolicy(t, map[string]string{"ENV": "production", "UPSTREAM_URL": up.URL})
+	client := &http.Client{Transport: newOutboundTransport(outboundOptsFrom(Config{}), p)}
+	tests := []struct {
+		name string
+		url  string
+		body string // "" is refused
+	}{
+		{"upstream", up.URL, "upstream"},
+		{"within the upstream", hop(up.URL + "/next"), "upstream"},
+		{"two hops within the upstream", hop(hop(up.URL)), "upstream"},
+		{"to another loopback port", hop(fmt.Sprintf("http://127.0.0.1:%d/", otherPort)), ""},
+		{"to a name on loopback", hop(fmt.Sprintf("http://loopback.test:%d/", otherPort)), ""},
+		{"to https on a loopback name", hop(fmt.Sprintf("https://loopback.test:%d/", otherPort)), ""},
+		{"to metadata", hop("http://169.254.169.254/latest/meta-data/"), ""},
+		{"to https metadata by name", hop("https://metadata.test/latest/meta-data/"), ""},
+		{"to a private name", hop("https://private.test/admin"), ""},
+		{"a hop later", hop(hop(hop("https://mapped.test/"))), ""},
+		{"to another scheme", hop("ftp://public.test/"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := testutil.ToFloat64(metricEgressDenied.WithLabelValues("outbound"))
+			resp, err := client.Get(tt.url)
+			if tt.body != "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				if got, _ := io.ReadAll(resp.Body); string(got) != tt.body {
+					t.Fatalf("body %q, want %q", got, tt.body)
+				}
+				return
+			}
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("followed to %s: status %d", resp.Request.URL, resp.StatusCode)
+			}
+			if !errors.Is(err, errEgressDenied) {
+				t.Fatalf("err %v, want a refusal", err)
+			}
+			if testutil.ToFloat64(metricEgressDenied.WithLabelValues("outbound")) == before {
+				t.Fatal("refusal not counted")
+			}
+		})
+	}
+}
+
+func TestEgressConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		ok   bool
+	}{
+		{"public webhook", map[string]string{"ERROR_WEBHOOK_URL": "https://hooks.example.com/x"}, true},
+		{"http webhook", map[string]string{"ERROR_WEBHOOK_URL": "http://hooks.example.com/x"}, false},
+		{"loopback webhook in dev", map[string]string{"ERROR_WEBHOOK_URL": "http://127.0.0.1:9000/x"}, true},
+		{"metadata jwks", map[string]string{"JWT_JWKS_URL": "https://169.254.169.254/keys"}, false},
+		{"allowlisted jwks", map[string]string{"JWT_JWKS_URL": "https://10.0.0.5/keys", "EGRESS_ALLOWLIST": "10.0.0.0/8"}, true},
+		{"bad allowlist entry", map[string]string{"EGRESS_ALLOWLIST": "user@host"}, false},
+		{"bad allowlist range", map[string]string{"EGRESS_ALLOWLIST": "10.0.0.0/99"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{"ENV": "development"}
+			for k, v := range tt.env {
+				vars[k] = v
+			}
+			if _, err := LoadConfig(func(k string) string { return vars[k] }); (err == nil) != tt.ok {
+				t.Fatalf("err %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errorCache remembers upstream 4xx rejections for a short TTL, so a client retrying a phrase
+// the upstream refuses gets the same answer without another upstream call. It lives in process
+// whatever CACHE_BACKEND is: rejections are cheap to rediscover and not worth sharing. Every
+// entry gets the same TTL, so insertion order is expiry order and the list doubles as both.
+type errorCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	max   int
+	clock Clock
+	ll    *list.List // front = oldest
+	items map[string]*list.Element
+
+	hits atomic.Uint64
+}
+
+type errorEntry struct {
+	key     string
+	err     upstreamError
+	expires time.Time
+}
+
+func newErrorCache(ttl time.Duration, max int, clk Clock) *errorCache {
+	return &errorCache{ttl: ttl, max: max, clock: clk, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns a copy of the rejection stored under key, marked Cached and with no attempts.
+func (c *errorCache) get(key string) (*upstreamError, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*errorEntry)
+	if c.clock.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.hits.Add(1)
+	ue := e.err
+	ue.Attempts, ue.Cached = 0, true
+	return &ue, true
+}
+
+// put stores ue under key, replacing any earlier rejection and dropping the oldest entries past max.
+func (c *errorCache) put(key string, ue *upstreamError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.ll.PushBack(&errorEntry{key: key, err: *ue, expires: c.clock.Now().Add(c.ttl)})
+	now := c.clock.Now()
+	for el := c.ll.Front(); el != nil && (c.ll.Len() > c.max || now.After(el.Value.(*errorEntry).expires)); el = c.ll.Front() {
+		c.remove(el)
+	}
+}
+
+// forget drops the rejection under key, reporting whether there was one.
+func (c *errorCache) forget(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		c.remove(el)
+	}
+	return ok
+}
+
+// clear drops every rejection, returning how many there were.
+func (c *errorCache) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.ll.Len()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	return n
+}
+
+func (c *errorCache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*errorEntry).key)
+}
+
+// stats counts live rejections for /go/stats; expired ones still in the list aren't counted.
+func (c *errorCache) stats() map[string]any {
+	c.mu.Lock()
+	n, now := 0, c.clock.Now()
+	for el := c.ll.Back(); el != nil && !now.After(el.Value.(*errorEntry).expires); el = el.Prev() {
+		n++
+	}
+	c.mu.Unlock()
+	return map[string]any{"entries": n, "hits": c.hits.Load(), "ttl_s": c.ttl.Seconds()}
+}
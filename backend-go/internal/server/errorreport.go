@@ -0,0 +1,359 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// errorReport is one captured problem, in the shape ERROR_WEBHOOK_URL receives it.
+type errorReport struct {
+	Kind      string           `json:"kind"` // "panic" or "upstream_5xx_burst"
+	Message   string           `json:"message"`
+	Time      time.Time        `json:"ts"`
+	Env       string           `json:"env"`
+	Release   string           `json:"release"`
+	RequestID string           `json:"request_id,omitempty"`
+	Route     string           `json:"route,omitempty"`
+	Count     int              `json:"count,omitempty"` // upstream failures in the burst window
+	Stack     string           `json:"stack,omitempty"`
+	Request   *reportedRequest `json:"request,omitempty"`
+}
+
+// reportedRequest is the scrubbed copy of a request sent with a report: no API keys or tokens,
+// and the text to translate only as LOG_CONTENT allows.
+type reportedRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   map[string]string `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// reportedHeaders are the only request headers copied into a report.
+var reportedHeaders = []string{"Accept", "Content-Type", "Content-Length", "Origin", "User-Agent"}
+
+func scrubRequest(r *http.Request) *reportedRequest {
+	out := &reportedRequest{Method: r.Method, Path: r.URL.Path}
+	for k, vs := range r.URL.Query() {
+		v := strings.Join(vs, ",")
+		switch k {
+		case "api_key":
+			continue
+		case "q":
+			v = describeContent(v)
+		}
+		if out.Query == nil {
+			out.Query = map[string]string{}
+		}
+		out.Query[k] = v
+	}
+	for _, h := range reportedHeaders {
+		if v := r.Header.Get(h); v != "" {
+			if out.Headers == nil {
+				out.Headers = map[string]string{}
+			}
+			out.Headers[h] = v
+		}
+	}
+	return out
+}
+
+// reportSink delivers one report.
+type reportSink interface {
+	send(ctx context.Context, rep errorReport) error
+}
+
+// errorReporterOpts configures newErrorReporter; with neither SentryDSN nor WebhookURL set,
+// reporting is off.
+type errorReporterOpts struct {
+	SentryDSN   *url.URL
+	WebhookURL  *url.URL
+	PerMin      int // reports sent per minute; the rest are dropped
+	Burst       int // upstream 5xx within BurstWindow that make a burst
+	BurstWindow time.Duration
+	Env         string
+	Release     string
+	Transport   http.RoundTripper // the shared outbound transport
+	Clock       Clock
+}
+
+// errorReporter sends recovered panics and bursts of upstream 5xx to Sentry and/or a webhook.
+// Capturing never blocks a request: reports are queued and sent by one goroutine, rate-limited
+// so an error storm can't turn into a reporting storm, and dropped when the queue is full. A nil
+// *errorReporter is valid and reports nothing.
+type errorReporter struct {
+	opts    errorReporterOpts
+	sinks   []reportSink
+	client  *http.Client
+	limit   *rateLimiter
+	queue   chan errorReport
+	done    chan struct{}
+	dropped atomic.Int64
+
+	mu          sync.Mutex // guards the burst window and closed
+	windowStart time.Time
+	failures    int
+	burstSent   bool
+	closed      bool
+}
+
+func newErrorReporter(opts errorReporterOpts) *errorReporter {
+	rep := &errorReporter{
+		opts:   opts,
+		client: &http.Client{Timeout: 5 * time.Second, Transport: opts.Transport},
+		limit:  newRateLimiter(opts.PerMin, opts.PerMin, time.Hour, opts.Clock),
+		queue:  make(chan errorReport, 64),
+		done:   make(chan struct{}),
+	}
+	if opts.SentryDSN != nil {
+		rep.sinks = append(rep.sinks, newSentrySink(opts.SentryDSN, rep.client))
+	}
+	if opts.WebhookURL != nil {
+		rep.sinks = append(rep.sinks, &webhookSink{url: opts.WebhookURL.String(), client: rep.client})
+	}
+	if len(rep.sinks) == 0 {
+		return nil
+	}
+	go rep.run()
+	return rep
+}
+
+func (rep *errorReporter) run() {
+	defer close(rep.done)
+	for r := range rep.queue {
+		for _, s := range rep.sinks {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := s.send(ctx, r); err != nil {
+				slog.Warn("error report failed", "kind", r.Kind, "request_id", r.RequestID, "err", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// capture queues rep without waiting; it is dropped when over the rate or the queue is full.
+func (rep *errorReporter) capture(r errorReport) {
+	if !rep.limit.take("reports").Allowed {
+		rep.dropped.Add(1)
+		return
+	}
+	r.Time, r.Env, r.Release = rep.opts.Clock.Now().UTC(), rep.opts.Env, rep.opts.Release
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if rep.closed {
+		rep.dropped.Add(1)
+		return
+	}
+	select {
+	case rep.queue <- r:
+	default:
+		rep.dropped.Add(1)
+	}
+}
+
+// panicked reports a panic recovered while serving r.
+func (rep *errorReporter) panicked(r *http.Request, rec any, stack string) {
+	if rep == nil {
+		return
+	}
+	rep.capture(errorReport{
+		Kind:      "panic",
+		Message:   fmt.Sprint(rec),
+		RequestID: middleware.GetReqID(r.Context()),
+		Route:     routePattern(r),
+		Stack:     stack,
+		Request:   scrubRequest(r),
+	})
+}
+
+// upstreamFailed counts an upstream 5xx and reports once per window when they reach the burst
+// threshold, with the request that tipped it over.
+func (rep *errorReporter) upstreamFailed(ctx context.Context, err error) {
+	if rep == nil {
+		return
+	}
+	now := rep.opts.Clock.Now()
+	rep.mu.Lock()
+	if now.Sub(rep.windowStart) > rep.opts.BurstWindow {
+		rep.windowStart, rep.failures, rep.burstSent = now, 0, false
+	}
+	rep.failures++
+	n, send := rep.failures, rep.failures >= rep.opts.Burst && !rep.burstSent
+	if send {
+		rep.burstSent = true
+	}
+	rep.mu.Unlock()
+	if !send {
+		return
+	}
+	r := errorReport{
+		Kind:      "upstream_5xx_burst",
+		Message:   fmt.Sprintf("%d upstream 5xx in %s; last: %v", n, rep.opts.BurstWindow, scrubUpstreamError(err)),
+		RequestID: middleware.GetReqID(ctx),
+		Count:     n,
+	}
+	if req, ok := ctx.Value(reportRequestKey{}).(*http.Request); ok {
+		r.Route = routePattern(req)
+		r.Request = scrubRequest(req)
+	}
+	rep.capture(r)
+}
+
+// reportRequestKey holds the *http.Request in its context, so reports made deep in the
+// translator chain can describe the request.
+type reportRequestKey struct{}
+
+func (rep *errorReporter) middleware(next http.Handler) http.Handler {
+	if rep == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), reportRequestKey{}, r)))
+	})
+}
+
+// stop sends whatever is still queued, waiting up to ctx's deadline.
+func (rep *errorReporter) stop(ctx context.Context) error {
+	if rep == nil {
+		return nil
+	}
+	rep.mu.Lock()
+	rep.closed = true
+	close(rep.queue)
+	rep.mu.Unlock()
+	if n := rep.dropped.Load(); n > 0 {
+		slog.Warn("error reports dropped", "count", n)
+	}
+	select {
+	case <-rep.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error reports not flushed: %w", ctx.Err())
+	}
+}
+
+// reportingTranslator reports bursts of 5xx from the translator it wraps (the upstream).
+type reportingTranslator struct {
+	next Translator
+	rep  *errorReporter
+}
+
+func (t *reportingTranslator) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	res, err := t.next.Translate(ctx, req)
+	var ue *upstreamError
+	if errors.As(err, &ue) && ue.Status >= 500 {
+		t.rep.upstreamFailed(ctx, err)
+	}
+	return res, err
+}
+
+// webhookSink POSTs the report as JSON.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) send(ctx context.Context, rep errorReport) error {
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	return postReport(ctx, s.client, s.url, "application/json", body, nil)
+}
+
+// sentrySink sends events to Sentry's envelope endpoint, so no SDK is needed for the two kinds
+// of event reported here.
+type sentrySink struct {
+	dsn      string
+	endpoint string
+	auth     string
+	client   *http.Client
+}
+
+// validSentryDSN checks the https://<key>@<host>/<project> shape.
+func validSentryDSN(u *url.URL) bool {
+	return u.User != nil && u.User.Username() != "" && strings.Trim(u.Path, "/") != ""
+}
+
+func newSentrySink(dsn *url.URL, client *http.Client) *sentrySink {
+	project := path.Base(dsn.Path)
+	endpoint := url.URL{Scheme: dsn.Scheme, Host: dsn.Host, Path: path.Join(path.Dir(dsn.Path), "api", project, "envelope") + "/"}
+	return &sentrySink{
+		dsn:      dsn.String(),
+		endpoint: endpoint.String(),
+		auth:     "Sentry sentry_version=7, sentry_client=dhkalign-go/1.0, sentry_key=" + dsn.User.Username(),
+		client:   client,
+	}
+}
+
+func (s *sentrySink) send(ctx context.Context, rep errorReport) error {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	eventID := hex.EncodeToString(id)
+	level := "error"
+	if rep.Kind == "panic" {
+		level = "fatal"
+	}
+	event := map[string]any{
+		"event_id":    eventID,
+		"timestamp":   rep.Time.Format(time.RFC3339Nano),
+		"platform":    "go",
+		"level":       level,
+		"logger":      "backend-go",
+		"environment": rep.Env,
+		"release":     rep.Release,
+		"message":     map[string]any{"formatted": rep.Message},
+		"tags":        map[string]any{"kind": rep.Kind, "route": rep.Route, "request_id": rep.RequestID},
+		"extra":       map[string]any{"stack": rep.Stack, "count": rep.Count},
+	}
+	if rep.Request != nil {
+		event["request"] = map[string]any{
+			"method":       rep.Request.Method,
+			"url":          rep.Request.Path,
+			"query_string": rep.Request.Query,
+			"headers":      rep.Request.Headers,
+		}
+	}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	_ = enc.Encode(map[string]any{"event_id": eventID, "dsn": s.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339Nano)})
+	_ = enc.Encode(map[string]any{"type": "event"})
+	if err := enc.Encode(event); err != nil {
+		return err
+	}
+	return postReport(ctx, s.client, s.endpoint, "application/x-sentry-envelope", body.Bytes(), map[string]string{"X-Sentry-Auth": s.auth})
+}
+
+func postReport(ctx context.Context, client *http.Client, target, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	drainClose(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %d", req.URL.Redacted(), resp.StatusCode)
+	}
+	return nil
+}
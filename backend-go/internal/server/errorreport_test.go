@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// reportHook is an ERROR_WEBHOOK_URL that hands each report it receives to reports.
+func reportHook(t *testing.T) (*httptest.Server, chan errorReport) {
+	reports := make(chan errorReport, 16)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rep errorReport
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&rep) != nil {
+			t.Errorf("webhook got %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		reports <- rep
+	}))
+	t.Cleanup(hook.Close)
+	return hook, reports
+}
+
+func nextReport(t *testing.T, reports chan errorReport) errorReport {
+	t.Helper()
+	select {
+	case rep := <-reports:
+		return rep
+	case <-time.After(5 * time.Second):
+		t.Fatal("no report arrived")
+		return errorReport{}
+	}
+}
+
+// panicCache panics on lookups while armed.
+type panicCache struct {
+	Cache
+	armed atomic.Bool
+}
+
+func (c *panicCache) Get(ctx context.Context, key string) (cacheValue, bool, error) {
+	if c.armed.Load() {
+		panic("cache blew up")
+	}
+	return c.Cache.Get(ctx, key)
+}
+
+// TestErrorReportWebhook sends a panic and a burst of upstream 5xx through a server and checks
+// what ERROR_WEBHOOK_URL receives, with the request scrubbed of its key and its text.
+func TestErrorReportWebhook(t *testing.T) {
+	hook, reports := reportHook(t)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer up.Close()
+	cache := &panicCache{Cache: newLRUCache(100, 1<<20, 100, time.Hour, systemClock{})}
+	h := newTestServer(t, map[string]string{
+		"UPSTREAM_URL":          up.URL,
+		"UPSTREAM_MAX_ATTEMPTS": "1",
+		"ERROR_WEBHOOK_URL":     hook.URL,
+		"ERROR_REPORT_BURST":    "3",
+		"EGRESS_ALLOWLIST":      "127.0.0.1",
+	}, Deps{Cache: cache}).Handler()
+
+	cache.armed.Store(true)
+	w := serve(h, "GET", "/go/translate?q=kaboom+secret+text&src=dv", "", "X-API-Key", testProKey, "User-Agent", "curl/8")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("panicking request: status %d: %s", w.Code, w.Body.String())
+	}
+	cache.armed.Store(false)
+	rep := nextReport(t, reports)
+	if rep.Kind != "panic" || rep.Message != "cache blew up" || rep.Route != "/go/translate" || rep.RequestID == "" || rep.RequestID != w.Header().Get("X-Request-Id") ||
+		!strings.Contains(rep.Stack, "panicCache") || rep.Env != "development" || rep.Time.IsZero() {
+		t.Fatalf("panic report %+v", rep)
+	}
+	if rep.Request == nil || rep.Request.Method != "GET" || rep.Request.Query["q"] != "[18 chars]" || rep.Request.Query["src"] != "dv" || rep.Request.Headers["User-Agent"] != "curl/8" {
+		t.Fatalf("reported request %+v", rep.Request)
+	}
+	b, _ := json.Marshal(rep.Request)
+	for _, leak := range []string{"secret text", "X-Api-Key", `"` + testProKey + `"`} {
+		if strings.Contains(string(b), leak) {
+			t.Fatalf("reported request keeps %q: %s", leak, b)
+		}
+	}
+
+	for _, q := range []string{"one", "two", "three", "four"} {
+		if w := serve(h, "GET", "/go/translate?q="+q, "", "X-API-Key", testProKey); w.Code < 500 {
+			t.Fatalf("translate %s against the failing upstream: status %d", q, w.Code)
+		}
+	}
+	rep = nextReport(t, reports)
+	if rep.Kind != "upstream_5xx_burst" || rep.Count != 3 || !strings.HasPrefix(rep.Message, "3 upstream 5xx in 1m0s") || rep.Route != "/go/translate" || rep.Request.Query["q"] != "[5 chars]" {
+		t.Fatalf("burst report %+v", rep)
+	}
+	select {
+	case rep := <-reports:
+		t.Fatalf("a second report in the same burst window: %+v", rep)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestErrorReporterLimits checks reports over the per-minute rate are dropped, those queued are
+// delivered before stop returns, and that without a sink there is no reporter at all.
+func TestErrorReporterLimits(t *testing.T) {
+	if rep := newErrorReporter(errorReporterOpts{PerMin: 10, Clock: systemClock{}}); rep != nil {
+		t.Fatal("a reporter with nowhere to send")
+	}
+	var rep *errorReporter
+	rep.panicked(httptest.NewRequest("GET", "/", nil), "ignored", "")
+	rep.upstreamFailed(context.Background(), &upstreamError{Status: 502})
+	if err := rep.stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	hook, reports := reportHook(t)
+	u, _ := url.Parse(hook.URL)
+	rep = newErrorReporter(errorReporterOpts{WebhookURL: u, PerMin: 3, Clock: NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))})
+	for range 5 {
+		rep.panicked(httptest.NewRequest("GET", "/go/translate", nil), "storm", "")
+	}
+	if err := rep.stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 || rep.dropped.Load() != 2 {
+		t.Fatalf("%d delivered by stop, %d dropped; want 3 and 2", len(reports), rep.dropped.Load())
+	}
+	rep.panicked(httptest.NewRequest("GET", "/go/translate", nil), "after stop", "")
+	if rep.dropped.Load() != 3 {
+		t.Fatal("a report captured after stop wasn't dropped")
+	}
+}
+
+// TestSentrySink checks an event reaches the DSN's envelope endpoint with its auth header.
+func TestSentrySink(t *testing.T) {
+	var (
+		path, auth string
+		lines      []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		path, auth, lines = r.URL.Path, r.Header.Get("X-Sentry-Auth"), strings.Split(strings.TrimSpace(string(b)), "\n")
+	}))
+	defer srv.Close()
+	dsn, _ := url.Parse(strings.Replace(srv.URL, "http://", "http://publickey@", 1) + "/sentry/42")
+	if !validSentryDSN(dsn) {
+		t.Fatalf("%s refused", dsn)
+	}
+	s := newSentrySink(dsn, srv.Client())
+	err := s.send(context.Background(), errorReport{Kind: "panic", Message: "boom", Route: "/go/translate", RequestID: "r1", Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/sentry/api/42/envelope/" || !strings.Contains(auth, "sentry_key=publickey") || len(lines) != 3 {
+		t.Fatalf("POST %s, X-Sentry-Auth %q, %d envelope lines", path, auth, len(lines))
+	}
+	var event struct {
+		Level   string            `json:"level"`
+		Message map[string]string `json:"message"`
+		Tags    map[string]string `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &event); err != nil || event.Level != "fatal" || event.Message["formatted"] != "boom" || event.Tags["request_id"] != "r1" {
+		t.Fatalf("event %s (%v)", lines[2], err)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// errorCode is the machine-readable half of an error response. Codes are part of the API:
+// clients switch on them, so existing ones never change meaning; messages may be reworded.
+type errorCode string
+
+const (
+	codeBadRequest       errorCode = "BAD_REQUEST"            // malformed input: invalid JSON, bad parameter values
+	codeInvalidUTF8      errorCode = "INVALID_UTF8"           // q is not valid UTF-8
+	codeControlChars     errorCode = "CONTROL_CHARACTERS"     // q has C0/C1 control characters and INPUT_CONTROL_CHARS=reject
+	codeBidiOverride     errorCode = "BIDI_OVERRIDE"          // q has bidi embeddings, overrides or isolates without allow_bidi
+	codeMissingQuery     errorCode = "MISSING_QUERY"          // q (or an item's q or id) is missing or empty
+	codeUnsupportedPair  errorCode = "UNSUPPORTED_PAIR"       // no translation for src → dst; details list the supported pairs
+	codeUnauthorized     errorCode = "UNAUTHORIZED"           // missing or invalid API key, bearer token, admin or metrics token
+	codeForbidden        errorCode = "FORBIDDEN"              // disabled key, refused IP, bad edge signature
+	codeUpgradeRequired  errorCode = "UPGRADE_REQUIRED"       // the feature needs a pro key
+	codeNotFound         errorCode = "NOT_FOUND"              // no such route or resource
+	codeMethodNotAllowed errorCode = "METHOD_NOT_ALLOWED"     // the route exists but not for this method
+	codeNotAcceptable    errorCode = "NOT_ACCEPTABLE"         // no representation matches Accept
+	codeConflict         errorCode = "CONFLICT"               // an Idempotency-Key reused for a different request, a replayed edge nonce, a job not in a state for the call
+	codeRejected         errorCode = "REJECTED"               // well-formed, but what it points at failed validation (a pack reload)
+	codePayloadTooLarge  errorCode = "PAYLOAD_TOO_LARGE"      // body or batch over its limit
+	codeUnsupportedMedia errorCode = "UNSUPPORTED_MEDIA_TYPE" // wrong Content-Type
+	codeRateLimited      errorCode = "RATE_LIMITED"           // request rate over the key's or IP's limit; see dimension and retry_after
+	codeQuotaExceeded    errorCode = "QUOTA_EXCEEDED"         // daily character quota spent; see dimension and reset_at
+	codeUpstreamRejected errorCode = "UPSTREAM_REJECTED"      // the translation backend refused the input (its 4xx)
+	codeUpstreamDown     errorCode = "UPSTREAM_UNAVAILABLE"   // the translation backend failed or its breaker is open
+	codeUpstreamSchema   errorCode = "UPSTREAM_SCHEMA"        // the translation backend answered 2xx with a body that isn't a translation; see detail
+	codeOverloaded       errorCode = "OVERLOADED"             // too many requests in flight; see retry_after
+	codeMaintenance      errorCode = "MAINTENANCE"            // maintenance mode; see retry_after
+	codeTimeout          errorCode = "TIMEOUT"                // the route's time budget ran out
+	codeDeadlineExceeded errorCode = "DEADLINE_EXCEEDED"      // the deadline the caller gave in X-Deadline-Ms ran out
+	codeNotImplemented   errorCode = "NOT_IMPLEMENTED"        // not available with this configuration
+	codeNotCovered       errorCode = "NOT_COVERED"            // TRANSLATE_MODE=pack_only and no pack or cached translation for the input
+	codeStarting         errorCode = "STARTING"               // the instance is still starting up; see /go/health/startup and retry_after
+	codeCancelled        errorCode = "CANCELLED"              // an operator cancelled the request (status 499)
+	codeInvalidCursor    errorCode = "INVALID_CURSOR"         // a list's cursor was altered, or came from another list or other filters
+	codeScopeDenied      errorCode = "SCOPE_DENIED"           // the key's scopes don't cover the route, direction or time; see missing_scope
+	codeFaultInjected    errorCode = "FAULT_INJECTED"         // a fault injected for testing, outside production; see X-DHK-Fault
+	codeInternal         errorCode = "INTERNAL"               // a bug or a failure on our side
+)
+
+// errorCodes lists every code, for the API document.
+var errorCodes = []errorCode{
+	codeBadRequest, codeInvalidUTF8, codeControlChars, codeBidiOverride, codeMissingQuery, codeUnsupportedPair, codeUnauthorized, codeForbidden,
+	codeUpgradeRequired, codeNotFound, codeMethodNotAllowed, codeNotAcceptable, codeConflict,
+	codeRejected, codePayloadTooLarge, codeUnsupportedMedia, codeRateLimited, codeQuotaExceeded,
+	codeUpstreamRejected, codeUpstreamDown, codeUpstreamSchema, codeOverloaded, codeMaintenance, codeTimeout,
+	codeDeadlineExceeded, codeNotImplemented, codeNotCovered, codeStarting, codeCancelled, codeInvalidCursor, codeScopeDenied, codeFaultInjected, codeInternal,
+}
+
+// writeError is the one way an HTTP error leaves this service:
+//
+//	{"error": {"code": "MISSING_QUERY", "message": "...", "request_id": "...", ...details}}
+//
+// details are key/value pairs, as with slog, added next to the message (retry_after, detail,
+// supported, ...). request_id is set whenever the request got one, so a client can quote it.
+func writeError(w http.ResponseWriter, status int, code errorCode, msg string, details ...any) {
+	body := map[string]any{"code": code, "message": msg}
+	for i := 0; i+1 < len(details); i += 2 {
+		body[fmt.Sprint(details[i])] = details[i+1]
+	}
+	if id := w.Header().Get(middleware.RequestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	j(w, status, map[string]any{"error": body})
+}
+
+// httpError carries a status code and error code alongside a client-facing message.
+type httpError struct {
+	code    int
+	errCode errorCode
+	msg     string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func (e *httpError) write(w http.ResponseWriter) { writeError(w, e.code, e.errCode, e.msg) }
+
+// notFound replaces chi's plain-text default, as methodNotAllowedOn does for its 405.
+func notFound(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, http.StatusNotFound, codeNotFound, "not found")
+}
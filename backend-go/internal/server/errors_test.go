@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// checkEnvelope fails unless w is an error in the envelope writeError produces, with a listed
+// code and the response's request id.
+func checkEnvelope(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("status %d with Content-Type %q: %s", w.Code, ct, w.Body.String())
+	}
+	var env map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(w.Body.Bytes()))
+	if err := dec.Decode(&env); err != nil || len(env) != 1 || env["error"] == nil {
+		t.Fatalf("status %d body not an error envelope: %s", w.Code, w.Body.String())
+	}
+	var e struct {
+		Code      errorCode `json:"code"`
+		Message   string    `json:"message"`
+		RequestID string    `json:"request_id"`
+	}
+	if err := json.Unmarshal(env["error"], &e); err != nil {
+		t.Fatalf("status %d error object: %v: %s", w.Code, err, w.Body.String())
+	}
+	if !slices.Contains(errorCodes, e.Code) {
+		t.Fatalf("status %d code %q isn't in errorCodes", w.Code, e.Code)
+	}
+	if e.Message == "" {
+		t.Fatalf("status %d code %s without a message", w.Code, e.Code)
+	}
+	if id := w.Header().Get(middleware.RequestIDHeader); id == "" || e.RequestID != id {
+		t.Fatalf("status %d request_id %q, header %q", w.Code, e.RequestID, id)
+	}
+}
+
+// TestErrorEnvelopeOnEveryRoute sends every route bad requests, without credentials, with
+// credentials and a malformed body, with a query it can't use and with a method it doesn't
+// take, and checks each error that comes back is in the envelope.
+func TestErrorEnvelopeOnEveryRoute(t *testing.T) {
+	s := fullTestServer(t)
+	h := s.Handler()
+	bad := []struct {
+		name    string
+		method  string // "" keeps the route's
+		query   string
+		body    string
+		headers []string
+	}{
+		{"anonymous", "", "", "", nil},
+		{"key and malformed body", "", "", `{"q":`, []string{"X-API-Key", testProKey, "Content-Type", "application/json"}},
+		{"admin and malformed body", "", "", `{"q":`, []string{"Authorization", "Bearer " + testAdmin, "Content-Type", "application/json"}},
+		{"key and bad query", "", "?q=%FF&src=zz&limit=-1&cursor=nope", "", []string{"X-API-Key", testProKey}},
+		{"wrong content type", "", "", "q=hello", []string{"X-API-Key", testProKey, "Content-Type", "text/csv"}},
+		{"unacceptable", "", "", "", []string{"X-API-Key", testProKey, "Accept", "image/png"}},
+		{"method", "TRACE", "", "", []string{"X-API-Key", testProKey}},
+	}
+	errs := 0
+	for _, key := range goRoutes(t, s) {
+		method, route, _ := strings.Cut(key, " ")
+		// Probes answer 503 with their checks, and ENABLE_DEBUG's profiles are net/http/pprof's own.
+		if route == "/go/ready" || strings.HasPrefix(route, "/go/health") || strings.HasPrefix(route, "/go/debug/pprof/") {
+			continue
+		}
+		t.Run(key, func(t *testing.T) {
+			for _, b := range bad {
+				m := method
+				if b.method != "" {
+					m = b.method
+				}
+				// Bounded, for the long-lived routes should one accept the request.
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				r := httptest.NewRequest(m, routePath(route)+b.query, strings.NewReader(b.body)).WithContext(ctx)
+				for i := 0; i+1 < len(b.headers); i += 2 {
+					r.Header.Set(b.headers[i], b.headers[i+1])
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				cancel()
+				if w.Code < 400 {
+					continue
+				}
+				errs++
+				t.Run(b.name, func(t *testing.T) { checkEnvelope(t, w) })
+			}
+		})
+	}
+	if errs == 0 {
+		t.Fatal("no route answered a bad request with an error")
+	}
+	t.Run("no route", func(t *testing.T) { checkEnvelope(t, serve(h, "GET", "/go/nope", "")) })
+}
+
+func TestErrorEnvelopeFromMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		mw      func(http.Handler) http.Handler
+		handler http.HandlerFunc
+		status  int
+		code    errorCode
+	}{
+		{"panic", recoverer(nil), func(http.ResponseWriter, *http.Request) { panic("boom") }, http.StatusInternalServerError, codeInternal},
+		{"route timeout", routeTimeout(20*time.Millisecond, 0), func(_ http.ResponseWriter, r *http.Request) { <-r.Context().Done() }, http.StatusGatewayTimeout, codeTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := middleware.RequestID(tt.mw(tt.handler))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/go/translate", nil)
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			var env struct {
+				Error struct {
+					Code errorCode `json:"code"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &env)
+			if env.Error.Code != tt.code {
+				t.Fatalf("code %q, want %q: %s", env.Error.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheRow is one exported cache entry.
+type cacheRow struct {
+	Source      string    `json:"source"`
+	Translation string    `json:"translation"`
+	Src         string    `json:"-"`
+	Dst         string    `json:"-"`
+	Extended    bool      `json:"-"`
+	Glossary    bool      `json:"-"` // kept apart for one caller's own glossary
+	Hits        int64     `json:"hit_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (r cacheRow) direction() string { return r.Src + "-" + r.Dst }
+
+// rowFromKey recovers the phrase and direction from a cacheKey.
+func rowFromKey(key string) cacheRow {
+	parts := strings.Split(key, "\x00")
+	if len(parts) < 3 {
+		return cacheRow{Source: key}
+	}
+	row := cacheRow{Src: parts[0], Dst: parts[1], Source: parts[2], Extended: slices.Contains(parts[3:], "x")}
+	for _, p := range parts[3:] {
+		row.Glossary = row.Glossary || strings.HasPrefix(p, "g")
+	}
+	return row
+}
+
+// cacheExporter is implemented by caches that can enumerate their entries.
+type cacheExporter interface {
+	Export(ctx context.Context, since time.Time, fn func(cacheRow) error) error
+}
+
+// exportMaxDuration bounds writing one export, and sending one; the latter replaces the
+// server's WriteTimeout for the response.
+const exportMaxDuration = 10 * time.Minute
+
+// cacheExportHandler serves GET /go/admin/cache/export?format=csv|jsonl[&since=]. It reads the
+// persistent store when CACHE_DB_PATH is set, since that holds the longest history, and the
+// in-memory cache otherwise; Redis can't be exported. since is RFC 3339 or a date. The export
+// is an artifact (artifacts.go): a large one answers 202 with where to download it.
+func cacheExportHandler(ct *cachedTranslator, arts *artifactStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var src cacheExporter
+		if ct.store != nil {
+			src = ct.store
+		} else if e, ok := ct.cache.(cacheExporter); ok {
+			src = e
+		} else {
+			writeError(w, http.StatusNotImplemented, codeNotImplemented, "this cache backend can't be exported; set CACHE_DB_PATH")
+			return
+		}
+		q := r.URL.Query()
+		format := q.Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "jsonl" {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "format must be csv or jsonl")
+			return
+		}
+		var since time.Time
+		if v := q.Get("since"); v != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, v); err != nil {
+				if since, err = time.Parse(time.DateOnly, v); err != nil {
+					writeError(w, http.StatusBadRequest, codeBadRequest, "since must be RFC 3339 or YYYY-MM-DD")
+					return
+				}
+			}
+		}
+
+		auditParam(r.Context(), "format", format)
+
+		spec := artifactSpec{
+			kind:        "cache",
+			owner:       artifactAdmin,
+			name:        "dhkalign-cache-" + ct.clock.Now().UTC().Format("20060102T150405Z") + "." + format,
+			contentType: "application/x-ndjson",
+		}
+		if format == "csv" {
+			spec.contentType = "text/csv; charset=utf-8"
+		}
+		spec.write = func(ctx context.Context, w io.Writer) (int, error) {
+			var write func(cacheRow) error
+			flush := func() error { return nil }
+			if format == "csv" {
+				cw := csv.NewWriter(w)
+				_ = cw.Write([]string{"source", "translation", "direction", "hit_count", "created_at"})
+				write = func(row cacheRow) error {
+					return cw.Write([]string{row.Source, row.Translation, row.direction(), strconv.FormatInt(row.Hits, 10), row.CreatedAt.UTC().Format(time.RFC3339)})
+				}
+				flush = func() error {
+					cw.Flush()
+					return cw.Error()
+				}
+			} else {
+				enc := json.NewEncoder(w)
+				write = func(row cacheRow) error {
+					return enc.Encode(struct {
+						cacheRow
+						Direction string `json:"direction"`
+					}{row, row.direction()})
+				}
+			}
+			n := 0
+			err := src.Export(ctx, since, func(row cacheRow) error {
+				n++
+				return write(row)
+			})
+			if err == nil {
+				err = flush()
+			}
+			return n, err
+		}
+		arts.serve(w, r, spec)
+	}
+}
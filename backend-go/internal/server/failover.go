@@ -0,0 +1,491 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// upstreamMember is one backend in UPSTREAM_URLS with its own breaker and poller.
+type upstreamMember struct {
+	name    string // the URL's host, for the X-Upstream header, metrics and logs
+	client  *upstreamClient
+	tr      Translator      // client wrapped in error reporting and the breaker
+	breaker *breaker        // nil when BREAKER_FAILURES is 0
+	poller  *upstreamPoller // nil when UPSTREAM_POLL_INTERVAL is 0
+	weight  atomic.Int64    // share of traffic; adjustable at /go/admin/upstreams
+
+	// Outcomes since start, for judging a canary at /go/admin/upstreams.
+	ok, rejected, failed atomic.Uint64
+	latency              latencyHistogram
+}
+
+// healthy reports whether the member should be tried ahead of the others: its last health poll
+// passed and its breaker isn't open.
+func (m *upstreamMember) healthy() bool {
+	if m.poller != nil && !m.poller.up() {
+		return false
+	}
+	return m.breaker == nil || m.breaker.available()
+}
+
+// ping is the member's readiness probe: its last poll, or a live health check without a poller.
+func (m *upstreamMember) ping(ctx context.Context) error {
+	if m.poller != nil {
+		return m.poller.Probe(ctx)
+	}
+	return m.client.Ping(ctx)
+}
+
+// failoverTranslator sends each call to the first healthy upstream in UPSTREAM_URLS order and
+// falls down the list when one fails outright (no response, a 5xx, an open breaker) while the
+// request's deadline allows. A 4xx is the upstream's answer and is returned as is. Health is
+// judged afresh on every call, so traffic returns to the primary as soon as it recovers. When
+// every member looks unhealthy they are all tried anyway, in order, rather than failing unasked.
+//
+// With weights (UPSTREAM_URLS=https://a=95,https://b=5) the first try goes to a healthy member
+// picked at random in proportion to its weight, and the others follow in list order. A weight of
+// 0 takes no traffic of its own but still serves as a fallback; with every weight 0 the order is
+// plain failover.
+//
+// A config reload that changes the upstream settings replaces the members whole (see replace);
+// calls already under way finish on the members they started with.
+type failoverTranslator struct {
+	cur   atomic.Pointer[[]*upstreamMember]
+	pick  func(n int64) int64 // rand.Int63n
+	hedge atomic.Int64        // HEDGE_DELAY as a time.Duration; 0 never hedges
+	sinks metricSinks
+	clock Clock
+
+	mu        sync.Mutex
+	pollCtx   context.Context // set by startPolls
+	stopPolls context.CancelFunc
+}
+
+func newFailoverTranslator(members []*upstreamMember, sinks metricSinks, clk Clock) *failoverTranslator {
+	f := &failoverTranslator{pick: rand.Int63n, sinks: sinks, clock: clk}
+	f.cur.Store(&members)
+	return f
+}
+
+// newUpstreamMembers builds one member per UPSTREAM_URLS entry, sharing one traced client over rt
+// for every upstream call, translate and health alike. Their adaptive timeouts follow flags, and
+// their calls are counted in sinks too.
+func newUpstreamMembers(cfg Config, rt http.RoundTripper, reporter *errorReporter, maint *maintenanceMode, flags *featureFlags, sinks metricSinks, clk Clock) []*upstreamMember {
+	hc := newUpstreamHTTPClient(cfg.UpstreamTimeout, rt, clk)
+	var members []*upstreamMember
+	for i, base := range cfg.UpstreamURLs {
+		m := &upstreamMember{name: base.Host, client: newUpstreamClient(base, hc, cfg.UpstreamMaxAttempts, cfg.UpstreamRetryBase, clk)}
+		m.client.strict, m.client.sinks = cfg.UpstreamStrict, sinks
+		if cfg.AdaptiveTimeout {
+			m.client.timeouts = newAdaptiveTimeouts(adaptiveTimeoutOpts{
+				Ceiling:    cfg.UpstreamTimeout,
+				Floor:      cfg.AdaptiveTimeoutFloor,
+				Quantile:   cfg.AdaptiveTimeoutQuantile,
+				Factor:     cfg.AdaptiveTimeoutFactor,
+				MinSamples: cfg.AdaptiveTimeoutSamples,
+			}, flags, clk)
+		}
+		m.weight.Store(cfg.UpstreamWeights[i])
+		if cfg.UpstreamExtended != "" {
+			m.client.extended = base.JoinPath(cfg.UpstreamExtended)
+		}
+		m.tr = m.client
+		if reporter != nil {
+			m.tr = &reportingTranslator{next: m.client, rep: reporter}
+		}
+		if cfg.UpstreamPollInterval > 0 {
+			m.poller = newUpstreamPoller(m.name, m.client.Ping, cfg.UpstreamPollInterval, cfg.UpstreamPollTimeout, cfg.UpstreamPollWindow, maint, clk)
+		}
+		if cfg.BreakerFailures > 0 {
+			m.breaker = newBreaker(m.name, m.tr, cfg.BreakerFailures, cfg.BreakerCooldown, clk)
+			if m.poller != nil {
+				m.breaker.okSince = m.poller.okSince
+			}
+			m.tr = m.breaker
+		}
+		members = append(members, m)
+	}
+	return members
+}
+
+func (f *failoverTranslator) members() []*upstreamMember { return *f.cur.Load() }
+
+// startPolls runs the members' health pollers, and those of any later replacement, until ctx
+// is done.
+func (f *failoverTranslator) startPolls(ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pollCtx = ctx
+	f.stopPolls = f.runPolls(f.members())
+}
+
+// runPolls starts members' pollers under f.pollCtx; the returned func stops them. The caller
+// holds f.mu.
+func (f *failoverTranslator) runPolls(members []*upstreamMember) context.CancelFunc {
+	ctx, cancel := context.WithCancel(f.pollCtx)
+	for _, m := range members {
+		if m.poller != nil {
+			go m.poller.run(ctx)
+		}
+	}
+	return cancel
+}
+
+// replace swaps in members, for a config reload: their pollers start, the old ones' stop and the
+// old client's idle connections are closed. Breaker state, counters and weights set at
+// /go/admin/upstreams start over with the new members, and the breaker gauge drops the upstreams
+// that no longer have one.
+func (f *failoverTranslator) replace(members []*upstreamMember) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.members()
+	f.cur.Store(&members)
+	for _, m := range old {
+		if m.breaker != nil && !slices.ContainsFunc(members, func(n *upstreamMember) bool { return n.breaker != nil && n.name == m.name }) {
+			metricBreakerState.DeleteLabelValues(m.name)
+		}
+	}
+	if f.pollCtx != nil {
+		f.stopPolls()
+		f.stopPolls = f.runPolls(members)
+	}
+	if len(old) > 0 {
+		old[0].client.client.CloseIdleConnections()
+	}
+	slog.Info("upstreams replaced", "upstreams", f.names())
+}
+
+// order lists the members in the order this call should try them.
+func (f *failoverTranslator) order() []*upstreamMember {
+	var up, down []*upstreamMember
+	var total int64
+	for _, m := range f.members() {
+		if m.healthy() {
+			up = append(up, m)
+			total += m.weight.Load()
+		} else {
+			down = append(down, m)
+		}
+	}
+	if total > 0 {
+		n := f.pick(total)
+		for i, m := range up {
+			if n -= m.weight.Load(); n < 0 {
+				copy(up[1:i+1], up[:i])
+				up[0] = m
+				break
+			}
+		}
+	}
+	return append(up, down...)
+}
+
+func (f *failoverTranslator) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	order := f.order()
+	var (
+		res   translateResult
+		err   error
+		tried int
+	)
+	if delay := f.hedgeDelay(ctx, order); delay > 0 {
+		var final bool
+		if res, err, tried, final = f.hedged(ctx, req, order, delay); final {
+			return res, err
+		}
+	}
+	for i := tried; i < len(order); i++ {
+		m := order[i]
+		res, err = call(ctx, m, req)
+		if err == nil {
+			res.Upstream = m.name
+			return res, nil
+		}
+		if settled(ctx, err) || i == len(order)-1 {
+			break
+		}
+		metricUpstreamFailovers.WithLabelValues(m.name).Inc()
+		slog.Warn("upstream failed; trying the next one", "request_id", middleware.GetReqID(ctx),
+			"upstream", m.name, "next", order[i+1].name, "err", err)
+	}
+	return res, err
+}
+
+// call makes one attempt on m, timing it and counting its outcome. An attempt cancelled because
+// the other half of a hedge answered first is counted as "cancelled" and left out of the latency
+// figures, which it would only drag down.
+func call(ctx context.Context, m *upstreamMember, req translateReq) (translateResult, error) {
+	start := time.Now()
+	stage := startStage(ctx, "upstream")
+	done := upstreamStarted(ctx)
+	answered := noteInflightUpstream(ctx, m.name)
+	timeout := m.client.timeouts.pick(req.Q)
+	stage.noteTimeout(timeout)
+	res, err := m.tr.Translate(withUpstreamTimeout(ctx, timeout), req)
+	answered()
+	lost := err != nil && errors.Is(context.Cause(ctx), errHedgeLost)
+	var open *breakerOpenError
+	if !errors.As(err, &open) {
+		done(m.name, attemptsOf(res, err), err)
+		if !lost {
+			d := time.Since(start)
+			m.latency.observe(d)
+			metricUpstreamLatency.WithLabelValues(m.name).Observe(d.Seconds())
+			m.client.sinks.timing("upstream.request_duration", d, "upstream", m.name)
+		}
+	}
+	var ue *upstreamError
+	switch {
+	case err == nil:
+		m.ok.Add(1)
+		countUpstreamRequest(m.client.sinks, m.name, "ok")
+		stage.finish(stageOK, m.name, attemptsOf(res, err), nil)
+	case errors.As(err, &ue) && ue.clientError():
+		m.rejected.Add(1)
+		countUpstreamRequest(m.client.sinks, m.name, "rejected")
+		stage.finish("rejected", m.name, attemptsOf(res, err), err)
+	case lost:
+		countUpstreamRequest(m.client.sinks, m.name, "cancelled")
+		stage.finish("cancelled", m.name, attemptsOf(res, err), nil)
+	default:
+		m.failed.Add(1)
+		countUpstreamRequest(m.client.sinks, m.name, "failed")
+		outcome := stageFailed
+		if open != nil {
+			outcome = "breaker_open"
+		}
+		stage.finish(outcome, m.name, attemptsOf(res, err), err)
+	}
+	return res, err
+}
+
+// settled reports whether err ends the call with no point trying another upstream: a 4xx is the
+// upstream's answer, and a request already out of time can't use one.
+func settled(ctx context.Context, err error) bool {
+	var ue *upstreamError
+	return errors.As(err, &ue) && ue.clientError() || ctx.Err() != nil
+}
+
+// Hedging. A single translate whose first upstream attempt hasn't answered within HEDGE_DELAY
+// gets one more attempt, on the next upstream in order or on the same one when it is the only
+// member. Whichever answers first is used and the other is cancelled. Batches, jobs and cache
+// warming are not latency-bound and never hedge, and neither does any call while a member's
+// breaker shows stress: an upstream that is already failing doesn't need twice the traffic.
+
+var errHedgeLost = errors.New("hedge: the other attempt answered first")
+
+type hedgeKey struct{}
+
+// withHedging marks ctx as a single, latency-sensitive translate that may be hedged.
+func withHedging(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hedgeKey{}, true)
+}
+
+// hedgeDelay is how long this call waits on its first attempt before hedging; 0 doesn't hedge.
+func (f *failoverTranslator) hedgeDelay(ctx context.Context, order []*upstreamMember) time.Duration {
+	d := time.Duration(f.hedge.Load())
+	if d <= 0 || ctx.Value(hedgeKey{}) == nil || len(order) == 0 || !order[0].healthy() {
+		return 0
+	}
+	for _, m := range order {
+		if m.breaker != nil && m.breaker.stressed() {
+			return 0
+		}
+	}
+	return d
+}
+
+// hedged runs the first attempt, and a second once delay passes without an answer. It returns
+// how many members of order it has used up and whether its result is final; when it isn't, both
+// attempts failed and failover carries on down the rest of order.
+func (f *failoverTranslator) hedged(ctx context.Context, req translateReq, order []*upstreamMember, delay time.Duration) (translateResult, error, int, bool) {
+	type outcome struct {
+		m     *upstreamMember
+		res   translateResult
+		err   error
+		hedge bool
+	}
+	results := make(chan outcome, 2)
+	var cancels []context.CancelCauseFunc
+	defer func() {
+		for _, cancel := range cancels {
+			cancel(errHedgeLost)
+		}
+	}()
+	start := func(m *upstreamMember, hedge bool) {
+		actx, cancel := context.WithCancelCause(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			res, err := call(actx, m, req)
+			results <- outcome{m, res, err, hedge}
+		}()
+	}
+
+	start(order[0], false)
+	timer := f.clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case first := <-results:
+		if first.err == nil {
+			first.res.Upstream = first.m.name
+			return first.res, nil, 1, true
+		}
+		return first.res, first.err, 1, settled(ctx, first.err)
+	case <-timer.C():
+	}
+
+	tried, next := 1, order[0]
+	if len(order) > 1 {
+		tried, next = 2, order[1]
+	}
+	metricUpstreamHedges.WithLabelValues(next.name).Inc()
+	f.sinks.count("upstream.hedges", 1, "upstream", next.name)
+	slog.Debug("upstream slow; hedging", "request_id", middleware.GetReqID(ctx), "upstream", order[0].name, "hedge", next.name, "after", delay)
+	start(next, true)
+
+	var o outcome
+	for range 2 {
+		if o = <-results; o.err == nil || settled(ctx, o.err) {
+			break
+		}
+	}
+	if o.err != nil {
+		return o.res, o.err, tried, settled(ctx, o.err)
+	}
+	if o.hedge {
+		metricUpstreamHedgeWins.WithLabelValues(o.m.name).Inc()
+		f.sinks.count("upstream.hedge_wins", 1, "upstream", o.m.name)
+	}
+	o.res.Upstream = o.m.name
+	return o.res, nil, tried, true
+}
+
+// countUpstreamRequest counts one translate call to upstream by outcome.
+func countUpstreamRequest(sinks metricSinks, upstream, outcome string) {
+	metricUpstreamRequests.WithLabelValues(upstream, outcome).Inc()
+	sinks.count("upstream.requests", 1, "upstream", upstream, "outcome", outcome)
+}
+
+// Ping passes while any member is reachable: failover covers the rest, so one region being down
+// shouldn't take the instance out of the load balancer.
+func (f *failoverTranslator) Ping(ctx context.Context) error {
+	var errs []string
+	for _, m := range f.members() {
+		err := m.ping(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, m.name+": "+err.Error())
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// names lists the members in order, for the startup log.
+func (f *failoverTranslator) names() []string {
+	members := f.members()
+	out := make([]string, len(members))
+	for i, m := range members {
+		out[i] = m.client.endpoint.Redacted()
+	}
+	return out
+}
+
+// upstreamInfo is one member as /go/admin/upstreams lists it.
+type upstreamInfo struct {
+	Name        string                     `json:"name"`
+	URL         string                     `json:"url"`
+	Weight      int64                      `json:"weight"`
+	Healthy     bool                       `json:"healthy"`
+	Breaker     string                     `json:"breaker,omitempty"`
+	OK          uint64                     `json:"ok"`
+	Rejected    uint64                     `json:"rejected"`
+	Failed      uint64                     `json:"failed"`
+	SuccessRate float64                    `json:"success_rate"` // ok / (ok + failed); rejections are the caller's
+	LatencyMS   map[string]float64         `json:"latency_ms"`
+	Timeouts    map[string]upstreamTimeout `json:"timeouts,omitempty"` // by length class, with UPSTREAM_ADAPTIVE_TIMEOUT
+}
+
+func (f *failoverTranslator) list() []upstreamInfo {
+	members := f.members()
+	out := make([]upstreamInfo, 0, len(members))
+	for _, m := range members {
+		p := m.latency.quantiles(0.50, 0.95, 0.99)
+		info := upstreamInfo{
+			Name:      m.name,
+			URL:       m.client.endpoint.Redacted(),
+			Weight:    m.weight.Load(),
+			Healthy:   m.healthy(),
+			OK:        m.ok.Load(),
+			Rejected:  m.rejected.Load(),
+			Failed:    m.failed.Load(),
+			LatencyMS: map[string]float64{"p50": p[0], "p95": p[1], "p99": p[2]},
+			Timeouts:  m.client.timeouts.current(),
+		}
+		if m.breaker != nil {
+			info.Breaker = m.breaker.current().String()
+		}
+		if n := info.OK + info.Failed; n > 0 {
+			info.SuccessRate = math.Round(float64(info.OK)/float64(n)*1e4) / 1e4
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// upstreamListHandler serves GET /go/admin/upstreams.
+func upstreamListHandler(f *failoverTranslator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j(w, http.StatusOK, map[string]any{"upstreams": f.list()})
+	}
+}
+
+// upstreamWeightsHandler serves PUT /go/admin/upstreams/weights, setting the weight of each named
+// upstream; the ones left out keep theirs. Weights last until changed again or the process
+// restarts, when UPSTREAM_URLS applies again.
+func upstreamWeightsHandler(f *failoverTranslator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Weights map[string]int64 `json:"weights"`
+		}
+		if herr := decodeJSONBody(r, &req); herr != nil {
+			herr.write(w)
+			return
+		}
+		auditParam(r.Context(), "weights", req.Weights)
+		if len(req.Weights) == 0 {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "missing field 'weights'")
+			return
+		}
+		members := f.members()
+		byName := make(map[string]*upstreamMember, len(members))
+		for _, m := range members {
+			byName[m.name] = m
+		}
+		for name, wt := range req.Weights {
+			if byName[name] == nil {
+				writeError(w, http.StatusNotFound, codeNotFound, "no upstream named "+name)
+				return
+			}
+			if wt < 0 {
+				writeError(w, http.StatusBadRequest, codeBadRequest, "weights must be >= 0")
+				return
+			}
+		}
+		for name, wt := range req.Weights {
+			byName[name].weight.Store(wt)
+		}
+		slog.Warn("upstream weights changed", "request_id", middleware.GetReqID(r.Context()),
+			"admin", adminFrom(r.Context()), "weights", req.Weights)
+		j(w, http.StatusOK, map[string]any{"upstreams": f.list()})
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testMembers builds members for the given upstream servers from cfg, with a second's timeout,
+// one attempt a call and no weights unless cfg says otherwise.
+func testMembers(t *testing.T, cfg Config, servers ...*httptest.Server) []*upstreamMember {
+	t.Helper()
+	cfg.UpstreamTimeout = cmp.Or(cfg.UpstreamTimeout, time.Second)
+	cfg.UpstreamMaxAttempts = cmp.Or(cfg.UpstreamMaxAttempts, 1)
+	for _, srv := range servers {
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.UpstreamURLs = append(cfg.UpstreamURLs, u)
+	}
+	if cfg.UpstreamWeights == nil {
+		cfg.UpstreamWeights = make([]int64, len(servers))
+	}
+	return newUpstreamMembers(cfg, http.DefaultTransport, nil, nil, nil, nil, systemClock{})
+}
+
+// TestWeightedUpstreams checks UPSTREAM_URLS weights split the first tries in proportion, that a
+// member marked down is skipped whatever its weight, and that /go/admin/upstreams changes the
+// weights at runtime and reports each member's success rate and latency.
+func TestWeightedUpstreams(t *testing.T) {
+	srvA := httptest.NewServer(&fakeUpstream{})
+	defer srvA.Close()
+	srvB := httptest.NewServer(&fakeUpstream{})
+	defer srvB.Close()
+	members := testMembers(t, Config{UpstreamWeights: []int64{95, 5}}, srvA, srvB)
+	a, b := members[0], members[1]
+	f := newFailoverTranslator(members, nil, systemClock{})
+
+	for _, tt := range []struct {
+		n    int64
+		want *upstreamMember
+	}{
+		{0, a}, {94, a}, {95, b}, {99, b},
+	} {
+		f.pick = func(total int64) int64 {
+			if total != 100 {
+				t.Fatalf("picking out of %d, want the weights' sum 100", total)
+			}
+			return tt.n
+		}
+		if order := f.order(); order[0] != tt.want || len(order) != 2 {
+			t.Errorf("pick %d: first try %s, want %s", tt.n, order[0].name, tt.want.name)
+		}
+	}
+
+	f.pick = rand.New(rand.NewSource(1)).Int63n
+	served := map[string]int{}
+	for range 1000 {
+		res, err := f.Translate(context.Background(), translateReq{Q: "salaam", Src: "dv", Dst: "en"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		served[res.Upstream]++
+	}
+	if served[a.name] < 920 || served[a.name] > 980 || served[a.name]+served[b.name] != 1000 {
+		t.Fatalf("1000 calls split %v, want about 95:5", served)
+	}
+
+	b.poller = &upstreamPoller{polled: true, lastErr: "connection refused"}
+	f.pick = func(total int64) int64 {
+		if total != 95 {
+			t.Fatalf("picking out of %d with %s down, want 95", total, b.name)
+		}
+		return total - 1
+	}
+	if order := f.order(); order[0] != a || order[1] != b {
+		t.Fatalf("with %s down: order %s, %s; want it last", b.name, order[0].name, order[1].name)
+	}
+	b.poller = nil
+
+	put := upstreamWeightsHandler(f)
+	for _, tt := range []struct {
+		body   string
+		status int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"weights":{"` + b.name + `":-1}}`, http.StatusBadRequest},
+		{`{"weights":{"elsewhere.test":10}}`, http.StatusNotFound},
+	} {
+		if w := serve(put, "PUT", "/go/admin/upstreams/weights", tt.body, "Content-Type", "application/json"); w.Code != tt.status {
+			t.Fatalf("PUT %s: status %d, want %d: %s", tt.body, w.Code, tt.status, w.Body.String())
+		}
+	}
+	if a.weight.Load() != 95 || b.weight.Load() != 5 {
+		t.Fatalf("weights %d, %d after refused changes", a.weight.Load(), b.weight.Load())
+	}
+	w := serve(put, "PUT", "/go/admin/upstreams/weights", `{"weights":{"`+a.name+`":0,"`+b.name+`":100}}`, "Content-Type", "application/json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT weights: status %d: %s", w.Code, w.Body.String())
+	}
+	f.pick = rand.Int63n
+	if res, err := f.Translate(context.Background(), translateReq{Q: "salaam", Src: "dv", Dst: "en"}); err != nil || res.Upstream != b.name {
+		t.Fatalf("after the change: served by %q (%v), want %s", res.Upstream, err, b.name)
+	}
+	// Both answer "fail" with a 500: one failure each.
+	f.Translate(context.Background(), translateReq{Q: "fail", Src: "dv", Dst: "en"})
+
+	w = serve(upstreamListHandler(f), "GET", "/go/admin/upstreams", "")
+	var list struct {
+		Upstreams []upstreamInfo `json:"upstreams"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Upstreams) != 2 {
+		t.Fatalf("list %s (%v)", w.Body.String(), err)
+	}
+	gotA, gotB := list.Upstreams[0], list.Upstreams[1]
+	if gotA.Name != a.name || gotA.Weight != 0 || gotB.Weight != 100 || !gotA.Healthy || !gotB.Healthy {
+		t.Fatalf("listed %+v, %+v; want weights 0 and 100", gotA, gotB)
+	}
+	for _, tt := range []struct {
+		got upstreamInfo
+		ok  int
+	}{
+		{gotA, served[a.name]},
+		{gotB, served[b.name] + 1},
+	} {
+		rate := math.Round(float64(tt.ok)/float64(tt.ok+1)*1e4) / 1e4
+		if tt.got.OK != uint64(tt.ok) || tt.got.Failed != 1 || tt.got.SuccessRate != rate || tt.got.LatencyMS["p50"] <= 0 || len(tt.got.LatencyMS) != 3 {
+			t.Errorf("%s listed %+v; want %d ok, 1 failed, success rate %v and latency quantiles", tt.got.Name, tt.got, tt.ok, rate)
+		}
+	}
+}
+
+// pacedUpstream answers its nth call after delays[n-1], the last delay repeating, counting the
+// calls it hears and those whose caller gave up first.
+type pacedUpstream struct {
+	delays           []time.Duration
+	calls, abandoned atomic.Int64
+}
+
+func (u *pacedUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/health" {
+		return
+	}
+	n := int(u.calls.Add(1))
+	select {
+	case <-time.After(u.delays[min(n, len(u.delays))-1]):
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "data": map[string]string{"tgt": "T(" + r.URL.Query().Get("q") + ")"}})
+	case <-r.Context().Done():
+		u.abandoned.Add(1)
+	}
+}
+
+// TestHedging pairs slow and fast upstreams and checks a single translate slower than
+// HEDGE_DELAY gets exactly one more attempt, the first answer wins and the loser is cancelled,
+// and that unmarked calls, a fast first answer and a stressed breaker don't hedge.
+func TestHedging(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	slow, fast := &pacedUpstream{delays: []time.Duration{2 * time.Second}}, &pacedUpstream{delays: []time.Duration{0}}
+	srvSlow, srvFast := httptest.NewServer(slow), httptest.NewServer(fast)
+	defer srvSlow.Close()
+	defer srvFast.Close()
+	members := testMembers(t, Config{BreakerFailures: 5, BreakerCooldown: time.Minute}, srvSlow, srvFast)
+	s, fa := members[0], members[1]
+	f := newFailoverTranslator(members, nil, systemClock{})
+	f.hedge.Store(int64(delay))
+	req := translateReq{Q: "salaam", Src: "dv", Dst: "en"}
+	hedges := func() float64 { return testutil.ToFloat64(metricUpstreamHedges.WithLabelValues(fa.name)) }
+	wins := func() float64 { return testutil.ToFloat64(metricUpstreamHedgeWins.WithLabelValues(fa.name)) }
+	cancelled := func() float64 { return testutil.ToFloat64(metricUpstreamRequests.WithLabelValues(s.name, "cancelled")) }
+
+	h0, w0, c0 := hedges(), wins(), cancelled()
+	start := time.Now()
+	res, err := f.Translate(withHedging(context.Background()), req)
+	if err != nil || res.Upstream != fa.name || res.Translation != "T(salaam)" {
+		t.Fatalf("hedged call: %+v, %v; want the fast upstream's answer", res, err)
+	}
+	if took := time.Since(start); took < delay || took > time.Second {
+		t.Fatalf("hedged call took %v, want just over the %v delay", took, delay)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for slow.abandoned.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if slow.calls.Load() != 1 || fast.calls.Load() != 1 || slow.abandoned.Load() != 1 {
+		t.Fatalf("slow %d calls (%d abandoned), fast %d; want one each and the slow one cancelled", slow.calls.Load(), slow.abandoned.Load(), fast.calls.Load())
+	}
+	if hedges()-h0 != 1 || wins()-w0 != 1 || cancelled()-c0 != 1 || s.failed.Load() != 0 || s.breaker.stressed() {
+		t.Fatalf("hedges +%v, wins +%v, cancelled +%v, slow failed %d; want the loser counted only as cancelled",
+			hedges()-h0, wins()-w0, cancelled()-c0, s.failed.Load())
+	}
+
+	// Only single translates marked by withHedging hedge.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*delay)
+	_, err = f.Translate(ctx, req)
+	cancel()
+	if err == nil || fast.calls.Load() != 1 || hedges()-h0 != 1 {
+		t.Fatalf("unmarked call: err %v, fast %d calls, %v hedges; want it left on the slow upstream", err, fast.calls.Load(), hedges()-h0)
+	}
+
+	// A failure counted against a breaker turns hedging off.
+	s.breaker.record(context.Background(), &upstreamError{Status: http.StatusBadGateway, Msg: "bad gateway"})
+	if !s.breaker.stressed() {
+		t.Fatal("a 502 left the breaker unstressed")
+	}
+	if got := f.hedgeDelay(withHedging(context.Background()), f.order()); got != 0 {
+		t.Fatalf("hedge delay %v with a stressed breaker, want none", got)
+	}
+
+	// A lone member is hedged on itself, and a first answer inside the delay isn't hedged at all.
+	lone := &pacedUpstream{delays: []time.Duration{2 * time.Second, 0, 0}}
+	srvLone := httptest.NewServer(lone)
+	defer srvLone.Close()
+	one := testMembers(t, Config{}, srvLone)
+	f = newFailoverTranslator(one, nil, systemClock{})
+	f.hedge.Store(int64(delay))
+	if res, err := f.Translate(withHedging(context.Background()), req); err != nil || res.Upstream != one[0].name || lone.calls.Load() != 2 {
+		t.Fatalf("lone member: %+v, %v after %d calls; want the second attempt's answer", res, err, lone.calls.Load())
+	}
+	lh := testutil.ToFloat64(metricUpstreamHedges.WithLabelValues(one[0].name))
+	if _, err := f.Translate(withHedging(context.Background()), req); err != nil || lone.calls.Load() != 3 || testutil.ToFloat64(metricUpstreamHedges.WithLabelValues(one[0].name)) != lh {
+		t.Fatalf("fast answer: err %v, %d calls; want no hedge", err, lone.calls.Load())
+	}
+}
@@ -0,0 +1,440 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Fault injection, for testing the retries, the breaker and the edge worker's fallbacks in
+// staging without breaking anything real. FAULTS, or PUT /go/admin/faults, lists faults to
+// inject at random into a share of requests:
+//
+//	FAULTS=upstream:error=0.5@503,/go/translate:latency=0.2@300ms~200ms,*:drop=0.01
+//
+// Each entry is target:fault=rate@arg. The target is "upstream", for calls to the translation
+// backend, a route path such as /go/translate (at any API version), or * for every route but
+// the admin and health ones. The faults are latency (arg a duration, ~ and a jitter added up to
+// it at random), error (arg the 5xx status, 503 by default), truncate (the body is cut off
+// halfway) and drop (the connection closes without an answer). A response a fault touched says
+// so in X-DHK-Fault, and with ?debug=1 in a provenance stage "fault", so a harness can tell an
+// injected failure from a real one; each is logged and counted in dhk_go_faults_injected_total.
+// Nothing is injected with ENV=production, whatever FAULTS says.
+
+const faultHeader = "X-DHK-Fault"
+
+// Faults.
+const (
+	faultLatency  = "latency"
+	faultError    = "error"
+	faultTruncate = "truncate"
+	faultDrop     = "drop"
+)
+
+const faultTargetUpstream = "upstream"
+
+// faultRule is one FAULTS entry.
+type faultRule struct {
+	Target  string        `json:"target"`
+	Fault   string        `json:"fault"`
+	Rate    float64       `json:"rate"`
+	Latency time.Duration `json:"latency,omitempty"`
+	Jitter  time.Duration `json:"jitter,omitempty"`
+	Status  int           `json:"status,omitempty"`
+}
+
+func (f faultRule) String() string {
+	s := f.Target + ":" + f.Fault + "=" + strconv.FormatFloat(f.Rate, 'g', -1, 64)
+	switch f.Fault {
+	case faultLatency:
+		s += "@" + f.Latency.String()
+		if f.Jitter > 0 {
+			s += "~" + f.Jitter.String()
+		}
+	case faultError:
+		s += "@" + strconv.Itoa(f.Status)
+	}
+	return s
+}
+
+// faultRules is FAULTS parsed.
+type faultRules []faultRule
+
+func (fs faultRules) String() string {
+	parts := make([]string, len(fs))
+	for i, f := range fs {
+		parts[i] = f.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseFaults reads a FAULTS value.
+func parseFaults(raw string) (faultRules, error) {
+	var out faultRules
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		target, spec, ok := strings.Cut(part, ":")
+		fault, val, ok2 := strings.Cut(spec, "=")
+		if !ok || !ok2 || target == "" {
+			return nil, fmt.Errorf("%s: want target:fault=rate[@arg]", part)
+		}
+		if target != faultTargetUpstream && target != "*" && !strings.HasPrefix(target, "/") {
+			return nil, fmt.Errorf("%s: target must be upstream, * or a route path", part)
+		}
+		rate, arg, _ := strings.Cut(val, "@")
+		f := faultRule{Target: target, Fault: fault}
+		var err error
+		if f.Rate, err = strconv.ParseFloat(rate, 64); err != nil || f.Rate < 0 || f.Rate > 1 {
+			return nil, fmt.Errorf("%s: rate must be between 0 and 1", part)
+		}
+		switch fault {
+		case faultLatency:
+			d, jitter, _ := strings.Cut(arg, "~")
+			if f.Latency, err = time.ParseDuration(d); err != nil || f.Latency < 0 {
+				return nil, fmt.Errorf("%s: latency wants @duration[~jitter]", part)
+			}
+			if jitter != "" {
+				if f.Jitter, err = time.ParseDuration(jitter); err != nil || f.Jitter < 0 {
+					return nil, fmt.Errorf("%s: jitter must be a duration", part)
+				}
+			}
+		case faultError:
+			f.Status = http.StatusServiceUnavailable
+			if arg != "" {
+				if f.Status, err = strconv.Atoi(arg); err != nil || f.Status < 500 || f.Status > 599 {
+					return nil, fmt.Errorf("%s: error wants a 5xx status", part)
+				}
+			}
+		case faultTruncate, faultDrop:
+			if arg != "" {
+				return nil, fmt.Errorf("%s: %s takes no argument", part, fault)
+			}
+		default:
+			return nil, fmt.Errorf("%s: unknown fault %q (valid: latency, error, truncate, drop)", part, fault)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// faultInjector holds the faults in effect. New builds none with ENV=production.
+type faultInjector struct {
+	rules  atomic.Pointer[faultRules]
+	sample func() float64 // rand.Float64
+
+	mu       sync.Mutex
+	injected map[string]uint64 // by "target:fault", since start
+}
+
+func newFaultInjector(rules faultRules) *faultInjector {
+	fi := &faultInjector{sample: rand.Float64, injected: map[string]uint64{}}
+	fi.set(rules)
+	return fi
+}
+
+func (fi *faultInjector) set(rules faultRules) {
+	fi.rules.Store(&rules)
+	if len(rules) > 0 {
+		slog.Warn("fault injection on", "faults", rules.String())
+	}
+}
+
+// roll picks the faults that fire for one request to target: every latency rule that comes up,
+// then the first error, drop or truncate that does.
+func (fi *faultInjector) roll(target string) (delay time.Duration, fault *faultRule) {
+	for _, f := range *fi.rules.Load() {
+		if !faultApplies(f.Target, target) || fi.sample() >= f.Rate {
+			continue
+		}
+		if f.Fault == faultLatency {
+			delay += f.Latency
+			if f.Jitter > 0 {
+				delay += time.Duration(fi.sample() * float64(f.Jitter))
+			}
+			continue
+		}
+		if fault == nil {
+			fault = &f
+		}
+	}
+	return delay, fault
+}
+
+// faultApplies reports whether a rule for ruleTarget covers target, a route path or upstream.
+func faultApplies(ruleTarget, target string) bool {
+	switch {
+	case ruleTarget == target:
+		return target != "/go/admin/faults"
+	case ruleTarget == "*":
+		return target != faultTargetUpstream && !strings.HasPrefix(target, "/go/admin") && !strings.HasPrefix(target, "/go/health")
+	}
+	return false
+}
+
+// count records one injected fault: metrics, log, the X-DHK-Fault note and the provenance.
+func (fi *faultInjector) count(ctx context.Context, target, fault string) {
+	fi.mu.Lock()
+	fi.injected[target+":"+fault]++
+	fi.mu.Unlock()
+	metricFaultsInjected.WithLabelValues(target, fault).Inc()
+	slog.Info("fault injected", "request_id", middleware.GetReqID(ctx), "target", target, "fault", fault)
+	if n, _ := ctx.Value(faultNoteKey{}).(*faultNote); n != nil {
+		n.add(target + ":" + fault)
+	}
+	startStage(ctx, "fault").end(stageInjected, target+":"+fault)
+}
+
+// faultSleep waits d, or until ctx is done.
+func faultSleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// faultNote collects the faults injected into one request, for X-DHK-Fault.
+type faultNote struct {
+	mu     sync.Mutex
+	faults []string
+}
+
+type faultNoteKey struct{}
+
+func (n *faultNote) add(f string) {
+	n.mu.Lock()
+	n.faults = append(n.faults, f)
+	n.mu.Unlock()
+}
+
+func (n *faultNote) header() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return strings.Join(n.faults, ", ")
+}
+
+// middleware injects the route faults, and reports those injected further down, upstream ones
+// included, in X-DHK-Fault. It runs early, ahead of compression and signing, so a truncated
+// or dropped response is cut off on the wire and not inside a buffer.
+func (fi *faultInjector) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := &faultNote{}
+		ctx := context.WithValue(r.Context(), faultNoteKey{}, n)
+		r = r.WithContext(ctx)
+		fw := &faultWriter{ResponseWriter: w, note: n}
+		path := apiPath(r.URL.Path)
+		delay, fault := fi.roll(path)
+		if delay > 0 {
+			fi.count(ctx, path, faultLatency)
+			if faultSleep(ctx, delay) != nil {
+				return
+			}
+		}
+		switch {
+		case fault == nil:
+			next.ServeHTTP(fw, r)
+		case fault.Fault == faultError:
+			fi.count(ctx, path, faultError)
+			writeError(fw, fault.Status, codeFaultInjected, "fault injected")
+		case fault.Fault == faultDrop:
+			fi.count(ctx, path, faultDrop)
+			panic(http.ErrAbortHandler)
+		case fault.Fault == faultTruncate:
+			fi.count(ctx, path, faultTruncate)
+			fw.hold = true
+			next.ServeHTTP(fw, r)
+			fw.truncate()
+		}
+	})
+}
+
+// faultWriter sets X-DHK-Fault with the status, and for truncate holds the body back to send
+// half of it.
+type faultWriter struct {
+	http.ResponseWriter
+	note  *faultNote
+	hold  bool
+	code  int
+	buf   bytes.Buffer
+	wrote bool
+}
+
+func (w *faultWriter) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	if w.hold {
+		if w.code == 0 {
+			w.code = code
+		}
+		return
+	}
+	w.wrote = true
+	if f := w.note.header(); f != "" {
+		w.Header().Set(faultHeader, f)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *faultWriter) Write(b []byte) (int, error) {
+	if w.hold {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		return w.buf.Write(b)
+	}
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// truncate sends the held response, its full Content-Length announced, and aborts halfway
+// through the body.
+func (w *faultWriter) truncate() {
+	w.hold = false
+	w.Header().Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	w.WriteHeader(max(w.code, http.StatusOK))
+	w.ResponseWriter.Write(w.buf.Bytes()[:w.buf.Len()/2])
+	http.NewResponseController(w.ResponseWriter).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+// Hijack hands the connection to a WebSocket upgrade; the library asserts http.Hijacker on the
+// writer itself rather than through Unwrap.
+func (w *faultWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *faultWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// transport injects the upstream faults into calls through next: the error is a synthetic
+// response, the drop a transport error, the truncate a body that fails halfway.
+func (fi *faultInjector) transport(next http.RoundTripper) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		ctx := req.Context()
+		delay, fault := fi.roll(faultTargetUpstream)
+		if delay > 0 {
+			fi.count(ctx, faultTargetUpstream, faultLatency)
+			if err := faultSleep(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case fault == nil:
+			return next.RoundTrip(req)
+		case fault.Fault == faultError:
+			fi.count(ctx, faultTargetUpstream, faultError)
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			body := `{"error":"fault injected"}`
+			return &http.Response{
+				Status: fmt.Sprintf("%d %s", fault.Status, http.StatusText(fault.Status)), StatusCode: fault.Status,
+				Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+				Header:        http.Header{"Content-Type": {"application/json"}, faultHeader: {faultError}},
+				Body:          io.NopCloser(strings.NewReader(body)),
+				ContentLength: int64(len(body)),
+				Request:       req,
+			}, nil
+		case fault.Fault == faultDrop:
+			fi.count(ctx, faultTargetUpstream, faultDrop)
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, errFaultDropped
+		}
+		fi.count(ctx, faultTargetUpstream, faultTruncate)
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(b[:len(b)/2]), errReader{io.ErrUnexpectedEOF}))
+		return resp, nil
+	})
+}
+
+var errFaultDropped = errors.New("fault injected: connection dropped")
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// faultsHandler serves GET /go/admin/faults: the faults in effect and how many of each were
+// injected. fi is nil with ENV=production, and the faults routes refuse.
+func faultsHandler(fi *faultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if fi == nil {
+			writeError(w, http.StatusForbidden, codeForbidden, "fault injection is disabled in production")
+			return
+		}
+		rules := *fi.rules.Load()
+		fi.mu.Lock()
+		injected := make(map[string]uint64, len(fi.injected))
+		for k, v := range fi.injected {
+			injected[k] = v
+		}
+		fi.mu.Unlock()
+		if rules == nil {
+			rules = faultRules{}
+		}
+		j(w, http.StatusOK, map[string]any{"faults": rules.String(), "rules": rules, "injected": injected})
+	}
+}
+
+// faultsPutHandler serves PUT /go/admin/faults: {"faults": "<FAULTS value>"} replaces the
+// faults until the next config reload that changes FAULTS; an empty value turns them all off.
+func faultsPutHandler(fi *faultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if fi == nil {
+			writeError(w, http.StatusForbidden, codeForbidden, "fault injection is disabled in production")
+			return
+		}
+		var req struct {
+			Faults *string `json:"faults"`
+		}
+		if herr := decodeJSONBody(r, &req); herr != nil {
+			herr.write(w)
+			return
+		}
+		if req.Faults == nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "missing field 'faults'")
+			return
+		}
+		auditParam(r.Context(), "faults", *req.Faults)
+		rules, err := parseFaults(*req.Faults)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		fi.set(rules)
+		faultsHandler(fi)(w, r)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFaultsUpstream injects 503s into half the upstream calls and checks the retries absorb
+// them: nearly every request is answered, only a real call reaches the upstream, and the
+// injected ones are counted and named in X-DHK-Fault and the provenance. With every call failing
+// the breaker then opens after BREAKER_FAILURES requests and stops calling at all, injected
+// faults included, until a probe after the cooldown finds the upstream well again.
+func TestFaultsUpstream(t *testing.T) {
+	var hits atomic.Int64
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		hits.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "data": map[string]string{"tgt": "T"}})
+	}))
+	defer up.Close()
+	h := newTestServer(t, map[string]string{
+		"UPSTREAM_URL":           up.URL,
+		"UPSTREAM_MAX_ATTEMPTS":  "4",
+		"UPSTREAM_RETRY_BASE":    "1ms",
+		"BREAKER_FAILURES":       "5",
+		"BREAKER_COOLDOWN":       "200ms",
+		"FAULTS":                 "upstream:error=0.5@503",
+		"RATE_LIMIT_PRO_BURST":   "1000",
+		"RATE_LIMIT_PRO_PER_MIN": "100000",
+	}, Deps{}).Handler()
+	injected := func() uint64 {
+		t.Helper()
+		var out struct {
+			Injected map[string]uint64 `json:"injected"`
+		}
+		json.Unmarshal(serve(h, "GET", "/go/admin/faults", "", "Authorization", "Bearer "+testAdmin).Body.Bytes(), &out)
+		return out.Injected["upstream:error"]
+	}
+
+	const calls = 200
+	ok, marked := 0, 0
+	for n := range calls {
+		w := serve(h, "GET", "/go/translate?src=dv&dst=en&debug=1&q="+consonantWord(n), "", "X-API-Key", testProKey)
+		body := w.Body.String()
+		fault := w.Header().Get(faultHeader)
+		if fault != "" {
+			marked++
+			if strings.Trim(strings.ReplaceAll(fault, "upstream:error", ""), ", ") != "" ||
+				w.Code == http.StatusOK && !strings.Contains(body, `"layer":"fault","outcome":"injected","detail":"upstream:error"`) {
+				t.Fatalf("call %d: X-DHK-Fault %q: %s", n, fault, body)
+			}
+		}
+		switch w.Code {
+		case http.StatusOK:
+			ok++
+		case http.StatusBadGateway:
+			if fault == "" || !strings.Contains(body, `"upstream_status":503`) {
+				t.Fatalf("call %d: a 502 not put down to the injected 503s (X-DHK-Fault %q): %s", n, fault, body)
+			}
+		default:
+			t.Fatalf("call %d: status %d: %s", n, w.Code, body)
+		}
+	}
+	// Each request fails all 4 attempts one time in 16; the breaker needs 5 of those in a row.
+	t.Logf("%d of %d answered, %d with faults injected, %d upstream calls injected", ok, calls, marked, injected())
+	if ok < calls*80/100 || int(hits.Load()) != ok {
+		t.Fatalf("%d of %d answered and %d reached the upstream, want most answered, each by one real call", ok, calls, hits.Load())
+	}
+	if n := injected(); n < calls/2 || n > 2*calls || marked < calls/4 {
+		t.Fatalf("%d upstream faults injected over %d requests, %d marked, want about one a request", n, calls, marked)
+	}
+
+	set := func(faults string) {
+		t.Helper()
+		if w := serve(h, "PUT", "/go/admin/faults", `{"faults":"`+faults+`"}`, "Authorization", "Bearer "+testAdmin, "Content-Type", "application/json"); w.Code != http.StatusOK {
+			t.Fatalf("PUT faults %q: status %d: %s", faults, w.Code, w.Body.String())
+		}
+	}
+	set("upstream:error=1@503")
+	for n := range 5 {
+		if w := serve(h, "GET", "/go/translate?src=dv&dst=en&q="+consonantWord(calls+n), "", "X-API-Key", testProKey); w.Code != http.StatusBadGateway {
+			t.Fatalf("failing call %d: status %d, want 502: %s", n, w.Code, w.Body.String())
+		}
+	}
+	before, hitsBefore := injected(), hits.Load()
+	w := serve(h, "GET", "/go/translate?src=dv&dst=en&q="+consonantWord(calls+5), "", "X-API-Key", testProKey)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" || !strings.Contains(w.Body.String(), "circuit open") {
+		t.Fatalf("after 5 failed requests: status %d, want the breaker's 503: %s", w.Code, w.Body.String())
+	}
+	if injected() != before || hits.Load() != hitsBefore || w.Header().Get(faultHeader) != "" {
+		t.Fatal("the open breaker let a call through to the transport")
+	}
+
+	set("")
+	time.Sleep(250 * time.Millisecond)
+	if w := serve(h, "GET", "/go/translate?src=dv&dst=en&q="+consonantWord(calls+6), "", "X-API-Key", testProKey); w.Code != http.StatusOK || w.Header().Get(faultHeader) != "" {
+		t.Fatalf("probe after the cooldown with the faults off: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestFaultsRoutes checks a route fault answers for the handler and says so, leaves the admin
+// routes alone, and that ENV=production injects nothing and refuses the admin routes.
+func TestFaultsRoutes(t *testing.T) {
+	h := newTestServer(t, map[string]string{"FAULTS": "*:error=1@500"}, Deps{}).Handler()
+	w := serve(h, "GET", "/go/translate?q=salaam", "", "X-API-Key", testProKey)
+	if w.Code != http.StatusInternalServerError || w.Header().Get(faultHeader) != "/go/translate:error" || !strings.Contains(w.Body.String(), `"code":"FAULT_INJECTED"`) {
+		t.Fatalf("translate under *:error: status %d, X-DHK-Fault %q: %s", w.Code, w.Header().Get(faultHeader), w.Body.String())
+	}
+	checkEnvelope(t, w)
+	if w := serve(h, "GET", "/go/health", ""); w.Code != http.StatusOK {
+		t.Fatalf("health under *:error: status %d", w.Code)
+	}
+	if w := serve(h, "PUT", "/go/admin/faults", `{"faults":"upstream:explode=1"}`, "Authorization", "Bearer "+testAdmin, "Content-Type", "application/json"); w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT of an unknown fault: status %d: %s", w.Code, w.Body.String())
+	}
+
+	prod := newTestServer(t, map[string]string{"ENV": "production", "TRANSLATE_MODE": "stub", "FAULTS": "*:error=1@500,upstream:drop=1"}, Deps{}).Handler()
+	if w := serve(prod, "GET", "/go/translate?q=salaam", "", "X-API-Key", testProKey); w.Code != http.StatusOK || w.Header().Get(faultHeader) != "" {
+		t.Fatalf("production translate with FAULTS set: status %d, X-DHK-Fault %q", w.Code, w.Header().Get(faultHeader))
+	}
+	for _, method := range []string{"GET", "PUT"} {
+		w := serve(prod, method, "/go/admin/faults", `{"faults":"*:error=1"}`, "Authorization", "Bearer "+testAdmin, "Content-Type", "application/json")
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "disabled in production") {
+			t.Fatalf("%s /go/admin/faults in production: status %d: %s", method, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Response field selection. ?fields=translation,confidence on a translate route trims the
+// response to those top-level fields (each item's, for a batch), for clients on slow links that
+// only want the string. It is applied to the response map just before encoding, so fields the
+// cache or provenance add are selectable like the rest.
+
+// fieldSelection is the fields a client asked for; nil is all of them.
+type fieldSelection map[string]bool
+
+// fieldsError is a ?fields= naming something the route never returns.
+type fieldsError struct {
+	Unknown []string
+	Valid   []string
+}
+
+func (e *fieldsError) Error() string {
+	return fmt.Sprintf("'fields' names %s, not a field of this response; see valid_fields", strings.Join(e.Unknown, ", "))
+}
+
+// parseFields reads ?fields= against the fields the route can return. Empty selects all.
+func parseFields(v url.Values, valid []string) (fieldSelection, error) {
+	raw := strings.Join(v["fields"], ",")
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	sel := fieldSelection{}
+	var unknown []string
+	for _, f := range strings.Split(raw, ",") {
+		switch f = strings.TrimSpace(f); {
+		case f == "":
+		case slices.Contains(valid, f):
+			sel[f] = true
+		default:
+			unknown = append(unknown, f)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, &fieldsError{Unknown: unknown, Valid: valid}
+	}
+	return sel, nil
+}
+
+// apply drops the fields out of s from out, keeping any named in keep whatever s says.
+func (s fieldSelection) apply(out map[string]any, keep ...string) map[string]any {
+	if s == nil {
+		return out
+	}
+	for k := range out {
+		if !s[k] && !slices.Contains(keep, k) {
+			delete(out, k)
+		}
+	}
+	return out
+}
+
+// project is apply for a value that encodes as a JSON object, such as a batch item.
+func (s fieldSelection) project(v any, keep ...string) any {
+	if s == nil {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out map[string]any
+	if json.Unmarshal(b, &out) != nil {
+		return v
+	}
+	return s.apply(out, keep...)
+}
+
+// jsonFieldNames lists the JSON names of struct type t's fields, for a route whose response
+// items are t.
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// String is the selection in a fixed order, for the ETag.
+func (s fieldSelection) String() string {
+	fields := make([]string, 0, len(s))
+	for f := range s {
+		fields = append(fields, f)
+	}
+	slices.Sort(fields)
+	return strings.Join(fields, ",")
+}
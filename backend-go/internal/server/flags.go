@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Runtime feature flags, for rolling a risky behavior out, or back, without a redeploy. Each
+// starts on unless FEATURE_FLAGS says otherwise and can be flipped under the admin token; a flag
+// only turns off something the configuration enables (response_signing does nothing without
+// RESPONSE_SIGNING_KEY). Readers get the current snapshot in one atomic load.
+
+type flag int
+
+const (
+	flagFuzzyKeys       flag = iota // cache keys and pack matches on the canonical romanized spelling
+	flagResponseSigning             // X-Origin-Signature on translate responses
+	flagNBest                       // honor ?nbest
+	flagSegments                    // translate multi-sentence input one sentence at a time
+	flagAdaptiveTimeout             // upstream timeouts from recent latency, with UPSTREAM_ADAPTIVE_TIMEOUT
+	flagCount
+)
+
+var flagNames = [flagCount]string{
+	flagFuzzyKeys:       "fuzzy_keys",
+	flagResponseSigning: "response_signing",
+	flagNBest:           "nbest",
+	flagSegments:        "segment_sentences",
+	flagAdaptiveTimeout: "adaptive_timeout",
+}
+
+func (fl flag) String() string { return flagNames[fl] }
+
+// flagByName returns the flag called name.
+func flagByName(name string) (flag, bool) {
+	i := slices.Index(flagNames[:], name)
+	return flag(i), i >= 0
+}
+
+// flagSnapshot is one setting of every flag; it is replaced whole, never edited.
+type flagSnapshot struct {
+	on    [flagCount]bool
+	since [flagCount]time.Time
+	by    [flagCount]string // "default", "env" or "admin:<token id>"
+}
+
+// featureFlags holds a Server's current snapshot. The zero value has no snapshot; a nil one
+// reads as the defaults, every flag on, for components built without a Server.
+type featureFlags struct {
+	cur atomic.Pointer[flagSnapshot]
+}
+
+// newFeatureFlags has every flag on, stamped with now, the Server's start by its clock; set takes
+// the time of a change from its caller's.
+func newFeatureFlags(now time.Time) *featureFlags {
+	s := &flagSnapshot{}
+	for i := range s.on {
+		s.on[i], s.since[i], s.by[i] = true, now, "default"
+	}
+	f := &featureFlags{}
+	f.cur.Store(s)
+	return f
+}
+
+// on reports whether fl is enabled.
+func (f *featureFlags) on(fl flag) bool { return f == nil || f.cur.Load().on[fl] }
+
+// set applies changes, by flag name, in one swap and returns the new snapshot. Concurrent
+// calls don't lose each other's changes.
+func (f *featureFlags) set(changes map[flag]bool, by string, now time.Time) *flagSnapshot {
+	for {
+		old := f.cur.Load()
+		next := *old
+		for fl, v := range changes {
+			if next.on[fl] != v {
+				next.on[fl], next.since[fl], next.by[fl] = v, now, by
+			}
+		}
+		if f.cur.CompareAndSwap(old, &next) {
+			return &next
+		}
+	}
+}
+
+// state is every flag's value by name, for /go/version.
+func (f *featureFlags) state() map[string]bool {
+	s := f.cur.Load()
+	out := make(map[string]bool, flagCount)
+	for i, name := range flagNames {
+		out[name] = s.on[i]
+	}
+	return out
+}
+
+// flagInfo is one flag in the /go/admin/flags responses.
+type flagInfo struct {
+	Enabled bool   `json:"enabled"`
+	Since   string `json:"since"`
+	By      string `json:"by"`
+}
+
+func (s *flagSnapshot) report() map[string]flagInfo {
+	out := make(map[string]flagInfo, flagCount)
+	for i, name := range flagNames {
+		out[name] = flagInfo{Enabled: s.on[i], Since: s.since[i].UTC().Format(time.RFC3339), By: s.by[i]}
+	}
+	return out
+}
+
+// parseFeatureFlags reads FEATURE_FLAGS: comma-separated name=bool.
+func parseFeatureFlags(raw string) (map[flag]bool, error) {
+	out := map[flag]bool{}
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(part, "=")
+		fl, known := flagByName(strings.TrimSpace(name))
+		if !known {
+			return nil, fmt.Errorf("unknown flag %q (valid: %s)", strings.TrimSpace(name), strings.Join(flagNames[:], ", "))
+		}
+		v, err := strconv.ParseBool(strings.TrimSpace(val))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%s: want name=true|false", part)
+		}
+		out[fl] = v
+	}
+	return out, nil
+}
+
+// flagsHandler serves GET /go/admin/flags.
+func flagsHandler(f *featureFlags) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		j(w, http.StatusOK, map[string]any{"flags": f.cur.Load().report()})
+	}
+}
+
+// flagsPatchHandler serves PATCH /go/admin/flags: a JSON object of flag name to bool. Nothing
+// changes unless every name is known.
+func flagsPatchHandler(f *featureFlags, clk Clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]bool
+		if herr := decodeJSONBody(r, &req); herr != nil {
+			herr.write(w)
+			return
+		}
+		changes := make(map[flag]bool, len(req))
+		var unknown []string
+		for name, v := range req {
+			auditParam(r.Context(), name, v)
+			fl, ok := flagByName(name)
+			if !ok {
+				unknown = append(unknown, name)
+				continue
+			}
+			changes[fl] = v
+		}
+		if len(unknown) > 0 {
+			slices.Sort(unknown)
+			writeError(w, http.StatusBadRequest, codeBadRequest, "unknown flag: "+strings.Join(unknown, ", "), "valid", flagNames[:])
+			return
+		}
+		if len(changes) == 0 {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "no flags given", "valid", flagNames[:])
+			return
+		}
+		by := "admin:" + adminFrom(r.Context())
+		s := f.set(changes, by, clk.Now())
+		for fl, v := range changes {
+			slog.Warn("feature flag changed", "flag", flagNames[fl], "enabled", v, "by", by)
+		}
+		j(w, http.StatusOK, map[string]any{"flags": s.report()})
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// flightGroup shares one call among concurrent callers with the same key, like singleflight,
+// and runs it on a context of its own: one detached from the caller that happened to start it,
+// so that caller disconnecting doesn't fail the others, but canceled once every caller waiting
+// on it has given up, so an abandoned upstream call stops instead of running to its timeout.
+// The zero value is ready to use.
+type flightGroup struct {
+	group singleflight.Group
+
+	mu     sync.Mutex
+	calls  map[string]*flightCall
+	joined chan struct{} // closed, and cleared, when a caller joins, for tests to wait on
+}
+
+// flightCall is the context a key's call runs on and how many callers are waiting for it.
+type flightCall struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// do runs fn once for concurrent callers of key, on a context bounded by timeout that carries
+// ctx's values. It returns ctx's error if ctx is done before the call is.
+func (g *flightGroup) do(ctx context.Context, key string, timeout time.Duration, fn func(context.Context) (any, error)) (singleflight.Result, error) {
+	fc := g.join(ctx, key, timeout)
+	defer g.leave(key, fc)
+	ch := g.group.DoChan(key, func() (any, error) { return fn(fc.ctx) })
+	select {
+	case r := <-ch:
+		return r, nil
+	case <-ctx.Done():
+		return singleflight.Result{}, ctx.Err()
+	}
+}
+
+func (g *flightGroup) join(ctx context.Context, key string, timeout time.Duration) *flightCall {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fc := g.calls[key]
+	if fc != nil && fc.ctx.Err() != nil {
+		// The call timed out with callers still waiting on it; this one starts a new call
+		// rather than being handed the old one's timeout.
+		g.group.Forget(key)
+		fc = nil
+	}
+	if fc == nil {
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		fc = &flightCall{ctx: fctx, cancel: cancel}
+		if g.calls == nil {
+			g.calls = map[string]*flightCall{}
+		}
+		g.calls[key] = fc
+	}
+	fc.waiters++
+	if g.joined != nil {
+		close(g.joined)
+		g.joined = nil
+	}
+	return fc
+}
+
+// leave cancels fc once nobody waits on it. If its call is still running it is forgotten, so a
+// later caller starts afresh instead of being handed the canceled result.
+func (g *flightGroup) leave(key string, fc *flightCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if fc.waiters--; fc.waiters > 0 {
+		return
+	}
+	fc.cancel()
+	if g.calls[key] == fc {
+		delete(g.calls, key)
+		g.group.Forget(key)
+	}
+}
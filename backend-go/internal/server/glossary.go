@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// glossaryTerm is one protected source term. keep means the matched text passes through as
+// written; otherwise target replaces it.
+type glossaryTerm struct {
+	name   string // the key as written in the file
+	source []rune
+	target string
+	keep   bool
+	fold   bool // Latin terms match case-insensitively, Thaana ones exactly
+}
+
+// glossary holds the protected terms, bucketed by folded first rune and longest first, so the
+// scan can take the longest match at each position.
+type glossary struct {
+	byFirst map[rune][]*glossaryTerm
+	size    int
+}
+
+// loadGlossary reads a JSON object mapping source term to its fixed target string, or to true
+// to keep the term untranslated:
+//
+//	{"Malé": "Male", "Maafushi": true}
+func loadGlossary(path string) (*glossary, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("glossary: %w", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("glossary %s: %w", path, err)
+	}
+	terms, err := parseGlossaryTerms(raw)
+	if err != nil {
+		return nil, fmt.Errorf("glossary %s: %w", path, err)
+	}
+	g := newGlossary(terms)
+	slog.Info("glossary loaded", "path", path, "terms", g.size)
+	return g, nil
+}
+
+// parseGlossaryTerms reads the term to target-or-true entries of a glossary object.
+func parseGlossaryTerms(raw map[string]json.RawMessage) ([]*glossaryTerm, error) {
+	terms := make([]*glossaryTerm, 0, len(raw))
+	for src, v := range raw {
+		norm, ok := normalizeText(src)
+		if !ok || norm == "" {
+			return nil, fmt.Errorf("invalid term %q", src)
+		}
+		t := &glossaryTerm{name: src, source: []rune(norm), fold: detectScript(norm) != scriptThaana}
+		switch v = bytes.TrimSpace(v); {
+		case string(v) == "true":
+			t.keep = true
+		default:
+			if err := json.Unmarshal(v, &t.target); err != nil || t.target == "" {
+				return nil, fmt.Errorf("%q wants a target string or true", src)
+			}
+		}
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
+
+func newGlossary(terms []*glossaryTerm) *glossary {
+	g := &glossary{byFirst: make(map[rune][]*glossaryTerm)}
+	for _, t := range terms {
+		first := t.source[0]
+		if t.fold {
+			first = unicode.ToLower(first)
+		}
+		g.byFirst[first] = append(g.byFirst[first], t)
+		g.size++
+	}
+	for _, ts := range g.byFirst {
+		sort.Slice(ts, func(i, j int) bool { return len(ts[i].source) > len(ts[j].source) })
+	}
+	return g
+}
+
+// placeholder is substituted for protected terms. It has no letters for the model to translate
+// and survives tokenizers that split on punctuation.
+func placeholder(n int) string { return "⟦" + strconv.Itoa(n) + "⟧" }
+
+// glossaryMatch is one protected span and what replaces it after translation.
+type glossaryMatch struct {
+	term        string // glossary key, reported to the client
+	replacement string
+	client      bool // from the caller's own glossary
+	opaque      bool // an emoji, URL or figure (see protectOpaque), not a term
+	number      bool // a figure, whose digits follow the request's digits
+}
+
+// protect replaces every glossary term in q with a numbered placeholder, preferring the longest
+// term at each position and requiring word boundaries on both sides. Numbering starts at next,
+// so a second pass over the result doesn't reuse the first pass's placeholders.
+func (g *glossary) protect(q string, next int) (string, []glossaryMatch) {
+	rs := []rune(q)
+	var b strings.Builder
+	var matches []glossaryMatch
+	for i := 0; i < len(rs); {
+		if t := g.matchAt(rs, i); t != nil {
+			n := len(t.source)
+			repl := t.target
+			if t.keep {
+				repl = string(rs[i : i+n])
+			}
+			b.WriteString(placeholder(next + len(matches)))
+			matches = append(matches, glossaryMatch{term: t.name, replacement: repl})
+			i += n
+			continue
+		}
+		b.WriteRune(rs[i])
+		i++
+	}
+	if len(matches) == 0 {
+		return q, nil
+	}
+	return b.String(), matches
+}
+
+func (g *glossary) matchAt(rs []rune, i int) *glossaryTerm {
+	if i > 0 && wordRune(rs[i-1]) {
+		return nil
+	}
+	for _, t := range g.byFirst[unicode.ToLower(rs[i])] {
+		n := len(t.source)
+		if i+n > len(rs) || (i+n < len(rs) && wordRune(rs[i+n])) {
+			continue
+		}
+		if runesMatch(rs[i:i+n], t.source, t.fold) {
+			return t
+		}
+	}
+	return nil
+}
+
+func runesMatch(a, b []rune, fold bool) bool {
+	for k := range a {
+		if a[k] == b[k] {
+			continue
+		}
+		if !fold || unicode.ToLower(a[k]) != unicode.ToLower(b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// wordRune reports letters, digits and combining marks (Thaana vowel signs are marks).
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// restore puts the glossary replacements back in place of the placeholders. applied lists the
+// terms put back, client the ones of those from the caller's glossary, and missing the terms
+// whose placeholder didn't survive the upstream.
+func restore(translation string, matches []glossaryMatch) (out string, applied, client, missing []string) {
+	seen := make(map[string]bool)
+	for n, m := range matches {
+		ph := placeholder(n)
+		if m.opaque {
+			translation = strings.ReplaceAll(translation, ph, m.replacement)
+			continue
+		}
+		if !strings.Contains(translation, ph) {
+			missing = append(missing, m.term)
+			continue
+		}
+		translation = strings.ReplaceAll(translation, ph, m.replacement)
+		if !seen[m.term] {
+			seen[m.term] = true
+			applied = append(applied, m.term)
+			if m.client {
+				client = append(client, m.term)
+			}
+		}
+	}
+	return translation, applied, client, missing
+}
+
+// glossaryTranslator shields glossary terms from next: emoji and URLs first (see
+// protectOpaque), then the global glossary's terms, then the caller's own. It sits above the cache, so the cache holds the placeholder form and one entry
+// serves every name that fills the same slots. A caller with its own glossary has its entries
+// kept apart under the glossary's hash (see cacheKey), so an edit needs no cache invalidation:
+// the next request looks under the new hash and the old entries age out.
+type glossaryTranslator struct {
+	g       *glossary         // GLOSSARY_PATH; nil without it
+	clients *clientGlossaries // nil without GLOSSARY_DB_PATH
+	next    Translator
+}
+
+func (t *glossaryTranslator) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	stage := startStage(ctx, "glossary")
+	masked, matches := protectOpaque(req.Q)
+	for i := range matches {
+		if matches[i].number {
+			matches[i].replacement = convertDigits(matches[i].replacement, req.Digits)
+		}
+	}
+	if t.g != nil {
+		var more []glossaryMatch
+		masked, more = t.g.protect(masked, len(matches))
+		matches = append(matches, more...)
+	}
+	own := t.clients.forCaller(ctx)
+	if own != nil {
+		req.GlossaryHash = own.hash
+		var more []glossaryMatch
+		masked, more = own.g.protect(masked, len(matches))
+		for i := range more {
+			more[i].client = true
+		}
+		matches = append(matches, more...)
+	}
+	if len(matches) == 0 {
+		stage.end("none", "")
+		res, err := t.next.Translate(ctx, req)
+		res.OwnGlossary = own != nil
+		return res, err
+	}
+	req.Q = masked
+	stage.end("masked", "")
+	res, err := t.next.Translate(ctx, req)
+	if err != nil {
+		return res, err
+	}
+	res.OwnGlossary = own != nil
+	lost := 0
+	for n, m := range matches {
+		if m.opaque && !strings.Contains(res.Translation, placeholder(n)) {
+			lost++
+		}
+	}
+	if lost > 0 {
+		slog.Warn("placeholders lost upstream", "request_id", middleware.GetReqID(ctx), "lost", lost, "of", len(matches), "src", res.Src)
+	}
+	var missing []string
+	res.Translation, res.Glossary, res.GlossaryClient, missing = restore(res.Translation, matches)
+	noteProvenanceGlossary(ctx, res.Glossary, res.GlossaryClient, missing)
+	if len(res.Alternatives) > 0 {
+		alts := make([]alternative, len(res.Alternatives))
+		for i, a := range res.Alternatives {
+			a.Translation, _, _, _ = restore(a.Translation, matches)
+			alts[i] = a
+		}
+		res.Alternatives = alts
+	}
+	if len(missing) > 0 {
+		// The terms are words of the text, so they are logged as it would be.
+		terms := make([]string, len(missing))
+		for i, t := range missing {
+			terms[i] = describeContent(t)
+		}
+		slog.Warn("glossary placeholders lost upstream", "request_id", middleware.GetReqID(ctx), "terms", terms, "src", res.Src)
+	}
+	return res, nil
+}
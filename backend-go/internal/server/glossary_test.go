@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func testGlossary(t *testing.T) *glossary {
+	t.Helper()
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(`{
+		"Malé": "Male",
+		"South Malé Atoll": "Kaafu",
+		"Maafushi": true,
+		"ހުޅުމާލެ": "Hulhumalé"
+	}`), &raw); err != nil {
+		t.Fatal(err)
+	}
+	terms, err := parseGlossaryTerms(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newGlossary(terms)
+}
+
+func TestGlossaryProtect(t *testing.T) {
+	g := testGlossary(t)
+	tests := []struct {
+		name   string
+		q      string
+		masked string
+		terms  []string // the matched keys, in order
+		repl   []string
+	}{
+		{"none", "good morning", "good morning", nil, nil},
+		{"target", "to Malé today", "to ⟦0⟧ today", []string{"Malé"}, []string{"Male"}},
+		{"latin folds case", "to MALÉ today", "to ⟦0⟧ today", []string{"Malé"}, []string{"Male"}},
+		{"keep passes the text as written", "ferry to maafushi", "ferry to ⟦0⟧", []string{"Maafushi"}, []string{"maafushi"}},
+		{"longest match wins", "South Malé Atoll resorts", "⟦0⟧ resorts", []string{"South Malé Atoll"}, []string{"Kaafu"}},
+		{"twice", "Malé to Malé", "⟦0⟧ to ⟦1⟧", []string{"Malé", "Malé"}, []string{"Male", "Male"}},
+		{"several", "Maafushi and Malé", "⟦0⟧ and ⟦1⟧", []string{"Maafushi", "Malé"}, []string{"Maafushi", "Male"}},
+		{"thaana exact", "ހުޅުމާލެ އަށް", "⟦0⟧ އަށް", []string{"ހުޅުމާލެ"}, []string{"Hulhumalé"}},
+		{"inside a word", "Maafushian beaches", "Maafushian beaches", nil, nil},
+		{"thaana with a trailing fili", "ހުޅުމާލެއަށް", "ހުޅުމާލެއަށް", nil, nil},
+		{"punctuation bounds a term", "(Malé)", "(⟦0⟧)", []string{"Malé"}, []string{"Male"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masked, matches := g.protect(tt.q, 0)
+			if masked != tt.masked {
+				t.Fatalf("masked %q, want %q", masked, tt.masked)
+			}
+			var terms, repl []string
+			for _, m := range matches {
+				terms, repl = append(terms, m.term), append(repl, m.replacement)
+			}
+			if !slices.Equal(terms, tt.terms) || !slices.Equal(repl, tt.repl) {
+				t.Fatalf("terms %q -> %q, want %q -> %q", terms, repl, tt.terms, tt.repl)
+			}
+		})
+	}
+	t.Run("numbering continues from next", func(t *testing.T) {
+		if masked, _ := g.protect("to Malé", 3); masked != "to ⟦3⟧" {
+			t.Fatalf("masked %q, want placeholder 3", masked)
+		}
+	})
+}
+
+func TestGlossaryRestore(t *testing.T) {
+	matches := []glossaryMatch{
+		{term: "Malé", replacement: "Male"},
+		{term: "Malé", replacement: "Male"},
+		{term: "Maafushi", replacement: "Maafushi", client: true},
+	}
+	tests := []struct {
+		name        string
+		translation string
+		out         string
+		applied     []string
+		client      []string
+		missing     []string
+	}{
+		{"all survive", "⟦0⟧ and ⟦1⟧, ⟦2⟧", "Male and Male, Maafushi", []string{"Malé", "Maafushi"}, []string{"Maafushi"}, nil},
+		{"moved around", "⟦2⟧ ⟦1⟧ ⟦0⟧", "Maafushi Male Male", []string{"Malé", "Maafushi"}, []string{"Maafushi"}, nil},
+		{"one lost", "⟦0⟧ and ⟦2⟧", "Male and Maafushi", []string{"Malé", "Maafushi"}, []string{"Maafushi"}, []string{"Malé"}},
+		{"all lost", "somewhere", "somewhere", nil, nil, []string{"Malé", "Malé", "Maafushi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, applied, client, missing := restore(tt.translation, matches)
+			if out != tt.out {
+				t.Fatalf("restored %q, want %q", out, tt.out)
+			}
+			if !slices.Equal(applied, tt.applied) || !slices.Equal(client, tt.client) || !slices.Equal(missing, tt.missing) {
+				t.Fatalf("applied %q, client %q, missing %q; want %q, %q, %q", applied, client, missing, tt.applied, tt.client, tt.missing)
+			}
+		})
+	}
+}
+
+// dropUpstream translates to a fixed string, dropping whatever placeholders it was sent.
+type dropUpstream struct{}
+
+func (dropUpstream) Translate(context.Context, translateReq) (translateResult, error) {
+	return translateResult{Translation: "somewhere", Src: "upstream"}, nil
+}
+
+func TestGlossaryTranslator(t *testing.T) {
+	g := testGlossary(t)
+	tests := []struct {
+		name        string
+		next        Translator
+		q           string
+		translation string
+		glossary    []string
+	}{
+		{"restored", &echoUpstream{}, "Malé to Maafushi", "EN(Male to Maafushi)", []string{"Malé", "Maafushi"}},
+		{"no terms", &echoUpstream{}, "good morning", "EN(good morning)", nil},
+		{"placeholders lost", dropUpstream{}, "Malé", "somewhere", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gt := &glossaryTranslator{g: g, next: tt.next}
+			res, err := gt.Translate(context.Background(), translateReq{Q: tt.q})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Translation != tt.translation || !slices.Equal(res.Glossary, tt.glossary) {
+				t.Fatalf("got %q with %q, want %q with %q", res.Translation, res.Glossary, tt.translation, tt.glossary)
+			}
+		})
+	}
+}
+
+func TestParseGlossaryTerms(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		ok   bool
+	}{
+		{"target and keep", `{"Malé": "Male", "Maafushi": true}`, true},
+		{"false", `{"Malé": false}`, false},
+		{"empty target", `{"Malé": ""}`, false},
+		{"number", `{"Malé": 1}`, false},
+		{"blank term", `{" ": "x"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(tt.json), &raw); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parseGlossaryTerms(raw); (err == nil) != tt.ok {
+				t.Fatalf("err %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
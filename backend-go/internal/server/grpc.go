@@ -0,0 +1,282 @@
+package server
+
+//go:generate buf generate
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	translatev1 "github.com/sartu01/dhkalign/backend-go/gen/dhkalign/translate/v1"
+)
+
+// grpcService adapts translateService to the generated TranslateService server.
+type grpcService struct {
+	translatev1.UnimplementedTranslateServiceServer
+	svc    *translateService
+	uptime func() time.Duration
+}
+
+func (g *grpcService) Translate(ctx context.Context, in *translatev1.TranslateRequest) (*translatev1.TranslateResponse, error) {
+	res, err := g.svc.Translate(ctx, translateReq{Q: in.GetQ(), Src: in.GetSrc(), Dst: in.GetDst(), Extended: in.GetExtended()})
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	out := &translatev1.TranslateResponse{
+		Translation: res.Translation, Src: res.Src, Cached: res.Cached, DetectedScript: res.Script, SrcLang: res.Pair.Src, DstLang: res.Pair.Dst,
+		DetectedSrc: res.DetectedSrc, DetectionConfidence: res.DetectionConfidence, Pack: res.Pack, Glossary: res.Glossary,
+	}
+	if res.Cached {
+		out.CachedAt = timestamppb.New(res.CachedAt)
+	}
+	return out, nil
+}
+
+func (g *grpcService) BatchTranslate(ctx context.Context, in *translatev1.BatchTranslateRequest) (*translatev1.BatchTranslateResponse, error) {
+	req := batchReq{Src: in.GetSrc(), Dst: in.GetDst(), Extended: in.GetExtended(), Items: make([]batchItem, len(in.GetItems()))}
+	for i, it := range in.GetItems() {
+		req.Items[i] = batchItem{ID: it.GetId(), Q: it.GetQ(), Src: it.GetSrc(), Dst: it.GetDst()}
+	}
+	results, err := g.svc.Batch(ctx, req)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	out := &translatev1.BatchTranslateResponse{Results: make(map[string]*translatev1.BatchResult, len(results)), Count: int32(len(results))}
+	for id, r := range results {
+		out.Results[id] = &translatev1.BatchResult{
+			Translation: r.Translation, Src: r.Src, Cached: r.Cached, Error: r.Error, DetectedScript: r.DetectedScript, SrcLang: r.SrcLang, DstLang: r.DstLang,
+			DetectedSrc: r.DetectedSrc, DetectionConfidence: r.DetectionConf, Pack: r.Pack, Glossary: r.Glossary,
+		}
+	}
+	return out, nil
+}
+
+func (g *grpcService) Health(context.Context, *translatev1.HealthRequest) (*translatev1.HealthResponse, error) {
+	return &translatev1.HealthResponse{Status: "ok", Uptime: durationpb.New(g.uptime())}, nil
+}
+
+// grpcError maps service errors onto status codes the way the HTTP handlers map them onto statuses.
+func grpcError(ctx context.Context, err error) error {
+	var (
+		herr *httpError
+		pe   *unsupportedPairError
+		open *breakerOpenError
+		qe   *quotaError
+		te   *inputTooLongError
+		ie   *InputError
+		nc   *notCoveredError
+		ue   *upstreamError
+		se   *scopeError
+	)
+	switch {
+	case errors.As(err, &herr):
+		return status.Error(httpCode(herr.code), herr.msg)
+	case errors.As(err, &pe):
+		return status.Error(codes.InvalidArgument, pe.Error())
+	case errors.As(err, &se):
+		return status.Error(codes.PermissionDenied, se.Error())
+	case errors.As(err, &qe):
+		return status.Error(codes.ResourceExhausted, qe.Error()+"; resets at "+qe.Reset.Format(time.RFC3339))
+	case errors.As(err, &te):
+		return status.Error(codes.InvalidArgument, te.Error())
+	case errors.As(err, &ie):
+		return status.Error(codes.InvalidArgument, ie.Error())
+	case errors.As(err, &nc):
+		return status.Error(codes.Unimplemented, nc.Error())
+	case errors.As(err, &open):
+		return status.Error(codes.Unavailable, err.Error())
+	case ctx.Err() != nil:
+		return status.FromContextError(ctx.Err()).Err()
+	case errors.As(err, &ue) && ue.clientError():
+		return status.Error(httpCode(ue.Status), ue.Msg)
+	case ue != nil && ue.Schema:
+		return status.Error(codes.Internal, "upstream response failed validation: "+ue.Msg)
+	default:
+		return status.Error(codes.Unavailable, err.Error())
+	}
+}
+
+func httpCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusPaymentRequired, http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	default:
+		return codes.FailedPrecondition
+	}
+}
+
+// newGRPCServer serves TranslateService with the same protections as the HTTP translate routes:
+// x-api-key metadata when keys are configured (or a bearer token in authorization), the shared per-tier rate limiters, and the HTTP
+// route timeouts as default deadlines for calls that arrive without one. Health stays open like
+// /go/health.
+func newGRPCServer(svc *translateService, keys *keyStore, jv *jwtVerifier, limiters tierLimiters, timeout, batchTimeout time.Duration, uptime func() time.Duration) *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		grpcRecover,
+		grpcLog,
+		grpcAuth(keys, jv, limiters),
+		grpcDeadline(timeout, batchTimeout),
+	))
+	translatev1.RegisterTranslateServiceServer(srv, &grpcService{svc: svc, uptime: uptime})
+	return srv
+}
+
+func grpcRecover(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (_ any, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			slog.Error("panic", "method", info.FullMethod, "panic", v, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return next(ctx, req)
+}
+
+// grpcLog logs each call. Like the HTTP requestID middleware, it keeps a valid incoming
+// x-request-id (or makes one), returns it as response metadata and sends it to the upstream.
+func grpcLog(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	id := newRequestID()
+	if v := metadata.ValueFromIncomingContext(ctx, "x-request-id"); len(v) > 0 && validRequestID(v[0]) {
+		id = v[0]
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, id)
+	meta := &requestMeta{}
+	resp, err := next(context.WithValue(ctx, requestMetaCtxKey, meta), req)
+	attrs := []any{
+		"method", info.FullMethod,
+		"request_id", id,
+		"code", status.Code(err).String(),
+		"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+		"client_ip", grpcPeerIP(ctx),
+	}
+	if meta.KeyID != "" {
+		attrs = append(attrs, "key_id", meta.KeyID, "tier", meta.Tier)
+	}
+	slog.Info("grpc request", attrs...)
+	return resp, err
+}
+
+func grpcAuth(keys *keyStore, jv *jwtVerifier, limiters tierLimiters) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		if info.FullMethod == translatev1.TranslateService_Health_FullMethodName {
+			return next(ctx, req)
+		}
+		var bearer string
+		if v := metadata.ValueFromIncomingContext(ctx, "authorization"); len(v) > 0 {
+			bearer, _ = strings.CutPrefix(v[0], "Bearer ")
+		}
+		switch {
+		case jv != nil && bearer != "":
+			id, err := jv.identity(ctx, bearer)
+			switch {
+			case errors.Is(err, errJWTKeysUnavailable):
+				return nil, status.Error(codes.Unavailable, err.Error())
+			case err != nil:
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			ctx = withIdentity(ctx, id)
+		case keys.Len() > 0 || jv != nil:
+			var key string
+			if v := metadata.ValueFromIncomingContext(ctx, "x-api-key"); len(v) > 0 {
+				key = v[0]
+			}
+			if key == "" {
+				return nil, status.Error(codes.Unauthenticated, "missing api key")
+			}
+			k, ok := keys.lookup(key)
+			if !ok {
+				return nil, status.Error(codes.Unauthenticated, "invalid api key")
+			}
+			switch why := k.refusal(keys.clock.Now()); why {
+			case "disabled":
+				return nil, status.Error(codes.PermissionDenied, "api key disabled")
+			case "revoked", "expired":
+				return nil, status.Error(codes.Unauthenticated, "api key "+why)
+			}
+			route := scopeTranslate
+			if info.FullMethod == translatev1.TranslateService_BatchTranslate_FullMethodName {
+				route = scopeBatch
+			}
+			if err := k.scopes.permits(route, keys.clock.Now()); err != nil {
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			}
+			ctx = withIdentity(ctx, k.identity())
+		}
+		l, name := limiters.pick(ctx)
+		if d := l.take(callerKey(ctx, grpcPeerIP(ctx))); !d.Allowed {
+			metricRateLimited.WithLabelValues(name).Inc()
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+		return next(ctx, req)
+	}
+}
+
+func grpcDeadline(timeout, batchTimeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			d := timeout
+			if info.FullMethod == translatev1.TranslateService_BatchTranslate_FullMethodName {
+				d = batchTimeout
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		return next(ctx, req)
+	}
+}
+
+func grpcPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSpace(addr)
+}
+
+// stopGRPC drains in-flight RPCs, cutting them off when ctx expires. A nil server is a no-op.
+func stopGRPC(ctx context.Context, srv *grpc.Server) error {
+	if srv == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	translatev1 "github.com/sartu01/dhkalign/backend-go/gen/dhkalign/translate/v1"
+)
+
+// grpcTestClient serves s's gRPC server over an in-memory listener and returns a client for it.
+func grpcTestClient(t *testing.T, s *Server) translatev1.TranslateServiceClient {
+	t.Helper()
+	if s.gsrv == nil {
+		t.Fatal("no gRPC server; set GRPC_PORT")
+	}
+	lis := bufconn.Listen(1 << 20)
+	go s.gsrv.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return translatev1.NewTranslateServiceClient(conn)
+}
+
+// TestGRPCMatchesHTTP sends the same translations through both transports: the same answer,
+// from the one cache, and the same failures.
+func TestGRPCMatchesHTTP(t *testing.T) {
+	up := &echoUpstream{}
+	s := newTestServer(t, map[string]string{"GRPC_PORT": "0"}, Deps{Upstream: up})
+	h, client := s.Handler(), grpcTestClient(t, s)
+	tests := []struct {
+		name   string
+		key    string
+		q      string
+		src    string
+		dst    string
+		status int
+		code   codes.Code
+	}{
+		{"latin", testProKey, "kihineh", "", "", http.StatusOK, codes.OK},
+		{"thaana", testFreeKey, "ކިހިނެއް ތިބެނީ", "", "", http.StatusOK, codes.OK},
+		{"explicit pair", testProKey, "where is the hospital", "en", "dv", http.StatusOK, codes.OK},
+		{"missing key", "", "kihineh", "", "", http.StatusUnauthorized, codes.Unauthenticated},
+		{"unknown key", "nope", "kihineh", "", "", http.StatusUnauthorized, codes.Unauthenticated},
+		{"empty phrase", testProKey, "", "", "", http.StatusBadRequest, codes.InvalidArgument},
+		{"unsupported pair", testProKey, "bonjour", "fr", "dv", http.StatusUnprocessableEntity, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"q": tt.q, "src": tt.src, "dst": tt.dst})
+			w := serve(h, "POST", "/go/translate", string(body), "X-API-Key", tt.key, "Content-Type", "application/json")
+			if w.Code != tt.status {
+				t.Fatalf("http status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if tt.key != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", tt.key)
+			}
+			res, err := client.Translate(ctx, &translatev1.TranslateRequest{Q: tt.q, Src: tt.src, Dst: tt.dst})
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("grpc code %s, want %s: %v", got, tt.code, err)
+			}
+			if tt.code != codes.OK {
+				return
+			}
+			var hr struct {
+				Translation    string `json:"translation"`
+				Src            string `json:"src"`
+				DetectedScript string `json:"detected_script"`
+				SrcLang        string `json:"src_lang"`
+				DstLang        string `json:"dst_lang"`
+				DetectedSrc    string `json:"detected_src"`
+				Cached         bool   `json:"cached"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &hr); err != nil {
+				t.Fatal(err)
+			}
+			got := [...]string{res.Translation, res.Src, res.DetectedScript, res.SrcLang, res.DstLang, res.DetectedSrc}
+			want := [...]string{hr.Translation, hr.Src, hr.DetectedScript, hr.SrcLang, hr.DstLang, hr.DetectedSrc}
+			if got != want {
+				t.Fatalf("grpc %q, http %q", got, want)
+			}
+			// The HTTP call filled the cache the gRPC call read from.
+			if hr.Cached || !res.Cached {
+				t.Fatalf("cached: http %v, grpc %v; want false, true", hr.Cached, res.Cached)
+			}
+		})
+	}
+	if n := up.calls.Load(); n != 3 {
+		t.Fatalf("%d upstream calls, want one per phrase", n)
+	}
+}
+
+func TestGRPCUpstreamFailureMatchesHTTP(t *testing.T) {
+	up := &echoUpstream{}
+	s := newTestServer(t, map[string]string{"GRPC_PORT": "0", "BREAKER_FAILURES": "100"}, Deps{Upstream: up})
+	h, client := s.Handler(), grpcTestClient(t, s)
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   codes.Code
+	}{
+		{"upstream 5xx", &upstreamError{Status: http.StatusBadGateway, Msg: "bad gateway"}, http.StatusBadGateway, codes.Unavailable},
+		{"upstream rejects", &upstreamError{Status: http.StatusBadRequest, Msg: "bad phrase"}, http.StatusBadRequest, codes.InvalidArgument},
+		{"unreachable", errors.New("connection refused"), http.StatusBadGateway, codes.Unavailable},
+		{"circuit open", &breakerOpenError{RetryAfter: time.Second}, http.StatusServiceUnavailable, codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up.err = tt.err
+			q := "failing " + tt.name // a phrase of its own, so neither call is answered from the cache
+			body, _ := json.Marshal(map[string]string{"q": q})
+			w := serve(h, "POST", "/go/translate", string(body), "X-API-Key", testProKey, "Content-Type", "application/json")
+			if w.Code != tt.status {
+				t.Fatalf("http status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", testProKey)
+			_, err := client.Translate(ctx, &translatev1.TranslateRequest{Q: q})
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("grpc code %s, want %s: %v", got, tt.code, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// withH2C lets srv's port also serve HTTP/2 without TLS, with prior knowledge or an h2c Upgrade,
+// for proxies (Fly's, a gRPC gateway) that talk h2 to the origin in cleartext. HTTP/1.1 clients
+// are unaffected.
+//
+// srv's ReadTimeout and WriteTimeout still bound each stream, since http2 takes them from the
+// base server, but a connection lives much longer than one request; it is closed after
+// srv.IdleTimeout with no open streams, and a peer that stops answering pings is dropped so
+// dead proxy connections don't pile up.
+func withH2C(srv *http.Server) {
+	h2 := &http2.Server{
+		MaxConcurrentStreams: 250,
+		IdleTimeout:          srv.IdleTimeout,
+		ReadIdleTimeout:      30 * time.Second,
+		PingTimeout:          15 * time.Second,
+		WriteByteTimeout:     srv.WriteTimeout,
+	}
+	srv.Handler = h2c.NewHandler(srv.Handler, h2)
+}
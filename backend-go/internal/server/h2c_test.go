@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+// h2cClient speaks HTTP/2 with prior knowledge over cleartext, counting the connections it opens.
+func h2cClient(dials *atomic.Int64) *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			dials.Add(1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+// TestH2C sends concurrent translations over h2c, held at the upstream until all of them are in
+// flight, and checks they shared one connection, that HTTP/1.1 still works on the same port, and
+// that without ENABLE_H2C the port doesn't speak h2c.
+func TestH2C(t *testing.T) {
+	up := &heldUpstream{release: make(chan struct{}), entered: make(chan struct{})}
+	s := newTestServer(t, map[string]string{"ENABLE_H2C": "1"}, Deps{Upstream: up})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.serveHTTP(ln)
+	base := "http://" + ln.Addr().String()
+
+	var dials atomic.Int64
+	client := h2cClient(&dials)
+	phrases := []string{"salaam", "kihineh", "shukuriyyaa", "miadhu", "rangalhu"}
+	n := len(phrases)
+	type answer struct {
+		proto int
+		code  int
+		err   error
+	}
+	answers := make(chan answer, n)
+	for _, q := range phrases {
+		go func() {
+			req, _ := http.NewRequest("GET", base+"/go/translate?q="+q, nil)
+			req.Header.Set("X-API-Key", testProKey)
+			res, err := client.Do(req)
+			if err != nil {
+				answers <- answer{err: err}
+				return
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			answers <- answer{proto: res.ProtoMajor, code: res.StatusCode}
+		}()
+	}
+	for range n {
+		<-up.entered // every request is in flight at once
+	}
+	close(up.release)
+	for range n {
+		a := <-answers
+		if a.err != nil || a.proto != 2 || a.code != http.StatusOK {
+			t.Fatalf("h2c translation: HTTP/%d, status %d, err %v", a.proto, a.code, a.err)
+		}
+	}
+	if got := dials.Load(); got != 1 {
+		t.Fatalf("%d connections for %d concurrent requests, want them multiplexed on one", got, n)
+	}
+
+	res, err := http.Get(base + "/go/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 1 || res.StatusCode != http.StatusOK {
+		t.Fatalf("HTTP/1.1 on the h2c port: HTTP/%d, status %d", res.ProtoMajor, res.StatusCode)
+	}
+
+	plain := newTestServer(t, nil, Deps{})
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.serveHTTP(ln)
+	if res, err := h2cClient(&dials).Get("http://" + ln.Addr().String() + "/go/health"); err == nil {
+		res.Body.Close()
+		t.Fatalf("h2c without ENABLE_H2C: HTTP/%d, status %d; want the connection refused", res.ProtoMajor, res.StatusCode)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// healthCheck serves /go/health. The plain response is static and cheap, for Fly's checks;
+// ?verbose=1 with an admin token adds live probes of every dependency, the pack status and how
+// saturated the translate slots are.
+// Verbose is a diagnostic view, not a gate: it answers 200 whatever the probes find. With the
+// upstream poller, the upstream's entry is its last poll rather than a fresh probe.
+type healthCheck struct {
+	maint    *maintenanceMode
+	admins   *keyStore
+	probes   []dependency
+	timeout  time.Duration
+	packs    *packSet            // nil without PACK_DIR or the embedded packs
+	upstream *failoverTranslator // nil in stub mode
+	shed     *loadShedder
+	clock    Clock
+	uptime   func() time.Duration
+
+	mu      sync.Mutex
+	lastErr map[string]probeFailure
+}
+
+// probeFailure is a dependency's most recent failed probe, kept after it recovers.
+type probeFailure struct {
+	Error string
+	At    time.Time
+}
+
+// verboseProbe is one dependency in the verbose report.
+type verboseProbe struct {
+	probeResult
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt string `json:"last_error_at,omitempty"`
+}
+
+func (h *healthCheck) handler(w http.ResponseWriter, r *http.Request) {
+	out := map[string]any{
+		"status":      "ok",
+		"ts":          h.clock.Now().UTC().Format(time.RFC3339),
+		"uptime":      h.uptime().String(),
+		"maintenance": h.maint.state().health(),
+	}
+	if !queryBool(r.URL.Query().Get("verbose")) {
+		j(w, http.StatusOK, out)
+		return
+	}
+	if _, msg := adminToken(h.admins, r); msg != "" {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, msg)
+		return
+	}
+	out["dependencies"] = h.probe(r)
+	out["packs"] = h.packStatus()
+	out["concurrency"] = h.shed.stats()
+	if polls := h.upstreamPolls(); len(polls) > 0 {
+		out["upstream_poll"] = polls
+	}
+	j(w, http.StatusOK, out)
+}
+
+func (h *healthCheck) probe(r *http.Request) map[string]verboseProbe {
+	probes := h.probeList()
+	results := probeAll(r.Context(), h.timeout, probes)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	report := make(map[string]verboseProbe, len(probes))
+	for i, d := range probes {
+		if !results[i].OK {
+			h.lastErr[d.Name] = probeFailure{Error: results[i].Error, At: h.clock.Now()}
+		}
+		p := verboseProbe{probeResult: results[i]}
+		if f, ok := h.lastErr[d.Name]; ok {
+			p.LastError, p.LastErrorAt = f.Error, f.At.UTC().Format(time.RFC3339)
+		}
+		report[d.Name] = p
+	}
+	return report
+}
+
+// probeList is h.probes plus, when there are several upstreams, each one on its own. The members
+// are read on every check since a config reload can replace them.
+func (h *healthCheck) probeList() []dependency {
+	if h.upstream == nil || len(h.upstream.members()) < 2 {
+		return h.probes
+	}
+	probes := slices.Clone(h.probes)
+	for _, m := range h.upstream.members() {
+		probes = append(probes, dependency{Name: "upstream:" + m.name, Probe: m.ping})
+	}
+	return probes
+}
+
+// upstreamPolls is each polled upstream's status by name.
+func (h *healthCheck) upstreamPolls() map[string]any {
+	out := map[string]any{}
+	if h.upstream == nil {
+		return out
+	}
+	for _, m := range h.upstream.members() {
+		if m.poller != nil {
+			out[m.name] = m.poller.status()
+		}
+	}
+	return out
+}
+
+func (h *healthCheck) packStatus() map[string]any {
+	if h.packs == nil {
+		return map[string]any{"enabled": false}
+	}
+	ix, st := h.packs.current(), h.packs.status.Load()
+	return map[string]any{
+		"enabled": true,
+		"entries": ix.size(),
+		"files":   ix.files,
+		"status":  st,
+	}
+}
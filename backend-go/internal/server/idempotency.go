@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxIdempotencyKeyLen = 255
+	maxReplayBytes       = 1 << 20 // larger responses run normally but aren't stored
+)
+
+// fingerprintHeaders are the request headers that change the response, so a retry that differs
+// in one is a different request. The API version is part of the path.
+var fingerprintHeaders = []string{"Accept", "Accept-Encoding", stubHeader, faultHeader}
+
+// replayHeaders are the response headers that describe the content and so go with a replay.
+// The rest belong to the request that set them: X-Request-Id, trace context, Date, and the first
+// attempt's rate limit and quota counts.
+var replayHeaders = []string{
+	"Content-Type", "Content-Language", "Content-Encoding", "Content-Disposition", "ETag",
+	"Cache-Control", "Age", "Vary", "Warning", "Location", "Deprecation", "Sunset",
+	"X-Upstream", "X-Upstream-Attempts",
+}
+
+// idempotencyStore remembers POST responses per (caller, Idempotency-Key) for ttl, so a client
+// retrying after a dropped connection gets the first answer back instead of a second upstream
+// call. Each caller holds at most maxPerClient keys; the oldest finished one is evicted first.
+type idempotencyStore struct {
+	mu           sync.Mutex
+	ttl          time.Duration
+	maxPerClient int
+	clock        Clock
+	clients      map[string]map[string]*idemEntry
+}
+
+// idemEntry is a stored response, or a request still running when done is open.
+type idemEntry struct {
+	fingerprint [sha256.Size]byte
+	created     time.Time
+	done        chan struct{}
+
+	// Set before done is closed; ok is false when the response wasn't kept (429, 5xx, too large).
+	ok     bool
+	code   int
+	header http.Header
+	body   []byte
+}
+
+func newIdempotencyStore(ttl time.Duration, maxPerClient int, clk Clock) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:          ttl,
+		maxPerClient: maxPerClient,
+		clock:        clk,
+		clients:      make(map[string]map[string]*idemEntry),
+	}
+}
+
+// claim returns the live entry for key and false, or registers a new in-flight entry and returns
+// it with true, in which case the caller must finish it. A nil entry means the client has
+// maxPerClient requests in flight and nothing can be evicted.
+func (s *idempotencyStore) claim(client, key string, fp [sha256.Size]byte) (*idemEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	keys := s.clients[client]
+	if keys == nil {
+		keys = make(map[string]*idemEntry)
+		s.clients[client] = keys
+	}
+	if e, ok := keys[key]; ok && now.Sub(e.created) < s.ttl {
+		return e, false
+	}
+	delete(keys, key)
+	if len(keys) >= s.maxPerClient && !s.evictOldest(keys) {
+		return nil, false
+	}
+	e := &idemEntry{fingerprint: fp, created: now, done: make(chan struct{})}
+	keys[key] = e
+	return e, true
+}
+
+// evictOldest drops the oldest finished entry in keys, reporting whether there was one.
+func (s *idempotencyStore) evictOldest(keys map[string]*idemEntry) bool {
+	var oldest string
+	var at time.Time
+	for k, e := range keys {
+		select {
+		case <-e.done:
+		default:
+			continue
+		}
+		if oldest == "" || e.created.Before(at) {
+			oldest, at = k, e.created
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	delete(keys, oldest)
+	return true
+}
+
+// finish publishes the response held by rec and wakes any waiters. Responses that shouldn't be
+// replayed are forgotten, so the next retry runs for real.
+func (s *idempotencyStore) finish(client, key string, e *idemEntry, rec *replayRecorder) {
+	s.mu.Lock()
+	if rec != nil && rec.code < 500 && rec.code != http.StatusTooManyRequests && !rec.overflow {
+		e.ok, e.code, e.header, e.body = true, rec.code, http.Header{}, rec.buf.Bytes()
+		for _, k := range replayHeaders {
+			if v := rec.Header().Values(k); len(v) > 0 {
+				e.header[k] = slices.Clone(v)
+			}
+		}
+	} else if keys := s.clients[client]; keys[key] == e {
+		delete(keys, key)
+	}
+	s.mu.Unlock()
+	close(e.done)
+}
+
+// gc drops expired entries and empty clients.
+func (s *idempotencyStore) gc() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := s.clock.Now().Add(-s.ttl)
+	for c, keys := range s.clients {
+		for k, e := range keys {
+			if e.created.Before(cutoff) {
+				delete(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			delete(s.clients, c)
+		}
+	}
+}
+
+// run garbage-collects expired entries until ctx is done.
+func (s *idempotencyStore) run(ctx context.Context) {
+	t := s.clock.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			s.gc()
+		}
+	}
+}
+
+// idempotent honors an Idempotency-Key header on POST requests. The first request with a key
+// runs; later ones with the same key, query, body and fingerprintHeaders get its status, body
+// and replayHeaders back, with Idempotent-Replay: true. A retry arriving while the first is still running waits for it.
+// Reusing a key for a different request is a 422. Callers are told apart by rateLimitKey.
+//
+// The body is read up to maxBody, the largest any route allows, since the routes' own limits
+// are mounted after this; each still applies to what the handler reads. A throttled answer
+// (429, or a 5xx such as shedding's 503) isn't stored, so the retry after Retry-After runs.
+func idempotent(s *idempotencyStore, maxBody int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				writeError(w, http.StatusBadRequest, codeBadRequest, "Idempotency-Key too long", "limit", maxIdempotencyKeyLen)
+				return
+			}
+			body, err := bufferBody(w, r, maxBody)
+			if err != nil {
+				// Let the handler report the read error (e.g. 413) the way it always does.
+				next.ServeHTTP(w, r)
+				return
+			}
+			seed := r.URL.Path + "?" + r.URL.RawQuery + "\n"
+			for _, k := range fingerprintHeaders {
+				if v := r.Header.Values(k); len(v) > 0 {
+					seed += k + ": " + strings.Join(v, ", ") + "\n"
+				}
+			}
+			fp := sha256.Sum256(append([]byte(seed), body...))
+			client := rateLimitKey(r)
+
+			for {
+				e, owner := s.claim(client, key, fp)
+				if e == nil {
+					writeError(w, http.StatusTooManyRequests, codeRateLimited, "too many idempotency keys in flight")
+					return
+				}
+				if owner {
+					rec := &replayRecorder{ResponseWriter: w, code: http.StatusOK}
+					func() {
+						defer func() {
+							if p := recover(); p != nil {
+								s.finish(client, key, e, nil)
+								panic(p)
+							}
+						}()
+						next.ServeHTTP(rec, r)
+					}()
+					s.finish(client, key, e, rec)
+					return
+				}
+				if e.fingerprint != fp {
+					writeError(w, http.StatusUnprocessableEntity, codeConflict, "Idempotency-Key was already used for a different request")
+					return
+				}
+				select {
+				case <-e.done:
+				case <-r.Context().Done():
+					return
+				}
+				if !e.ok {
+					continue // the first attempt wasn't kept; run this one
+				}
+				h := w.Header()
+				for k, v := range e.header {
+					h[k] = v
+				}
+				h.Set("Idempotent-Replay", "true")
+				w.WriteHeader(e.code)
+				w.Write(e.body)
+				return
+			}
+		})
+	}
+}
+
+// replayRecorder passes the response through while keeping a copy of it, up to maxReplayBytes.
+type replayRecorder struct {
+	http.ResponseWriter
+	code     int
+	wrote    bool
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (w *replayRecorder) WriteHeader(code int) {
+	if !w.wrote {
+		w.code, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *replayRecorder) Write(b []byte) (int, error) {
+	w.wrote = true
+	if !w.overflow {
+		if w.buf.Len()+len(b) > maxReplayBytes {
+			w.overflow = true
+			w.buf = bytes.Buffer{}
+		} else {
+			w.buf.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *replayRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// bigBatch is a /go/translate/batch body of about size bytes.
+func bigBatch(size int) string {
+	var b strings.Builder
+	b.WriteString(`{"items":[`)
+	for i := 0; b.Len() < size; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":"%d","q":"phrase %d %s"}`, i, i, strings.Repeat("x", 200))
+	}
+	b.WriteString(`]}`)
+	return b.String()
+}
+
+func TestIdempotencyHonorsRouteBodyLimit(t *testing.T) {
+	h := newTestServer(t, map[string]string{"BATCH_MAX_ITEMS": "1000", "BATCH_MAX_ITEMS_PRO": "1000"}, Deps{}).Handler()
+	big := bigBatch(96 << 10) // over MAX_BODY_BYTES, under BATCH_MAX_BODY_BYTES
+	tests := []struct {
+		name   string
+		body   string
+		key    string
+		status int
+	}{
+		{"without key", big, "", http.StatusOK},
+		{"with key", big, "big-1", http.StatusOK},
+		{"over the route limit with key", bigBatch(1<<20 + 1024), "big-2", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "POST", "/go/translate/batch", tt.body, "X-API-Key", testProKey, "Content-Type", "application/json", "Idempotency-Key", tt.key)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+	t.Run("replayed", func(t *testing.T) {
+		w := serve(h, "POST", "/go/translate/batch", big, "X-API-Key", testProKey, "Content-Type", "application/json", "Idempotency-Key", "big-1")
+		if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replay") != "true" {
+			t.Fatalf("status %d, replay %q", w.Code, w.Header().Get("Idempotent-Replay"))
+		}
+	})
+}
+
+func TestIdempotencyKeepsRootLimitOnOtherRoutes(t *testing.T) {
+	h := newTestServer(t, nil, Deps{}).Handler()
+	body := `{"q":"` + strings.Repeat("x", 70<<10) + `"}`
+	w := serve(h, "POST", "/go/translate", body, "X-API-Key", testProKey, "Content-Type", "application/json", "Idempotency-Key", "k")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIdempotencyDoesNotStoreThrottling(t *testing.T) {
+	up := &echoUpstream{}
+	h := newTestServer(t, map[string]string{"RATE_LIMIT_PER_MIN": "1", "RATE_LIMIT_BURST": "1"}, Deps{Upstream: up}).Handler()
+	send := func(key string) *http.Response {
+		return serve(h, "POST", "/go/translate", `{"q":"hello"}`, "X-API-Key", testFreeKey, "Content-Type", "application/json", "Idempotency-Key", key).Result()
+	}
+	if res := send("first"); res.StatusCode != http.StatusOK {
+		t.Fatalf("first: status %d", res.StatusCode)
+	}
+	limited := send("second")
+	if limited.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second: status %d, want 429", limited.StatusCode)
+	}
+	// The bucket is empty still, so the retry is throttled again rather than replayed.
+	retry := send("second")
+	if retry.StatusCode != http.StatusTooManyRequests || retry.Header.Get("Idempotent-Replay") != "" {
+		t.Fatalf("retry: status %d, replay %q", retry.StatusCode, retry.Header.Get("Idempotent-Replay"))
+	}
+	if got := retry.Header.Get("X-Request-Id"); got == limited.Header.Get("X-Request-Id") {
+		t.Fatalf("retry answered with the first 429's request id %q", got)
+	}
+}
+
+// TestIdempotencyFingerprint retries a translation under the same key, changing one part of the
+// request at a time: anything that changes the response makes it a different request.
+func TestIdempotencyFingerprint(t *testing.T) {
+	h := newTestServer(t, map[string]string{"RATE_LIMIT_PRO_BURST": "100"}, Deps{}).Handler()
+	first := []string{"X-API-Key", testProKey, "Content-Type", "application/json", "Idempotency-Key", "fp", "Accept", "application/json"}
+	if w := serve(h, "POST", "/go/translate", `{"q":"hello"}`, first...); w.Code != http.StatusOK {
+		t.Fatalf("first: status %d: %s", w.Code, w.Body.String())
+	}
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		headers []string // set over the first request's
+		status  int
+	}{
+		{"same request", "/go/translate", `{"q":"hello"}`, nil, http.StatusOK},
+		{"other body", "/go/translate", `{"q":"bye"}`, nil, http.StatusUnprocessableEntity},
+		{"other query", "/go/translate?fields=translation", `{"q":"hello"}`, nil, http.StatusUnprocessableEntity},
+		{"other version", "/go/v2/translate", `{"q":"hello"}`, nil, http.StatusUnprocessableEntity},
+		{"other Accept", "/go/translate", `{"q":"hello"}`, []string{"Accept", "text/plain"}, http.StatusUnprocessableEntity},
+		{"other Accept-Encoding", "/go/translate", `{"q":"hello"}`, []string{"Accept-Encoding", "gzip"}, http.StatusUnprocessableEntity},
+		{"forced stub", "/go/translate", `{"q":"hello"}`, []string{stubHeader, "1"}, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "POST", tt.target, tt.body, append(slices.Clone(first), tt.headers...)...)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if replayed := w.Header().Get("Idempotent-Replay") == "true"; replayed != (tt.status == http.StatusOK) {
+				t.Fatalf("replayed %v", replayed)
+			}
+		})
+	}
+}
+
+// TestIdempotencyReplayHeaders checks a replay carries the first response's content headers but
+// the retry's own request id, and none of the first attempt's rate limit counts.
+func TestIdempotencyReplayHeaders(t *testing.T) {
+	h := newTestServer(t, map[string]string{"RATE_LIMIT_PRO_BURST": "100"}, Deps{}).Handler()
+	send := func(id string) *httptest.ResponseRecorder {
+		return serve(h, "POST", "/go/translate", `{"q":"hello"}`, "X-API-Key", testProKey, "Content-Type", "application/json",
+			"Idempotency-Key", "hdr", "X-Request-Id", id)
+	}
+	first := send("first-attempt")
+	if first.Code != http.StatusOK || first.Header().Get("X-RateLimit-Remaining") == "" {
+		t.Fatalf("first: status %d, headers %v", first.Code, first.Header())
+	}
+	retry := send("the-retry")
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replay") != "true" {
+		t.Fatalf("retry: status %d, replay %q: %s", retry.Code, retry.Header().Get("Idempotent-Replay"), retry.Body.String())
+	}
+	if got := retry.Header().Get("X-Request-Id"); got != "the-retry" {
+		t.Fatalf("replay X-Request-Id %q, want the retry's", got)
+	}
+	if got, want := retry.Header().Get("Content-Type"), first.Header().Get("Content-Type"); got != want {
+		t.Fatalf("replay Content-Type %q, want %q", got, want)
+	}
+	if got := retry.Header().Get("X-RateLimit-Remaining"); got != "" {
+		t.Fatalf("replay carried the first attempt's X-RateLimit-Remaining %q", got)
+	}
+}
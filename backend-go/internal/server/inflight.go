@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// errCancelledByAdmin is the cause of a request's context when DELETE /go/admin/inflight/{id}
+// ended it.
+var errCancelledByAdmin = errors.New("cancelled by an operator")
+
+// inflightRegistry holds every request being served, by request id, for GET /go/admin/inflight.
+// Registering costs a sync.Map store and delete per request; the parts filled in later (the
+// caller, upstream calls) are atomics on the entry, so listing never waits on a request.
+type inflightRegistry struct {
+	reqs  sync.Map // request id → *inflightEntry
+	clock Clock    // for when each started, and the ages listed
+}
+
+// count is how many requests are in flight; 0 for a nil registry.
+func (g *inflightRegistry) count() int {
+	if g == nil {
+		return 0
+	}
+	n := 0
+	g.reqs.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// inflightEntry is one request in flight.
+type inflightEntry struct {
+	id, method, path, ip string
+	started              time.Time
+	cancel               context.CancelCauseFunc
+
+	client   atomic.Pointer[string] // key id, once authenticated
+	upstream atomic.Pointer[string] // the member called last
+	calls    atomic.Int32           // upstream calls started
+	waiting  atomic.Int32           // of those, not answered yet
+}
+
+type inflightKey struct{}
+
+func inflightFrom(ctx context.Context) *inflightEntry {
+	e, _ := ctx.Value(inflightKey{}).(*inflightEntry)
+	return e
+}
+
+// noteInflightClient records the authenticated key on ctx's entry.
+func noteInflightClient(ctx context.Context, keyID string) {
+	if e := inflightFrom(ctx); e != nil {
+		e.client.Store(&keyID)
+	}
+}
+
+// noteInflightUpstream records a call to upstream starting; the returned func marks it answered.
+func noteInflightUpstream(ctx context.Context, upstream string) func() {
+	e := inflightFrom(ctx)
+	if e == nil {
+		return func() {}
+	}
+	e.upstream.Store(&upstream)
+	e.calls.Add(1)
+	e.waiting.Add(1)
+	return func() { e.waiting.Add(-1) }
+}
+
+// middleware registers each request for its lifetime, and gives it a context the registry can
+// cancel. The entry goes in a deferred cleanup, so a panicking handler doesn't leave it behind. A
+// request cancelled that way that wrote nothing gets a 499 CANCELLED. Requests with no id, or
+// with one a request still in flight already uses (ids can come from the client), aren't listed.
+func (reg *inflightRegistry) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := middleware.GetReqID(r.Context())
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithCancelCause(r.Context())
+		e := &inflightEntry{id: id, method: r.Method, path: r.URL.Path, ip: clientIP(r), started: reg.clock.Now(), cancel: cancel}
+		if _, dup := reg.reqs.LoadOrStore(id, e); dup {
+			cancel(nil)
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer func() {
+			reg.reqs.CompareAndDelete(id, e)
+			cancel(nil)
+		}()
+		ww := wrapResponse(w, r)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(ctx, inflightKey{}, e)))
+		if ww.Status() == 0 && errors.Is(context.Cause(ctx), errCancelledByAdmin) {
+			writeError(ww, statusClientClosed, codeCancelled, "request cancelled by an operator")
+		}
+	})
+}
+
+// inflightRequest is one entry as GET /go/admin/inflight lists it.
+type inflightRequest struct {
+	RequestID string            `json:"request_id"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	ClientID  string            `json:"client_id,omitempty"` // the key id; empty before or without auth
+	ClientIP  string            `json:"client_ip"`
+	StartedAt time.Time         `json:"started_at"`
+	AgeMS     int64             `json:"age_ms"`
+	Upstream  *inflightUpstream `json:"upstream,omitempty"`
+}
+
+// inflightUpstream is where a request stands with the upstreams.
+type inflightUpstream struct {
+	Last    string `json:"last"`    // the member called last
+	Calls   int32  `json:"calls"`   // calls started, hedges and failovers included
+	Waiting int32  `json:"waiting"` // calls not answered yet
+}
+
+// list is every request in flight, oldest first.
+func (reg *inflightRegistry) list() []inflightRequest {
+	now := reg.clock.Now()
+	out := []inflightRequest{}
+	reg.reqs.Range(func(_, v any) bool {
+		e := v.(*inflightEntry)
+		ir := inflightRequest{
+			RequestID: e.id,
+			Method:    e.method,
+			Path:      e.path,
+			ClientIP:  e.ip,
+			StartedAt: e.started.UTC(),
+			AgeMS:     now.Sub(e.started).Milliseconds(),
+		}
+		if c := e.client.Load(); c != nil {
+			ir.ClientID = *c
+		}
+		if u := e.upstream.Load(); u != nil {
+			ir.Upstream = &inflightUpstream{Last: *u, Calls: e.calls.Load(), Waiting: e.waiting.Load()}
+		}
+		out = append(out, ir)
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+// cancel ends the request with id, if it is still in flight.
+func (reg *inflightRegistry) cancel(id string) (*inflightEntry, bool) {
+	v, ok := reg.reqs.Load(id)
+	if !ok {
+		return nil, false
+	}
+	e := v.(*inflightEntry)
+	e.cancel(errCancelledByAdmin)
+	return e, true
+}
+
+// inflightListHandler serves GET /go/admin/inflight.
+func inflightListHandler(reg *inflightRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		reqs := reg.list()
+		j(w, http.StatusOK, map[string]any{"count": len(reqs), "requests": reqs})
+	}
+}
+
+// inflightCancelHandler serves DELETE /go/admin/inflight/{request_id}. The request's context is
+// cancelled, so its upstream calls stop, its slots are given back as its handler returns, and
+// its client gets a 499; this answers once the cancel is sent, not once the request has ended.
+func inflightCancelHandler(reg *inflightRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "request_id")
+		auditParam(r.Context(), "request_id", id)
+		e, ok := reg.cancel(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, codeNotFound, "no request in flight with this id")
+			return
+		}
+		age := reg.clock.Now().Sub(e.started)
+		slog.Info("request cancelled", "request_id", middleware.GetReqID(r.Context()), "admin_id", adminFrom(r.Context()),
+			"cancelled_request_id", id, "path", e.path, "age_ms", age.Milliseconds())
+		j(w, http.StatusOK, map[string]any{"cancelled": true, "request_id": id, "path": e.path, "age_ms": age.Milliseconds()})
+	}
+}
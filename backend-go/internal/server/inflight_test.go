@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// TestInflightCancel holds a translation on a slow upstream, finds it in GET /go/admin/inflight
+// with its key and upstream call, cancels it, and checks the client gets a 499 CANCELLED, the
+// upstream call is dropped, the key's one slot comes back and the entry is gone.
+func TestInflightCancel(t *testing.T) {
+	entered, dropped := make(chan struct{}, 1), make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("q") == "slow" {
+			entered <- struct{}{}
+			<-r.Context().Done()
+			close(dropped)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "data": map[string]string{"tgt": "T"}})
+	}))
+	defer up.Close()
+	u, _ := url.Parse(up.URL)
+	h := newTestServer(t, map[string]string{"UPSTREAM_URL": up.URL, "UPSTREAM_MAX_ATTEMPTS": "1", "KEY_CONCURRENCY_PRO": "1"}, Deps{}).Handler()
+	admin := func(method, target string) map[string]any {
+		t.Helper()
+		w := serve(h, method, target, "", "Authorization", "Bearer "+testAdmin)
+		var out map[string]any
+		json.Unmarshal(w.Body.Bytes(), &out)
+		out["status"] = float64(w.Code)
+		return out
+	}
+
+	slow := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		slow <- serve(h, "GET", "/go/translate?q=slow&src=dv&dst=en", "", "X-API-Key", testProKey, middleware.RequestIDHeader, "slow-1")
+	}()
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow translation never reached the upstream")
+	}
+	if w := serve(h, "GET", "/go/translate?q=salaam&src=dv&dst=en", "", "X-API-Key", testProKey); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second call on a key with 1 slot held: status %d, want 429", w.Code)
+	}
+
+	list := admin("GET", "/go/admin/inflight")
+	reqs, _ := list["requests"].([]any)
+	if list["status"] != 200.0 || list["count"] != 2.0 || len(reqs) != 2 {
+		t.Fatalf("inflight list, want the slow call and the list itself: %v", list)
+	}
+	first := reqs[0].(map[string]any)
+	upstream, _ := first["upstream"].(map[string]any)
+	if first["request_id"] != "slow-1" || first["method"] != "GET" || first["path"] != "/go/translate" || first["client_id"] != "acme" ||
+		upstream["last"] != u.Host || upstream["calls"] != 1.0 || upstream["waiting"] != 1.0 {
+		t.Fatalf("oldest in flight %v, want slow-1 from acme waiting on %s", first, u.Host)
+	}
+	if second := reqs[1].(map[string]any); second["path"] != "/go/admin/inflight" || second["upstream"] != nil {
+		t.Fatalf("newest in flight %v, want the list request", second)
+	}
+
+	if out := admin("DELETE", "/go/admin/inflight/slow-1"); out["status"] != 200.0 || out["cancelled"] != true || out["request_id"] != "slow-1" || out["path"] != "/go/translate" {
+		t.Fatalf("cancel: %v", out)
+	}
+	var w *httptest.ResponseRecorder
+	select {
+	case w = <-slow:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cancelled translation never returned")
+	}
+	if w.Code != statusClientClosed {
+		t.Fatalf("cancelled translation: status %d, want 499: %s", w.Code, w.Body.String())
+	}
+	checkEnvelope(t, w)
+	var res struct {
+		Error map[string]any `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Error["code"] != string(codeCancelled) || res.Error["message"] != "request cancelled by an operator" {
+		t.Fatalf("cancelled translation body %s", w.Body.String())
+	}
+	select {
+	case <-dropped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the upstream call outlived the cancel")
+	}
+
+	if w := serve(h, "GET", "/go/translate?q=salaam&src=dv&dst=en", "", "X-API-Key", testProKey); w.Code != http.StatusOK {
+		t.Fatalf("call after the cancel: status %d, want the slot back: %s", w.Code, w.Body.String())
+	}
+	if list := admin("GET", "/go/admin/inflight"); list["count"] != 1.0 {
+		t.Fatalf("inflight after the cancel: %v, want only the list itself", list)
+	}
+	if out := admin("DELETE", "/go/admin/inflight/slow-1"); out["status"] != 404.0 {
+		t.Fatalf("second cancel: %v, want 404", out)
+	}
+}
+
+// TestInflightPanic checks a handler that panics doesn't leave its entry listed.
+func TestInflightPanic(t *testing.T) {
+	reg := &inflightRegistry{clock: systemClock{}}
+	h := middleware.RequestID(reg.middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		if reg.count() != 1 {
+			t.Error("the request wasn't registered while it ran")
+		}
+		panic("boom")
+	})))
+	func() {
+		defer func() { recover() }()
+		serve(h, "GET", "/go/translate", "")
+	}()
+	if n := reg.count(); n != 0 {
+		t.Fatalf("%d entries left after a panic, want 0", n)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Input validation, ahead of normalization: text the upstream tokenizer chokes on, or that can
+// make the frontend show something other than what was sent, is refused with a code per reason
+// instead of being passed through.
+
+// Control character policies for INPUT_CONTROL_CHARS.
+const (
+	controlsStrip  = "strip"
+	controlsReject = "reject"
+)
+
+// InputOptions are the caller- and configuration-dependent parts of ValidateInput.
+type InputOptions struct {
+	RejectControls bool // refuse C0/C1 control characters instead of removing them
+	AllowBidi      bool // let bidi embeddings, overrides and isolates through (normalization drops them)
+}
+
+// InputError is why ValidateInput refused s. Offset is the byte offset of the offending rune.
+type InputError struct {
+	Code   errorCode
+	Rune   rune
+	Offset int
+}
+
+func (e *InputError) Error() string {
+	switch e.Code {
+	case codeInvalidUTF8:
+		return fmt.Sprintf("q is not valid UTF-8 (at byte %d)", e.Offset)
+	case codeControlChars:
+		return fmt.Sprintf("q contains control character %U at byte %d", e.Rune, e.Offset)
+	default:
+		return fmt.Sprintf("q contains bidi control %U at byte %d; pass allow_bidi=1 if it is intended", e.Rune, e.Offset)
+	}
+}
+
+// ValidateInput checks translate input and returns it with control characters removed when
+// opts allows that. Invalid UTF-8 is always refused, and so is U+FFFD, because encoding/json
+// substitutes it for bad bytes in JSON bodies. Tab, newline and carriage return (as in CRLF, which
+// browsers send from text areas) are not control characters here. Any error is an *InputError.
+func ValidateInput(s string, opts InputOptions) (string, error) {
+	var strip []int
+	for i, r := range s {
+		switch {
+		case r == utf8.RuneError:
+			return "", &InputError{Code: codeInvalidUTF8, Rune: r, Offset: i}
+		case isBidiControl(r) && !opts.AllowBidi:
+			return "", &InputError{Code: codeBidiOverride, Rune: r, Offset: i}
+		case unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r':
+			if opts.RejectControls {
+				return "", &InputError{Code: codeControlChars, Rune: r, Offset: i}
+			}
+			strip = append(strip, i)
+		}
+	}
+	if len(strip) == 0 {
+		return s, nil
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	last := 0
+	for _, i := range strip {
+		b.WriteString(s[last:i])
+		_, size := utf8.DecodeRuneInString(s[i:])
+		last = i + size
+	}
+	b.WriteString(s[last:])
+	return b.String(), nil
+}
+
+// isBidiControl reports the explicit directional embeddings, overrides and isolates
+// (U+202A–U+202E, U+2066–U+2069), which can reorder how surrounding text displays.
+func isBidiControl(r rune) bool {
+	return r >= '\u202A' && r <= '\u202E' || r >= '\u2066' && r <= '\u2069'
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestValidateInput(t *testing.T) {
+	strip, reject, bidi := InputOptions{}, InputOptions{RejectControls: true}, InputOptions{AllowBidi: true}
+	tests := []struct {
+		name   string
+		in     string
+		opts   InputOptions
+		out    string
+		code   errorCode // "" when accepted
+		offset int
+	}{
+		{"plain", "good morning", strip, "good morning", "", 0},
+		{"thaana", "ކިހިނެއް", strip, "ކިހިނެއް", "", 0},
+		{"tab, newline and crlf kept", "a\tb\nc\r\nd", reject, "a\tb\nc\r\nd", "", 0},
+		{"c0 stripped", "a\x00b\x1bc", strip, "abc", "", 0},
+		{"c1 stripped", "a\u0085b\u009fc", strip, "abc", "", 0},
+		{"del stripped", "a\x7fb", strip, "ab", "", 0},
+		{"only controls", "\x01\x02", strip, "", "", 0},
+		{"c0 rejected", "ab\x00c", reject, "", codeControlChars, 2},
+		{"c1 rejected", "é\u0085", reject, "", codeControlChars, 2},
+		{"invalid byte", "ab\xffc", strip, "", codeInvalidUTF8, 2},
+		{"truncated sequence", "a\xe0\xa4", strip, "", codeInvalidUTF8, 1},
+		{"surrogate half", "a\xed\xa0\x80", strip, "", codeInvalidUTF8, 1},
+		{"replacement character", "a\uFFFD", strip, "", codeInvalidUTF8, 1},
+		{"right-to-left override", "abc\u202Edcba", strip, "", codeBidiOverride, 3},
+		{"embedding", "\u202Ax", strip, "", codeBidiOverride, 0},
+		{"isolate", "x\u2066y\u2069", strip, "", codeBidiOverride, 1},
+		{"pop directional isolate", "x\u2069", strip, "", codeBidiOverride, 1},
+		{"bidi allowed", "abc\u202Edcba", bidi, "abc\u202Edcba", "", 0},
+		{"marks are not overrides", "a\u200Fb\u200E", strip, "a\u200Fb\u200E", "", 0},
+		{"first refusal wins", "\x00\u202E", reject, "", codeControlChars, 0},
+		{"bad utf-8 even with bidi allowed", "\u202E\xff", bidi, "", codeInvalidUTF8, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := ValidateInput(tt.in, tt.opts)
+			if tt.code == "" {
+				if err != nil || out != tt.out {
+					t.Fatalf("%q, %v; want %q", out, err, tt.out)
+				}
+				return
+			}
+			var ie *InputError
+			if !errors.As(err, &ie) || ie.Code != tt.code || ie.Offset != tt.offset {
+				t.Fatalf("err %v, want %s at byte %d", err, tt.code, tt.offset)
+			}
+		})
+	}
+}
+
+// FuzzValidateInput checks what ValidateInput accepts is valid UTF-8 with the refused
+// characters gone, and that it only ever removes control characters.
+func FuzzValidateInput(f *testing.F) {
+	for _, seed := range []string{
+		"", "hello", "ކިހިނެއް ތިބެނީ", "a\tb\r\nc", "\x00\x1f\x7f", "\u0080\u009f", "\xff\xfe", "\xc3",
+		"\xed\xa0\x80", "\uFFFD", "\u202A\u202B\u202C\u202D\u202E", "\u2066\u2067\u2068\u2069",
+		"\u200E\u200F\u061C", "abc\u202Edcba.exe", "\uFEFFbom", "😀\x00😀",
+	} {
+		f.Add(seed, false, false)
+		f.Add(seed, true, true)
+	}
+	f.Fuzz(func(t *testing.T, s string, rejectControls, allowBidi bool) {
+		opts := InputOptions{RejectControls: rejectControls, AllowBidi: allowBidi}
+		out, err := ValidateInput(s, opts)
+		if err != nil {
+			var ie *InputError
+			if !errors.As(err, &ie) {
+				t.Fatalf("error %T, want *InputError", err)
+			}
+			if ie.Offset < 0 || ie.Offset >= len(s) {
+				t.Fatalf("offset %d outside %d bytes", ie.Offset, len(s))
+			}
+			return
+		}
+		if !utf8.ValidString(out) || strings.ContainsRune(out, utf8.RuneError) {
+			t.Fatalf("accepted %q as %q, not valid UTF-8", s, out)
+		}
+		removed := 0
+		for _, r := range out {
+			if isBidiControl(r) && !allowBidi {
+				t.Fatalf("bidi control %U let through", r)
+			}
+			if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+				t.Fatalf("control character %U let through", r)
+			}
+		}
+		rest := out
+		for _, r := range s {
+			if strings.HasPrefix(rest, string(r)) {
+				rest = rest[utf8.RuneLen(r):]
+				continue
+			}
+			if !unicode.IsControl(r) {
+				t.Fatalf("%q became %q: %U removed", s, out, r)
+			}
+			removed++
+		}
+		if rest != "" || rejectControls && removed > 0 {
+			t.Fatalf("%q became %q", s, out)
+		}
+	})
+}
+
+func TestInputValidationOnRoutes(t *testing.T) {
+	strip := newTestServer(t, nil, Deps{}).Handler()
+	reject := newTestServer(t, map[string]string{"INPUT_CONTROL_CHARS": controlsReject}, Deps{}).Handler()
+	tests := []struct {
+		name   string
+		h      http.Handler
+		method string
+		target string
+		body   string
+		status int
+		has    string // in the body
+		out    string
+	}{
+		{"get, control stripped", strip, "GET", "/go/translate?q=" + url.QueryEscape("hel\x07lo"), "", http.StatusOK, "", "EN(hello)"},
+		{"get, control rejected", reject, "GET", "/go/translate?q=" + url.QueryEscape("hel\x07lo"), "", http.StatusBadRequest, `"code":"CONTROL_CHARACTERS"`, ""},
+		{"get, invalid utf-8", strip, "GET", "/go/translate?q=%FFhello", "", http.StatusBadRequest, `"code":"INVALID_UTF8"`, ""},
+		{"get, override", strip, "GET", "/go/translate?q=" + url.QueryEscape("abc\u202E"), "", http.StatusBadRequest, `"code":"BIDI_OVERRIDE"`, ""},
+		{"get, override allowed", strip, "GET", "/go/translate?allow_bidi=1&q=" + url.QueryEscape("abc\u202E"), "", http.StatusOK, "", "EN(abc)"},
+		{"post, invalid utf-8", strip, "POST", "/go/translate", "{\"q\":\"a\xffb\"}", http.StatusBadRequest, `"code":"INVALID_UTF8"`, ""},
+		{"post, escaped override", strip, "POST", "/go/translate", `{"q":"abc\u202E"}`, http.StatusBadRequest, `"code":"BIDI_OVERRIDE"`, ""},
+		{"post, escaped control rejected", reject, "POST", "/go/translate", `{"q":"a\u0000b"}`, http.StatusBadRequest, `"code":"CONTROL_CHARACTERS"`, ""},
+		{"batch item", strip, "POST", "/go/translate/batch", `{"items":[{"id":"1","q":"ok"},{"id":"2","q":"x\u2066y"}]}`, http.StatusOK, `"2":{"error":"q contains bidi control U+2066`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.h, tt.method, tt.target, tt.body, "X-API-Key", testProKey, "Content-Type", "application/json")
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				checkEnvelope(t, w)
+			}
+			if !strings.Contains(w.Body.String(), tt.has) {
+				t.Fatalf("body without %s: %s", tt.has, w.Body.String())
+			}
+			if tt.out != "" {
+				var res struct {
+					Translation string `json:"translation"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &res)
+				if res.Translation != tt.out {
+					t.Fatalf("translated %q, want %q", res.Translation, tt.out)
+				}
+			}
+		})
+	}
+}
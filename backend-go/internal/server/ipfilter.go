@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// parsePrefixes parses comma-separated CIDRs; a bare address is taken as a single host.
+func parsePrefixes(raw string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, v := range strings.Split(raw, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			a, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an IP or CIDR", v)
+			}
+			a = a.Unmap()
+			out = append(out, netip.PrefixFrom(a, a.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a CIDR", v)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+// ipRules is one allow/deny configuration.
+type ipRules struct {
+	allow, deny []netip.Prefix
+}
+
+func (r *ipRules) empty() bool { return len(r.allow) == 0 && len(r.deny) == 0 }
+
+// check returns whether addr may pass and the rule that decided it ("" when no rule matched).
+// The denylist wins; with an allowlist configured, anything outside it is refused.
+func (r *ipRules) check(addr netip.Addr, ok bool) (bool, string) {
+	if ok {
+		for _, p := range r.deny {
+			if p.Contains(addr) {
+				return false, "deny " + p.String()
+			}
+		}
+	}
+	if len(r.allow) == 0 {
+		return true, ""
+	}
+	if ok {
+		for _, p := range r.allow {
+			if p.Contains(addr) {
+				return true, "allow " + p.String()
+			}
+		}
+	}
+	return false, "not in allowlist"
+}
+
+// ipFilter applies IP_ALLOWLIST and IP_DENYLIST to the realIP-resolved client address. The
+// rules sit behind an atomic pointer so they can be swapped without a restart.
+type ipFilter struct {
+	rules atomic.Pointer[ipRules]
+}
+
+func newIPFilter(allow, deny []netip.Prefix) *ipFilter {
+	f := &ipFilter{}
+	f.set(allow, deny)
+	return f
+}
+
+func (f *ipFilter) set(allow, deny []netip.Prefix) {
+	f.rules.Store(&ipRules{allow: allow, deny: deny})
+}
+
+func (f *ipFilter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules := f.rules.Load()
+		if rules.empty() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		addr, err := netip.ParseAddr(ip)
+		addr = addr.WithZone("").Unmap()
+		allowed, rule := rules.check(addr, err == nil)
+		slog.Debug("ip filter", "request_id", middleware.GetReqID(r.Context()), "client_ip", ip, "allowed", allowed, "rule", rule)
+		if !allowed {
+			writeError(w, http.StatusForbidden, codeForbidden, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
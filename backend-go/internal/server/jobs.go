@@ -0,0 +1,738 @@
+package server
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Asynchronous jobs. POST /go/jobs takes a batch body or a bulk NDJSON upload, stores it and
+// answers 202 at once; a small worker pool translates queued jobs in the background while the
+// owner polls GET /go/jobs/{id}. Jobs and every finished item live in SQLite (JOBS_DB_PATH),
+// so a restart picks running jobs up where they stopped.
+
+// Job statuses. done, failed and canceled are final.
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// jobOpts bounds the job API.
+type jobOpts struct {
+	Workers       int           // jobs translated at once
+	Retention     time.Duration // finished jobs are kept this long
+	MaxItems      int           // per job for pro keys; free keys get the batch limit
+	MaxItemsFree  int
+	MaxPending    int // queued and running jobs one key may have
+	InlineResults int // a status for a done job this small carries its results
+	ItemTimeout   time.Duration
+	Callbacks     callbackOpts
+	Scopes        func(keyID string) *keyScopes // the owner's current scopes, for the direction check
+	Artifacts     *artifactStore                // where results are spooled for download
+}
+
+// job is one stored job. Req holds every item, with the batch-level src and dst.
+type job struct {
+	ID, Owner, Tier, Status, Error string
+	Req                            batchReq
+	Created, Updated               time.Time
+	CallbackURL                    string
+	CallbackState                  string // callbackPending etc.; empty without a callback
+}
+
+// jobPayload is a POST /go/jobs JSON body: a batch, and where to report when it finishes.
+type jobPayload struct {
+	batchReq
+	CallbackURL string `json:"callback_url"`
+}
+
+var errJobNotFound = errors.New("job not found")
+
+// jobStore is the SQLite side of the job API.
+type jobStore struct {
+	db    *sql.DB
+	clock Clock // what the created, updated and callback times are read from
+}
+
+// openJobStore opens or creates the job DB at path. Jobs a previous process left running go
+// back on the queue; their finished items are kept and skipped.
+func openJobStore(path string, clk Clock) (*jobStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate(db, "jobs", clk); err != nil {
+		db.Close()
+		return nil, err
+	}
+	res, err := db.Exec(`UPDATE jobs SET status = ? WHERE status = ?`, jobQueued, jobRunning)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("jobs resumed after restart", "jobs", n)
+	}
+	if _, err := db.Exec(`UPDATE jobs SET callback_state = ? WHERE callback_state = ?`, callbackPending, callbackSending); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &jobStore{db: db, clock: clk}, nil
+}
+
+func (s *jobStore) create(ctx context.Context, jb job) error {
+	req, err := json.Marshal(jb.Req)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO jobs (id, owner, tier, status, request, total, created_at, updated_at, callback_url) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		jb.ID, jb.Owner, jb.Tier, jb.Status, req, len(jb.Req.Items), jb.Created.Unix(), jb.Created.Unix(), jb.CallbackURL)
+	return err
+}
+
+func (s *jobStore) scan(row interface{ Scan(...any) error }) (job, error) {
+	var (
+		jb               job
+		req              []byte
+		created, updated int64
+	)
+	if err := row.Scan(&jb.ID, &jb.Owner, &jb.Tier, &jb.Status, &req, &jb.Error, &created, &updated, &jb.CallbackURL, &jb.CallbackState); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return jb, errJobNotFound
+		}
+		return jb, err
+	}
+	jb.Created, jb.Updated = time.Unix(created, 0), time.Unix(updated, 0)
+	return jb, json.Unmarshal(req, &jb.Req)
+}
+
+const jobColumns = `id, owner, tier, status, request, error, created_at, updated_at, callback_url, callback_state`
+
+func (s *jobStore) get(ctx context.Context, id string) (job, error) {
+	return s.scan(s.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+}
+
+// claim marks the oldest queued job running and returns it; ok is false when none is queued.
+func (s *jobStore) claim(ctx context.Context) (job, bool, error) {
+	jb, err := s.scan(s.db.QueryRowContext(ctx, `UPDATE jobs SET status = ?, updated_at = ?
+		WHERE id = (SELECT id FROM jobs WHERE status = ? ORDER BY created_at, id LIMIT 1)
+		RETURNING `+jobColumns, jobRunning, s.clock.Now().Unix(), jobQueued))
+	if errors.Is(err, errJobNotFound) {
+		return jb, false, nil
+	}
+	return jb, err == nil, err
+}
+
+// setStatus moves job id to the final status if it is in one of from, reporting whether it was.
+// A job with a callback URL is queued for delivery in the same update.
+func (s *jobStore) setStatus(ctx context.Context, id, status, msg string, from ...string) (bool, error) {
+	q := `UPDATE jobs SET status = ?, error = ?, updated_at = ?,
+		callback_state = CASE callback_url WHEN '' THEN '' ELSE ? END, callback_next_at = ?
+		WHERE id = ? AND status IN (?` + strings.Repeat(", ?", len(from)-1) + `)`
+	now := s.clock.Now().Unix()
+	args := []any{status, msg, now, callbackPending, now, id}
+	for _, f := range from {
+		args = append(args, f)
+	}
+	res, err := s.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *jobStore) putItem(ctx context.Context, id string, seq int, itemID string, res batchResult) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT OR REPLACE INTO job_items (job_id, seq, item_id, result, failed) VALUES (?, ?, ?, ?, ?)`,
+		id, seq, itemID, b, res.Error != "")
+	return err
+}
+
+// finished returns the seqs of job id's items that already have a result.
+func (s *jobStore) finished(ctx context.Context, id string) (map[int]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT seq FROM job_items WHERE job_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seqs := make(map[int]bool)
+	for rows.Next() {
+		var seq int
+		if err := rows.Scan(&seq); err != nil {
+			return nil, err
+		}
+		seqs[seq] = true
+	}
+	return seqs, rows.Err()
+}
+
+func (s *jobStore) progress(ctx context.Context, id string) (done, failed int, err error) {
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(failed), 0) FROM job_items WHERE job_id = ?`, id).Scan(&done, &failed)
+	return done, failed, err
+}
+
+// results calls fn for each of job id's item results, in submission order. Like the cache
+// export, it reads a page at a time so a slow client doesn't hold the one connection.
+func (s *jobStore) results(ctx context.Context, id string, fn func(itemID string, res batchResult) error) error {
+	type row struct {
+		itemID string
+		res    []byte
+	}
+	after := -1
+	for {
+		rows, err := s.db.QueryContext(ctx, `SELECT seq, item_id, result FROM job_items WHERE job_id = ? AND seq > ? ORDER BY seq LIMIT ?`,
+			id, after, exportPage)
+		if err != nil {
+			return err
+		}
+		var page []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&after, &r.itemID, &r.res); err != nil {
+				rows.Close()
+				return err
+			}
+			page = append(page, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, r := range page {
+			var res batchResult
+			if err := json.Unmarshal(r.res, &res); err != nil {
+				return err
+			}
+			if err := fn(r.itemID, res); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPage {
+			return nil
+		}
+	}
+}
+
+// pending counts owner's queued and running jobs.
+func (s *jobStore) pending(ctx context.Context, owner string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM jobs WHERE owner = ? AND status IN (?, ?)`, owner, jobQueued, jobRunning).Scan(&n)
+	return n, err
+}
+
+// prune drops finished jobs last updated before cutoff, with their items and deliveries. A
+// callback still being retried keeps its job.
+func (s *jobStore) prune(cutoff time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM jobs WHERE status IN (?, ?, ?) AND updated_at < ? AND callback_state NOT IN (?, ?)`,
+		jobDone, jobFailed, jobCanceled, cutoff.Unix(), callbackPending, callbackSending)
+	if err != nil {
+		return 0, err
+	}
+	for _, table := range []string{"job_items", "job_deliveries"} {
+		if _, err := s.db.Exec(`DELETE FROM ` + table + ` WHERE job_id NOT IN (SELECT id FROM jobs)`); err != nil {
+			return 0, err
+		}
+	}
+	return res.RowsAffected()
+}
+
+// jobSummary is one job as GET /go/admin/jobs lists it: no items, no results.
+type jobSummary struct {
+	ID            string    `json:"job_id"`
+	KeyID         string    `json:"key_id"`
+	Tier          string    `json:"tier"`
+	Status        string    `json:"status"`
+	Total         int       `json:"total"`
+	Error         string    `json:"error,omitempty"`
+	CallbackState string    `json:"callback_state,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// jobFilter narrows list. Empty fields match everything.
+type jobFilter struct {
+	Status, Owner, Tier string
+	Since               time.Time // only jobs created at or after it
+}
+
+// list returns the page req asks for of jobs matching f, newest first.
+func (s *jobStore) list(ctx context.Context, f jobFilter, req pageReq) ([]jobSummary, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	for _, c := range [][2]string{{"status", f.Status}, {"owner", f.Owner}, {"tier", f.Tier}} {
+		if c[1] != "" {
+			conds, args = append(conds, c[0]+" = ?"), append(args, c[1])
+		}
+	}
+	if !f.Since.IsZero() {
+		conds, args = append(conds, "created_at >= ?"), append(args, f.Since.Unix())
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	where, args = seekAfter(where, args, req, "id")
+	rows, err := s.db.QueryContext(ctx, `SELECT id, owner, tier, status, total, error, callback_state, created_at, updated_at
+		FROM jobs`+where+` ORDER BY created_at DESC, id DESC LIMIT ?`, append(args, req.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []jobSummary{}
+	for rows.Next() {
+		var (
+			js               jobSummary
+			created, updated int64
+		)
+		if err := rows.Scan(&js.ID, &js.KeyID, &js.Tier, &js.Status, &js.Total, &js.Error, &js.CallbackState, &created, &updated); err != nil {
+			return nil, err
+		}
+		js.CreatedAt, js.UpdatedAt = time.Unix(created, 0).UTC(), time.Unix(updated, 0).UTC()
+		out = append(out, js)
+	}
+	return out, rows.Err()
+}
+
+func (s *jobStore) Ping(ctx context.Context) error { return s.db.PingContext(ctx) }
+
+func (s *jobStore) Close() error { return s.db.Close() }
+
+// jobRunner owns the worker pool and the job routes.
+type jobRunner struct {
+	store *jobStore
+	svc   *translateService
+	opts  jobOpts
+	clock Clock
+	wake  chan struct{}
+	quit  context.CancelFunc
+	done  chan struct{}
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+func newJobRunner(store *jobStore, svc *translateService, opts jobOpts) *jobRunner {
+	return &jobRunner{store: store, svc: svc, opts: opts, clock: store.clock, wake: make(chan struct{}, 1), done: make(chan struct{}), running: make(map[string]context.CancelFunc)}
+}
+
+// jobPoll is how often idle workers look for queued jobs they weren't woken for.
+const jobPoll = 5 * time.Second
+
+// start runs the workers, and prunes expired jobs hourly, until stop.
+func (jr *jobRunner) start() {
+	ctx, quit := context.WithCancel(context.Background())
+	jr.quit = quit
+	go func() {
+		defer close(jr.done)
+		jr.run(ctx)
+	}()
+}
+
+// stop ends the workers at their next item boundary and waits for them up to ctx. A job cut
+// off this way stays running in the store and is resumed by the next process.
+func (jr *jobRunner) stop(ctx context.Context) error {
+	if jr == nil {
+		return nil
+	}
+	jr.quit()
+	select {
+	case <-jr.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (jr *jobRunner) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range max(jr.opts.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jr.work(ctx)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		jr.deliverCallbacks(ctx)
+	}()
+	t := jr.clock.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-t.C():
+			n, err := jr.store.prune(jr.clock.Now().Add(-jr.opts.Retention))
+			if err != nil {
+				slog.Warn("job prune failed", "err", err)
+			} else if n > 0 {
+				slog.Info("jobs pruned", "jobs", n)
+			}
+		}
+	}
+}
+
+func (jr *jobRunner) work(ctx context.Context) {
+	t := jr.clock.NewTicker(jobPoll)
+	defer t.Stop()
+	for ctx.Err() == nil {
+		jb, ok, err := jr.store.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("job claim failed", "err", err)
+		}
+		if ok {
+			jr.process(ctx, jb)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-jr.wake:
+		case <-t.C():
+		}
+	}
+}
+
+// process translates jb's unfinished items one at a time, as its owner, recording each result
+// as it lands. Cancellation is checked between items; an item under way finishes.
+func (jr *jobRunner) process(ctx context.Context, jb job) {
+	jctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jr.mu.Lock()
+	jr.running[jb.ID] = cancel
+	jr.mu.Unlock()
+	defer func() {
+		jr.mu.Lock()
+		delete(jr.running, jb.ID)
+		jr.mu.Unlock()
+	}()
+
+	fail := func(msg string) {
+		if _, err := jr.store.setStatus(context.WithoutCancel(ctx), jb.ID, jobFailed, msg, jobRunning); err != nil {
+			slog.Warn("job status update failed", "job", jb.ID, "err", err)
+		}
+		metricJobs.WithLabelValues(jobFailed).Inc()
+	}
+	seen, err := jr.store.finished(jctx, jb.ID)
+	if err != nil {
+		fail("job store unavailable")
+		return
+	}
+	as := withIdentity(jctx, identity{KeyID: jb.Owner, Tier: jb.Tier, Scopes: jr.opts.Scopes(jb.Owner)})
+	for seq, it := range jb.Req.Items {
+		if jctx.Err() != nil {
+			return // canceled, or shutting down and left to resume
+		}
+		if seen[seq] {
+			continue
+		}
+		if err := jr.svc.usage.exhausted(as); err != nil {
+			fail(err.Error())
+			return
+		}
+		ictx, done := context.WithTimeout(context.WithoutCancel(as), jr.opts.ItemTimeout)
+		res := translateItem(ictx, batchItems{jr.svc}, jb.Req, it)
+		done()
+		if err := jr.store.putItem(context.WithoutCancel(ctx), jb.ID, seq, it.ID, res); err != nil {
+			slog.Warn("job item write failed", "job", jb.ID, "err", err)
+			fail("job store unavailable")
+			return
+		}
+	}
+	if ok, err := jr.store.setStatus(context.WithoutCancel(ctx), jb.ID, jobDone, "", jobRunning); err != nil {
+		slog.Warn("job status update failed", "job", jb.ID, "err", err)
+	} else if ok {
+		metricJobs.WithLabelValues(jobDone).Inc()
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "job_" + hex.EncodeToString(b)
+}
+
+// submit serves POST /go/jobs: a batch JSON body, or a bulk NDJSON upload with ?src= and ?dst=
+// defaults. The whole payload is checked before anything is queued. The job counts as one
+// request against the key's usage; each item's characters count against its quota as it runs.
+func (jr *jobRunner) submit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	payload, herr := readJobPayload(r)
+	if herr != nil {
+		herr.write(w)
+		return
+	}
+	req := payload.batchReq
+	if herr := applyTier(ctx, &req.Extended); herr != nil {
+		herr.write(w)
+		return
+	}
+	tier := tierFrom(ctx)
+	max := jr.opts.MaxItemsFree
+	if tier == tierPro {
+		max = jr.opts.MaxItems
+	} else if n := len(req.Items); n > max && n <= jr.opts.MaxItems {
+		upgradeRequired(fmt.Sprintf("jobs over %d items", max)).write(w)
+		return
+	}
+	if herr := validateBatch(req, max); herr != nil {
+		herr.write(w)
+		return
+	}
+	jr.svc.usage.request(ctx)
+	if err := jr.svc.usage.exhausted(ctx); err != nil {
+		writeTranslateError(ctx, w, err)
+		return
+	}
+	id, _ := identityFrom(ctx)
+	if payload.CallbackURL != "" {
+		if err := checkCallbackURL(payload.CallbackURL, jr.opts.Callbacks.AllowPrivate); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "callback_url: "+err.Error())
+			return
+		}
+		if _, ok := jr.opts.Callbacks.Secrets(id.KeyID); !ok {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "callback_url needs a webhook_secret on this API key")
+			return
+		}
+	}
+	n, err := jr.store.pending(ctx, id.KeyID)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeInternal, "job store unavailable", "detail", err.Error())
+		return
+	}
+	if n >= jr.opts.MaxPending {
+		writeError(w, http.StatusTooManyRequests, codeRateLimited, fmt.Sprintf("%d jobs already queued or running for this key", n), "max_pending", jr.opts.MaxPending)
+		return
+	}
+	jb := job{ID: newJobID(), Owner: id.KeyID, Tier: tier, Status: jobQueued, Req: req, Created: jr.clock.Now(), CallbackURL: payload.CallbackURL}
+	if err := jr.store.create(ctx, jb); err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeInternal, "job store unavailable", "detail", err.Error())
+		return
+	}
+	metricJobs.WithLabelValues(jobQueued).Inc()
+	select {
+	case jr.wake <- struct{}{}:
+	default:
+	}
+	w.Header().Set("Location", "/go/jobs/"+jb.ID)
+	j(w, http.StatusAccepted, map[string]any{"job_id": jb.ID, "status": jobQueued, "total": len(req.Items)})
+}
+
+// readJobPayload reads a jobPayload from a JSON body, or NDJSON batch items with the rest in
+// the query string. Unlike the bulk route, a malformed line rejects the whole upload, since
+// nothing has been answered yet.
+func readJobPayload(r *http.Request) (jobPayload, *httpError) {
+	var req jobPayload
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != "application/x-ndjson" {
+		return req, decodeJSONBody(r, &req)
+	}
+	q := r.URL.Query()
+	req.Src, req.Dst, req.Extended = q.Get("src"), q.Get("dst"), queryBool(q.Get("extended"))
+	req.CallbackURL = q.Get("callback_url")
+	sc := bufio.NewScanner(r.Body)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var it batchItem
+		if err := json.Unmarshal([]byte(line), &it); err != nil {
+			return req, &httpError{http.StatusBadRequest, codeBadRequest, fmt.Sprintf("line %d: invalid JSON", n)}
+		}
+		req.Items = append(req.Items, it)
+	}
+	if err := sc.Err(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return req, &httpError{http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("request body too large (limit %d bytes)", tooLarge.Limit)}
+		}
+		return req, &httpError{http.StatusBadRequest, codeBadRequest, "unreadable NDJSON body"}
+	}
+	return req, nil
+}
+
+// owned loads the job named in the URL if the caller owns it. Another key's job is reported
+// as missing, so ids can't be probed.
+func (jr *jobRunner) owned(w http.ResponseWriter, r *http.Request) (job, bool) {
+	id := chi.URLParam(r, "id")
+	jb, err := jr.store.get(r.Context(), id)
+	caller, _ := identityFrom(r.Context())
+	switch {
+	case errors.Is(err, errJobNotFound), err == nil && jb.Owner != caller.KeyID:
+		writeError(w, http.StatusNotFound, codeNotFound, "no job "+id)
+		return jb, false
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, codeInternal, "job store unavailable", "detail", err.Error())
+		return jb, false
+	}
+	return jb, true
+}
+
+// status serves GET /go/jobs/{id}: the job's state and progress, and once it is done, where
+// to get the results, or for a small job the results themselves.
+func (jr *jobRunner) status(w http.ResponseWriter, r *http.Request) {
+	jb, ok := jr.owned(w, r)
+	if !ok {
+		return
+	}
+	done, failed, err := jr.store.progress(r.Context(), jb.ID)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeInternal, "job store unavailable", "detail", err.Error())
+		return
+	}
+	out := map[string]any{
+		"job_id":     jb.ID,
+		"status":     jb.Status,
+		"progress":   map[string]int{"total": len(jb.Req.Items), "done": done, "failed": failed},
+		"created_at": jb.Created.UTC().Format(time.RFC3339),
+		"updated_at": jb.Updated.UTC().Format(time.RFC3339),
+	}
+	if jb.Error != "" {
+		out["error"] = jb.Error
+	}
+	if jb.CallbackURL != "" {
+		ds, err := jr.store.deliveries(r.Context(), jb.ID)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, codeInternal, "job store unavailable", "detail", err.Error())
+			return
+		}
+		if ds == nil {
+			ds = []delivery{}
+		}
+		out["callback"] = map[string]any{"url": jb.CallbackURL, "state": cmp.Or(jb.CallbackState, "waiting"), "deliveries": ds}
+	}
+	switch jb.Status {
+	case jobDone:
+		out["results_url"] = "/go/jobs/" + jb.ID + "/results"
+		if len(jb.Req.Items) <= jr.opts.InlineResults {
+			results := make(map[string]batchResult, len(jb.Req.Items))
+			err := jr.store.results(r.Context(), jb.ID, func(id string, res batchResult) error {
+				results[id] = res
+				return nil
+			})
+			if err != nil {
+				writeError(w, http.StatusServiceUnavailable, codeInternal, "job store unavailable", "detail", err.Error())
+				return
+			}
+			out["results"] = results
+		}
+		fallthrough
+	case jobFailed, jobCanceled:
+		out["expires_at"] = jb.Updated.Add(jr.opts.Retention).UTC().Format(time.RFC3339)
+	}
+	j(w, http.StatusOK, out)
+}
+
+// jobResultLine is one NDJSON line of GET /go/jobs/{id}/results.
+type jobResultLine struct {
+	ID string `json:"id"`
+	batchResult
+}
+
+// results serves GET /go/jobs/{id}/results as NDJSON, one line per item in submission order.
+// Results of a failed or canceled job so far are served too; a queued or running job is a 409.
+// They are written once, as an artifact under the job's id, and then served from it with
+// Range; while a large one is being written this is a 202.
+func (jr *jobRunner) results(w http.ResponseWriter, r *http.Request) {
+	jb, ok := jr.owned(w, r)
+	if !ok {
+		return
+	}
+	if jb.Status == jobQueued || jb.Status == jobRunning {
+		writeError(w, http.StatusConflict, codeConflict, "job "+jb.ID+" is still "+jb.Status)
+		return
+	}
+	jr.opts.Artifacts.serve(w, r, artifactSpec{
+		id:          jb.ID,
+		kind:        "job",
+		owner:       jb.Owner,
+		name:        jb.ID + "-results.jsonl",
+		contentType: "application/x-ndjson",
+		url:         "/go/jobs/" + jb.ID + "/results",
+		write: func(ctx context.Context, w io.Writer) (int, error) {
+			enc := json.NewEncoder(w)
+			n := 0
+			err := jr.store.results(ctx, jb.ID, func(id string, res batchResult) error {
+				n++
+				return enc.Encode(jobResultLine{ID: id, batchResult: res})
+			})
+			return n, err
+		},
+	})
+}
+
+// cancel serves DELETE /go/jobs/{id}. A queued job never starts; a running one stops once its
+// current item is done. Canceling a finished job is a 409.
+func (jr *jobRunner) cancel(w http.ResponseWriter, r *http.Request) {
+	jb, ok := jr.owned(w, r)
+	if !ok {
+		return
+	}
+	ok, err := jr.store.setStatus(r.Context(), jb.ID, jobCanceled, "", jobQueued, jobRunning)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeInternal, "job store unavailable", "detail", err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusConflict, codeConflict, "job "+jb.ID+" already "+jb.Status)
+		return
+	}
+	jr.mu.Lock()
+	if stop, ok := jr.running[jb.ID]; ok {
+		stop()
+	}
+	jr.mu.Unlock()
+	metricJobs.WithLabelValues(jobCanceled).Inc()
+	j(w, http.StatusOK, map[string]any{"job_id": jb.ID, "status": jobCanceled})
+}
+
+// jobListHandler serves GET /go/admin/jobs, every key's jobs newest first, paged (see paging.go)
+// and filtered by status, key_id and tier.
+func jobListHandler(s *jobStore, pg *pager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, herr := pg.readPage(r, "jobs", "status", "key_id", "tier")
+		if herr != nil {
+			herr.write(w)
+			return
+		}
+		q := r.URL.Query()
+		jobs, err := s.list(r.Context(), jobFilter{Status: q.Get("status"), Owner: q.Get("key_id"), Tier: q.Get("tier"), Since: req.Since}, req)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, codeInternal, "job store unavailable", "detail", err.Error())
+			return
+		}
+		jobs, next := pageTail(pg, req, jobs, func(js jobSummary) pageKey {
+			return pageKey{At: js.CreatedAt.UnixNano(), ID: []string{js.ID}}
+		})
+		j(w, http.StatusOK, pageBody(map[string]any{"jobs": jobs, "count": len(jobs)}, next))
+	}
+}
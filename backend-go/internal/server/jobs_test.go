@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJobsPrunedAfterRetention(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	store, err := openJobStore(filepath.Join(t.TempDir(), "jobs.db"), clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	finish := func(id string) {
+		t.Helper()
+		if err := store.create(ctx, job{ID: id, Owner: "acme", Tier: tierPro, Status: jobQueued, Created: clk.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.setStatus(ctx, id, jobDone, "", jobQueued); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jr := newJobRunner(store, nil, jobOpts{Workers: 1, Retention: 2 * time.Hour, Callbacks: callbackOpts{Workers: 1}})
+	jr.start()
+	defer jr.stop(ctx)
+	// The hourly prune, the worker's poll and the callback worker's poll.
+	wait, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := clk.BlockUntil(wait, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	finish("old")
+	clk.Advance(2 * time.Hour) // old is exactly at the retention, which keeps it
+	finish("new")
+	clk.Advance(time.Hour)
+	for {
+		if _, err := store.get(ctx, "old"); errors.Is(err, errJobNotFound) {
+			break
+		}
+		select {
+		case <-wait.Done():
+			t.Fatal("old job not pruned three hours after it finished")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if _, err := store.get(ctx, "new"); err != nil {
+		t.Fatalf("job an hour into its retention: %v", err)
+	}
+}
@@ -0,0 +1,424 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWT bearer tokens, for partners who mint short-lived tokens for their own clients rather than
+// ship an API key. Only what's needed to check a signed token is implemented: compact JWS with
+// RS*, PS*, ES* or EdDSA, against one static key or a JWKS. Unsigned ("none") and HMAC tokens are
+// refused, so a public key can never be used as a shared secret.
+
+// jwtClaims are the registered claims checked here, plus the raw set for the tier claim.
+type jwtClaims struct {
+	Issuer    string         `json:"iss"`
+	Subject   string         `json:"sub"`
+	Audience  jwtAudience    `json:"aud"`
+	ExpiresAt *float64       `json:"exp"`
+	NotBefore *float64       `json:"nbf"`
+	raw       map[string]any // every claim, for the tier
+	header    jwtHeader
+}
+
+// jwtAudience is "aud", which may be one string or a list.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = jwtAudience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return errors.New("aud must be a string or a list of strings")
+	}
+	*a = many
+	return nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// errJWTKeysUnavailable means the JWKS hasn't been fetched yet; the token may well be fine.
+var errJWTKeysUnavailable = errors.New("token signing keys unavailable")
+
+// jwtKeySource finds the public key a token names.
+type jwtKeySource interface {
+	key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// jwtVerifier checks bearer tokens against JWT_* settings.
+type jwtVerifier struct {
+	keys      jwtKeySource
+	issuer    string   // required "iss" when set
+	audience  []string // "aud" must name one of these when set
+	leeway    time.Duration
+	tierClaim string
+	clock     Clock
+}
+
+// newJWTVerifier returns the verifier for the JWT_* settings, or nil when bearer tokens are off.
+// The JWKS is fetched over rt.
+func newJWTVerifier(cfg Config, rt http.RoundTripper, clk Clock) (*jwtVerifier, error) {
+	v := &jwtVerifier{issuer: cfg.JWTIssuer, audience: cfg.JWTAudience, leeway: cfg.JWTLeeway, tierClaim: cfg.JWTTierClaim, clock: clk}
+	switch {
+	case cfg.JWTJWKSURL != nil:
+		v.keys = newJWKSKeys(cfg.JWTJWKSURL.String(), cfg.JWTJWKSRefresh, rt, clk)
+	case cfg.JWTPublicKey != "":
+		pub, err := loadJWTPublicKey(cfg.JWTPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = staticJWTKey{pub}
+	default:
+		return nil, nil
+	}
+	return v, nil
+}
+
+// verify checks token's signature and time window, issuer and audience, and returns its claims.
+func (v *jwtVerifier) verify(ctx context.Context, token string) (jwtClaims, error) {
+	var c jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return c, errors.New("malformed token")
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hb, &c.header) != nil {
+		return c, errors.New("malformed token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return c, errors.New("malformed token signature")
+	}
+	pub, err := v.keys.key(ctx, c.header.Kid)
+	if err != nil {
+		return c, err
+	}
+	if err := verifyJWS(c.header.Alg, pub, parts[0]+"."+parts[1], sig); err != nil {
+		return c, err
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(pb, &c) != nil || json.Unmarshal(pb, &c.raw) != nil {
+		return c, errors.New("malformed token claims")
+	}
+	now := v.clock.Now()
+	switch {
+	case c.ExpiresAt == nil:
+		return c, errors.New("token has no exp")
+	case now.After(unixFloat(*c.ExpiresAt).Add(v.leeway)):
+		return c, errors.New("token expired")
+	case c.NotBefore != nil && now.Add(v.leeway).Before(unixFloat(*c.NotBefore)):
+		return c, errors.New("token not valid yet")
+	case v.issuer != "" && c.Issuer != v.issuer:
+		return c, errors.New("token issuer not accepted")
+	case len(v.audience) > 0 && !slices.ContainsFunc(c.Audience, func(a string) bool { return slices.Contains(v.audience, a) }):
+		return c, errors.New("token audience not accepted")
+	}
+	return c, nil
+}
+
+// identity verifies token and maps it onto the caller identity the rate limiter and metering
+// use. The tier comes from the tier claim; anything but "pro" is free.
+func (v *jwtVerifier) identity(ctx context.Context, token string) (identity, error) {
+	c, err := v.verify(ctx, token)
+	if err != nil {
+		return identity{}, err
+	}
+	tier := tierFree
+	if t, _ := c.raw[v.tierClaim].(string); t == tierPro {
+		tier = tierPro
+	}
+	sub := c.Subject
+	if sub == "" {
+		sub = "anonymous"
+	}
+	return identity{KeyID: "jwt:" + sub, Tier: tier}, nil
+}
+
+func unixFloat(f float64) time.Time {
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9))
+}
+
+// verifyJWS checks sig over signed for alg with pub, refusing a key of the wrong type for alg.
+func verifyJWS(alg string, pub crypto.PublicKey, signed string, sig []byte) error {
+	var h crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		h = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		h = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		h = crypto.SHA512
+	case "EdDSA":
+		k, ok := pub.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, []byte(signed), sig) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("token algorithm %q not accepted", alg)
+	}
+	d := h.New()
+	d.Write([]byte(signed))
+	sum := d.Sum(nil)
+	ok := false
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'R' {
+			ok = rsa.VerifyPKCS1v15(k, h, sum, sig) == nil
+		} else if alg[0] == 'P' {
+			ok = rsa.VerifyPSS(k, h, sum, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		n := (k.Curve.Params().BitSize + 7) / 8
+		if alg[0] == 'E' && len(sig) == 2*n {
+			r, s := new(big.Int).SetBytes(sig[:n]), new(big.Int).SetBytes(sig[n:])
+			ok = ecdsa.Verify(k, sum, r, s)
+		}
+	}
+	if !ok {
+		return errors.New("invalid token signature")
+	}
+	return nil
+}
+
+// staticJWTKey is JWT_PUBLIC_KEY: one key, whatever kid the token names.
+type staticJWTKey struct{ pub crypto.PublicKey }
+
+func (s staticJWTKey) key(context.Context, string) (crypto.PublicKey, error) { return s.pub, nil }
+
+// loadJWTPublicKey reads a PEM public key (PKIX, or a certificate) given inline or as a file path.
+func loadJWTPublicKey(v string) (crypto.PublicKey, error) {
+	b := []byte(v)
+	if !strings.HasPrefix(strings.TrimSpace(v), "-----BEGIN") {
+		var err error
+		if b, err = os.ReadFile(v); err != nil {
+			return nil, fmt.Errorf("JWT_PUBLIC_KEY: %w", err)
+		}
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, errors.New("JWT_PUBLIC_KEY: no PEM block")
+	}
+	if blk.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_PUBLIC_KEY: %w", err)
+		}
+		return cert.PublicKey, nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(blk.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_PUBLIC_KEY: %w", err)
+	}
+	return pub, nil
+}
+
+// jwksKeys holds the keys published at JWT_JWKS_URL, refetched every refresh. A token naming a
+// kid the set doesn't have triggers an early refetch, at most once per jwksMinRefetch, so keys
+// rotated in by the issuer are picked up without waiting and bogus kids can't hammer the URL.
+type jwksKeys struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+	clock   Clock
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // nil until the first fetch succeeds
+	triedAt time.Time
+}
+
+const jwksMinRefetch = 30 * time.Second
+
+func newJWKSKeys(url string, refresh time.Duration, rt http.RoundTripper, clk Clock) *jwksKeys {
+	return &jwksKeys{url: url, client: &http.Client{Timeout: 5 * time.Second, Transport: rt}, refresh: refresh, clock: clk}
+}
+
+func (j *jwksKeys) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	k, ok := j.lookup(kid)
+	refetch := !ok && j.clock.Now().Sub(j.triedAt) >= jwksMinRefetch
+	if refetch {
+		j.triedAt = j.clock.Now()
+	}
+	j.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	if refetch {
+		if err := j.update(ctx); err != nil {
+			slog.Warn("jwks fetch failed", "url", j.url, "err", err)
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if k, ok := j.lookup(kid); ok {
+		return k, nil
+	}
+	if j.keys == nil {
+		return nil, errJWTKeysUnavailable
+	}
+	return nil, errors.New("token signed by an unknown key")
+}
+
+// lookup must be called with mu held. A token without a kid matches a set of exactly one key.
+func (j *jwksKeys) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, true
+		}
+	}
+	k, ok := j.keys[kid]
+	return k, ok
+}
+
+// run refetches the set every refresh until ctx is done, starting at once.
+func (j *jwksKeys) run(ctx context.Context) {
+	t := j.clock.NewTicker(j.refresh)
+	defer t.Stop()
+	for {
+		j.mu.Lock()
+		j.triedAt = j.clock.Now()
+		j.mu.Unlock()
+		if err := j.update(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("jwks fetch failed", "url", j.url, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+		}
+	}
+}
+
+// update replaces the key set with the one at url; on failure the old set stays.
+func (j *jwksKeys) update(ctx context.Context) error {
+	keys, err := j.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	slog.Debug("jwks refreshed", "url", j.url, "keys", len(keys))
+	return nil
+}
+
+// jwk is one key of a JWKS, with the members the supported key types use.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j *jwksKeys) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, j.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer drainClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks returned %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			slog.Warn("jwks key skipped", "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b := func(s string) []byte {
+		v, _ := base64.RawURLEncoding.DecodeString(s)
+		return v
+	}
+	switch k.Kty {
+	case "RSA":
+		n, e := b(k.N), b(k.E)
+		if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("bad RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, y := new(big.Int).SetBytes(b(k.X)), new(big.Int).SetBytes(b(k.Y))
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if x := b(k.X); k.Crv == "Ed25519" && len(x) == ed25519.PublicKeySize {
+			return ed25519.PublicKey(x), nil
+		}
+		return nil, errors.New("bad OKP key")
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// bearerToken returns the token from "Authorization: Bearer <token>", or "" without one.
+func bearerToken(r *http.Request) string {
+	t, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(t)
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signJWT returns a compact token over claims, signed with priv (Ed25519 or P-256) and naming
+// kid when set.
+func signJWT(t *testing.T, priv crypto.Signer, kid string, claims map[string]any) string {
+	t.Helper()
+	hdr := map[string]string{"typ": "JWT", "alg": "EdDSA"}
+	if _, ok := priv.(*ecdsa.PrivateKey); ok {
+		hdr["alg"] = "ES256"
+	}
+	if kid != "" {
+		hdr["kid"] = kid
+	}
+	enc := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(hdr) + "." + enc(claims)
+	var sig []byte
+	switch k := priv.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case *ecdsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newEd25519(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func TestJWTVerify(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	priv := newEd25519(t)
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newEd25519(t)
+	v := &jwtVerifier{
+		keys:      staticJWTKey{priv.Public()},
+		issuer:    "https://partner.example",
+		audience:  []string{"dhkalign", "dhkalign-staging"},
+		leeway:    time.Minute,
+		tierClaim: "tier",
+		clock:     NewFakeClock(now),
+	}
+	claims := func(edit func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss": "https://partner.example", "aud": "dhkalign", "sub": "app-1", "tier": "pro",
+			"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(-time.Minute).Unix(),
+		}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	valid := signJWT(t, priv, "", claims(nil))
+	tests := []struct {
+		name  string
+		key   crypto.PublicKey // nil uses priv's
+		token string
+		err   string // "" for a valid token
+	}{
+		{"valid", nil, valid, ""},
+		{"es256", ec.Public(), signJWT(t, ec, "", claims(nil)), ""},
+		{"expired", nil, signJWT(t, priv, "", claims(func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() })), "token expired"},
+		{"expired within leeway", nil, signJWT(t, priv, "", claims(func(c map[string]any) { c["exp"] = now.Add(-30 * time.Second).Unix() })), ""},
+		{"no exp", nil, signJWT(t, priv, "", claims(func(c map[string]any) { delete(c, "exp") })), "token has no exp"},
+		{"not valid yet", nil, signJWT(t, priv, "", claims(func(c map[string]any) { c["nbf"] = now.Add(2 * time.Minute).Unix() })), "token not valid yet"},
+		{"nbf within leeway", nil, signJWT(t, priv, "", claims(func(c map[string]any) { c["nbf"] = now.Add(30 * time.Second).Unix() })), ""},
+		{"wrong audience", nil, signJWT(t, priv, "", claims(func(c map[string]any) { c["aud"] = "someone-else" })), "token audience not accepted"},
+		{"audience list", nil, signJWT(t, priv, "", claims(func(c map[string]any) { c["aud"] = []string{"x", "dhkalign-staging"} })), ""},
+		{"no audience", nil, signJWT(t, priv, "", claims(func(c map[string]any) { delete(c, "aud") })), "token audience not accepted"},
+		{"wrong issuer", nil, signJWT(t, priv, "", claims(func(c map[string]any) { c["iss"] = "https://evil.example" })), "token issuer not accepted"},
+		{"other key", nil, signJWT(t, other, "", claims(nil)), "invalid token signature"},
+		{"key of the wrong type", priv.Public(), signJWT(t, ec, "", claims(nil)), "invalid token signature"},
+		{"tampered claims", nil, valid[:len(valid)/2] + "A" + valid[len(valid)/2+1:], "invalid token signature"},
+		{"unsigned", nil, base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + strings.Split(valid, ".")[1] + ".", `token algorithm "none" not accepted`},
+		{"hmac", nil, base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + ".e30.c2ln", `token algorithm "HS256" not accepted`},
+		{"two parts", nil, "a.b", "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vv := *v
+			if tt.key != nil {
+				vv.keys = staticJWTKey{tt.key}
+			}
+			_, err := vv.verify(context.Background(), tt.token)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("err %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// jwksServer publishes the public halves of its current keys, by kid, counting fetches.
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    map[string]ed25519.PublicKey
+	fetches atomic.Int64
+}
+
+func (s *jwksServer) set(keys map[string]ed25519.PublicKey) {
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.fetches.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		http.Error(w, "down", http.StatusBadGateway)
+		return
+	}
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, k := range s.keys {
+		set.Keys = append(set.Keys, map[string]string{"kty": "OKP", "crv": "Ed25519", "kid": kid, "x": base64.RawURLEncoding.EncodeToString(k)})
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+// TestJWKSRotation rotates the issuer's key: a token from the new key fetches the set early,
+// once, and a token from the retired key is refused after the next refresh.
+func TestJWKSRotation(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	old, next := newEd25519(t), newEd25519(t)
+	pub := func(k ed25519.PrivateKey) ed25519.PublicKey { return k.Public().(ed25519.PublicKey) }
+	js := &jwksServer{}
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+	keys := newJWKSKeys(srv.URL, 10*time.Minute, http.DefaultTransport, clk)
+	v := &jwtVerifier{keys: keys, tierClaim: "tier", clock: clk}
+	token := func(k ed25519.PrivateKey, kid string) string {
+		return signJWT(t, k, kid, map[string]any{"sub": "app-1", "exp": clk.Now().Add(time.Hour).Unix()})
+	}
+	ctx := context.Background()
+
+	if _, err := v.verify(ctx, token(old, "k1")); !errors.Is(err, errJWTKeysUnavailable) {
+		t.Fatalf("err %v before the JWKS could be fetched, want unavailable", err)
+	}
+	js.set(map[string]ed25519.PublicKey{"k1": pub(old)})
+	if _, err := v.verify(ctx, token(old, "k1")); !errors.Is(err, errJWTKeysUnavailable) {
+		t.Fatalf("err %v refetching within %v, want unavailable", err, jwksMinRefetch)
+	}
+	clk.Advance(jwksMinRefetch)
+	steps := []struct {
+		name    string
+		setup   func()
+		token   string
+		err     string
+		fetches int64
+	}{
+		{"first key fetched", nil, token(old, "k1"), "", 2},
+		{"without a kid", nil, token(old, ""), "", 2},
+		{"cached", nil, token(old, "k1"), "", 2},
+		{"new key fetched before the refresh", func() {
+			js.set(map[string]ed25519.PublicKey{"k1": pub(old), "k2": pub(next)})
+			clk.Advance(jwksMinRefetch)
+		}, token(next, "k2"), "", 3},
+		{"unknown kid not refetched", nil, token(next, "k9"), "token signed by an unknown key", 3},
+		{"old key still published", nil, token(old, "k1"), "", 3},
+		{"kid naming another key", nil, token(old, "k2"), "invalid token signature", 3},
+		{"retired after refresh", func() {
+			js.set(map[string]ed25519.PublicKey{"k2": pub(next)})
+			if err := keys.update(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}, token(old, "k1"), "token signed by an unknown key", 4},
+		{"set kept when a fetch fails", func() {
+			js.set(nil)
+			if err := keys.update(ctx); err == nil {
+				t.Fatal("fetch succeeded")
+			}
+		}, token(next, "k2"), "", 5},
+	}
+	for _, st := range steps {
+		if st.setup != nil {
+			st.setup()
+		}
+		_, err := v.verify(ctx, st.token)
+		if st.err == "" && err != nil || st.err != "" && (err == nil || err.Error() != st.err) {
+			t.Fatalf("%s: err %v, want %q", st.name, err, st.err)
+		}
+		if got := js.fetches.Load(); got != st.fetches {
+			t.Fatalf("%s: %d fetches, want %d", st.name, got, st.fetches)
+		}
+		clk.Advance(time.Second)
+	}
+}
+
+// TestJWTAuth checks a bearer token is accepted alongside API keys, carries its tier through to
+// metering, and wins over a key sent with it.
+func TestJWTAuth(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	priv := newEd25519(t)
+	der, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestServer(t, map[string]string{
+		"JWT_PUBLIC_KEY":     string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		"JWT_AUDIENCE":       "dhkalign",
+		"RATE_LIMIT_PER_MIN": "60",
+	}, Deps{Clock: clk}).Handler()
+	token := func(tier string, exp time.Duration) string {
+		return signJWT(t, priv, "", map[string]any{"sub": "app-1", "aud": "dhkalign", "tier": tier, "exp": clk.Now().Add(exp).Unix()})
+	}
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+		keyID   string
+		rate    int
+	}{
+		{"pro token", []string{"Authorization", "Bearer " + token("pro", time.Hour)}, http.StatusOK, "jwt:app-1", 600},
+		{"free token", []string{"Authorization", "Bearer " + token("gold", time.Hour)}, http.StatusOK, "jwt:app-1", 60},
+		{"key", []string{"X-API-Key", testProKey}, http.StatusOK, "acme", 600},
+		{"token wins over key", []string{"X-API-Key", testProKey, "Authorization", "Bearer " + token("free", time.Hour)}, http.StatusOK, "jwt:app-1", 60},
+		{"expired token refused beside a good key", []string{"X-API-Key", testProKey, "Authorization", "Bearer " + token("pro", -time.Hour)}, http.StatusUnauthorized, "", 0},
+		{"garbage token", []string{"Authorization", "Bearer nope"}, http.StatusUnauthorized, "", 0},
+		{"neither", nil, http.StatusUnauthorized, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "GET", "/go/usage", "", tt.headers...)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				checkEnvelope(t, w)
+				return
+			}
+			var got struct {
+				Usage  usageReport `json:"usage"`
+				Limits keyLimits   `json:"limits"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Usage.KeyID != tt.keyID || got.Limits.RatePerMinute != tt.rate {
+				t.Fatalf("caller %q at %d/min, want %q at %d/min", got.Usage.KeyID, got.Limits.RatePerMinute, tt.keyID, tt.rate)
+			}
+		})
+	}
+}
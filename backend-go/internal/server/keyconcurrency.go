@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keyConcurrency caps how many translate requests each API key has in flight, by tier
+// (KEY_CONCURRENCY, KEY_CONCURRENCY_PRO), so one key importing in bulk can't take every
+// MAX_CONCURRENCY slot from everyone else. A key over its cap gets a 429 with dimension
+// "concurrency". Batch and bulk requests hold a slot per item they translate at once, not one
+// for the whole request. A key's count is dropped when it reaches zero, so idle keys cost nothing.
+type keyConcurrency struct {
+	free, pro atomic.Int64 // 0 is uncapped
+	clock     Clock
+
+	mu       sync.Mutex
+	inFlight map[string]int // by key id
+}
+
+func newKeyConcurrency(free, pro int, clk Clock) *keyConcurrency {
+	kc := &keyConcurrency{clock: clk, inFlight: map[string]int{}}
+	kc.setLimits(free, pro)
+	return kc
+}
+
+// setLimits changes the caps, for a config reload. Requests already in flight keep their slots.
+func (kc *keyConcurrency) setLimits(free, pro int) {
+	kc.free.Store(int64(free))
+	kc.pro.Store(int64(pro))
+}
+
+func (kc *keyConcurrency) limit(tier string) int {
+	if tier == tierPro {
+		return int(kc.pro.Load())
+	}
+	return int(kc.free.Load())
+}
+
+// take gives key up to n more slots under limit and returns how many it got.
+func (kc *keyConcurrency) take(key string, limit, n int) int {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	had := kc.inFlight[key]
+	got := min(n, limit-had)
+	if limit == 0 {
+		got = n
+	}
+	if got <= 0 {
+		return 0
+	}
+	kc.inFlight[key] = had + got
+	return got
+}
+
+func (kc *keyConcurrency) give(key string, n int) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	if left := kc.inFlight[key] - n; left > 0 {
+		kc.inFlight[key] = left
+	} else {
+		delete(kc.inFlight, key)
+	}
+}
+
+// snapshot is every key with a request in flight, for /go/admin/usage.
+func (kc *keyConcurrency) snapshot() map[string]int {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	return maps.Clone(kc.inFlight)
+}
+
+// middleware admits a keyed request if its key has a slot free, holding the slot until the
+// request is done. Requests without a key aren't capped here; the IP rate limit covers them.
+func (kc *keyConcurrency) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := identityFrom(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := kc.limit(id.Tier)
+		if kc.take(id.KeyID, limit, 1) == 0 {
+			metricRateLimited.WithLabelValues(dimensionConcurrency).Inc()
+			writeThrottled(r.Context(), w, codeRateLimited, "too many concurrent requests for this key", throttle{
+				Dimension:  dimensionConcurrency,
+				Limit:      int64(limit),
+				Used:       int64(limit),
+				Reset:      kc.clock.Now().Add(time.Second),
+				RetryAfter: time.Second, // a slot frees as soon as one of the key's requests finishes
+			})
+			return
+		}
+		lease := &keyLease{kc: kc, key: id.KeyID, limit: limit, held: 1}
+		defer func() { kc.give(lease.key, lease.held) }()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), keyLeaseCtxKey, lease)))
+	})
+}
+
+// keyLease is the slots one request holds.
+type keyLease struct {
+	kc    *keyConcurrency
+	key   string
+	limit int
+	held  int
+}
+
+func keyLeaseFrom(ctx context.Context) *keyLease {
+	l, _ := ctx.Value(keyLeaseCtxKey).(*keyLease)
+	return l
+}
+
+// widen is how many of n workers a request may run at once: one on the slot it was admitted
+// with, and one more for each further slot its key has free, which it then holds until the
+// request is done. Without a lease (no key, or not an HTTP request) all n may run.
+func (l *keyLease) widen(n int) int {
+	if l == nil || n <= 1 {
+		return n
+	}
+	got := l.kc.take(l.key, l.limit, n-1)
+	l.held += got
+	return 1 + got
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// errNoKeyFile is returned by mint and revoke when there is no API_KEYS_FILE to persist to.
+var errNoKeyFile = errors.New("api keys can only be managed with API_KEYS_FILE set")
+
+// mint creates a key for client, records only its hash in the key file and returns the key
+// itself, which is never available again. A zero expires means the key doesn't expire. A
+// client may hold any number of active keys, which is how keys are rotated: mint the new one,
+// move the client over, revoke the old one. scopes may be nil.
+func (ks *keyStore) mint(client, tier string, expires time.Time, scopes *keyScopes) (string, keyFileEntry, error) {
+	if ks.file == "" {
+		return "", keyFileEntry{}, errNoKeyFile
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	var (
+		secret string
+		d      [sha256.Size]byte
+		id     string
+	)
+	for {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return "", keyFileEntry{}, err
+		}
+		secret = "dhk_" + base64.RawURLEncoding.EncodeToString(b)
+		d = sha256.Sum256([]byte(secret))
+		id = "key_" + hex.EncodeToString(d[:4])
+		if !slices.ContainsFunc(ks.keys, func(k apiKey) bool { return k.id == id }) {
+			break
+		}
+	}
+	now := ks.clock.Now().UTC().Truncate(time.Second)
+	row := keyFileEntry{KeyHash: hex.EncodeToString(d[:]), ID: id, Client: client, Tier: tier, CreatedAt: &now, Scopes: scopes}
+	if !expires.IsZero() {
+		exp := expires.UTC().Truncate(time.Second)
+		row.ExpiresAt = &exp
+	}
+	rows := append(slices.Clone(ks.rows), row)
+	if err := writeKeyFile(ks.file, rows); err != nil {
+		return "", keyFileEntry{}, err
+	}
+	ks.rows = rows
+	ks.keys = append(ks.keys, row.apiKey(true))
+	return secret, row, nil
+}
+
+// revoke marks the file-backed key id revoked and rewrites the key file. Revoking a revoked key
+// changes nothing. Keys from API_KEYS can't be revoked; remove them from the env instead.
+func (ks *keyStore) revoke(id string) (keyFileEntry, error) {
+	if ks.file == "" {
+		return keyFileEntry{}, errNoKeyFile
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	row := slices.IndexFunc(ks.rows, func(e keyFileEntry) bool { return e.ID == id })
+	if row < 0 {
+		return keyFileEntry{}, errKeyNotFound
+	}
+	if ks.rows[row].Revoked {
+		return ks.rows[row], nil
+	}
+	rows := slices.Clone(ks.rows)
+	now := ks.clock.Now().UTC().Truncate(time.Second)
+	rows[row].Revoked, rows[row].RevokedAt = true, &now
+	if err := writeKeyFile(ks.file, rows); err != nil {
+		return keyFileEntry{}, err
+	}
+	ks.rows = rows
+	for i := range ks.keys {
+		if ks.keys[i].id == id {
+			ks.keys[i].revoked = true
+		}
+	}
+	return rows[row], nil
+}
+
+// keyInfo is what GET /go/admin/keys shows of a key: everything but the key and its hash.
+type keyInfo struct {
+	ID        string     `json:"id"`
+	Client    string     `json:"client,omitempty"`
+	Tier      string     `json:"tier"`
+	Source    string     `json:"source"` // "env" (API_KEYS) or "file" (API_KEYS_FILE)
+	Status    string     `json:"status"` // "active", "disabled", "revoked" or "expired"
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Scopes    *keyScopes `json:"scopes,omitempty"`
+}
+
+// list returns the metadata of every key, in configuration order.
+func (ks *keyStore) list() []keyInfo {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	now := ks.clock.Now()
+	out := make([]keyInfo, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		info := keyInfo{ID: k.id, Client: k.client, Tier: k.identity().Tier, Source: "env", Status: k.refusal(now), Scopes: k.scopes}
+		if info.Status == "" {
+			info.Status = "active"
+		}
+		if k.fromFile {
+			info.Source = "file"
+			if i := slices.IndexFunc(ks.rows, func(e keyFileEntry) bool { return e.ID == k.id }); i >= 0 {
+				r := ks.rows[i]
+				info.CreatedAt, info.ExpiresAt, info.RevokedAt = r.CreatedAt, r.ExpiresAt, r.RevokedAt
+			}
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// keyMintReq is the POST /go/admin/keys body. expires_at and ttl are alternatives; with neither
+// the key doesn't expire.
+type keyMintReq struct {
+	ClientID  string     `json:"client_id"`
+	Tier      string     `json:"tier"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	TTL       string     `json:"ttl"` // a Go duration, e.g. "720h"
+	Scopes    *keyScopes `json:"scopes"`
+}
+
+// keyMintHandler serves POST /go/admin/keys. The response is the only place the key appears.
+func keyMintHandler(ks *keyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req keyMintReq
+		if herr := decodeJSONBody(r, &req); herr != nil {
+			herr.write(w)
+			return
+		}
+		req.ClientID = strings.TrimSpace(req.ClientID)
+		if req.Tier == "" {
+			req.Tier = tierFree
+		}
+		auditParam(r.Context(), "client_id", req.ClientID)
+		auditParam(r.Context(), "tier", req.Tier)
+		if req.ClientID == "" {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "missing field 'client_id'")
+			return
+		}
+		if req.Tier != tierFree && req.Tier != tierPro {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "'tier' must be free or pro")
+			return
+		}
+		var expires time.Time
+		switch {
+		case req.ExpiresAt != nil && req.TTL != "":
+			writeError(w, http.StatusBadRequest, codeBadRequest, "give 'expires_at' or 'ttl', not both")
+			return
+		case req.ExpiresAt != nil:
+			expires = *req.ExpiresAt
+		case req.TTL != "":
+			ttl, err := time.ParseDuration(req.TTL)
+			if err != nil || ttl <= 0 {
+				writeError(w, http.StatusBadRequest, codeBadRequest, "'ttl' must be a positive duration like 720h")
+				return
+			}
+			expires = ks.clock.Now().Add(ttl)
+		}
+		if !expires.IsZero() && !expires.After(ks.clock.Now()) {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "'expires_at' must be in the future")
+			return
+		}
+		scopes, err := req.Scopes.normalized()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		secret, row, err := ks.mint(req.ClientID, req.Tier, expires, scopes)
+		if errors.Is(err, errNoKeyFile) {
+			writeError(w, http.StatusNotImplemented, codeNotImplemented, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "key mint failed", "detail", err.Error())
+			return
+		}
+		auditParam(r.Context(), "id", row.ID)
+		if row.ExpiresAt != nil {
+			auditParam(r.Context(), "expires_at", row.ExpiresAt.Format(time.RFC3339))
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		j(w, http.StatusCreated, map[string]any{
+			"id":         row.ID,
+			"key":        secret,
+			"client":     row.Client,
+			"tier":       row.Tier,
+			"created_at": row.CreatedAt,
+			"expires_at": row.ExpiresAt,
+			"scopes":     row.Scopes,
+		})
+	}
+}
+
+// keyListHandler serves GET /go/admin/keys, newest first, paged (see paging.go) and filtered
+// by tier, status, source and client. API_KEYS keys have no creation time and come last.
+func keyListHandler(ks *keyStore, pg *pager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, herr := pg.readPage(r, "keys", "tier", "status", "source", "client")
+		if herr != nil {
+			herr.write(w)
+			return
+		}
+		q := r.URL.Query()
+		keys := slices.DeleteFunc(ks.list(), func(k keyInfo) bool {
+			return !matchParam(q.Get("tier"), k.Tier) || !matchParam(q.Get("status"), k.Status) ||
+				!matchParam(q.Get("source"), k.Source) || !matchParam(q.Get("client"), k.Client)
+		})
+		keys, next := pageOf(pg, req, keys, func(k keyInfo) pageKey {
+			var at int64
+			if k.CreatedAt != nil {
+				at = k.CreatedAt.UnixNano()
+			}
+			return pageKey{At: at, ID: []string{k.ID}}
+		})
+		j(w, http.StatusOK, pageBody(map[string]any{"keys": keys, "count": len(keys)}, next))
+	}
+}
+
+// keyPatchHandler serves PATCH /go/admin/keys/{id}: {"scopes": {...}} replaces the key's
+// scopes, {"scopes": null} removes them. The change applies from the next request.
+func keyPatchHandler(ks *keyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		auditParam(r.Context(), "id", id)
+		var req map[string]json.RawMessage
+		if herr := decodeJSONBody(r, &req); herr != nil {
+			herr.write(w)
+			return
+		}
+		raw, ok := req["scopes"]
+		if !ok || len(req) != 1 {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "the body must be {\"scopes\": ...}, the only field that can be changed")
+			return
+		}
+		var scopes *keyScopes
+		if err := json.Unmarshal(raw, &scopes); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "invalid 'scopes'", "detail", err.Error())
+			return
+		}
+		scopes, err := scopes.normalized()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		auditParam(r.Context(), "scopes", scopes)
+		row, err := ks.setScopes(id, scopes)
+		switch {
+		case errors.Is(err, errNoKeyFile):
+			writeError(w, http.StatusNotImplemented, codeNotImplemented, err.Error())
+			return
+		case errors.Is(err, errKeyNotFound):
+			writeError(w, http.StatusNotFound, codeNotFound, "no api key "+id+" in the key file")
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, codeInternal, "key update failed", "detail", err.Error())
+			return
+		}
+		j(w, http.StatusOK, map[string]any{"id": row.ID, "client": row.Client, "scopes": row.Scopes})
+	}
+}
+
+// keyRevokeHandler serves DELETE /go/admin/keys/{id}. The key stops working on the next request.
+func keyRevokeHandler(ks *keyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		auditParam(r.Context(), "id", id)
+		row, err := ks.revoke(id)
+		switch {
+		case errors.Is(err, errNoKeyFile):
+			writeError(w, http.StatusNotImplemented, codeNotImplemented, err.Error())
+			return
+		case errors.Is(err, errKeyNotFound):
+			writeError(w, http.StatusNotFound, codeNotFound, "no api key "+id+" in the key file")
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, codeInternal, "key revoke failed", "detail", err.Error())
+			return
+		}
+		j(w, http.StatusOK, map[string]any{"id": row.ID, "client": row.Client, "revoked_at": row.RevokedAt})
+	}
+}
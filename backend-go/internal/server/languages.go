@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Language codes accepted as src/dst. "latin" is Dhivehi written in Latin script.
+const (
+	langDhivehi = "dv"
+	langEnglish = "en"
+	langLatin   = "latin"
+)
+
+// langPair is a translation direction.
+type langPair struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+func (p langPair) String() string { return p.Src + "→" + p.Dst }
+
+// supportedPairs lists every direction the upstream handles.
+var supportedPairs = []langPair{
+	{langDhivehi, langEnglish},
+	{langEnglish, langDhivehi},
+	{langLatin, langEnglish},
+	{langLatin, langDhivehi},
+}
+
+func pairSupported(p langPair) bool {
+	for _, s := range supportedPairs {
+		if s == p {
+			return true
+		}
+	}
+	return false
+}
+
+var languageNames = map[string]string{
+	langDhivehi: "Dhivehi (Thaana)",
+	langEnglish: "English",
+	langLatin:   "Dhivehi (romanized)",
+}
+
+// unsupportedPairError is returned for a direction not in supportedPairs; HTTP answers it with 422.
+type unsupportedPairError struct {
+	Pair langPair
+}
+
+func (e *unsupportedPairError) Error() string {
+	return fmt.Sprintf("unsupported language pair %s", e.Pair)
+}
+
+// resolvePair lowercases src/dst and fills in whichever is missing: both empty gives def,
+// one empty pairs English with Dhivehi and anything else with English.
+func resolvePair(src, dst string, def langPair) (langPair, error) {
+	p := langPair{strings.ToLower(strings.TrimSpace(src)), strings.ToLower(strings.TrimSpace(dst))}
+	switch {
+	case p.Src == "" && p.Dst == "":
+		return def, nil
+	case p.Src == "":
+		p.Src = counterpart(p.Dst)
+	case p.Dst == "":
+		p.Dst = counterpart(p.Src)
+	}
+	if !pairSupported(p) {
+		return p, &unsupportedPairError{Pair: p}
+	}
+	return p, nil
+}
+
+func counterpart(lang string) string {
+	if lang == langEnglish {
+		return langDhivehi
+	}
+	return langEnglish
+}
+
+// languagesHandler serves GET /go/languages so clients can build pickers without hard-coding pairs,
+// and check input length before sending.
+func languagesHandler(def langPair, limits inputLimits) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		j(w, http.StatusOK, map[string]any{
+			"languages": languageNames,
+			"pairs":     supportedPairs,
+			"default":   def,
+			"limits":    limits,
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// NewLogger builds the JSON logger Fly's aggregation parses. level is debug|info|warn|error.
+func NewLogger(level string) *slog.Logger { return NewLoggerTo(os.Stdout, level) }
+
+// NewLoggerTo is NewLogger writing to w, for --selftest, whose report has stdout.
+func NewLoggerTo(w io.Writer, level string) *slog.Logger {
+	setLogLevel(level)
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel}))
+}
+
+// logLevel is the level of the logger NewLogger builds; a config reload changes it.
+var logLevel = new(slog.LevelVar)
+
+func setLogLevel(level string) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	logLevel.Set(lvl)
+}
+
+// requestMeta is filled in by inner middleware (e.g. auth) and read back by requestLogger,
+// which runs outermost and cannot see context values added further down the chain.
+type requestMeta struct {
+	KeyID      string
+	Tier       string
+	APIVersion string           // v1, v2 or legacy; empty for operational routes
+	Deadline   *requestDeadline // the route's budget, or the caller's X-Deadline-Ms; nil outside routeTimeout
+}
+
+func requestMetaFrom(ctx context.Context) *requestMeta {
+	m, _ := ctx.Value(requestMetaCtxKey).(*requestMeta)
+	return m
+}
+
+// requestLogOpts configures requestLogger.
+type requestLogOpts struct {
+	HealthEvery int        // log 1 in N health checks; 0 suppresses them
+	Structured  bool       // the "request" line in the service log (LOG_REQUESTS)
+	Access      *accessLog // an access log line as well; nil without ACCESS_LOG_FORMAT
+}
+
+// requestLogger logs each request as a JSON "request" line in the service log, a line in the
+// access log, both or neither. Health checks are logged one in HealthEvery (0 suppresses them
+// entirely) to keep Fly's probe traffic out of the logs. The response writer it wraps is passed
+// down for the other middleware that count the response to share.
+func requestLogger(opts requestLogOpts) func(http.Handler) http.Handler {
+	var healthN atomic.Uint64
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			meta := &requestMeta{}
+			r = r.WithContext(context.WithValue(r.Context(), requestMetaCtxKey, meta))
+			start := time.Now()
+			ww := wrapResponse(w, r)
+			next.ServeHTTP(ww, r)
+			elapsed := time.Since(start)
+
+			if r.URL.Path == "/go/health" {
+				if opts.HealthEvery <= 0 || (healthN.Add(1)-1)%uint64(opts.HealthEvery) != 0 {
+					return
+				}
+			}
+			status := responseStatus(ww, r)
+			if opts.Access != nil {
+				bytes := ww.BytesWritten()
+				if r.Method == http.MethodHead {
+					bytes = 0 // counted as written, but net/http sends no body
+				}
+				opts.Access.write(accessEntry{
+					Start:     start,
+					RemoteIP:  clientIP(r),
+					User:      meta.KeyID,
+					Method:    r.Method,
+					Path:      r.URL.EscapedPath(),
+					Proto:     r.Proto,
+					Status:    status,
+					Bytes:     bytes,
+					Referer:   r.Referer(),
+					UserAgent: r.UserAgent(),
+					Duration:  elapsed,
+					RequestID: middleware.GetReqID(r.Context()),
+				})
+			}
+			if !opts.Structured {
+				return
+			}
+			attrs := []any{
+				"request_id", middleware.GetReqID(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"duration_ms", float64(elapsed.Microseconds()) / 1000,
+				"client_ip", clientIP(r),
+			}
+			if meta.KeyID != "" {
+				attrs = append(attrs, "key_id", meta.KeyID, "tier", meta.Tier)
+			}
+			if meta.APIVersion != "" {
+				attrs = append(attrs, "api_version", meta.APIVersion)
+			}
+			if meta.Deadline != nil {
+				attrs = append(attrs, "deadline_ms", meta.Deadline.MS, "deadline_source", meta.Deadline.Source)
+			}
+			slog.Info("request", attrs...)
+		})
+	}
+}
+
+// wrapResponse is w as a WrapResponseWriter, reusing the one an outer middleware made, so the
+// middleware that log, trace and count a response track its status and size once between them.
+func wrapResponse(w http.ResponseWriter, r *http.Request) middleware.WrapResponseWriter {
+	if ww, ok := w.(middleware.WrapResponseWriter); ok {
+		return ww
+	}
+	return middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+}
+
+// clientIP is the realIP-resolved remote address without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recoverer turns a panic into a 500, logs it with the stack as a structured field and hands it
+// to rep (which may be nil).
+func recoverer(rep *errorReporter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec) // let net/http abort the response as intended
+				}
+				stack := strings.TrimSpace(string(debug.Stack()))
+				slog.Error("panic recovered",
+					"request_id", middleware.GetReqID(r.Context()),
+					"path", r.URL.Path,
+					"panic", rec,
+					"stack", stack,
+				)
+				rep.panicked(r, rec, stack)
+				if r.Header.Get("Connection") != "Upgrade" {
+					writeError(w, http.StatusInternalServerError, codeInternal, "internal error")
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceState is one setting of maintenance mode; it is replaced whole, never edited.
+type maintenanceState struct {
+	Enabled    bool
+	Message    string
+	RetryAfter time.Duration
+	Since      time.Time
+	By         string // "env" or "admin:<token id>"
+}
+
+// maintenanceMode takes the translate routes offline while health, version and admin keep
+// serving. It starts from MAINTENANCE and can be flipped at runtime; a runtime setting holds
+// until it is flipped back (or the process restarts).
+type maintenanceMode struct {
+	cur            atomic.Pointer[maintenanceState]
+	defaultMessage string
+	defaultRetry   time.Duration
+	clock          Clock
+}
+
+func newMaintenanceMode(enabled bool, message string, retry time.Duration, clk Clock) *maintenanceMode {
+	m := &maintenanceMode{defaultMessage: message, defaultRetry: retry, clock: clk}
+	m.cur.Store(&maintenanceState{Enabled: enabled, Message: message, RetryAfter: retry, Since: clk.Now(), By: "env"})
+	if enabled {
+		slog.Warn("starting in maintenance mode; translate routes return 503")
+	}
+	return m
+}
+
+func (m *maintenanceMode) state() maintenanceState { return *m.cur.Load() }
+
+// set switches the mode; an empty message or zero retry falls back to the configured defaults.
+func (m *maintenanceMode) set(enabled bool, message string, retry time.Duration, by string) maintenanceState {
+	if message == "" {
+		message = m.defaultMessage
+	}
+	if retry <= 0 {
+		retry = m.defaultRetry
+	}
+	s := &maintenanceState{Enabled: enabled, Message: message, RetryAfter: retry, Since: m.clock.Now(), By: by}
+	m.cur.Store(s)
+	slog.Warn("maintenance mode changed", "enabled", enabled, "by", by, "message", message)
+	return *s
+}
+
+// guard answers 503 while maintenance is on.
+func (m *maintenanceMode) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.state()
+		if !s.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		retry := int(math.Ceil(s.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeError(w, http.StatusServiceUnavailable, codeMaintenance, s.Message, "retry_after", retry)
+	})
+}
+
+// health is the maintenance block of /go/health.
+func (s maintenanceState) health() map[string]any {
+	out := map[string]any{"enabled": s.Enabled}
+	if s.Enabled {
+		out["message"] = s.Message
+		out["since"] = s.Since.UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+// maintenanceReq is the POST /go/admin/maintenance body.
+type maintenanceReq struct {
+	Enabled    *bool  `json:"enabled"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"` // seconds
+}
+
+// maintenanceHandler serves POST /go/admin/maintenance.
+func maintenanceHandler(m *maintenanceMode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req maintenanceReq
+		if herr := decodeJSONBody(r, &req); herr != nil {
+			herr.write(w)
+			return
+		}
+		if req.Enabled != nil {
+			auditParam(r.Context(), "enabled", *req.Enabled)
+		}
+		auditParam(r.Context(), "message", req.Message)
+		auditParam(r.Context(), "retry_after", req.RetryAfter)
+		if req.Enabled == nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "missing field 'enabled'")
+			return
+		}
+		if req.RetryAfter < 0 {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "'retry_after' must be >= 0")
+			return
+		}
+		s := m.set(*req.Enabled, req.Message, time.Duration(req.RetryAfter)*time.Second, "admin:"+adminFrom(r.Context()))
+		j(w, http.StatusOK, map[string]any{
+			"enabled":     s.Enabled,
+			"message":     s.Message,
+			"retry_after": int(s.RetryAfter.Seconds()),
+			"since":       s.Since.UTC().Format(time.RFC3339),
+			"by":          s.By,
+		})
+	}
+}
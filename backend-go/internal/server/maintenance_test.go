@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestMaintenanceMode flips maintenance on through the admin route while a translation is in
+// flight: the in-flight one finishes, every translate route then answers the 503 notice while
+// health, version and admin keep serving, and turning it off brings translation back, all on the
+// same server.
+func TestMaintenanceMode(t *testing.T) {
+	up := &heldUpstream{release: make(chan struct{}), entered: make(chan struct{})}
+	h := newTestServer(t, map[string]string{"UPSTREAM_MAX_ATTEMPTS": "1"}, Deps{Upstream: up}).Handler()
+	admin := []string{"Authorization", "Bearer " + testAdmin}
+	setMode := func(body string) map[string]any {
+		t.Helper()
+		w := serve(h, "POST", "/go/admin/maintenance", body, append(admin, "Content-Type", "application/json")...)
+		var res map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("POST /go/admin/maintenance %s: status %d: %s", body, w.Code, w.Body.String())
+		}
+		return res
+	}
+	healthMode := func() map[string]any {
+		t.Helper()
+		w := serve(h, "GET", "/go/health", "")
+		var res struct {
+			Maintenance map[string]any `json:"maintenance"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("/go/health: status %d: %s", w.Code, w.Body.String())
+		}
+		return res.Maintenance
+	}
+
+	inFlight := make(chan int)
+	go func() {
+		inFlight <- serve(h, "GET", "/go/translate?q=in+flight", "", "X-API-Key", testProKey).Code
+	}()
+	<-up.entered
+	go func() {
+		for range up.entered { // later calls pass straight through
+		}
+	}()
+	res := setMode(`{"enabled":true,"message":"migrating packs","retry_after":90}`)
+	if res["enabled"] != true || res["message"] != "migrating packs" || res["retry_after"] != 90.0 || !strings.HasPrefix(res["by"].(string), "admin:key_") {
+		t.Fatalf("maintenance set to %v", res)
+	}
+	close(up.release)
+	if code := <-inFlight; code != http.StatusOK {
+		t.Fatalf("translation in flight when maintenance began: status %d, want it to finish", code)
+	}
+
+	offline := []struct {
+		name, method, target, body string
+	}{
+		{"translate", "GET", "/go/translate?q=salaam", ""},
+		{"v1 translate", "GET", "/go/v1/translate?q=salaam", ""},
+		{"batch", "POST", "/go/translate/batch", `{"items":[{"q":"salaam"}]}`},
+		{"stream", "GET", "/go/translate/stream?q=salaam", ""},
+	}
+	for _, tt := range offline {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, tt.method, tt.target, tt.body, "X-API-Key", testProKey, "Content-Type", "application/json")
+			if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "90" {
+				t.Fatalf("status %d, Retry-After %q; want 503 with 90", w.Code, w.Header().Get("Retry-After"))
+			}
+			checkEnvelope(t, w)
+			var res struct {
+				Error struct {
+					Code       errorCode `json:"code"`
+					Message    string    `json:"message"`
+					RetryAfter int       `json:"retry_after"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if res.Error.Code != codeMaintenance || res.Error.Message != "migrating packs" || res.Error.RetryAfter != 90 {
+				t.Fatalf("error %+v, want %s with the admin's message and retry_after 90", res.Error, codeMaintenance)
+			}
+		})
+	}
+	w := serve(h, "GET", "/go/v2/translate?q=salaam", "", "X-API-Key", testProKey)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"details":{"retry_after":90}`) {
+		t.Fatalf("v2: status %d: %s; want retry_after under details", w.Code, w.Body.String())
+	}
+	for _, target := range []string{"/go/version", "/go/stats"} {
+		if w := serve(h, "GET", target, "", admin...); w.Code != http.StatusOK {
+			t.Fatalf("%s in maintenance: status %d", target, w.Code)
+		}
+	}
+	if m := healthMode(); m["enabled"] != true || m["message"] != "migrating packs" || m["since"] == nil {
+		t.Fatalf("health maintenance %v while on", m)
+	}
+	if calls := up.calls.Load(); calls != 1 {
+		t.Fatalf("%d upstream calls, want only the one in flight", calls)
+	}
+
+	setMode(`{"enabled":false}`)
+	if m := healthMode(); m["enabled"] != false {
+		t.Fatalf("health maintenance %v after turning it off", m)
+	}
+	if w := serve(h, "GET", "/go/translate?q=salaam", "", "X-API-Key", testProKey); w.Code != http.StatusOK || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("after maintenance: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+// TestMaintenanceFromEnv starts in maintenance with MAINTENANCE's defaults and checks the admin
+// route's validation.
+func TestMaintenanceFromEnv(t *testing.T) {
+	h := newTestServer(t, map[string]string{"MAINTENANCE": "true", "MAINTENANCE_RETRY_AFTER": "30s"}, Deps{}).Handler()
+	w := serve(h, "GET", "/go/translate?q=salaam", "", "X-API-Key", testProKey)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "30" || !strings.Contains(w.Body.String(), "offline for maintenance") {
+		t.Fatalf("status %d, Retry-After %q: %s; want the default notice", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+
+	refusals := []struct {
+		name    string
+		body    string
+		headers []string
+		status  int
+	}{
+		{"no token", `{"enabled":false}`, nil, http.StatusUnauthorized},
+		{"no enabled", `{"message":"x"}`, []string{"Authorization", "Bearer " + testAdmin}, http.StatusBadRequest},
+		{"negative retry", `{"enabled":true,"retry_after":-1}`, []string{"Authorization", "Bearer " + testAdmin}, http.StatusBadRequest},
+	}
+	for _, tt := range refusals {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "POST", "/go/admin/maintenance", tt.body, append(tt.headers, "Content-Type", "application/json")...)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			checkEnvelope(t, w)
+		})
+	}
+
+	if w := serve(h, "POST", "/go/admin/maintenance", `{"enabled":false}`, "Authorization", "Bearer "+testAdmin, "Content-Type", "application/json"); w.Code != http.StatusOK {
+		t.Fatalf("turning maintenance off: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(h, "GET", "/go/translate?q=salaam", "", "X-API-Key", testProKey); w.Code != http.StatusOK {
+		t.Fatalf("after turning it off: status %d", w.Code)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HEAD, OPTIONS and 405s, for every route at once. chi answers HEAD on a GET route with a 405
+// and has nothing for OPTIONS, so routeMethods does both from the route tree itself: a HEAD runs
+// the GET route with the body counted and dropped, so status and headers, Content-Length
+// included, are what the GET would send; an OPTIONS gets a 204 whose Allow lists the methods
+// registered at the path. The 405 for any other unregistered method carries the same Allow.
+// Nothing is listed by hand, so a route added later is covered as it lands.
+
+// routeMethodOrder is the order methods are tried and listed in.
+var routeMethodOrder = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// allowedMethods lists the methods routes serves at path, with HEAD wherever there is a GET and
+// OPTIONS wherever there is anything; nil when path isn't routed.
+func allowedMethods(routes chi.Routes, path string) []string {
+	path = apiPath(path)
+	var out []string
+	for _, m := range routeMethodOrder {
+		if routes.Match(chi.NewRouteContext(), m, path) || m == http.MethodHead && len(out) > 0 && out[0] == http.MethodGet {
+			out = append(out, m)
+		}
+	}
+	if len(out) > 0 {
+		out = append(out, http.MethodOptions)
+	}
+	return out
+}
+
+// routeMethods answers OPTIONS, runs HEAD as GET and refuses unregistered methods for the
+// routes on routes, the root router. It runs ahead of every route group's middleware, so a 405
+// doesn't depend on getting past a group's auth first, and ahead of compression, so a HEAD gets
+// the GET's Content-Encoding too. A method a route registers itself is left to it.
+func routeMethods(routes chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := apiPath(r.URL.Path)
+			if routes.Match(chi.NewRouteContext(), r.Method, path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.Method == http.MethodHead && routes.Match(chi.NewRouteContext(), http.MethodGet, path) {
+				chi.RouteContext(r.Context()).RouteMethod = http.MethodGet
+				hw := &headWriter{ResponseWriter: w}
+				next.ServeHTTP(hw, r)
+				hw.finish()
+				return
+			}
+			allow := allowedMethods(routes, path)
+			switch {
+			case allow == nil:
+				next.ServeHTTP(w, r) // the router's 404
+			case r.Method == http.MethodOptions:
+				w.Header().Set("Allow", strings.Join(allow, ", "))
+				w.WriteHeader(http.StatusNoContent)
+			default:
+				methodNotAllowedOn(routes)(w, r)
+			}
+		})
+	}
+}
+
+// methodNotAllowedOn is the JSON 405 for routes, with the Allow header RFC 9110 asks for.
+func methodNotAllowedOn(routes chi.Routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allow := allowedMethods(routes, r.URL.Path); allow != nil {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+		}
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, r.Method+" is not allowed here")
+	}
+}
+
+// headWriter holds back a HEAD's header until the handler is done, counting the body it
+// writes and dropping it, so Content-Length can be the GET's.
+type headWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *headWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.n += int64(len(b))
+	return len(b), nil
+}
+
+// Flush does nothing: a flush would send the header before the length is known.
+func (w *headWriter) Flush() {}
+
+// Hijack is refused: nothing is upgraded on a HEAD.
+func (w *headWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *headWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// finish sends the header, with the counted length unless the handler set one or the status has
+// no body.
+func (w *headWriter) finish() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	if h.Get("Content-Length") == "" && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		h.Set("Content-Length", strconv.FormatInt(w.n, 10))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
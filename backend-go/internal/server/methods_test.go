@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestRouteMethods walks every route: OPTIONS lists its method, an unregistered method is the
+// JSON 405 with the same Allow, and HEAD on a GET route has the GET's status without a body.
+func TestRouteMethods(t *testing.T) {
+	s := fullTestServer(t)
+	h := s.Handler()
+	for _, key := range goRoutes(t, s) {
+		method, route, _ := strings.Cut(key, " ")
+		t.Run(key, func(t *testing.T) {
+			path := routePath(route)
+			opt := serve(h, http.MethodOptions, path, "")
+			if opt.Code != http.StatusNoContent {
+				t.Fatalf("OPTIONS status %d, want 204", opt.Code)
+			}
+			allow := strings.Split(opt.Header().Get("Allow"), ", ")
+			if !slices.Contains(allow, method) || !slices.Contains(allow, http.MethodOptions) {
+				t.Fatalf("Allow %q is missing %s or OPTIONS", allow, method)
+			}
+			bad := serve(h, "TRACE", path, "")
+			if bad.Code != http.StatusMethodNotAllowed || bad.Header().Get("Allow") != opt.Header().Get("Allow") {
+				t.Fatalf("TRACE status %d, Allow %q; want 405, %q", bad.Code, bad.Header().Get("Allow"), opt.Header().Get("Allow"))
+			}
+			if ct := bad.Header().Get("Content-Type"); !strings.HasPrefix(ct, mediaJSON) {
+				t.Fatalf("405 Content-Type %q", ct)
+			}
+			if method != http.MethodGet || strings.HasSuffix(route, "/stream") || route == "/go/ws" {
+				return
+			}
+			if !slices.Contains(allow, http.MethodHead) {
+				t.Fatalf("Allow %q is missing HEAD", allow)
+			}
+			get, head := serve(h, http.MethodGet, path, ""), serve(h, http.MethodHead, path, "")
+			if head.Code != get.Code {
+				t.Fatalf("HEAD status %d, GET %d", head.Code, get.Code)
+			}
+			if head.Body.Len() != 0 {
+				t.Fatalf("HEAD sent %d body bytes", head.Body.Len())
+			}
+			n, err := strconv.Atoi(head.Header().Get("Content-Length"))
+			if changing := route == "/go/metrics" || strings.HasPrefix(route, "/go/health"); changing && err == nil {
+				return // counters and uptimes move between the two requests
+			}
+			if err != nil || n != get.Body.Len() {
+				t.Fatalf("HEAD Content-Length %q, GET body %d bytes", head.Header().Get("Content-Length"), get.Body.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds every metric the service exports at /go/metrics.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	metricRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_http_requests_total",
+		Help: "HTTP requests by route pattern, method, status and API key tier (none when unauthenticated).",
+	}, []string{"route", "method", "status", "tier"})
+
+	metricLatency = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhk_go_http_request_duration_seconds",
+		Help:    "HTTP request latency by route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	metricAbandoned = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_http_requests_abandoned_total",
+		Help: "Requests whose client went away before they were answered, by route pattern.",
+	}, []string{"route"})
+
+	metricResponseEncodeErrors = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_http_response_encode_errors_total",
+		Help: "JSON responses that couldn't be encoded and were answered with a 500 instead.",
+	})
+
+	metricResponseWriteErrors = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_http_response_write_errors_total",
+		Help: "JSON responses the client went away before receiving.",
+	})
+
+	metricSlowRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_slow_requests_total",
+		Help: "Requests slower than SLOW_REQUEST_THRESHOLD by route pattern, whether or not sampling logged them.",
+	}, []string{"route"})
+
+	metricStatsdDropped = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_statsd_dropped_total",
+		Help: "StatsD lines dropped because the send queue was full.",
+	})
+
+	metricInFlight = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "dhk_go_http_in_flight_requests",
+		Help: "Requests currently being served.",
+	})
+
+	metricWSConnections = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "dhk_go_ws_connections",
+		Help: "Open /go/ws sessions.",
+	})
+
+	metricUpstreamUp = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dhk_go_upstream_up",
+		Help: "1 when the last background health poll of an upstream succeeded, 0 when it failed.",
+	}, []string{"upstream"})
+
+	metricUpstreamRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_upstream_requests_total",
+		Help: "Translate calls by upstream and outcome (ok, rejected for 4xx, failed, cancelled for a hedge that lost).",
+	}, []string{"upstream", "outcome"})
+
+	metricUpstreamLatency = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhk_go_upstream_request_duration_seconds",
+		Help:    "Translate call latency by upstream, retries included.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream"})
+
+	metricUpstreamFailovers = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_upstream_failovers_total",
+		Help: "Calls passed on to the next upstream after this one failed.",
+	}, []string{"upstream"})
+
+	metricUpstreamHedges = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_upstream_hedges_total",
+		Help: "Second attempts sent to this upstream because the first hadn't answered within HEDGE_DELAY.",
+	}, []string{"upstream"})
+
+	metricUpstreamHedgeWins = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_upstream_hedge_wins_total",
+		Help: "Hedged attempts to this upstream that answered before the first attempt.",
+	}, []string{"upstream"})
+
+	metricEdgeRejections = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_edge_rejections_total",
+		Help: "Requests refused by edge signature checks, by reason (missing, nonce, timestamp, signature, replay, nonce_store).",
+	}, []string{"reason"})
+
+	metricCacheHits = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_cache_hits_total",
+		Help: "Translate cache hits on entries within CACHE_TTL.",
+	})
+
+	metricCacheStaleHits = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_cache_stale_hits_total",
+		Help: "Translate cache hits served stale, within CACHE_STALE_GRACE past CACHE_TTL, while the entry refreshes.",
+	})
+
+	metricCacheRefreshFailures = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_cache_refresh_failures_total",
+		Help: "Background refreshes of stale cache entries that failed.",
+	})
+
+	metricCacheMisses = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_cache_misses_total",
+		Help: "Translate cache misses.",
+	})
+
+	metricCacheBytes = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "dhk_go_cache_bytes",
+		Help: "Approximate bytes held by the in-memory translate cache.",
+	})
+
+	metricCacheEvictions = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_cache_evictions_total",
+		Help: "Entries evicted from the in-memory cache to stay under CACHE_MAX_ENTRIES or CACHE_MAX_BYTES.",
+	})
+
+	metricCacheEvictedBytes = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_cache_evicted_bytes_total",
+		Help: "Approximate bytes freed by in-memory cache evictions.",
+	})
+
+	metricCacheOversized = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_cache_oversized_total",
+		Help: "Results too large for the in-memory cache (over CACHE_MAX_ENTRY_PERCENT of CACHE_MAX_BYTES), served uncached.",
+	})
+
+	metricCacheErrorHits = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_cache_error_hits_total",
+		Help: "Upstream 4xx rejections replayed from the error cache without calling upstream.",
+	})
+
+	metricFlightShared = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_translate_shared_total",
+		Help: "Cache misses whose upstream call was shared with concurrent identical requests.",
+	})
+
+	metricTMHits = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_tm_hits_total",
+		Help: "Translations answered from the translation memory instead of the upstream.",
+	})
+
+	metricPackHits = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_pack_hits_total",
+		Help: "Translations answered from a loaded pack without touching the cache or upstream.",
+	})
+
+	metricTransliterations = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_transliterations_total",
+		Help: "Transliterations served, by direction and whether the transliteration cache had them.",
+	}, []string{"direction", "cached"})
+
+	metricJobs = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_jobs_total",
+		Help: "Async translation jobs by the status they reached: queued on submit, then done, failed or canceled.",
+	}, []string{"status"})
+
+	metricJobCallbacks = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_job_callbacks_total",
+		Help: "Job callback attempts by outcome: delivered, retry or failed (given up).",
+	}, []string{"outcome"})
+
+	metricUpstreamErrors = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_upstream_errors_total",
+		Help: "Failed upstream calls by upstream and kind (timeout, refused, dns, canceled, transport, 4xx, 5xx, decode, schema).",
+	}, []string{"upstream", "kind"})
+
+	metricUpstreamPhase = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhk_go_upstream_phase_seconds",
+		Help:    "Upstream HTTP timing by upstream, call (translate, health) and phase (dns, connect, tls, ttfb, total), per attempt.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"upstream", "call", "phase"})
+
+	metricUpstreamRetries = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_upstream_retries_total",
+		Help: "Upstream translate calls retried after a transient failure.",
+	})
+
+	metricShedInFlight = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "dhk_go_translate_in_flight",
+		Help: "Translate requests holding a MAX_CONCURRENCY slot.",
+	})
+
+	metricShedQueued = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "dhk_go_translate_queued",
+		Help: "Translate requests waiting for a MAX_CONCURRENCY slot.",
+	})
+
+	metricShedWait = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhk_go_translate_queue_wait_seconds",
+		Help:    "Time translate requests spent queued for a MAX_CONCURRENCY slot, by outcome (admitted, timed_out, canceled).",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 13),
+	}, []string{"outcome"})
+
+	metricShed = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "dhk_go_translate_shed_total",
+		Help: "Translate requests refused with 503 because the queue was full or no slot freed up in time.",
+	})
+
+	metricRateLimited = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_rate_limited_total",
+		Help: "Requests refused with 429 by limiter (free, pro, ip, health, concurrency).",
+	}, []string{"limiter"})
+
+	metricQuotaOverage = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_quota_overage_chars_total",
+		Help: "Characters translated past a key's daily quota, within its tier's grace, by tier.",
+	}, []string{"tier"})
+
+	metricSharedLimitFallbacks = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_shared_limit_fallbacks_total",
+		Help: "Rate limit and quota decisions made locally because the Redis holding the shared limits failed, by limit (rate, quota).",
+	}, []string{"limit"})
+
+	metricEgressDenied = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_egress_denied_total",
+		Help: "Outbound requests and dials refused by the egress policy, by client (outbound, callbacks).",
+	}, []string{"client"})
+
+	metricMirror = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_mirror_requests_total",
+		Help: "Translations sampled for the mirror upstream, by outcome (compared, failed, dropped, disabled).",
+	}, []string{"outcome"})
+
+	metricMirrorSimilarity = promauto.With(metricsRegistry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "dhk_go_mirror_similarity",
+		Help:    "Similarity of the mirror upstream's translations to the primary's, 1 for identical.",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	})
+
+	metricWriteQueueDepth = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dhk_go_write_queue_depth",
+		Help: "Writes waiting in a store's background write queue, by store (cache_db, tm).",
+	}, []string{"store"})
+
+	metricWriteQueueDropped = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_write_queue_dropped_total",
+		Help: "Writes dropped because a store's write queue was full or shutting down, by store.",
+	}, []string{"store"})
+
+	metricWriteBatchSize = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhk_go_write_batch_size",
+		Help:    "Writes per batch a store's writer applied, after coalescing writes to the same key, by store.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 9),
+	}, []string{"store"})
+
+	metricWriteSeconds = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhk_go_write_batch_duration_seconds",
+		Help:    "Time to apply one batch of writes, by store.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	}, []string{"store"})
+
+	metricFaultsInjected = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_faults_injected_total",
+		Help: "Faults injected for testing by FAULTS, by target (upstream or route) and fault (latency, error, truncate, drop).",
+	}, []string{"target", "fault"})
+
+	metricStubForced = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dhk_go_stub_forced_total",
+		Help: "Translate requests echoed because they sent X-DHK-Stub: 1, by route.",
+	}, []string{"route"})
+
+	metricBreakerState = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dhk_go_upstream_breaker_state",
+		Help: "Upstream circuit breaker state by upstream: 0 closed, 1 open, 2 half-open.",
+	}, []string{"upstream"})
+)
+
+// countUpstreamError feeds both /go/metrics and /go/stats. Rejections (4xx) and calls the
+// client gave up on don't count against the upstream's error rate, whose window slot now picks.
+func countUpstreamError(sinks metricSinks, now time.Time, upstream, kind string) {
+	metricUpstreamErrors.WithLabelValues(upstream, kind).Inc()
+	sinks.count("upstream.errors", 1, "upstream", upstream, "kind", kind)
+	stats.upstreamErrors.Add(1)
+	if kind != "4xx" && kind != "canceled" {
+		upstreamWindowFor(upstream).failed(now)
+	}
+}
+
+func init() {
+	metricsRegistry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// instrument records request count, latency and in-flight gauge for every route, for
+// /go/metrics, /go/stats and any StatsD sink. The chi route pattern (not the raw path) is the label, so
+// cardinality stays bounded and new routes are covered.
+func instrument(sinks metricSinks) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			metricInFlight.Inc()
+			defer metricInFlight.Dec()
+			start := time.Now()
+			ww := wrapResponse(w, r)
+			next.ServeHTTP(ww, r)
+
+			route := routePattern(r)
+			status := responseStatus(ww, r)
+			tier := "none"
+			if m := requestMetaFrom(r.Context()); m != nil && m.Tier != "" {
+				tier = m.Tier
+			}
+			metricRequests.WithLabelValues(route, r.Method, strconv.Itoa(status), tier).Inc()
+			if clientGone(r) {
+				metricAbandoned.WithLabelValues(route).Inc()
+			}
+			elapsed := time.Since(start)
+			metricLatency.WithLabelValues(route).Observe(elapsed.Seconds())
+			sinks.count("http.requests", 1, "route", route, "method", r.Method, "status", strconv.Itoa(status), "tier", tier)
+			sinks.timing("http.request_duration", elapsed, "route", route)
+			stats.record(route, status, elapsed)
+		})
+	}
+}
+
+// statusClientClosed is recorded for a request abandoned before anything was written, the
+// status nginx logs for one.
+const statusClientClosed = 499
+
+// responseStatus is the status ww was written with; nothing written is a 200, or a 499 when the
+// client left first.
+func responseStatus(ww middleware.WrapResponseWriter, r *http.Request) int {
+	switch status := ww.Status(); {
+	case status != 0:
+		return status
+	case clientGone(r):
+		return statusClientClosed
+	}
+	return http.StatusOK
+}
+
+// clientGone reports whether r's client disconnected: the server cancels the request's context
+// then, while a timeout ends it with DeadlineExceeded.
+func clientGone(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// routePattern returns the matched chi pattern, or "unmatched" for 404s.
+func routePattern(r *http.Request) string {
+	if rc := chi.RouteContext(r.Context()); rc != nil {
+		if p := rc.RoutePattern(); p != "" {
+			return p
+		}
+	}
+	return "unmatched"
+}
+
+// metricsHandler serves the Prometheus exposition, requiring "Authorization: Bearer <token>" when token is set.
+func metricsHandler(token string) http.Handler {
+	h := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "invalid metrics token")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape reads /go/metrics through h and returns a lookup of a sample's value by its name and
+// some of its labels, summed over the series that match; 0 when none does.
+func scrape(t *testing.T, h http.Handler, headers ...string) func(name string, labels ...string) float64 {
+	t.Helper()
+	w := serve(h, "GET", "/go/metrics", "", headers...)
+	if w.Code != http.StatusOK {
+		t.Fatalf("scrape status %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	return func(name string, labels ...string) float64 {
+		var sum float64
+		sc := bufio.NewScanner(strings.NewReader(body))
+		for sc.Scan() {
+			series, value, ok := strings.Cut(sc.Text(), " ")
+			if !ok || strings.HasPrefix(series, "#") {
+				continue
+			}
+			metric, lbls, _ := strings.Cut(strings.TrimSuffix(series, "}"), "{")
+			if metric != name {
+				continue
+			}
+			matched := true
+			for i := 0; i+1 < len(labels); i += 2 {
+				if !strings.Contains(","+lbls+",", ","+labels[i]+`="`+labels[i+1]+`",`) {
+					matched = false
+				}
+			}
+			if matched {
+				v, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					t.Fatalf("sample %q: %v", sc.Text(), err)
+				}
+				sum += v
+			}
+		}
+		return sum
+	}
+}
+
+func TestMetricsMoveWithRequests(t *testing.T) {
+	h := newTestServer(t, nil, Deps{}).Handler()
+	before := scrape(t, h)
+	serve(h, "GET", "/go/translate?q=metrics+probe", "", "X-API-Key", testProKey)
+	serve(h, "GET", "/go/translate?q=metrics+probe", "", "X-API-Key", testProKey)
+	serve(h, "GET", "/go/translate?q=metrics+probe", "")
+	serve(h, "GET", "/go/no-such-route", "")
+	after := scrape(t, h)
+	tests := []struct {
+		name   string
+		metric string
+		labels []string
+		delta  float64
+	}{
+		{"translated", "dhk_go_http_requests_total", []string{"route", "/go/translate", "method", "GET", "status", "200", "tier", tierPro}, 2},
+		{"unauthenticated", "dhk_go_http_requests_total", []string{"route", "/go/translate", "status", "401", "tier", "none"}, 1},
+		{"unmatched", "dhk_go_http_requests_total", []string{"route", "unmatched", "status", "404"}, 1},
+		{"latency", "dhk_go_http_request_duration_seconds_count", []string{"route", "/go/translate"}, 3},
+		{"cache miss", "dhk_go_cache_misses_total", nil, 1},
+		{"cache hit", "dhk_go_cache_hits_total", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := after(tt.metric, tt.labels...) - before(tt.metric, tt.labels...); got != tt.delta {
+				t.Fatalf("%s %v moved by %v, want %v", tt.metric, tt.labels, got, tt.delta)
+			}
+		})
+	}
+	if got := after("dhk_go_http_in_flight_requests"); got != 1 { // the scrape itself
+		t.Fatalf("in flight %v during a scrape, want 1", got)
+	}
+}
+
+func TestMetricsToken(t *testing.T) {
+	h := newTestServer(t, map[string]string{"METRICS_TOKEN": "scrape-me"}, Deps{}).Handler()
+	tests := []struct {
+		name   string
+		auth   string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"not bearer", "scrape-me", http.StatusUnauthorized},
+		{"token", "Bearer scrape-me", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(h, "GET", "/go/metrics", "", "Authorization", tt.auth); w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Schema migrations for the SQLite stores. Each store's schema is the numbered SQL files under
+// migrations/<store>, applied in order: 001_translation_cache.sql, then 002_pack_sync.sql. A
+// database records what it has had in schema_migrations, keyed by store so two stores can
+// share a file. The pending migrations are applied in one transaction, at startup before the
+// listener opens and again (finding nothing) when each store opens, so a database is either
+// at this build's version or where it was. A database ahead of the build, written by a newer
+// one, stops startup: downgrades aren't supported. --migrate-dry-run prints the plan and
+// --migrate-only applies it, each without starting the server.
+//
+// A migration is never edited once released; a change is a new file. Databases from before
+// the runner come with the tables of the early migrations and some of their columns, so a
+// CREATE ... IF NOT EXISTS does nothing on them and an ADD COLUMN of a column that exists
+// counts as applied.
+
+//go:embed migrations
+var migrationFS embed.FS
+
+const schemaMigrationsDDL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	store      TEXT NOT NULL,
+	version    INTEGER NOT NULL,
+	name       TEXT NOT NULL,
+	applied_at INTEGER NOT NULL,
+	PRIMARY KEY (store, version)
+)`
+
+// migration is one numbered file.
+type migration struct {
+	version int
+	name    string // the file name without its number and .sql
+	sql     string
+}
+
+// migrations lists store's migrations in order, checking they are numbered 1, 2, 3...
+func migrations(store string) ([]migration, error) {
+	files, err := fs.ReadDir(migrationFS, path.Join("migrations", store))
+	if err != nil {
+		return nil, fmt.Errorf("%s migrations: %w", store, err)
+	}
+	var out []migration
+	for _, f := range files {
+		num, name, ok := strings.Cut(strings.TrimSuffix(f.Name(), ".sql"), "_")
+		v, err := strconv.Atoi(num)
+		if !ok || err != nil || !strings.HasSuffix(f.Name(), ".sql") {
+			return nil, fmt.Errorf("%s migrations: %s is not NNN_name.sql", store, f.Name())
+		}
+		if v != len(out)+1 {
+			return nil, fmt.Errorf("%s migrations: %s is out of sequence, want %03d", store, f.Name(), len(out)+1)
+		}
+		b, err := migrationFS.ReadFile(path.Join("migrations", store, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, migration{version: v, name: name, sql: string(b)})
+	}
+	return out, nil
+}
+
+// migrationPlan is where a store's database is and what would bring it to this build.
+type migrationPlan struct {
+	store   string
+	current int // the highest version applied; 0 for a new database
+	latest  int // the highest this build knows
+	pending []migration
+}
+
+// planMigrations reads what db has applied of store's migrations. A database with a version
+// this build doesn't know, or a different migration under a known number, is an error.
+func planMigrations(q interface {
+	QueryRow(string, ...any) *sql.Row
+	Query(string, ...any) (*sql.Rows, error)
+}, store string) (migrationPlan, error) {
+	known, err := migrations(store)
+	if err != nil {
+		return migrationPlan{}, err
+	}
+	p := migrationPlan{store: store, latest: len(known)}
+	var tables int
+	if err := q.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&tables); err != nil {
+		return p, err
+	}
+	if tables > 0 {
+		rows, err := q.Query(`SELECT version, name FROM schema_migrations WHERE store = ? ORDER BY version`, store)
+		if err != nil {
+			return p, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				v    int
+				name string
+			)
+			if err := rows.Scan(&v, &name); err != nil {
+				return p, err
+			}
+			if v > len(known) {
+				return p, fmt.Errorf("database is at schema version %d (%s), ahead of this build's %d; run a build that has its migrations", v, name, len(known))
+			}
+			if known[v-1].name != name {
+				return p, fmt.Errorf("database has migration %d as %q, this build has %q", v, name, known[v-1].name)
+			}
+			p.current = v
+		}
+		if err := rows.Err(); err != nil {
+			return p, err
+		}
+	}
+	p.pending = known[p.current:]
+	return p, nil
+}
+
+// migrate brings db to this build's version of store's schema, in one transaction, and
+// returns the plan it carried out. The applied_at stamps are read from clk.
+func migrate(db *sql.DB, store string, clk Clock) (migrationPlan, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return migrationPlan{}, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(schemaMigrationsDDL); err != nil {
+		return migrationPlan{}, err
+	}
+	p, err := planMigrations(tx, store)
+	if err != nil || len(p.pending) == 0 {
+		return p, err
+	}
+	now := clk.Now().Unix()
+	for _, m := range p.pending {
+		for _, stmt := range sqlStatements(m.sql) {
+			if _, err := tx.Exec(stmt); err != nil && !alreadyAdded(stmt, err) {
+				return p, fmt.Errorf("migration %03d_%s: %w", m.version, m.name, err)
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (store, version, name, applied_at) VALUES (?, ?, ?, ?)`, store, m.version, m.name, now); err != nil {
+			return p, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+	slog.Info("schema migrated", "store", store, "from", p.current, "to", p.latest)
+	return p, nil
+}
+
+// sqlStatements splits a migration at the semicolons that end a line.
+func sqlStatements(src string) []string {
+	var out []string
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(src, "\n") {
+		b.WriteString(line)
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			out = append(out, b.String())
+			b.Reset()
+		}
+	}
+	if strings.TrimSpace(b.String()) != "" {
+		out = append(out, b.String())
+	}
+	return out
+}
+
+// alreadyAdded reports an ADD COLUMN that failed because a database from before the runner
+// already has the column.
+func alreadyAdded(stmt string, err error) bool {
+	return strings.Contains(strings.ToUpper(stmt), "ADD COLUMN") && strings.Contains(err.Error(), "duplicate column")
+}
+
+// sqliteStore is a store Config gives a database file.
+type sqliteStore struct {
+	name, path string
+}
+
+// sqliteStores are the stores cfg enables, by migration set.
+func sqliteStores(cfg Config) []sqliteStore {
+	var out []sqliteStore
+	for _, s := range []sqliteStore{
+		{"cache", cfg.CacheDBPath},
+		{"tm", cfg.TMDBPath},
+		{"glossary", cfg.GlossaryDBPath},
+		{"usage", cfg.UsageDBPath},
+		{"jobs", cfg.JobsDBPath},
+	} {
+		if s.path != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// openSQLite opens the database at path the way every store does.
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // one writer; SQLite serializes anyway
+	return db, nil
+}
+
+// Migrate prints the migration plan of every SQLite store cfg enables to out and, unless
+// dryRun, applies it. A dry run opens the databases read-only and creates none.
+func Migrate(cfg Config, dryRun bool, out io.Writer) error {
+	stores := sqliteStores(cfg)
+	if len(stores) == 0 {
+		fmt.Fprintln(out, "no SQLite stores configured")
+		return nil
+	}
+	failed := 0
+	for _, s := range stores {
+		p, err := migrateStore(s, dryRun, systemClock{})
+		if err != nil {
+			fmt.Fprintf(out, "%s %s: %v\n", s.name, s.path, err)
+			failed++
+			continue
+		}
+		switch {
+		case len(p.pending) == 0:
+			fmt.Fprintf(out, "%s %s: up to date at version %d\n", s.name, s.path, p.current)
+		case dryRun:
+			fmt.Fprintf(out, "%s %s: at version %d, would apply:\n", s.name, s.path, p.current)
+		default:
+			fmt.Fprintf(out, "%s %s: migrated from version %d to %d:\n", s.name, s.path, p.current, p.latest)
+		}
+		for _, m := range p.pending {
+			fmt.Fprintf(out, "  %03d_%s\n", m.version, m.name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d SQLite stores could not be migrated", failed, len(stores))
+	}
+	return nil
+}
+
+// migrateStore plans s and, unless dryRun, migrates it.
+func migrateStore(s sqliteStore, dryRun bool, clk Clock) (migrationPlan, error) {
+	if dryRun {
+		if _, err := os.Stat(s.path); errors.Is(err, fs.ErrNotExist) {
+			known, err := migrations(s.name)
+			return migrationPlan{store: s.name, latest: len(known), pending: known}, err
+		}
+		db, err := sql.Open("sqlite", "file:"+s.path+"?mode=ro&_pragma=busy_timeout(5000)")
+		if err != nil {
+			return migrationPlan{}, err
+		}
+		defer db.Close()
+		return planMigrations(db, s.name)
+	}
+	db, err := openSQLite(s.path)
+	if err != nil {
+		return migrationPlan{}, err
+	}
+	defer db.Close()
+	return migrate(db, s.name, clk)
+}
+
+// migrateAll migrates every store cfg enables, for Boot to run before it listens.
+func migrateAll(cfg Config, clk Clock) error {
+	for _, s := range sqliteStores(cfg) {
+		if _, err := migrateStore(s, false, clk); err != nil {
+			return fmt.Errorf("%s db migration failed: %w", s.name, err)
+		}
+	}
+	return nil
+}
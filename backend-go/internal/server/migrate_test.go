@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// migrationStores is every store with migrations, as sqliteStores names them.
+var migrationStores = []string{"cache", "tm", "glossary", "usage", "jobs"}
+
+func TestMigrationFiles(t *testing.T) {
+	dirs, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stores []string
+	for _, d := range dirs {
+		stores = append(stores, d.Name())
+	}
+	want := slices.Clone(migrationStores)
+	slices.Sort(stores)
+	slices.Sort(want)
+	if !slices.Equal(stores, want) {
+		t.Fatalf("migration sets %q, want %q", stores, want)
+	}
+	for _, store := range migrationStores {
+		t.Run(store, func(t *testing.T) {
+			ms, err := migrations(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ms) == 0 {
+				t.Fatal("no migrations")
+			}
+			for _, m := range ms {
+				if len(sqlStatements(m.sql)) == 0 {
+					t.Fatalf("%03d_%s has no statements", m.version, m.name)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"one", "CREATE TABLE a (x);\n", []string{"CREATE TABLE a (x);\n"}},
+		{"over lines", "-- comment\nCREATE TABLE a (\n  x\n);\nCREATE INDEX i ON a (x);\n", []string{"-- comment\nCREATE TABLE a (\n  x\n);\n", "CREATE INDEX i ON a (x);\n"}},
+		{"semicolon inside a line", "INSERT INTO a VALUES ('x;y');\n", []string{"INSERT INTO a VALUES ('x;y');\n"}},
+		{"no final semicolon", "CREATE TABLE a (x)", []string{"CREATE TABLE a (x)"}},
+		{"trailing blank lines", "CREATE TABLE a (x);\n\n  \n", []string{"CREATE TABLE a (x);\n"}},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqlStatements(tt.src); !slices.Equal(got, tt.want) {
+				t.Fatalf("statements %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testDB opens a new database in a temp dir.
+func testDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func appliedVersions(t *testing.T, db *sql.DB, store string) []int {
+	t.Helper()
+	rows, err := db.Query(`SELECT version FROM schema_migrations WHERE store = ? ORDER BY version`, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var out []int
+	for rows.Next() {
+		var v int
+		rows.Scan(&v)
+		out = append(out, v)
+	}
+	return out
+}
+
+// TestMigrateChain runs each store's full chain against a new database, then again, and
+// checks the second run finds nothing to do and changes nothing.
+func TestMigrateChain(t *testing.T) {
+	for _, store := range migrationStores {
+		t.Run(store, func(t *testing.T) {
+			known, _ := migrations(store)
+			db, _ := testDB(t)
+			p, err := migrate(db, store, systemClock{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.current != 0 || p.latest != len(known) || len(p.pending) != len(known) {
+				t.Fatalf("first run: at %d of %d with %d pending, want 0 of %d with all pending", p.current, p.latest, len(p.pending), len(known))
+			}
+			schema := func() string {
+				rows, err := db.Query(`SELECT type || ' ' || name || ' ' || COALESCE(sql, '') FROM sqlite_master ORDER BY name`)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rows.Close()
+				var b strings.Builder
+				for rows.Next() {
+					var s string
+					rows.Scan(&s)
+					b.WriteString(s + "\n")
+				}
+				return b.String()
+			}
+			before := schema()
+			for run := 2; run <= 3; run++ {
+				p, err := migrate(db, store, systemClock{})
+				if err != nil {
+					t.Fatalf("run %d: %v", run, err)
+				}
+				if p.current != len(known) || len(p.pending) != 0 {
+					t.Fatalf("run %d: at %d with %d pending, want %d with none", run, p.current, len(p.pending), len(known))
+				}
+			}
+			if after := schema(); after != before {
+				t.Fatalf("schema changed on a re-run:\n%s\nthen\n%s", before, after)
+			}
+			want := make([]int, len(known))
+			for i := range want {
+				want[i] = i + 1
+			}
+			if got := appliedVersions(t, db, store); !slices.Equal(got, want) {
+				t.Fatalf("schema_migrations has %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("stores sharing a file", func(t *testing.T) {
+		db, _ := testDB(t)
+		for run := range 2 {
+			for _, store := range migrationStores {
+				if _, err := migrate(db, store, systemClock{}); err != nil {
+					t.Fatalf("run %d, %s: %v", run, store, err)
+				}
+			}
+		}
+		for _, store := range migrationStores {
+			known, _ := migrations(store)
+			if got := appliedVersions(t, db, store); len(got) != len(known) {
+				t.Fatalf("%s: versions %v, want %d", store, got, len(known))
+			}
+		}
+	})
+}
+
+// TestMigrateBeforeTheRunner migrates databases a build from before the runner created, with
+// the tables and added columns already in place but no schema_migrations.
+func TestMigrateBeforeTheRunner(t *testing.T) {
+	for _, store := range migrationStores {
+		t.Run(store, func(t *testing.T) {
+			db, _ := testDB(t)
+			known, _ := migrations(store)
+			for _, m := range known {
+				for _, stmt := range sqlStatements(m.sql) {
+					if _, err := db.Exec(stmt); err != nil {
+						t.Fatalf("%03d_%s: %v", m.version, m.name, err)
+					}
+				}
+			}
+			p, err := migrate(db, store, systemClock{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.current != 0 || len(p.pending) != len(known) || len(appliedVersions(t, db, store)) != len(known) {
+				t.Fatalf("plan %d with %d pending, versions %v", p.current, len(p.pending), appliedVersions(t, db, store))
+			}
+		})
+	}
+}
+
+func TestMigrateRefusals(t *testing.T) {
+	known, _ := migrations("usage")
+	tests := []struct {
+		name    string
+		version int
+		mname   string
+		want    string
+	}{
+		{"database ahead of the build", len(known) + 1, "from_the_future", "ahead of this build"},
+		{"different migration under a known number", 1, "something_else", `as "something_else"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := testDB(t)
+			if _, err := migrate(db, "usage", systemClock{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(`INSERT OR REPLACE INTO schema_migrations (store, version, name, applied_at) VALUES ('usage', ?, ?, 0)`, tt.version, tt.mname); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := migrate(db, "usage", systemClock{}); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("migrate: %v, want %q", err, tt.want)
+			}
+			// Startup stops on it before it listens.
+			cfg, err := LoadConfig(func(k string) string {
+				return map[string]string{"ENV": "development", "PORT": "0", "USAGE_DB_PATH": path}[k]
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := Boot(context.Background(), cfg, Deps{})
+			if err == nil {
+				s.Stop(context.Background(), StopCause{})
+				t.Fatal("booted on a database it can't migrate")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("boot: %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestMigrateCLI runs --migrate-dry-run and --migrate-only in turn and checks the plans they
+// print, that the dry run creates and changes nothing, and what a failure reports.
+func TestMigrateCLI(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{CacheDBPath: filepath.Join(dir, "cache.db"), TMDBPath: filepath.Join(dir, "tm.db")}
+	steps := []struct {
+		name   string
+		dryRun bool
+		want   []string // lines, in order
+	}{
+		{"dry run on new files", true, []string{"cache " + cfg.CacheDBPath + ": at version 0, would apply:", "  001_translation_cache", "  002_pack_sync",
+			"tm " + cfg.TMDBPath + ": at version 0, would apply:", "  001_translation_memory"}},
+		{"migrate", false, []string{"cache " + cfg.CacheDBPath + ": migrated from version 0 to 2:", "  001_translation_cache", "  002_pack_sync",
+			"tm " + cfg.TMDBPath + ": migrated from version 0 to 1:", "  001_translation_memory"}},
+		{"dry run when current", true, []string{"cache " + cfg.CacheDBPath + ": up to date at version 2", "tm " + cfg.TMDBPath + ": up to date at version 1"}},
+		{"migrate again", false, []string{"cache " + cfg.CacheDBPath + ": up to date at version 2", "tm " + cfg.TMDBPath + ": up to date at version 1"}},
+	}
+	for _, st := range steps {
+		var out bytes.Buffer
+		if err := Migrate(cfg, st.dryRun, &out); err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+		if got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); !slices.Equal(got, st.want) {
+			t.Fatalf("%s: printed\n%s\nwant\n%s", st.name, out.String(), strings.Join(st.want, "\n"))
+		}
+		if _, err := os.Stat(cfg.CacheDBPath); st.name == "dry run on new files" && !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("%s: created the database (%v)", st.name, err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Migrate(Config{}, false, &out); err != nil || out.String() != "no SQLite stores configured\n" {
+		t.Fatalf("no stores: %v, %q", err, out.String())
+	}
+	out.Reset()
+	bad := Config{CacheDBPath: cfg.CacheDBPath, TMDBPath: filepath.Join(dir, "missing", "tm.db")}
+	if err := Migrate(bad, false, &out); err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Fatalf("one store failing: %v", err)
+	}
+	if !strings.Contains(out.String(), "up to date at version 2") || !strings.Contains(out.String(), "tm "+bad.TMDBPath+": ") {
+		t.Fatalf("one store failing printed %q", out.String())
+	}
+}
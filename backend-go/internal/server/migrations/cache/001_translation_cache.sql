@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS translation_cache (
+	key         TEXT PRIMARY KEY,
+	translation TEXT NOT NULL,
+	src         TEXT NOT NULL DEFAULT '',
+	dst         TEXT NOT NULL DEFAULT '',
+	created_at  INTEGER NOT NULL,
+	hits        INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS translation_cache_created_at ON translation_cache(created_at);
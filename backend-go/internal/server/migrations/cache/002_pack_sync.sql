@@ -0,0 +1,12 @@
+-- Pack reload state shared by the instances on this database (PACK_SYNC_INTERVAL).
+CREATE TABLE IF NOT EXISTS pack_stamp (
+	id           INTEGER PRIMARY KEY CHECK (id = 1),
+	stamp        TEXT NOT NULL,
+	published_by TEXT NOT NULL,
+	published_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS pack_instances (
+	instance TEXT PRIMARY KEY,
+	stamp    TEXT NOT NULL,
+	seen_at  INTEGER NOT NULL
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS client_glossary (
+	key_id     TEXT NOT NULL,
+	term       TEXT NOT NULL,
+	target     TEXT NOT NULL, -- JSON: the target string, or true to keep the term as written
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (key_id, term)
+);
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS jobs (
+	id         TEXT PRIMARY KEY,
+	owner      TEXT NOT NULL,
+	tier       TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	request    TEXT NOT NULL,
+	total      INTEGER NOT NULL,
+	error      TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_status ON jobs(status, created_at);
+CREATE INDEX IF NOT EXISTS jobs_created ON jobs(created_at, id);
+CREATE TABLE IF NOT EXISTS job_deliveries (
+	job_id      TEXT NOT NULL,
+	attempt     INTEGER NOT NULL,
+	at          INTEGER NOT NULL,
+	status      INTEGER NOT NULL,
+	error       TEXT NOT NULL DEFAULT '',
+	duration_ms INTEGER NOT NULL,
+	PRIMARY KEY (job_id, attempt)
+);
+CREATE TABLE IF NOT EXISTS job_items (
+	job_id  TEXT NOT NULL,
+	seq     INTEGER NOT NULL,
+	item_id TEXT NOT NULL,
+	result  TEXT NOT NULL,
+	failed  INTEGER NOT NULL,
+	PRIMARY KEY (job_id, seq)
+);
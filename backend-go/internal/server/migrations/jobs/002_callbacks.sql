@@ -0,0 +1,4 @@
+-- Completion callbacks (callback_url on POST /go/jobs).
+ALTER TABLE jobs ADD COLUMN callback_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN callback_state TEXT NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN callback_next_at INTEGER NOT NULL DEFAULT 0;
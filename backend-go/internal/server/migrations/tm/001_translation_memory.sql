@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS translation_memory (
+	src        TEXT NOT NULL,
+	dst        TEXT NOT NULL,
+	source     TEXT NOT NULL,
+	target     TEXT NOT NULL,
+	corrected  INTEGER NOT NULL DEFAULT 0,
+	updated_by TEXT NOT NULL DEFAULT '', -- admin id of the last correction
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (src, dst, source)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS tm_created ON translation_memory (created_at, src, dst, source);
@@ -0,0 +1,32 @@
+-- usage_rollup holds the last event id folded into usage_daily. A rollup recomputes every day
+-- with events past it from all of that day's events, so running it twice changes nothing, and
+-- pruning removes whole days only once they have been rolled up.
+CREATE TABLE IF NOT EXISTS usage_events (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	day          TEXT NOT NULL,
+	at           INTEGER NOT NULL,
+	key_id       TEXT NOT NULL,
+	tier         TEXT NOT NULL DEFAULT '',
+	requests     INTEGER NOT NULL DEFAULT 0,
+	translations INTEGER NOT NULL DEFAULT 0,
+	chars        INTEGER NOT NULL DEFAULT 0,
+	cache_hits   INTEGER NOT NULL DEFAULT 0,
+	errors       INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS usage_events_day_key ON usage_events(day, key_id);
+CREATE TABLE IF NOT EXISTS usage_daily (
+	day          TEXT NOT NULL,
+	key_id       TEXT NOT NULL,
+	tier         TEXT NOT NULL DEFAULT '',
+	requests     INTEGER NOT NULL,
+	translations INTEGER NOT NULL,
+	chars        INTEGER NOT NULL,
+	cache_hits   INTEGER NOT NULL,
+	errors       INTEGER NOT NULL,
+	rolled_at    INTEGER NOT NULL,
+	PRIMARY KEY (day, key_id)
+);
+CREATE TABLE IF NOT EXISTS usage_rollup (
+	id         INTEGER PRIMARY KEY CHECK (id = 1),
+	last_event INTEGER NOT NULL
+);
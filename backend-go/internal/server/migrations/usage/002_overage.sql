@@ -0,0 +1,3 @@
+-- Requests served past a key's monthly quota, within QUOTA_GRACE_*.
+ALTER TABLE usage_events ADD COLUMN overage INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE usage_daily ADD COLUMN overage INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Upstream mirroring, for shadow-testing a new backend before traffic moves to it. With
+// MIRROR_UPSTREAM_URL and MIRROR_SAMPLE_RATE, that share of the translations the upstream
+// answers is sent again to the mirror, after the client has its answer and on a context of its
+// own, and the mirror's status, latency and similarity to the primary's translation are
+// logged, counted and kept for GET /go/admin/mirror. The client's response and quota never see
+// it. At most MIRROR_MAX_INFLIGHT mirror calls run at once; a sample that finds them all busy
+// is dropped, not queued. If mirror calls come to hold more than MIRROR_MAX_CONN_SHARE of the
+// outbound requests in flight, mirroring turns itself off until the next config reload.
+
+// mirrorShareFloor is how many outbound requests must be in flight before the mirror's share of
+// them is judged; below it MIRROR_MAX_INFLIGHT is bound enough.
+const mirrorShareFloor = 10
+
+// mirrorOpts is MIRROR_*.
+type mirrorOpts struct {
+	Rate      float64
+	MaxFlight int
+	MaxShare  float64
+	Samples   int     // kept for the report
+	Agree     float64 // similarity at which a sample counts as agreeing
+}
+
+// mirrorSample is one mirrored call.
+type mirrorSample struct {
+	At         time.Time `json:"at"`
+	Status     int       `json:"status"` // the mirror's HTTP status; 0 when it never answered
+	Error      string    `json:"error,omitempty"`
+	LatencyMS  float64   `json:"latency_ms"`
+	PrimaryMS  float64   `json:"primary_ms"`
+	Similarity float64   `json:"similarity"` // trigram Dice against the primary's translation; 1 is identical
+}
+
+// upstreamMirror sends sampled calls to the mirror and keeps the last opts.Samples outcomes.
+type upstreamMirror struct {
+	name   string // the mirror's host
+	client *upstreamClient
+	opts   mirrorOpts
+	sample func() float64 // rand.Float64
+	slots  chan struct{}
+
+	sent, dropped atomic.Uint64
+	disabled      atomic.Pointer[string] // why mirroring turned itself off
+
+	mu     sync.Mutex
+	recent []mirrorSample // a ring of opts.Samples
+	next   int
+}
+
+func newUpstreamMirror(client *upstreamClient, opts mirrorOpts) *upstreamMirror {
+	return &upstreamMirror{
+		name:   client.endpoint.Host,
+		client: client,
+		opts:   opts,
+		sample: rand.Float64,
+		slots:  make(chan struct{}, opts.MaxFlight),
+		recent: make([]mirrorSample, 0, opts.Samples),
+	}
+}
+
+// mirrorFromConfig is the mirror cfg asks for over rt, nil when it asks for none.
+func mirrorFromConfig(cfg Config, rt http.RoundTripper, sinks metricSinks, clk Clock) *upstreamMirror {
+	if cfg.MirrorURL == nil || cfg.MirrorSampleRate == 0 {
+		return nil
+	}
+	client := newUpstreamClient(cfg.MirrorURL, newUpstreamHTTPClient(cfg.MirrorTimeout, rt, clk), 1, 0, clk)
+	client.strict, client.sinks = cfg.UpstreamStrict, sinks
+	return newUpstreamMirror(client, mirrorOpts{
+		Rate:      cfg.MirrorSampleRate,
+		MaxFlight: cfg.MirrorMaxInFlight,
+		MaxShare:  cfg.MirrorMaxConnShare,
+		Samples:   cfg.MirrorReportSamples,
+		Agree:     cfg.MirrorAgreeSimilarity,
+	})
+}
+
+// mirrorTranslator mirrors the upstream's successful answers from next. A config reload swaps
+// the mirror whole, or clears it.
+type mirrorTranslator struct {
+	next Translator
+	cur  atomic.Pointer[upstreamMirror]
+}
+
+func (t *mirrorTranslator) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	start := time.Now()
+	res, err := t.next.Translate(ctx, req)
+	if m := t.cur.Load(); m != nil && err == nil && res.Src == "upstream" {
+		m.offer(middleware.GetReqID(ctx), req, res.Translation, time.Since(start))
+	}
+	return res, err
+}
+
+// offer mirrors req if it is sampled and a slot is free.
+func (m *upstreamMirror) offer(requestID string, req translateReq, primary string, took time.Duration) {
+	if m.disabled.Load() != nil || m.sample() >= m.opts.Rate {
+		return
+	}
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		m.dropped.Add(1)
+		metricMirror.WithLabelValues("dropped").Inc()
+		return
+	}
+	if total := stats.outbound.inFlight.Load(); total >= mirrorShareFloor {
+		if share := float64(len(m.slots)) / float64(total); share > m.opts.MaxShare {
+			<-m.slots
+			m.disable(fmt.Sprintf("mirror calls held %.0f%% of the outbound requests in flight", share*100))
+			return
+		}
+	}
+	m.sent.Add(1)
+	go func() {
+		defer func() { <-m.slots }()
+		m.call(requestID, req, primary, took)
+	}()
+}
+
+func (m *upstreamMirror) call(requestID string, req translateReq, primary string, took time.Duration) {
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, requestID)
+	req.NBest = 0
+	start := time.Now()
+	res, err := m.client.translateOnce(ctx, req, 0)
+	s := mirrorSample{At: start.UTC(), LatencyMS: ms(time.Since(start)), PrimaryMS: ms(took)}
+	var ue *upstreamError
+	switch {
+	case err == nil:
+		s.Status = http.StatusOK
+		s.Similarity = similarity(primary, res.Translation)
+		metricMirror.WithLabelValues("compared").Inc()
+		metricMirrorSimilarity.Observe(s.Similarity)
+	case errors.As(err, &ue):
+		s.Status, s.Error = ue.Status, ue.Msg
+		metricMirror.WithLabelValues("failed").Inc()
+	default:
+		s.Error = err.Error()
+		metricMirror.WithLabelValues("failed").Inc()
+	}
+	slog.Info("mirror compared", "request_id", requestID, "mirror", m.name, "status", s.Status, "err", s.Error,
+		"latency_ms", s.LatencyMS, "primary_ms", s.PrimaryMS, "similarity", s.Similarity)
+	m.record(s)
+}
+
+// similarity is 1 for identical translations, else their trigram Dice coefficient.
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	return math.Round(dice(trigrams(a), trigrams(b))*1000) / 1000
+}
+
+// resume turns mirroring back on after it disabled itself; every config reload calls it.
+func (m *upstreamMirror) resume() {
+	if m.disabled.Swap(nil) != nil {
+		slog.Info("mirroring resumed", "mirror", m.name)
+	}
+}
+
+func (m *upstreamMirror) disable(why string) {
+	if m.disabled.CompareAndSwap(nil, &why) {
+		metricMirror.WithLabelValues("disabled").Inc()
+		slog.Warn("mirroring disabled", "mirror", m.name, "reason", why)
+	}
+}
+
+func (m *upstreamMirror) record(s mirrorSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.recent) < m.opts.Samples {
+		m.recent = append(m.recent, s)
+		return
+	}
+	m.recent[m.next] = s
+	m.next = (m.next + 1) % m.opts.Samples
+}
+
+// mirrorReport is GET /go/admin/mirror.
+type mirrorReport struct {
+	Mirror         string  `json:"mirror"`
+	Enabled        bool    `json:"enabled"`
+	DisabledReason string  `json:"disabled_reason,omitempty"`
+	SampleRate     float64 `json:"sample_rate"`
+	InFlight       int     `json:"in_flight"`
+	Sent           uint64  `json:"sent"`    // since start
+	Dropped        uint64  `json:"dropped"` // sampled, but every slot was busy
+
+	Samples       int     `json:"samples"` // in the window below, the last MIRROR_REPORT_SAMPLES
+	Failed        int     `json:"failed"`
+	AgreementRate float64 `json:"agreement_rate"` // of the answered samples, those at least MIRROR_AGREE_SIMILARITY alike
+	ExactRate     float64 `json:"exact_rate"`
+	MeanSim       float64 `json:"mean_similarity"`
+	MirrorP50MS   float64 `json:"mirror_p50_ms"`
+	MirrorP95MS   float64 `json:"mirror_p95_ms"`
+	PrimaryP50MS  float64 `json:"primary_p50_ms"`
+	PrimaryP95MS  float64 `json:"primary_p95_ms"`
+
+	Recent []mirrorSample `json:"recent,omitempty"` // with ?recent=N, newest first
+}
+
+func (m *upstreamMirror) report(recent int) mirrorReport {
+	out := mirrorReport{Mirror: m.name, Enabled: true, SampleRate: m.opts.Rate, InFlight: len(m.slots), Sent: m.sent.Load(), Dropped: m.dropped.Load()}
+	if why := m.disabled.Load(); why != nil {
+		out.Enabled, out.DisabledReason = false, *why
+	}
+	m.mu.Lock()
+	window := slices.Concat(m.recent[m.next:], m.recent[:m.next]) // oldest first
+	m.mu.Unlock()
+	var mirrorMS, primaryMS []float64
+	var agree, exact int
+	var sum float64
+	for _, s := range window {
+		mirrorMS, primaryMS = append(mirrorMS, s.LatencyMS), append(primaryMS, s.PrimaryMS)
+		if s.Status != http.StatusOK {
+			out.Failed++
+			continue
+		}
+		sum += s.Similarity
+		if s.Similarity >= m.opts.Agree {
+			agree++
+		}
+		if s.Similarity == 1 {
+			exact++
+		}
+	}
+	out.Samples = len(window)
+	if answered := out.Samples - out.Failed; answered > 0 {
+		out.AgreementRate = math.Round(float64(agree)/float64(answered)*1000) / 1000
+		out.ExactRate = math.Round(float64(exact)/float64(answered)*1000) / 1000
+		out.MeanSim = math.Round(sum/float64(answered)*1000) / 1000
+	}
+	out.MirrorP50MS, out.MirrorP95MS = quantileMS(mirrorMS, 0.5), quantileMS(mirrorMS, 0.95)
+	out.PrimaryP50MS, out.PrimaryP95MS = quantileMS(primaryMS, 0.5), quantileMS(primaryMS, 0.95)
+	for i := len(window) - 1; i >= 0 && len(out.Recent) < recent; i-- {
+		out.Recent = append(out.Recent, window[i])
+	}
+	return out
+}
+
+// quantileMS is the q quantile of vs by nearest rank; 0 for none.
+func quantileMS(vs []float64, q float64) float64 {
+	if len(vs) == 0 {
+		return 0
+	}
+	slices.Sort(vs)
+	return vs[min(len(vs)-1, int(math.Ceil(q*float64(len(vs))))-1)]
+}
+
+// mirrorReportHandler serves GET /go/admin/mirror; ?recent=N adds the last N samples.
+func mirrorReportHandler(t *mirrorTranslator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := t.cur.Load()
+		if m == nil {
+			writeError(w, http.StatusNotImplemented, codeNotImplemented, "mirroring is off; set MIRROR_UPSTREAM_URL and MIRROR_SAMPLE_RATE")
+			return
+		}
+		recent := 0
+		if v := r.URL.Query().Get("recent"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, codeBadRequest, "recent must be a non-negative integer")
+				return
+			}
+			recent = n
+		}
+		j(w, http.StatusOK, m.report(recent))
+	}
+}
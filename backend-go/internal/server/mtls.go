@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Mutual TLS for server-to-server callers that won't hold a header secret. With
+// MTLS_CLIENT_CA_FILE the HTTPS listener demands a client certificate chaining to that CA, so a
+// caller without one never gets past the handshake. The certificate's names become the caller
+// identity, tiered by MTLS_CLIENTS_FILE; the health check gets a plain listener of its own on
+// HEALTH_PORT, since the platform's checker has no certificate.
+
+// mtlsClient is one MTLS_CLIENTS_FILE entry: a certificate name (a DNS or URI SAN, an email SAN
+// or the CN) and what callers presenting it are.
+type mtlsClient struct {
+	Name string `json:"name"`
+	ID   string `json:"id"` // key id for limits and metering; the name when empty
+	Tier string `json:"tier"`
+}
+
+// mtlsClients maps certificate names to identities. Without MTLS_CLIENTS_FILE it is empty and
+// every certificate the CA issued is a free-tier caller.
+type mtlsClients struct {
+	byName map[string]identity
+}
+
+// loadMTLSClients reads MTLS_CLIENTS_FILE, a JSON array of mtlsClient; "" gives the empty set.
+func loadMTLSClients(path string) (*mtlsClients, error) {
+	c := &mtlsClients{}
+	if path == "" {
+		return c, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("MTLS_CLIENTS_FILE: %w", err)
+	}
+	var entries []mtlsClient
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("MTLS_CLIENTS_FILE: %w", err)
+	}
+	c.byName = make(map[string]identity, len(entries))
+	for i, e := range entries {
+		switch {
+		case e.Name == "":
+			return nil, fmt.Errorf("MTLS_CLIENTS_FILE: entry %d has no name", i)
+		case e.Tier != "" && e.Tier != tierFree && e.Tier != tierPro:
+			return nil, fmt.Errorf("MTLS_CLIENTS_FILE: %s: tier %q is not free or pro", e.Name, e.Tier)
+		}
+		if _, dup := c.byName[e.Name]; dup {
+			return nil, fmt.Errorf("MTLS_CLIENTS_FILE: %s is listed twice", e.Name)
+		}
+		id := identity{KeyID: "mtls:" + e.Name, Tier: e.Tier}
+		if e.ID != "" {
+			id.KeyID = e.ID
+		}
+		if id.Tier == "" {
+			id.Tier = tierFree
+		}
+		c.byName[e.Name] = id
+	}
+	return c, nil
+}
+
+// certNames lists the names cert is known by, SANs first and the CN last.
+func certNames(cert *x509.Certificate) []string {
+	names := slices.Clone(cert.DNSNames)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// identity returns who cert belongs to: the first of its names the clients file lists, or with
+// no file, its first name at the free tier. ok is false for a certificate the file doesn't know.
+func (c *mtlsClients) identity(cert *x509.Certificate) (identity, string, bool) {
+	names := certNames(cert)
+	if c.byName == nil {
+		if len(names) == 0 {
+			return identity{}, "", false
+		}
+		return identity{KeyID: "mtls:" + names[0], Tier: tierFree}, names[0], true
+	}
+	for _, n := range names {
+		if id, ok := c.byName[n]; ok {
+			return id, n, true
+		}
+	}
+	return identity{}, "", false
+}
+
+// requireClientCert turns on client certificate verification in tc against the CAs in caFile.
+// ACME's TLS-ALPN challenge comes from a validator without a certificate, so with autocert those
+// handshakes alone are let through.
+func requireClientCert(tc *tls.Config, caFile string) error {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("MTLS_CLIENT_CA_FILE: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("MTLS_CLIENT_CA_FILE: no certificates found")
+	}
+	tc.ClientCAs = pool
+	tc.ClientAuth = tls.RequireAndVerifyClientCert
+	if tc.GetCertificate != nil {
+		acmeOnly := tc.Clone()
+		acmeOnly.ClientAuth = tls.NoClientCert
+		tc.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if slices.Equal(hello.SupportedProtos, []string{acme.ALPNProto}) {
+				return acmeOnly, nil
+			}
+			return nil, nil
+		}
+	}
+	return nil
+}
+
+// clientCertIdentity attaches the verified client certificate's identity to each request, for
+// the rate limiter and metering, ahead of API keys and bearer tokens. The handshake has already
+// refused callers without a valid certificate; this refuses those the clients file doesn't list.
+func clientCertIdentity(clients *mtlsClients) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "client certificate required")
+				return
+			}
+			id, _, ok := clients.identity(r.TLS.VerifiedChains[0][0])
+			if !ok {
+				writeError(w, http.StatusForbidden, codeForbidden, "client certificate not authorized")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), id)))
+		})
+	}
+}
+
+// healthServer serves only the /go/health probes and /go/ready from h on port, in plain HTTP, for a
+// platform health checker that can't present a client certificate.
+func healthServer(port string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr: ":" + port,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/go/health", "/go/health/live", "/go/health/startup", "/go/ready":
+				h.ServeHTTP(w, r)
+			default:
+				writeError(w, http.StatusNotFound, codeNotFound, "only the /go/health probes and /go/ready are served on this port")
+			}
+		}),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the mTLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var testSerial int64
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(testSerial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a leaf certificate for tmpl's names, for a client or, with ips, a server.
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate, ips ...net.IP) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	tmpl.SerialNumber = big.NewInt(testSerial)
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if len(ips) > 0 {
+		tmpl.IPAddresses, tmpl.ExtKeyUsage = ips, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestMTLSClientIdentity(t *testing.T) {
+	ca := newTestCA(t, "test ca")
+	spiffe, _ := url.Parse("spiffe://partner.example/billing")
+	certs := map[string]*x509.Certificate{
+		"cn only":     ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "batch-runner"}}).Leaf,
+		"dns and cn":  ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ignored"}, DNSNames: []string{"api.partner.example"}}).Leaf,
+		"uri":         ca.issue(t, &x509.Certificate{URIs: []*url.URL{spiffe}}).Leaf,
+		"second name": ca.issue(t, &x509.Certificate{DNSNames: []string{"new.partner.example", "api.partner.example"}}).Leaf,
+		"unknown":     ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}).Leaf,
+		"no names":    ca.issue(t, &x509.Certificate{}).Leaf,
+	}
+	file := filepath.Join(t.TempDir(), "clients.json")
+	if err := os.WriteFile(file, []byte(`[
+		{"name": "api.partner.example", "id": "partner", "tier": "pro"},
+		{"name": "spiffe://partner.example/billing", "tier": "pro"},
+		{"name": "batch-runner"}
+	]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := loadMTLSClients(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anyCert, _ := loadMTLSClients("")
+	tests := []struct {
+		name    string
+		clients *mtlsClients
+		cert    string
+		id      identity
+		ok      bool
+	}{
+		{"cn", listed, "cn only", identity{KeyID: "mtls:batch-runner", Tier: tierFree}, true},
+		{"dns before cn", listed, "dns and cn", identity{KeyID: "partner", Tier: tierPro}, true},
+		{"uri", listed, "uri", identity{KeyID: "mtls:spiffe://partner.example/billing", Tier: tierPro}, true},
+		{"first listed name", listed, "second name", identity{KeyID: "partner", Tier: tierPro}, true},
+		{"not listed", listed, "unknown", identity{}, false},
+		{"no names", listed, "no names", identity{}, false},
+		{"no file", anyCert, "dns and cn", identity{KeyID: "mtls:api.partner.example", Tier: tierFree}, true},
+		{"no file, no names", anyCert, "no names", identity{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, _, ok := tt.clients.identity(certs[tt.cert])
+			if ok != tt.ok || id.KeyID != tt.id.KeyID || id.Tier != tt.id.Tier {
+				t.Fatalf("identity %+v, %v; want %+v, %v", id, ok, tt.id, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLoadMTLSClients(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		ok   bool
+	}{
+		{"valid", `[{"name":"a","tier":"pro"},{"name":"b"}]`, true},
+		{"no name", `[{"id":"x"}]`, false},
+		{"bad tier", `[{"name":"a","tier":"gold"}]`, false},
+		{"duplicate", `[{"name":"a"},{"name":"a","tier":"pro"}]`, false},
+		{"not a list", `{"name":"a"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "clients.json")
+			if err := os.WriteFile(file, []byte(tt.json), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadMTLSClients(file); (err == nil) != tt.ok {
+				t.Fatalf("err %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+// TestMTLSListener serves the API over TLS with MTLS_CLIENT_CA_FILE and checks that callers
+// without a certificate from the CA are refused in the handshake, that the certificate sets the
+// caller's identity and tier, and that HEALTH_PORT answers only the probes.
+func TestMTLSListener(t *testing.T) {
+	dir := t.TempDir()
+	ca, rogue := newTestCA(t, "test ca"), newTestCA(t, "rogue ca")
+	srvCert := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "origin"}}, net.IPv4(127, 0, 0, 1))
+	write := func(name string, b []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	keyDER, err := x509.MarshalECPrivateKey(srvCert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, map[string]string{
+		"TLS_CERT_FILE":       write("cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srvCert.Certificate[0]})),
+		"TLS_KEY_FILE":        write("key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		"MTLS_CLIENT_CA_FILE": write("ca.pem", ca.pem),
+		"MTLS_CLIENTS_FILE":   write("clients.json", []byte(`[{"name":"api.partner.example","id":"partner","tier":"pro"},{"name":"batch-runner"}]`)),
+	}, Deps{})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.serveHTTP(ln)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name   string
+		cert   *tls.Certificate
+		status int // 0 for a refused handshake
+		keyID  string
+		tier   string
+	}{
+		{"no certificate", nil, 0, "", ""},
+		{"other ca", ptr(rogue.issue(t, &x509.Certificate{DNSNames: []string{"api.partner.example"}})), 0, "", ""},
+		{"listed pro", ptr(ca.issue(t, &x509.Certificate{DNSNames: []string{"api.partner.example"}})), http.StatusOK, "partner", tierPro},
+		{"listed by cn", ptr(ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "batch-runner"}})), http.StatusOK, "mtls:batch-runner", tierFree},
+		{"not listed", ptr(ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}})), http.StatusForbidden, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &tls.Config{RootCAs: roots}
+			if tt.cert != nil {
+				tc.Certificates = []tls.Certificate{*tt.cert}
+			}
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: tc}, Timeout: 5 * time.Second}
+			defer c.CloseIdleConnections()
+			resp, err := c.Get("https://" + ln.Addr().String() + "/go/usage")
+			if tt.status == 0 {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("status %d, want the handshake refused", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var got struct {
+				Usage usageReport `json:"usage"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Usage.KeyID != tt.keyID || got.Usage.Tier != tt.tier {
+				t.Fatalf("caller %q (%s), want %q (%s)", got.Usage.KeyID, got.Usage.Tier, tt.keyID, tt.tier)
+			}
+		})
+	}
+
+	health := healthServer("0", s.startup).Handler
+	for path, status := range map[string]int{
+		"/go/health":       http.StatusOK,
+		"/go/health/live":  http.StatusOK,
+		"/go/translate":    http.StatusNotFound,
+		"/go/admin/config": http.StatusNotFound,
+	} {
+		if w := serve(health, "GET", path+"?q=hello", ""); w.Code != status {
+			t.Fatalf("health port %s: status %d, want %d", path, w.Code, status)
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	mediaJSON  = "application/json"
+	mediaPlain = "text/plain"
+)
+
+// negotiate picks the offer the Accept header prefers. Each offer gets the q of the most
+// specific range that matches it (exact, then type/*, then */*); the highest q wins, and ties
+// go to the earlier offer. An empty header takes the first offer. ok is false when every
+// offer is refused.
+func negotiate(accept string, offers ...string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+	ranges := parseAccept(accept)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQ(ranges, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, best != ""
+}
+
+type mediaRange struct {
+	typ, sub string
+	q        float64
+}
+
+func parseAccept(accept string) []mediaRange {
+	var out []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		typ, sub, ok := strings.Cut(mt, "/")
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+				q = f
+			}
+		}
+		out = append(out, mediaRange{typ: typ, sub: sub, q: q})
+	}
+	return out
+}
+
+// acceptQ is the q the most specific matching range gives offer; 0 when none matches.
+func acceptQ(ranges []mediaRange, offer string) float64 {
+	typ, sub, _ := strings.Cut(offer, "/")
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.typ == typ && r.sub == sub:
+			s = 2
+		case r.typ == typ && r.sub == "*":
+			s = 1
+		case r.typ == "*" && r.sub == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+// notAcceptable answers a 406 listing the types the route can produce. Like every error it is
+// JSON, which RFC 9110 allows even though the client didn't ask for it.
+func notAcceptable(w http.ResponseWriter, offers ...string) {
+	sorted := append([]string(nil), offers...)
+	sort.Strings(sorted)
+	writeError(w, http.StatusNotAcceptable, codeNotAcceptable, "not acceptable; available types: "+strings.Join(sorted, ", "), "available", sorted)
+}
+
+func writePlain(w http.ResponseWriter, code int, line string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(line)+1))
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(line + "\n"))
+}
+
+// plainErrors turns the JSON error bodies handlers write through writeError into one-line plain
+// text, keeping the status and headers, for clients that asked for text/plain. Responses that
+// aren't JSON pass through untouched. finish must run once the handler returns.
+type plainErrors struct {
+	http.ResponseWriter
+	code int
+	buf  *bytes.Buffer // set while a JSON response is being held back
+}
+
+func (w *plainErrors) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	if mt, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mt == mediaJSON {
+		w.buf = &bytes.Buffer{}
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *plainErrors) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buf != nil {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *plainErrors) finish() {
+	if w.buf == nil {
+		return
+	}
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Detail  string `json:"detail"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(w.buf.Bytes(), &body)
+	line := body.Error.Message
+	if body.Error.Detail != "" {
+		line += ": " + body.Error.Detail
+	}
+	if line == "" {
+		line = http.StatusText(w.code)
+	}
+	writePlain(w.ResponseWriter, w.code, strings.ReplaceAll(line, "\n", " "))
+}
+
+func (w *plainErrors) Unwrap() http.ResponseWriter { return w.ResponseWriter }
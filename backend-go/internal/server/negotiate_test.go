@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string // empty when nothing is acceptable
+	}{
+		{"", mediaJSON},
+		{"*/*", mediaJSON},
+		{"text/plain", mediaPlain},
+		{"text/*", mediaPlain},
+		{"text/plain, application/json", mediaJSON}, // a tie goes to the earlier offer
+		{"application/json;q=0.5, text/plain", mediaPlain},
+		{"text/plain;q=0.2, */*;q=0.1", mediaPlain},
+		{"*/*;q=0.8, application/json;q=0", mediaPlain}, // the exact range beats the wildcard
+		{"text/*;q=0.9, text/plain;q=0, application/json;q=0.3", mediaJSON},
+		{"application/xml", ""},
+		{"text/html, image/*", ""},
+		{"text/plain;q=0", ""},
+		{"nonsense", ""},
+	}
+	for _, tt := range tests {
+		got, ok := negotiate(tt.accept, mediaJSON, mediaPlain)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("negotiate(%q) = %q, %v; want %q", tt.accept, got, ok, tt.want)
+		}
+	}
+}
+
+// TestTranslatePlainText asks /go/translate for text/plain: the translation alone, errors as one
+// line with their status, and a 406 listing both types for anything else.
+func TestTranslatePlainText(t *testing.T) {
+	h := newTestServer(t, nil, Deps{}).Handler()
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		status int
+		ctype  string
+		body   string // for a plain-text answer
+	}{
+		{"plain", "/go/translate?q=salaam", "text/plain", http.StatusOK, "text/plain; charset=utf-8", "EN(salaam)\n"},
+		{"preferred by q", "/go/translate?q=salaam", "application/json;q=0.4, text/*;q=0.9", http.StatusOK, "text/plain; charset=utf-8", "EN(salaam)\n"},
+		{"plain error", "/go/translate", "text/plain", http.StatusBadRequest, "text/plain; charset=utf-8", "missing query param 'q'\n"},
+		{"json by default", "/go/translate?q=salaam", "", http.StatusOK, "application/json", ""},
+		{"wildcard", "/go/translate?q=salaam", "*/*", http.StatusOK, "application/json", ""},
+		{"unsupported", "/go/translate?q=salaam", "application/xml", http.StatusNotAcceptable, "application/json", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := []string{"X-API-Key", testProKey}
+			if tt.accept != "" {
+				headers = append(headers, "Accept", tt.accept)
+			}
+			w := serve(h, "GET", tt.target, "", headers...)
+			if w.Code != tt.status || w.Header().Get("Content-Type") != tt.ctype || w.Header().Get("Vary") == "" {
+				t.Fatalf("status %d, Content-Type %q, Vary %q; want %d, %q", w.Code, w.Header().Get("Content-Type"), w.Header().Get("Vary"), tt.status, tt.ctype)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Fatalf("body %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+
+	w := serve(h, "GET", "/go/translate?q=salaam", "", "X-API-Key", testProKey, "Accept", "application/xml")
+	checkEnvelope(t, w)
+	var res struct {
+		Error struct {
+			Code      errorCode `json:"code"`
+			Available []string  `json:"available"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Error.Code != codeNotAcceptable || len(res.Error.Available) != 2 || res.Error.Available[0] != mediaJSON || res.Error.Available[1] != mediaPlain {
+		t.Fatalf("406 %+v, want %s listing both types", res.Error, codeNotAcceptable)
+	}
+}
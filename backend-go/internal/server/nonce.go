@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// nonceStore remembers X-Edge-Nonce values until the signed request carrying them could no
+// longer pass the timestamp check, so a captured request can't be replayed within the window.
+type nonceStore interface {
+	// claim records nonce until expires, reporting false when it was already recorded.
+	claim(ctx context.Context, nonce string, expires time.Time) (bool, error)
+}
+
+// errNonceStoreFull is returned while the memory store holds its cap of live nonces.
+var errNonceStoreFull = errors.New("nonce store full")
+
+const nonceShards = 16
+
+// memoryNonces is the in-process nonceStore, sharded so concurrent requests rarely share a lock.
+// It holds at most max live nonces; past that it refuses new ones rather than forget live ones,
+// which would reopen the replay window.
+type memoryNonces struct {
+	seed   maphash.Seed
+	max    int // per shard
+	clock  Clock
+	shards [nonceShards]nonceShard
+}
+
+type nonceShard struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // nonce -> when it may be forgotten
+}
+
+func newMemoryNonces(max int, clk Clock) *memoryNonces {
+	s := &memoryNonces{seed: maphash.MakeSeed(), max: max/nonceShards + 1, clock: clk}
+	for i := range s.shards {
+		s.shards[i].seen = make(map[string]time.Time)
+	}
+	return s
+}
+
+func (s *memoryNonces) claim(_ context.Context, nonce string, expires time.Time) (bool, error) {
+	sh := &s.shards[maphash.String(s.seed, nonce)%nonceShards]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	now := s.clock.Now()
+	if exp, ok := sh.seen[nonce]; ok && now.Before(exp) {
+		return false, nil
+	}
+	if len(sh.seen) >= s.max {
+		sh.gc(now)
+		if len(sh.seen) >= s.max {
+			return false, errNonceStoreFull
+		}
+	}
+	sh.seen[nonce] = expires
+	return true, nil
+}
+
+// gc must be called with mu held.
+func (sh *nonceShard) gc(now time.Time) {
+	for n, exp := range sh.seen {
+		if !now.Before(exp) {
+			delete(sh.seen, n)
+		}
+	}
+}
+
+// run drops expired nonces every interval until ctx is done.
+func (s *memoryNonces) run(ctx context.Context, interval time.Duration) {
+	t := s.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C():
+			for i := range s.shards {
+				sh := &s.shards[i]
+				sh.mu.Lock()
+				sh.gc(now)
+				sh.mu.Unlock()
+			}
+		}
+	}
+}
+
+// redisNonces shares seen nonces across instances, so a request replayed to another machine is
+// caught too. Redis expires the keys itself.
+type redisNonces struct {
+	rdb       *redis.Client
+	opTimeout time.Duration
+	clock     Clock
+}
+
+const redisNoncePrefix = "dhk:nonce:"
+
+func (s *redisNonces) claim(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opTimeout)
+	defer cancel()
+	return s.rdb.SetNX(ctx, redisNoncePrefix+nonce, 1, max(expires.Sub(s.clock.Now()), time.Second)).Result()
+}
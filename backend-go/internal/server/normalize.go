@@ -0,0 +1,91 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeText canonicalizes translate input so visually identical phrases share a cache entry
+// and reach the upstream in one form: NFC, zero-width and bidi control characters removed (but
+// for a joiner inside an emoji sequence, which is part of the emoji), whitespace runs collapsed to
+// one space, ends trimmed. ok is false for invalid UTF-8. U+FFFD is
+// treated as invalid too, because encoding/json substitutes it for bad bytes in JSON bodies.
+func normalizeText(s string) (_ string, ok bool) {
+	if !utf8.ValidString(s) || strings.ContainsRune(s, utf8.RuneError) {
+		return "", false
+	}
+	s = norm.NFC.String(s)
+	var b strings.Builder
+	b.Grow(len(s))
+	space := false
+	var last rune // the last rune written
+	for i, r := range s {
+		switch {
+		case r == '\u200D' && (emojiRune(last) || emojiJoiner(last)) && emojiRune(firstRune(s[i+len("\u200D"):])):
+			// joins two emoji into one, so it stays
+		case invisibleControl(r):
+			continue
+		case unicode.IsSpace(r):
+			space, last = b.Len() > 0, ' '
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+		last = r
+	}
+	return b.String(), true
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+// invisibleControl reports zero-width and bidirectional formatting characters, which change
+// nothing visible but make byte-wise different strings.
+func invisibleControl(r rune) bool {
+	switch {
+	case r == '\u200B', r == '\u200C', r == '\u200D', r == '\u2060', r == '\uFEFF': // zero-width
+		return true
+	case r == '\u200E', r == '\u200F', r == '\u061C': // LRM, RLM, ALM
+		return true
+	case r >= '\u202A' && r <= '\u202E', r >= '\u2066' && r <= '\u2069': // embeddings, isolates
+		return true
+	}
+	return false
+}
+
+// Script names reported as detected_script.
+const (
+	scriptThaana  = "thaana"
+	scriptLatin   = "latin"
+	scriptUnknown = "unknown"
+)
+
+// detectScript reports whether the letters in s are predominantly Thaana (U+0780–U+07BF) or Latin.
+// Ties and text with neither are "unknown".
+func detectScript(s string) string {
+	var thaana, latin int
+	for _, r := range s {
+		switch {
+		case r >= 0x0780 && r <= 0x07BF:
+			thaana++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	switch {
+	case thaana > latin:
+		return scriptThaana
+	case latin > thaana:
+		return scriptLatin
+	default:
+		return scriptUnknown
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+		ok   bool
+	}{
+		{"plain", "hello", "hello", true},
+		{"empty", "", "", true},
+		{"nfd to nfc", "cafe\u0301", "café", true},
+		{"nfc kept", "café", "café", true},
+		{"thaana with fili", "ކިހިނެއް", "ކިހިނެއް", true},
+		{"zwj inside thaana", "ކިހި\u200Dނެއް", "ކިހިނެއް", true},
+		{"zwj inside an emoji", "👨\u200D👩\u200D👧 \u200D👍", "👨\u200D👩\u200D👧 👍", true},
+		{"zwnj", "ޝުކު\u200Cރިއްޔާ", "ޝުކުރިއްޔާ", true},
+		{"zero-width space and word joiner", "good\u200B\u2060bye", "goodbye", true},
+		{"leading bom", "\uFEFFhello", "hello", true},
+		{"rlm and alm", "\u200Fކިހިނެއް\u061C", "ކިހިނެއް", true},
+		{"embedding and isolate", "\u202Bhello\u202C \u2067world\u2069", "hello world", true},
+		{"whitespace runs", "  good \t\n morning  ", "good morning", true},
+		{"no-break and ideographic space", "good\u00A0\u3000morning", "good morning", true},
+		{"only controls", "\u200B\u200D\uFEFF", "", true},
+		{"invalid utf-8", "hel\xfflo", "", false},
+		{"truncated sequence", "ކ\xde", "", false},
+		{"replacement character", "hel\uFFFDlo", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := normalizeText(tt.in)
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("normalizeText(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDetectScript(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ކިހިނެއް ތިބެނީ", scriptThaana},
+		{"hello there", scriptLatin},
+		{"déjà vu", scriptLatin},
+		{"ކިހިނެއް ok", scriptThaana}, // more Thaana letters than Latin
+		{"hello ކ", scriptLatin},
+		{"ab ކި", scriptUnknown}, // a fili is Thaana too: two each
+		{"12 345 !?", scriptUnknown},
+		{"", scriptUnknown},
+		{"مرحبا", scriptUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := detectScript(tt.in); got != tt.want {
+				t.Fatalf("detectScript(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizedQueryOnRoute(t *testing.T) {
+	up := &echoUpstream{}
+	h := newTestServer(t, nil, Deps{Upstream: up}).Handler()
+	type response struct {
+		Translation    string `json:"translation"`
+		Cached         bool   `json:"cached"`
+		DetectedScript string `json:"detected_script"`
+	}
+	get := func(q string) (*httptest.ResponseRecorder, response) {
+		w := serve(h, "GET", "/go/translate?q="+url.QueryEscape(q), "", "X-API-Key", testProKey)
+		var res response
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w, res
+	}
+	t.Run("invalid utf-8", func(t *testing.T) {
+		if w := serve(h, "GET", "/go/translate?q=hel%FFlo", "", "X-API-Key", testProKey); w.Code != http.StatusBadRequest {
+			t.Fatalf("status %d, want 400: %s", w.Code, w.Body.String())
+		}
+	})
+	tests := []struct {
+		name   string
+		first  string
+		second string
+		script string
+	}{
+		{"nfd and nfc", "cafe\u0301 noir", "café  noir", scriptLatin},
+		{"zero-width joiner", "ކިހި\u200Dނެއް", "ކިހިނެއް", scriptThaana},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := up.calls.Load()
+			w, first := get(tt.first)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+			_, second := get(tt.second)
+			if !second.Cached || second.Translation != first.Translation {
+				t.Fatalf("second spelling %+v, want the first's cached %q", second, first.Translation)
+			}
+			if got := up.calls.Load() - before; got != 1 {
+				t.Fatalf("%d upstream calls, want 1", got)
+			}
+			if first.DetectedScript != tt.script {
+				t.Fatalf("detected_script %q, want %q", first.DetectedScript, tt.script)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// fullTestServer is a test server with every optional route group switched on, proxying to an
+// upstream nothing listens on.
+func fullTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	return newTestServer(t, map[string]string{
+		"ENABLE_DEBUG":          "1",
+		"ENABLE_API_DOCS":       "1",
+		"JOBS_DB_PATH":          filepath.Join(dir, "jobs.db"),
+		"USAGE_DB_PATH":         filepath.Join(dir, "usage.db"),
+		"TM_DB_PATH":            filepath.Join(dir, "tm.db"),
+		"STRIPE_WEBHOOK_SECRET": "whsec_test",
+		"UPSTREAM_URL":          "http://127.0.0.1:1",
+	}, Deps{})
+}
+
+// goRoutes is every "METHOD /go/..." route on s's router.
+func goRoutes(t *testing.T, s *Server) []string {
+	t.Helper()
+	routes, ok := s.Handler().(chi.Routes)
+	if !ok {
+		t.Fatalf("handler is a %T, not a chi router", s.Handler())
+	}
+	var out []string
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, "/go/") {
+			out = append(out, method+" "+route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// TestOpenAPIMatchesRoutes fails when a route is missing from the document or apiOps, or when
+// either documents a route that isn't registered.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	s := fullTestServer(t)
+	w := serve(s.Handler(), "GET", "/go/openapi.json", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	documented := map[string]bool{}
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	registered := map[string]bool{}
+	for _, key := range goRoutes(t, s) {
+		registered[key] = true
+		method, route, _ := strings.Cut(key, " ")
+		want := []string{key}
+		if version, _ := splitAPIVersion(route); version == apiLegacy {
+			want = append(want, method+" /go/"+apiV1+strings.TrimPrefix(route, "/go"))
+		}
+		for _, k := range want {
+			if !documented[k] {
+				t.Errorf("%s is routed but not in the document", k)
+			}
+			delete(documented, k)
+		}
+		if _, ok := apiOps[key]; !ok {
+			t.Errorf("%s is routed but has no apiOps entry", key)
+		}
+	}
+	for k := range documented {
+		t.Errorf("%s is in the document but not routed", k)
+	}
+	for k := range apiOps {
+		if !registered[k] {
+			t.Errorf("apiOps documents %s, which isn't routed", k)
+		}
+	}
+}
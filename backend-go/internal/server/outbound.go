@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/tls"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// Outbound HTTP. The upstreams, the JWKS fetch and error reports all go through one transport
+// built from the HTTP_* settings, so calls to the FastAPI backend share a pool sized for them
+// instead of each client keeping the default transport's two idle connections per host, which
+// caps throughput well below what the backend serves. Job callbacks are the one exception, with a
+// client and a stricter egress policy of their own (see newCallbackClient). The transport
+// enforces the egress policy on every request and every dial (see egress.go), and ignores
+// HTTP(S)_PROXY, since a proxy would dial addresses the policy never sees.
+
+// outboundOpts configures newOutboundTransport.
+type outboundOpts struct {
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int // 0 is unlimited
+	IdleConnTimeout       time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration // 0 is none
+	HTTP2                 bool
+	DisableKeepAlives     bool
+}
+
+func outboundOptsFrom(cfg Config) outboundOpts {
+	return outboundOpts{
+		MaxIdleConnsPerHost:   cfg.HTTPMaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.HTTPMaxConnsPerHost,
+		IdleConnTimeout:       cfg.HTTPIdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.HTTPTLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.HTTPResponseHeaderTimeout,
+		HTTP2:                 cfg.HTTP2,
+		DisableKeepAlives:     cfg.HTTPDisableKeepAlives,
+	}
+}
+
+// newOutboundTransport builds the shared transport. Clients wrap it with their own timeouts.
+func newOutboundTransport(o outboundOpts, egress *egressPolicy) http.RoundTripper {
+	t := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           egress.dialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}),
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       o.MaxConnsPerHost,
+		IdleConnTimeout:       o.IdleConnTimeout,
+		TLSHandshakeTimeout:   o.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: o.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableKeepAlives:     o.DisableKeepAlives,
+		ForceAttemptHTTP2:     o.HTTP2,
+	}
+	if !o.HTTP2 {
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{} // non-nil and empty turns HTTP/2 off
+	}
+	return &pooledTransport{next: t, egress: egress}
+}
+
+// poolStats counts how outbound requests got their connection, for /go/stats.
+type poolStats struct {
+	reused    atomic.Uint64 // an idle or in-use connection from the pool
+	dialed    atomic.Uint64 // a new connection
+	idleNanos atomic.Uint64 // total time reused connections sat idle first
+	inFlight  atomic.Int64  // requests sent and not yet done with: until the body is closed
+}
+
+// pooledTransport checks each request's URL against the egress policy, redirects included, and
+// records in stats.outbound whether its connection was reused.
+type pooledTransport struct {
+	next   *http.Transport
+	egress *egressPolicy
+}
+
+func (t *pooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.egress.checkURL(req.URL); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, t.egress.denied(err)
+	}
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		if !info.Reused {
+			stats.outbound.dialed.Add(1)
+			return
+		}
+		stats.outbound.reused.Add(1)
+		if info.WasIdle {
+			stats.outbound.idleNanos.Add(uint64(info.IdleTime))
+		}
+	}}
+	stats.outbound.inFlight.Add(1)
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		stats.outbound.inFlight.Add(-1)
+		return nil, err
+	}
+	resp.Body = &inFlightBody{ReadCloser: resp.Body}
+	return resp, nil
+}
+
+// inFlightBody takes its request off stats.outbound.inFlight when it is closed.
+type inFlightBody struct {
+	io.ReadCloser
+	done atomic.Bool
+}
+
+func (b *inFlightBody) Close() error {
+	if b.done.CompareAndSwap(false, true) {
+		stats.outbound.inFlight.Add(-1)
+	}
+	return b.ReadCloser.Close()
+}
+
+// CloseIdleConnections closes the pool's idle connections; an upstream reload calls it so none
+// are kept to a host that is gone.
+func (t *pooledTransport) CloseIdleConnections() { t.next.CloseIdleConnections() }
+
+// drainClose reads what is left of an outbound response body, up to a limit, before closing it:
+// the transport only returns a connection to the pool once its body has been read to the end.
+func drainClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	body.Close()
+}
+
+// summary is the outbound block of /go/stats.
+func (s *poolStats) summary() map[string]any {
+	reused, dialed := s.reused.Load(), s.dialed.Load()
+	out := map[string]any{"reused": reused, "dialed": dialed, "reuse_ratio": 0.0, "in_flight": s.inFlight.Load()}
+	if total := reused + dialed; total > 0 {
+		out["reuse_ratio"] = math.Round(float64(reused)/float64(total)*1000) / 1000
+	}
+	if reused > 0 {
+		out["avg_idle_ms"] = math.Round(float64(s.idleNanos.Load())/float64(reused)/1e3) / 1e3
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// consonantWord spells n in consonants only, so no spelling rule folds two of them together.
+func consonantWord(n int) string {
+	const letters = "bcdfghjklmnpqrstvwxz"
+	b := []byte{'w'}
+	for ; n > 0; n /= len(letters) {
+		b = append(b, letters[n%len(letters)])
+	}
+	return string(b)
+}
+
+// poolLoad sends calls unique translations through a server over ws workers, against an upstream
+// taking 2ms a call, and returns the connections the upstream accepted and the rate achieved.
+func poolLoad(t *testing.T, env map[string]string, workers, calls int) (conns int64, rps float64) {
+	t.Helper()
+	var accepted atomic.Int64
+	up := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "data": map[string]string{"tgt": "T"}})
+	}))
+	up.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			accepted.Add(1)
+		}
+	}
+	up.Start()
+	defer up.Close()
+	vars := map[string]string{"UPSTREAM_URL": up.URL, "UPSTREAM_MAX_ATTEMPTS": "1", "RATE_LIMIT_PRO_PER_MIN": "1000000", "RATE_LIMIT_PRO_BURST": "1000000", "KEY_CONCURRENCY_PRO": "0", "MAX_CONCURRENCY": "256"}
+	for k, v := range env {
+		vars[k] = v
+	}
+	h := newTestServer(t, vars, Deps{}).Handler()
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := int(next.Add(1)); n <= calls; n = int(next.Add(1)) {
+				if w := serve(h, "GET", "/go/translate?src=dv&dst=en&q="+consonantWord(n), "", "X-API-Key", testProKey); w.Code != http.StatusOK {
+					t.Errorf("call %d: status %d: %s", n, w.Code, w.Body.String())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return accepted.Load(), float64(calls) / time.Since(start).Seconds()
+}
+
+// TestOutboundPool runs the same load over the default transport settings' two idle connections
+// per host and the shared transport's 64: the small pool throws most connections away and the
+// tuned one keeps the ones it opened. The rates are logged; the fewer dials are what lifts them.
+func TestOutboundPool(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+	const workers, calls = 32, 1600
+	before := stats.outbound.summary()
+	small, smallRPS := poolLoad(t, map[string]string{"HTTP_MAX_IDLE_CONNS_PER_HOST": "2"}, workers, calls)
+	tuned, tunedRPS := poolLoad(t, nil, workers, calls)
+	t.Logf("idle per host 2: %d connections, %.0f rps; 64: %d connections, %.0f rps", small, smallRPS, tuned, tunedRPS)
+	if tuned > workers+4 {
+		t.Fatalf("%d upstream connections for %d workers with the tuned pool, want them kept and reused", tuned, workers)
+	}
+	if small < 4*tuned {
+		t.Fatalf("%d connections with 2 idle per host against %d with 64, want the small pool dialing far more", small, tuned)
+	}
+
+	after := stats.outbound.summary()
+	reused := after["reused"].(uint64) - before["reused"].(uint64)
+	dialed := after["dialed"].(uint64) - before["dialed"].(uint64)
+	if reused+dialed < 2*calls || dialed == 0 || after["in_flight"].(int64) != 0 {
+		t.Fatalf("outbound_conns moved by %d reused, %d dialed, %v in flight over %d calls", reused, dialed, after["in_flight"], 2*calls)
+	}
+}
+
+// TestOutboundTransport checks the settings reach the shared transport and /go/stats reports
+// its connections.
+func TestOutboundTransport(t *testing.T) {
+	egress := testEgressPolicy(t, nil)
+	rt := newOutboundTransport(outboundOpts{
+		MaxIdleConnsPerHost:   16,
+		MaxConnsPerHost:       8,
+		IdleConnTimeout:       30 * time.Second,
+		TLSHandshakeTimeout:   2 * time.Second,
+		ResponseHeaderTimeout: 3 * time.Second,
+		DisableKeepAlives:     true,
+	}, egress).(*pooledTransport)
+	tr := rt.next
+	if tr.MaxIdleConnsPerHost != 16 || tr.MaxConnsPerHost != 8 || tr.IdleConnTimeout != 30*time.Second || tr.TLSHandshakeTimeout != 2*time.Second ||
+		tr.ResponseHeaderTimeout != 3*time.Second || !tr.DisableKeepAlives || tr.ForceAttemptHTTP2 || tr.TLSNextProto == nil || tr.Proxy != nil {
+		t.Fatalf("transport %+v", tr)
+	}
+	if tr := newOutboundTransport(outboundOpts{HTTP2: true}, egress).(*pooledTransport).next; !tr.ForceAttemptHTTP2 || tr.TLSNextProto != nil {
+		t.Fatal("HTTP2 didn't leave HTTP/2 on")
+	}
+
+	h := newTestServer(t, nil, Deps{}).Handler()
+	w := serve(h, "GET", "/go/stats", "", "Authorization", "Bearer "+testAdmin)
+	var out map[string]any
+	json.Unmarshal(w.Body.Bytes(), &out)
+	conns, _ := out["outbound_conns"].(map[string]any)
+	for _, k := range []string{"reused", "dialed", "reuse_ratio", "in_flight"} {
+		if _, ok := conns[k]; !ok {
+			t.Fatalf("/go/stats outbound_conns %v without %s (status %d)", conns, k, w.Code)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Pack curation check. POST /go/admin/packs/check takes candidate source phrases, as a JSON
+// array or NDJSON, and says for each whether the packs (base and pro) or the translation memory
+// already answer it, and if not, the closest phrase they hold. A candidate is normalized and
+// looked up exactly as /go/translate would, so "covered" means the service would answer it;
+// the nearest match comes from the suggestion trigram indexes, built on the first check when
+// pack_only hasn't built them already. Nothing is translated or changed, so a list can be
+// checked as often as needed.
+
+// packCheckOpts bounds one check request.
+type packCheckOpts struct {
+	MaxItems      int
+	MaxBodyBytes  int64
+	MinSimilarity float64 // the nearest match must be at least this similar
+}
+
+// packCheckItem is one candidate: a plain string, or {"q", "src", "dst"}.
+type packCheckItem struct {
+	Q   string `json:"q"`
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+func (it *packCheckItem) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &it.Q)
+	}
+	type plain packCheckItem
+	return json.Unmarshal(b, (*plain)(it))
+}
+
+// packCheckMatch is the entry a covered candidate hit.
+type packCheckMatch struct {
+	Source      string `json:"source"` // as written in the pack or memory
+	Translation string `json:"translation"`
+	From        string `json:"from"` // pack file name, or "tm"
+	Kind        string `json:"kind"` // exact, or normalized when it matched by canonicalKey spelling rules
+	Corrected   bool   `json:"corrected,omitempty"`
+}
+
+// packCheckNearest is the most similar phrase to a candidate that isn't covered.
+type packCheckNearest struct {
+	Source      string  `json:"source"`
+	Translation string  `json:"translation"`
+	From        string  `json:"from"`
+	Similarity  float64 `json:"similarity"` // 0..1, trigram Dice
+}
+
+// packCheckResult is one candidate's answer; Index is its position in the request. Match and
+// Nearest are always present, null when there is none, so every line has the same fields.
+type packCheckResult struct {
+	Index      int               `json:"index"`
+	Q          string            `json:"q"`
+	Normalized string            `json:"normalized"`
+	Src        string            `json:"src"`
+	Dst        string            `json:"dst"`
+	Covered    bool              `json:"covered"`
+	Match      *packCheckMatch   `json:"match"`
+	Nearest    *packCheckNearest `json:"nearest"`
+	Error      string            `json:"error,omitempty"`
+}
+
+type packCheckCounts struct {
+	Covered int `json:"covered"`
+	Near    int `json:"near"` // not covered, with a nearest match
+	New     int `json:"new"`  // not covered, nothing near
+	Invalid int `json:"invalid"`
+}
+
+func (c *packCheckCounts) add(res packCheckResult) {
+	switch {
+	case res.Error != "":
+		c.Invalid++
+	case res.Covered:
+		c.Covered++
+	case res.Nearest != nil:
+		c.Near++
+	default:
+		c.New++
+	}
+}
+
+// packCheckDone is the last NDJSON line.
+type packCheckDone struct {
+	Done bool `json:"done"`
+	packCheckCounts
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// packCheckFlushEvery is how many NDJSON lines go out between flushes.
+const packCheckFlushEvery = 100
+
+// packCheckHandler serves POST /go/admin/packs/check. The body is a JSON array, or with
+// Content-Type: application/x-ndjson one candidate per line; ?src= and ?dst= are the direction
+// of candidates that don't give one. With Accept: application/x-ndjson each result is a line,
+// in input order, then a packCheckDone line; otherwise the results come back together. A
+// candidate without src has it detected as on /go/translate. packs and tm may be nil.
+func packCheckHandler(svc *translateService, packs *packSet, tm *translationMemory, opts packCheckOpts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, herr := readPackCheckItems(r, opts.MaxItems)
+		if herr != nil {
+			herr.write(w)
+			return
+		}
+		if len(items) == 0 {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "no phrases given")
+			return
+		}
+		mt, ok := negotiate(r.Header.Get("Accept"), mediaJSON, "application/x-ndjson")
+		if !ok {
+			notAcceptable(w, mediaJSON, "application/x-ndjson")
+			return
+		}
+		auditParam(r.Context(), "phrases", len(items))
+
+		start := time.Now()
+		ctx := r.Context()
+		if tm != nil {
+			if err := tm.indexForSuggestions(ctx); err != nil {
+				writeError(w, http.StatusServiceUnavailable, codeInternal, "translation memory unavailable", "detail", err.Error())
+				return
+			}
+		}
+		c := packChecker{svc: svc, tm: tm, min: opts.MinSimilarity}
+		if packs != nil {
+			c.ix = packs.current().searchable()
+		}
+		q := r.URL.Query()
+		check := func(i int) packCheckResult {
+			it := items[i]
+			if it.Src == "" && it.Dst == "" {
+				it.Src, it.Dst = q.Get("src"), q.Get("dst")
+			}
+			return c.check(ctx, i, it)
+		}
+
+		var counts packCheckCounts
+		if mt == "application/x-ndjson" {
+			rc := http.NewResponseController(w)
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			enc := json.NewEncoder(w)
+			var writeErr error
+			for i := range items {
+				if ctx.Err() != nil || writeErr != nil {
+					break
+				}
+				res := check(i)
+				counts.add(res)
+				if writeErr = enc.Encode(res); writeErr == nil && (i+1)%packCheckFlushEvery == 0 {
+					writeErr = rc.Flush()
+				}
+			}
+			done := packCheckDone{Done: true, packCheckCounts: counts, DurationMS: ms(time.Since(start))}
+			if err := ctx.Err(); err != nil {
+				done.Error = ctxErrMsg(err)
+			}
+			if writeErr == nil {
+				_ = enc.Encode(done)
+			}
+		} else {
+			out := make([]packCheckResult, len(items))
+			for i := range items {
+				if ctx.Err() != nil {
+					writeTranslateError(ctx, w, ctx.Err())
+					return
+				}
+				out[i] = check(i)
+				counts.add(out[i])
+			}
+			j(w, http.StatusOK, map[string]any{
+				"results":     out,
+				"counts":      counts,
+				"duration_ms": ms(time.Since(start)),
+			})
+		}
+		auditParam(ctx, "counts", counts)
+		slog.Info("pack check", "admin_id", adminFrom(ctx), "phrases", len(items),
+			"covered", counts.Covered, "near", counts.Near, "new", counts.New, "invalid", counts.Invalid,
+			"duration", time.Since(start))
+	}
+}
+
+// readPackCheckItems reads the candidates from a JSON array or NDJSON body. A malformed line
+// rejects the upload, as for jobs.
+func readPackCheckItems(r *http.Request, maxItems int) ([]packCheckItem, *httpError) {
+	var items []packCheckItem
+	tooMany := &httpError{http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("too many phrases (max %d)", maxItems)}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/x-ndjson" {
+		if herr := decodeJSONBody(r, &items); herr != nil {
+			return nil, herr
+		}
+		if len(items) > maxItems {
+			return nil, tooMany
+		}
+		return items, nil
+	}
+	sc := bufio.NewScanner(r.Body)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var it packCheckItem
+		if err := json.Unmarshal(line, &it); err != nil {
+			return nil, &httpError{http.StatusBadRequest, codeBadRequest, fmt.Sprintf("line %d: invalid JSON", n)}
+		}
+		if items = append(items, it); len(items) > maxItems {
+			return nil, tooMany
+		}
+	}
+	if err := sc.Err(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &httpError{http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("request body too large (limit %d bytes)", tooLarge.Limit)}
+		}
+		return nil, &httpError{http.StatusBadRequest, codeBadRequest, "unreadable NDJSON body"}
+	}
+	return items, nil
+}
+
+// packChecker checks candidates against one pack index and the memory; either may be nil.
+type packChecker struct {
+	svc *translateService
+	ix  *packIndex
+	tm  *translationMemory
+	min float64
+}
+
+func (c packChecker) check(ctx context.Context, i int, it packCheckItem) packCheckResult {
+	res := packCheckResult{Index: i, Q: it.Q}
+	q, ok := normalizeText(it.Q)
+	switch {
+	case !ok:
+		res.Error = "invalid UTF-8"
+		return res
+	case q == "":
+		res.Error = "empty phrase"
+		return res
+	}
+	res.Normalized = q
+	if strings.TrimSpace(it.Src) == "" {
+		it.Src, _ = c.svc.detectSrc(q, it.Dst)
+	}
+	p, err := resolvePair(it.Src, it.Dst, c.svc.detect.Default)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Src, res.Dst = p.Src, p.Dst
+
+	if c.ix != nil {
+		if h, ok := c.ix.lookup(p, q, true); ok {
+			res.Covered = true
+			res.Match = &packCheckMatch{Source: h.source, Translation: h.translation, From: h.pack, Kind: "exact"}
+			if h.normalized {
+				res.Match.Kind = "normalized"
+			}
+			return res
+		}
+	}
+	if c.tm != nil {
+		e, ok, err := c.tm.lookup(ctx, p, q)
+		if err != nil {
+			res.Error = "translation memory read failed: " + err.Error()
+			return res
+		}
+		if ok {
+			res.Covered = true
+			res.Match = &packCheckMatch{Source: e.Source, Translation: e.Target, From: srcTM, Kind: "exact", Corrected: e.Corrected}
+			return res
+		}
+	}
+
+	grams := trigrams(q)
+	var found []suggestion
+	if c.ix != nil {
+		found = append(found, c.ix.fuzzy.search(p, grams, c.min)...)
+		found = append(found, c.ix.fuzzyExtended.search(p, grams, c.min)...)
+	}
+	if c.tm != nil {
+		found = append(found, c.tm.suggest(p, grams, c.min)...)
+	}
+	for _, s := range found {
+		if res.Nearest == nil || s.Similarity > res.Nearest.Similarity ||
+			s.Similarity == res.Nearest.Similarity && strings.ToLower(s.Q) < strings.ToLower(res.Nearest.Source) {
+			res.Nearest = &packCheckNearest{Source: s.Q, Translation: s.Translation, From: s.From, Similarity: s.Similarity}
+		}
+	}
+	if res.Nearest != nil {
+		res.Nearest.Similarity = math.Round(res.Nearest.Similarity*1000) / 1000
+	}
+	return res
+}
@@ -0,0 +1,530 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// packEntry is one line of a JSONL pack.
+type packEntry struct {
+	Src        string `json:"src"`
+	Dst        string `json:"dst"`
+	SourceText string `json:"source_text"`
+	TargetText string `json:"target_text"`
+
+	// Optional scoring metadata. Confidence is reported for normalized hits; an exact hit is
+	// always 1. Alternatives answer ?nbest.
+	Confidence   *float64      `json:"confidence,omitempty"`
+	Alternatives []alternative `json:"alternatives,omitempty"`
+}
+
+// embeddedPackFS is the baseline packs built into the binary, so an instance answers the common
+// phrases with no PACK_DIR mounted. It has the same layout as PACK_DIR.
+//
+//go:embed packs
+var embeddedPackFS embed.FS
+
+// Where a pack was loaded from.
+const (
+	packsEmbedded = "embedded"
+	packsExternal = "external" // PACK_DIR
+)
+
+// packSource is one layer of packs: a file system holding *.jsonl and *.tsv files and
+// optionally a pro/ directory of extended ones.
+type packSource struct {
+	fsys   fs.FS
+	origin string
+}
+
+// packSources lists the layers to load, lowest first: the embedded packs unless embedded is
+// off, then ext when set.
+func packSources(ext fs.FS, embedded bool) []packSource {
+	var out []packSource
+	if embedded {
+		sub, _ := fs.Sub(embeddedPackFS, "packs")
+		out = append(out, packSource{sub, packsEmbedded})
+	}
+	if ext != nil {
+		out = append(out, packSource{ext, packsExternal})
+	}
+	return out
+}
+
+// packInfo describes a loaded pack file for /go/version.
+type packInfo struct {
+	Name     string `json:"name"`
+	Origin   string `json:"origin"` // embedded or external
+	Entries  int    `json:"entries"`
+	Skipped  int    `json:"skipped,omitempty"`
+	Extended bool   `json:"extended,omitempty"` // pro-tier pack
+	SHA256   string `json:"sha256"`             // of the file as loaded
+}
+
+type packHit struct {
+	source       string // as written in the pack
+	translation  string
+	pack         string
+	normalized   bool // found by canonicalKey, not as typed
+	confidence   *float64
+	alternatives []alternative
+}
+
+// packTable is one tier of packs: phrases keyed on the lowercased source text, and romanized
+// ones on their canonicalKey too, for spellings that only differ in ways the rules fold.
+type packTable struct {
+	exact      map[string]packHit
+	normalized map[string]packHit
+}
+
+func newPackTable() packTable {
+	return packTable{exact: make(map[string]packHit), normalized: make(map[string]packHit)}
+}
+
+func (t packTable) add(kf keyFolding, p langPair, source string, h packHit) {
+	t.exact[packKey(p, source)] = h
+	if detectScript(source) == scriptLatin {
+		t.normalized[normalizedPackKey(kf, p, source)] = h
+	}
+}
+
+// packIndex is the phrase table built from the curated packs, keyed on direction and the
+// normalized source text. Packs in the pro/ subdirectory (the safe and profanity sets) go in
+// extended and only answer extended requests.
+type packIndex struct {
+	entries  packTable
+	extended packTable
+	files    []packInfo
+	pairs    map[langPair]int
+	keys     keyFolding
+
+	// Trigram indexes of entries and extended, built at load for suggestions and search
+	// (packSet.indexed) or on first use by searchable; nil until then.
+	fuzzy, fuzzyExtended fuzzyIndexes
+	fuzzyOnce            sync.Once
+
+	next func() // reports the next file to loadPacks' progress while loading
+}
+
+func packKey(p langPair, q string) string {
+	return p.Src + "\x00" + p.Dst + "\x00" + strings.ToLower(q)
+}
+
+func normalizedPackKey(kf keyFolding, p langPair, q string) string {
+	return p.Src + "\x00" + p.Dst + "\x00" + kf.canonicalKey(q)
+}
+
+// lookup returns the pack translation for an already-normalized q, preferring an extended pack
+// when extended is set, and an exact match over a normalized one, which the fuzzy_keys flag
+// can turn off.
+func (ix *packIndex) lookup(p langPair, q string, extended bool) (packHit, bool) {
+	tables := []packTable{ix.entries}
+	if extended {
+		tables = []packTable{ix.extended, ix.entries}
+	}
+	k := packKey(p, q)
+	for _, t := range tables {
+		if h, ok := t.exact[k]; ok {
+			return h, true
+		}
+	}
+	if detectScript(q) != scriptLatin || !ix.keys.fuzzy() {
+		return packHit{}, false
+	}
+	k = normalizedPackKey(ix.keys, p, q)
+	for _, t := range tables {
+		if h, ok := t.normalized[k]; ok {
+			h.normalized = true
+			return h, true
+		}
+	}
+	return packHit{}, false
+}
+
+// indexForSuggestions builds the trigram indexes, once.
+func (ix *packIndex) indexForSuggestions() {
+	ix.fuzzyOnce.Do(func() {
+		build := func(t packTable) fuzzyIndexes {
+			f := fuzzyIndexes{}
+			for k, h := range t.exact {
+				parts := strings.SplitN(k, "\x00", 3)
+				f.put(langPair{parts[0], parts[1]}, h.source, h.translation, h.pack)
+			}
+			return f
+		}
+		ix.fuzzy, ix.fuzzyExtended = build(ix.entries), build(ix.extended)
+	})
+}
+
+// searchable is ix with its trigram indexes, building them if loading didn't.
+func (ix *packIndex) searchable() *packIndex {
+	ix.indexForSuggestions()
+	return ix
+}
+
+// size is the total entry count across base and extended packs.
+func (ix *packIndex) size() int { return len(ix.entries.exact) + len(ix.extended.exact) }
+
+// loadPacks reads every *.jsonl and *.tsv file of each source in turn, in name order; a later
+// file, and anything in a later source, wins on duplicate phrases. JSONL lines are packEntry
+// objects, with src/dst defaulting to def. TSV lines are "source<TAB>target" in the direction
+// named by the file, e.g. greetings.dv-en.tsv, or def. Malformed lines are logged and counted,
+// or with strict set, fail the load; an unreadable file or directory always does. The embedded
+// packs are always loaded strictly: a bad line there is a bug in the build. Romanized phrases are
+// also keyed as keys folds them. progress, if set, is told before each file which of how many is
+// next.
+func loadPacks(sources []packSource, def langPair, keys keyFolding, strict bool, progress func(n, total int)) (*packIndex, error) {
+	ix := &packIndex{entries: newPackTable(), extended: newPackTable(), pairs: make(map[langPair]int), keys: keys}
+	if progress != nil {
+		total := 0
+		for _, src := range sources {
+			total += countPackFiles(src.fsys, ".") + countPackFiles(src.fsys, proPackDir)
+		}
+		ix.next = func() { progress(len(ix.files)+1, total) }
+	}
+	for _, src := range sources {
+		strict := strict || src.origin == packsEmbedded
+		if err := ix.loadDir(src, ".", "", ix.entries, def, strict); err != nil {
+			return nil, err
+		}
+		if fi, err := fs.Stat(src.fsys, proPackDir); err == nil && fi.IsDir() {
+			if err := ix.loadDir(src, proPackDir, proPackDir+"/", ix.extended, def, strict); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ix.next = nil
+	return ix, nil
+}
+
+// proPackDir is the PACK_DIR subdirectory holding the extended (pro-tier) packs.
+const proPackDir = "pro"
+
+// countPackFiles is how many files loadDir would load from dir; 0 when dir can't be read.
+func countPackFiles(fsys fs.FS, dir string) int {
+	ents, _ := fs.ReadDir(fsys, dir)
+	n := 0
+	for _, e := range ents {
+		if ext := path.Ext(e.Name()); !e.IsDir() && (ext == ".jsonl" || ext == ".tsv") {
+			n++
+		}
+	}
+	return n
+}
+
+func (ix *packIndex) loadDir(src packSource, dir, prefix string, into packTable, def langPair, strict bool) error {
+	ents, err := fs.ReadDir(src.fsys, dir)
+	if err != nil {
+		return fmt.Errorf("%s pack dir: %w", src.origin, err)
+	}
+	for _, e := range ents {
+		ext := path.Ext(e.Name())
+		if e.IsDir() || (ext != ".jsonl" && ext != ".tsv") {
+			continue
+		}
+		if ix.next != nil {
+			ix.next()
+		}
+		info, err := ix.loadFile(src, path.Join(dir, e.Name()), prefix, into, def, strict)
+		if err != nil {
+			return err
+		}
+		slog.Info("pack loaded", "pack", info.Name, "origin", info.Origin, "entries", info.Entries, "skipped", info.Skipped)
+		ix.files = append(ix.files, info)
+	}
+	return nil
+}
+
+func (ix *packIndex) loadFile(src packSource, name, prefix string, into packTable, def langPair, strict bool) (packInfo, error) {
+	f, err := src.fsys.Open(name)
+	if err != nil {
+		return packInfo{}, fmt.Errorf("pack: %w", err)
+	}
+	defer f.Close()
+
+	info := packInfo{Name: prefix + path.Base(name), Origin: src.origin, Extended: prefix != ""}
+	tsv := path.Ext(name) == ".tsv"
+	tsvPair := def
+	if tsv {
+		tsvPair = pairFromName(path.Base(name), def)
+	}
+	sum := sha256.New()
+	sc := bufio.NewScanner(io.TeeReader(f, sum))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var pe packEntry
+		var perr error
+		if tsv {
+			pe, perr = parseTSVLine(line, tsvPair)
+		} else {
+			pe, perr = parseJSONLLine(line, def)
+		}
+		if perr != nil && strict {
+			return info, fmt.Errorf("pack %s line %d: %w", info.Name, n, perr)
+		}
+		if perr != nil {
+			info.Skipped++
+			slog.Warn("pack line skipped", "pack", info.Name, "line", n, "err", perr)
+			continue
+		}
+		p := langPair{pe.Src, pe.Dst}
+		into.add(ix.keys, p, pe.SourceText, packHit{source: pe.SourceText, translation: pe.TargetText, pack: info.Name, confidence: pe.Confidence, alternatives: pe.Alternatives})
+		ix.pairs[p]++
+		info.Entries++
+	}
+	if err := sc.Err(); err != nil {
+		return info, fmt.Errorf("pack %s: %w", info.Name, err)
+	}
+	info.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return info, nil
+}
+
+func parseJSONLLine(line string, def langPair) (packEntry, error) {
+	var pe packEntry
+	if err := json.Unmarshal([]byte(line), &pe); err != nil {
+		return pe, err
+	}
+	if pe.Src == "" && pe.Dst == "" {
+		pe.Src, pe.Dst = def.Src, def.Dst
+	}
+	return checkPackEntry(pe)
+}
+
+func parseTSVLine(line string, p langPair) (packEntry, error) {
+	src, dst, ok := strings.Cut(line, "\t")
+	if !ok || strings.Contains(dst, "\t") {
+		return packEntry{}, fmt.Errorf("want 2 tab-separated columns")
+	}
+	return checkPackEntry(packEntry{Src: p.Src, Dst: p.Dst, SourceText: src, TargetText: dst})
+}
+
+// checkPackEntry normalizes the texts the same way translate input is, so lookups match.
+func checkPackEntry(pe packEntry) (packEntry, error) {
+	p := langPair{strings.ToLower(pe.Src), strings.ToLower(pe.Dst)}
+	if !pairSupported(p) {
+		return pe, &unsupportedPairError{Pair: p}
+	}
+	src, ok1 := normalizeText(pe.SourceText)
+	dst, ok2 := normalizeText(pe.TargetText)
+	if !ok1 || !ok2 || src == "" || dst == "" {
+		return pe, fmt.Errorf("empty or invalid text")
+	}
+	if c := pe.Confidence; c != nil && !(*c >= 0 && *c <= 1) {
+		return pe, fmt.Errorf("confidence %v is outside [0, 1]", *c)
+	}
+	var alts []alternative
+	for _, a := range pe.Alternatives {
+		t, ok := normalizeText(a.Translation)
+		if !ok || t == "" {
+			return pe, fmt.Errorf("empty or invalid alternative")
+		}
+		if !(a.Confidence >= 0 && a.Confidence <= 1) {
+			return pe, fmt.Errorf("alternative confidence %v is outside [0, 1]", a.Confidence)
+		}
+		alts = append(alts, alternative{Translation: t, Confidence: a.Confidence})
+	}
+	return packEntry{Src: p.Src, Dst: p.Dst, SourceText: src, TargetText: dst, Confidence: pe.Confidence, Alternatives: alts}, nil
+}
+
+// pairFromName reads a direction from the last dotted part before the extension, such as
+// "dv-en" in greetings.dv-en.tsv, falling back to def.
+func pairFromName(name string, def langPair) langPair {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	if i := strings.LastIndexByte(base, '.'); i >= 0 {
+		if src, dst, ok := strings.Cut(base[i+1:], "-"); ok {
+			if p := (langPair{src, dst}); pairSupported(p) {
+				return p
+			}
+		}
+	}
+	return def
+}
+
+// pairSummary formats entry counts per direction for logs, e.g. "dv→en=120 en→dv=80".
+func (ix *packIndex) pairSummary() string {
+	pairs := make([]string, 0, len(ix.pairs))
+	for p, n := range ix.pairs {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", p, n))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// packSet holds the live packIndex behind an atomic pointer. A reload builds a complete new
+// index off to the side and swaps it in, so lookups see either the old packs or the new ones.
+type packSet struct {
+	dir      string // PACK_DIR; may be empty
+	ext      fs.FS  // the packs over the embedded ones: dir, or what New was given instead
+	embedded bool
+	indexed  bool // build the trigram indexes at every load
+	def      langPair
+	keys     keyFolding
+	cur      atomic.Pointer[packIndex]
+	mu       sync.Mutex // serializes reloads
+
+	status   atomic.Pointer[packStatus]
+	onReload func() // called after a reload swaps in new packs; may be nil
+	sync     *packSync
+}
+
+// packStatus is when the live packs were loaded and how the most recent failed reload went
+// (kept after later successes), for /go/health?verbose=1.
+type packStatus struct {
+	LoadedAt    time.Time `json:"loaded_at"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt string    `json:"last_error_at,omitempty"`
+}
+
+// openPacks loads the embedded packs, unless embedded is off, and ext or else dir over them
+// leniently, as at startup: malformed lines there are skipped, not fatal. With indexed, every
+// load also builds the trigram indexes near-match suggestions and /go/packs/search read.
+func openPacks(dir string, ext fs.FS, embedded, indexed bool, def langPair, keys keyFolding, progress func(loaded, total int)) (*packSet, error) {
+	if ext == nil && dir != "" {
+		ext = os.DirFS(dir)
+	}
+	ix, err := loadPacks(packSources(ext, embedded), def, keys, false, progress)
+	if err != nil {
+		return nil, err
+	}
+	if indexed {
+		ix.indexForSuggestions()
+	}
+	ps := &packSet{dir: dir, ext: ext, embedded: embedded, indexed: indexed, def: def, keys: keys}
+	ps.cur.Store(ix)
+	ps.status.Store(&packStatus{LoadedAt: time.Now()})
+	slog.Info("translation packs loaded", "files", len(ix.files), "entries", ix.size(), "pairs", ix.pairSummary())
+	return ps, nil
+}
+
+func (ps *packSet) current() *packIndex { return ps.cur.Load() }
+
+// packReload reports what a reload changed.
+type packReload struct {
+	OldEntries int           `json:"old_entries"`
+	NewEntries int           `json:"new_entries"`
+	Files      []packInfo    `json:"files"`
+	Duration   time.Duration `json:"-"`
+}
+
+// reload re-reads the packs strictly; on any error the old index stays live.
+func (ps *packSet) reload(reason string) (packReload, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	start := time.Now()
+	old := ps.current()
+	ix, err := loadPacks(packSources(ps.ext, ps.embedded), ps.def, ps.keys, true, nil)
+	if err != nil {
+		slog.Error("pack reload rejected", "reason", reason, "err", err, "entries", old.size())
+		st := *ps.status.Load()
+		st.LastError, st.LastErrorAt = err.Error(), time.Now().UTC().Format(time.RFC3339)
+		ps.status.Store(&st)
+		return packReload{OldEntries: old.size()}, err
+	}
+	if ps.indexed {
+		ix.indexForSuggestions()
+	}
+	ps.cur.Store(ix)
+	st := *ps.status.Load()
+	st.LoadedAt = time.Now()
+	ps.status.Store(&st)
+	if ps.onReload != nil {
+		ps.onReload()
+	}
+	res := packReload{OldEntries: old.size(), NewEntries: ix.size(), Files: ix.files, Duration: time.Since(start)}
+	slog.Info("packs reloaded", "reason", reason, "old_entries", res.OldEntries, "new_entries", res.NewEntries,
+		"pairs", ix.pairSummary(), "duration", res.Duration.String())
+	ps.sync.reloaded(reason, ix)
+	return res, nil
+}
+
+// watch reloads on changes to the pack directory, which must be set, until ctx is done. Editors and rsync touch a
+// file several times per save, so events are coalesced for debounce before reloading.
+func (ps *packSet) watch(ctx context.Context, debounce time.Duration) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(ps.dir); err != nil {
+		w.Close()
+		return err
+	}
+	if fi, err := os.Stat(filepath.Join(ps.dir, proPackDir)); err == nil && fi.IsDir() {
+		if err := w.Add(filepath.Join(ps.dir, proPackDir)); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	go func() {
+		defer w.Close()
+		var fire <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if ext := filepath.Ext(ev.Name); ext == ".jsonl" || ext == ".tsv" {
+					fire = time.After(debounce)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("pack watcher error", "err", err)
+			case <-fire:
+				fire = nil
+				ps.reload("watch")
+			}
+		}
+	}()
+	return nil
+}
+
+// packTranslator answers from the packs before falling through to next (cache, then upstream).
+type packTranslator struct {
+	packs *packSet
+	next  Translator
+}
+
+func (t *packTranslator) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	stage := startStage(ctx, "pack")
+	if h, ok := t.packs.current().lookup(langPair{req.Src, req.Dst}, req.Q, req.Extended); ok {
+		metricPackHits.Inc()
+		stage.end(stageHit, h.pack)
+		res := translateResult{Translation: h.translation, Src: "pack", Pack: h.pack, Alternatives: h.alternatives}
+		if h.normalized {
+			res.Match = matchNormalized
+			res.Confidence = h.confidence
+		} else {
+			exact := 1.0
+			res.Confidence = &exact
+		}
+		return res, nil
+	}
+	stage.end(stageMiss, "")
+	return t.next.Translate(ctx, req)
+}
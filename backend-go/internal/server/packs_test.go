@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestEmbeddedPacks loads every pack built into the binary strictly, as startup does, so a bad
+// line in one fails the build's tests rather than a deploy.
+func TestEmbeddedPacks(t *testing.T) {
+	var names []string
+	fs.WalkDir(embeddedPackFS, "packs", func(p string, d fs.DirEntry, err error) error {
+		if ext := path.Ext(p); err == nil && !d.IsDir() && (ext == ".tsv" || ext == ".jsonl") {
+			names = append(names, strings.TrimPrefix(p, "packs/"))
+		}
+		return err
+	})
+	ix, err := loadPacks(packSources(nil, true), langPair{"dv", "en"}, keyFolding{}, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 || len(ix.files) != len(names) {
+		t.Fatalf("loaded %d embedded packs of %d: %v", len(ix.files), len(names), names)
+	}
+	for _, f := range ix.files {
+		if f.Origin != packsEmbedded || f.Entries == 0 || f.Skipped != 0 {
+			t.Errorf("embedded pack %+v", f)
+		}
+	}
+	if h, ok := ix.lookup(langPair{"latin", "en"}, "shukuriyyaa", false); !ok || h.translation != "Thank you" {
+		t.Fatalf("embedded lookup: %+v, %v", h, ok)
+	}
+}
+
+// TestPackLayers puts a PACK_DIR over the embedded packs and checks its phrases win, the rest of
+// the embedded ones still answer, and /go/version counts each origin apart.
+func TestPackLayers(t *testing.T) {
+	ext := fstest.MapFS{"local.latin-en.tsv": {Data: []byte("rangalhu\tFine\nmiadhu\tToday\n")}}
+	ix, err := loadPacks(packSources(ext, true), langPair{"dv", "en"}, keyFolding{}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ q, translation, pack string }{
+		{"rangalhu", "Fine", "local.latin-en.tsv"},
+		{"miadhu", "Today", "local.latin-en.tsv"},
+		{"shukuriyyaa", "Thank you", "greetings.latin-en.tsv"},
+	} {
+		if h, ok := ix.lookup(langPair{"latin", "en"}, tt.q, false); !ok || h.translation != tt.translation || h.pack != tt.pack {
+			t.Errorf("%s: %q from %q (found %v), want %q from %q", tt.q, h.translation, h.pack, ok, tt.translation, tt.pack)
+		}
+	}
+
+	embedded := countPacks(ix.files)
+	for _, tt := range []struct {
+		name string
+		env  map[string]string
+		want packCount
+	}{
+		{"layered", nil, packCount{embedded.EmbeddedFiles, embedded.EmbeddedEntries, 1, 2}},
+		{"external only", map[string]string{"PACKS_EMBEDDED": "false"}, packCount{0, 0, 1, 2}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestServer(t, tt.env, Deps{Packs: ext}).Handler()
+			var v versionInfo
+			json.Unmarshal(serve(h, "GET", "/go/version", "").Body.Bytes(), &v)
+			if v.PackCount == nil || *v.PackCount != tt.want {
+				t.Fatalf("pack_counts %+v, want %+v", v.PackCount, tt.want)
+			}
+			w := serve(h, "GET", "/go/translate?q=rangalhu&src=latin&dst=en", "", "X-API-Key", testProKey)
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"pack":"local.latin-en.tsv"`) || !strings.Contains(w.Body.String(), `"translation":"Fine"`) {
+				t.Fatalf("translate: status %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
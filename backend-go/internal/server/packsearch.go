@@ -0,0 +1,243 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pack search, for content editors: GET /go/packs/search finds every pack and translation
+// memory entry whose source or translation contains q, in either script. A Latin q is also
+// looked for as Thaana and a Thaana one as Latin, through the transliteration table, and each
+// result says where each term was found so the frontend can highlight it.
+//
+// The search reads the suggestion index (suggest.go), which posts each entry's translation
+// trigrams beside its source ones: an entry containing a term has every trigram of it, so it
+// is in the shortest of their posting lists, and only those are read and checked. Results page
+// with the admin lists' cursors, best first: an entry that is the term, then one with a word
+// starting with it, then any other; shorter entries first within each.
+
+// packSearchMinChars is the shortest q, so every term has a trigram to look up.
+const packSearchMinChars = 3
+
+// packSearchMatch is where a term was found, in characters (code points, which for Thaana and
+// Latin text are also the UTF-16 units JavaScript counts), end exclusive.
+type packSearchMatch struct {
+	Field string `json:"field"` // source_text or target_text
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Term  string `json:"term"` // q, or its transliteration
+}
+
+type packSearchResult struct {
+	Pack       string            `json:"pack"` // pack file name, or tm
+	Src        string            `json:"src"`
+	Dst        string            `json:"dst"`
+	SourceText string            `json:"source_text"`
+	TargetText string            `json:"target_text"`
+	Matches    []packSearchMatch `json:"matches"`
+}
+
+// packSearchHit is a phrase containing a term, with its sort key. Only those on the page asked
+// for become results, so a broad q costs a rank per hit, not the matches.
+type packSearchHit struct {
+	pair langPair
+	doc  *fuzzyDoc
+	key  pageKey
+}
+
+// packSearcher serves /go/packs/search. packs and tm may be nil.
+type packSearcher struct {
+	packs  *packSet
+	tm     *translationMemory
+	table  *translitTable
+	pg     *pager
+	limits inputLimits
+}
+
+// handler serves GET /go/packs/search?q=[&src=&dst=][&limit=&cursor=]. Pro keys also search the
+// extended packs.
+func (s *packSearcher) handler(w http.ResponseWriter, r *http.Request) {
+	req, herr := s.pg.readPage(r, "pack_search", "q", "src", "dst")
+	if herr != nil {
+		herr.write(w)
+		return
+	}
+	query := r.URL.Query()
+	if query.Has("since") {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "since is not supported by search; pack entries have no creation time")
+		return
+	}
+	q, ok := normalizeText(query.Get("q"))
+	tier := tierFrom(r.Context())
+	switch n := utf8.RuneCountInString(q); {
+	case !ok:
+		writeError(w, http.StatusBadRequest, codeBadRequest, "q is not valid UTF-8")
+		return
+	case q == "":
+		writeError(w, http.StatusBadRequest, codeMissingQuery, "missing 'q'")
+		return
+	case n < packSearchMinChars:
+		writeError(w, http.StatusBadRequest, codeBadRequest, "q must be at least 3 characters", "min_chars", packSearchMinChars)
+		return
+	case n > s.limits.maxChars(tier):
+		writeTranslateError(r.Context(), w, &inputTooLongError{Tier: tier, Limit: s.limits.maxChars(tier), Length: n})
+		return
+	}
+	terms := s.terms(q)
+	auditParam(r.Context(), "terms", len(terms))
+	hits, err := s.search(r, terms, langPair{strings.ToLower(query.Get("src")), strings.ToLower(query.Get("dst"))}, tier == tierPro)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeInternal, "translation memory unavailable", "detail", err.Error())
+		return
+	}
+	page, next := pageOf(s.pg, req, hits, func(h packSearchHit) pageKey { return h.key })
+	results := make([]packSearchResult, len(page))
+	for i, h := range page {
+		matches, _ := searchMatches(h.doc, terms, true)
+		results[i] = packSearchResult{Pack: h.doc.from, Src: h.pair.Src, Dst: h.pair.Dst, SourceText: h.doc.source, TargetText: h.doc.translation, Matches: matches}
+	}
+	j(w, http.StatusOK, pageBody(map[string]any{"q": q, "terms": terms, "results": results, "count": len(results), "total": len(hits)}, next))
+}
+
+// terms is q as searched for, lowercased with its spaces collapsed, and its transliteration
+// when q is in one script and that comes out different and long enough.
+func (s *packSearcher) terms(q string) []string {
+	term := func(s string) string { return strings.ToLower(strings.Join(strings.Fields(s), " ")) }
+	out := []string{term(q)}
+	var alt transliteration
+	switch detectScript(q) {
+	case scriptThaana:
+		alt = s.table.toLatin(q)
+	case scriptLatin:
+		alt = s.table.toThaana(q)
+	default:
+		return out
+	}
+	if t := term(alt.Output); t != out[0] && alt.Unmapped == 0 && utf8.RuneCountInString(t) >= packSearchMinChars {
+		out = append(out, t)
+	}
+	return out
+}
+
+// search is every entry in the direction p narrows to (empty fields match any) containing one of
+// terms, the extended packs' too when extended.
+func (s *packSearcher) search(r *http.Request, terms []string, p langPair, extended bool) ([]packSearchHit, error) {
+	var out []packSearchHit
+	collect := func(f fuzzyIndexes) {
+		for pair, ix := range f {
+			if !matchParam(p.Src, pair.Src) || !matchParam(p.Dst, pair.Dst) {
+				continue
+			}
+			ix.containing(terms, func(d *fuzzyDoc) {
+				if _, rank := searchMatches(d, terms, false); rank > 0 {
+					out = append(out, packSearchHit{pair: pair, doc: d, key: pageKey{
+						At: int64(rank)*rankTextSpan - int64(min(utf8.RuneCountInString(d.source), rankTextSpan-1)),
+						ID: []string{d.from, pair.Src, pair.Dst, strings.ToLower(d.source)},
+					}})
+				}
+			})
+		}
+	}
+	if s.packs != nil {
+		ix := s.packs.current().searchable()
+		collect(ix.fuzzy)
+		if extended {
+			collect(ix.fuzzyExtended)
+		}
+	}
+	if s.tm != nil {
+		if err := s.tm.indexForSuggestions(r.Context()); err != nil {
+			return nil, err
+		}
+		if f := s.tm.fuzzy.Load(); f != nil {
+			f.mu.RLock()
+			collect(f.idx)
+			f.mu.RUnlock()
+		}
+	}
+	return out, nil
+}
+
+// containing calls fn with each phrase that may contain one of terms, which are lowercased and
+// at least three runes long: those in the shortest posting list, source or translation, of the
+// term's trigrams. Any other lacks one of them.
+func (ix *fuzzyIndex) containing(terms []string, fn func(*fuzzyDoc)) {
+	seen := make(map[int32]bool)
+	for _, t := range terms {
+		grams := runeTrigrams([]rune(t))
+		for _, postings := range []map[uint64][]int32{ix.postings, ix.translations} {
+			var shortest []int32
+			for i, g := range grams {
+				l := postings[g]
+				if len(l) == 0 {
+					shortest = nil
+					break
+				}
+				if i == 0 || len(l) < len(shortest) {
+					shortest = l
+				}
+			}
+			for _, id := range shortest {
+				if !seen[id] && !ix.docs[id].deleted {
+					seen[id] = true
+					fn(&ix.docs[id])
+				}
+			}
+		}
+	}
+}
+
+// Ranks of a match, best last.
+const (
+	rankWithin = 1 // inside a word
+	rankWord   = 2 // at the start of a word
+	rankEntire = 3 // the whole text
+)
+
+// rankTextSpan separates the ranks in a result's sort key, leaving room for the source's length.
+const rankTextSpan = 1_000_000
+
+// searchMatches is the best rank of terms' matches in d, 0 if there is none, and with all set,
+// every match.
+func searchMatches(d *fuzzyDoc, terms []string, all bool) ([]packSearchMatch, int) {
+	var matches []packSearchMatch
+	best := 0
+	for _, f := range []struct{ name, text string }{{"source_text", d.source}, {"target_text", d.translation}} {
+		lower := strings.ToLower(f.text)
+		for _, t := range terms {
+			for off := 0; off < len(lower); {
+				i := strings.Index(lower[off:], t)
+				if i < 0 {
+					break
+				}
+				at := off + i
+				rank := rankWithin
+				if strings.TrimSpace(lower) == t {
+					rank = rankEntire
+				} else if prev, _ := utf8.DecodeLastRuneInString(lower[:at]); at == 0 || !inWord(prev) {
+					rank = rankWord
+				}
+				best = max(best, rank)
+				if all {
+					// ToLower maps rune for rune, so character offsets in lower are those in the text.
+					start := utf8.RuneCountInString(lower[:at])
+					matches = append(matches, packSearchMatch{Field: f.name, Start: start, End: start + utf8.RuneCountInString(t), Term: t})
+				}
+				off = at + len(t)
+			}
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b packSearchMatch) int {
+		if a.Field != b.Field {
+			return strings.Compare(a.Field, b.Field)
+		}
+		return a.Start - b.Start
+	})
+	return matches, best
+}
+
+// inWord reports whether r continues a word: a letter, a digit, or a mark such as Thaana's fili.
+func inWord(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) }
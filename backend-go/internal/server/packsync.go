@@ -0,0 +1,356 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Pack reloads across instances. A reload reaches the one instance that got the SIGHUP or the
+// admin call, so a fleet behind a load balancer answers from two sets of packs until someone
+// reloads the rest. With a shared backend (Redis when CACHE_BACKEND=redis, else the
+// CACHE_DB_PATH database, for instances on one host) every successful local reload publishes
+// the packs' stamp, a hash of the files as loaded, and each instance checks the published
+// stamp every PACK_SYNC_INTERVAL and reloads itself when it has changed since it last looked.
+// It acts on changes, not differences: an instance whose files haven't caught up reloads once,
+// says so, and keeps serving what it has rather than reloading on every tick. Instances also
+// heartbeat their own stamp, which GET /go/admin/packs/status lists to show whether the
+// fleet has converged. Without a shared backend, or with PACK_SYNC_INTERVAL=0, an instance
+// only knows about itself and reloads as before.
+
+// packReasonSync prefixes the reason of a reload an instance made to catch up with the fleet;
+// those reloads don't publish.
+const packReasonSync = "sync:"
+
+// stamp identifies the packs as loaded: a hash over each file's origin, name and contents, in
+// load order.
+func (ix *packIndex) stamp() string {
+	h := sha256.New()
+	for _, f := range ix.files {
+		fmt.Fprintf(h, "%s/%s %s\n", f.Origin, f.Name, f.SHA256)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// packStamp is the stamp last published to the fleet.
+type packStamp struct {
+	Stamp string    `json:"stamp"`
+	By    string    `json:"by"` // the instance whose reload published it
+	At    time.Time `json:"at"`
+}
+
+// packInstance is one instance's heartbeat.
+type packInstance struct {
+	Instance string    `json:"instance"`
+	Stamp    string    `json:"stamp"`
+	SeenAt   time.Time `json:"seen_at"`
+	Current  bool      `json:"current"` // its stamp is the published one
+}
+
+// packSyncStore is the shared state behind pack sync.
+type packSyncStore interface {
+	publish(ctx context.Context, s packStamp) error
+	// published is the last published stamp; the zero packStamp when none has been.
+	published(ctx context.Context) (packStamp, error)
+	heartbeat(ctx context.Context, instance, stamp string, at time.Time) error
+	// instances lists the instances heard from since, forgetting the others.
+	instances(ctx context.Context, since time.Time) ([]packInstance, error)
+}
+
+// packSync keeps one instance's packs in step with the fleet. Its store is nil without a shared
+// backend, and then it only reports this instance.
+type packSync struct {
+	packs     *packSet
+	store     packSyncStore
+	backend   string // redis, sqlite or none
+	instance  string
+	interval  time.Duration
+	opTimeout time.Duration
+	clock     Clock
+
+	mu   sync.Mutex
+	seen string // the published stamp this instance last caught up with
+}
+
+// instanceID is INSTANCE_ID, or the Fly machine, or the host name.
+func instanceID(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if id := os.Getenv("FLY_MACHINE_ID"); id != "" {
+		return id
+	}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h
+	}
+	return fmt.Sprintf("pid-%d", os.Getpid())
+}
+
+// start takes the published stamp as caught up with, publishing this instance's when there is
+// none yet, and then polls until ctx is done. It doesn't reload: the packs were just loaded.
+func (s *packSync) start(ctx context.Context) {
+	own := s.packs.current().stamp()
+	cur, err := s.published(ctx)
+	switch {
+	case err != nil:
+		slog.Warn("pack sync unavailable; will retry", "backend", s.backend, "err", err)
+	case cur.Stamp == "":
+		s.publish(ctx, own)
+	default:
+		s.mu.Lock()
+		s.seen = cur.Stamp
+		s.mu.Unlock()
+		if cur.Stamp != own {
+			slog.Warn("packs differ from the fleet's", "stamp", own, "fleet_stamp", cur.Stamp, "published_by", cur.By)
+		}
+	}
+	s.beat(ctx, own)
+	go s.run(ctx)
+}
+
+func (s *packSync) run(ctx context.Context) {
+	t := s.clock.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			s.poll(ctx)
+		}
+	}
+}
+
+// poll reloads when the published stamp has changed since this instance last caught up, and
+// heartbeats.
+func (s *packSync) poll(ctx context.Context) {
+	cur, err := s.published(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("pack sync poll failed", "backend", s.backend, "err", err)
+		}
+		return
+	}
+	s.mu.Lock()
+	changed := cur.Stamp != "" && cur.Stamp != s.seen
+	if changed {
+		s.seen = cur.Stamp
+	}
+	s.mu.Unlock()
+	own := s.packs.current().stamp()
+	if changed && cur.Stamp != own {
+		slog.Info("packs changed on another instance; reloading", "from", own, "to", cur.Stamp, "published_by", cur.By)
+		if _, err := s.packs.reload(packReasonSync + cur.By); err == nil {
+			if own = s.packs.current().stamp(); own != cur.Stamp {
+				slog.Warn("packs still differ from the fleet's after reload", "stamp", own, "fleet_stamp", cur.Stamp, "published_by", cur.By)
+			}
+		}
+	}
+	s.beat(ctx, own)
+}
+
+// reloaded publishes the packs of a local reload.
+func (s *packSync) reloaded(reason string, ix *packIndex) {
+	if s == nil || s.store == nil || strings.HasPrefix(reason, packReasonSync) {
+		return
+	}
+	stamp := ix.stamp()
+	s.publish(context.Background(), stamp)
+	s.beat(context.Background(), stamp)
+}
+
+func (s *packSync) publish(ctx context.Context, stamp string) {
+	s.mu.Lock()
+	s.seen = stamp
+	s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, s.opTimeout)
+	defer cancel()
+	if err := s.store.publish(ctx, packStamp{Stamp: stamp, By: s.instance, At: s.clock.Now().UTC()}); err != nil {
+		slog.Warn("pack stamp publish failed", "backend", s.backend, "stamp", stamp, "err", err)
+		return
+	}
+	slog.Info("pack stamp published", "stamp", stamp)
+}
+
+func (s *packSync) beat(ctx context.Context, stamp string) {
+	op, cancel := context.WithTimeout(ctx, s.opTimeout)
+	defer cancel()
+	if err := s.store.heartbeat(op, s.instance, stamp, s.clock.Now().UTC()); err != nil && ctx.Err() == nil {
+		slog.Warn("pack sync heartbeat failed", "backend", s.backend, "err", err)
+	}
+}
+
+func (s *packSync) published(ctx context.Context) (packStamp, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opTimeout)
+	defer cancel()
+	return s.store.published(ctx)
+}
+
+// packSyncStatus is GET /go/admin/packs/status.
+type packSyncStatus struct {
+	Instance  string         `json:"instance"`
+	Backend   string         `json:"backend"`
+	Stamp     string         `json:"stamp"` // this instance's
+	Published *packStamp     `json:"published,omitempty"`
+	Instances []packInstance `json:"instances"`
+	Converged bool           `json:"converged"` // every instance has the published stamp
+}
+
+// status reports the instances heard from within three intervals.
+func (s *packSync) status(ctx context.Context) (packSyncStatus, error) {
+	out := packSyncStatus{Instance: s.instance, Backend: s.backend, Stamp: s.packs.current().stamp()}
+	if s.store == nil {
+		out.Instances = []packInstance{{Instance: s.instance, Stamp: out.Stamp, SeenAt: s.clock.Now().UTC(), Current: true}}
+		out.Converged = true
+		return out, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.opTimeout)
+	defer cancel()
+	cur, err := s.store.published(ctx)
+	if err != nil {
+		return out, err
+	}
+	list, err := s.store.instances(ctx, s.clock.Now().Add(-3*s.interval))
+	if err != nil {
+		return out, err
+	}
+	if cur.Stamp != "" {
+		out.Published = &cur
+	}
+	out.Converged = cur.Stamp != ""
+	for i := range list {
+		list[i].Current = list[i].Stamp == cur.Stamp
+		out.Converged = out.Converged && list[i].Current
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Instance < list[j].Instance })
+	out.Instances = list
+	return out, nil
+}
+
+// packSyncStatusHandler serves GET /go/admin/packs/status.
+func packSyncStatusHandler(s *packSync) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st, err := s.status(r.Context())
+		if err != nil {
+			writeError(w, http.StatusBadGateway, codeInternal, "pack sync store unavailable", "backend", s.backend, "detail", err.Error())
+			return
+		}
+		auditParam(r.Context(), "converged", st.Converged)
+		j(w, http.StatusOK, st)
+	}
+}
+
+// redisPackSync keeps the published stamp in one key and the heartbeats in a hash.
+type redisPackSync struct{ rdb *redis.Client }
+
+const (
+	redisPackStampKey     = "dhk:packs:stamp"
+	redisPackInstancesKey = "dhk:packs:instances"
+)
+
+func (s redisPackSync) publish(ctx context.Context, ps packStamp) error {
+	b, _ := json.Marshal(ps)
+	return s.rdb.Set(ctx, redisPackStampKey, b, 0).Err()
+}
+
+func (s redisPackSync) published(ctx context.Context) (packStamp, error) {
+	var ps packStamp
+	b, err := s.rdb.Get(ctx, redisPackStampKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return ps, nil
+	}
+	if err != nil {
+		return ps, err
+	}
+	return ps, json.Unmarshal(b, &ps)
+}
+
+func (s redisPackSync) heartbeat(ctx context.Context, instance, stamp string, at time.Time) error {
+	b, _ := json.Marshal(packInstance{Instance: instance, Stamp: stamp, SeenAt: at})
+	return s.rdb.HSet(ctx, redisPackInstancesKey, instance, b).Err()
+}
+
+func (s redisPackSync) instances(ctx context.Context, since time.Time) ([]packInstance, error) {
+	all, err := s.rdb.HGetAll(ctx, redisPackInstancesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	var out []packInstance
+	var stale []string
+	for name, raw := range all {
+		var in packInstance
+		if json.Unmarshal([]byte(raw), &in) != nil || in.SeenAt.Before(since) {
+			stale = append(stale, name)
+			continue
+		}
+		out = append(out, in)
+	}
+	if len(stale) > 0 {
+		s.rdb.HDel(ctx, redisPackInstancesKey, stale...)
+	}
+	return out, nil
+}
+
+// sqlitePackSync keeps the same state in the CACHE_DB_PATH database, in the tables of the
+// cache's 002_pack_sync migration.
+type sqlitePackSync struct{ db *sql.DB }
+
+func (s sqlitePackSync) publish(ctx context.Context, ps packStamp) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO pack_stamp (id, stamp, published_by, published_at) VALUES (1, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET stamp = excluded.stamp, published_by = excluded.published_by, published_at = excluded.published_at`,
+		ps.Stamp, ps.By, ps.At.UnixMilli())
+	return err
+}
+
+func (s sqlitePackSync) published(ctx context.Context) (packStamp, error) {
+	var ps packStamp
+	var at int64
+	err := s.db.QueryRowContext(ctx, `SELECT stamp, published_by, published_at FROM pack_stamp WHERE id = 1`).Scan(&ps.Stamp, &ps.By, &at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ps, nil
+	}
+	ps.At = time.UnixMilli(at).UTC()
+	return ps, err
+}
+
+func (s sqlitePackSync) heartbeat(ctx context.Context, instance, stamp string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO pack_instances (instance, stamp, seen_at) VALUES (?, ?, ?)
+		ON CONFLICT(instance) DO UPDATE SET stamp = excluded.stamp, seen_at = excluded.seen_at`,
+		instance, stamp, at.UnixMilli())
+	return err
+}
+
+func (s sqlitePackSync) instances(ctx context.Context, since time.Time) ([]packInstance, error) {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM pack_instances WHERE seen_at < ?`, since.UnixMilli()); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT instance, stamp, seen_at FROM pack_instances`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []packInstance
+	for rows.Next() {
+		var in packInstance
+		var at int64
+		if err := rows.Scan(&in.Instance, &in.Stamp, &at); err != nil {
+			return nil, err
+		}
+		in.SeenAt = time.UnixMilli(at).UTC()
+		out = append(out, in)
+	}
+	return out, rows.Err()
+}
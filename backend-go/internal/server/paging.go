@@ -0,0 +1,212 @@
+package server
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Admin list pagination. Every list route under /go/admin (keys, tm, audit, jobs) pages the
+// same way: newest first by created_at, ties broken by id, ?limit= (1-1000, default 50), and a
+// next_cursor in the response while there is more, omitted on the last page. Pass it back as
+// ?cursor= with the same filters for the next page. ?since= (RFC 3339) keeps items created at
+// or after it.
+//
+// A cursor is the last item's sort key, signed with PAGE_CURSOR_SECRET (a random secret per
+// process without it, so set it when instances share traffic) over the list's name and filters:
+// a client can't make up positions, nor carry a cursor over to another list or filter.
+// Positions, not offsets, so an item added meanwhile doesn't shift the next page.
+
+const (
+	pageDefaultLimit = 50
+	pageMaxLimit     = 1000
+)
+
+// pager signs and checks the cursors.
+type pager struct {
+	key []byte
+}
+
+func newPager(secret string) *pager {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &pager{key: key}
+}
+
+// pageKey is a position in a list: created_at in unix nanoseconds, then the id, which is more
+// than one column for some lists. Lists run from the greatest key down.
+type pageKey struct {
+	At int64    `json:"t"`
+	ID []string `json:"i"`
+}
+
+func (k pageKey) compare(o pageKey) int {
+	return cmp.Or(cmp.Compare(k.At, o.At), slices.Compare(k.ID, o.ID))
+}
+
+// pageReq is one page asked for.
+type pageReq struct {
+	Limit int
+	After *pageKey  // the last item of the previous page; nil for the first
+	Since time.Time // zero for no lower bound
+
+	scope string // the list and its filters, which the cursor is signed over
+}
+
+// cursorToken is a cursor's signed content.
+type cursorToken struct {
+	Scope string `json:"s"`
+	pageKey
+}
+
+// readPage reads limit, cursor and since for the list, narrowed by the query parameters named
+// in filters.
+func (p *pager) readPage(r *http.Request, list string, filters ...string) (pageReq, *httpError) {
+	q := r.URL.Query()
+	req := pageReq{Limit: pageDefaultLimit}
+	if q.Has("offset") {
+		return req, &httpError{http.StatusBadRequest, codeBadRequest, "offset is not supported; follow next_cursor"}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > pageMaxLimit {
+			return req, &httpError{http.StatusBadRequest, codeBadRequest, fmt.Sprintf("limit must be 1-%d", pageMaxLimit)}
+		}
+		req.Limit = n
+	}
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return req, &httpError{http.StatusBadRequest, codeBadRequest, "since must be an RFC 3339 time"}
+		}
+		req.Since = t
+	}
+	scope := []string{list, "since=" + q.Get("since")}
+	for _, f := range filters {
+		scope = append(scope, f+"="+q.Get(f))
+	}
+	req.scope = strings.Join(scope, "\x00")
+	if v := q.Get("cursor"); v != "" {
+		k, err := p.open(v, req.scope)
+		if err != nil {
+			return req, &httpError{http.StatusBadRequest, codeInvalidCursor, err.Error()}
+		}
+		req.After = &k
+	}
+	return req, nil
+}
+
+// cursor is the signed cursor for the page after the one ending at k.
+func (p *pager) cursor(req pageReq, k pageKey) string {
+	body, _ := json.Marshal(cursorToken{Scope: p.digest(req.scope), pageKey: k})
+	return cursorEncoding.EncodeToString(body) + "." + cursorEncoding.EncodeToString(p.sign(body))
+}
+
+// cursorEncoding is strict so a cursor has one spelling: an altered last character is refused
+// rather than decoding to the same bytes.
+var cursorEncoding = base64.RawURLEncoding.Strict()
+
+func (p *pager) open(cursor, scope string) (pageKey, error) {
+	body64, sig64, _ := strings.Cut(cursor, ".")
+	body, err := cursorEncoding.DecodeString(body64)
+	if err != nil {
+		return pageKey{}, errInvalidCursor
+	}
+	sig, err := cursorEncoding.DecodeString(sig64)
+	if err != nil || !hmac.Equal(sig, p.sign(body)) {
+		return pageKey{}, errInvalidCursor
+	}
+	var t cursorToken
+	if err := json.Unmarshal(body, &t); err != nil || len(t.ID) == 0 {
+		return pageKey{}, errInvalidCursor
+	}
+	if t.Scope != p.digest(scope) {
+		return pageKey{}, errCursorScope
+	}
+	return t.pageKey, nil
+}
+
+var (
+	errInvalidCursor = errors.New("cursor is not one this server issued; start again without it")
+	errCursorScope   = errors.New("cursor belongs to another list or other filters; repeat the filters it was issued with")
+)
+
+func (p *pager) sign(body []byte) []byte {
+	m := hmac.New(sha256.New, p.key)
+	m.Write(body)
+	return m.Sum(nil)[:16]
+}
+
+// digest keeps the scope out of the cursor itself: filters may be text an admin searched for.
+func (p *pager) digest(scope string) string {
+	m := hmac.New(sha256.New, p.key)
+	m.Write([]byte("scope\x00" + scope))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil)[:9])
+}
+
+// pageOf is the page req asks for of items, already filtered, and the cursor of the next page,
+// "" on the last. For lists held in memory; database lists filter on req.Since themselves and
+// seek with seekAfter.
+func pageOf[T any](p *pager, req pageReq, items []T, key func(T) pageKey) ([]T, string) {
+	out := make([]T, 0, min(len(items), req.Limit+1))
+	for _, it := range items {
+		k := key(it)
+		if (req.After == nil || k.compare(*req.After) < 0) && (req.Since.IsZero() || k.At >= req.Since.UnixNano()) {
+			out = append(out, it)
+		}
+	}
+	slices.SortFunc(out, func(a, b T) int { return key(b).compare(key(a)) })
+	return pageTail(p, req, out, key)
+}
+
+// pageTail trims items, fetched as req.Limit+1 in list order, to the page, with the cursor of
+// the next one when the extra item is there.
+func pageTail[T any](p *pager, req pageReq, items []T, key func(T) pageKey) ([]T, string) {
+	if len(items) <= req.Limit {
+		return items, ""
+	}
+	items = items[:req.Limit]
+	return items, p.cursor(req, key(items[len(items)-1]))
+}
+
+// seekAfter extends where, a filter's " WHERE ..." clause or "", and its arguments to the rows
+// after req.After, by the created_at column (unix seconds) and then the id columns. Rows are to
+// be read ORDER BY those columns DESC, req.Limit+1 of them, for pageTail.
+func seekAfter(where string, args []any, req pageReq, idCols ...string) (string, []any) {
+	if req.After == nil || len(req.After.ID) != len(idCols) {
+		return where, args
+	}
+	cond := "(created_at, " + strings.Join(idCols, ", ") + ") < (?" + strings.Repeat(", ?", len(idCols)) + ")"
+	args = append(slices.Clip(args), time.Unix(0, req.After.At).Unix())
+	for _, id := range req.After.ID {
+		args = append(args, id)
+	}
+	if where == "" {
+		return " WHERE " + cond, args
+	}
+	return where + " AND " + cond, args
+}
+
+// matchParam reports whether a filter's value v, empty for any, admits got.
+func matchParam(v, got string) bool { return v == "" || v == got }
+
+// pageBody adds next_cursor to a list response when there is a next page.
+func pageBody(body map[string]any, next string) map[string]any {
+	if next != "" {
+		body["next_cursor"] = next
+	}
+	return body
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAdminPaging mints keys a second apart, two of them in the same second, and walks
+// GET /go/admin/keys filtered to them: newest first with the tie broken by id, next_cursor on
+// every page but the last, including a last page that ends exactly on the final key.
+func TestAdminPaging(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := newTestServer(t, nil, Deps{Clock: clk}).Handler()
+	type key struct {
+		ID        string    `json:"id"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	var minted []key
+	for i, tier := range []string{"pro", "free", "pro", "pro", "pro"} {
+		if i != 3 { // the fourth shares the third's second
+			clk.Advance(time.Second)
+		}
+		w := serve(h, "POST", "/go/admin/keys", `{"client_id":"paged","tier":"`+tier+`"}`, "Authorization", "Bearer "+testAdmin, "Content-Type", "application/json")
+		var k key
+		json.Unmarshal(w.Body.Bytes(), &k)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("mint: status %d: %s", w.Code, w.Body.String())
+		}
+		if tier == "pro" {
+			minted = append(minted, k)
+		}
+	}
+	slices.SortFunc(minted, func(a, b key) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+	var want []string
+	for _, k := range minted {
+		want = append(want, k.ID)
+	}
+
+	type page struct {
+		Keys       []key  `json:"keys"`
+		Count      int    `json:"count"`
+		NextCursor string `json:"next_cursor"`
+	}
+	get := func(target string) (*httptest.ResponseRecorder, page) {
+		t.Helper()
+		w := serve(h, "GET", target, "", "Authorization", "Bearer "+testAdmin)
+		var p page
+		json.Unmarshal(w.Body.Bytes(), &p)
+		return w, p
+	}
+	for limit := 1; limit <= len(want)+1; limit++ {
+		var got []string
+		pages, cursor := 0, ""
+		for {
+			target := "/go/admin/keys?client=paged&tier=pro&limit=" + strconv.Itoa(limit)
+			if cursor != "" {
+				target += "&cursor=" + cursor
+			}
+			w, p := get(target)
+			if w.Code != http.StatusOK || p.Count != len(p.Keys) {
+				t.Fatalf("limit %d page %d: status %d: %s", limit, pages+1, w.Code, w.Body.String())
+			}
+			if strings.Contains(w.Body.String(), "next_cursor") != (p.NextCursor != "") {
+				t.Fatalf("limit %d: next_cursor sent empty: %s", limit, w.Body.String())
+			}
+			pages++
+			for _, k := range p.Keys {
+				got = append(got, k.ID)
+			}
+			if cursor = p.NextCursor; cursor == "" {
+				break
+			}
+		}
+		if wantPages := (len(want) + limit - 1) / limit; pages != wantPages || !slices.Equal(got, want) {
+			t.Fatalf("limit %d: %v over %d pages, want %v over %d", limit, got, pages, want, wantPages)
+		}
+	}
+
+	if _, p := get("/go/admin/keys?client=paged&since=2026-03-01T12:00:03Z"); len(p.Keys) != 3 || p.NextCursor != "" {
+		t.Fatalf("since the third second: %+v, want the 3 keys minted then or later", p)
+	}
+}
+
+// TestAdminPagingRefusals checks the limits, since and offset are validated, and that a cursor
+// altered in its body or signature, used with other filters, on another list or by a server with
+// another secret is a 400 INVALID_CURSOR.
+func TestAdminPagingRefusals(t *testing.T) {
+	h := newTestServer(t, map[string]string{"PAGE_CURSOR_SECRET": "s1", "AUDIT_LOG_PATH": filepath.Join(t.TempDir(), "audit.jsonl")}, Deps{}).Handler()
+	for _, q := range []string{"limit=0", "limit=1001", "limit=ten", "since=yesterday", "offset=50"} {
+		w := serve(h, "GET", "/go/admin/keys?"+q, "", "Authorization", "Bearer "+testAdmin)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"BAD_REQUEST"`) {
+			t.Errorf("%s: status %d: %s", q, w.Code, w.Body.String())
+		}
+	}
+
+	pg := newPager("s1")
+	req, herr := pg.readPage(httptest.NewRequest("GET", "/go/admin/keys?tier=pro", nil), "keys", "tier", "status", "source", "client")
+	if herr != nil {
+		t.Fatal(herr)
+	}
+	cursor := pg.cursor(req, pageKey{At: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC).UnixNano(), ID: []string{"key_0001"}})
+	body, sig, _ := strings.Cut(cursor, ".")
+	flip := func(s string) string {
+		b := []byte(s)
+		b[len(b)/2] ^= 1
+		return string(b)
+	}
+	cursorWith := func(p *pager) string { return p.cursor(req, pageKey{At: 1, ID: []string{"key_0001"}}) }
+	for _, tt := range []struct {
+		name, target, message string
+	}{
+		{"body", "/go/admin/keys?tier=pro&cursor=" + flip(body) + "." + sig, errInvalidCursor.Error()},
+		{"signature", "/go/admin/keys?tier=pro&cursor=" + body + "." + flip(sig), errInvalidCursor.Error()},
+		{"unsigned", "/go/admin/keys?tier=pro&cursor=" + body, errInvalidCursor.Error()},
+		{"other secret", "/go/admin/keys?tier=pro&cursor=" + cursorWith(newPager("s2")), errInvalidCursor.Error()},
+		{"other filters", "/go/admin/keys?tier=free&cursor=" + cursor, errCursorScope.Error()},
+		{"fewer filters", "/go/admin/keys?cursor=" + cursor, errCursorScope.Error()},
+		{"other list", "/go/admin/audit?cursor=" + cursor, errCursorScope.Error()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "GET", tt.target, "", "Authorization", "Bearer "+testAdmin)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want 400: %s", w.Code, w.Body.String())
+			}
+			checkEnvelope(t, w)
+			var res struct {
+				Error map[string]any `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if res.Error["code"] != string(codeInvalidCursor) || res.Error["message"] != tt.message {
+				t.Fatalf("body %s, want INVALID_CURSOR %q", w.Body.String(), tt.message)
+			}
+		})
+	}
+	if w := serve(h, "GET", "/go/admin/keys?tier=pro&cursor="+cursor, "", "Authorization", "Bearer "+testAdmin); w.Code != http.StatusOK {
+		t.Fatalf("the untouched cursor: status %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Untranslatable input. "😂😂😂", "12345" or "!!!" have nothing for the model to translate, and
+// sending them upstream costs a call for nonsense back. Input without a Thaana or Latin letter
+// outside its URLs is answered with itself, as src "passthrough", and charges no characters.
+// Input that does have words keeps its emoji, URLs and figures (see digits.go) where they were:
+// they are masked with numbered placeholders ahead of the glossary terms and put back after,
+// the same way.
+
+const srcPassthrough = "passthrough"
+
+// urlPattern finds links, so their letters don't make input translatable and the model can't
+// rewrite them. Trailing sentence punctuation is trimmed off a match.
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+const urlTrailing = `.,;:!?)]}'"`
+
+// translatable reports whether q has a Thaana or Latin letter outside its URLs.
+func translatable(q string) bool {
+	for _, r := range urlPattern.ReplaceAllString(q, " ") {
+		if unicode.IsLetter(r) && (unicode.Is(unicode.Thaana, r) || unicode.Is(unicode.Latin, r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// emojiRune reports symbols that start or continue an emoji: pictographs, dingbats and flag
+// letters are So, and skin tones are Sk.
+func emojiRune(r rune) bool {
+	return unicode.Is(unicode.So, r) || (r >= 0x1F3FB && r <= 0x1F3FF)
+}
+
+// emojiJoiner reports the runes that only occur inside an emoji sequence: the zero-width joiner,
+// variation selectors and the keycap mark.
+func emojiJoiner(r rune) bool {
+	return r == '\u200d' || r == '\ufe0e' || r == '\ufe0f' || r == '\u20e3'
+}
+
+// protectOpaque replaces the URLs, numbers, dates, times and emoji sequences in q with
+// placeholders numbered from 0; restore puts them back. The matches have no term, so restore
+// doesn't report them.
+func protectOpaque(q string) (string, []glossaryMatch) {
+	var spans []opaqueSpan
+	add := func(start, end int, number bool) { spans = append(spans, opaqueSpan{start, end, number}) }
+	// URLs first, then the numbers and emoji between them.
+	gaps, last := [][2]int{}, 0
+	for _, l := range urlPattern.FindAllStringIndex(q, -1) {
+		gaps = append(gaps, [2]int{last, l[0]})
+		last = l[0] + len(strings.TrimRight(q[l[0]:l[1]], urlTrailing))
+		add(l[0], last, false)
+	}
+	gaps = append(gaps, [2]int{last, len(q)})
+	for _, g := range gaps {
+		from := g[0]
+		for _, n := range numericSpans(q[g[0]:g[1]]) {
+			emojiSpans(q, from, g[0]+n[0], add)
+			add(g[0]+n[0], g[0]+n[1], true)
+			from = g[0] + n[1]
+		}
+		emojiSpans(q, from, g[1], add)
+	}
+	if len(spans) == 0 {
+		return q, nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var b strings.Builder
+	matches := make([]glossaryMatch, 0, len(spans))
+	last = 0
+	for _, s := range spans {
+		b.WriteString(q[last:s.start])
+		b.WriteString(placeholder(len(matches)))
+		matches = append(matches, glossaryMatch{replacement: q[s.start:s.end], opaque: true, number: s.number})
+		last = s.end
+	}
+	b.WriteString(q[last:])
+	return b.String(), matches
+}
+
+type opaqueSpan struct {
+	start, end int
+	number     bool // a number, date or time, whose digits convertDigits may rewrite
+}
+
+// emojiSpans adds the emoji sequences in q[from:to].
+func emojiSpans(q string, from, to int, add func(start, end int, number bool)) {
+	start := -1
+	for i, r := range q[from:to] {
+		switch {
+		case emojiRune(r) || start >= 0 && emojiJoiner(r):
+			if start < 0 {
+				start = from + i
+			}
+		case start >= 0:
+			add(start, from+i, false)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		add(start, to, false)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// queryUpstream echoes like echoUpstream and keeps the last text it was sent.
+type queryUpstream struct {
+	echoUpstream
+	mu   sync.Mutex
+	last string
+}
+
+func (u *queryUpstream) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	u.mu.Lock()
+	u.last = req.Q
+	u.mu.Unlock()
+	return u.echoUpstream.Translate(ctx, req)
+}
+
+var passthroughCases = []struct {
+	name string
+	q    string
+	want bool // translatable
+}{
+	{"emoji", "😂😂😂", false},
+	{"skin tone", "👍🏽", false},
+	{"zwj family", "👨‍👩‍👧", false},
+	{"flag", "🇲🇻", false},
+	{"keycap", "1️⃣", false},
+	{"digits", "12345", false},
+	{"arabic-indic digits", "١٢٣", false},
+	{"punctuation", "!!!", false},
+	{"date and time", "14/10/2026 09:30", false},
+	{"url", "https://example.com/path?q=word", false},
+	{"www url", "www.dhkalign.example.", false},
+	{"urls and emoji", "👉 https://a.example/x 🙏", false},
+	{"other script", "привет", false},
+	{"latin", "salaam", true},
+	{"latin with diacritics", "Malé", true},
+	{"thaana", "ކިހިނެއް", true},
+	{"text and emoji", "salaam 😂", true},
+	{"text and zwj emoji", "salaam 👨‍👩‍👧 friend", true},
+	{"text and url", "see https://a.example/x", true},
+	{"text and digits", "room 12", true},
+	{"one letter", "!a!", true},
+}
+
+func TestTranslatable(t *testing.T) {
+	for _, tt := range passthroughCases {
+		if got := translatable(tt.q); got != tt.want {
+			t.Errorf("%s: translatable(%q) = %v, want %v", tt.name, tt.q, got, tt.want)
+		}
+	}
+}
+
+// TestProtectOpaque checks emoji, URLs and figures are masked in place, in order, and that
+// restore puts them back without reporting them as glossary terms.
+func TestProtectOpaque(t *testing.T) {
+	tests := []struct {
+		q, masked string
+	}{
+		{"good morning", "good morning"},
+		{"salaam 😂 friend", "salaam ⟦0⟧ friend"},
+		{"👍🏽 ok 👨‍👩‍👧", "⟦0⟧ ok ⟦1⟧"},
+		{"see https://a.example/x.", "see ⟦0⟧."},
+		{"room 12 at 09:30 🙏", "room ⟦0⟧ at ⟦1⟧ ⟦2⟧"},
+		{"(https://a.example/1) 😂", "(⟦0⟧) ⟦1⟧"},
+	}
+	for _, tt := range tests {
+		masked, matches := protectOpaque(tt.q)
+		if masked != tt.masked {
+			t.Errorf("protectOpaque(%q) = %q, want %q", tt.q, masked, tt.masked)
+			continue
+		}
+		out, applied, client, missing := restore(masked, matches)
+		if out != tt.q || applied != nil || client != nil || missing != nil {
+			t.Errorf("restore(%q) = %q, applied %v, client %v, missing %v; want %q and no terms", masked, out, applied, client, missing, tt.q)
+		}
+	}
+}
+
+// TestPassthrough sends each case through /go/translate: untranslatable input comes back as it
+// was with src passthrough and never reaches the upstream, and mixed input reaches it with its
+// emoji and links masked and gets them back in place.
+func TestPassthrough(t *testing.T) {
+	up := &queryUpstream{}
+	h := newTestServer(t, nil, Deps{Upstream: up}).Handler()
+	for _, tt := range passthroughCases {
+		t.Run(tt.name, func(t *testing.T) {
+			before := up.calls.Load()
+			w := serve(h, "GET", "/go/translate?src=latin&dst=en&q="+url.QueryEscape(tt.q), "", "X-API-Key", testProKey)
+			var res translateResult
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+			called := up.calls.Load() != before
+			if !tt.want {
+				if res.Translation != tt.q || res.Src != srcPassthrough || called {
+					t.Fatalf("%q: %q from %q (upstream called: %v), want it back as passthrough", tt.q, res.Translation, res.Src, called)
+				}
+				return
+			}
+			if res.Src == srcPassthrough || !called && res.Src != "pack" {
+				t.Fatalf("%q: src %q, upstream called %v, want it translated", tt.q, res.Src, called)
+			}
+			if called {
+				up.mu.Lock()
+				sent := up.last
+				up.mu.Unlock()
+				masked, _ := protectOpaque(tt.q)
+				if !strings.Contains(sent, masked) || res.Translation != "EN("+tt.q+")" {
+					t.Fatalf("%q: upstream sent %q, answered %q; want %q sent and the original put back", tt.q, sent, res.Translation, masked)
+				}
+			}
+		})
+	}
+}
+
+// TestPassthroughQuota checks passthrough input charges nothing: a key with a 10 character
+// quota answers any number of 10 character passthroughs, and then still has all 10 to spend.
+func TestPassthroughQuota(t *testing.T) {
+	h := newTestServer(t, map[string]string{"QUOTA_CHARS_FREE": "10", "RATE_LIMIT_BURST": "100"}, Deps{}).Handler()
+	for i := range 20 {
+		w := serve(h, "GET", "/go/translate?q=1234567890", "", "X-API-Key", testFreeKey)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"src":"passthrough"`) || w.Header().Get("X-Quota-Remaining") != "" {
+			t.Fatalf("passthrough %d: status %d, X-Quota-Remaining %q: %s", i, w.Code, w.Header().Get("X-Quota-Remaining"), w.Body.String())
+		}
+	}
+	if w := serve(h, "GET", "/go/translate?q=abcdefghij", "", "X-API-Key", testFreeKey); w.Code != http.StatusOK || w.Header().Get("X-Quota-Remaining") != "0" {
+		t.Fatalf("10 characters after the passthroughs: status %d, X-Quota-Remaining %q, want the whole quota spent on them", w.Code, w.Header().Get("X-Quota-Remaining"))
+	}
+	if w := serve(h, "GET", "/go/translate?q=k", "", "X-API-Key", testFreeKey); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("past the quota: status %d, want 429", w.Code)
+	}
+	if w := serve(h, "GET", "/go/translate?q=%F0%9F%98%82", "", "X-API-Key", testFreeKey); w.Code != http.StatusOK {
+		t.Fatalf("passthrough with the quota spent: status %d, want it still answered", w.Code)
+	}
+}
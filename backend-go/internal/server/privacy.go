@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Request content in logs. Some clients translate personal text, so what this server writes
+// about a request anywhere but the response (slow_request lines, error reports, audit entries,
+// upstream failure logs) carries the text to translate, or a translation, only as LOG_CONTENT
+// allows:
+//
+//   - none, the default: its length at most;
+//   - truncated: its first LOG_CONTENT_RUNES runes and a SHA-256 of all of it, enough to tell
+//     lines about the same text apart from the rest without storing it;
+//   - full: as it came, for debugging.
+//
+// The debug headers (X-Upstream, X-Upstream-Attempts) describe calls, never content.
+
+const (
+	logContentNone      = "none"
+	logContentTruncated = "truncated"
+	logContentFull      = "full"
+)
+
+type contentPolicy struct {
+	mode  string
+	runes int
+}
+
+// logContent is the policy in effect; a config reload changes it. Unset is none.
+var logContent atomic.Pointer[contentPolicy]
+
+func setLogContent(mode string, runes int) {
+	logContent.Store(&contentPolicy{mode: mode, runes: runes})
+}
+
+func contentMode() contentPolicy {
+	if p := logContent.Load(); p != nil {
+		return *p
+	}
+	return contentPolicy{mode: logContentNone}
+}
+
+// contentShown reports whether LOG_CONTENT lets any text be written beyond its length.
+func contentShown() bool { return contentMode().mode != logContentNone }
+
+// describeContent is s as LOG_CONTENT lets it be written: "[42 chars]" under none, the first
+// runes and a hash under truncated, s itself under full.
+func describeContent(s string) string {
+	p := contentMode()
+	n := utf8.RuneCountInString(s)
+	switch p.mode {
+	case logContentFull:
+		return s
+	case logContentTruncated:
+		sum := sha256.Sum256([]byte(s))
+		prefix := s
+		if n > p.runes {
+			prefix = string([]rune(s)[:p.runes]) + "…"
+		}
+		return fmt.Sprintf("%s [%d chars sha256:%s]", prefix, n, hex.EncodeToString(sum[:]))
+	}
+	return fmt.Sprintf("[%d chars]", n)
+}
+
+// contentParams are the query parameters that carry text to translate.
+var contentParams = []string{"q"}
+
+// describeQuery is a raw query for a log line: parameters sorted, contentParams through
+// describeContent, nothing escaped.
+func describeQuery(raw string) string {
+	if contentMode().mode == logContentFull {
+		return raw
+	}
+	q, _ := url.ParseQuery(raw)
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var parts []string
+	for _, name := range names {
+		v := strings.Join(q[name], ",")
+		if slices.Contains(contentParams, name) {
+			v = describeContent(v)
+		}
+		parts = append(parts, name+"="+v)
+	}
+	return strings.Join(parts, "&")
+}
+
+// scrubURLError rewrites the URL in a *url.Error, which net/http puts in every transport
+// error, through describeQuery; other errors come back as they are.
+func scrubURLError(err error) error {
+	var ue *url.Error
+	if !errors.As(err, &ue) || contentMode().mode == logContentFull {
+		return err
+	}
+	target, query, _ := strings.Cut(ue.URL, "?")
+	if query != "" {
+		target += "?" + describeQuery(query)
+	}
+	return &url.Error{Op: ue.Op, URL: target, Err: ue.Err}
+}
+
+// scrubUpstreamError is err as a report may quote it: an upstream's own message, which can echo
+// the text it was sent, through describeContent, and a transport error through scrubURLError.
+func scrubUpstreamError(err error) error {
+	var ue *upstreamError
+	if !errors.As(err, &ue) || contentMode().mode == logContentFull {
+		return scrubURLError(err)
+	}
+	scrubbed := *ue
+	scrubbed.Msg = describeContent(ue.Msg)
+	return &scrubbed
+}
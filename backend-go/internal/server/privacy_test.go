@@ -0,0 +1,213 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDescribeContent(t *testing.T) {
+	defer setLogContent(logContentNone, 0)
+	const text = "ކިހިނެއް ތިބެނީ, my address is 12 Orchid Magu"
+	sum := sha256.Sum256([]byte(text))
+	tests := []struct {
+		mode  string
+		runes int
+		want  string
+		query string
+	}{
+		{logContentNone, 16, "[45 chars]", "dst=en&q=[45 chars]&src=dv"},
+		{logContentTruncated, 8, "ކިހިނެއް… [45 chars sha256:" + hex.EncodeToString(sum[:]) + "]", "dst=en&q=ކިހިނެއް… [45 chars sha256:" + hex.EncodeToString(sum[:]) + "]&src=dv"},
+		{logContentTruncated, 100, text + " [45 chars sha256:" + hex.EncodeToString(sum[:]) + "]", ""},
+		{logContentFull, 16, text, "src=dv&q=" + url.QueryEscape(text) + "&dst=en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			setLogContent(tt.mode, tt.runes)
+			if got := describeContent(text); got != tt.want {
+				t.Fatalf("describeContent %q, want %q", got, tt.want)
+			}
+			if tt.query == "" {
+				return
+			}
+			if got := describeQuery("src=dv&q=" + url.QueryEscape(text) + "&dst=en"); got != tt.query {
+				t.Fatalf("describeQuery %q, want %q", got, tt.query)
+			}
+		})
+	}
+}
+
+func TestScrubRequest(t *testing.T) {
+	defer setLogContent(logContentNone, 0)
+	setLogContent(logContentNone, 0)
+	r := httptest.NewRequest("GET", "/go/translate?q=private+words&api_key=secret&src=dv", nil)
+	r.Header.Set("X-API-Key", "secret")
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("User-Agent", "partner-app/1.0")
+	got := scrubRequest(r)
+	b, _ := json.Marshal(got)
+	if strings.Contains(string(b), "secret") || strings.Contains(string(b), "private") {
+		t.Fatalf("report request %s carries a credential or content", b)
+	}
+	if got.Query["q"] != "[13 chars]" || got.Query["src"] != "dv" || got.Headers["User-Agent"] != "partner-app/1.0" {
+		t.Fatalf("report request %s", b)
+	}
+}
+
+// privacyUpstream is the FastAPI translate route, failing by the last word of q: "fail" a 500
+// quoting q back, "drop" a closed connection, "odd" a body that isn't JSON and "lose" a
+// translation without q's glossary placeholders. Anything else is translated.
+func privacyUpstream(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	switch q[strings.LastIndexByte(q, ' ')+1:] {
+	case "fail":
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"ok":false,"error":"cannot translate ` + q + `"}`))
+	case "drop":
+		conn, _, _ := http.NewResponseController(w).Hijack()
+		conn.Close()
+	case "odd":
+		_, _ = w.Write([]byte("<html>" + q + "</html>"))
+	default:
+		tgt := "T(" + q + ")"
+		if strings.HasSuffix(q, " lose") {
+			tgt = "T(nothing)"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "data": map[string]string{"tgt": tgt}})
+	}
+}
+
+// TestLogContent sends text through everything that logs about requests (slow request lines,
+// upstream failures, schema mismatches, lost glossary terms, admin audit entries, the access
+// log and error reports) and then greps all of it. Under none the text must appear nowhere;
+// under truncated only as a prefix and hash; under full, as it was, which shows the grep finds it.
+func TestLogContent(t *testing.T) {
+	defer setLogContent(logContentNone, 0)
+	const (
+		secret = "orchidmagu" // in every text sent
+		term   = "hiyaavahi"  // a glossary term
+	)
+	up := httptest.NewServer(http.HandlerFunc(privacyUpstream))
+	defer up.Close()
+	for _, mode := range []string{logContentNone, logContentTruncated, logContentFull} {
+		t.Run(mode, func(t *testing.T) {
+			var (
+				mu      sync.Mutex
+				reports []string
+				sent    = make(chan struct{}, 1)
+			)
+			hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				reports = append(reports, string(b))
+				mu.Unlock()
+				select {
+				case sent <- struct{}{}:
+				default:
+				}
+			}))
+			defer hook.Close()
+			dir := t.TempDir()
+			env := map[string]string{
+				"LOG_CONTENT":            mode,
+				"LOG_CONTENT_RUNES":      "4",
+				"UPSTREAM_URL":           up.URL,
+				"UPSTREAM_MAX_ATTEMPTS":  "1",
+				"BREAKER_FAILURES":       "100",
+				"SLOW_REQUEST_THRESHOLD": "1ns",
+				"ACCESS_LOG_FORMAT":      accessLogJSON,
+				"ACCESS_LOG_FILE":        filepath.Join(dir, "access.log"),
+				"AUDIT_LOG_PATH":         filepath.Join(dir, "audit.log"),
+				"TM_DB_PATH":             filepath.Join(dir, "tm.db"),
+				"GLOSSARY_DB_PATH":       filepath.Join(dir, "glossary.db"),
+				"ERROR_WEBHOOK_URL":      hook.URL,
+				"ERROR_REPORT_BURST":     "1",
+				"EGRESS_ALLOWLIST":       "127.0.0.1",
+			}
+			mark := suiteLog.mark()
+			t.Run("requests", func(t *testing.T) {
+				h := newTestServer(t, env, Deps{}).Handler()
+				json := "application/json"
+				for _, rq := range []struct {
+					method, target, body string
+					headers              []string
+				}{
+					{"PUT", "/go/keys/self/glossary", `{"` + term + `": "` + term + `"}`, []string{"X-API-Key", testProKey, "Content-Type", json}},
+					{"GET", "/go/translate?q=" + secret + "+fine", "", []string{"X-API-Key", testProKey}},
+					{"POST", "/go/translate", `{"q":"` + secret + ` posted"}`, []string{"X-API-Key", testProKey, "Content-Type", json}},
+					{"POST", "/go/translate/batch", `{"items":[{"id":"1","q":"` + secret + ` one"},{"id":"2","q":"` + secret + ` two"}]}`, []string{"X-API-Key", testProKey, "Content-Type", json}},
+					{"GET", "/go/translate?q=" + secret + "+" + term + "+lose", "", []string{"X-API-Key", testProKey}},
+					{"GET", "/go/translate?q=" + secret + "+odd", "", []string{"X-API-Key", testProKey}},
+					{"GET", "/go/translate?q=" + secret + "+drop", "", []string{"X-API-Key", testProKey}},
+					{"GET", "/go/translate?q=" + secret + "+fail", "", []string{"X-API-Key", testProKey}},
+					{"PUT", "/go/admin/tm", `{"q":"` + secret + ` corrected","translation":"` + secret + ` fixed"}`, []string{"Authorization", "Bearer " + testAdmin, "Content-Type", json}},
+					{"DELETE", "/go/admin/cache?q=" + secret + "+fine", "", []string{"Authorization", "Bearer " + testAdmin}},
+				} {
+					serve(h, rq.method, rq.target, rq.body, rq.headers...)
+				}
+				// Error reports go out in the background; wait for the burst's.
+				select {
+				case <-sent:
+				case <-time.After(5 * time.Second):
+					t.Fatal("no error report sent")
+				}
+			})
+
+			outputs := map[string]string{"structured log": suiteLog.since(mark)}
+			for _, name := range []string{"access.log", "audit.log"} {
+				b, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				outputs[name] = string(b)
+			}
+			mu.Lock()
+			outputs["error reports"] = strings.Join(reports, "\n")
+			mu.Unlock()
+			for name, out := range outputs {
+				if out == "" {
+					t.Fatalf("nothing in the %s", name)
+				}
+			}
+			for _, want := range []string{`"slow_request"`, "upstream request failed", "glossary placeholders lost upstream", `"action":"tm.correct"`, `"kind":"upstream_5xx_burst"`} {
+				if !strings.Contains(strings.Join([]string{outputs["structured log"], outputs["audit.log"], outputs["error reports"]}, "\n"), want) {
+					t.Fatalf("no %s in the output; the requests didn't reach what logs them", want)
+				}
+			}
+
+			var leaks []string
+			for name, out := range outputs {
+				for _, line := range strings.Split(out, "\n") {
+					if strings.Contains(line, secret) || strings.Contains(line, term) {
+						leaks = append(leaks, name+": "+line)
+					}
+				}
+			}
+			hashed := strings.Contains(outputs["structured log"]+outputs["audit.log"]+outputs["error reports"], "sha256:")
+			switch mode {
+			case logContentNone, logContentTruncated:
+				if len(leaks) > 0 {
+					t.Fatalf("under LOG_CONTENT=%s, request content was written:\n%s", mode, strings.Join(leaks, "\n"))
+				}
+				if hashed != (mode == logContentTruncated) {
+					t.Fatalf("under LOG_CONTENT=%s, hashes written: %v", mode, hashed)
+				}
+			case logContentFull:
+				if len(leaks) == 0 {
+					t.Fatal("under LOG_CONTENT=full, the content never showed up; the grep proves nothing")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Provenance. With ?debug=1, or for pro keys on every call with PROVENANCE_PRO, a /go/translate
+// response carries where its translation came from: the normalized input and direction, each
+// layer the request went through in order (pack, glossary, cache, tm, each upstream call) with
+// its outcome and time, which of them answered, the glossary terms put back, and the same per
+// sentence when the input was split.
+//
+// Each layer writes its own stage as it runs, through the provenance on the request's context;
+// nothing is pieced together from the result afterwards, so a stage that ran shows up even when
+// a later one erased its trace from the result. Without a provenance on the context the calls
+// are no-ops. Text, the input and glossary terms, goes through describeContent: under the
+// default LOG_CONTENT=none the object says how long it is, not what it says.
+
+// Stage outcomes. hit, stale_hit, ok and shared (a concurrent request's answer) answer the
+// request; the first stage to answer is the provenance's answered_by.
+const (
+	stageHit      = "hit"
+	stageStaleHit = "stale_hit"
+	stageOK       = "ok"
+	stageShared   = "shared"
+	stageMiss     = "miss"
+	stageFailed   = "failed"
+	stageInjected = "injected" // a fault injected for testing; see faults.go
+)
+
+// provenance collects one translation's stages, or one sentence's.
+type provenance struct {
+	clock    Clock // what the stages are timed on
+	start    time.Time
+	deadline *requestDeadline // the request's budget; nil for a sentence
+
+	mu       sync.Mutex
+	input    provenanceInput
+	stages   []provenanceStage
+	answered *provenanceAnswer
+	glossary *provenanceGlossary
+	segments []*provenance // one per sentence, in order; nil unless split
+}
+
+// provenanceView is the response's provenance object.
+type provenanceView struct {
+	Input      provenanceInput     `json:"input"`
+	AnsweredBy *provenanceAnswer   `json:"answered_by"` // null when nothing answered, as on an error
+	Stages     []provenanceStage   `json:"stages"`
+	Glossary   *provenanceGlossary `json:"glossary,omitempty"`
+	Segments   []provenanceSegment `json:"segments,omitempty"`
+	Deadline   *requestDeadline    `json:"deadline,omitempty"` // the budget the request had, and whether X-Deadline-Ms set it
+	TotalMS    float64             `json:"total_ms"`
+}
+
+// provenanceSegment is one sentence's part of a split input.
+type provenanceSegment struct {
+	Input      provenanceInput     `json:"input"`
+	AnsweredBy *provenanceAnswer   `json:"answered_by"`
+	Stages     []provenanceStage   `json:"stages"`
+	Glossary   *provenanceGlossary `json:"glossary,omitempty"`
+}
+
+type provenanceInput struct {
+	Normalized string `json:"normalized"` // as LOG_CONTENT lets it be shown
+	Chars      int    `json:"chars"`
+	Src        string `json:"src,omitempty"`
+	Dst        string `json:"dst,omitempty"`
+	Detected   bool   `json:"detected,omitempty"` // src was detected, not given
+}
+
+// provenanceStage is one layer's part.
+type provenanceStage struct {
+	Layer      string           `json:"layer"`            // input, segments, pack, glossary, cache, flight, tm, upstream, stub or pack_only
+	Outcome    string           `json:"outcome"`          // hit, stale_hit, miss, error_hit, shared, ok, failed, applied, none, ...
+	Detail     string           `json:"detail,omitempty"` // the pack file, the upstream member, the cache tier, the sentences' src
+	Attempts   int              `json:"attempts,omitempty"`
+	Timeout    *upstreamTimeout `json:"timeout,omitempty"` // an upstream stage's first-attempt timeout, with UPSTREAM_ADAPTIVE_TIMEOUT
+	Error      string           `json:"error,omitempty"`
+	StartMS    float64          `json:"start_ms"` // since the translation started
+	DurationMS float64          `json:"duration_ms"`
+}
+
+type provenanceAnswer struct {
+	Layer  string `json:"layer"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// provenanceGlossary is the glossary terms found in the input and put back in the translation.
+type provenanceGlossary struct {
+	Terms       []string `json:"terms"`
+	ClientTerms []string `json:"client_terms,omitempty"` // of those, the ones from the key's own glossary
+	Lost        []string `json:"lost,omitempty"`         // masked, but missing from the upstream's answer
+}
+
+type provenanceCtxKey struct{}
+
+// startProvenance starts a provenance on ctx, timed on clk, with the deadline routeTimeout put
+// there.
+func startProvenance(ctx context.Context, clk Clock) (context.Context, *provenance) {
+	p := &provenance{clock: clk, start: clk.Now()}
+	if dl, ok := deadlineFrom(ctx); ok {
+		p.deadline = &dl
+	}
+	return context.WithValue(ctx, provenanceCtxKey{}, p), p
+}
+
+func provenanceFrom(ctx context.Context) *provenance {
+	p, _ := ctx.Value(provenanceCtxKey{}).(*provenance)
+	return p
+}
+
+// withoutProvenance is ctx with no provenance, for work that outlives the request (a stale
+// entry's refresh) and so must not write stages into a response already sent.
+func withoutProvenance(ctx context.Context) context.Context {
+	if provenanceFrom(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, provenanceCtxKey{}, (*provenance)(nil))
+}
+
+// splitProvenance gives ctx's provenance n sentences; segmentContext is then each one's context.
+func splitProvenance(ctx context.Context, n int) {
+	if p := provenanceFrom(ctx); p != nil {
+		p.mu.Lock()
+		p.segments = make([]*provenance, n)
+		p.mu.Unlock()
+	}
+}
+
+// segmentContext is the context sentence i, text, translates on, with a provenance of its own.
+func segmentContext(ctx context.Context, i int, text string) context.Context {
+	p := provenanceFrom(ctx)
+	if p == nil {
+		return ctx
+	}
+	sp := &provenance{clock: p.clock, start: p.start, input: provenanceInput{Normalized: describeContent(text), Chars: utf8.RuneCountInString(text)}}
+	p.mu.Lock()
+	if i < len(p.segments) {
+		p.segments[i] = sp
+	}
+	p.mu.Unlock()
+	return context.WithValue(ctx, provenanceCtxKey{}, sp)
+}
+
+// noteProvenanceInput records the normalized input and its direction.
+func noteProvenanceInput(ctx context.Context, q string, n int, pair langPair, detected bool) {
+	if p := provenanceFrom(ctx); p != nil {
+		p.mu.Lock()
+		p.input = provenanceInput{Normalized: describeContent(q), Chars: n, Src: pair.Src, Dst: pair.Dst, Detected: detected}
+		p.mu.Unlock()
+	}
+}
+
+// noteProvenanceGlossary records the glossary terms a translation got back.
+func noteProvenanceGlossary(ctx context.Context, terms, client, lost []string) {
+	p := provenanceFrom(ctx)
+	if p == nil {
+		return
+	}
+	g := &provenanceGlossary{Terms: describeAll(terms), ClientTerms: describeAll(client), Lost: describeAll(lost)}
+	p.mu.Lock()
+	p.glossary = g
+	p.mu.Unlock()
+}
+
+func describeAll(terms []string) []string {
+	if len(terms) == 0 {
+		return nil
+	}
+	out := make([]string, len(terms))
+	for i, t := range terms {
+		out[i] = describeContent(t)
+	}
+	return out
+}
+
+// stageMark is a stage begun and not yet ended. A nil mark, from a context without a
+// provenance, ignores its calls.
+type stageMark struct {
+	p     *provenance
+	i     int
+	start time.Time
+}
+
+// startStage appends a stage for layer to ctx's provenance. Stages are listed in the order they
+// start, so a layer's stage comes before those of the layers it calls.
+func startStage(ctx context.Context, layer string) *stageMark {
+	p := provenanceFrom(ctx)
+	if p == nil {
+		return nil
+	}
+	now := p.clock.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stages = append(p.stages, provenanceStage{Layer: layer, StartMS: ms(now.Sub(p.start))})
+	return &stageMark{p: p, i: len(p.stages) - 1, start: now}
+}
+
+// end closes the stage with outcome and detail.
+func (m *stageMark) end(outcome, detail string) {
+	m.finish(outcome, detail, 0, nil)
+}
+
+// finish closes the stage with outcome and detail, the upstream attempts it took and its error.
+// A stage ends once; later calls are ignored. The first stage to end with an answering outcome
+// is what answered.
+func (m *stageMark) finish(outcome, detail string, attempts int, err error) {
+	if m == nil {
+		return
+	}
+	d := m.p.clock.Now().Sub(m.start)
+	m.p.mu.Lock()
+	defer m.p.mu.Unlock()
+	st := &m.p.stages[m.i]
+	if st.Outcome != "" {
+		return
+	}
+	st.Outcome, st.Detail, st.Attempts, st.DurationMS = outcome, detail, attempts, ms(d)
+	if err != nil {
+		st.Error = scrubURLError(err).Error()
+	}
+	if m.p.answered == nil && (outcome == stageHit || outcome == stageStaleHit || outcome == stageOK || outcome == stageShared) {
+		m.p.answered = &provenanceAnswer{Layer: st.Layer, Detail: detail}
+	}
+}
+
+// noteTimeout records the timeout an upstream stage's call got; a zero t, with adaptive
+// timeouts off, records nothing.
+func (m *stageMark) noteTimeout(t upstreamTimeout) {
+	if m == nil || t.Class == "" {
+		return
+	}
+	m.p.mu.Lock()
+	m.p.stages[m.i].Timeout = &t
+	m.p.mu.Unlock()
+}
+
+// provenanceAnswered reports whether a stage of ctx's provenance has answered; true without a provenance.
+func provenanceAnswered(ctx context.Context) bool {
+	p := provenanceFrom(ctx)
+	if p == nil {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.answered != nil
+}
+
+// view is the provenance as the response carries it.
+func (p *provenance) view() provenanceView {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v := provenanceView{
+		Input:      p.input,
+		AnsweredBy: p.answered,
+		Stages:     append([]provenanceStage{}, p.stages...),
+		Glossary:   p.glossary,
+		Deadline:   p.deadline,
+		TotalMS:    ms(p.clock.Now().Sub(p.start)),
+	}
+	for _, sp := range p.segments {
+		if sp == nil {
+			continue // not started: an earlier sentence failed
+		}
+		sp.mu.Lock()
+		v.Segments = append(v.Segments, provenanceSegment{Input: sp.input, AnsweredBy: sp.answered, Stages: append([]provenanceStage{}, sp.stages...), Glossary: sp.glossary})
+		sp.mu.Unlock()
+	}
+	return v
+}
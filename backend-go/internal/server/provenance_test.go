@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// provenanceOf is the provenance object of a translate response, indented for a golden file,
+// with the upstream's address, a random port, as "upstream".
+func provenanceOf(t *testing.T, h http.Handler, upstream, target string, headers ...string) []byte {
+	t.Helper()
+	w := serve(h, "GET", target, "", headers...)
+	var out struct {
+		Provenance json.RawMessage `json:"provenance"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &out)
+	if w.Code != http.StatusOK || out.Provenance == nil {
+		t.Fatalf("%s: status %d, without a provenance: %s", target, w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" || w.Header().Get("ETag") != "" {
+		t.Fatalf("%s: Cache-Control %q, ETag %q with a provenance, want no-store and none", target, cc, w.Header().Get("ETag"))
+	}
+	b, _ := json.MarshalIndent(out.Provenance, "", "  ")
+	return append(bytes.ReplaceAll(b, []byte(upstream), []byte("upstream")), '\n')
+}
+
+// TestProvenanceGolden pins the provenance of a pack hit, an upstream call, the cache hit after
+// it, a split input and a glossary term, on a clock that doesn't move so every time is 0, under
+// LOG_CONTENT none and full. A change to the schema shows as a diff in testdata/provenance.
+func TestProvenanceGolden(t *testing.T) {
+	up := httptest.NewServer(&fakeUpstream{})
+	defer up.Close()
+	u, _ := url.Parse(up.URL)
+	for _, mode := range []string{logContentNone, logContentFull} {
+		t.Run(mode, func(t *testing.T) {
+			clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+			gl := filepath.Join(t.TempDir(), "glossary.json")
+			if err := os.WriteFile(gl, []byte(`{"Maafushi": true}`), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			h := newTestServer(t, map[string]string{"LOG_CONTENT": mode, "GLOSSARY_PATH": gl, "UPSTREAM_URL": up.URL}, Deps{Clock: clk}).Handler()
+			for _, tt := range []struct{ name, target string }{
+				{"pack", "/go/translate?q=rangalhu&src=latin&dst=en&debug=1"},
+				{"upstream", "/go/translate?q=miadhu+harudhu&src=latin&dst=en&debug=1"},
+				{"cache", "/go/translate?q=miadhu+harudhu&src=latin&dst=en&debug=1"},
+				{"segments", "/go/translate?q=kihineh%3F+miadhu+harudhu.&src=latin&dst=en&debug=1"},
+				{"glossary", "/go/translate?q=ferry+to+Maafushi&src=latin&dst=en&debug=1"},
+			} {
+				got := provenanceOf(t, h, u.Host, tt.target, "X-API-Key", testFreeKey)
+				if mode == logContentNone && (strings.Contains(string(got), "harudhu") || strings.Contains(string(got), "rangalhu")) {
+					t.Fatalf("%s: the input shows under LOG_CONTENT=none:\n%s", tt.name, got)
+				}
+				golden(t, filepath.Join("provenance", mode, tt.name+".golden"), got)
+			}
+		})
+	}
+}
+
+// TestProvenanceOptIn checks only ?debug=1 adds a provenance, unless PROVENANCE_PRO gives one to
+// every pro call.
+func TestProvenanceOptIn(t *testing.T) {
+	for _, tt := range []struct {
+		env        map[string]string
+		key, query string
+		want       bool
+	}{
+		{nil, testFreeKey, "", false},
+		{nil, testProKey, "", false},
+		{nil, testFreeKey, "&debug=1", true},
+		{map[string]string{"PROVENANCE_PRO": "true"}, testProKey, "", true},
+		{map[string]string{"PROVENANCE_PRO": "true"}, testFreeKey, "", false},
+	} {
+		h := newTestServer(t, tt.env, Deps{}).Handler()
+		w := serve(h, "GET", "/go/translate?q=salaam&src=dv&dst=en"+tt.query, "", "X-API-Key", tt.key)
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"provenance"`) != tt.want {
+			t.Errorf("%v, key %s%s: status %d, provenance %v: %s", tt.env, tt.key, tt.query, w.Code, !tt.want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per caller: each bucket holds up to burst tokens and refills at
+// rate tokens/second. Buckets idle longer than idleTTL are garbage-collected, and with
+// maxBuckets set the least recently used bucket is dropped to make room for a new caller, so
+// callers the limiter can't trust to be few (client IPs) can't grow it without bound.
+type rateLimiter struct {
+	mu         sync.Mutex
+	rate       float64
+	burst      float64
+	idleTTL    time.Duration
+	maxBuckets int                      // 0 is unbounded
+	buckets    map[string]*list.Element // of *bucket
+	lru        *list.List               // most recently used first
+
+	shared     *sharedLimits // nil keeps every bucket here
+	sharedName string        // the limiter's part of its Redis keys
+	clock      Clock
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// rateDecision is the outcome of one take, with the numbers the X-RateLimit-* headers report.
+type rateDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Time     // when the bucket will be full again
+	RetryAfter time.Duration // when the next token is available (only when !Allowed)
+}
+
+func newRateLimiter(perMinute, burst int, idleTTL time.Duration, clk Clock) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		idleTTL: idleTTL,
+		clock:   clk,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// capped limits l to max buckets and returns it.
+func (l *rateLimiter) capped(max int) *rateLimiter {
+	l.maxBuckets = max
+	return l
+}
+
+// sharedAs keeps l's buckets in s under name, when s isn't nil, and returns it. The local
+// buckets are still used while s is unavailable.
+func (l *rateLimiter) sharedAs(s *sharedLimits, name string) *rateLimiter {
+	l.shared, l.sharedName = s, name
+	return l
+}
+
+// setLimit changes the rate and burst in place, for a config reload. Buckets keep their tokens,
+// down to the new burst.
+func (l *rateLimiter) setLimit(perMinute, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst = float64(perMinute)/60, float64(burst)
+	for _, e := range l.buckets {
+		b := e.Value.(*bucket)
+		b.tokens = min(b.tokens, l.burst)
+	}
+}
+
+// limits is the rate per minute and the burst, as setLimit last left them.
+func (l *rateLimiter) limits() (perMinute, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(math.Round(l.rate * 60)), int(l.burst)
+}
+
+// take consumes one token from key's bucket if available.
+func (l *rateLimiter) take(key string) rateDecision {
+	if l.shared.available("rate") {
+		d, err := l.takeShared(key)
+		if err == nil {
+			return d
+		}
+		l.shared.failed("rate", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	var b *bucket
+	if e, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*bucket)
+	} else {
+		b = &bucket{key: key, tokens: l.burst, last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+		if l.maxBuckets > 0 && l.lru.Len() > l.maxBuckets {
+			l.remove(l.lru.Back())
+		}
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	d := rateDecision{Limit: int(l.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = l.secondsFor(1 - b.tokens)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = now.Add(l.secondsFor(l.burst - b.tokens))
+	return d
+}
+
+// takeShared is take against the bucket in Redis.
+func (l *rateLimiter) takeShared(key string) (rateDecision, error) {
+	l.mu.Lock()
+	rate, burst := l.rate, l.burst
+	full := l.secondsFor(burst)
+	l.mu.Unlock()
+	allowed, tokens, err := l.shared.take(l.sharedName, key, rate, burst, max(l.idleTTL, full))
+	if err != nil {
+		return rateDecision{}, err
+	}
+	d := rateDecision{Allowed: allowed, Limit: int(burst), Remaining: int(tokens)}
+	if !allowed {
+		d.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	d.Reset = l.clock.Now().Add(time.Duration((burst - tokens) / rate * float64(time.Second)))
+	return d, nil
+}
+
+func (l *rateLimiter) secondsFor(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// gc drops buckets that have been idle for idleTTL; an idle bucket has refilled anyway. The
+// LRU list is in order of last use, so the idle ones are all at the back.
+func (l *rateLimiter) gc() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := l.clock.Now().Add(-l.idleTTL)
+	for e := l.lru.Back(); e != nil && e.Value.(*bucket).last.Before(cutoff); e = l.lru.Back() {
+		l.remove(e)
+	}
+}
+
+func (l *rateLimiter) remove(e *list.Element) {
+	l.lru.Remove(e)
+	delete(l.buckets, e.Value.(*bucket).key)
+}
+
+// run garbage-collects idle buckets until ctx is done.
+func (l *rateLimiter) run(ctx context.Context) {
+	t := l.clock.NewTicker(l.idleTTL)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			l.gc()
+		}
+	}
+}
+
+// tierLimiters holds one rateLimiter per key tier, and one per client IP for callers without a
+// key.
+type tierLimiters struct {
+	free, pro, ip *rateLimiter
+}
+
+// pick returns the limiter for the caller in ctx and its name for metrics: the key's tier
+// limiter when authenticated, the IP limiter otherwise.
+func (t tierLimiters) pick(ctx context.Context) (*rateLimiter, string) {
+	id, ok := identityFrom(ctx)
+	switch {
+	case !ok:
+		return t.ip, "ip"
+	case id.Tier == tierPro:
+		return t.pro, tierPro
+	default:
+		return t.free, tierFree
+	}
+}
+
+func (t tierLimiters) run(ctx context.Context) {
+	go t.free.run(ctx)
+	go t.ip.run(ctx)
+	t.pro.run(ctx)
+}
+
+// rateLimitKey identifies the caller: the API key id when authenticated, else the client IP.
+func rateLimitKey(r *http.Request) string { return callerKey(r.Context(), clientIP(r)) }
+
+func callerKey(ctx context.Context, ip string) string {
+	if id, ok := identityFrom(ctx); ok {
+		return "key:" + id.KeyID
+	}
+	return "ip:" + ip
+}
+
+// rateLimit sets X-RateLimit-* on every response and rejects callers with an empty bucket with 429.
+// Pro keys draw from their own, larger buckets; callers without a key share one per client IP.
+func rateLimit(limiters tierLimiters) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l, name := limiters.pick(r.Context())
+			dim := dimensionKey
+			if name == "ip" {
+				dim = dimensionIP
+			}
+			limited(w, r, next, l.take(rateLimitKey(r)), name, dim)
+		})
+	}
+}
+
+// ipRateLimit limits every request by client IP alone, for routes outside the keyed group.
+func ipRateLimit(l *rateLimiter, name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limited(w, r, next, l.take("ip:"+clientIP(r)), name, dimensionIP)
+		})
+	}
+}
+
+// callerRateLimit limits every request by caller, as rateLimit does, but from l alone whatever
+// the key's tier, for a route kept apart from the translate limits.
+func callerRateLimit(l *rateLimiter, name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			dim := dimensionKey
+			if _, ok := identityFrom(r.Context()); !ok {
+				dim = dimensionIP
+			}
+			limited(w, r, next, l.take(rateLimitKey(r)), name, dim)
+		})
+	}
+}
+
+// limited reports d in X-RateLimit-* and serves r, or answers 429 when d refused it.
+func limited(w http.ResponseWriter, r *http.Request, next http.Handler, d rateDecision, limiter, dimension string) {
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(d.Reset.Unix(), 10))
+	if !d.Allowed {
+		metricRateLimited.WithLabelValues(limiter).Inc()
+		writeThrottled(r.Context(), w, codeRateLimited, "rate limit exceeded", throttle{
+			Dimension:  dimension,
+			Limit:      int64(d.Limit),
+			Used:       int64(d.Limit - d.Remaining),
+			Reset:      d.Reset,
+			RetryAfter: d.RetryAfter,
+		})
+		return
+	}
+	next.ServeHTTP(w, r)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefillsOnTheClock(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	l := newRateLimiter(60, 2, time.Hour, clk) // a token a second, two at most
+	steps := []struct {
+		name    string
+		advance time.Duration
+		allowed bool
+		retry   time.Duration
+	}{
+		{"first of the burst", 0, true, 0},
+		{"second of the burst", 0, true, 0},
+		{"empty", 0, false, time.Second},
+		{"half refilled", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"refilled", 500 * time.Millisecond, true, 0},
+		{"idle past a full bucket", 10 * time.Minute, true, 0},
+		{"burst still the cap", 0, true, 0},
+		{"empty again", 0, false, time.Second},
+	}
+	for _, st := range steps {
+		clk.Advance(st.advance)
+		d := l.take("caller")
+		if d.Allowed != st.allowed || d.RetryAfter != st.retry {
+			t.Fatalf("%s: allowed %v, retry after %v; want %v, %v", st.name, d.Allowed, d.RetryAfter, st.allowed, st.retry)
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := newTestServer(t, map[string]string{"RATE_LIMIT_PER_MIN": "60", "RATE_LIMIT_BURST": "2"}, Deps{Clock: clk}).Handler()
+	steps := []struct {
+		name      string
+		key       string
+		advance   time.Duration
+		status    int
+		remaining string
+		reset     time.Duration // from the step's time
+		retry     string
+	}{
+		{"first", testFreeKey, 0, http.StatusOK, "1", time.Second, ""},
+		{"second", testFreeKey, 0, http.StatusOK, "0", 2 * time.Second, ""},
+		{"throttled", testFreeKey, 0, http.StatusTooManyRequests, "0", 2 * time.Second, "1"},
+		{"another tier's bucket", testProKey, 0, http.StatusOK, "", 0, ""},
+		{"refilled", testFreeKey, time.Second, http.StatusOK, "0", 2 * time.Second, ""},
+	}
+	for _, st := range steps {
+		clk.Advance(st.advance)
+		w := serve(h, "GET", "/go/translate?q=kihineh", "", "X-API-Key", st.key)
+		if w.Code != st.status {
+			t.Fatalf("%s: status %d, want %d: %s", st.name, w.Code, st.status, w.Body.String())
+		}
+		if st.remaining == "" {
+			continue
+		}
+		hd := w.Header()
+		reset := strconv.FormatInt(clk.Now().Add(st.reset).Unix(), 10)
+		if hd.Get("X-RateLimit-Limit") != "2" || hd.Get("X-RateLimit-Remaining") != st.remaining || hd.Get("X-RateLimit-Reset") != reset {
+			t.Fatalf("%s: limit %q, remaining %q, reset %q; want 2, %s, %s", st.name,
+				hd.Get("X-RateLimit-Limit"), hd.Get("X-RateLimit-Remaining"), hd.Get("X-RateLimit-Reset"), st.remaining, reset)
+		}
+		if got := hd.Get("Retry-After"); got != st.retry {
+			t.Fatalf("%s: Retry-After %q, want %q", st.name, got, st.retry)
+		}
+		if st.status == http.StatusTooManyRequests && !strings.Contains(w.Body.String(), `"code":"`+string(codeRateLimited)+`"`) {
+			t.Fatalf("%s: body %s", st.name, w.Body.String())
+		}
+	}
+}
+
+func TestRateLimiterForgetsIdleBuckets(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	l := newRateLimiter(60, 5, 10*time.Minute, clk)
+	l.take("old")
+	clk.Advance(6 * time.Minute)
+	l.take("recent")
+	clk.Advance(5 * time.Minute)
+	l.gc()
+	if _, ok := l.buckets["old"]; ok {
+		t.Fatal("bucket idle past RATE_LIMIT_IDLE_TTL kept")
+	}
+	if _, ok := l.buckets["recent"]; !ok {
+		t.Fatal("bucket used within RATE_LIMIT_IDLE_TTL dropped")
+	}
+	// A forgotten bucket starts full again.
+	if d := l.take("old"); !d.Allowed || d.Remaining != 4 {
+		t.Fatalf("allowed %v, remaining %d; want a full bucket", d.Allowed, d.Remaining)
+	}
+}
+
+func TestRateLimiterCap(t *testing.T) {
+	l := newRateLimiter(60, 1, time.Hour, systemClock{}).capped(2)
+	for _, k := range []string{"a", "b", "a", "c"} {
+		l.take(k)
+	}
+	if len(l.buckets) != 2 || l.buckets["b"] != nil {
+		t.Fatalf("buckets %v, want a and c, the least recently used dropped", l.buckets)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		name string
+		id   *identity
+		want string
+	}{
+		{"key", &identity{KeyID: "acme", Tier: tierPro}, "key:acme"},
+		{"no key", nil, "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/go/translate", nil)
+			if tt.id != nil {
+				r = r.WithContext(withIdentity(r.Context(), *tt.id))
+			}
+			if got := rateLimitKey(r); got != tt.want {
+				t.Fatalf("rateLimitKey %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Shared limits. With CACHE_BACKEND=redis the per-key and per-IP token buckets and the daily
+// character quotas live in that Redis too, so every instance draws from the same allowance and
+// a deploy no longer hands a throttled client a fresh one. Each decision is one Lua script, run
+// atomically by Redis, and reads Redis's own clock: instances whose clocks disagree still see
+// one time, and a bucket's last refill never moves backwards.
+//
+// When Redis fails, the callers limit locally, as without it, for sharedLimitsRetry before
+// trying again, and count each such decision in dhk_go_shared_limit_fallbacks_total. A request
+// is never refused because Redis is down.
+
+const (
+	redisBucketPrefix = "dhk:rl:"    // + limiter name + ":" + caller key
+	redisQuotaPrefix  = "dhk:quota:" // + key id
+	sharedLimitsRetry = 5 * time.Second
+)
+
+// bucketScript takes one token from the bucket in KEYS[1]. ARGV: rate in tokens per second,
+// burst, and how long an idle bucket is kept in ms (by then it would be full anyway). Returns
+// {allowed, tokens left} with tokens as a string, since Redis truncates Lua numbers.
+var bucketScript = redis.NewScript(`
+local rate, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens, last = tonumber(b[1]), tonumber(b[2])
+if tokens == nil or last == nil then
+  tokens, last = burst, now
+end
+if now > last then
+  tokens = math.min(burst, tokens + (now - last) * rate)
+  last = now
+else
+  tokens = math.min(burst, tokens)
+end
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(last))
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return {allowed, tostring(tokens)}
+`)
+
+// quotaScript charges ARGV[1] characters to the quota in KEYS[1] unless that would pass the
+// limit in ARGV[2]; 0 characters only reads it. The counter belongs to a UTC day by Redis's
+// clock and starts over with the next. Returns {charged, used, day}.
+var quotaScript = redis.NewScript(`
+local n, limit = tonumber(ARGV[1]), tonumber(ARGV[2])
+local t = redis.call('TIME')
+local day = tostring(math.floor(tonumber(t[1]) / 86400))
+if redis.call('HGET', KEYS[1], 'day') ~= day then
+  redis.call('HSET', KEYS[1], 'day', day, 'used', 0)
+  redis.call('EXPIRE', KEYS[1], 172800)
+end
+local used = redis.call('HINCRBY', KEYS[1], 'used', n)
+if used > limit then
+  return {0, redis.call('HINCRBY', KEYS[1], 'used', -n), day}
+end
+return {1, used, day}
+`)
+
+// refundScript gives ARGV[1] characters back to the quota in KEYS[1] if it is still on the day
+// in ARGV[2]; a refund from before midnight would eat into the new day's allowance.
+var refundScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'day') == ARGV[2] then
+  return redis.call('HINCRBY', KEYS[1], 'used', -tonumber(ARGV[1]))
+end
+return 0
+`)
+
+// sharedLimits runs the limit scripts against Redis. A nil *sharedLimits is valid and never
+// available, so callers limit locally.
+type sharedLimits struct {
+	rdb       *redis.Client
+	opTimeout time.Duration
+	clock     Clock
+
+	downUntil atomic.Int64 // unix nanos; Redis is skipped until then after a failure
+	warnedAt  atomic.Int64 // unix nanos of the last fallback warning
+}
+
+func newSharedLimits(rdb *redis.Client, timeout time.Duration, clk Clock) *sharedLimits {
+	return &sharedLimits{rdb: rdb, opTimeout: timeout, clock: clk}
+}
+
+// available reports whether the next decision about limit should try Redis, counting it as a
+// fallback when Redis is being skipped after a failure.
+func (s *sharedLimits) available(limit string) bool {
+	if s == nil {
+		return false
+	}
+	if s.clock.Now().UnixNano() < s.downUntil.Load() {
+		metricSharedLimitFallbacks.WithLabelValues(limit).Inc()
+		return false
+	}
+	return true
+}
+
+// failed counts a decision made locally because Redis returned err, and stops trying Redis for
+// sharedLimitsRetry. It warns at most once a minute.
+func (s *sharedLimits) failed(limit string, err error) {
+	now := s.clock.Now()
+	s.downUntil.Store(now.Add(sharedLimitsRetry).UnixNano())
+	metricSharedLimitFallbacks.WithLabelValues(limit).Inc()
+	if last := s.warnedAt.Load(); now.UnixNano()-last >= int64(time.Minute) && s.warnedAt.CompareAndSwap(last, now.UnixNano()) {
+		slog.Warn("shared limits unavailable; limiting locally", "limit", limit, "retry_in", sharedLimitsRetry, "err", err)
+	}
+}
+
+// take runs bucketScript for the bucket name:key.
+func (s *sharedLimits) take(name, key string, rate, burst float64, idle time.Duration) (allowed bool, tokens float64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opTimeout)
+	defer cancel()
+	res, err := bucketScript.Run(ctx, s.rdb, []string{redisBucketPrefix + name + ":" + key},
+		strconv.FormatFloat(rate, 'g', -1, 64), strconv.FormatFloat(burst, 'g', -1, 64), max(idle.Milliseconds(), 1000)).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("bucket script: unexpected reply %v", res)
+	}
+	n, _ := res[0].(int64)
+	str, _ := res[1].(string)
+	if tokens, err = strconv.ParseFloat(str, 64); err != nil {
+		return false, 0, fmt.Errorf("bucket script: tokens %q: %w", str, err)
+	}
+	return n == 1, tokens, nil
+}
+
+// reserve runs quotaScript for keyID; n of 0 reads the quota without charging it.
+func (s *sharedLimits) reserve(keyID string, n, limit int64) (charged bool, used int64, day string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opTimeout)
+	defer cancel()
+	res, err := quotaScript.Run(ctx, s.rdb, []string{redisQuotaPrefix + keyID}, n, limit).Slice()
+	if err != nil {
+		return false, 0, "", err
+	}
+	if len(res) != 3 {
+		return false, 0, "", fmt.Errorf("quota script: unexpected reply %v", res)
+	}
+	ok, _ := res[0].(int64)
+	used, _ = res[1].(int64)
+	day, _ = res[2].(string)
+	return ok == 1, used, day, nil
+}
+
+// refund runs refundScript. A refund that doesn't reach Redis is only counted: the characters
+// stay charged, which errs on the side of the limit.
+func (s *sharedLimits) refund(keyID, day string, n int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opTimeout)
+	defer cancel()
+	if err := refundScript.Run(ctx, s.rdb, []string{redisQuotaPrefix + keyID}, n, day).Err(); err != nil {
+		s.failed("quota", err)
+	}
+}
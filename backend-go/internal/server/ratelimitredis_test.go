@@ -0,0 +1,339 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// testSharedLimits returns a sharedLimits on mr through a client of its own, as each instance
+// has.
+func testSharedLimits(t *testing.T, mr *miniredis.Miniredis, clk Clock) *sharedLimits {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return newSharedLimits(rdb, time.Second, clk)
+}
+
+// TestSharedBucket steps Redis's clock, backwards too, and checks the bucket refills by it.
+func TestSharedBucket(t *testing.T) {
+	mr := miniredis.RunT(t)
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := testSharedLimits(t, mr, NewFakeClock(base))
+	steps := []struct {
+		name    string
+		at      time.Duration // Redis's time, after base
+		takes   int
+		allowed int
+		tokens  float64 // left after the last take
+	}{
+		{"burst", 0, 5, 3, 0},
+		{"one refilled", time.Second, 2, 1, 0},
+		{"clock went back", 500 * time.Millisecond, 1, 0, 0},
+		{"refill from the latest time", 3 * time.Second, 3, 2, 0},
+		{"capped at burst", time.Minute, 1, 1, 2},
+		{"half a token", time.Minute + 500*time.Millisecond, 3, 2, 0.5},
+	}
+	for _, st := range steps {
+		mr.SetTime(base.Add(st.at))
+		allowed := 0
+		var tokens float64
+		for range st.takes {
+			ok, left, err := s.take("free", "k", 1, 3, time.Minute)
+			if err != nil {
+				t.Fatalf("%s: %v", st.name, err)
+			}
+			if ok {
+				allowed++
+			}
+			tokens = left
+		}
+		if allowed != st.allowed || tokens != st.tokens {
+			t.Fatalf("%s: %d allowed with %v left, want %d with %v", st.name, allowed, tokens, st.allowed, st.tokens)
+		}
+	}
+	if ttl := mr.TTL(redisBucketPrefix + "free:k"); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("bucket TTL %v, want the idle time", ttl)
+	}
+}
+
+// TestSharedBucketParallel has instances with skewed clocks take from one bucket at once and
+// checks exactly its burst gets through, however the takes interleave.
+func TestSharedBucketParallel(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances int
+		callers   int
+		burst     int
+	}{
+		{"one instance", 1, 50, 10},
+		{"three instances", 3, 90, 10},
+		{"burst of one", 4, 40, 1},
+		{"more burst than callers", 2, 8, 20},
+	}
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			mr.SetTime(base)
+			limiters := make([]*rateLimiter, tt.instances)
+			for i := range limiters {
+				// An hour of skew each; the buckets go by Redis's clock.
+				clk := NewFakeClock(base.Add(time.Duration(i) * time.Hour))
+				limiters[i] = newRateLimiter(1, tt.burst, time.Minute, clk).sharedAs(testSharedLimits(t, mr, clk), tierFree)
+			}
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for i := range tt.callers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if limiters[i%tt.instances].take("k").Allowed {
+						allowed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if got, want := allowed.Load(), int64(min(tt.callers, tt.burst)); got != want {
+				t.Fatalf("%d allowed, want %d", got, want)
+			}
+		})
+	}
+}
+
+// TestSharedQuotaParallel charges one quota from many callers at once and checks it never
+// passes the limit, then that refunds and the day's rollover go by Redis's day.
+func TestSharedQuotaParallel(t *testing.T) {
+	tests := []struct {
+		name    string
+		callers int
+		n       int64
+		limit   int64
+		charged int
+	}{
+		{"stops short of the limit", 40, 10, 95, 9},
+		{"exactly the limit", 40, 10, 100, 10},
+		{"all fit", 10, 10, 1000, 10},
+		{"none fit", 10, 200, 100, 0},
+	}
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			mr.SetTime(base)
+			instances := []*sharedLimits{testSharedLimits(t, mr, systemClock{}), testSharedLimits(t, mr, systemClock{})}
+			var charged atomic.Int64
+			var wg sync.WaitGroup
+			for i := range tt.callers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					ok, used, _, err := instances[i%2].reserve("acme", tt.n, tt.limit)
+					if err != nil {
+						t.Error(err)
+					}
+					if used > tt.limit {
+						t.Errorf("used %d past the limit %d", used, tt.limit)
+					}
+					if ok {
+						charged.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if got := charged.Load(); got != int64(tt.charged) {
+				t.Fatalf("%d charged, want %d", got, tt.charged)
+			}
+			if _, used, _, _ := instances[0].reserve("acme", 0, tt.limit); used != int64(tt.charged)*tt.n {
+				t.Fatalf("used %d, want %d", used, int64(tt.charged)*tt.n)
+			}
+		})
+	}
+
+	t.Run("refunds", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		mr.SetTime(base)
+		s := testSharedLimits(t, mr, systemClock{})
+		_, _, today, err := s.reserve("acme", 60, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strconv.FormatInt(base.Unix()/86400, 10); today != want {
+			t.Fatalf("day %q, want %q", today, want)
+		}
+		steps := []struct {
+			name string
+			at   time.Time // Redis's time
+			day  string
+			n    int64
+			used int64
+		}{
+			{"today's refund", base, today, 20, 40},
+			{"another day's refund ignored", base, "1", 20, 40},
+			{"new day starts over", base.Add(24 * time.Hour), "", 0, 0},
+			{"yesterday's refund ignored", base.Add(24 * time.Hour), today, 20, 0},
+		}
+		for _, st := range steps {
+			mr.SetTime(st.at)
+			if st.n > 0 {
+				s.refund("acme", st.day, st.n)
+			}
+			if _, used, _, _ := s.reserve("acme", 0, 100); used != st.used {
+				t.Fatalf("%s: used %d, want %d", st.name, used, st.used)
+			}
+		}
+	})
+}
+
+// TestSharedLimitsFallback fails Redis under a limiter and checks it limits locally, counting
+// each decision, and goes back to Redis after sharedLimitsRetry.
+func TestSharedLimitsFallback(t *testing.T) {
+	mr := miniredis.RunT(t)
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	l := newRateLimiter(1, 2, time.Minute, clk).sharedAs(testSharedLimits(t, mr, clk), tierFree)
+	fallbacks := metricSharedLimitFallbacks.WithLabelValues("rate")
+	steps := []struct {
+		name      string
+		redisErr  string // "" serves
+		advance   time.Duration
+		allowed   bool
+		fallbacks float64 // counted by this step
+		inRedis   bool    // the bucket is kept in Redis afterwards
+	}{
+		{"shared", "", 0, true, 0, true},
+		{"redis fails: local bucket", "ERR down", 0, true, 1, false},
+		{"skipped while down", "ERR down", time.Second, true, 1, false},
+		{"local bucket spent", "", 0, false, 1, false},
+		{"retried after the wait", "", sharedLimitsRetry, true, 0, true},
+	}
+	for _, st := range steps {
+		mr.FlushAll()
+		mr.SetError(st.redisErr)
+		clk.Advance(st.advance)
+		before := testutil.ToFloat64(fallbacks)
+		if d := l.take("k"); d.Allowed != st.allowed {
+			t.Fatalf("%s: allowed %v, want %v", st.name, d.Allowed, st.allowed)
+		}
+		if got := testutil.ToFloat64(fallbacks) - before; got != st.fallbacks {
+			t.Fatalf("%s: %v fallbacks counted, want %v", st.name, got, st.fallbacks)
+		}
+		mr.SetError("")
+		if got := mr.Exists(redisBucketPrefix + tierFree + ":k"); got != st.inRedis {
+			t.Fatalf("%s: bucket in Redis %v, want %v", st.name, got, st.inRedis)
+		}
+	}
+}
+
+// TestSharedLimitsAcrossServers runs instances on one Redis and checks a key's rate limit and
+// quota are spent across them, and that a restarted instance doesn't hand out a fresh
+// allowance.
+func TestSharedLimitsAcrossServers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	env := map[string]string{
+		"CACHE_BACKEND":           "redis",
+		"REDIS_URL":               "redis://" + mr.Addr(),
+		"RATE_LIMIT_PER_MIN":      "1",
+		"RATE_LIMIT_BURST":        "2",
+		"RATE_LIMIT_PRO_BURST":    "100",
+		"QUOTA_CHARS_PRO":         "12",
+		"QUOTA_GRACE_PRO":         "0",
+		"KEY_CONCURRENCY":         "0",
+		"KEY_CONCURRENCY_PRO":     "0",
+		"NEGATIVE_CACHE_TTL":      "0",
+		"RATE_LIMIT_IP_PER_MIN":   "1000",
+		"RATE_LIMIT_IP_BURST":     "1000",
+		"RATE_LIMIT_HEALTH_BURST": "1000",
+	}
+	a := newTestServer(t, env, Deps{}).Handler()
+	b := newTestServer(t, env, Deps{}).Handler()
+	steps := []struct {
+		name    string
+		h       *http.Handler
+		key, q  string
+		status  int
+		restart bool // a replaces its instance first
+	}{
+		{"free on a", &a, testFreeKey, "one", http.StatusOK, false},
+		{"free on b", &b, testFreeKey, "two", http.StatusOK, false},
+		{"free burst spent on a", &a, testFreeKey, "three", http.StatusTooManyRequests, false},
+		{"still spent after a restart", &a, testFreeKey, "four", http.StatusTooManyRequests, true},
+		{"pro on a", &a, testProKey, "hello", http.StatusOK, false},
+		{"pro on b", &b, testProKey, "world", http.StatusOK, false},
+		{"pro quota spent on a", &a, testProKey, "again", http.StatusTooManyRequests, false},
+		{"pro quota spent after a restart", &a, testProKey, "there", http.StatusTooManyRequests, true},
+	}
+	for _, st := range steps {
+		if st.restart {
+			*st.h = newTestServer(t, env, Deps{}).Handler()
+		}
+		w := serve(*st.h, "GET", "/go/translate?q="+st.q, "", "X-API-Key", st.key)
+		if w.Code != st.status {
+			t.Fatalf("%s: status %d, want %d: %s", st.name, w.Code, st.status, w.Body.String())
+		}
+	}
+}
+
+// TestSharedLimitsAcrossServersParallel sends a burst of requests split between two servers on
+// one Redis at once and checks exactly the shared allowance is admitted: a free key's rate-limit
+// burst, and a pro key's daily characters.
+func TestSharedLimitsAcrossServersParallel(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		requests int
+		admitted int
+	}{
+		{"rate limit", testFreeKey, 40, 10}, // RATE_LIMIT_BURST
+		{"quota", testProKey, 20, 5},        // QUOTA_CHARS_PRO over 10-character phrases
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			env := map[string]string{
+				"CACHE_BACKEND":           "redis",
+				"REDIS_URL":               "redis://" + mr.Addr(),
+				"RATE_LIMIT_PER_MIN":      "1",
+				"RATE_LIMIT_BURST":        "10",
+				"RATE_LIMIT_PRO_BURST":    "1000",
+				"QUOTA_CHARS_PRO":         "50",
+				"QUOTA_GRACE_PRO":         "0",
+				"KEY_CONCURRENCY":         "0",
+				"KEY_CONCURRENCY_PRO":     "0",
+				"MAX_CONCURRENCY":         "100",
+				"RATE_LIMIT_IP_PER_MIN":   "1000",
+				"RATE_LIMIT_IP_BURST":     "1000",
+				"RATE_LIMIT_HEALTH_BURST": "1000",
+			}
+			servers := []http.Handler{newTestServer(t, env, Deps{}).Handler(), newTestServer(t, env, Deps{}).Handler()}
+			var admitted, limited atomic.Int64
+			var wg sync.WaitGroup
+			for i := range tt.requests {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					q := "phrase " + strconv.Itoa(100 + i)[:3] // 10 characters
+					switch w := serve(servers[i%2], "GET", "/go/translate?q="+url.QueryEscape(q), "", "X-API-Key", tt.key); w.Code {
+					case http.StatusOK:
+						admitted.Add(1)
+					case http.StatusTooManyRequests:
+						limited.Add(1)
+					default:
+						t.Errorf("status %d: %s", w.Code, w.Body.String())
+					}
+				}()
+			}
+			wg.Wait()
+			if got := admitted.Load(); got != int64(tt.admitted) || got+limited.Load() != int64(tt.requests) {
+				t.Fatalf("%d admitted and %d limited of %d, want %d admitted", got, limited.Load(), tt.requests, tt.admitted)
+			}
+		})
+	}
+}
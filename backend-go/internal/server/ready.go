@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dependency is something /go/ready must be able to reach before the instance takes traffic.
+type dependency struct {
+	Name  string
+	Probe func(ctx context.Context) error
+}
+
+// pinger is implemented by cache backends that live outside the process.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type probeResult struct {
+	OK        bool    `json:"ok"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readiness probes every dependency concurrently with a short timeout and caches the report for
+// ttl so a burst of readiness checks doesn't hammer the upstream.
+type readiness struct {
+	deps     []dependency
+	timeout  time.Duration
+	ttl      time.Duration
+	clock    Clock
+	draining atomic.Bool                    // set once shutdown begins; readiness then fails for good
+	selftest atomic.Pointer[SelfTestReport] // a failed SELFTEST_ON_START run; readiness fails for good
+
+	mu     sync.Mutex
+	last   map[string]probeResult
+	lastOK bool
+	lastAt time.Time
+}
+
+func newReadiness(timeout, ttl time.Duration, clk Clock, deps ...dependency) *readiness {
+	return &readiness{deps: deps, timeout: timeout, ttl: ttl, clock: clk}
+}
+
+// check returns the cached report, re-probing when it is older than ttl.
+func (rd *readiness) check(ctx context.Context) (map[string]probeResult, bool) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rd.last != nil && rd.clock.Now().Sub(rd.lastAt) < rd.ttl {
+		return rd.last, rd.lastOK
+	}
+
+	results := probeAll(ctx, rd.timeout, rd.deps)
+	report, ok := make(map[string]probeResult, len(rd.deps)), true
+	for i, d := range rd.deps {
+		report[d.Name] = results[i]
+		ok = ok && results[i].OK
+	}
+	rd.last, rd.lastOK, rd.lastAt = report, ok, rd.clock.Now()
+	return report, ok
+}
+
+// probeAll runs every probe concurrently under one timeout; results are in deps order.
+func probeAll(ctx context.Context, timeout time.Duration, deps []dependency) []probeResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	results := make([]probeResult, len(deps))
+	var wg sync.WaitGroup
+	for i, d := range deps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := d.Probe(ctx)
+			results[i] = probeResult{OK: err == nil, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// drain marks the instance as shutting down so the load balancer stops routing to it.
+func (rd *readiness) drain() { rd.draining.Store(true) }
+
+// failSelfTest keeps the instance out of rotation for good, reporting rep.
+func (rd *readiness) failSelfTest(rep *SelfTestReport) { rd.selftest.Store(rep) }
+
+func (rd *readiness) handler(w http.ResponseWriter, r *http.Request) {
+	if rd.draining.Load() {
+		j(w, http.StatusServiceUnavailable, map[string]any{
+			"status": "draining",
+			"ts":     rd.clock.Now().UTC().Format(time.RFC3339),
+		})
+		return
+	}
+	if rep := rd.selftest.Load(); rep != nil {
+		j(w, http.StatusServiceUnavailable, map[string]any{
+			"status":   "selftest_failed",
+			"selftest": rep,
+			"ts":       rd.clock.Now().UTC().Format(time.RFC3339),
+		})
+		return
+	}
+	report, ok := rd.check(r.Context())
+	code, status := http.StatusOK, "ready"
+	if !ok {
+		code, status = http.StatusServiceUnavailable, "not_ready"
+	}
+	j(w, code, map[string]any{
+		"status": status,
+		"checks": report,
+		"ts":     rd.clock.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// defaultTrustedProxies is TRUSTED_PROXIES when unset: Cloudflare's published edge ranges
+// (https://www.cloudflare.com/ips/), plus loopback and the private ranges a load balancer or
+// Fly's proxy connects from.
+var defaultTrustedProxies = []string{
+	"173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22", "141.101.64.0/18",
+	"108.162.192.0/18", "190.93.240.0/20", "188.114.96.0/20", "197.234.240.0/22", "198.41.128.0/17",
+	"162.158.0.0/15", "104.16.0.0/13", "104.24.0.0/14", "172.64.0.0/13", "131.0.72.0/22",
+	"2400:cb00::/32", "2606:4700::/32", "2803:f800::/32", "2405:b500::/32", "2405:8100::/32",
+	"2a06:98c0::/29", "2c0f:f248::/32",
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "127.0.0.0/8", "::1/128", "fc00::/7",
+}
+
+// realIP sets r.RemoteAddr to the client address, for everything after it (logging, rate limits,
+// the IP filter). Forwarding headers are only believed from a trusted proxy: X-Forwarded-For is
+// walked right to left, past trusted hops, to the first address that isn't one, which is the
+// nearest party that could have lied. CF-Connecting-IP is the fallback when a trusted peer sent
+// no X-Forwarded-For. From anyone else the headers are ignored, so a direct caller can't pick
+// its own address. A Unix-socket peer is a local process and counts as trusted.
+func realIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(a netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(a) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, ok := parseHop(r.RemoteAddr)
+			if ok && !isTrusted(peer) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			client, found := netip.Addr{}, false
+			hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				a, ok := parseHop(hops[i])
+				if !ok {
+					break // garbage from an untrusted hop; the last good address stands
+				}
+				client, found = a, true
+				if !isTrusted(a) {
+					break
+				}
+			}
+			if !found {
+				client, found = parseHop(r.Header.Get("CF-Connecting-IP"))
+			}
+			if found {
+				r.RemoteAddr = client.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// parseHop reads an address as it appears in RemoteAddr or a forwarding header: a bare IP, or
+// one with a port.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return a.WithZone("").Unmap(), true
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := parsePrefixes("10.0.0.0/8,173.245.48.0/20,2400:cb00::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		peer   string
+		xff    []string
+		cf     string
+		client string
+	}{
+		{"direct caller", "203.0.113.5:4321", nil, "", "203.0.113.5:4321"},
+		{"spoofed forwarded-for", "203.0.113.5:4321", []string{"198.51.100.7"}, "", "203.0.113.5:4321"},
+		{"spoofed cf-connecting-ip", "203.0.113.5:4321", nil, "198.51.100.7", "203.0.113.5:4321"},
+		{"spoofed trusted hop", "203.0.113.5:4321", []string{"10.0.0.9"}, "", "203.0.113.5:4321"},
+		{"through the edge", "173.245.48.10:443", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"client-supplied hops ignored", "10.0.0.2:80", []string{"1.2.3.4, 198.51.100.7, 173.245.48.10"}, "", "198.51.100.7"},
+		{"headers joined", "10.0.0.2:80", []string{"1.2.3.4", "198.51.100.7", "10.0.0.8"}, "", "198.51.100.7"},
+		{"every hop trusted", "10.0.0.2:80", []string{"10.0.0.3, 10.0.0.4"}, "", "10.0.0.3"},
+		{"garbage past a trusted hop", "10.0.0.2:80", []string{"junk, 10.0.0.4"}, "", "10.0.0.4"},
+		{"garbage last falls back to cf", "10.0.0.2:80", []string{"198.51.100.7, junk"}, "192.0.2.44", "192.0.2.44"},
+		{"cf without forwarded-for", "173.245.48.10:443", nil, "198.51.100.7", "198.51.100.7"},
+		{"forwarded-for wins over cf", "173.245.48.10:443", []string{"198.51.100.9"}, "198.51.100.7", "198.51.100.9"},
+		{"trusted peer, no headers", "10.0.0.2:80", nil, "", "10.0.0.2:80"},
+		{"ipv6 hop with port", "[2400:cb00::1]:443", []string{"[2001:db8::7]:1234"}, "", "2001:db8::7"},
+		{"ipv4-mapped hop", "10.0.0.2:80", []string{"::ffff:198.51.100.7"}, "", "198.51.100.7"},
+		{"unix socket peer", "@", []string{"198.51.100.7"}, "", "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := realIP(trusted)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) { got = r.RemoteAddr }))
+			r := httptest.NewRequest("GET", "/go/translate", nil)
+			r.RemoteAddr = tt.peer
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.cf != "" {
+				r.Header.Set("CF-Connecting-IP", tt.cf)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.client {
+				t.Fatalf("client %q, want %q", got, tt.client)
+			}
+		})
+	}
+}
+
+// TestRealIPDrivesFilters checks the IP filter sees the resolved address: a denied client is
+// refused through a trusted proxy, and naming an allowed one from outside gets nobody in.
+func TestRealIPDrivesFilters(t *testing.T) {
+	h := newTestServer(t, map[string]string{
+		"TRUSTED_PROXIES": "10.0.0.0/8",
+		"IP_ALLOWLIST":    "10.0.0.0/8,198.51.100.0/24,203.0.113.0/24",
+		"IP_DENYLIST":     "198.51.100.66/32",
+	}, Deps{}).Handler()
+	tests := []struct {
+		name   string
+		peer   string
+		xff    string
+		status int
+	}{
+		{"allowed client via proxy", "10.0.0.2:80", "198.51.100.7", http.StatusOK},
+		{"denied client via proxy", "10.0.0.2:80", "198.51.100.66", http.StatusForbidden},
+		{"denied client spoofing another", "198.51.100.66:999", "198.51.100.7", http.StatusForbidden},
+		{"outsider naming an allowed client", "192.0.2.10:999", "198.51.100.7", http.StatusForbidden},
+		{"allowed caller's spoof ignored", "203.0.113.5:999", "198.51.100.66", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/go/translate?q=hello", nil)
+			r.RemoteAddr = tt.peer
+			r.Header.Set("X-API-Key", testProKey)
+			r.Header.Set("X-Forwarded-For", tt.xff)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisCache shares cached translations across instances. Every operation runs under a short
+// timeout so an unreachable Redis costs at most opTimeout before the caller falls through.
+type redisCache struct {
+	rdb       *redis.Client
+	ttl       time.Duration
+	opTimeout time.Duration
+	clock     Clock
+
+	hits, misses atomic.Uint64
+}
+
+const redisKeyPrefix = "dhk:tr:"
+
+// redisValue is the stored JSON form of a cached result.
+type redisValue struct {
+	Translation  string        `json:"translation"`
+	Src          string        `json:"src"`
+	Query        string        `json:"q,omitempty"`
+	Confidence   *float64      `json:"confidence,omitempty"`
+	Alternatives []alternative `json:"alternatives,omitempty"`
+	StoredAt     time.Time     `json:"stored_at"`
+}
+
+func newRedisCache(rawURL string, ttl, timeout time.Duration, clk Clock) (*redisCache, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	opts.DialTimeout = timeout
+	opts.ReadTimeout = timeout
+	opts.WriteTimeout = timeout
+	opts.MaxRetries = 0 // fail fast; the caller falls through to upstream
+	return &redisCache{rdb: redis.NewClient(opts), ttl: ttl, opTimeout: timeout, clock: clk}, nil
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) (cacheValue, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opTimeout)
+	defer cancel()
+	b, err := c.rdb.Get(ctx, redisKeyPrefix+key).Bytes()
+	if err == redis.Nil {
+		c.misses.Add(1)
+		return cacheValue{}, false, nil
+	}
+	if err != nil {
+		c.misses.Add(1)
+		return cacheValue{}, false, err
+	}
+	var v redisValue
+	if err := json.Unmarshal(b, &v); err != nil {
+		c.misses.Add(1)
+		return cacheValue{}, false, err
+	}
+	c.hits.Add(1)
+	return cacheValue{Result: translateResult{Translation: v.Translation, Src: v.Src, Query: v.Query, Confidence: v.Confidence, Alternatives: v.Alternatives}, StoredAt: v.StoredAt}, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, res translateResult) error {
+	b, err := json.Marshal(redisValue{
+		Translation:  res.Translation,
+		Src:          res.Src,
+		Query:        res.Query,
+		Confidence:   res.Confidence,
+		Alternatives: res.Alternatives,
+		StoredAt:     c.clock.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opTimeout)
+	defer cancel()
+	return c.rdb.Set(ctx, redisKeyPrefix+key, b, c.ttl).Err()
+}
+
+func (c *redisCache) Delete(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opTimeout)
+	defer cancel()
+	n, err := c.rdb.Del(ctx, redisKeyPrefix+key).Result()
+	return n > 0, err
+}
+
+// Flush deletes every key under redisKeyPrefix (shared by all instances) using SCAN, so other
+// data in the same Redis DB is left alone. It runs under ctx rather than opTimeout since a large
+// cache takes many round trips.
+func (c *redisCache) Flush(ctx context.Context) (int, error) {
+	var (
+		cursor  uint64
+		removed int
+	)
+	for {
+		keys, next, err := c.rdb.Scan(ctx, cursor, redisKeyPrefix+"*", 500).Result()
+		if err != nil {
+			return removed, err
+		}
+		if len(keys) > 0 {
+			n, err := c.rdb.Del(ctx, keys...).Result()
+			removed += int(n)
+			if err != nil {
+				return removed, err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return removed, nil
+		}
+	}
+}
+
+// Stats reports this instance's hit/miss counters; Entries is the size of the Redis DB.
+func (c *redisCache) Stats(ctx context.Context) (cacheStats, error) {
+	st := cacheStats{Backend: "redis", Hits: c.hits.Load(), Misses: c.misses.Load()}
+	ctx, cancel := context.WithTimeout(ctx, c.opTimeout)
+	defer cancel()
+	n, err := c.rdb.DBSize(ctx).Result()
+	st.Entries = int(n)
+	return st, err
+}
+
+func (c *redisCache) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opTimeout)
+	defer cancel()
+	return c.rdb.Ping(ctx).Err()
+}
+
+func (c *redisCache) Close() error { return c.rdb.Close() }
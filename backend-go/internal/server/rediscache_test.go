@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestRedisCache stores, reads and deletes an entry on miniredis, and checks it expires with its
+// TTL and that Stats counts this instance's lookups.
+func TestRedisCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	c, err := newRedisCache("redis://"+mr.Addr(), time.Hour, 100*time.Millisecond, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	res := translateResult{Translation: "hello", Src: "upstream", Confidence: ptr(0.9), Alternatives: []alternative{{Translation: "hi", Confidence: 0.5}}}
+
+	if _, ok, err := c.Get(ctx, "dv|en|kihineh"); ok || err != nil {
+		t.Fatalf("empty cache: ok %v, err %v", ok, err)
+	}
+	if err := c.Set(ctx, "dv|en|kihineh", res); err != nil {
+		t.Fatal(err)
+	}
+	v, ok, err := c.Get(ctx, "dv|en|kihineh")
+	if !ok || err != nil || v.Result.Translation != "hello" || *v.Result.Confidence != 0.9 || len(v.Result.Alternatives) != 1 || !v.StoredAt.Equal(clk.Now()) {
+		t.Fatalf("got %+v, ok %v, err %v", v, ok, err)
+	}
+	if st, err := c.Stats(ctx); err != nil || st.Backend != "redis" || st.Entries != 1 || st.Hits != 1 || st.Misses != 1 {
+		t.Fatalf("stats %+v, err %v", st, err)
+	}
+	if removed, err := c.Delete(ctx, "dv|en|kihineh"); !removed || err != nil {
+		t.Fatalf("delete: removed %v, err %v", removed, err)
+	}
+	if removed, _ := c.Delete(ctx, "dv|en|kihineh"); removed {
+		t.Fatal("deleted an entry twice")
+	}
+
+	c.Set(ctx, "dv|en|kihineh", res)
+	mr.FastForward(time.Hour + time.Second)
+	if _, ok, _ := c.Get(ctx, "dv|en|kihineh"); ok {
+		t.Fatal("entry outlived its TTL")
+	}
+}
+
+// TestRedisCacheAcrossServers checks a translation one server cached is a hit on another on the
+// same Redis, and that with Redis gone requests still reach the upstream, quickly.
+func TestRedisCacheAcrossServers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	env := map[string]string{"CACHE_BACKEND": "redis", "REDIS_URL": "redis://" + mr.Addr(), "REDIS_TIMEOUT": "50ms"}
+	upA, upB := &echoUpstream{}, &echoUpstream{}
+	a := newTestServer(t, env, Deps{Upstream: upA}).Handler()
+	b := newTestServer(t, env, Deps{Upstream: upB}).Handler()
+
+	if w := serve(a, "GET", "/go/translate?q=shared+phrase", "", "X-API-Key", testProKey); w.Code != http.StatusOK {
+		t.Fatalf("a: status %d: %s", w.Code, w.Body.String())
+	}
+	w := serve(b, "GET", "/go/translate?q=shared+phrase", "", "X-API-Key", testProKey)
+	var res struct {
+		Translation string `json:"translation"`
+		Cached      bool   `json:"cached"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK || res.Translation != "EN(shared phrase)" || !res.Cached {
+		t.Fatalf("b: status %d: %s; want a's translation, cached", w.Code, w.Body.String())
+	}
+	if upA.calls.Load() != 1 || upB.calls.Load() != 0 {
+		t.Fatalf("upstream calls: a %d, b %d; want b answered from a's entry", upA.calls.Load(), upB.calls.Load())
+	}
+
+	mr.Close()
+	start := time.Now()
+	for range 2 {
+		if w := serve(b, "GET", "/go/translate?q=after+redis", "", "X-API-Key", testProKey); w.Code != http.StatusOK {
+			t.Fatalf("with Redis down: status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Fatalf("two requests took %v with Redis down", took)
+	}
+	if got := upB.calls.Load(); got != 2 {
+		t.Fatalf("%d upstream calls with Redis down, want both requests to fall through", got)
+	}
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Configuration reload. SIGHUP or POST /go/admin/reload reads the environment and CONFIG_FILE
+// again, validates the result in full and swaps it in; with any problem the running configuration
+// is left exactly as it was. The parts of the service that derive state from the configuration
+// (rate limiters, upstream clients, packs) register a reloadPart and rebuild from the new values.
+// A changed setting no part can apply in place, such as PORT or TLS, is reported as needing a
+// restart and keeps its old value in the live configuration, so that stays a true picture of what
+// is running.
+
+// liveConfig is the active Config and where its values came from. Reloads are serialized.
+type liveConfig struct {
+	cur   atomic.Pointer[Config]
+	src   atomic.Pointer[configSources]
+	load  func() (Config, configSources, error)
+	mu    sync.Mutex
+	parts []reloadPart
+}
+
+// configSources says where each variable that was set came from: "flag", "file" (CONFIG_FILE),
+// "env" or "config" (the --config file; see cmdline.go). Anything absent took its default.
+type configSources map[string]string
+
+// reloadPart is one component that takes new settings without a restart.
+type reloadPart struct {
+	name   string
+	fields []string // the Config fields it applies; none runs it on every reload
+	// can reports whether this particular change can be applied in place; nil means always.
+	can   func(old, next *Config) bool
+	apply func(old, next *Config) error
+}
+
+func newLiveConfig(cfg Config, src configSources, load func() (Config, configSources, error)) *liveConfig {
+	l := &liveConfig{load: load}
+	l.cur.Store(&cfg)
+	l.src.Store(&src)
+	return l
+}
+
+func (l *liveConfig) current() *Config { return l.cur.Load() }
+
+// register adds p; New does this before serving.
+func (l *liveConfig) register(p reloadPart) { l.parts = append(l.parts, p) }
+
+// configChange is one changed setting in a reload report. Secrets say only that they changed.
+type configChange struct {
+	Field   string `json:"field"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+	Secret  bool   `json:"secret,omitempty"`
+	Applied bool   `json:"applied"`
+}
+
+// configReload reports what a reload did.
+type configReload struct {
+	Changed         []configChange    `json:"changed"`
+	RestartRequired []string          `json:"restart_required,omitempty"`
+	Errors          map[string]string `json:"errors,omitempty"` // by part, e.g. a pack that didn't parse
+	Fingerprint     string            `json:"config_fingerprint"`
+}
+
+// reload loads, validates and swaps in a new configuration. An invalid one returns the load
+// error, a *configError listing every problem, and changes nothing.
+func (l *liveConfig) reload(reason string) (configReload, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	next, src, err := l.load()
+	if err != nil {
+		slog.Error("config reload rejected", "reason", reason, "err", err)
+		return configReload{}, err
+	}
+	old := l.current()
+	changes := diffConfig(*old, next)
+
+	hot := map[string]bool{}
+	for _, p := range l.parts {
+		if p.can == nil || p.can(old, &next) {
+			for _, f := range p.fields {
+				hot[f] = true
+			}
+		}
+	}
+	res := configReload{Changed: []configChange{}}
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(&next).Elem()
+	oldSrc := *l.src.Load()
+	for _, c := range changes {
+		c.Applied = hot[c.Field]
+		if !c.Applied {
+			res.RestartRequired = append(res.RestartRequired, c.Field)
+			nv.FieldByName(c.Field).Set(ov.FieldByName(c.Field))
+			if sf, ok := reflect.TypeOf(next).FieldByName(c.Field); ok {
+				env, _, _ := strings.Cut(sf.Tag.Get("config"), ",")
+				if s, ok := oldSrc[env]; ok {
+					src[env] = s
+				} else {
+					delete(src, env)
+				}
+			}
+		}
+		res.Changed = append(res.Changed, c)
+	}
+	l.cur.Store(&next)
+	l.src.Store(&src)
+
+	changed := map[string]bool{}
+	for _, c := range res.Changed {
+		changed[c.Field] = c.Applied
+	}
+	for _, p := range l.parts {
+		run := len(p.fields) == 0
+		for _, f := range p.fields {
+			run = run || changed[f]
+		}
+		if !run {
+			continue
+		}
+		if err := p.apply(old, &next); err != nil {
+			if res.Errors == nil {
+				res.Errors = map[string]string{}
+			}
+			res.Errors[p.name] = err.Error()
+		}
+	}
+	res.Fingerprint = next.fingerprint()
+
+	logged := make([]slog.Attr, 0, len(res.Changed))
+	for _, c := range res.Changed {
+		if c.Secret {
+			logged = append(logged, slog.String(c.Field, "(secret changed)"))
+		} else {
+			logged = append(logged, slog.Group(c.Field, "old", c.Old, "new", c.New))
+		}
+	}
+	slog.Info("config reloaded", "reason", reason, "changed", slog.GroupValue(logged...),
+		"fingerprint", res.Fingerprint)
+	if len(res.RestartRequired) > 0 {
+		slog.Warn("config changes need a restart to take effect", "fields", res.RestartRequired)
+	}
+	return res, nil
+}
+
+// diffConfig lists the fields that differ between a and b, in declaration order. Values are
+// shown as redacted shows them; a field that changed but reads the same there is a secret.
+func diffConfig(a, b Config) []configChange {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	ar, br := reflect.ValueOf(a.redacted()), reflect.ValueOf(b.redacted())
+	var out []configChange
+	for i := 0; i < av.NumField(); i++ {
+		if reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			continue
+		}
+		c := configChange{Field: av.Type().Field(i).Name}
+		if reflect.DeepEqual(ar.Field(i).Interface(), br.Field(i).Interface()) {
+			c.Secret = true
+		} else {
+			c.Old, c.New = showValue(ar.Field(i)), showValue(br.Field(i))
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// showValue formats one Config value for the reload diff.
+func showValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return ""
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String()
+	}
+	if v.Kind() == reflect.Slice {
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = showValue(v.Index(i))
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// reloadHandler serves POST /go/admin/reload. An invalid configuration is a 422 listing every
+// problem, with the running one untouched.
+func reloadHandler(live *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := live.reload("admin:" + adminFrom(r.Context()))
+		if err != nil {
+			problems := []string{err.Error()}
+			var ce *configError
+			if errors.As(err, &ce) {
+				problems = ce.problems
+			}
+			writeError(w, http.StatusUnprocessableEntity, codeRejected, "config reload rejected", "errors", problems)
+			return
+		}
+		fields := make([]string, len(res.Changed))
+		for i, c := range res.Changed {
+			fields[i] = c.Field
+		}
+		auditParam(r.Context(), "changed", fields)
+		j(w, http.StatusOK, res)
+	}
+}
+
+// readEnvFile parses an env file: KEY=VALUE per line, optionally after "export ", with blank
+// lines and # comments skipped and one pair of matching quotes stripped from the value.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	vars := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: want KEY=VALUE", path, n)
+		}
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		}
+		vars[key] = val
+	}
+	return vars, sc.Err()
+}
+
+// configEntry is one Config field in /go/admin/config.
+type configEntry struct {
+	Field  string `json:"field"`
+	Env    string `json:"env"`
+	Value  string `json:"value"`
+	Secret bool   `json:"secret,omitempty"`
+	Set    *bool  `json:"set,omitempty"` // secrets only, whose value is never shown
+	Source string `json:"source"`        // flag | file | env | config | default | derived (built from several variables)
+}
+
+// configHandler serves GET /go/admin/config: every setting in effect, in declaration order, as
+// the reload diff shows it, with where it came from. Secrets read "***" when set.
+func configHandler(live *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		cfg, src := live.current(), *live.src.Load()
+		cv, rv := reflect.ValueOf(*cfg), reflect.ValueOf(cfg.redacted())
+		out := make([]configEntry, len(configFields))
+		for i, f := range configFields {
+			e := configEntry{Field: f.name, Env: f.env, Source: "default"}
+			if s, ok := src[f.env]; ok {
+				e.Source = s
+			} else if f.env == "-" {
+				e.Source = "derived"
+			}
+			if f.secret {
+				set := !cv.Field(f.index).IsZero()
+				e.Secret, e.Set = true, &set
+				if set {
+					e.Value = "***"
+				}
+			} else {
+				e.Value = showValue(rv.Field(f.index))
+			}
+			out[i] = e
+		}
+		j(w, http.StatusOK, map[string]any{"config": out, "config_fingerprint": cfg.fingerprint(), "features": cfg.features()})
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxRequestIDLen bounds a client-supplied X-Request-ID; longer ones are replaced.
+const maxRequestIDLen = 128
+
+// requestID replaces chi's middleware.RequestID. A well-formed incoming X-Request-ID (from the
+// edge worker, say) is kept so one id follows the request end to end; otherwise a fresh one is
+// generated. Either way it is stored under chi's context key, so middleware.GetReqID keeps
+// working for logs, spans and the upstream call, and is echoed in the response header.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(middleware.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(middleware.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middleware.RequestIDKey, id)))
+	})
+}
+
+// validRequestID allows ids that are safe to log and forward as a header: 1-128 characters of
+// letters, digits and - _ . : /.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
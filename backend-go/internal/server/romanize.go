@@ -0,0 +1,121 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Romanized Dhivehi has no settled spelling: "Assalaamu alaikum", "assalamu alaikum" and
+// "Assalaamu_alaikum" are one greeting. Cache and pack lookups key Latin-script text on a
+// canonical form so the variants share an entry; the text sent upstream is left as typed.
+// Thaana has one spelling and is only whitespace-collapsed.
+
+//go:embed romanize.json
+var defaultRomanRules []byte
+
+// romanRules is the rule table, romanize.json unless ROMAN_RULES_FILE replaces it.
+type romanRules struct {
+	Separators string      `json:"separators"` // become spaces; other punctuation is dropped
+	Replace    [][2]string `json:"replace"`    // applied in order, after lowercasing
+	Collapse   string      `json:"collapse"`   // runs of one of these letters become one
+}
+
+// builtinRomanRules is romanize.json, parsed once; it is never modified.
+var builtinRomanRules = mustParseRomanRules(defaultRomanRules)
+
+// keyFolding is how one Server folds text into cache and pack keys: by its rule table, nil for
+// the built-in one, while its fuzzy_keys flag is on.
+type keyFolding struct {
+	rules *romanRules
+	flags *featureFlags
+}
+
+// fuzzy reports whether keys fold romanized spellings, as the fuzzy_keys flag says.
+func (kf keyFolding) fuzzy() bool { return kf.flags.on(flagFuzzyKeys) }
+
+func parseRomanRules(b []byte) (*romanRules, error) {
+	var rr romanRules
+	if err := json.Unmarshal(b, &rr); err != nil {
+		return nil, err
+	}
+	for i, p := range rr.Replace {
+		if p[0] == "" || p[0] != strings.ToLower(p[0]) || p[1] != strings.ToLower(p[1]) {
+			return nil, fmt.Errorf("replace rule %d: both sides must be lowercase, and the first non-empty", i)
+		}
+	}
+	return &rr, nil
+}
+
+func mustParseRomanRules(b []byte) *romanRules {
+	rr, err := parseRomanRules(b)
+	if err != nil {
+		panic("romanize.json: " + err.Error())
+	}
+	return rr
+}
+
+// loadRomanRules reads a replacement rule table from path.
+func loadRomanRules(path string) (*romanRules, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ROMAN_RULES_FILE: %w", err)
+	}
+	rr, err := parseRomanRules(b)
+	if err != nil {
+		return nil, fmt.Errorf("ROMAN_RULES_FILE: %w", err)
+	}
+	return rr, nil
+}
+
+// canonicalKey is q as cache and pack lookups see it: for Latin-script text, lowercased,
+// without diacritics or punctuation and rewritten by the rules; otherwise only with its
+// whitespace collapsed.
+func (kf keyFolding) canonicalKey(q string) string {
+	if detectScript(q) != scriptLatin {
+		return strings.Join(strings.Fields(q), " ")
+	}
+	if kf.rules == nil {
+		return builtinRomanRules.canonical(q)
+	}
+	return kf.rules.canonical(q)
+}
+
+func (rr *romanRules) canonical(q string) string {
+	var b strings.Builder
+	b.Grow(len(q))
+	for _, r := range norm.NFD.String(strings.ToLower(q)) {
+		switch {
+		case unicode.Is(unicode.Mn, r): // the accents NFD split off
+		case r == '⟦' || r == '⟧': // glossary placeholders must stay distinct from plain digits
+			b.WriteRune(r)
+		case strings.ContainsRune(rr.Separators, r):
+			b.WriteByte(' ')
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	s := strings.Join(strings.Fields(b.String()), " ")
+	for _, p := range rr.Replace {
+		s = strings.ReplaceAll(s, p[0], p[1])
+	}
+	if rr.Collapse == "" {
+		return s
+	}
+	b.Reset()
+	var prev rune
+	for _, r := range s {
+		if r == prev && strings.ContainsRune(rr.Collapse, r) {
+			continue
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
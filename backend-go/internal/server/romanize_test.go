@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCanonicalKeyVariants(t *testing.T) {
+	var kf keyFolding
+	groups := [][]string{
+		{"Assalaamu alaikum", "assalamu alaikum", "Assalaamu_alaikum", "ASSALAAMU  ALAIKUM!", "assalāmu-alaikum"},
+		{"Shukuriyyaa", "shukuriyya", "Shukuriyyā."},
+		{"Raashee", "rashi", "raashi"},
+		{"Kihineh", "kiheeneh", "kihinéh"},
+		{"Thiyey", "thiyei", "THIYEI"},
+	}
+	for _, g := range groups {
+		want := kf.canonicalKey(g[0])
+		for _, v := range g[1:] {
+			if got := kf.canonicalKey(v); got != want {
+				t.Errorf("canonicalKey(%q) = %q, want %q as for %q", v, got, want, g[0])
+			}
+		}
+	}
+	if a, b := kf.canonicalKey("rashi"), kf.canonicalKey("rasha"); a == b {
+		t.Errorf("rashi and rasha both fold to %q", a)
+	}
+	for _, tt := range []struct{ in, want string }{
+		{"ރަށް", "ރަށް"},
+		{"  ރަށް   ދޯ ", "ރަށް ދޯ"},
+		{"ރަށް!", "ރަށް!"}, // punctuation is only dropped from Latin text
+	} {
+		if got := kf.canonicalKey(tt.in); got != tt.want {
+			t.Errorf("canonicalKey(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestFuzzyKeys checks a romanized variant spelling is answered from the cache or a pack entry
+// written another way, marked "match":"normalized", while the upstream hears the text as typed;
+// that Thaana isn't folded; and that turning fuzzy_keys off keys on the text as typed.
+func TestFuzzyKeys(t *testing.T) {
+	dir := t.TempDir()
+	pack := `{"src":"dv","dst":"en","source_text":"Kihineh","target_text":"how"}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "greetings.jsonl"), []byte(pack), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	up := &echoUpstream{}
+	h := newTestServer(t, map[string]string{"PACK_DIR": dir}, Deps{Upstream: up}).Handler()
+	translate := func(h http.Handler, q string) map[string]any {
+		t.Helper()
+		w := serve(h, "GET", "/go/translate?src=dv&dst=en&q="+url.QueryEscape(q), "", "X-API-Key", testFreeKey)
+		if w.Code != http.StatusOK {
+			t.Fatalf("translate %q: status %d: %s", q, w.Code, w.Body.String())
+		}
+		var out map[string]any
+		json.Unmarshal(w.Body.Bytes(), &out)
+		return out
+	}
+
+	for _, tt := range []struct {
+		q, translation, src string
+		cached, normalized  bool
+		calls               int64
+	}{
+		{"Assalaamu alaikum", "EN(Assalaamu alaikum)", "upstream", false, false, 1},
+		{"assalamu_alaikum", "EN(Assalaamu alaikum)", "upstream", true, true, 1},
+		{"Assalaamu alaikum", "EN(Assalaamu alaikum)", "upstream", true, false, 1},
+		{"kiheeneh", "how", "pack", false, true, 1},
+		{"Kihineh", "how", "pack", false, false, 1},
+		{"ރަށް", "EN(ރަށް)", "upstream", false, false, 2},
+		{"ރަށް!", "EN(ރަށް!)", "upstream", false, false, 3},
+	} {
+		out := translate(h, tt.q)
+		match, _ := out["match"].(string)
+		if out["translation"] != tt.translation || out["src"] != tt.src || (out["cached"] == true) != tt.cached || (match == matchNormalized) != tt.normalized {
+			t.Errorf("%q: %v; want %q from %s, cached %v, normalized %v", tt.q, out, tt.translation, tt.src, tt.cached, tt.normalized)
+		}
+		if got := up.calls.Load(); got != tt.calls {
+			t.Errorf("after %q: %d upstream calls, want %d", tt.q, got, tt.calls)
+		}
+	}
+
+	up = &echoUpstream{}
+	h = newTestServer(t, map[string]string{"FEATURE_FLAGS": "fuzzy_keys=false"}, Deps{Upstream: up}).Handler()
+	translate(h, "Assalaamu alaikum")
+	if out := translate(h, "assalamu_alaikum"); out["translation"] != "EN(assalamu_alaikum)" || out["match"] != nil || up.calls.Load() != 2 {
+		t.Fatalf("without fuzzy_keys: %v after %d upstream calls, want the variant sent upstream", out, up.calls.Load())
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Key scopes. A key in the key file can be narrowed to some route groups (translate, batch,
+// transliterate), some directions, and a validity window, for a partner who should, say, only
+// call dv→en single translations for one semester. A key without scopes, or with a part left
+// empty, is unrestricted there. requireAPIKey checks the routes and the window once the key is
+// known; the direction is only known once src is resolved, so the translate service checks it.
+// A refusal is a 403 SCOPE_DENIED whose missing_scope names what the key lacks:
+// "route:batch", "pair:en-dv" or "window". Admins set scopes at mint or with
+// PATCH /go/admin/keys/{id}, taking effect from the next request.
+
+// Route groups a key can be scoped to.
+const (
+	scopeTranslate     = "translate"     // /go/translate, its stream and /go/ws
+	scopeBatch         = "batch"         // /go/translate/batch and /bulk, and /go/jobs
+	scopeTransliterate = "transliterate" // /go/transliterate
+)
+
+var scopeRoutes = []string{scopeTranslate, scopeBatch, scopeTransliterate}
+
+// keyScopes is what a key may do. Empty Routes or Pairs allow all; a nil bound leaves the
+// window open on that side.
+type keyScopes struct {
+	Routes     []string   `json:"routes,omitempty"`
+	Pairs      []langPair `json:"pairs,omitempty"`
+	ValidFrom  *time.Time `json:"valid_from,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+// normalized checks s and returns it with names lowercased and times in UTC, or nil when it
+// restricts nothing.
+func (s *keyScopes) normalized() (*keyScopes, error) {
+	if s == nil {
+		return nil, nil
+	}
+	out := &keyScopes{}
+	for _, r := range s.Routes {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if !slices.Contains(scopeRoutes, r) {
+			return nil, fmt.Errorf("unknown route scope %q (want %s)", r, strings.Join(scopeRoutes, ", "))
+		}
+		if !slices.Contains(out.Routes, r) {
+			out.Routes = append(out.Routes, r)
+		}
+	}
+	for _, p := range s.Pairs {
+		p = langPair{strings.ToLower(strings.TrimSpace(p.Src)), strings.ToLower(strings.TrimSpace(p.Dst))}
+		if !pairSupported(p) {
+			return nil, fmt.Errorf("unsupported pair scope %s→%s", p.Src, p.Dst)
+		}
+		if !slices.Contains(out.Pairs, p) {
+			out.Pairs = append(out.Pairs, p)
+		}
+	}
+	if s.ValidFrom != nil {
+		t := s.ValidFrom.UTC().Truncate(time.Second)
+		out.ValidFrom = &t
+	}
+	if s.ValidUntil != nil {
+		t := s.ValidUntil.UTC().Truncate(time.Second)
+		out.ValidUntil = &t
+	}
+	if out.ValidFrom != nil && out.ValidUntil != nil && !out.ValidUntil.After(*out.ValidFrom) {
+		return nil, fmt.Errorf("'valid_until' must be after 'valid_from'")
+	}
+	if len(out.Routes) == 0 && len(out.Pairs) == 0 && out.ValidFrom == nil && out.ValidUntil == nil {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// scopeError is a request outside the caller's scopes.
+type scopeError struct {
+	Scope  string // the missing scope: route:<group>, pair:<src>-<dst> or window
+	msg    string
+	scopes *keyScopes
+}
+
+func (e *scopeError) Error() string { return e.msg }
+
+func (e *scopeError) write(w http.ResponseWriter) {
+	details := []any{"missing_scope", e.Scope}
+	if e.Scope == "window" {
+		details = append(details, "valid_from", e.scopes.ValidFrom, "valid_until", e.scopes.ValidUntil)
+	}
+	writeError(w, http.StatusForbidden, codeScopeDenied, e.msg, details...)
+}
+
+// permits reports whether a request to route group at now is within s; route "" is a route no
+// scope covers, such as /go/usage, which every key may call.
+func (s *keyScopes) permits(route string, now time.Time) *scopeError {
+	switch {
+	case s == nil:
+		return nil
+	case s.ValidFrom != nil && now.Before(*s.ValidFrom):
+		return &scopeError{Scope: "window", msg: "api key not valid until " + s.ValidFrom.Format(time.RFC3339), scopes: s}
+	case s.ValidUntil != nil && !now.Before(*s.ValidUntil):
+		return &scopeError{Scope: "window", msg: "api key validity ended at " + s.ValidUntil.Format(time.RFC3339), scopes: s}
+	case route != "" && len(s.Routes) > 0 && !slices.Contains(s.Routes, route):
+		return &scopeError{Scope: "route:" + route, msg: "api key is not scoped for " + route + " requests", scopes: s}
+	}
+	return nil
+}
+
+// permitsPair reports whether s allows translating in direction p.
+func (s *keyScopes) permitsPair(p langPair) *scopeError {
+	if s == nil || len(s.Pairs) == 0 || slices.Contains(s.Pairs, p) {
+		return nil
+	}
+	return &scopeError{Scope: "pair:" + p.Src + "-" + p.Dst, msg: "api key is not scoped for " + p.Src + "→" + p.Dst, scopes: s}
+}
+
+// checkPairScope is the direction check for the caller on ctx; nil without scopes.
+func checkPairScope(ctx context.Context, p langPair) error {
+	id, _ := identityFrom(ctx)
+	if err := id.Scopes.permitsPair(p); err != nil {
+		return err
+	}
+	return nil
+}
+
+// routeScope is the route group path belongs to, "" for routes no scope covers.
+func routeScope(path string) string {
+	switch path = apiPath(path); {
+	case path == "/go/translate/batch", path == "/go/translate/bulk", path == "/go/jobs", strings.HasPrefix(path, "/go/jobs/"):
+		return scopeBatch
+	case path == "/go/translate", path == "/go/translate/stream", path == "/go/ws":
+		return scopeTranslate
+	case path == "/go/transliterate":
+		return scopeTransliterate
+	}
+	return ""
+}
+
+// scopesOf returns the scopes of key id, nil when it has none or isn't known.
+func (ks *keyStore) scopesOf(id string) *keyScopes {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, k := range ks.keys {
+		if k.id == id {
+			return k.scopes
+		}
+	}
+	return nil
+}
+
+// setScopes replaces the scopes of the file-backed key id, nil clearing them, and rewrites the
+// key file.
+func (ks *keyStore) setScopes(id string, scopes *keyScopes) (keyFileEntry, error) {
+	if ks.file == "" {
+		return keyFileEntry{}, errNoKeyFile
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	row := slices.IndexFunc(ks.rows, func(e keyFileEntry) bool { return e.ID == id })
+	if row < 0 {
+		return keyFileEntry{}, errKeyNotFound
+	}
+	rows := slices.Clone(ks.rows)
+	rows[row].Scopes = scopes
+	if err := writeKeyFile(ks.file, rows); err != nil {
+		return keyFileEntry{}, err
+	}
+	ks.rows = rows
+	for i := range ks.keys {
+		if ks.keys[i].id == id {
+			ks.keys[i].scopes = scopes
+		}
+	}
+	return rows[row], nil
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestKeyScopesNormalized(t *testing.T) {
+	from := time.Date(2026, 2, 1, 9, 30, 15, 500, time.FixedZone("MVT", 5*3600))
+	until := from.Add(120 * 24 * time.Hour)
+	tests := []struct {
+		name   string
+		scopes *keyScopes
+		want   *keyScopes // nil with ok is unrestricted
+		ok     bool
+	}{
+		{"nil", nil, nil, true},
+		{"empty", &keyScopes{}, nil, true},
+		{"routes folded and deduplicated", &keyScopes{Routes: []string{" Translate", "translate", "BATCH"}}, &keyScopes{Routes: []string{scopeTranslate, scopeBatch}}, true},
+		{"unknown route", &keyScopes{Routes: []string{"admin"}}, nil, false},
+		{"pairs folded", &keyScopes{Pairs: []langPair{{"DV", "en"}, {"dv", "EN"}}}, &keyScopes{Pairs: []langPair{{langDhivehi, langEnglish}}}, true},
+		{"unsupported pair", &keyScopes{Pairs: []langPair{{langEnglish, langLatin}}}, nil, false},
+		{"window in utc seconds", &keyScopes{ValidFrom: &from, ValidUntil: &until},
+			&keyScopes{ValidFrom: ptr(from.UTC().Truncate(time.Second)), ValidUntil: ptr(until.UTC().Truncate(time.Second))}, true},
+		{"window backwards", &keyScopes{ValidFrom: &until, ValidUntil: &from}, nil, false},
+		{"empty window", &keyScopes{ValidFrom: &from, ValidUntil: &from}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.scopes.normalized()
+			if (err == nil) != tt.ok {
+				t.Fatalf("err %v, want ok %v", err, tt.ok)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Fatalf("normalized %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestKeyScopesPermits(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	partner := &keyScopes{
+		Routes:     []string{scopeTranslate},
+		Pairs:      []langPair{{langDhivehi, langEnglish}},
+		ValidFrom:  ptr(now.Add(-24 * time.Hour)),
+		ValidUntil: ptr(now.Add(24 * time.Hour)),
+	}
+	tests := []struct {
+		name    string
+		scopes  *keyScopes
+		route   string
+		at      time.Time
+		pair    langPair
+		missing string // "" permits
+	}{
+		{"unrestricted", nil, scopeBatch, now, langPair{langEnglish, langDhivehi}, ""},
+		{"scoped route", partner, scopeTranslate, now, langPair{langDhivehi, langEnglish}, ""},
+		{"unscoped route", partner, scopeBatch, now, langPair{langDhivehi, langEnglish}, "route:batch"},
+		{"route no scope covers", partner, "", now, langPair{langDhivehi, langEnglish}, ""},
+		{"unscoped pair", partner, scopeTranslate, now, langPair{langEnglish, langDhivehi}, "pair:en-dv"},
+		{"before the window", partner, scopeTranslate, now.Add(-25 * time.Hour), langPair{langDhivehi, langEnglish}, "window"},
+		{"at the window's end", partner, scopeTranslate, now.Add(24 * time.Hour), langPair{langDhivehi, langEnglish}, "window"},
+		{"window checked first", partner, scopeBatch, now.Add(48 * time.Hour), langPair{langDhivehi, langEnglish}, "window"},
+		{"pairs only", &keyScopes{Pairs: []langPair{{langLatin, langEnglish}}}, scopeBatch, now, langPair{langLatin, langEnglish}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var missing string
+			if err := tt.scopes.permits(tt.route, tt.at); err != nil {
+				missing = err.Scope
+			} else if err := tt.scopes.permitsPair(tt.pair); err != nil {
+				missing = err.Scope
+			}
+			if missing != tt.missing {
+				t.Fatalf("missing scope %q, want %q", missing, tt.missing)
+			}
+		})
+	}
+}
+
+func TestRouteScope(t *testing.T) {
+	tests := []struct {
+		path  string
+		scope string
+	}{
+		{"/go/translate", scopeTranslate},
+		{"/go/translate/stream", scopeTranslate},
+		{"/go/ws", scopeTranslate},
+		{"/go/translate/batch", scopeBatch},
+		{"/go/translate/bulk", scopeBatch},
+		{"/go/jobs", scopeBatch},
+		{"/go/jobs/j_1/result", scopeBatch},
+		{"/go/transliterate", scopeTransliterate},
+		{"/go/v2/translate", scopeTranslate},
+		{"/go/usage", ""},
+		{"/go/packs/search", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := routeScope(tt.path); got != tt.scope {
+				t.Fatalf("scope %q, want %q", got, tt.scope)
+			}
+		})
+	}
+}
+
+// TestScopedKey walks a partner's key, minted for dv→en single translations over a semester,
+// through what it may and may not call, an admin widening its scopes without a restart, and
+// the end of its window.
+func TestScopedKey(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := newTestServer(t, map[string]string{"RATE_LIMIT_BURST": "100"}, Deps{Clock: clk}).Handler()
+	admin := []string{"Authorization", "Bearer " + testAdmin, "Content-Type", "application/json"}
+	w := serve(h, "POST", "/go/admin/keys", `{"client_id":"university","scopes":{"routes":["translate"],"pairs":[{"src":"dv","dst":"en"}],
+		"valid_from":"2026-02-01T00:00:00Z","valid_until":"2026-06-30T00:00:00Z"}}`, admin...)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("mint: status %d: %s", w.Code, w.Body.String())
+	}
+	var minted struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &minted); err != nil {
+		t.Fatal(err)
+	}
+	translate := func(q, src, dst string) string {
+		return "/go/translate?" + url.Values{"q": {q}, "src": {src}, "dst": {dst}}.Encode()
+	}
+	steps := []struct {
+		name    string
+		advance time.Duration
+		patch   string // a scopes PATCH first; "" is none
+		method  string
+		target  string
+		body    string
+		status  int
+		missing string // missing_scope of a 403
+	}{
+		{"dv to en", 0, "", "GET", translate("ރަށް", "dv", "en"), "", http.StatusOK, ""},
+		{"en to dv", 0, "", "GET", translate("island", "en", "dv"), "", http.StatusForbidden, "pair:en-dv"},
+		{"batch", 0, "", "POST", "/go/translate/batch", `{"items":[{"id":"1","q":"ރަށް","src":"dv","dst":"en"}]}`, http.StatusForbidden, "route:batch"},
+		{"transliterate", 0, "", "GET", "/go/transliterate?q=rash", "", http.StatusForbidden, "route:transliterate"},
+		{"own usage", 0, "", "GET", "/go/usage", "", http.StatusOK, ""},
+		{"batch once allowed", 0, `{"scopes":{"routes":["translate","batch"],"pairs":[{"src":"dv","dst":"en"}],"valid_until":"2026-06-30T00:00:00Z"}}`,
+			"POST", "/go/translate/batch", `{"items":[{"id":"1","q":"ރަށް","src":"dv","dst":"en"}]}`, http.StatusOK, ""},
+		{"semester over", 122 * 24 * time.Hour, "", "GET", translate("ރަށް", "dv", "en"), "", http.StatusForbidden, "window"},
+		{"usage after the window", 0, "", "GET", "/go/usage", "", http.StatusForbidden, "window"},
+		{"scopes removed", 0, `{"scopes":null}`, "GET", translate("island", "en", "dv"), "", http.StatusOK, ""},
+	}
+	for _, st := range steps {
+		clk.Advance(st.advance)
+		if st.patch != "" {
+			if w := serve(h, "PATCH", "/go/admin/keys/"+minted.ID, st.patch, admin...); w.Code != http.StatusOK {
+				t.Fatalf("%s: patch status %d: %s", st.name, w.Code, w.Body.String())
+			}
+		}
+		w := serve(h, st.method, st.target, st.body, "X-API-Key", minted.Key, "Content-Type", "application/json")
+		if w.Code != st.status {
+			t.Fatalf("%s: status %d, want %d: %s", st.name, w.Code, st.status, w.Body.String())
+		}
+		if st.status == http.StatusForbidden {
+			checkEnvelope(t, w)
+			var env struct {
+				Error struct {
+					Code         errorCode `json:"code"`
+					MissingScope string    `json:"missing_scope"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &env)
+			if env.Error.Code != codeScopeDenied || env.Error.MissingScope != st.missing {
+				t.Fatalf("%s: %s %q, want %s %q", st.name, env.Error.Code, env.Error.MissingScope, codeScopeDenied, st.missing)
+			}
+		}
+	}
+
+	// The caller sees their own scopes.
+	w = serve(h, "GET", "/go/usage", "", "X-API-Key", testFreeKey)
+	if got := string(usageScopes(t, w)); got != "null" {
+		t.Fatalf("unscoped key's scopes %s, want null", got)
+	}
+	if w := serve(h, "PATCH", "/go/admin/keys/freebie", `{"scopes":{"routes":["transliterate"]}}`, admin...); w.Code != http.StatusOK {
+		t.Fatalf("patch status %d: %s", w.Code, w.Body.String())
+	}
+	if got := string(usageScopes(t, serve(h, "GET", "/go/usage", "", "X-API-Key", testFreeKey))); got != `{"routes":["transliterate"]}` {
+		t.Fatalf("scopes %s, want the routes just set", got)
+	}
+}
+
+// usageScopes is the scopes /go/usage reported in w.
+func usageScopes(t *testing.T, w *httptest.ResponseRecorder) json.RawMessage {
+	t.Helper()
+	var body struct {
+		Scopes json.RawMessage `json:"scopes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("status %d: %v: %s", w.Code, err, w.Body.String())
+	}
+	return body.Scopes
+}
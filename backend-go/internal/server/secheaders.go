@@ -0,0 +1,75 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+)
+
+// securityHeaders is the header set applied to every response. An empty value leaves that
+// header out.
+type securityHeaders struct {
+	ContentTypeOptions string `config:"SECURITY_CONTENT_TYPE_OPTIONS"`
+	FrameOptions       string `config:"SECURITY_FRAME_OPTIONS"`
+	ReferrerPolicy     string `config:"SECURITY_REFERRER_POLICY"`
+	HSTS               string `config:"SECURITY_HSTS"`
+	HSTSAlways         bool   `config:"SECURITY_HSTS_BEHIND_HTTPS"` // we're behind HTTPS termination (Fly, Cloudflare), so send HSTS on plain HTTP too
+	CSP                string `config:"SECURITY_CSP"`               // sent only on non-JSON responses (errors from chi, metrics text, HTML)
+}
+
+// secure sets the security headers before the handler runs, so anything the handler sets itself
+// wins. CSP waits until the status is written, when the Content-Type is known. Upgrades pass
+// straight through: a 101 has no body to protect, and the WebSocket needs the raw writer.
+func secure(h securityHeaders) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hdr := w.Header()
+			setIfEmpty(hdr, "X-Content-Type-Options", h.ContentTypeOptions)
+			setIfEmpty(hdr, "X-Frame-Options", h.FrameOptions)
+			setIfEmpty(hdr, "Referrer-Policy", h.ReferrerPolicy)
+			if r.TLS != nil || h.HSTSAlways {
+				setIfEmpty(hdr, "Strict-Transport-Security", h.HSTS)
+			}
+			if h.CSP == "" || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(&cspWriter{ResponseWriter: w, csp: h.CSP}, r)
+		})
+	}
+}
+
+func setIfEmpty(h http.Header, key, value string) {
+	if value != "" && h.Get(key) == "" {
+		h.Set(key, value)
+	}
+}
+
+// cspWriter adds Content-Security-Policy when the response turns out not to be JSON.
+type cspWriter struct {
+	http.ResponseWriter
+	csp   string
+	wrote bool
+}
+
+func (w *cspWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.wrote = true
+		h := w.Header()
+		if mt, _, _ := mime.ParseMediaType(h.Get("Content-Type")); mt != "application/json" {
+			setIfEmpty(h, "Content-Security-Policy", w.csp)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cspWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cspWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
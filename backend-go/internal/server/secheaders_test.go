@@ -0,0 +1,98 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSecurityHeaders checks the default set on JSON successes, error envelopes (from the
+// handlers, the auth middleware and the router's 404) and text or HTML responses, which alone
+// carry the CSP. The docs page keeps the CSP it sets itself.
+func TestSecurityHeaders(t *testing.T) {
+	h := newTestServer(t, map[string]string{"ENABLE_API_DOCS": "1"}, Deps{}).Handler()
+	const defaultCSP = "default-src 'none'; frame-ancestors 'none'"
+	docs := httptest.NewRecorder()
+	docsHandler()(docs, httptest.NewRequest("GET", "/go/docs", nil))
+	docsCSP := docs.Header().Get("Content-Security-Policy")
+	tests := []struct {
+		name    string
+		target  string
+		headers []string
+		status  int
+		csp     string
+	}{
+		{"health", "/go/health", nil, http.StatusOK, ""},
+		{"translation", "/go/translate?q=salaam", []string{"X-API-Key", testProKey}, http.StatusOK, ""},
+		{"missing query", "/go/translate", []string{"X-API-Key", testProKey}, http.StatusBadRequest, ""},
+		{"missing key", "/go/translate?q=salaam", nil, http.StatusUnauthorized, ""},
+		{"unknown route", "/go/nowhere", nil, http.StatusNotFound, ""},
+		{"metrics text", "/go/metrics", nil, http.StatusOK, defaultCSP},
+		{"docs page", "/go/docs", nil, http.StatusOK, docsCSP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "GET", tt.target, "", tt.headers...)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			want := map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Referrer-Policy":           "no-referrer",
+				"Strict-Transport-Security": "", // plain HTTP, and not configured as behind HTTPS
+				"Content-Security-Policy":   tt.csp,
+			}
+			for k, v := range want {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("%s %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+// TestSecurityHeadersConfig covers the env overrides, when HSTS is sent, and that a header the
+// handler set itself is kept.
+func TestSecurityHeadersConfig(t *testing.T) {
+	t.Run("overrides", func(t *testing.T) {
+		h := newTestServer(t, map[string]string{
+			"SECURITY_FRAME_OPTIONS":     "SAMEORIGIN",
+			"SECURITY_REFERRER_POLICY":   "off",
+			"SECURITY_HSTS":              "max-age=60",
+			"SECURITY_HSTS_BEHIND_HTTPS": "1",
+		}, Deps{}).Handler()
+		w := serve(h, "GET", "/go/health", "")
+		if got := w.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+			t.Errorf("X-Frame-Options %q, want the override", got)
+		}
+		if _, ok := w.Header()["Referrer-Policy"]; ok {
+			t.Errorf("Referrer-Policy %q sent after being turned off", w.Header().Get("Referrer-Policy"))
+		}
+		if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=60" {
+			t.Errorf("Strict-Transport-Security %q behind HTTPS, want the override", got)
+		}
+	})
+
+	hs := securityHeaders{FrameOptions: "DENY", HSTS: "max-age=31536000", CSP: "default-src 'none'"}
+	handler := secure(hs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<p>hi</p>"))
+	}))
+	r := httptest.NewRequest("GET", "/page", nil)
+	r.TLS = &tls.ConnectionState{}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("Strict-Transport-Security %q over TLS", got)
+	}
+	if got := w.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("X-Frame-Options %q, want the handler's SAMEORIGIN kept", got)
+	}
+	if got := w.Header().Get("Content-Security-Policy"); got != "default-src 'self'" {
+		t.Errorf("Content-Security-Policy %q, want the handler's kept", got)
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Sentence segmentation. The upstream does best on a sentence or two, so a paragraph is cut
+// into sentences that go through the translator chain on their own and are put back together
+// with the input's own spacing and line breaks. Each sentence is cached separately, so a
+// paragraph with one new sentence costs one upstream call.
+
+// segmentOpts controls segmentation in translateService.
+type segmentOpts struct {
+	Enabled bool
+	Workers int // sentences of one input translated at once
+}
+
+// segment is one sentence of an input, normalized, and the whitespace that followed it.
+type segment struct {
+	Text string
+	Sep  string
+}
+
+// segmentResult is one sentence's part of a translation, returned with ?segments=1.
+type segmentResult struct {
+	Source      string `json:"source"`
+	Translation string `json:"translation"`
+	Src         string `json:"src"`
+	Cached      bool   `json:"cached,omitempty"`
+}
+
+const (
+	// sentenceMarks end a sentence when whitespace or the end of input follows: Latin . ! ?,
+	// the Arabic-script question mark and comma Thaana writes with, and the danda.
+	sentenceMarks = ".!?؟،।"
+	// sentenceClosers may follow a mark and stay with its sentence.
+	sentenceClosers = "\"')]»”’"
+)
+
+// abbreviations are words whose trailing period doesn't end a sentence. Single letters
+// (initials) and dotted forms such as e.g. and U.S. are caught without listing them.
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true, "jr": true, "sr": true,
+	"vs": true, "etc": true, "no": true, "approx": true, "dept": true, "fig": true, "vol": true, "pp": true,
+}
+
+// segmentText splits text after a sentence mark followed by whitespace and at line breaks.
+// A period doesn't end a sentence after an abbreviation or an initial, or when the next word
+// starts with a lowercase letter or a digit; one inside a number has no whitespace after it. Text
+// with no marks is one segment. The last segment's Sep is always empty.
+func segmentText(text string) []segment {
+	runes := []rune(text)
+	var out []segment
+	start := 0
+	for i := 0; i < len(runes); {
+		end := -1
+		switch r := runes[i]; {
+		case r == '\n':
+			end = i
+		case strings.ContainsRune(sentenceMarks, r):
+			j := i + 1
+			for j < len(runes) && strings.ContainsRune(sentenceClosers, runes[j]) {
+				j++
+			}
+			if (j == len(runes) || unicode.IsSpace(runes[j])) && (r != '.' || fullStop(runes, i, j)) {
+				end = j
+			}
+		}
+		if end < 0 {
+			i++
+			continue
+		}
+		k := end
+		for k < len(runes) && unicode.IsSpace(runes[k]) {
+			k++
+		}
+		out = appendSegment(out, string(runes[start:end]), string(runes[end:k]))
+		start, i = k, k
+	}
+	out = appendSegment(out, string(runes[start:]), "")
+	if len(out) > 0 {
+		out[len(out)-1].Sep = ""
+	}
+	return out
+}
+
+// fullStop reports whether the period at runes[i], with closers up to j, ends a sentence.
+func fullStop(runes []rune, i, j int) bool {
+	w := i
+	for w > 0 && !unicode.IsSpace(runes[w-1]) {
+		w--
+	}
+	word := strings.ToLower(string(runes[w:i]))
+	if abbreviations[word] || strings.ContainsRune(word, '.') || (len(runes[w:i]) == 1 && unicode.IsLetter(runes[w])) {
+		return false
+	}
+	for j < len(runes) && unicode.IsSpace(runes[j]) {
+		j++
+	}
+	return j == len(runes) || !(unicode.IsLower(runes[j]) || unicode.IsDigit(runes[j]))
+}
+
+// appendSegment adds raw, normalized, with sep after it. Whitespace trailing raw joins sep, and
+// a piece with no text only lengthens the previous separator.
+func appendSegment(out []segment, raw, sep string) []segment {
+	text := strings.TrimRightFunc(raw, unicode.IsSpace)
+	sep = raw[len(text):] + sep
+	text, _ = normalizeText(text)
+	if text == "" {
+		if len(out) > 0 {
+			out[len(out)-1].Sep += sep
+		}
+		return out
+	}
+	return append(out, segment{Text: text, Sep: sep})
+}
+
+// translateSegments translates each of segs through s.t, up to s.segment.Workers at once, and
+// joins them in order. The first failure cancels the rest and is returned. The combined result
+// is cached only if every sentence was, reports the lowest confidence, and has Src "mixed" when
+// the sentences came from different layers. Alternatives aren't offered for a paragraph.
+func (s *translateService) translateSegments(ctx context.Context, req translateReq, segs []segment) (translateResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	splitProvenance(ctx, len(segs))
+	stage := startStage(ctx, "segments")
+	var (
+		results  = make([]translateResult, len(segs))
+		sem      = make(chan struct{}, max(s.segment.Workers, 1))
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i, sg := range segs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			sub := req
+			sub.Q, sub.NBest = sg.Text, 0
+			res, err := s.t.Translate(segmentContext(ctx, i, sg.Text), sub)
+			if err != nil {
+				once.Do(func() { firstErr = err; cancel() })
+				return
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = &upstreamError{Msg: transportErrMsg(ctx.Err())}
+	}
+	if firstErr != nil {
+		stage.finish(stageFailed, "", 0, firstErr)
+		return translateResult{}, firstErr
+	}
+	res := joinSegments(segs, results, req.Segments)
+	stage.end(stageOK, res.Src)
+	return res, nil
+}
+
+// joinSegments combines the per-sentence results of translateSegments.
+func joinSegments(segs []segment, results []translateResult, detail bool) translateResult {
+	var b strings.Builder
+	out := translateResult{Src: results[0].Src, Pack: results[0].Pack, Cached: true, Confidence: results[0].Confidence}
+	seen, seenClient := make(map[string]bool), make(map[string]bool)
+	for i, res := range results {
+		b.WriteString(res.Translation)
+		b.WriteString(segs[i].Sep)
+		if res.Src != out.Src {
+			out.Src = "mixed"
+		}
+		if res.Pack != out.Pack {
+			out.Pack = ""
+		}
+		if !res.Cached {
+			out.Cached = false
+		} else if out.CachedAt.IsZero() || res.CachedAt.Before(out.CachedAt) {
+			out.CachedAt = res.CachedAt
+		}
+		out.Stale = out.Stale || res.Stale
+		if res.Confidence == nil || out.Confidence == nil {
+			out.Confidence = nil
+		} else if *res.Confidence < *out.Confidence {
+			out.Confidence = res.Confidence
+		}
+		if res.Match != "" {
+			out.Match = res.Match
+		}
+		for _, g := range res.Glossary {
+			if !seen[g] {
+				seen[g] = true
+				out.Glossary = append(out.Glossary, g)
+			}
+		}
+		for _, g := range res.GlossaryClient {
+			if !seenClient[g] {
+				seenClient[g] = true
+				out.GlossaryClient = append(out.GlossaryClient, g)
+			}
+		}
+		out.OwnGlossary = out.OwnGlossary || res.OwnGlossary
+		out.Attempts += res.Attempts
+		if res.Upstream != "" {
+			out.Upstream = res.Upstream
+		}
+		if detail {
+			out.Segments = append(out.Segments, segmentResult{Source: segs[i].Text, Translation: res.Translation, Src: res.Src, Cached: res.Cached})
+		}
+	}
+	if !out.Cached {
+		out.CachedAt, out.Stale = time.Time{}, false
+	}
+	out.Translation = b.String()
+	return out
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Self-test. A build whose upstream answers its health check but refuses every translation (a
+// wrong auth header, a renamed field) looks healthy until users complain, so the self-test
+// drives the pipeline itself once the server is built:
+//
+//   - the readiness probes (the upstream's health, an outside cache);
+//   - SELFTEST_PHRASE, normalized, straight to each upstream, past the packs and the cache, with
+//     the answer's shape checked and, with SELFTEST_EXPECT, its translation;
+//   - a phrase taken from the packs, through the full pipeline, which must come back as that
+//     pack's exact-match translation;
+//   - SELFTEST_PHRASE through the full pipeline.
+//
+// --selftest builds the server without listening, prints the report as JSON on stdout and exits
+// non-zero if any check failed; REPLAY_UPSTREAM_DIR=testdata/upstream has a fixture for the
+// default phrase. With SELFTEST_ON_START the same run follows Boot's build: the router takes
+// over as usual, so /go/health/live, the admin routes and the logs stay reachable, but a
+// failure keeps /go/ready at 503 for good, with the report in its body.
+
+const (
+	selfTestOK      = "ok"
+	selfTestFailed  = "failed"
+	selfTestSkipped = "skipped"
+)
+
+// SelfTestReport is the self-test's outcome, as --selftest prints it.
+type SelfTestReport struct {
+	OK          bool            `json:"ok"`
+	Version     string          `json:"version"`
+	Fingerprint string          `json:"config_fingerprint"`
+	Mode        string          `json:"mode"`
+	StartedAt   time.Time       `json:"started_at"`
+	DurationMS  float64         `json:"duration_ms"`
+	Checks      []SelfTestCheck `json:"checks"`
+}
+
+// SelfTestCheck is one step of the self-test.
+type SelfTestCheck struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"` // ok, failed or skipped
+	DurationMS float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// selfTest holds what the checks drive, from the built server.
+type selfTest struct {
+	cfg       Config
+	svc       *translateService
+	packs     *packSet // nil without packs
+	deps      []dependency
+	upstreams func() []canaryTarget // nil without an upstream
+}
+
+// canaryTarget is one upstream the canary goes to.
+type canaryTarget struct {
+	name string
+	tr   Translator
+}
+
+// newSelfTest collects the upstreams: each member of upstream, or tr (Deps.Upstream) alone.
+func newSelfTest(cfg Config, svc *translateService, packs *packSet, deps []dependency, upstream *failoverTranslator, tr Translator) *selfTest {
+	st := &selfTest{cfg: cfg, svc: svc, packs: packs, deps: deps}
+	switch {
+	case tr != nil:
+		st.upstreams = func() []canaryTarget { return []canaryTarget{{name: "upstream", tr: tr}} }
+	case upstream != nil:
+		st.upstreams = func() []canaryTarget {
+			var out []canaryTarget
+			for _, m := range upstream.members() {
+				out = append(out, canaryTarget{name: m.name, tr: m.client})
+			}
+			return out
+		}
+	}
+	return st
+}
+
+// SelfTest builds the server for cfg as New does, runs the self-test against it without
+// listening, and closes it again. A build that fails is the report's one check.
+func SelfTest(ctx context.Context, cfg Config, deps Deps) *SelfTestReport {
+	rep := newSelfTestReport(cfg)
+	s, err := New(cfg, deps)
+	if err != nil {
+		rep.add(SelfTestCheck{Name: "build", Status: selfTestFailed, Error: err.Error()}, rep.StartedAt)
+		rep.finish()
+		return rep
+	}
+	defer s.close()
+	return s.selftest.run(ctx)
+}
+
+func newSelfTestReport(cfg Config) *SelfTestReport {
+	return &SelfTestReport{Version: buildVersion(cfg).SHA, Fingerprint: cfg.fingerprint(), Mode: cfg.TranslateMode, StartedAt: time.Now().UTC()}
+}
+
+func (rep *SelfTestReport) add(c SelfTestCheck, start time.Time) {
+	c.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+	rep.Checks = append(rep.Checks, c)
+}
+
+// finish sets OK and the duration: no check failed.
+func (rep *SelfTestReport) finish() {
+	rep.OK = true
+	for _, c := range rep.Checks {
+		rep.OK = rep.OK && c.Status != selfTestFailed
+	}
+	rep.DurationMS = float64(time.Since(rep.StartedAt).Microseconds()) / 1000
+}
+
+// run does every check, within SELFTEST_TIMEOUT.
+func (st *selfTest) run(ctx context.Context) *SelfTestReport {
+	ctx, cancel := context.WithTimeout(ctx, st.cfg.SelftestTimeout)
+	defer cancel()
+	rep := newSelfTestReport(st.cfg)
+	pair := langPair{st.cfg.SelftestSrc, st.cfg.SelftestDst}
+	q, _ := normalizeText(st.cfg.SelftestPhrase)
+
+	start := time.Now()
+	for i, res := range probeAll(ctx, st.cfg.ReadyProbeTimeout, st.deps) {
+		c := SelfTestCheck{Name: "ready:" + st.deps[i].Name, Status: selfTestOK}
+		if !res.OK {
+			c.Status, c.Error = selfTestFailed, res.Error
+		}
+		rep.add(c, start)
+	}
+
+	if st.upstreams == nil {
+		rep.add(SelfTestCheck{Name: "canary", Status: selfTestSkipped, Detail: "no upstream in " + st.cfg.TranslateMode + " mode"}, time.Now())
+	} else {
+		for _, t := range st.upstreams() {
+			start := time.Now()
+			res, err := t.tr.Translate(ctx, translateReq{Q: q, Src: pair.Src, Dst: pair.Dst})
+			c := SelfTestCheck{Name: "canary:" + t.name}
+			if err == nil {
+				err = st.checkCanary(res)
+			}
+			c.Status, c.Detail, c.Error = checkOutcome(fmt.Sprintf("%q → %q", q, res.Translation), err)
+			rep.add(c, start)
+		}
+	}
+
+	start = time.Now()
+	if src, p, want, ok := packSample(st.packs); !ok {
+		rep.add(SelfTestCheck{Name: "pack", Status: selfTestSkipped, Detail: "no packs loaded"}, start)
+	} else {
+		res, err := st.svc.resolve(ctx, translateReq{Q: src, Src: p.Src, Dst: p.Dst})
+		if err == nil && (res.Src != "pack" || res.Translation != want) {
+			err = fmt.Errorf("got %q from %s, want %q from the packs", res.Translation, res.Src, want)
+		}
+		c := SelfTestCheck{Name: "pack"}
+		c.Status, c.Detail, c.Error = checkOutcome(fmt.Sprintf("%s→%s %q → %q", p.Src, p.Dst, src, res.Translation), err)
+		rep.add(c, start)
+	}
+
+	start = time.Now()
+	if st.cfg.TranslateMode == modePackOnly {
+		rep.add(SelfTestCheck{Name: "pipeline", Status: selfTestSkipped, Detail: "pack_only answers from the packs alone"}, start)
+	} else {
+		res, err := st.svc.resolve(ctx, translateReq{Q: st.cfg.SelftestPhrase, Src: pair.Src, Dst: pair.Dst})
+		switch {
+		case err != nil:
+		case res.Pair != pair:
+			err = fmt.Errorf("resolved to %s→%s, want %s→%s", res.Pair.Src, res.Pair.Dst, pair.Src, pair.Dst)
+		case res.Src == "":
+			err = errors.New("no layer reported answering")
+		default:
+			err = st.checkCanary(res)
+		}
+		c := SelfTestCheck{Name: "pipeline"}
+		c.Status, c.Detail, c.Error = checkOutcome(fmt.Sprintf("%q → %q from %s", q, res.Translation, res.Src), err)
+		rep.add(c, start)
+	}
+	rep.finish()
+	return rep
+}
+
+// checkCanary checks a translation of SELFTEST_PHRASE.
+func (st *selfTest) checkCanary(res translateResult) error {
+	switch {
+	case strings.TrimSpace(res.Translation) == "":
+		return errors.New("empty translation")
+	case st.cfg.SelftestExpect != "" && !strings.EqualFold(strings.TrimSpace(res.Translation), st.cfg.SelftestExpect):
+		return fmt.Errorf("got %q, want %q (SELFTEST_EXPECT)", res.Translation, st.cfg.SelftestExpect)
+	}
+	return nil
+}
+
+// checkOutcome is a check's status, detail and error: the detail on success, the error otherwise.
+func checkOutcome(detail string, err error) (string, string, string) {
+	if err != nil {
+		return selfTestFailed, "", err.Error()
+	}
+	return selfTestOK, detail, ""
+}
+
+// packSample is the first exact-match phrase of the current packs in key order, so each run of
+// one pack set tests the same phrase.
+func packSample(ps *packSet) (source string, p langPair, translation string, ok bool) {
+	if ps == nil {
+		return "", langPair{}, "", false
+	}
+	ix := ps.current()
+	keys := make([]string, 0, len(ix.entries.exact))
+	for k := range ix.entries.exact {
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return "", langPair{}, "", false
+	}
+	sort.Strings(keys)
+	parts := strings.SplitN(keys[0], "\x00", 3)
+	h := ix.entries.exact[keys[0]]
+	return h.source, langPair{parts[0], parts[1]}, h.translation, true
+}
+
+// runOnStart is SELFTEST_ON_START's run in Boot: the report is logged, and a failure pins
+// readiness at 503.
+func (st *selfTest) runOnStart(ctx context.Context, ready *readiness) {
+	rep := st.run(ctx)
+	b, _ := json.Marshal(rep)
+	if !rep.OK {
+		ready.failSelfTest(rep)
+		slog.Error("self-test failed; /go/ready stays 503", "report", json.RawMessage(b))
+		return
+	}
+	slog.Info("self-test passed", "report", json.RawMessage(b))
+}
@@ -0,0 +1,1132 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+)
+
+// j writes JSON with status code. The body is encoded in full before anything is written, so
+// Content-Length is exact (the compression middleware drops it when it gzips the response) and
+// a value that can't be encoded, such as a NaN, becomes a 500 in the error envelope instead of
+// a 200 with half a body. A failed write means the client is gone: it is counted and logged at
+// debug, as there is no one left to tell. Errors go through writeError.
+func j(w http.ResponseWriter, code int, v any) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		slog.Error("json response encode failed", "request_id", w.Header().Get(middleware.RequestIDHeader), "status", code, "err", err)
+		metricResponseEncodeErrors.Inc()
+		if code != http.StatusInternalServerError {
+			writeError(w, http.StatusInternalServerError, codeInternal, "response could not be encoded")
+			return
+		}
+		buf.Reset()
+		buf.WriteString(`{"error":{"code":"INTERNAL","message":"response could not be encoded"}}` + "\n")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(code)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		slog.Debug("json response write failed", "request_id", w.Header().Get(middleware.RequestIDHeader), "bytes", buf.Len(), "err", err)
+		metricResponseWriteErrors.Inc()
+	}
+}
+
+// Server is the service: the router with every route on it, and the listeners, background work
+// and stores around it. New builds one from a Config, Start serves it and Shutdown stops it;
+// Handler is the router alone, for httptest or another binary's server.
+type Server struct {
+	cfg     Config
+	handler http.Handler
+	live    *liveConfig
+
+	srv       *http.Server
+	serve     func(net.Listener) error
+	redirect  *http.Server // the ACME challenge and HTTPS redirect; nil without autocert
+	healthSrv *http.Server // HEALTH_PORT; nil without it
+	gsrv      *grpc.Server // GRPC_PORT; nil without it
+	errc      chan error
+
+	clock    Clock         // Deps.Clock, or the system's
+	started  time.Time     // by clock, for uptimes
+	startup  *startupState // serves until the router is built, and /go/health/startup after
+	ready    *readiness
+	selftest *selfTest
+	health   *healthCheck
+	inflight *inflightRegistry
+	flags    *featureFlags // FEATURE_FLAGS, flipped at /go/admin/flags
+	keys     keyFolding    // how cache and pack keys fold romanized spellings
+	sinks    metricSinks   // STATSD_ADDR's, next to Prometheus
+	retryMax atomic.Int64  // RETRY_AFTER_MAX as a time.Duration; see throttle.go
+	stopBG   context.CancelFunc
+	closers  []func() error // the stores New opened, closed in reverse by Shutdown
+	hooks    []shutdownHook // what else Shutdown stops, by phase; see shutdown.go
+}
+
+// Deps are what New would otherwise build from the Config, for tests to fake and other binaries
+// to supply their own. Any left nil is built as usual.
+type Deps struct {
+	// Cache holds translation results in place of the CACHE_BACKEND one.
+	Cache Cache
+	// Upstream answers what the packs, translation memory and cache can't, in place of the
+	// TRANSLATE_MODE translator. It is probed by /go/ready if it has a Ping method.
+	Upstream Translator
+	// Packs are pack files read in place of PACK_DIR, over the embedded ones.
+	Packs fs.FS
+	// Clock is what uptimes, response timestamps, expiry and the sweepers' ticks are read
+	// from, in place of the system's; see clock.go.
+	Clock Clock
+	// Settings is where the Config came from, for reloads to read again. Without it a reload
+	// reads the environment and CONFIG_FILE.
+	Settings *Settings
+}
+
+// New builds the server for cfg, logging as it goes as the service always has. Nothing listens
+// until Start.
+func New(cfg Config, deps Deps) (*Server, error) {
+	s, err := newServer(cfg, deps.Clock)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.build(cfg, deps); err != nil {
+		return nil, err
+	}
+	s.startup.finish(s.handler)
+	return s, nil
+}
+
+// Boot is New and Start for a process that must pass health checks while it is built. It binds
+// the HTTP listeners at once and answers /go/health/live and /go/health/startup on them, and
+// 503 everywhere else, while the rest is built in the background; when that is done the full
+// router takes over and gRPC and systemd readiness follow, as Start would. A setup failure is
+// reported on Err.
+func Boot(ctx context.Context, cfg Config, deps Deps) (*Server, error) {
+	s, err := newServer(cfg, deps.Clock)
+	if err != nil {
+		return nil, err
+	}
+	// The SQLite stores are migrated first, so a database from a newer build stops the process
+	// before it takes traffic.
+	if err := migrateAll(cfg, s.clock); err != nil {
+		return nil, err
+	}
+	ln, err := listen(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("listen failed: %w", err)
+	}
+	s.serveHTTP(ln)
+	go func() {
+		if err := s.build(cfg, deps); err != nil {
+			s.failed("server setup failed", err)
+			return
+		}
+		if err := s.startGRPC(ctx); err != nil {
+			s.close()
+			s.failed("server start failed", err)
+			return
+		}
+		if cfg.SelftestOnStart {
+			s.startup.set("running the self-test", 0, 0)
+			s.selftest.runOnStart(ctx, s.ready)
+		}
+		s.startup.finish(s.handler)
+		s.notifyReady(ctx)
+	}()
+	return s, nil
+}
+
+// newServer is the part of a Server that must exist before anything listens: the HTTP server
+// with its TLS setup, in front of a handler that Boot serves health checks on until the router
+// is built.
+func newServer(cfg Config, clk Clock) (*Server, error) {
+	if clk == nil {
+		clk = systemClock{}
+	}
+	s := &Server{cfg: cfg, clock: clk, started: clk.Now().UTC(), errc: make(chan error, 4)}
+	s.startup = newStartupState(s.uptime)
+	setLogContent(cfg.LogContent, cfg.LogContentRunes)
+	if cfg.LogContent != logContentNone {
+		slog.Warn("text to translate will appear in logs and error reports", "log_content", cfg.LogContent)
+	}
+	s.srv = &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           s.startup,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	if cfg.EnableH2C {
+		withH2C(s.srv)
+	}
+	// HTTPS when TLS_CERT_FILE or AUTOCERT_DOMAINS is set, plain HTTP otherwise.
+	s.serve, s.redirect = tlsSetup(cfg, s.srv)
+	if cfg.MTLSClientCAFile != "" {
+		if err := requireClientCert(s.srv.TLSConfig, cfg.MTLSClientCAFile); err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+	}
+	// HEALTH_PORT answers the platform's health checks in plain HTTP, for when PORT demands a
+	// client certificate the checker can't present.
+	if cfg.HealthPort != "" {
+		s.healthSrv = healthServer(cfg.HealthPort, s.startup)
+	}
+	return s, nil
+}
+
+// build does the work of New on s: the stores, the upstream, the packs and every route. What it
+// opened is closed again if it fails.
+func (s *Server) build(cfg Config, deps Deps) (err error) {
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+	clk := s.clock
+	// The config reloads swap in; cfg stays the startup one for what is wired once below.
+	if deps.Settings != nil {
+		s.live = newLiveConfig(cfg, deps.Settings.src, deps.Settings.cl.readConfig)
+	} else {
+		s.live = newLiveConfig(cfg, configSources{}, cmdline{}.readConfig)
+	}
+	live := s.live
+	s.startup.set("opening stores", 0, 0)
+
+	// Runtime flags start on, stamped with this server's start; FEATURE_FLAGS is applied below.
+	s.flags = newFeatureFlags(s.started)
+	// Romanized spellings are folded for cache and pack keys, by ROMAN_RULES_FILE's rules if set.
+	s.keys = keyFolding{flags: s.flags}
+	if cfg.RomanRulesFile != "" {
+		rr, err := loadRomanRules(cfg.RomanRulesFile)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		s.keys.rules = rr
+	}
+
+	// IP_ALLOWLIST / IP_DENYLIST against the realIP-resolved address; the rules can be swapped at runtime.
+	ipf := newIPFilter(cfg.IPAllowlist, cfg.IPDenylist)
+
+	// Every outbound call but job callbacks shares one connection pool, sized by HTTP_*, and one
+	// egress policy, which trusts the upstream hosts as they are configured now.
+	egress := newEgressPolicy(cfg)
+	outbound := newOutboundTransport(outboundOptsFrom(cfg), egress)
+
+	// FAULTS injects failures for testing, into upstream calls and routes; never in production,
+	// where the admin route refuses to set any either.
+	var faults *faultInjector
+	switch {
+	case !cfg.Production():
+		faults = newFaultInjector(cfg.Faults)
+	case len(cfg.Faults) > 0:
+		slog.Error("FAULTS ignored: fault injection never runs with ENV=production")
+	}
+
+	// Upstream calls alone can be recorded as fixtures (RECORD_UPSTREAM_DIR) or answered from
+	// them (REPLAY_UPSTREAM_DIR).
+	upstreamRT := outbound
+	switch {
+	case cfg.RecordUpstreamDir != "":
+		rt, err := newRecordingTransport(outbound, cfg.RecordUpstreamDir)
+		if err != nil {
+			return fmt.Errorf("upstream recording failed: %w", err)
+		}
+		upstreamRT = rt
+		slog.Warn("recording upstream fixtures", "dir", cfg.RecordUpstreamDir)
+	case cfg.ReplayUpstreamDir != "":
+		rt, err := newReplayTransport(cfg.ReplayUpstreamDir, cfg.UpstreamStrict)
+		if err != nil {
+			return fmt.Errorf("upstream fixtures load failed: %w", err)
+		}
+		upstreamRT = rt
+	}
+	if faults != nil {
+		upstreamRT = faults.transport(upstreamRT)
+	}
+
+	// Panics and upstream 5xx bursts go to SENTRY_DSN and/or ERROR_WEBHOOK_URL; nil when neither is set.
+	reporter := newErrorReporter(errorReporterOpts{
+		Transport:   outbound,
+		SentryDSN:   cfg.SentryDSN,
+		WebhookURL:  cfg.ErrorWebhookURL,
+		PerMin:      cfg.ErrorReportPerMin,
+		Burst:       cfg.ErrorReportBurst,
+		BurstWindow: cfg.ErrorReportBurstWindow,
+		Env:         cfg.Env,
+		Release:     buildVersion(cfg).SHA,
+		Clock:       clk,
+	})
+
+	// STATSD_ADDR pushes the request, cache and upstream metrics to a StatsD/DogStatsD agent too.
+	statsd, err := newStatsdSink(statsdOpts{
+		Addr:      cfg.StatsdAddr,
+		Prefix:    cfg.StatsdPrefix,
+		Tags:      cfg.StatsdTags,
+		DogStatsD: cfg.StatsdFlavor == "dogstatsd",
+		Buffer:    cfg.StatsdBuffer,
+		Clock:     clk,
+	})
+	if err != nil {
+		return fmt.Errorf("statsd setup failed: %w", err)
+	}
+	if statsd != nil {
+		s.sinks = metricSinks{statsd}
+	}
+
+	// Admin and introspection routes exist only when ADMIN_TOKEN is set; the tokens are separate
+	// from API_KEYS. They also unlock X-Debug-Timing on any request.
+	adminKeys, err := loadKeyStore(cfg.AdminToken, "", clk)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	// ACCESS_LOG_FORMAT adds an access log, in Apache's format for tools that only read that, to or
+	// instead of (LOG_REQUESTS=false) the request lines in the service log.
+	var access *accessLog
+	if cfg.AccessLog != accessLogOff {
+		if access, err = openAccessLog(cfg.AccessLog, cfg.AccessLogFile); err != nil {
+			return fmt.Errorf("access log open failed (path %s): %w", cfg.AccessLogFile, err)
+		}
+		s.closers = append(s.closers, access.Close)
+	}
+
+	// Every request is registered while it runs, for /go/admin/inflight to list and cancel.
+	inflight := &inflightRegistry{clock: clk}
+
+	// Router + essential middlewares
+	r := chi.NewRouter()
+	r.Use(
+		requestID,
+		capRetryAfter(&s.retryMax),
+		realIP(cfg.TrustedProxies),
+		secure(cfg.Security),
+		requestLogger(requestLogOpts{HealthEvery: cfg.LogHealthEvery, Structured: cfg.LogRequests, Access: access}),
+		slowRequests(slowLogOpts{Threshold: cfg.SlowRequestThreshold, Sample: cfg.SlowRequestSample}, adminKeys),
+		traceRequests,
+		instrument(s.sinks),
+		inflight.middleware,
+		ipf.middleware,
+		reporter.middleware,
+		recoverer(reporter),
+		limitBody(cfg.MaxBodyBytes),
+	)
+	if faults != nil {
+		r.Use(faults.middleware)
+	}
+	r.NotFound(notFound)
+	r.MethodNotAllowed(methodNotAllowedOn(r))
+	// CORS only for browser clients (demo mode); server-to-server callers don't need it.
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		r.Use(cors(cfg.CORSAllowedOrigins))
+	}
+	// HEAD, OPTIONS and 405s for every route, after CORS so preflights are its own, and ahead of
+	// compression so a HEAD gets the GET's encoding and length.
+	r.Use(routeMethods(r), compress(cfg.CompressLevel, cfg.BrotliQuality, cfg.CompressMinBytes))
+	// With RESPONSE_SIGNING_KEY, the edge can check that what it relays came from here
+	// unaltered; ahead of the routes, so rejections further down are signed too, and of the API
+	// version's adapter, so what is signed is what the client gets.
+	if key := cfg.signingKey(); key != nil {
+		r.Use(signResponses(key, s.flags, clk))
+	}
+	// With CANONICAL_JSON, between the two: what is signed is canonical, and so is what the
+	// API version's adapter made of it.
+	if cfg.CanonicalJSON {
+		r.Use(canonicalJSON)
+	}
+	// /go/v1 and /go/v2 reach the routes below, registered at their unversioned paths, through
+	// apiVersions; the bare paths stay as deprecated aliases of v1 until LEGACY_API_SUNSET.
+	r.Use(apiVersions(cfg.LegacyAPISunset))
+
+	// Concurrent translate requests are capped, with a short queue in front, so a spike is shed
+	// with 503s at the edge of the process instead of piling onto the upstream.
+	shed := newLoadShedder(cfg.MaxConcurrency, cfg.ConcurrencyQueue, cfg.QueueWaitMax, clk)
+
+	// Maintenance mode 503s the translate routes only, so health checks keep passing.
+	maint := newMaintenanceMode(cfg.Maintenance, cfg.MaintenanceMessage, cfg.MaintenanceRetryAfter, clk)
+
+	// Health and version endpoints (under /go/*), on the short HEALTH_TIMEOUT budget, with their
+	// own per-IP limit well above the translate one so monitors never trip it.
+	quick := r.With(routeTimeout(cfg.HealthTimeout, cfg.DeadlineFloor))
+	var healthLimiter *rateLimiter
+	if cfg.RateLimitHealthPerMin > 0 {
+		healthLimiter = newRateLimiter(cfg.RateLimitHealthPerMin, cfg.RateLimitHealthBurst, cfg.RateLimitIdleTTL, clk).capped(cfg.RateLimitIPMaxAddrs)
+		quick = quick.With(ipRateLimit(healthLimiter, "health"))
+	}
+	// The API document is built from the router once every route is registered, below.
+	apiDocs := &apiDoc{}
+	quick.Get("/go/openapi.json", apiDocs.handler)
+	if cfg.EnableAPIDocs {
+		quick.Get("/go/docs", docsHandler())
+	}
+	limits := inputLimits{
+		MaxChars:         cfg.MaxInputChars,
+		MaxCharsPro:      cfg.MaxInputCharsPro,
+		BatchMaxChars:    cfg.BatchMaxChars,
+		BatchMaxCharsPro: cfg.BatchMaxCharsPro,
+		NBestMax:         cfg.NBestMax,
+	}
+	quick.Get("/go/languages", languagesHandler(langPair{cfg.DefaultSrc, cfg.DefaultDst}, limits))
+
+	r.Method(http.MethodGet, "/go/metrics", metricsHandler(cfg.MetricsToken))
+
+	// Translate endpoint: TRANSLATE_MODE picks what answers after the packs and cache. proxy calls
+	// the FastAPI backend, stub echoes, and pack_only refuses, so a staging instance pointed at the
+	// paid upstream by mistake still never calls it. GET is kept for quick checks, POST takes a
+	// JSON body for longer input.
+	var tr Translator = stubTranslator{}
+	var (
+		readyDeps []dependency        // probed by /go/ready
+		upstream  *failoverTranslator // nil unless proxying
+		mirror    *mirrorTranslator   // in front of upstream; mirrors nothing without MIRROR_*
+	)
+	slog.Info("translate mode", "mode", cfg.TranslateMode)
+	// Runtime flags start from FEATURE_FLAGS; /go/admin/flags flips them.
+	s.flags.set(cfg.FeatureFlags, "env", clk.Now())
+	slog.Info("feature flags", "flags", s.flags.state())
+	if cfg.TranslateMode != modeProxy && len(cfg.UpstreamURLs) > 0 {
+		slog.Warn("upstream configured but not used", "mode", cfg.TranslateMode, "upstreams", len(cfg.UpstreamURLs))
+	}
+	var suggest *suggester // pack_only's near matches, from the packs and memory opened below
+	if cfg.TranslateMode == modePackOnly {
+		suggest = &suggester{minSimilarity: cfg.SuggestMinSimilarity, minLetters: cfg.SuggestMinChars}
+		tr = packOnlyTranslator{suggest: suggest}
+	}
+	switch {
+	case deps.Upstream != nil:
+		tr = deps.Upstream
+		if p, ok := tr.(pinger); ok {
+			readyDeps = append(readyDeps, dependency{Name: "upstream", Probe: p.Ping})
+		}
+	case cfg.TranslateMode == modeProxy:
+		upstream = newFailoverTranslator(newUpstreamMembers(cfg, upstreamRT, reporter, maint, s.flags, s.sinks, clk), s.sinks, clk)
+		upstream.hedge.Store(int64(cfg.HedgeDelay))
+		mirror = &mirrorTranslator{next: upstream}
+		mirror.cur.Store(mirrorFromConfig(cfg, outbound, s.sinks, clk))
+		tr = mirror
+		readyDeps = append(readyDeps, dependency{Name: "upstream", Probe: upstream.Ping})
+		slog.Info("proxying translate", "upstreams", upstream.names())
+	}
+	// The translation memory answers what the upstream has translated before, and admins'
+	// corrections to it, with no expiry. It sits under the cache, which keeps copies of its hits.
+	var tm *translationMemory
+	writes := writeQueueOpts{Size: cfg.WriteQueueSize, Batch: cfg.WriteBatchMax}
+	if cfg.TMDBPath != "" {
+		if tm, err = openTranslationMemory(cfg.TMDBPath, langPair{cfg.DefaultSrc, cfg.DefaultDst}, writes, clk); err != nil {
+			return fmt.Errorf("tm db open failed: %w", err)
+		}
+		s.closers = append(s.closers, tm.Close)
+		s.onShutdown(shutdownHook{name: "tm_writes", phase: phaseWrites, timeout: cfg.WriteDrainTimeout, run: tm.drain, writes: &tm.learns.counts})
+		if suggest != nil || cfg.PackSearch {
+			if err := tm.indexForSuggestions(context.Background()); err != nil {
+				return fmt.Errorf("tm index failed: %w", err)
+			}
+		}
+		if suggest != nil {
+			suggest.tm = tm
+		}
+		tr = &tmTranslator{tm: tm, next: tr}
+		slog.Info("translation memory enabled", "path", cfg.TMDBPath)
+	}
+	// Results are cached in front of whichever translator is active: in memory (LRU + TTL)
+	// by default, or in Redis when CACHE_BACKEND=redis so instances share hits. Entries are
+	// kept for the longest TTL in CACHE_POLICY, and past it for CACHE_STALE_GRACE, twice over,
+	// to be served stale.
+	cache := deps.Cache
+	keep := cfg.CachePolicy.maxTTL(cfg.CacheTTL) + 2*cfg.CacheStaleGrace
+	var shared *sharedLimits // the rate limits and quotas, in the same Redis
+	switch {
+	case cache != nil:
+	case cfg.CacheBackend == "memory":
+		cache = newLRUCache(cfg.CacheMaxEntries, cfg.CacheMaxBytes, cfg.CacheMaxEntryPercent, keep, clk)
+	case cfg.CacheBackend == "redis":
+		rc, err := newRedisCache(cfg.RedisURL, keep, cfg.RedisTimeout, clk)
+		if err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		s.closers = append(s.closers, rc.Close)
+		cache = rc
+		shared = newSharedLimits(rc.rdb, cfg.RedisTimeout, clk)
+	}
+	if p, ok := cache.(pinger); ok {
+		readyDeps = append(readyDeps, dependency{Name: "cache", Probe: p.Ping})
+	}
+	ct := &cachedTranslator{next: tr, cache: cache, flightTimeout: cfg.SharedCallTimeout, ttl: cfg.CacheTTL, grace: cfg.CacheStaleGrace, policy: cfg.CachePolicy, keys: s.keys, sinks: s.sinks, clock: clk}
+	if cfg.NegativeCacheTTL > 0 {
+		ct.errors = newErrorCache(cfg.NegativeCacheTTL, cfg.CacheMaxEntries, clk)
+	}
+	if cfg.CacheDBPath != "" {
+		store, err := openSQLiteCache(cfg.CacheDBPath, sqliteCacheOpts{
+			Retention:      cfg.CacheDBRetention,
+			PruneInterval:  cfg.CacheDBPruneInterval,
+			VacuumInterval: cfg.CacheDBVacuumInterval,
+			Writes:         writes,
+			Clock:          clk,
+		})
+		if err != nil {
+			return fmt.Errorf("cache db open failed: %w", err)
+		}
+		s.closers = append(s.closers, store.Close)
+		s.onShutdown(shutdownHook{name: "cache_db_writes", phase: phaseWrites, timeout: cfg.WriteDrainTimeout, run: store.drain, writes: &store.writes.counts})
+		ct.store = store
+		slog.Info("persistent cache enabled", "path", cfg.CacheDBPath)
+	}
+	// Warm the cache from the last snapshot so a deploy doesn't send every popular phrase upstream.
+	if cfg.CacheSeedPath != "" {
+		s.startup.set("seeding cache", 0, 0)
+		res, err := seedCache(context.Background(), cache, s.keys, cfg.CacheSeedPath, cfg.CacheMaxEntries, cfg.CacheTTL, langPair{cfg.DefaultSrc, cfg.DefaultDst}, clk)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			slog.Info("no cache seed file yet; starting cold", "path", cfg.CacheSeedPath)
+		case err != nil:
+			slog.Warn("cache seed failed; starting cold", "path", cfg.CacheSeedPath, "err", err)
+		default:
+			slog.Info("cache seeded", "path", cfg.CacheSeedPath, "entries", res.Seeded, "skipped", res.Skipped, "duration", res.Duration.String())
+		}
+	}
+	tr = ct
+
+	// Emoji, URLs and glossary terms are masked before the cache, so masked phrases share
+	// entries. Each key's own glossary, from GLOSSARY_DB_PATH, is applied after the global one.
+	var clientGlossary *clientGlossaries
+	if cfg.GlossaryDBPath != "" {
+		if clientGlossary, err = openClientGlossaries(cfg.GlossaryDBPath, cfg.ClientGlossaryMaxTerms, clk); err != nil {
+			return fmt.Errorf("glossary db open failed: %w", err)
+		}
+		s.closers = append(s.closers, clientGlossary.Close)
+	}
+	gt := &glossaryTranslator{clients: clientGlossary, next: tr}
+	if cfg.GlossaryPath != "" {
+		if gt.g, err = loadGlossary(cfg.GlossaryPath); err != nil {
+			return fmt.Errorf("glossary load failed: %w", err)
+		}
+	}
+	tr = gt
+
+	// Curated packs answer exact phrase matches ahead of the cache and upstream: the embedded
+	// baseline, with PACK_DIR (or Deps.Packs) over it.
+	var packs *packSet
+	if cfg.PackDir != "" || cfg.PacksEmbedded || deps.Packs != nil {
+		packs, err = openPacks(cfg.PackDir, deps.Packs, cfg.PacksEmbedded, suggest != nil || cfg.PackSearch, langPair{cfg.DefaultSrc, cfg.DefaultDst}, s.keys, func(loaded, total int) {
+			s.startup.set("loading packs", loaded, total)
+		})
+		if err != nil {
+			return fmt.Errorf("pack load failed (dir %s): %w", cfg.PackDir, err)
+		}
+		packs.onReload = func() { ct.forgetErrors() } // a rejection may now have a pack answer
+		// Reloads reach the rest of the fleet through the shared cache backend, when there is one.
+		packs.sync = &packSync{packs: packs, backend: "none", instance: instanceID(cfg.InstanceID), interval: cfg.PackSyncInterval, clock: clk}
+		if cfg.PackSyncInterval > 0 {
+			if rc, ok := cache.(*redisCache); ok {
+				packs.sync.store, packs.sync.backend, packs.sync.opTimeout = redisPackSync{rdb: rc.rdb}, "redis", cfg.RedisTimeout
+			} else if ct.store != nil {
+				packs.sync.store, packs.sync.backend, packs.sync.opTimeout = sqlitePackSync{db: ct.store.db}, "sqlite", 5*time.Second
+			}
+		}
+		if suggest != nil {
+			suggest.packs = packs
+		}
+		tr = &packTranslator{packs: packs, next: tr}
+	}
+	quick.Get("/go/version", versionHandler(live, packs, ct, s.flags))
+
+	// Readiness: unlike /go/health, fails while the upstream or cache backend is unreachable.
+	ready := newReadiness(cfg.ReadyProbeTimeout, cfg.ReadyCacheTTL, clk, readyDeps...)
+	r.Get("/go/ready", ready.handler)
+
+	s.startup.set("building routes", 0, 0)
+
+	// Translate routes require x-api-key when keys are configured; health/version stay open.
+	keys, err := loadKeyStore(cfg.APIKeys, cfg.APIKeysFile, clk)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	// Bearer tokens checked against JWT_JWKS_URL or JWT_PUBLIC_KEY are accepted alongside keys.
+	jv, err := newJWTVerifier(cfg, outbound, clk)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	// With MTLS_CLIENT_CA_FILE the client certificate identifies the caller; see mtls.go.
+	var certClients *mtlsClients
+	if cfg.MTLSClientCAFile != "" {
+		if certClients, err = loadMTLSClients(cfg.MTLSClientsFile); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+	if keys.Len() == 0 && jv == nil && certClients == nil {
+		slog.Warn("no API_KEYS configured; /go/translate is unauthenticated")
+	}
+
+	// Stripe upgrades and cancellations change key tiers in API_KEYS_FILE. Stripe signs these
+	// itself, so the route sits outside the edge-signed group.
+	if cfg.StripeWebhookSecret != "" {
+		if cfg.APIKeysFile == "" {
+			slog.Warn("STRIPE_WEBHOOK_SECRET set without API_KEYS_FILE; tier changes will be ignored")
+		}
+		r.Post("/go/webhooks/stripe", stripeWebhookHandler([]byte(cfg.StripeWebhookSecret), cfg.StripeTolerance, keys, clk))
+	}
+
+	// Background goroutines (janitors, GC loops) stop when bg is canceled at shutdown.
+	bg, stopBG := context.WithCancel(context.Background())
+	s.stopBG = stopBG
+	if jv != nil {
+		if jk, ok := jv.keys.(*jwksKeys); ok {
+			go jk.run(bg)
+		}
+	}
+
+	// Token bucket per API key, or per client IP for callers without one; default 60 req/min.
+	// The IP buckets are capped, since anyone can bring a new address. With CACHE_BACKEND=redis
+	// the buckets are kept there, shared by every instance and kept over restarts.
+	limiters := tierLimiters{
+		free: newRateLimiter(cfg.RateLimitPerMin, cfg.RateLimitBurst, cfg.RateLimitIdleTTL, clk).sharedAs(shared, tierFree),
+		pro:  newRateLimiter(cfg.RateLimitProPerMin, cfg.RateLimitProBurst, cfg.RateLimitIdleTTL, clk).sharedAs(shared, tierPro),
+		ip:   newRateLimiter(cfg.RateLimitIPPerMin, cfg.RateLimitIPBurst, cfg.RateLimitIdleTTL, clk).capped(cfg.RateLimitIPMaxAddrs).sharedAs(shared, "ip"),
+	}
+	go limiters.run(bg)
+	// Translate requests in flight per API key (default 2 free, 10 pro), apart from MAX_CONCURRENCY.
+	keyConc := newKeyConcurrency(cfg.KeyConcurrency, cfg.KeyConcurrencyPro, clk)
+	if healthLimiter != nil {
+		go healthLimiter.run(bg)
+	}
+	// Pack search is typed into, so it comes in bursts: it has buckets of its own rather than
+	// spending the translate ones.
+	var search *packSearcher
+	var searchLimiter *rateLimiter
+	if cfg.PackSearch && (packs != nil || tm != nil) {
+		search = &packSearcher{packs: packs, tm: tm, pg: newPager(cfg.PageCursorSecret), limits: limits}
+		searchLimiter = newRateLimiter(cfg.RateLimitSearchPerMin, cfg.RateLimitSearchBurst, cfg.RateLimitIdleTTL, clk).capped(cfg.RateLimitIPMaxAddrs).sharedAs(shared, "search")
+		go searchLimiter.run(bg)
+	}
+	// POST responses kept for Idempotency-Key replays (default 24h, 1000 keys per caller).
+	idem := newIdempotencyStore(cfg.IdempotencyTTL, cfg.IdempotencyMaxKeys, clk)
+	go idem.run(bg)
+	if upstream != nil {
+		upstream.startPolls(bg)
+	}
+
+	// SIGHUP and POST /go/admin/reload re-read the environment and CONFIG_FILE. What can change in
+	// place registers here; anything else is reported as needing a restart.
+	live.register(reloadPart{name: "log_level", fields: []string{"LogLevel"}, apply: func(_, next *Config) error {
+		setLogLevel(next.LogLevel)
+		return nil
+	}})
+	live.register(reloadPart{name: "log_content", fields: []string{"LogContent", "LogContentRunes"}, apply: func(_, next *Config) error {
+		setLogContent(next.LogContent, next.LogContentRunes)
+		return nil
+	}})
+	live.register(reloadPart{name: "feature_flags", fields: []string{"FeatureFlags"}, apply: func(old, next *Config) error {
+		// Only what FEATURE_FLAGS itself changed, so flags flipped at /go/admin/flags otherwise stay.
+		// A flag dropped from it goes back to on.
+		changes := map[flag]bool{}
+		for fl, v := range next.FeatureFlags {
+			if was, ok := old.FeatureFlags[fl]; !ok || was != v {
+				changes[fl] = v
+			}
+		}
+		for fl := range old.FeatureFlags {
+			if _, ok := next.FeatureFlags[fl]; !ok {
+				changes[fl] = true
+			}
+		}
+		s.flags.set(changes, "reload", clk.Now())
+		return nil
+	}})
+	s.retryMax.Store(int64(cfg.RetryAfterMax))
+	live.register(reloadPart{name: "retry_after_max", fields: []string{"RetryAfterMax"}, apply: func(_, next *Config) error {
+		s.retryMax.Store(int64(next.RetryAfterMax))
+		return nil
+	}})
+	live.register(reloadPart{
+		name:   "rate_limits",
+		fields: []string{"RateLimitPerMin", "RateLimitBurst", "RateLimitProPerMin", "RateLimitProBurst", "RateLimitIPPerMin", "RateLimitIPBurst"},
+		apply: func(_, next *Config) error {
+			limiters.free.setLimit(next.RateLimitPerMin, next.RateLimitBurst)
+			limiters.pro.setLimit(next.RateLimitProPerMin, next.RateLimitProBurst)
+			limiters.ip.setLimit(next.RateLimitIPPerMin, next.RateLimitIPBurst)
+			return nil
+		},
+	})
+	live.register(reloadPart{name: "key_concurrency", fields: []string{"KeyConcurrency", "KeyConcurrencyPro"}, apply: func(_, next *Config) error {
+		keyConc.setLimits(next.KeyConcurrency, next.KeyConcurrencyPro)
+		return nil
+	}})
+	if healthLimiter != nil {
+		live.register(reloadPart{
+			name:   "health_rate_limit",
+			fields: []string{"RateLimitHealthPerMin", "RateLimitHealthBurst"},
+			can:    func(_, next *Config) bool { return next.RateLimitHealthPerMin > 0 }, // the limiter can't be taken out
+			apply: func(_, next *Config) error {
+				healthLimiter.setLimit(next.RateLimitHealthPerMin, next.RateLimitHealthBurst)
+				return nil
+			},
+		})
+	}
+	if searchLimiter != nil {
+		live.register(reloadPart{name: "search_rate_limit", fields: []string{"RateLimitSearchPerMin", "RateLimitSearchBurst"}, apply: func(_, next *Config) error {
+			searchLimiter.setLimit(next.RateLimitSearchPerMin, next.RateLimitSearchBurst)
+			return nil
+		}})
+	}
+	if upstream != nil {
+		live.register(reloadPart{name: "hedge_delay", fields: []string{"HedgeDelay"}, apply: func(_, next *Config) error {
+			upstream.hedge.Store(int64(next.HedgeDelay))
+			return nil
+		}})
+		live.register(reloadPart{
+			name: "upstreams",
+			fields: []string{"UpstreamURL", "UpstreamURLs", "UpstreamWeights", "UpstreamTimeout", "UpstreamMaxAttempts", "UpstreamRetryBase",
+				"UpstreamExtended", "UpstreamStrict", "BreakerFailures", "BreakerCooldown", "UpstreamPollInterval", "UpstreamPollTimeout", "UpstreamPollWindow",
+				"AdaptiveTimeout", "AdaptiveTimeoutQuantile", "AdaptiveTimeoutFactor", "AdaptiveTimeoutSamples", "AdaptiveTimeoutFloor"},
+			can: func(_, next *Config) bool { return next.TranslateMode == modeProxy },
+			apply: func(_, next *Config) error {
+				egress.trustUpstreams(upstreamHosts(*next))
+				upstream.replace(newUpstreamMembers(*next, upstreamRT, reporter, maint, s.flags, s.sinks, clk))
+				return nil
+			},
+		})
+		// A mirror that turned itself off resumes on any reload; changed MIRROR_* settings start
+		// a new one, with its report from zero.
+		live.register(reloadPart{
+			name: "mirror",
+			fields: []string{"MirrorURL", "MirrorSampleRate", "MirrorTimeout", "MirrorMaxInFlight", "MirrorMaxConnShare",
+				"MirrorReportSamples", "MirrorAgreeSimilarity", "UpstreamStrict"},
+			can: func(_, next *Config) bool { return next.TranslateMode == modeProxy },
+			apply: func(_, next *Config) error {
+				egress.trustUpstreams(upstreamHosts(*next))
+				mirror.cur.Store(mirrorFromConfig(*next, outbound, s.sinks, clk))
+				return nil
+			},
+		})
+		live.register(reloadPart{name: "mirror_resume", apply: func(_, _ *Config) error {
+			if m := mirror.cur.Load(); m != nil {
+				m.resume()
+			}
+			return nil
+		}})
+	}
+	if faults != nil {
+		live.register(reloadPart{name: "faults", fields: []string{"Faults"}, apply: func(_, next *Config) error {
+			faults.set(next.Faults)
+			return nil
+		}})
+	}
+	// Packs re-read their files on every reload, as SIGHUP always did; a pack that doesn't parse
+	// leaves the old packs serving and is reported without holding up the rest.
+	if packs != nil {
+		live.register(reloadPart{name: "packs", apply: func(_, _ *Config) error {
+			_, err := packs.reload("config")
+			return err
+		}})
+	}
+	// The access log is reopened on every reload, so it can be rotated by moving it and sending SIGHUP.
+	if access != nil {
+		live.register(reloadPart{name: "access_log", apply: func(_, _ *Config) error { return access.reopen() }})
+	}
+
+	if packs != nil && packs.sync.store != nil {
+		packs.sync.start(bg)
+		slog.Info("pack sync enabled", "backend", packs.sync.backend, "instance", packs.sync.instance, "interval", cfg.PackSyncInterval.String())
+	}
+
+	// Packs also reload on file changes with PACK_WATCH.
+	if packs != nil && cfg.PackDir != "" && deps.Packs == nil {
+		if cfg.PackWatch {
+			if err := packs.watch(bg, 500*time.Millisecond); err != nil {
+				return fmt.Errorf("pack watch failed (dir %s): %w", cfg.PackDir, err)
+			}
+		}
+	}
+
+	// Streams and WebSockets end when shutdown starts; see sessionGroup.
+	sessions := newSessionGroup()
+
+	// Per-key usage and daily character quotas, snapshotted to USAGE_FILE; the quotas are
+	// enforced from Redis too with CACHE_BACKEND=redis. QUOTA_GRACE_* serve past a quota as
+	// metered overage.
+	usage, err := newUsageMeter(cfg.UsageFile, map[string]int64{
+		tierFree: int64(cfg.QuotaCharsFree),
+		tierPro:  int64(cfg.QuotaCharsPro),
+	}, clk)
+	if err != nil {
+		return fmt.Errorf("usage load failed: %w", err)
+	}
+	usage.shared = shared
+	usage.grace = map[string]int64{tierFree: int64(cfg.QuotaGraceFree), tierPro: int64(cfg.QuotaGracePro)}
+	usage.warnAt = int64(cfg.QuotaWarnPercent)
+	go usage.run(bg, cfg.UsageFlushInterval)
+	// USAGE_DB_PATH also records every request for the daily reports under /go/admin/reports.
+	if cfg.UsageDBPath != "" {
+		if usage.store, err = openUsageStore(cfg.UsageDBPath, usageStoreOpts{Retention: cfg.UsageDetailKeep, RollupInterval: cfg.UsageRollupEvery, Clock: clk}); err != nil {
+			return fmt.Errorf("usage db open failed: %w", err)
+		}
+		s.closers = append(s.closers, usage.store.Close)
+	}
+
+	var jobs *jobStore
+	if cfg.JobsDBPath != "" {
+		if jobs, err = openJobStore(cfg.JobsDBPath, clk); err != nil {
+			return fmt.Errorf("job db open failed: %w", err)
+		}
+		s.closers = append(s.closers, jobs.Close)
+	}
+
+	arts, err := openArtifacts(cfg.ExportSpoolDir, cfg.ExportInlineMaxBytes, cfg.ExportRetention, clk)
+	if err != nil {
+		return fmt.Errorf("export spool dir: %w", err)
+	}
+	go arts.run(bg)
+
+	svc := &translateService{
+		t:      tr,
+		limits: limits,
+		input:  InputOptions{RejectControls: cfg.InputControlChars == controlsReject},
+		batch: batchOpts{
+			MaxItems:    cfg.BatchMaxItems,
+			MaxItemsPro: cfg.BatchMaxItemsPro,
+			Workers:     cfg.BatchConcurrency,
+		},
+		segment: segmentOpts{
+			Enabled: cfg.SegmentSentences,
+			Workers: cfg.SegmentWorkers,
+		},
+		detect: detectOpts{
+			Default:  langPair{cfg.DefaultSrc, cfg.DefaultDst},
+			MinChars: cfg.DetectMinChars,
+		},
+		usage: usage,
+		flags: s.flags,
+		keys:  s.keys,
+		clock: clk,
+	}
+
+	// /go/health?verbose=1 probes everything /go/ready does, plus each upstream on its own when
+	// there are several, the in-process cache and the cache, job and glossary dbs, for admins.
+	probes := append([]dependency(nil), readyDeps...)
+	if _, ok := cache.(pinger); !ok {
+		probes = append(probes, dependency{Name: "cache", Probe: func(ctx context.Context) error {
+			_, err := cache.Stats(ctx)
+			return err
+		}})
+	}
+	if ct.store != nil {
+		probes = append(probes, dependency{Name: "cache_db", Probe: ct.store.Ping})
+	}
+	if jobs != nil {
+		probes = append(probes, dependency{Name: "jobs_db", Probe: jobs.Ping})
+	}
+	if clientGlossary != nil {
+		probes = append(probes, dependency{Name: "glossary_db", Probe: clientGlossary.Ping})
+	}
+	if tm != nil {
+		probes = append(probes, dependency{Name: "tm_db", Probe: tm.Ping})
+	}
+	health := &healthCheck{maint: maint, admins: adminKeys, probes: probes, timeout: cfg.ReadyProbeTimeout, packs: packs, upstream: upstream, shed: shed, clock: clk, uptime: s.uptime, lastErr: map[string]probeFailure{}}
+	quick.Get("/go/health", health.handler)
+	quick.Get("/go/health/live", s.startup.live)
+	quick.Get("/go/health/startup", s.startup.handler)
+	if adminKeys.Len() > 0 {
+		dashboardRoutes(r)
+		audit, err := openAuditLog(cfg.AuditLogPath, int64(cfg.AuditLogMaxBytes), cfg.AuditLogKeep, clk)
+		if err != nil {
+			return fmt.Errorf("audit log open failed (path %s): %w", cfg.AuditLogPath, err)
+		}
+		r.Route("/go/admin", adminRoutes(adminKeys, keys, ct, cfg.CacheSeedPath, packs, upstream, mirror, faults, usage, keyConc, maint, audit, live, svc, clientGlossary, tm, jobs, arts, newPager(cfg.PageCursorSecret), inflight, warmOpts{
+			MaxItems:     cfg.CacheWarmMaxItems,
+			Workers:      cfg.CacheWarmConcurrency,
+			Timeout:      cfg.CacheWarmTimeout,
+			MaxBodyBytes: cfg.BatchMaxBodyBytes,
+			Floor:        cfg.DeadlineFloor,
+		}, packCheckOpts{
+			MaxItems:      cfg.PackCheckMaxItems,
+			MaxBodyBytes:  cfg.JobsMaxBodyBytes,
+			MinSimilarity: cfg.SuggestMinSimilarity,
+		}))
+		r.With(requireAdmin(adminKeys)).Get("/go/stats", statsHandler(ct, shed, clk, s.uptime))
+	}
+	// pprof and expvar for live diagnosis; without ENABLE_DEBUG the routes don't exist at all.
+	if cfg.EnableDebug {
+		if adminKeys.Len() == 0 {
+			slog.Warn("ENABLE_DEBUG set without ADMIN_TOKEN; /go/debug stays unreachable")
+		}
+		publishDebugVars(cache, s.uptime)
+		r.Route("/go/debug", debugRoutes(adminKeys))
+	}
+
+	tl := &transliterator{table: mustParseTranslitTable(defaultTranslitTable), policy: cfg.CachePolicy, limits: limits}
+	if cfg.TranslitTableFile != "" {
+		if tl.table, err = loadTranslitTable(cfg.TranslitTableFile); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+	if cfg.TranslitCacheEntries > 0 {
+		tl.cache = newTranslitCache(cfg.TranslitCacheEntries, clk)
+	}
+	if search != nil {
+		search.table = tl.table
+	}
+
+	var jr *jobRunner
+	if jobs != nil {
+		jr = newJobRunner(jobs, svc, jobOpts{
+			Workers:       cfg.JobsWorkers,
+			Retention:     cfg.JobsRetention,
+			MaxItems:      cfg.JobsMaxItems,
+			MaxItemsFree:  cfg.BatchMaxItems,
+			MaxPending:    cfg.JobsMaxPending,
+			InlineResults: cfg.JobsInlineResults,
+			ItemTimeout:   cfg.JobsItemTimeout,
+			Scopes:        keys.scopesOf,
+			Artifacts:     arts,
+			Callbacks: callbackOpts{
+				MaxAttempts:  cfg.JobsCallbackMaxAttempts,
+				RetryBase:    cfg.JobsCallbackRetryBase,
+				Timeout:      cfg.JobsCallbackTimeout,
+				Workers:      cfg.JobsWorkers,
+				AllowPrivate: cfg.JobsCallbackAllowPrivate,
+				Secrets:      keys.webhookSecret,
+			},
+		})
+		jr.start()
+	}
+
+	bulkMaxBody := int64(cfg.BulkMaxLines) * int64(cfg.BulkMaxLineBytes+1)
+	r.Group(func(r chi.Router) {
+		// When EDGE_HMAC_SECRET is set, only requests signed by the edge worker get through.
+		// EDGE_HMAC_SECRET_PREVIOUS keeps the old secret valid during rotation. Nonces are
+		// tracked in Redis when the cache lives there, so a replay to another instance fails too.
+		if secrets := cfg.edgeSecrets(); len(secrets) > 0 {
+			var nonces nonceStore
+			if rc, ok := cache.(*redisCache); ok {
+				nonces = &redisNonces{rdb: rc.rdb, opTimeout: cfg.RedisTimeout, clock: clk}
+			} else {
+				mn := newMemoryNonces(cfg.EdgeNonceMax, clk)
+				go mn.run(bg, time.Minute)
+				nonces = mn
+			}
+			r.Use(requireEdgeSignature(secrets, cfg.EdgeMaxSkew, nonces, clk))
+		}
+		if certClients != nil {
+			r.Use(clientCertIdentity(certClients))
+		}
+		// With API_KEYS_FILE, keys minted at runtime turn auth on without a restart.
+		if keys.Len() > 0 || cfg.APIKeysFile != "" || jv != nil {
+			r.Use(requireAPIKey(keys, jv))
+		}
+		// Replays are answered before the rate limiter, so a client's retries don't spend its quota.
+		r.Use(idempotent(idem, max(cfg.MaxBodyBytes, cfg.BatchMaxBodyBytes, cfg.JobsMaxBodyBytes, bulkMaxBody)))
+		// Registered ahead of the tier limiter, which so doesn't apply to it.
+		if search != nil {
+			r.With(callerRateLimit(searchLimiter, "search"), routeTimeout(cfg.TranslitTimeout, cfg.DeadlineFloor)).Get("/go/packs/search", search.handler)
+		}
+		r.Use(rateLimit(limiters))
+		r.Use(usage.quotaWarnings)
+		r.Get("/go/usage", usageHandler(usage, limiters, keyConc, clientGlossary))
+		r.Get("/go/keys/self/glossary", glossaryGetHandler(clientGlossary, selfGlossaryKey))
+		r.Put("/go/keys/self/glossary", glossaryPutHandler(clientGlossary, selfGlossaryKey))
+		r.Delete("/go/keys/self/glossary", glossaryDeleteHandler(clientGlossary, selfGlossaryKey))
+		// Transliteration never leaves the process, so it skips the load shedder and
+		// maintenance mode and gets a budget of its own.
+		r.With(routeTimeout(cfg.TranslitTimeout, cfg.DeadlineFloor)).Get("/go/transliterate", tl.handler)
+		r.With(routeTimeout(cfg.TranslitTimeout, cfg.DeadlineFloor)).Post("/go/transliterate", tl.handler)
+		if jr != nil {
+			r.With(maint.guard, routeTimeout(cfg.BatchTimeout, cfg.DeadlineFloor), limitBody(cfg.JobsMaxBodyBytes)).Post("/go/jobs", jr.submit)
+			r.Get("/go/jobs/{id}", jr.status)
+			r.Get("/go/jobs/{id}/results", jr.results)
+			r.Delete("/go/jobs/{id}", jr.cancel)
+		}
+		// The load shedder runs under the route timeout, so time spent queued counts against it.
+		r.Group(func(r chi.Router) {
+			r.Use(maint.guard)
+			r.Use(keyConc.middleware)
+			if cfg.StubOptIn && cfg.TranslateMode == modeProxy {
+				r.Use(stubOptIn)
+			}
+			r.With(routeTimeout(cfg.TranslateTimeout, cfg.DeadlineFloor), shed.middleware).Get("/go/translate", translateHandler(svc, cfg.DebugHeaders, cfg.ProvenancePro, cfg.CachePolicy, cfg.CacheTTL))
+			r.With(routeTimeout(cfg.TranslateTimeout, cfg.DeadlineFloor), shed.middleware).Post("/go/translate", translateHandler(svc, cfg.DebugHeaders, cfg.ProvenancePro, cfg.CachePolicy, cfg.CacheTTL))
+			r.With(routeTimeout(cfg.BatchTimeout, cfg.DeadlineFloor), shed.middleware, limitBody(cfg.BatchMaxBodyBytes)).Post("/go/translate/batch", batchHandler(svc))
+			r.With(routeTimeout(cfg.BatchTimeout, cfg.DeadlineFloor), shed.middleware, limitBody(bulkMaxBody)).Post("/go/translate/bulk", bulkHandler(svc, bulkOpts{
+				MaxLines:     cfg.BulkMaxLines,
+				MaxLineBytes: cfg.BulkMaxLineBytes,
+				Workers:      cfg.BatchConcurrency,
+			}))
+		})
+		// Streams and WebSocket sessions carry their own limits (STREAM_MAX_DURATION, pings) instead
+		// of a route timeout.
+		r.With(maint.guard).Get("/go/translate/stream", streamHandler(svc, streamOpts{
+			MaxDuration: cfg.StreamMaxDuration,
+			KeepAlive:   cfg.StreamKeepAlive,
+			Input:       svc.input,
+		}, sessions.ctx))
+		r.With(maint.guard).Get("/go/ws", wsHandler(svc, wsOpts{
+			MaxMessageBytes: cfg.WSMaxMessageBytes,
+			Debounce:        cfg.WSDebounce,
+			PingInterval:    cfg.WSPingInterval,
+			RatePerMin:      cfg.WSRatePerMin,
+			RateBurst:       cfg.WSRateBurst,
+			Origins:         cfg.CORSAllowedOrigins,
+			Clock:           clk,
+		}, sessions))
+	})
+
+	missing, err := apiDocs.build(r, buildVersion(cfg).SHA)
+	if err != nil {
+		return fmt.Errorf("api document build failed: %w", err)
+	}
+	for _, route := range missing {
+		slog.Warn("route missing from the api document", "route", route)
+	}
+
+	// gRPC on its own port when GRPC_PORT is set, sharing svc with the HTTP routes.
+	if cfg.GRPCPort != "" {
+		s.gsrv = newGRPCServer(svc, keys, jv, limiters, cfg.TranslateTimeout, cfg.BatchTimeout, s.uptime)
+	}
+
+	s.handler = r
+	s.selftest = newSelfTest(cfg, svc, packs, readyDeps, upstream, deps.Upstream)
+	s.ready, s.health, s.inflight = ready, health, inflight
+
+	// What the requests leave running stops after the listeners, before the write queues drain.
+	s.srv.RegisterOnShutdown(sessions.stop)
+	s.onShutdown(shutdownHook{name: "sessions", phase: phaseWork, run: sessions.wait})
+	if jr != nil {
+		s.onShutdown(shutdownHook{name: "jobs", phase: phaseWork, run: jr.stop})
+	}
+	s.onShutdown(shutdownHook{name: "exports", phase: phaseWork, run: arts.stop})
+	if reporter != nil {
+		s.onShutdown(shutdownHook{name: "error_reports", phase: phaseWork, run: reporter.stop})
+	}
+	if statsd != nil {
+		s.onShutdown(shutdownHook{name: "statsd", phase: phaseWork, run: statsd.stop})
+	}
+	s.onShutdown(shutdownHook{name: "usage_snapshot", phase: phaseWork, run: func(context.Context) error { return usage.flush() }})
+	return nil
+}
+
+// Handler is the router, with every route and middleware on it.
+func (s *Server) Handler() http.Handler { return s.handler }
+
+// Start binds the listeners and serves on them in the background; an error is a listener that
+// couldn't be bound, and one that fails later is reported on Err. ctx bounds binding the
+// gRPC port and, with the systemd watchdog on, how long it is fed.
+func (s *Server) Start(ctx context.Context) error {
+	ln, err := listen(s.cfg)
+	if err != nil {
+		return fmt.Errorf("listen failed: %w", err)
+	}
+	if err := s.startGRPC(ctx); err != nil {
+		ln.Close()
+		return err
+	}
+	s.serveHTTP(ln)
+	s.notifyReady(ctx)
+	return nil
+}
+
+// serveHTTP serves the main listener ln, the ACME redirect and HEALTH_PORT in the background.
+func (s *Server) serveHTTP(ln net.Listener) {
+	go func() {
+		slog.Info("backend-go listening", "addr", ln.Addr().String(), "tls", s.srv.TLSConfig != nil)
+		s.failed("server error", s.serve(ln))
+	}()
+	if s.redirect != nil {
+		go func() {
+			slog.Info("acme challenge and https redirect listening", "port", s.cfg.AutocertHTTPPort, "domains", s.cfg.AutocertDomains)
+			s.failed("redirect server error", s.redirect.ListenAndServe())
+		}()
+	}
+	if s.healthSrv != nil {
+		go func() {
+			slog.Info("health listening", "port", s.cfg.HealthPort)
+			s.failed("health server error", s.healthSrv.ListenAndServe())
+		}()
+	}
+}
+
+// startGRPC binds GRPC_PORT, if set, and serves it in the background.
+func (s *Server) startGRPC(ctx context.Context) error {
+	if s.gsrv == nil {
+		return nil
+	}
+	lis, err := (&net.ListenConfig{}).Listen(ctx, "tcp", ":"+s.cfg.GRPCPort)
+	if err != nil {
+		return fmt.Errorf("grpc listen failed (port %s): %w", s.cfg.GRPCPort, err)
+	}
+	go func() {
+		slog.Info("grpc listening", "port", s.cfg.GRPCPort)
+		s.failed("grpc server error", s.gsrv.Serve(lis))
+	}()
+	return nil
+}
+
+// notifyReady tells systemd (Type=notify) the service is ready and keeps its watchdog fed while
+// /go/health answers, until ctx is done.
+func (s *Server) notifyReady(ctx context.Context) {
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("systemd notify failed", "err", err)
+	}
+	if interval, ok := sdWatchdogInterval(); ok {
+		go sdWatchdog(ctx, interval, handlerHealthy(s.health.handler, "/go/health"))
+	}
+}
+
+// failed reports a server that stopped serving other than by being shut down.
+func (s *Server) failed(what string, err error) {
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		return
+	}
+	select {
+	case s.errc <- fmt.Errorf("%s: %w", what, err):
+	default:
+	}
+}
+
+// Err receives an error when one of the listeners Start began serving on fails, or, after Boot,
+// when building the server does.
+func (s *Server) Err() <-chan error { return s.errc }
+
+// Reload re-reads the configuration and applies what can change in place, as SIGHUP and
+// POST /go/admin/reload do.
+func (s *Server) Reload(reason string) error {
+	if !s.startup.done() {
+		slog.Warn("config reload skipped: still starting", "reason", reason)
+		return errStarting
+	}
+	_, err := s.live.reload(reason)
+	return err
+}
+
+// Shutdown stops the server gracefully: readiness fails first, then, after SHUTDOWN_DELAY, the
+// listeners stop accepting and in-flight requests, streams and jobs get until ctx is done to
+// finish. Usage is flushed, queued cache DB and TM writes get WRITE_DRAIN_TIMEOUT to land, and
+// the stores New opened are closed afterwards, whatever happened before. It is Stop without a
+// cause, returning the report's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.Stop(ctx, StopCause{}).Err()
+}
+
+// close stops the background work and closes the stores, newest first.
+func (s *Server) close() {
+	if s.stopBG != nil {
+		s.stopBG()
+	}
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if err := s.closers[i](); err != nil {
+			slog.Warn("close failed", "err", err)
+		}
+	}
+	s.closers = nil
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Helpers for the tests that go through the router, as a request from the edge would.
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&suiteLog, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	os.Exit(m.Run())
+}
+
+// suiteLog holds every structured log line the tests produce, debug included, for the tests
+// that check what the logs say.
+var suiteLog logBuffer
+
+// logBuffer is a buffer the log handler may write from any goroutine.
+type logBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+// mark is the offset of the next line, for since.
+func (l *logBuffer) mark() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Len()
+}
+
+// since returns what was logged after mark.
+func (l *logBuffer) since(mark int) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return string(l.b.Bytes()[mark:])
+}
+
+// Keys in every test server's key file.
+const (
+	testFreeKey = "fk" // id freebie
+	testProKey  = "pk" // id acme
+	testAdmin   = "adm"
+)
+
+// echoUpstream answers every phrase with EN(q), counting the calls; err, when set, is
+// returned instead.
+type echoUpstream struct {
+	calls atomic.Int64
+	err   error
+}
+
+func (u *echoUpstream) Translate(_ context.Context, req translateReq) (translateResult, error) {
+	u.calls.Add(1)
+	if u.err != nil {
+		return translateResult{}, u.err
+	}
+	return translateResult{Translation: "EN(" + req.Q + ")", Src: "upstream"}, nil
+}
+
+// newTestServer builds a Server as New does, from env over the test defaults, with deps's
+// upstream or, unless env configures one, an echoUpstream. It is stopped when the test ends.
+func newTestServer(t *testing.T, env map[string]string, deps Deps) *Server {
+	t.Helper()
+	cfg := testConfig(t, env)
+	if deps.Upstream == nil && cfg.UpstreamURL == nil {
+		deps.Upstream = &echoUpstream{}
+	}
+	s, err := New(cfg, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Stop(ctx, StopCause{})
+	})
+	return s
+}
+
+// testConfig loads a Config from env over the test defaults: development, the test keys, the
+// admin token and a spool directory of the test's own.
+func testConfig(t *testing.T, env map[string]string) Config {
+	t.Helper()
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(keys, []byte(`[{"key":"fk","id":"freebie"},{"key":"pk","id":"acme","tier":"pro"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{
+		"ENV":              "development",
+		"API_KEYS_FILE":    keys,
+		"ADMIN_TOKEN":      testAdmin,
+		"EXPORT_SPOOL_DIR": filepath.Join(dir, "exports"),
+	}
+	for k, v := range env {
+		vars[k] = v
+	}
+	cfg, err := LoadConfig(func(k string) string { return vars[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// serve sends a request with body (none when empty) and headers, given as name-value pairs,
+// through h.
+func serve(h http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, rd)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// TestServersKeepTheirOwnState builds two servers in one process with different flags, spelling
+// rules, Retry-After caps and metric sinks, and checks neither sees the other's.
+func TestServersKeepTheirOwnState(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "romanize.json")
+	if err := os.WriteFile(rules, []byte(`{"separators":"","replace":[],"collapse":""}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	limit := map[string]string{"RATE_LIMIT_PER_MIN": "1", "RATE_LIMIT_BURST": "1"}
+	with := func(env map[string]string) map[string]string {
+		for k, v := range limit {
+			env[k] = v
+		}
+		return env
+	}
+	a := newTestServer(t, with(map[string]string{"FEATURE_FLAGS": "nbest=false", "RETRY_AFTER_MAX": "5s", "STATSD_ADDR": "127.0.0.1:9"}), Deps{})
+	b := newTestServer(t, with(map[string]string{"ROMAN_RULES_FILE": rules}), Deps{})
+	ha, hb := a.Handler(), b.Handler()
+
+	if w := serve(hb, "PATCH", "/go/admin/flags", `{"fuzzy_keys":false}`, "Authorization", "Bearer "+testAdmin, "Content-Type", "application/json"); w.Code != http.StatusOK {
+		t.Fatalf("flags patch: status %d: %s", w.Code, w.Body.String())
+	}
+	flagsOf := func(h http.Handler) map[string]bool {
+		var v struct {
+			Flags map[string]bool `json:"flags"`
+		}
+		json.Unmarshal(serve(h, "GET", "/go/version", "").Body.Bytes(), &v)
+		return v.Flags
+	}
+	if fa, fb := flagsOf(ha), flagsOf(hb); fa["nbest"] || !fa["fuzzy_keys"] || !fb["nbest"] || fb["fuzzy_keys"] {
+		t.Fatalf("flags: a %v, b %v", fa, fb)
+	}
+
+	if got, want := a.keys.canonicalKey("Raashee"), "rashi"; got != want {
+		t.Fatalf("a folds to %q, want the built-in rules' %q", got, want)
+	}
+	if got, want := b.keys.canonicalKey("Raashee"), "raashee"; got != want {
+		t.Fatalf("b folds to %q, want its own rules' %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		h     http.Handler
+		retry string
+	}{{"a", ha, "5"}, {"b", hb, "60"}} {
+		serve(tt.h, "GET", "/go/translate?q=hello", "", "X-API-Key", testFreeKey)
+		w := serve(tt.h, "GET", "/go/translate?q=hello", "", "X-API-Key", testFreeKey)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != tt.retry {
+			t.Fatalf("%s: status %d, Retry-After %q, want 429 with %s", tt.name, w.Code, w.Header().Get("Retry-After"), tt.retry)
+		}
+	}
+
+	if len(a.sinks) != 1 || len(b.sinks) != 0 {
+		t.Fatalf("sinks: a %d, b %d", len(a.sinks), len(b.sinks))
+	}
+}
+
+// TestServerEndToEnd walks a server from New through its Handler as a client would: health,
+// version, and a translation by GET and by POST, at the legacy path and at v1.
+func TestServerEndToEnd(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	up := &echoUpstream{}
+	h := newTestServer(t, nil, Deps{Upstream: up, Clock: clk}).Handler()
+	ts := "2026-03-01T12:00:00Z"
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		want       map[string]any // fields of the JSON body
+		deprecated bool
+	}{
+		{"health", "GET", "/go/health", "", map[string]any{"status": "ok", "ts": ts}, false},
+		{"version", "GET", "/go/version", "", map[string]any{"sha": "dev", "translate_mode": "stub"}, false},
+		{"translate by GET", "GET", "/go/translate?q=hello", "", map[string]any{"translation": "EN(hello)", "src": "upstream", "src_lang": "dv", "dst_lang": "en", "ts": ts}, true},
+		{"translate by POST", "POST", "/go/translate", `{"q":"good morning","src":"dv","dst":"en"}`, map[string]any{"translation": "EN(good morning)", "ts": ts}, true},
+		{"translate at v1", "GET", "/go/v1/translate?q=hello", "", map[string]any{"translation": "EN(hello)", "ts": ts}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, tt.method, tt.target, tt.body, "X-API-Key", testProKey, "Content-Type", "application/json", "X-Request-Id", "e2e-"+tt.method)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("Content-Type %q", ct)
+			}
+			if id := w.Header().Get("X-Request-Id"); id != "e2e-"+tt.method {
+				t.Fatalf("X-Request-Id %q", id)
+			}
+			if got := w.Header().Get("Deprecation") != ""; got != tt.deprecated {
+				t.Fatalf("Deprecation %q, want deprecated %v", w.Header().Get("Deprecation"), tt.deprecated)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%v: %s", err, w.Body.String())
+			}
+			for k, v := range tt.want {
+				if body[k] != v {
+					t.Fatalf("%s %v, want %v: %s", k, body[k], v, w.Body.String())
+				}
+			}
+		})
+	}
+	// hello and good morning each reached the upstream once; v1's hello came from the cache.
+	if n := up.calls.Load(); n != 2 {
+		t.Fatalf("%d upstream calls, want 2", n)
+	}
+}
+
+// brokenWriter is a client that went away: every Write fails.
+type brokenWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *brokenWriter) Header() http.Header       { return w.header }
+func (w *brokenWriter) WriteHeader(code int)      { w.code = code }
+func (w *brokenWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+// TestJSONResponse checks j encodes before writing anything: a value JSON can't hold becomes a
+// 500 envelope, a large body goes out whole with its Content-Length, and a failed write is
+// counted instead of ignored.
+func TestJSONResponse(t *testing.T) {
+	encodeErrs, writeErrs := testutil.ToFloat64(metricResponseEncodeErrors), testutil.ToFloat64(metricResponseWriteErrors)
+	mark := suiteLog.mark()
+	w := httptest.NewRecorder()
+	w.Header().Set(middleware.RequestIDHeader, "req-nan")
+	j(w, http.StatusOK, map[string]any{"translation": "hello", "confidence": math.NaN()})
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "hello") {
+		t.Fatalf("NaN: status %d: %s", w.Code, w.Body.String())
+	}
+	checkEnvelope(t, w)
+	if !strings.Contains(w.Body.String(), `"code":"INTERNAL","message":"response could not be encoded"`) {
+		t.Fatalf("NaN: body %s", w.Body.String())
+	}
+	if logged := suiteLog.since(mark); !strings.Contains(logged, `"msg":"json response encode failed","request_id":"req-nan","status":200`) {
+		t.Fatalf("encode failure not logged:\n%s", logged)
+	}
+	w = httptest.NewRecorder()
+	j(w, http.StatusInternalServerError, map[string]any{"error": math.Inf(1)})
+	if w.Code != http.StatusInternalServerError || w.Body.String() != `{"error":{"code":"INTERNAL","message":"response could not be encoded"}}`+"\n" {
+		t.Fatalf("unencodable 500: status %d: %s", w.Code, w.Body.String())
+	}
+	if got := testutil.ToFloat64(metricResponseEncodeErrors) - encodeErrs; got != 2 {
+		t.Fatalf("%v encode errors counted, want 2", got)
+	}
+
+	big := strings.Repeat("ރ", 4<<20)
+	w = httptest.NewRecorder()
+	j(w, http.StatusOK, map[string]string{"translation": big})
+	var out map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil || out["translation"] != big || w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+		t.Fatalf("large body: %v, Content-Length %s for %d bytes", err, w.Header().Get("Content-Length"), w.Body.Len())
+	}
+
+	bw := &brokenWriter{header: http.Header{}}
+	j(bw, http.StatusOK, map[string]string{"translation": "hello"})
+	if bw.code != http.StatusOK || bw.header.Get("Content-Length") != strconv.Itoa(len(`{"translation":"hello"}`+"\n")) {
+		t.Fatalf("broken client: status %d, Content-Length %q", bw.code, bw.header.Get("Content-Length"))
+	}
+	if got := testutil.ToFloat64(metricResponseWriteErrors) - writeErrs; got != 1 {
+		t.Fatalf("%v write errors counted, want 1", got)
+	}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// translateService is the transport-neutral core behind both the HTTP handlers and the gRPC
+// server: validation and fan-out live here, so the two can't drift. Errors that are the caller's
+// fault come back as *httpError; anything else is from the translator chain.
+type translateService struct {
+	t       Translator
+	batch   batchOpts
+	detect  detectOpts
+	limits  inputLimits
+	input   InputOptions // AllowBidi comes from each request
+	segment segmentOpts
+	usage   *usageMeter
+	flags   *featureFlags
+	keys    keyFolding // what the cache keys on, for ETags
+	clock   Clock
+}
+
+// inputLimits bounds the text one call carries, per tier, in code points after normalization:
+// Thaana takes two or three bytes a letter, and zero-width characters are stripped before
+// counting. /go/languages publishes them so clients can check before sending.
+type inputLimits struct {
+	MaxChars         int `json:"max_input_chars"`
+	MaxCharsPro      int `json:"max_input_chars_pro"`
+	BatchMaxChars    int `json:"batch_max_chars"` // all items together
+	BatchMaxCharsPro int `json:"batch_max_chars_pro"`
+	NBestMax         int `json:"nbest_max"` // alternatives one translate may ask for
+}
+
+// inputTooLongError is the 413 for text over an inputLimits bound.
+type inputTooLongError struct {
+	Tier   string
+	Limit  int
+	Length int
+	Batch  bool // the batch total, not one q
+}
+
+func (e *inputTooLongError) Error() string {
+	what := "q"
+	if e.Batch {
+		what = "batch"
+	}
+	return fmt.Sprintf("%s is %d characters; the %s tier allows %d", what, e.Length, e.Tier, e.Limit)
+}
+
+// detectOpts controls source detection for requests that omit src.
+type detectOpts struct {
+	Default  langPair // used when neither src nor dst is given and detection can't decide
+	MinChars int      // Latin-script input with fewer letters falls back to Default.Src
+}
+
+// Translate validates and normalizes req, then resolves it through the translator chain.
+// The normalized q and resolved direction are what get cached and sent upstream. Each call is
+// metered as one request, and its characters count against the caller's daily quota unless
+// it has nothing to translate and comes back as it was (see translatable).
+func (s *translateService) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	s.usage.request(ctx)
+	return s.translate(withHedging(ctx), req)
+}
+
+func (s *translateService) translate(ctx context.Context, req translateReq) (translateResult, error) {
+	if herr := applyTier(ctx, &req.Extended); herr != nil {
+		return translateResult{}, herr
+	}
+	return s.resolve(ctx, req)
+}
+
+// resolve is translate with req.Extended taken as given, for callers that aren't a client:
+// cache warming picks the variant it fills.
+func (s *translateService) resolve(ctx context.Context, req translateReq) (res translateResult, err error) {
+	var n int
+	var over int64
+	stage := startStage(ctx, "input")
+	defer func() {
+		stage.end("rejected", "") // unless it got as far as a direction
+		s.usage.translated(ctx, int64(n), over, res.Cached, err)
+	}()
+	if herr := checkDigits(req.Digits); herr != nil {
+		return translateResult{}, herr
+	}
+	opts := s.input
+	opts.AllowBidi = req.AllowBidi
+	raw, err := ValidateInput(req.Q, opts)
+	if err != nil {
+		return translateResult{}, err
+	}
+	q, _ := normalizeText(raw)
+	if q == "" {
+		return translateResult{}, &httpError{http.StatusBadRequest, codeMissingQuery, "missing field 'q'"}
+	}
+	var segs []segment
+	if s.segment.Enabled && s.flags.on(flagSegments) {
+		segs = segmentText(raw) // from the raw q, to keep its line breaks
+	}
+	req.Q = q
+	req.NBest = min(max(req.NBest, 0), s.limits.NBestMax)
+	if !s.flags.on(flagNBest) {
+		req.NBest = 0
+	}
+	n = utf8.RuneCountInString(q)
+	noteInput(ctx, q, n)
+	if tier := tierFrom(ctx); n > s.limits.maxChars(tier) {
+		return translateResult{}, &inputTooLongError{Tier: tier, Limit: s.limits.maxChars(tier), Length: n}
+	}
+
+	// detected stays "" when detection fell back to the default direction, so the response
+	// doesn't claim to have detected the pair it was given.
+	detecting := strings.TrimSpace(req.Src) == ""
+	var detected string
+	var confidence float64
+	if detecting {
+		detected, confidence = s.detectSrc(q, req.Dst)
+		req.Src = detected
+	}
+	pair, err := resolvePair(req.Src, req.Dst, s.detect.Default)
+	if err != nil {
+		return translateResult{}, err
+	}
+	if err := checkPairScope(ctx, pair); err != nil {
+		return translateResult{}, err
+	}
+	req.Src, req.Dst = pair.Src, pair.Dst
+	noteProvenanceInput(ctx, q, n, pair, detecting)
+	if !translatable(q) {
+		stage.end(srcPassthrough, "")
+		n = 0 // nothing was translated, so nothing is charged
+		out := convertDigits(q, req.Digits)
+		res = translateResult{Translation: out, Src: srcPassthrough, Script: detectScript(q), Pair: pair}
+		if req.Segments {
+			res.Segments = []segmentResult{{Source: q, Translation: out, Src: srcPassthrough}}
+		}
+		if detecting {
+			res.DetectedSrc, res.DetectionConfidence = detected, confidence
+		}
+		return res, nil
+	}
+	stage.end("normalized", "")
+	release, over, err := s.usage.reserve(ctx, int64(n))
+	if err != nil {
+		return translateResult{}, err
+	}
+	switch {
+	case stubForced(ctx):
+		res, err = stubTranslator{}.Translate(ctx, req) // past the whole chain, so nothing is stored
+	case len(segs) > 1:
+		res, err = s.translateSegments(ctx, req, segs)
+	default:
+		res, err = s.t.Translate(ctx, req)
+		if err == nil && req.Segments {
+			res.Segments = []segmentResult{{Source: q, Translation: res.Translation, Src: res.Src, Cached: res.Cached}}
+		}
+	}
+	if err != nil {
+		release()
+		return res, err
+	}
+	res.Script = detectScript(q)
+	res.Pair = pair
+	if len(res.Alternatives) > req.NBest {
+		res.Alternatives = res.Alternatives[:req.NBest]
+	}
+	if detecting {
+		res.DetectedSrc, res.DetectionConfidence = detected, confidence
+	}
+	return res, nil
+}
+
+// detectSrc runs DetectLanguage on q. Latin-script input too short to call, or a guess that
+// can't pair with an explicit dst, returns "" with confidence 0 so resolvePair falls back to the
+// default direction. Thaana is unambiguous at any length.
+func (s *translateService) detectSrc(q, dst string) (string, float64) {
+	lang, confidence := DetectLanguage(q)
+	if lang == "" || (lang != langDhivehi && letterCount(q) < s.detect.MinChars) {
+		return "", 0
+	}
+	if dst = strings.ToLower(strings.TrimSpace(dst)); dst != "" && !pairSupported(langPair{lang, dst}) {
+		return "", 0
+	}
+	return lang, confidence
+}
+
+// applyTier turns extended on for pro callers and rejects it for everyone else.
+func applyTier(ctx context.Context, extended *bool) *httpError {
+	if tierFrom(ctx) == tierPro {
+		*extended = true
+		return nil
+	}
+	if *extended {
+		return upgradeRequired("extended translations")
+	}
+	return nil
+}
+
+// upgradeRequired is the 402 a free key gets for a pro-only option.
+func upgradeRequired(what string) *httpError {
+	return &httpError{http.StatusPaymentRequired, codeUpgradeRequired, what + " require a pro API key; upgrade at https://dhkalign.com/pricing"}
+}
+
+// Batch validates req against the batch limits and translates every item. Per-item failures are
+// reported in the results; only request-level problems return an error.
+func (s *translateService) Batch(ctx context.Context, req batchReq) (map[string]batchResult, error) {
+	if herr := applyTier(ctx, &req.Extended); herr != nil {
+		return nil, herr
+	}
+	max := s.batch.MaxItems
+	if tierFrom(ctx) == tierPro {
+		max = s.batch.MaxItemsPro
+	} else if n := len(req.Items); n > max && n <= s.batch.MaxItemsPro {
+		return nil, upgradeRequired(fmt.Sprintf("batches over %d items", max))
+	}
+	if herr := validateBatch(req, max); herr != nil {
+		return nil, herr
+	}
+	if err := s.limits.checkBatch(tierFrom(ctx), req.Items); err != nil {
+		return nil, err
+	}
+	s.usage.request(ctx)
+	if err := s.usage.exhausted(ctx); err != nil {
+		return nil, err
+	}
+	return translateBatch(ctx, batchItems{s}, req, s.batch.Workers), nil
+}
+
+func (l inputLimits) maxChars(tier string) int {
+	if tier == tierPro {
+		return l.MaxCharsPro
+	}
+	return l.MaxChars
+}
+
+// checkBatch holds the items' normalized text, together, to the tier's batch bound. Invalid
+// items count for nothing here; they fail on their own.
+func (l inputLimits) checkBatch(tier string, items []batchItem) error {
+	limit := l.BatchMaxChars
+	if tier == tierPro {
+		limit = l.BatchMaxCharsPro
+	}
+	total := 0
+	for _, it := range items {
+		q, _ := normalizeText(it.Q)
+		total += utf8.RuneCountInString(q)
+	}
+	if total > limit {
+		return &inputTooLongError{Tier: tier, Limit: limit, Length: total, Batch: true}
+	}
+	return nil
+}
+
+// batchItems translates batch items through the service without metering each as a request;
+// the batch itself counted once.
+type batchItems struct{ s *translateService }
+
+func (b batchItems) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	return b.s.translate(withCacheRoute(ctx, cacheRouteBatch), req)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// sessionGroup tracks long-lived connections across shutdown. ctx is canceled by stop (hooked to
+// http.Server.RegisterOnShutdown) so SSE streams and WebSocket sessions can say goodbye and
+// return. Shutdown waits for the streams but not for hijacked WebSockets, so those register with
+// enter and Server.Shutdown waits for them after http.Server.Shutdown returns.
+type sessionGroup struct {
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func newSessionGroup() *sessionGroup {
+	ctx, stop := context.WithCancel(context.Background())
+	return &sessionGroup{ctx: ctx, stop: stop}
+}
+
+// enter registers a session; call the returned func when it ends. Call it before hijacking so
+// it happens while Shutdown is still tracking the connection.
+func (g *sessionGroup) enter() func() {
+	g.wg.Add(1)
+	return g.wg.Done
+}
+
+// wait blocks until every registered session has ended, or ctx is done.
+func (g *sessionGroup) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadShedder caps concurrent translate requests. A request that finds every slot busy joins a
+// FIFO queue of at most maxQueue and waits up to wait for a slot, or less if its own deadline
+// comes sooner; only a full queue, or a wait that runs out, is refused with a 503 before the
+// request reaches the upstream. The 503's Retry-After is how long the queue ahead would take to
+// drain at the rate slots have been freeing up, so a spike slows clients down instead of
+// sending them straight back.
+type loadShedder struct {
+	limit    int
+	maxQueue int
+	wait     time.Duration
+	clock    Clock
+
+	mu       sync.Mutex
+	inFlight int
+	queue    list.List // of chan struct{}, closed when a freed slot is handed to that waiter
+
+	shed  atomic.Uint64
+	drain drainRate
+}
+
+func newLoadShedder(limit, maxQueue int, wait time.Duration, clk Clock) *loadShedder {
+	return &loadShedder{limit: limit, maxQueue: maxQueue, wait: wait, clock: clk}
+}
+
+// Queue outcomes, the outcome label on dhk_go_translate_queue_wait_seconds.
+const (
+	queueAdmitted = "admitted"
+	queueTimedOut = "timed_out" // QUEUE_WAIT_MAX or the request's deadline ran out first
+	queueCanceled = "canceled"  // the client went away
+)
+
+// acquire takes a slot, reporting false if none freed up in time. The caller releases it.
+func (l *loadShedder) acquire(r *http.Request) bool {
+	l.mu.Lock()
+	if l.inFlight < l.limit {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if l.queue.Len() >= l.maxQueue {
+		l.mu.Unlock()
+		return false
+	}
+	ready := make(chan struct{})
+	waiter := l.queue.PushBack(ready)
+	l.mu.Unlock()
+
+	metricShedQueued.Inc()
+	start := time.Now()
+	outcome := queueTimedOut
+	defer func() {
+		metricShedQueued.Dec()
+		metricShedWait.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}()
+	wait := l.wait
+	if dl, ok := r.Context().Deadline(); ok {
+		wait = min(wait, dl.Sub(l.clock.Now()))
+	}
+	if wait > 0 {
+		t := l.clock.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-ready:
+			outcome = queueAdmitted
+			return true
+		case <-t.C():
+		case <-r.Context().Done():
+			if clientGone(r) {
+				outcome = queueCanceled
+			}
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready: // a slot was handed over as the wait ran out; it goes to the next in line
+		l.handOff()
+	default:
+		l.queue.Remove(waiter)
+	}
+	return false
+}
+
+func (l *loadShedder) release() {
+	l.mu.Lock()
+	l.handOff()
+	l.mu.Unlock()
+	l.drain.freed(l.clock.Now())
+}
+
+// handOff passes a freed slot to the longest waiting request, or frees it when none waits. l.mu
+// is held.
+func (l *loadShedder) handOff() {
+	if front := l.queue.Front(); front != nil {
+		close(l.queue.Remove(front).(chan struct{}))
+		return
+	}
+	l.inFlight--
+}
+
+// retryAfter estimates when a refused request would find a slot: the queue ahead of it, drained
+// at the recent rate. With nothing freed lately it falls back to the queue wait.
+func (l *loadShedder) retryAfter(ctx context.Context) int {
+	if perSec := l.drain.perSecond(l.clock.Now()); perSec > 0 {
+		l.mu.Lock()
+		ahead := float64(l.queue.Len() + 1)
+		l.mu.Unlock()
+		return retryAfterSeconds(ctx, time.Duration(ahead/perSec*float64(time.Second)))
+	}
+	return retryAfterSeconds(ctx, l.wait)
+}
+
+func (l *loadShedder) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			if clientGone(r) {
+				return
+			}
+			l.shed.Add(1)
+			metricShed.Inc()
+			retry := l.retryAfter(r.Context())
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			writeError(w, http.StatusServiceUnavailable, codeOverloaded, "server overloaded", "retry_after", retry)
+			return
+		}
+		metricShedInFlight.Inc()
+		defer func() {
+			metricShedInFlight.Dec()
+			l.release()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stats is the concurrency block of /go/stats and of /go/health?verbose=1. saturated is every
+// slot taken, with requests queued behind them.
+func (l *loadShedder) stats() map[string]any {
+	l.mu.Lock()
+	inFlight, queued := l.inFlight, l.queue.Len()
+	l.mu.Unlock()
+	return map[string]any{
+		"limit":          l.limit,
+		"in_flight":      inFlight,
+		"queued":         queued,
+		"max_queue":      l.maxQueue,
+		"queue_wait_max": l.wait.String(),
+		"saturated":      queued > 0,
+		"drain_per_sec":  math.Round(l.drain.perSecond(l.clock.Now())*10) / 10,
+		"shed":           l.shed.Load(),
+	}
+}
+
+// drainRateSlots is how many seconds the drain rate is averaged over.
+const drainRateSlots = 10
+
+// drainRate counts freed slots per second over the last few seconds, one slot per second,
+// reused as the seconds come round.
+type drainRate struct {
+	mu    sync.Mutex
+	slots [drainRateSlots]struct{ second, freed int64 }
+}
+
+func (d *drainRate) freed(now time.Time) {
+	sec := now.Unix()
+	d.mu.Lock()
+	s := &d.slots[sec%drainRateSlots]
+	if s.second != sec {
+		s.second, s.freed = sec, 0
+	}
+	s.freed++
+	d.mu.Unlock()
+}
+
+// perSecond averages the complete seconds in the window that freed anything; the current one is
+// still filling, and a second with nothing freed was idle, not slow, so neither drags it down.
+func (d *drainRate) perSecond(now time.Time) float64 {
+	sec := now.Unix()
+	var freed, seconds int64
+	d.mu.Lock()
+	for _, s := range d.slots {
+		if s.second < sec && s.second >= sec-drainRateSlots+1 {
+			freed += s.freed
+			seconds++
+		}
+	}
+	d.mu.Unlock()
+	if seconds == 0 {
+		return 0
+	}
+	return float64(freed) / float64(seconds)
+}
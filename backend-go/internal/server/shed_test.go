@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadShedderQueue(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	l := newLoadShedder(1, 1, time.Second, clk)
+	r := httptest.NewRequest("GET", "/go/translate", nil)
+	if !l.acquire(r) {
+		t.Fatal("free slot refused")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	queue := func() chan bool {
+		got := make(chan bool, 1)
+		go func() { got <- l.acquire(r) }()
+		if err := clk.BlockUntil(ctx, 1); err != nil {
+			t.Fatal("request not queued")
+		}
+		return got
+	}
+
+	queued := queue()
+	if l.acquire(r) {
+		t.Fatal("admitted past a full queue")
+	}
+	clk.Advance(time.Second)
+	if <-queued {
+		t.Fatal("admitted after QUEUE_WAIT_MAX ran out")
+	}
+
+	queued = queue()
+	l.release()
+	if !<-queued {
+		t.Fatal("queued request not admitted when the slot freed")
+	}
+	if got := l.stats(); got["in_flight"] != 1 || got["queued"] != 0 || got["shed"] != uint64(0) {
+		t.Fatalf("stats %v", got)
+	}
+}
+
+// TestLoadShedderOrder queues requests behind a held slot and frees it one at a time: they are
+// admitted in the order they arrived.
+func TestLoadShedderOrder(t *testing.T) {
+	const waiting = 4
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	l := newLoadShedder(1, waiting, time.Minute, clk)
+	r := httptest.NewRequest("GET", "/go/translate", nil)
+	l.acquire(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	admitted := make(chan int, waiting)
+	for i := range waiting {
+		go func() {
+			if l.acquire(r) {
+				admitted <- i
+			}
+		}()
+		// Each is in the queue by the time its wait is armed, so the next arrives after it.
+		if err := clk.BlockUntil(ctx, i+1); err != nil {
+			t.Fatalf("request %d not queued", i)
+		}
+	}
+	for want := range waiting {
+		l.release()
+		if got := <-admitted; got != want {
+			t.Fatalf("request %d admitted, want %d", got, want)
+		}
+	}
+}
+
+// TestLoadShedderRetryAfter fills the queue behind a held slot and checks a refused request's
+// Retry-After: the queue wait while nothing has drained, then the queue ahead at the drain rate.
+func TestLoadShedderRetryAfter(t *testing.T) {
+	const maxQueue = 3
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	l := newLoadShedder(1, maxQueue, 10*time.Second, clk)
+	h := l.middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	r := httptest.NewRequest("GET", "/go/translate", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fill := func() chan bool {
+		got := make(chan bool, maxQueue)
+		for range maxQueue {
+			go func() { got <- l.acquire(r) }()
+		}
+		if err := clk.BlockUntil(ctx, maxQueue); err != nil {
+			t.Fatal("queue not filled")
+		}
+		return got
+	}
+	refused := func(want string) {
+		t.Helper()
+		w := serve(h, "GET", "/go/translate", "")
+		var res struct {
+			Error struct {
+				Code       errorCode `json:"code"`
+				RetryAfter int       `json:"retry_after"`
+			} `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		if w.Code != http.StatusServiceUnavailable || res.Error.Code != codeOverloaded {
+			t.Fatalf("status %d, want a 503: %s", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Retry-After"); got != want || strconv.Itoa(res.Error.RetryAfter) != want {
+			t.Fatalf("Retry-After %q, retry_after %d, want %s", got, res.Error.RetryAfter, want)
+		}
+	}
+
+	l.acquire(r)
+	queued := fill()
+	refused("10") // nothing freed yet: the queue wait
+	for range maxQueue {
+		l.release()
+		if !<-queued {
+			t.Fatal("queued request not admitted when a slot freed")
+		}
+	}
+
+	// Three slots freed in the last second; four ahead of the next refused request take 1.33s.
+	clk.Advance(time.Second)
+	queued = fill()
+	refused("2")
+	if got := l.stats()["drain_per_sec"]; got != 3.0 {
+		t.Fatalf("drain_per_sec %v, want 3", got)
+	}
+	clk.Advance(10 * time.Second)
+	for range maxQueue {
+		if <-queued {
+			t.Fatal("admitted after QUEUE_WAIT_MAX ran out")
+		}
+	}
+}
+
+func TestLoadShedderDeadline(t *testing.T) {
+	clk := systemClock{}
+	l := newLoadShedder(1, 1, time.Minute, clk)
+	r := httptest.NewRequest("GET", "/go/translate", nil)
+	l.acquire(r)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if l.acquire(r.WithContext(ctx)) {
+		t.Fatal("admitted with every slot held")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("waited %v for a request with 20ms left", waited)
+	}
+}
+
+func TestDrainRate(t *testing.T) {
+	base := time.Unix(1_772_366_400, 0)
+	tests := []struct {
+		name  string
+		freed []int // seconds after base
+		at    int
+		want  float64
+	}{
+		{"nothing freed", nil, 5, 0},
+		{"current second left out", []int{5, 5}, 5, 0},
+		{"one second", []int{4, 4, 4}, 5, 3},
+		{"idle seconds left out", []int{1, 1, 4, 4, 4, 4}, 5, 3},
+		{"outside the window", []int{0, 0, 0}, 12, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d drainRate
+			for _, s := range tt.freed {
+				d.freed(base.Add(time.Duration(s) * time.Second))
+			}
+			if got := d.perSecond(base.Add(time.Duration(tt.at) * time.Second)); got != tt.want {
+				t.Fatalf("perSecond %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOverloadShedsFast holds two requests in the upstream and sends a burst behind them: two
+// wait out QUEUE_WAIT_MAX in the queue, the rest find it full, and every one is refused quickly
+// rather than piling up, while health checks bypass the limiter.
+func TestOverloadShedsFast(t *testing.T) {
+	up := &heldUpstream{release: make(chan struct{}), entered: make(chan struct{}, 2)}
+	s := newTestServer(t, map[string]string{
+		"MAX_CONCURRENCY":     "2",
+		"CONCURRENCY_QUEUE":   "2",
+		"QUEUE_WAIT_MAX":      "50ms",
+		"KEY_CONCURRENCY":     "0",
+		"KEY_CONCURRENCY_PRO": "0",
+		"RATE_LIMIT_BURST":    "100",
+	}, Deps{Upstream: up})
+	h := s.Handler()
+	get := func(n int) *httptest.ResponseRecorder {
+		return serve(h, "GET", "/go/translate?q=phrase"+strconv.Itoa(n), "", "X-API-Key", testProKey)
+	}
+
+	held := make(chan int, 2)
+	for n := range 2 {
+		go func() { held <- get(n).Code }()
+	}
+	for range 2 {
+		select {
+		case <-up.entered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("requests never reached the upstream")
+		}
+	}
+
+	const burst = 18
+	start := time.Now()
+	codes := make([]*httptest.ResponseRecorder, burst)
+	var wg sync.WaitGroup
+	for i := range burst {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = get(100 + i)
+		}()
+	}
+	wg.Wait()
+	if took := time.Since(start); took > 2*time.Second {
+		t.Fatalf("burst took %v to be refused", took)
+	}
+	for i, w := range codes {
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("request %d: status %d, Retry-After %q; want a 503", i, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+	if got := up.calls.Load(); got != 2 {
+		t.Fatalf("%d upstream calls, want only the 2 held", got)
+	}
+	if w := serve(h, "GET", "/go/health", ""); w.Code != http.StatusOK {
+		t.Fatalf("/go/health %d while saturated", w.Code)
+	}
+	w := serve(h, "GET", "/go/stats", "", "Authorization", "Bearer "+testAdmin)
+	var stats struct {
+		Concurrency struct {
+			InFlight int `json:"in_flight"`
+			Shed     int `json:"shed"`
+		} `json:"concurrency"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if c := stats.Concurrency; c.InFlight != 2 || c.Shed != burst {
+		t.Fatalf("stats concurrency %+v, want 2 in flight and %d shed", c, burst)
+	}
+
+	close(up.release)
+	for range 2 {
+		if code := <-held; code != http.StatusOK {
+			t.Fatalf("held request: status %d", code)
+		}
+	}
+}
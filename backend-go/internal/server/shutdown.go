@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// Shutdown is a list of named hooks, each with its own deadline, run one after another in
+// phases: the listeners, which drain the requests; the background work the requests left
+// behind; the stores' write queues; and last the stores themselves. A hook still running at its
+// deadline is left behind and reported, and the next one starts, so one stuck component can't
+// keep the others from stopping. What happened goes into a ShutdownReport, logged as a single
+// "shutdown report" line, whose exit code tells Fly and systemd a clean stop from a drain
+// that timed out or a start that failed.
+
+// Exit codes, for main and the orchestrator watching it.
+const (
+	ExitClean         = 0 // every hook finished in time
+	ExitFailure       = 1 // a fatal error while serving, or a hook failed
+	ExitConfig        = 2 // the configuration is invalid
+	ExitDrainTimeout  = 3 // a hook was still running at its deadline
+	ExitStartupFailed = 4 // the server could not be built or bound
+)
+
+// Shutdown phases, in the order they run.
+const (
+	phaseListeners = iota // the HTTP, health and gRPC servers; requests drain here
+	phaseWork             // sessions, jobs and the senders the requests feed
+	phaseWrites           // the stores' write queues
+	phaseClose            // the stores
+)
+
+// shutdownHook is one component's part of Shutdown. A zero timeout is whatever is left of
+// Shutdown's context; any other runs detached from it, for its own timeout, as the write
+// queues do with WRITE_DRAIN_TIMEOUT.
+type shutdownHook struct {
+	name    string
+	phase   int
+	timeout time.Duration
+	run     func(context.Context) error
+	writes  *writeCounts // a write queue's, for the report
+}
+
+// closeTimeout bounds closing the stores, which takes no context.
+const closeTimeout = 5 * time.Second
+
+// shutdownGrace is what a hook without a timeout gets when it starts after Shutdown's context
+// has ended, so one with nothing left to do isn't reported as timed out along with the one that
+// used the time up.
+const shutdownGrace = 100 * time.Millisecond
+
+// onShutdown registers h, for build; a hook runs after those of earlier phases and, within its
+// phase, in the order registered.
+func (s *Server) onShutdown(h shutdownHook) { s.hooks = append(s.hooks, h) }
+
+// StopCause is why the process is stopping: a signal, or the error that came on Err.
+type StopCause struct {
+	Signal os.Signal
+	Err    error
+}
+
+// ShutdownReport is what Stop did.
+type ShutdownReport struct {
+	Reason     string                    `json:"reason"` // signal, fatal, startup_failed or requested
+	Signal     string                    `json:"signal,omitempty"`
+	Error      string                    `json:"error,omitempty"` // the fatal error
+	ExitCode   int                       `json:"exit_code"`
+	DurationMS float64                   `json:"duration_ms"`
+	Requests   shutdownRequests          `json:"requests"`
+	Writes     map[string]shutdownWrites `json:"writes,omitempty"` // by store
+	Hooks      []shutdownHookReport      `json:"hooks"`
+	TimedOut   []string                  `json:"timed_out,omitempty"` // hooks still running at their deadline
+	err        error
+}
+
+// shutdownRequests is the requests in flight when the listeners closed, and what became of them.
+type shutdownRequests struct {
+	InFlight int `json:"in_flight"`
+	Drained  int `json:"drained"`
+	Aborted  int `json:"aborted"` // cut off when the HTTP server's deadline passed
+}
+
+// shutdownWrites is a write queue's writes applied and dropped while shutting down.
+type shutdownWrites struct {
+	Flushed int64 `json:"flushed"`
+	Dropped int64 `json:"dropped"`
+}
+
+type shutdownHookReport struct {
+	Name       string  `json:"name"`
+	Outcome    string  `json:"outcome"` // ok, failed or timed_out
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Hook outcomes.
+const (
+	hookOK       = "ok"
+	hookFailed   = "failed"
+	hookTimedOut = "timed_out"
+)
+
+// Err is every hook's error joined, nil for a clean stop.
+func (r *ShutdownReport) Err() error { return r.err }
+
+// Stop shuts the server down as Shutdown does, within ctx for the hooks without a timeout of
+// their own, and logs and returns the report.
+func (s *Server) Stop(ctx context.Context, cause StopCause) *ShutdownReport {
+	start := time.Now()
+	rep := &ShutdownReport{Reason: "requested"}
+	switch {
+	case cause.Err != nil && !s.startup.done():
+		rep.Reason, rep.Error = "startup_failed", cause.Err.Error()
+	case cause.Err != nil:
+		rep.Reason, rep.Error = "fatal", cause.Err.Error()
+	case cause.Signal != nil:
+		rep.Reason, rep.Signal = "signal", cause.Signal.String()
+	}
+	if err := sdNotify("STOPPING=1"); err != nil {
+		slog.Warn("systemd notify failed", "err", err)
+	}
+	hooks := []shutdownHook{{name: "http", phase: phaseListeners, run: s.shutdownHTTP}}
+	if s.redirect != nil {
+		hooks = append(hooks, shutdownHook{name: "http_redirect", phase: phaseListeners, run: s.redirect.Shutdown})
+	}
+	if s.healthSrv != nil {
+		hooks = append(hooks, shutdownHook{name: "health_port", phase: phaseListeners, run: s.healthSrv.Shutdown})
+	}
+	// A server still booting answers only health checks, and whatever the build has opened so
+	// far goes with the process: only the listeners stop.
+	if s.startup.done() {
+		s.ready.drain()
+		<-s.clock.After(s.cfg.ShutdownDelay)
+		if s.gsrv != nil {
+			hooks = append(hooks, shutdownHook{name: "grpc", phase: phaseListeners, run: func(ctx context.Context) error { return stopGRPC(ctx, s.gsrv) }})
+		}
+		hooks = append(hooks, s.hooks...)
+		hooks = append(hooks, shutdownHook{name: "stores", phase: phaseClose, timeout: closeTimeout, run: func(context.Context) error {
+			s.close()
+			return nil
+		}})
+	}
+	slices.SortStableFunc(hooks, func(a, b shutdownHook) int { return a.phase - b.phase })
+
+	rep.Requests.InFlight = s.inflight.count()
+	before := map[string]shutdownWrites{}
+	for _, h := range hooks {
+		if h.writes != nil {
+			before[h.name] = h.writes.load()
+		}
+	}
+	var errs []error
+	for _, h := range hooks {
+		hr, err := runShutdownHook(ctx, h)
+		rep.Hooks = append(rep.Hooks, hr)
+		if hr.Outcome == hookTimedOut {
+			rep.TimedOut = append(rep.TimedOut, h.name)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if h.name == "http" {
+			rep.Requests.Aborted = s.inflight.count()
+			rep.Requests.Drained = max(0, rep.Requests.InFlight-rep.Requests.Aborted)
+		}
+	}
+	for _, h := range hooks {
+		if h.writes == nil {
+			continue
+		}
+		if rep.Writes == nil {
+			rep.Writes = map[string]shutdownWrites{}
+		}
+		now, was := h.writes.load(), before[h.name]
+		rep.Writes[h.writes.store] = shutdownWrites{Flushed: now.Flushed - was.Flushed, Dropped: now.Dropped - was.Dropped}
+	}
+	rep.err = errors.Join(errs...)
+	switch {
+	case rep.Reason == "startup_failed":
+		rep.ExitCode = ExitStartupFailed
+	case rep.Reason == "fatal":
+		rep.ExitCode = ExitFailure
+	case len(rep.TimedOut) > 0:
+		rep.ExitCode = ExitDrainTimeout
+	case rep.err != nil:
+		rep.ExitCode = ExitFailure
+	}
+	rep.DurationMS = ms(time.Since(start))
+	level := slog.LevelInfo
+	if rep.ExitCode != ExitClean {
+		level = slog.LevelError
+	}
+	slog.Log(context.Background(), level, "shutdown report", "report", rep)
+	return rep
+}
+
+// shutdownHTTP drains the HTTP server, and cuts off the requests still running when ctx ends.
+// The sessions, hijacked and so beyond Shutdown, are told to stop as it starts.
+func (s *Server) shutdownHTTP(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		s.srv.Close()
+	}
+	return err
+}
+
+// runShutdownHook runs h until its deadline. A hook still running then is left to finish on
+// its own, or with the process.
+func runShutdownHook(ctx context.Context, h shutdownHook) (shutdownHookReport, error) {
+	hctx, cancel := ctx, context.CancelFunc(func() {})
+	switch {
+	case h.timeout > 0:
+		hctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), h.timeout)
+	case ctx.Err() != nil:
+		hctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), shutdownGrace)
+	}
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- h.run(hctx) }()
+	var err error
+	hr := shutdownHookReport{Name: h.name, Outcome: hookOK}
+	select {
+	case err = <-done:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			hr.Outcome, hr.Error = hookFailed, err.Error()
+			if hctx.Err() != nil {
+				hr.Outcome = hookTimedOut
+			}
+		} else {
+			err = nil
+		}
+	case <-hctx.Done():
+		err = hctx.Err()
+		hr.Outcome, hr.Error = hookTimedOut, "still running at its deadline"
+	}
+	hr.DurationMS = ms(time.Since(start))
+	if err != nil {
+		slog.Warn("shutdown hook did not finish cleanly", "hook", h.name, "outcome", hr.Outcome, "err", err)
+		err = fmt.Errorf("%s: %w", h.name, err)
+	}
+	return hr, err
+}
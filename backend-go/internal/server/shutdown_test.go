@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// closeListener says on closed when the listener it wraps is closed.
+type closeListener struct {
+	net.Listener
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (l *closeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// gateUpstream holds every translation until release is closed, saying on started when one
+// arrives.
+type gateUpstream struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (u *gateUpstream) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	u.started <- struct{}{}
+	select {
+	case <-u.release:
+	case <-ctx.Done():
+		return translateResult{}, ctx.Err()
+	}
+	return translateResult{Translation: "EN(" + req.Q + ")", Src: "upstream"}, nil
+}
+
+// TestSIGTERMDrainsInFlight sends the process SIGTERM with a slow request in flight and stops
+// the server on it as main does: readiness fails at once while health stays up, the listener
+// then closes, and the request still completes before Stop returns.
+func TestSIGTERMDrainsInFlight(t *testing.T) {
+	up := &gateUpstream{started: make(chan struct{}, 1), release: make(chan struct{})}
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := newTestServer(t, map[string]string{"SHUTDOWN_DELAY": "300ms"}, Deps{Upstream: up, Clock: clk})
+	t.Cleanup(func() { s.cfg.ShutdownDelay = 0 }) // the cleanup's Stop would wait on clk forever
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &closeListener{Listener: inner, closed: make(chan struct{})}
+	s.serveHTTP(ln)
+	base := "http://" + ln.Addr().String()
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", base+"/go/translate?q=slow", nil)
+		req.Header.Set("X-API-Key", testProKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		slow <- result{status: resp.StatusCode, body: string(b)}
+	}()
+	<-up.started
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	sig := <-signals
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	armed := clk.Waiters()
+	stopped := make(chan *ShutdownReport, 1)
+	go func() { stopped <- s.Stop(ctx, StopCause{Signal: sig}) }()
+
+	// Within SHUTDOWN_DELAY the listener is still open, and only readiness has changed.
+	if err := clk.BlockUntil(ctx, armed+1); err != nil {
+		t.Fatal("Stop never waited out SHUTDOWN_DELAY")
+	}
+	h := s.Handler()
+	if w := serve(h, "GET", "/go/ready", ""); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("/go/ready %d after SIGTERM, want 503", w.Code)
+	}
+	if w := serve(h, "GET", "/go/health", ""); w.Code != http.StatusOK {
+		t.Fatalf("/go/health %d while draining, want 200", w.Code)
+	}
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("listener closed within SHUTDOWN_DELAY: %v", err)
+	}
+	c.Close()
+	// Once the delay is up the listener closes to new connections, with the request still held.
+	clk.Advance(300 * time.Millisecond)
+	select {
+	case <-ln.closed:
+	case <-ctx.Done():
+		t.Fatal("listener still open after SHUTDOWN_DELAY")
+	}
+	if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		c.Close()
+		t.Fatal("listener still accepting after SHUTDOWN_DELAY")
+	}
+	select {
+	case res := <-slow:
+		t.Fatalf("in-flight request finished before it was released: %+v", res)
+	default:
+	}
+	close(up.release)
+
+	res := <-slow
+	if res.err != nil || res.status != http.StatusOK {
+		t.Fatalf("in-flight request: status %d, err %v: %s", res.status, res.err, res.body)
+	}
+	rep := <-stopped
+	if rep.ExitCode != ExitClean || rep.Signal != syscall.SIGTERM.String() || rep.Requests.Drained != 1 {
+		t.Fatalf("report: exit %d, signal %q, drained %d", rep.ExitCode, rep.Signal, rep.Requests.Drained)
+	}
+}
+
+// hangingHook is a hook's run that ignores its context and blocks until release is closed.
+func hangingHook(release <-chan struct{}) func(context.Context) error {
+	return func(context.Context) error {
+		<-release
+		return nil
+	}
+}
+
+func TestRunShutdownHook(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	tests := []struct {
+		name    string
+		ended   bool // Shutdown's context has ended before the hook starts
+		timeout time.Duration
+		run     func(context.Context) error
+		outcome string
+		err     string // in the error returned; "" is none
+	}{
+		{"ok", false, 0, func(context.Context) error { return nil }, hookOK, ""},
+		{"failed", false, 0, func(context.Context) error { return errors.New("boom") }, hookFailed, "failing: boom"},
+		{"server already closed", false, 0, func(context.Context) error { return http.ErrServerClosed }, hookOK, ""},
+		{"hangs past its timeout", false, 20 * time.Millisecond, hangingHook(release), hookTimedOut, "deadline exceeded"},
+		{"gives up at its deadline", false, 20 * time.Millisecond, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, hookTimedOut, "deadline exceeded"},
+		{"own timeout after the context ended", true, time.Second, func(context.Context) error { return nil }, hookOK, ""},
+		{"within the grace after the context ended", true, 0, func(context.Context) error { return nil }, hookOK, ""},
+		{"hangs past the grace", true, 0, hangingHook(release), hookTimedOut, "deadline exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if tt.ended {
+				cancel()
+			}
+			start := time.Now()
+			hr, err := runShutdownHook(ctx, shutdownHook{name: "failing", timeout: tt.timeout, run: tt.run})
+			if took := time.Since(start); took > time.Second+tt.timeout {
+				t.Fatalf("took %v", took)
+			}
+			if hr.Name != "failing" || hr.Outcome != tt.outcome {
+				t.Fatalf("report %+v, want outcome %s", hr, tt.outcome)
+			}
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("err %v, want %q", err, tt.err)
+			}
+			if (hr.Error == "") != (tt.outcome == hookOK) {
+				t.Fatalf("report error %q for outcome %s", hr.Error, hr.Outcome)
+			}
+		})
+	}
+}
+
+// TestStopReport stops servers with a hook that hangs, one that fails and the causes main
+// passes, and checks the report says which hook held things up, that the hooks after it still
+// ran, and the exit code the orchestrator sees.
+func TestStopReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		hook     func(release <-chan struct{}) func(context.Context) error // nil registers none
+		cause    StopCause
+		reason   string
+		signal   string
+		exit     int
+		timedOut []string
+	}{
+		{"clean", nil, StopCause{}, "requested", "", ExitClean, nil},
+		{"signal", nil, StopCause{Signal: syscall.SIGTERM}, "signal", syscall.SIGTERM.String(), ExitClean, nil},
+		{"hanging hook", hangingHook, StopCause{Signal: syscall.SIGTERM}, "signal", syscall.SIGTERM.String(), ExitDrainTimeout, []string{"stuck"}},
+		{"failing hook", func(<-chan struct{}) func(context.Context) error {
+			return func(context.Context) error { return errors.New("flush refused") }
+		}, StopCause{}, "requested", "", ExitFailure, nil},
+		{"fatal error", nil, StopCause{Err: errors.New("listener died")}, "fatal", "", ExitFailure, nil},
+		{"fatal error and a hanging hook", hangingHook, StopCause{Err: errors.New("listener died")}, "fatal", "", ExitFailure, []string{"stuck"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := newTestServer(t, map[string]string{"TM_DB_PATH": filepath.Join(dir, "tm.db"), "CACHE_DB_PATH": filepath.Join(dir, "cache.db")}, Deps{})
+			release := make(chan struct{})
+			t.Cleanup(func() { close(release) }) // before newTestServer's Stop, which runs the hook again
+			if tt.hook != nil {
+				s.onShutdown(shutdownHook{name: "stuck", phase: phaseWork, timeout: 50 * time.Millisecond, run: tt.hook(release)})
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			start := time.Now()
+			rep := s.Stop(ctx, tt.cause)
+			if took := time.Since(start); took > 3*time.Second {
+				t.Fatalf("Stop took %v with one hook stuck", took)
+			}
+			if rep.Reason != tt.reason || rep.Signal != tt.signal || rep.ExitCode != tt.exit {
+				t.Fatalf("reason %q, signal %q, exit %d; want %q, %q, %d", rep.Reason, rep.Signal, rep.ExitCode, tt.reason, tt.signal, tt.exit)
+			}
+			if tt.cause.Err != nil && rep.Error != tt.cause.Err.Error() {
+				t.Fatalf("error %q", rep.Error)
+			}
+			if strings.Join(rep.TimedOut, ",") != strings.Join(tt.timedOut, ",") {
+				t.Fatalf("timed out %q, want %q", rep.TimedOut, tt.timedOut)
+			}
+			if (rep.Err() != nil) != (tt.hook != nil) {
+				t.Fatalf("Err %v", rep.Err())
+			}
+
+			// Every hook ran, in phase order, and only the registered one didn't finish.
+			var names []string
+			outcomes := map[string]string{}
+			for _, hr := range rep.Hooks {
+				names = append(names, hr.Name)
+				outcomes[hr.Name] = hr.Outcome
+			}
+			order := func(name string) int {
+				for i, n := range names {
+					if n == name {
+						return i
+					}
+				}
+				t.Fatalf("no %s hook in %q", name, names)
+				return -1
+			}
+			if !(order("http") < order("sessions") && order("sessions") < order("tm_writes") && order("tm_writes") < order("stores")) || names[len(names)-1] != "stores" {
+				t.Fatalf("hooks ran in the order %q", names)
+			}
+			for name, outcome := range outcomes {
+				want := hookOK
+				if name == "stuck" {
+					want = map[bool]string{true: hookTimedOut, false: hookFailed}[len(tt.timedOut) > 0]
+				}
+				if outcome != want {
+					t.Fatalf("%s: outcome %s, want %s", name, outcome, want)
+				}
+			}
+			if tt.hook != nil && order("stuck") > order("tm_writes") {
+				t.Fatalf("work hook ran after the write queues: %q", names)
+			}
+			for _, store := range []string{"tm", "cache_db"} {
+				if w, ok := rep.Writes[store]; !ok || w.Dropped != 0 {
+					t.Fatalf("writes %+v, want %s with none dropped", rep.Writes, store)
+				}
+			}
+		})
+	}
+
+	t.Run("startup failed", func(t *testing.T) {
+		cfg, err := LoadConfig(func(k string) string { return map[string]string{"ENV": "development"}[k] })
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := newServer(cfg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rep := s.Stop(context.Background(), StopCause{Err: errors.New("listen failed")})
+		if rep.Reason != "startup_failed" || rep.ExitCode != ExitStartupFailed || len(rep.Hooks) != 1 || rep.Hooks[0].Name != "http" {
+			t.Fatalf("report %+v", rep)
+		}
+	})
+}
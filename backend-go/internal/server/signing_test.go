@@ -0,0 +1,128 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestVerifyOriginResponse(t *testing.T) {
+	secret := []byte("origin-secret")
+	body := []byte(`{"translation":"EN(hello)","src":"upstream"}`)
+	sig := SignOriginResponse(secret, "1772366400", "req-1", body)
+	flip := func(b []byte, i int) []byte {
+		b = append([]byte(nil), b...)
+		b[i] ^= 1
+		return b
+	}
+	tests := []struct {
+		name      string
+		secret    []byte
+		timestamp string
+		requestID string
+		body      []byte
+		signature string
+		ok        bool
+	}{
+		{"valid", secret, "1772366400", "req-1", body, sig, true},
+		{"first byte flipped", secret, "1772366400", "req-1", flip(body, 0), sig, false},
+		{"middle byte flipped", secret, "1772366400", "req-1", flip(body, len(body)/2), sig, false},
+		{"last byte flipped", secret, "1772366400", "req-1", flip(body, len(body)-1), sig, false},
+		{"byte appended", secret, "1772366400", "req-1", append(append([]byte(nil), body...), ' '), sig, false},
+		{"truncated", secret, "1772366400", "req-1", body[:len(body)-1], sig, false},
+		{"other timestamp", secret, "1772366401", "req-1", body, sig, false},
+		{"other request", secret, "1772366400", "req-2", body, sig, false},
+		{"request id moved into the body", secret, "1772366400", "", append([]byte("req-1\n"), body...), sig, false},
+		{"other secret", []byte("other"), "1772366400", "req-1", body, sig, false},
+		{"signature byte flipped", secret, "1772366400", "req-1", body, string(flip([]byte(sig), 3)), false},
+		{"not hex", secret, "1772366400", "req-1", body, "zz" + sig[2:], false},
+		{"empty signature", secret, "1772366400", "req-1", body, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyOriginResponse(tt.secret, tt.timestamp, tt.requestID, tt.body, tt.signature); got != tt.ok {
+				t.Fatalf("verified %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+// TestSignedResponses checks the signature on what the routes send covers the exact body,
+// errors included, and that a byte changed on the way fails it. The timestamp is the server clock's.
+func TestSignedResponses(t *testing.T) {
+	const secret = "origin-secret"
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := newTestServer(t, map[string]string{
+		"RESPONSE_SIGNING_KEY": secret,
+		"CANONICAL_JSON":       "true",
+	}, Deps{Clock: clk}).Handler()
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		key     string // "" sends testProKey
+		headers []string
+		status  int
+		signed  bool
+	}{
+		{"translate", "GET", "/go/translate?q=hello", "", "", nil, http.StatusOK, true},
+		{"versioned", "GET", "/go/v2/translate?q=hello", "", "", nil, http.StatusOK, true},
+		{"compressed", "GET", "/go/translate?q=" + strings.Repeat("hello+", 200), "", "", []string{"Accept-Encoding", "gzip"}, http.StatusOK, true},
+		{"batch", "POST", "/go/translate/batch", `{"items":[{"id":"1","q":"hello"},{"id":"2","q":"bye"}]}`, "", []string{"Content-Type", "application/json"}, http.StatusOK, true},
+		{"handler error", "GET", "/go/translate", "", "", nil, http.StatusBadRequest, true},
+		{"middleware error", "GET", "/go/translate?q=hello", "", "nope", nil, http.StatusUnauthorized, true},
+		{"other route", "GET", "/go/health", "", "", nil, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key
+			if key == "" {
+				key = testProKey
+			}
+			w := serve(h, tt.method, tt.target, tt.body, append([]string{"X-API-Key", key}, tt.headers...)...)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			ts, sig := w.Header().Get(originTimestampHeader), w.Header().Get(originSignatureHeader)
+			if !tt.signed {
+				if ts != "" || sig != "" {
+					t.Fatalf("signed %q at %q, want unsigned", sig, ts)
+				}
+				return
+			}
+			if want := strconv.FormatInt(clk.Now().Unix(), 10); ts != want {
+				t.Fatalf("timestamp %q, want %q", ts, want)
+			}
+			body := w.Body.Bytes()
+			if w.Header().Get("Content-Encoding") == "gzip" {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			} else if tt.name == "compressed" {
+				t.Fatal("long response not compressed")
+			}
+			id := w.Header().Get(middleware.RequestIDHeader)
+			if !VerifyOriginResponse([]byte(secret), ts, id, body, sig) {
+				t.Fatalf("signature %q doesn't verify over %s", sig, body)
+			}
+			for i := range body {
+				body[i] ^= 0x20
+				if VerifyOriginResponse([]byte(secret), ts, id, body, sig) {
+					t.Fatalf("verified with byte %d changed", i)
+				}
+				body[i] ^= 0x20
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Slow-request logging. A request slower than SLOW_REQUEST_THRESHOLD gets a slow_request line of
+// its own, next to the ordinary request line, saying where the time went: waiting on upstreams
+// or spent here, and whether the cache answered. Inputs are described by length, and when there
+// is one, by as much of it as LOG_CONTENT allows.
+
+// debugTimingHeader forces a detailed slow_request line for one request, whatever its duration
+// or the sampling, when it carries a valid admin token. Requests authenticate with their own
+// key as usual; the token is only checked here.
+const debugTimingHeader = "X-Debug-Timing"
+
+// slowLogOpts controls slowRequests.
+type slowLogOpts struct {
+	Threshold time.Duration // 0 turns slow-request logging off; forced lines still work
+	Sample    int           // log 1 in N slow requests
+}
+
+// reqTiming collects what a request's translations spent, from wherever they run. Upstream calls
+// may overlap (sentences go out concurrently), so upstream time is wall time with at least one
+// call in flight rather than a sum.
+type reqTiming struct {
+	start    time.Time
+	detailed bool
+
+	mu           sync.Mutex
+	inFlight     int
+	flightStart  time.Time
+	upstream     time.Duration
+	upstreamN    int
+	calls        []upstreamCall // detailed only
+	cacheHits    int
+	cacheMisses  int
+	inputChars   int
+	translations int
+	input        string // the first translation's text, kept only when LOG_CONTENT shows it
+}
+
+// upstreamCall is one call to an upstream member, listed in a detailed line.
+type upstreamCall struct {
+	Upstream   string  `json:"upstream"`
+	StartMS    float64 `json:"start_ms"` // since the request started
+	DurationMS float64 `json:"duration_ms"`
+	Attempts   int     `json:"attempts,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func timingFrom(ctx context.Context) *reqTiming {
+	t, _ := ctx.Value(timingCtxKey).(*reqTiming)
+	return t
+}
+
+// upstreamStarted marks a call beginning; the returned func ends it. Both are no-ops without a
+// timing on ctx.
+func upstreamStarted(ctx context.Context) func(name string, attempts int, err error) {
+	t := timingFrom(ctx)
+	if t == nil {
+		return func(string, int, error) {}
+	}
+	start := time.Now()
+	t.mu.Lock()
+	if t.inFlight == 0 {
+		t.flightStart = start
+	}
+	t.inFlight++
+	t.mu.Unlock()
+	return func(name string, attempts int, err error) {
+		now := time.Now()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.inFlight--
+		if t.inFlight == 0 {
+			t.upstream += now.Sub(t.flightStart)
+		}
+		t.upstreamN++
+		if t.detailed {
+			c := upstreamCall{Upstream: name, StartMS: ms(start.Sub(t.start)), DurationMS: ms(now.Sub(start)), Attempts: attempts}
+			if err != nil {
+				c.Error = err.Error()
+			}
+			t.calls = append(t.calls, c)
+		}
+	}
+}
+
+// noteCache records one cache lookup on ctx's timing.
+func noteCache(ctx context.Context, hit bool) {
+	t := timingFrom(ctx)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if hit {
+		t.cacheHits++
+	} else {
+		t.cacheMisses++
+	}
+	t.mu.Unlock()
+}
+
+// noteInput records one translation of text, n characters long, on ctx's timing.
+func noteInput(ctx context.Context, text string, n int) {
+	t := timingFrom(ctx)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.inputChars += n
+	if t.translations == 0 && contentShown() {
+		t.input = text
+	}
+	t.translations++
+	t.mu.Unlock()
+}
+
+// cacheStatus sums up the lookups: hit, miss, partial (some of each, as with several sentences
+// or batch items) or none (the request never reached the cache).
+func (t *reqTiming) cacheStatus() string {
+	switch {
+	case t.cacheHits > 0 && t.cacheMisses > 0:
+		return "partial"
+	case t.cacheHits > 0:
+		return "hit"
+	case t.cacheMisses > 0:
+		return "miss"
+	}
+	return "none"
+}
+
+func ms(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+// slowRequests logs requests that take longer than opts.Threshold, one in opts.Sample of them
+// when sampling, and counts every one in dhk_go_slow_requests_total so the dashboards stay exact.
+// Streams and websocket sessions are long by design and left out unless forced. admins holds the
+// tokens that may force a line with debugTimingHeader.
+func slowRequests(opts slowLogOpts, admins *keyStore) func(http.Handler) http.Handler {
+	var n atomic.Uint64
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t := &reqTiming{start: time.Now(), detailed: forcedTiming(admins, r)}
+			if opts.Threshold <= 0 && !t.detailed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ww := wrapResponse(w, r)
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), timingCtxKey, t)))
+			d := time.Since(t.start)
+
+			long := strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || strings.HasPrefix(ww.Header().Get("Content-Type"), "text/event-stream")
+			slow := opts.Threshold > 0 && d >= opts.Threshold && !long
+			route := routePattern(r)
+			if slow {
+				metricSlowRequests.WithLabelValues(route).Inc()
+			}
+			if !t.detailed && (!slow || (n.Add(1)-1)%uint64(max(opts.Sample, 1)) != 0) {
+				return
+			}
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			upstream := t.upstream
+			if t.inFlight > 0 { // a call outliving the handler, such as a shared fill still running
+				upstream += time.Since(t.flightStart)
+			}
+			status := responseStatus(ww, r)
+			attrs := []any{
+				"request_id", middleware.GetReqID(r.Context()),
+				"method", r.Method,
+				"route", route,
+				"status", status,
+				"duration_ms", ms(d),
+				"upstream_ms", ms(upstream),
+				"local_ms", ms(max(d-upstream, 0)),
+				"upstream_calls", t.upstreamN,
+				"cache", t.cacheStatus(),
+				"input_chars", t.inputChars,
+			}
+			if t.translations > 1 {
+				attrs = append(attrs, "translations", t.translations)
+			} else if t.translations == 1 && contentShown() {
+				attrs = append(attrs, "input", describeContent(t.input))
+			}
+			if opts.Sample > 1 && !t.detailed {
+				attrs = append(attrs, "sample", opts.Sample)
+			}
+			if t.detailed {
+				attrs = append(attrs, "forced", true, "cache_hits", t.cacheHits, "cache_misses", t.cacheMisses, "calls", t.calls)
+			}
+			if m := requestMetaFrom(r.Context()); m != nil && m.KeyID != "" {
+				attrs = append(attrs, "key_id", m.KeyID, "tier", m.Tier)
+			}
+			slog.Warn("slow_request", attrs...)
+		})
+	}
+}
+
+// forcedTiming reports whether r asks for a detailed line with a valid admin token in
+// debugTimingHeader. Anything else in the header is ignored.
+func forcedTiming(admins *keyStore, r *http.Request) bool {
+	token := strings.TrimSpace(r.Header.Get(debugTimingHeader))
+	if token == "" || admins == nil {
+		return false
+	}
+	k, ok := admins.lookup(token)
+	return ok && k.refusal(admins.clock.Now()) == ""
+}
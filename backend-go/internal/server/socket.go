@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// listen opens the main listener: the socket systemd passed in when socket-activated, else
+// LISTEN_SOCKET when set, else TCP on PORT.
+func listen(cfg Config) (net.Listener, error) {
+	if ln, ok, err := systemdListener(); ok || err != nil {
+		return ln, err
+	}
+	if cfg.ListenSocket != "" {
+		return listenSocket(cfg.ListenSocket, cfg.ListenSocketMode)
+	}
+	return net.Listen("tcp", ":"+cfg.Port)
+}
+
+// listenSocket listens on a Unix socket at path with the given permissions. A socket file left
+// behind by a process that died is removed first; one that still answers is an error, as is a
+// path that isn't a socket. The file is unlinked when the listener closes at shutdown.
+func listenSocket(path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("listen socket: %w", err)
+	}
+	return ln, nil
+}
+
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("listen socket: %w", err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("listen socket: %s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("listen socket: %s is in use by another process", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("listen socket: removing stale %s: %w", path, err)
+	}
+	return nil
+}
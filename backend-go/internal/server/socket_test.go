@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestListenSocket serves on LISTEN_SOCKET over a stale socket file left by a process that died,
+// answers a client dialing the socket with the local proxy's X-Forwarded-For taken as the client,
+// refuses a path in use or that isn't a socket, and removes the socket at shutdown.
+func TestListenSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := newTestServer(t, map[string]string{"LISTEN_SOCKET": path, "LISTEN_SOCKET_MODE": "0600"}, Deps{})
+	ln, err := listen(s.cfg)
+	if err != nil {
+		t.Fatalf("listening over the stale socket: %v", err)
+	}
+	s.serveHTTP(ln)
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 || fi.Mode()&os.ModeSocket == 0 {
+		t.Fatalf("socket file %v, err %v; want a socket with mode 0600", fi.Mode(), err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+	req, _ := http.NewRequest("GET", "http://unix/go/translate?q=salaam", nil)
+	req.Header.Set("X-API-Key", testProKey)
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	mark := suiteLog.mark()
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "EN(salaam)") {
+		t.Fatalf("over the socket: status %d: %s", res.StatusCode, body)
+	}
+	if logged := suiteLog.since(mark); !strings.Contains(logged, `"client_ip":"198.51.100.7"`) {
+		t.Fatalf("request not logged with the proxy's client:\n%s", logged)
+	}
+
+	if _, err := listenSocket(path, 0o600); err == nil || !strings.Contains(err.Error(), "in use by another process") {
+		t.Fatalf("second listener on a live socket: err %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "not.sock")
+	os.WriteFile(file, nil, 0o600)
+	if _, err := listenSocket(file, 0o600); err == nil || !strings.Contains(err.Error(), "is not a socket") {
+		t.Fatalf("listening over a regular file: err %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Stop(ctx, StopCause{})
+	if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("socket file after shutdown: err %v; want it removed", err)
+	}
+}
+
+func TestListenSocketConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  string // empty when the configuration loads
+	}{
+		{"socket", map[string]string{"LISTEN_SOCKET": "/run/dhk.sock"}, ""},
+		{"socket and port", map[string]string{"LISTEN_SOCKET": "/run/dhk.sock", "PORT": "8080"}, "LISTEN_SOCKET: cannot be combined with PORT"},
+		{"socket and tls", map[string]string{"LISTEN_SOCKET": "/run/dhk.sock", "TLS_CERT_FILE": "c.pem", "TLS_KEY_FILE": "k.pem"}, "LISTEN_SOCKET: cannot be combined with TLS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{"ENV": "development"}
+			for k, v := range tt.env {
+				vars[k] = v
+			}
+			cfg, err := LoadConfig(func(k string) string { return vars[k] })
+			if tt.err == "" && (err != nil || cfg.ListenSocketMode != 0o660) || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("err %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
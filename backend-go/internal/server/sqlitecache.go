@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	_ "modernc.org/sqlite" // pure-Go driver; the image is built with CGO_ENABLED=0
+)
+
+// sqliteCache persists translations across restarts. Reads are synchronous; writes are queued
+// and applied in batches by a writeQueue so request latency never waits on disk.
+type sqliteCache struct {
+	db        *sql.DB
+	retention time.Duration
+	clock     Clock
+	writes    *writeQueue[cacheWrite]
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type cacheWrite struct {
+	key, translation, src, dst string
+	hits                       int64 // above 0: bump hits on an existing row by that many
+}
+
+// cacheWriteKey coalesces upserts of a key, the last one winning, and its hits, which add up.
+func cacheWriteKey(w cacheWrite) string {
+	if w.hits > 0 {
+		return "hit\x00" + w.key
+	}
+	return "put\x00" + w.key
+}
+
+func mergeCacheWrites(prev, next cacheWrite) cacheWrite {
+	if prev.hits > 0 {
+		prev.hits += next.hits
+		return prev
+	}
+	return next
+}
+
+// sqliteCacheOpts configures retention, how often the janitor prunes and vacuums, and the
+// write queue.
+type sqliteCacheOpts struct {
+	Retention      time.Duration
+	PruneInterval  time.Duration
+	VacuumInterval time.Duration
+	Writes         writeQueueOpts
+	Clock          Clock
+}
+
+// openSQLiteCache opens or creates the cache DB at path and starts the writer and janitor goroutines.
+func openSQLiteCache(path string, opts sqliteCacheOpts) (*sqliteCache, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate(db, "cache", opts.Clock); err != nil {
+		db.Close()
+		return nil, err
+	}
+	c := &sqliteCache{
+		db:        db,
+		retention: opts.Retention,
+		clock:     opts.Clock,
+		stop:      make(chan struct{}),
+	}
+	c.writes = newWriteQueue("cache_db", opts.Writes, cacheWriteKey, mergeCacheWrites, c.write)
+	c.wg.Add(1)
+	go c.janitor(opts.PruneInterval, opts.VacuumInterval)
+	return c, nil
+}
+
+// Get looks up key, ignoring rows past the retention window.
+func (c *sqliteCache) Get(ctx context.Context, key string) (translateResult, time.Time, bool) {
+	var (
+		res     translateResult
+		created int64
+	)
+	err := c.db.QueryRowContext(ctx,
+		`SELECT translation, created_at FROM translation_cache WHERE key = ? AND created_at >= ?`,
+		key, c.clock.Now().Add(-c.retention).Unix(),
+	).Scan(&res.Translation, &created)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) && ctx.Err() == nil {
+			slog.Warn("cache db read failed", "request_id", middleware.GetReqID(ctx), "err", err)
+		}
+		return res, time.Time{}, false
+	}
+	res.Src = "upstream"
+	c.writes.enqueue(cacheWrite{key: key, hits: 1})
+	return res, time.Unix(created, 0), true
+}
+
+// exportPage is how many rows Export reads per query. The pool has one connection, so the
+// export releases it between pages instead of holding a cursor open while the client reads.
+const exportPage = 500
+
+// Export calls fn for every row inside the retention window created at or after since, in key order.
+func (c *sqliteCache) Export(ctx context.Context, since time.Time, fn func(cacheRow) error) error {
+	from := max(since.Unix(), c.clock.Now().Add(-c.retention).Unix())
+	after := ""
+	for {
+		rows, err := c.db.QueryContext(ctx,
+			`SELECT key, translation, src, dst, hits, created_at FROM translation_cache
+				WHERE key > ? AND created_at >= ? ORDER BY key LIMIT ?`,
+			after, from, exportPage)
+		if err != nil {
+			return err
+		}
+		var page []cacheRow
+		for rows.Next() {
+			var (
+				row     cacheRow
+				key     string
+				created int64
+			)
+			if err := rows.Scan(&key, &row.Translation, &row.Src, &row.Dst, &row.Hits, &created); err != nil {
+				rows.Close()
+				return err
+			}
+			k := rowFromKey(key)
+			row.Source, row.Glossary = k.Source, k.Glossary
+			row.CreatedAt = time.Unix(created, 0)
+			page = append(page, row)
+			after = key
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, row := range page {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPage {
+			return nil
+		}
+	}
+}
+
+// Delete removes key right away. A write for it still in the queue may re-add it.
+func (c *sqliteCache) Delete(ctx context.Context, key string) (bool, error) {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM translation_cache WHERE key = ?`, key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Flush removes every row and returns how many there were.
+func (c *sqliteCache) Flush(ctx context.Context) (int, error) {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM translation_cache`)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Put queues an upsert; when the queue is full the write is dropped rather than blocking the request.
+func (c *sqliteCache) Put(key string, req translateReq, res translateResult) {
+	c.writes.enqueue(cacheWrite{key: key, translation: res.Translation, src: req.Src, dst: req.Dst})
+}
+
+// write applies one batch in a transaction.
+func (c *sqliteCache) write(ws []cacheWrite) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := c.clock.Now().Unix()
+	for _, w := range ws {
+		if w.hits > 0 {
+			_, err = tx.Exec(`UPDATE translation_cache SET hits = hits + ? WHERE key = ?`, w.hits, w.key)
+		} else {
+			_, err = tx.Exec(`INSERT INTO translation_cache (key, translation, src, dst, created_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT(key) DO UPDATE SET translation = excluded.translation, created_at = excluded.created_at`,
+				w.key, w.translation, w.src, w.dst, now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// janitor prunes rows older than the retention window and vacuums periodically.
+func (c *sqliteCache) janitor(pruneEvery, vacuumEvery time.Duration) {
+	defer c.wg.Done()
+	prune := c.clock.NewTicker(pruneEvery)
+	vacuum := c.clock.NewTicker(vacuumEvery)
+	defer prune.Stop()
+	defer vacuum.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-prune.C():
+			res, err := c.db.Exec(`DELETE FROM translation_cache WHERE created_at < ?`, c.clock.Now().Add(-c.retention).Unix())
+			if err != nil {
+				slog.Warn("cache db prune failed", "err", err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				slog.Info("cache db pruned expired rows", "rows", n)
+			}
+		case <-vacuum.C():
+			if _, err := c.db.Exec(`VACUUM`); err != nil {
+				slog.Warn("cache db vacuum failed", "err", err)
+			}
+		}
+	}
+}
+
+func (c *sqliteCache) Ping(ctx context.Context) error { return c.db.PingContext(ctx) }
+
+// drain applies the queued writes, until ctx is done; see writeQueue.drain.
+func (c *sqliteCache) drain(ctx context.Context) error { return c.writes.drain(ctx) }
+
+// Close flushes queued writes, unless a drain ran out of time, stops the janitor, and closes
+// the DB.
+func (c *sqliteCache) Close() error {
+	close(c.stop)
+	c.writes.drain(context.Background())
+	c.wg.Wait()
+	return c.db.Close()
+}
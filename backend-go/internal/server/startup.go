@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Startup probes. A platform that restarts an instance whose health check fails would kill one
+// still loading large packs, over and over, so health is split by what it answers:
+//
+//   - /go/health/live is 200 whenever the process can answer at all;
+//   - /go/health/startup is 503, with what is being done ("loading packs 3/7"), until the
+//     server is fully built, and 200 from then on;
+//   - /go/health keeps its meaning, and like every other route answers 503 until then.
+//
+// Under Boot the listener opens before anything else and startupState answers it; once the
+// router is built it is swapped in whole, and every request after that reaches it.
+
+var errStarting = errors.New("server is still starting")
+
+// startupState tracks the build and, until it is done, serves the listener in place of the router.
+type startupState struct {
+	router atomic.Pointer[http.Handler]
+	began  time.Time
+	live   http.HandlerFunc // /go/health/live
+
+	mu          sync.Mutex
+	phase       string
+	step, steps int
+	took        time.Duration
+}
+
+func newStartupState(uptime func() time.Duration) *startupState {
+	return &startupState{began: time.Now(), live: liveHandler(uptime), phase: "starting"}
+}
+
+// set records what the build is doing now; steps > 0 adds "step/steps" to it in the probe.
+func (st *startupState) set(phase string, step, steps int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.phase, st.step, st.steps = phase, step, steps
+}
+
+// finish hands the listener to router.
+func (st *startupState) finish(router http.Handler) {
+	st.mu.Lock()
+	st.took = time.Since(st.began)
+	st.phase, st.step, st.steps = "", 0, 0
+	st.mu.Unlock()
+	st.router.Store(&router)
+}
+
+func (st *startupState) done() bool { return st.router.Load() != nil }
+
+func (st *startupState) progress() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.steps > 0 {
+		return fmt.Sprintf("%s %d/%d", st.phase, st.step, st.steps)
+	}
+	return st.phase
+}
+
+func (st *startupState) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h := st.router.Load(); h != nil {
+		(*h).ServeHTTP(w, r)
+		return
+	}
+	switch r.URL.Path {
+	case "/go/health/live":
+		st.live(w, r)
+	case "/go/health/startup":
+		st.handler(w, r)
+	default:
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, codeStarting, "still starting up: "+st.progress(), "retry_after", 5)
+	}
+}
+
+// handler serves GET /go/health/startup.
+func (st *startupState) handler(w http.ResponseWriter, _ *http.Request) {
+	if !st.done() {
+		j(w, http.StatusServiceUnavailable, map[string]any{
+			"status":     "starting",
+			"progress":   st.progress(),
+			"elapsed_ms": time.Since(st.began).Milliseconds(),
+		})
+		return
+	}
+	st.mu.Lock()
+	took := st.took
+	st.mu.Unlock()
+	j(w, http.StatusOK, map[string]any{"status": "started", "startup_ms": took.Milliseconds()})
+}
+
+// liveHandler serves GET /go/health/live.
+func liveHandler(uptime func() time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		j(w, http.StatusOK, map[string]any{"status": "ok", "uptime": uptime().String()})
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// gatedPacks is a pack directory whose files each open only once a token arrives on open.
+type gatedPacks struct {
+	fstest.MapFS
+	open chan struct{}
+}
+
+func (g gatedPacks) Open(name string) (fs.File, error) {
+	if name != "." {
+		<-g.open
+	}
+	return g.MapFS.Open(name)
+}
+
+// TestBootPhases boots a server whose packs load one file at a time, on the test's say-so, and
+// checks the listener answers from the start: live at once, startup 503 with how far the packs
+// have got, every other route 503 STARTING, then all of them as usual once the build is done.
+func TestBootPhases(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "api.sock")
+	cfg := testConfig(t, map[string]string{"LISTEN_SOCKET": sock, "PACKS_EMBEDDED": "false"})
+	packs := gatedPacks{MapFS: fstest.MapFS{
+		"a.latin-en.tsv": {Data: []byte("rangalhu\tGood\n")},
+		"b.latin-en.tsv": {Data: []byte("miadhu\tToday\n")},
+		"c.latin-en.tsv": {Data: []byte("salaam\tHello\n")},
+	}, open: make(chan struct{})}
+	s, err := Boot(context.Background(), cfg, Deps{Upstream: &echoUpstream{}, Packs: packs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close(packs.open)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Stop(ctx, StopCause{})
+	})
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	get := func(path string) (int, http.Header, map[string]any) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", "http://boot"+path, nil)
+		req.Header.Set("X-API-Key", testProKey)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		var out map[string]any
+		json.Unmarshal(b, &out)
+		return res.StatusCode, res.Header, out
+	}
+	progress := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			code, _, out := get("/go/health/startup")
+			if code == http.StatusServiceUnavailable && out["status"] == "starting" && out["progress"] == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("startup probe %d %v, want 503 with progress %q", code, out, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	progress("loading packs 1/3")
+	if code, _, out := get("/go/health/live"); code != http.StatusOK || out["status"] != "ok" {
+		t.Fatalf("live while starting: %d %v", code, out)
+	}
+	for _, path := range []string{"/go/health", "/go/translate?q=salaam&src=latin&dst=en"} {
+		code, h, out := get(path)
+		e, _ := out["error"].(map[string]any)
+		if code != http.StatusServiceUnavailable || h.Get("Retry-After") != "5" || e["code"] != string(codeStarting) || e["message"] != "still starting up: loading packs 1/3" || e["retry_after"] != 5.0 {
+			t.Fatalf("%s while starting: %d, Retry-After %q, %v", path, code, h.Get("Retry-After"), out)
+		}
+	}
+	packs.open <- struct{}{}
+	progress("loading packs 2/3")
+	packs.open <- struct{}{}
+	progress("loading packs 3/3")
+	packs.open <- struct{}{}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, _, out := get("/go/health/startup")
+		if code == http.StatusOK && out["status"] == "started" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("startup probe %d %v after the packs loaded, want 200 started", code, out)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if code, _, out := get("/go/health"); code != http.StatusOK || out["status"] != "ok" {
+		t.Fatalf("health once started: %d %v", code, out)
+	}
+	if code, _, out := get("/go/translate?q=salaam&src=latin&dst=en"); code != http.StatusOK || out["translation"] != "Hello" || !strings.HasSuffix(out["pack"].(string), "c.latin-en.tsv") {
+		t.Fatalf("translate once started: %d %v", code, out)
+	}
+	if code, _, _ := get("/go/health/live"); code != http.StatusOK {
+		t.Fatalf("live once started: %d", code)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// appStats backs /go/stats: plain atomic counters updated by the instrument middleware, kept for
+// the life of the process. It complements /go/metrics for a quick look without Prometheus.
+type appStats struct {
+	requests       atomic.Uint64
+	byRoute        sync.Map // route pattern -> *atomic.Uint64
+	byStatus       sync.Map // status code -> *atomic.Uint64
+	upstreamErrors atomic.Uint64
+	upstreams      sync.Map // upstream host -> *upstreamWindow
+	outbound       poolStats
+	latency        latencyHistogram
+}
+
+var stats appStats
+
+func (s *appStats) record(route string, status int, d time.Duration) {
+	s.requests.Add(1)
+	counter(&s.byRoute, route).Add(1)
+	counter(&s.byStatus, strconv.Itoa(status)).Add(1)
+	s.latency.observe(d)
+}
+
+// counter returns the counter for key, creating it on first use.
+func counter(m *sync.Map, key string) *atomic.Uint64 {
+	if c, ok := m.Load(key); ok {
+		return c.(*atomic.Uint64)
+	}
+	c, _ := m.LoadOrStore(key, new(atomic.Uint64))
+	return c.(*atomic.Uint64)
+}
+
+func snapshot(m *sync.Map) map[string]uint64 {
+	out := map[string]uint64{}
+	m.Range(func(k, v any) bool {
+		out[k.(string)] = v.(*atomic.Uint64).Load()
+		return true
+	})
+	return out
+}
+
+// latencyHistogram estimates quantiles from log-spaced buckets: each bucket is 10% wider than the
+// last, from 100µs up to about 2 minutes, so a reported quantile is within ~10% of the true value.
+// Observing is a single atomic add.
+type latencyHistogram struct {
+	buckets [latencyBuckets]atomic.Uint64
+}
+
+const (
+	latencyBuckets = 150
+	latencyMin     = 100 * time.Microsecond
+	latencyGrowth  = 1.1
+)
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	if d > latencyMin {
+		i = min(int(math.Log(float64(d)/float64(latencyMin))/math.Log(latencyGrowth))+1, latencyBuckets-1)
+	}
+	h.buckets[i].Add(1)
+}
+
+// quantiles returns the upper bound, in milliseconds, of the bucket holding each quantile.
+func (h *latencyHistogram) quantiles(qs ...float64) []float64 {
+	var counts [latencyBuckets]uint64
+	var total uint64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		total += counts[i]
+	}
+	out := make([]float64, len(qs))
+	if total == 0 {
+		return out
+	}
+	for qi, q := range qs {
+		rank := uint64(math.Ceil(q * float64(total)))
+		var seen uint64
+		for i, c := range counts {
+			if seen += c; seen >= rank {
+				upper := float64(latencyMin) * math.Pow(latencyGrowth, float64(i))
+				out[qi] = math.Round(upper/float64(time.Millisecond)*1000) / 1000
+				break
+			}
+		}
+	}
+	return out
+}
+
+// upstreamSummaries is each upstream's window as of now, by host.
+func upstreamSummaries(now time.Time) map[string]any {
+	out := map[string]any{}
+	stats.upstreams.Range(func(k, v any) bool {
+		out[k.(string)] = v.(*upstreamWindow).summary(now)
+		return true
+	})
+	return out
+}
+
+// statsHandler serves GET /go/stats; New mounts it behind the admin token.
+func statsHandler(ct *cachedTranslator, shed *loadShedder, clk Clock, uptime func() time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		p := stats.latency.quantiles(0.50, 0.95, 0.99)
+		out := map[string]any{
+			"uptime_s": int(uptime().Seconds()),
+			"requests": map[string]any{
+				"total":     stats.requests.Load(),
+				"by_route":  snapshot(&stats.byRoute),
+				"by_status": snapshot(&stats.byStatus),
+			},
+			"latency_ms":      map[string]float64{"p50": p[0], "p95": p[1], "p99": p[2]},
+			"upstream_errors": stats.upstreamErrors.Load(),
+			"upstreams":       upstreamSummaries(clk.Now()),
+			"outbound_conns":  stats.outbound.summary(),
+			"concurrency":     shed.stats(),
+			"runtime": map[string]any{
+				"goroutines":        runtime.NumGoroutine(),
+				"heap_inuse_bytes":  ms.HeapInuse,
+				"gc_runs":           ms.NumGC,
+				"gc_pause_total_ms": float64(ms.PauseTotalNs) / 1e6,
+				"gc_pause_last_ms":  float64(ms.PauseNs[(ms.NumGC+255)%256]) / 1e6,
+			},
+			"ts": clk.Now().UTC().Format(time.RFC3339),
+		}
+		if st, err := ct.cache.Stats(r.Context()); err == nil {
+			out["cache"] = st
+		} else {
+			out["cache"] = map[string]any{"error": err.Error()}
+		}
+		if ct.errors != nil {
+			out["error_cache"] = ct.errors.stats()
+		}
+		j(w, http.StatusOK, out)
+	}
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// StatsD export. With STATSD_ADDR set, the counters and timers /go/metrics exposes for requests,
+// the cache and the upstreams are also pushed over UDP to a StatsD or Datadog agent, for setups
+// where scraping Fly machines is awkward. Prometheus keeps working alongside.
+
+// metricSink receives the request, cache and upstream measurements recorded alongside the
+// Prometheus metrics. tags are key, value pairs.
+type metricSink interface {
+	count(name string, n int64, tags ...string)
+	timing(name string, d time.Duration, tags ...string)
+}
+
+// metricSinks is a Server's extra sinks, handed to what it builds; nil sends nowhere.
+type metricSinks []metricSink
+
+func (ss metricSinks) count(name string, n int64, tags ...string) {
+	for _, s := range ss {
+		s.count(name, n, tags...)
+	}
+}
+
+func (ss metricSinks) timing(name string, d time.Duration, tags ...string) {
+	for _, s := range ss {
+		s.timing(name, d, tags...)
+	}
+}
+
+type statsdOpts struct {
+	Addr      string   // host:port of the agent
+	Prefix    string   // prepended to every metric name
+	Tags      []string // key:value tags on every line
+	DogStatsD bool     // send tags in DogStatsD's |#k:v form; plain StatsD drops them
+	Buffer    int      // lines queued before new ones are dropped
+	Clock     Clock    // ticks the flush
+}
+
+// statsdPacket is the most sent in one datagram, which stays under a typical 1500-byte MTU.
+const (
+	statsdPacket = 1432
+	statsdFlush  = 100 * time.Millisecond
+)
+
+// statsdSink formats lines on the caller's goroutine and queues them for one sender, which packs
+// them into datagrams every statsdFlush. Recording never blocks: with the queue full a line is
+// dropped and counted in dhk_go_statsd_dropped_total. A nil *statsdSink is valid and sends nothing.
+type statsdSink struct {
+	opts   statsdOpts
+	conn   net.Conn
+	tags   string // opts.Tags, formatted
+	queue  chan string
+	quit   chan struct{}
+	done   chan struct{}
+	closed atomic.Bool
+}
+
+// newStatsdSink dials opts.Addr (UDP, so nothing is sent yet) and starts the sender; nil without
+// an address.
+func newStatsdSink(opts statsdOpts) (*statsdSink, error) {
+	if opts.Addr == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &statsdSink{
+		opts:  opts,
+		conn:  conn,
+		queue: make(chan string, max(opts.Buffer, 1)),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if opts.DogStatsD && len(opts.Tags) > 0 {
+		s.tags = strings.Join(opts.Tags, ",")
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *statsdSink) count(name string, n int64, tags ...string) {
+	s.send(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+func (s *statsdSink) timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64), "ms", tags)
+}
+
+// send queues one line: <prefix><name>:<value>|<type>[|#tags].
+func (s *statsdSink) send(name, value, typ string, tags []string) {
+	if s == nil || s.closed.Load() {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(s.opts.Prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(typ)
+	if s.opts.DogStatsD && (len(tags) > 1 || s.tags != "") {
+		b.WriteString("|#")
+		b.WriteString(s.tags)
+		for i := 0; i+1 < len(tags); i += 2 {
+			if i > 0 || s.tags != "" {
+				b.WriteByte(',')
+			}
+			b.WriteString(tags[i])
+			b.WriteByte(':')
+			b.WriteString(statsdTagValue(tags[i+1]))
+		}
+	}
+	select {
+	case s.queue <- b.String():
+	default:
+		metricStatsdDropped.Inc()
+	}
+}
+
+// statsdTagValue replaces the characters DogStatsD uses as separators.
+func statsdTagValue(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+// run packs queued lines, newline-separated, into datagrams of up to statsdPacket bytes, sending
+// one when the next line wouldn't fit and whatever is pending every statsdFlush.
+func (s *statsdSink) run() {
+	defer close(s.done)
+	t := s.opts.Clock.NewTicker(statsdFlush)
+	defer t.Stop()
+	buf := make([]byte, 0, statsdPacket)
+	flush := func() {
+		if len(buf) == 0 {
+			return
+		}
+		if _, err := s.conn.Write(buf); err != nil {
+			slog.Debug("statsd write failed", "err", err)
+		}
+		buf = buf[:0]
+	}
+	add := func(line string) {
+		if len(buf) > 0 && len(buf)+1+len(line) > statsdPacket {
+			flush()
+		}
+		if len(buf) > 0 {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, line...)
+	}
+	for {
+		select {
+		case line := <-s.queue:
+			add(line)
+		case <-t.C():
+			flush()
+		case <-s.quit:
+			for {
+				select {
+				case line := <-s.queue:
+					add(line)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// stop sends what is queued and closes the socket, giving up when ctx ends.
+func (s *statsdSink) stop(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	if s.closed.CompareAndSwap(false, true) {
+		close(s.quit)
+	}
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// statsdAgent listens on a local UDP port and returns its address and the lines it receives.
+func statsdAgent(t *testing.T) (string, chan string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	lines := make(chan string, 256)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, l := range strings.Split(string(buf[:n]), "\n") {
+				lines <- l
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), lines
+}
+
+// awaitLines reads lines until every one of want has arrived, a want ending in ":" matching any
+// line it prefixes.
+func awaitLines(t *testing.T, lines chan string, want ...string) {
+	t.Helper()
+	missing := map[string]bool{}
+	for _, w := range want {
+		missing[w] = true
+	}
+	timeout := time.After(5 * time.Second)
+	var got []string
+	for len(missing) > 0 {
+		select {
+		case l := <-lines:
+			got = append(got, l)
+			for w := range missing {
+				if l == w || strings.HasSuffix(w, ":") && strings.HasPrefix(l, w) {
+					delete(missing, w)
+				}
+			}
+		case <-timeout:
+			t.Fatalf("never sent %v; got:\n%s", missing, strings.Join(got, "\n"))
+		}
+	}
+}
+
+// TestStatsdMetrics sends a cache miss and a hit through a server with STATSD_ADDR and checks the
+// DogStatsD lines for the requests, the cache and the upstream, tagged, while /go/metrics keeps
+// serving the same counts.
+func TestStatsdMetrics(t *testing.T) {
+	addr, lines := statsdAgent(t)
+	up := httptest.NewServer(&fakeUpstream{})
+	defer up.Close()
+	u, _ := url.Parse(up.URL)
+	h := newTestServer(t, map[string]string{
+		"STATSD_ADDR":  addr,
+		"STATSD_TAGS":  "env:test",
+		"UPSTREAM_URL": up.URL,
+	}, Deps{}).Handler()
+
+	for range 2 {
+		if w := serve(h, "GET", "/go/translate?q=salaam&src=dv&dst=en", "", "X-API-Key", testProKey); w.Code != http.StatusOK {
+			t.Fatalf("translate: status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	awaitLines(t, lines,
+		"dhk_go.http.requests:1|c|#env:test,route:/go/translate,method:GET,status:200,tier:pro",
+		"dhk_go.http.request_duration:",
+		"dhk_go.cache.lookups:1|c|#env:test,outcome:miss",
+		"dhk_go.cache.lookups:1|c|#env:test,outcome:hit",
+		"dhk_go.upstream.requests:1|c|#env:test,upstream:"+u.Host+",outcome:ok",
+		"dhk_go.upstream.request_duration:",
+	)
+	if w := serve(h, "GET", "/go/metrics", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `dhk_go_http_requests_total{method="GET",route="/go/translate",status="200",tier="pro"}`) {
+		t.Fatalf("/go/metrics alongside StatsD: status %d, without the translate requests", w.Code)
+	}
+}
+
+// TestStatsdSink checks plain StatsD lines carry no tags, tag values lose DogStatsD's separators,
+// and that with the queue full a line is dropped and counted instead of blocking.
+func TestStatsdSink(t *testing.T) {
+	addr, lines := statsdAgent(t)
+	plain, err := newStatsdSink(statsdOpts{Addr: addr, Prefix: "p.", Tags: []string{"env:test"}, Buffer: 16, Clock: systemClock{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.stop(context.Background())
+	plain.count("hits", 2, "route", "/go/translate")
+	plain.timing("took", 1500*time.Microsecond)
+	awaitLines(t, lines, "p.hits:2|c", "p.took:1.5|ms")
+
+	dog, err := newStatsdSink(statsdOpts{Addr: addr, DogStatsD: true, Buffer: 16, Clock: systemClock{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dog.stop(context.Background())
+	dog.count("odd", 1, "q", "a,b|c#d")
+	awaitLines(t, lines, "odd:1|c|#q:a_b_c_d")
+
+	var none *statsdSink
+	none.count("nowhere", 1)
+	full := &statsdSink{queue: make(chan string, 1)} // no sender draining it
+	before := testutil.ToFloat64(metricStatsdDropped)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 3 {
+			full.count("burst", 1)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recording blocked on a full queue")
+	}
+	if got := testutil.ToFloat64(metricStatsdDropped) - before; got != 2 {
+		t.Fatalf("%v lines counted dropped, want 2", got)
+	}
+}
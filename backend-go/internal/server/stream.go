@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// streamOpts bounds a streaming translation: total duration and keep-alive interval.
+type streamOpts struct {
+	MaxDuration time.Duration
+	KeepAlive   time.Duration
+	Input       InputOptions // checked before the stream starts, so bad input is still a 400
+}
+
+// streamHandler serves GET /go/translate/stream?q=... as Server-Sent Events. The input is split
+// by segmentText into sentences that are translated in order; each one is sent as a "sentence"
+// event ({"index","translation","src"}) as soon as it is done, followed by a "done" event
+// carrying the result joined with the input's spacing. A failed sentence ends the stream with
+// an "error" event.
+//
+// Streams are exempt from the usual request timeout and server write timeout and are bounded by
+// opts.MaxDuration instead. They end early when the client goes away or shutdown starts.
+func streamHandler(t Translator, opts streamOpts, shutdown context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		text := q.Get("q")
+		if strings.TrimSpace(text) == "" {
+			writeError(w, http.StatusBadRequest, codeMissingQuery, "missing query param 'q'")
+			return
+		}
+		input := opts.Input
+		input.AllowBidi = queryBool(q.Get("allow_bidi"))
+		if _, err := ValidateInput(text, input); err != nil {
+			writeTranslateError(r.Context(), w, err)
+			return
+		}
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(opts.MaxDuration)); err != nil {
+			slog.Warn("stream write deadline not extended", "request_id", middleware.GetReqID(r.Context()), "err", err)
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), opts.MaxDuration)
+		defer cancel()
+		stop := context.AfterFunc(shutdown, cancel)
+		defer stop()
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no") // keep nginx-style proxies from buffering events
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			slog.Warn("stream flush unsupported", "request_id", middleware.GetReqID(r.Context()), "err", err)
+			return
+		}
+
+		sentences := segmentText(text)
+		results := make(chan sseEvent)
+		go func() {
+			defer close(results)
+			var joined strings.Builder
+			for i, s := range sentences {
+				res, err := t.Translate(ctx, translateReq{Q: s.Text, Src: q.Get("src"), Dst: q.Get("dst"), AllowBidi: input.AllowBidi})
+				if err != nil {
+					send(ctx, results, sseEvent{"error", map[string]any{"index": i, "error": err.Error()}})
+					return
+				}
+				joined.WriteString(res.Translation + s.Sep)
+				if !send(ctx, results, sseEvent{"sentence", map[string]any{"index": i, "translation": res.Translation, "src": res.Src}}) {
+					return
+				}
+			}
+			send(ctx, results, sseEvent{"done", map[string]any{"translation": joined.String(), "count": len(sentences)}})
+		}()
+
+		ping := time.NewTicker(opts.KeepAlive)
+		defer ping.Stop()
+		for {
+			var err error
+			select {
+			case ev, ok := <-results:
+				if !ok {
+					return
+				}
+				err = ev.write(w)
+			case <-ping.C:
+				_, err = fmt.Fprint(w, ": ping\n\n")
+			case <-ctx.Done():
+				return
+			}
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// sseEvent is one named Server-Sent Event with a JSON payload.
+type sseEvent struct {
+	Name string
+	Data any
+}
+
+func (e sseEvent) write(w http.ResponseWriter) error {
+	b, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name, b)
+	return err
+}
+
+// send hands ev to the writer loop unless ctx is done first.
+func send(ctx context.Context, ch chan<- sseEvent, ev sseEvent) bool {
+	select {
+	case ch <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
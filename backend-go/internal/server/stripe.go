@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// stripeSignatureHeader carries "t=<unix>,v1=<hex hmac>[,v1=...]" on every Stripe webhook.
+const stripeSignatureHeader = "Stripe-Signature"
+
+// SignStripePayload returns the v1 signature Stripe sends for body at timestamp: the hex
+// HMAC-SHA256 of "<timestamp>.<body>" under the endpoint's signing secret.
+func SignStripePayload(secret []byte, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(timestamp + "."))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// verifyStripeSignature checks header against body. Any v1 entry may match, since Stripe sends
+// one per active secret while a secret is being rolled.
+func verifyStripeSignature(secret []byte, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			if b, err := hex.DecodeString(v); err == nil {
+				sigs = append(sigs, b)
+			}
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return errors.New("malformed signature header")
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+	want, _ := hex.DecodeString(SignStripePayload(secret, ts, body))
+	ok := false
+	for _, s := range sigs {
+		if hmac.Equal(s, want) {
+			ok = true
+		}
+	}
+	if !ok {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// stripeEvent is the subset of a Stripe event the handler reads. Both handled event types carry
+// metadata.api_key_id (set when the checkout session is created); checkout sessions may carry
+// the key id as client_reference_id instead.
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ClientReferenceID string            `json:"client_reference_id"`
+			Customer          string            `json:"customer"`
+			Metadata          map[string]string `json:"metadata"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// seenEvents remembers processed event ids for ttl so Stripe's retries are acknowledged without
+// being applied twice. It is in memory only; a retry after a restart reapplies the same tier,
+// which is harmless.
+type seenEvents struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	ids map[string]time.Time
+}
+
+// add records id, reporting false if it was already seen.
+func (s *seenEvents) add(id string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, at := range s.ids {
+		if now.Sub(at) > s.ttl {
+			delete(s.ids, k)
+		}
+	}
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = now
+	return true
+}
+
+func (s *seenEvents) forget(id string) {
+	s.mu.Lock()
+	delete(s.ids, id)
+	s.mu.Unlock()
+}
+
+// stripeWebhookHandler serves POST /go/webhooks/stripe. A completed checkout upgrades the key to
+// pro and a deleted subscription drops it back to free; other event types are acknowledged and
+// ignored. Bad signatures are a 400. A failure to apply an event is a 500, so Stripe retries it.
+func stripeWebhookHandler(secret []byte, tolerance time.Duration, keys *keyStore, clk Clock) http.HandlerFunc {
+	seen := &seenEvents{ttl: 72 * time.Hour, ids: make(map[string]time.Time)}
+	return func(w http.ResponseWriter, r *http.Request) {
+		rid := middleware.GetReqID(r.Context())
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "unreadable body")
+			return
+		}
+		if err := verifyStripeSignature(secret, r.Header.Get(stripeSignatureHeader), body, tolerance, clk.Now()); err != nil {
+			slog.Warn("stripe webhook rejected", "request_id", rid, "err", err, "client_ip", clientIP(r))
+			writeError(w, http.StatusBadRequest, codeBadRequest, "invalid signature")
+			return
+		}
+		var ev stripeEvent
+		if err := json.Unmarshal(body, &ev); err != nil || ev.ID == "" {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "invalid event")
+			return
+		}
+
+		var tier string
+		switch ev.Type {
+		case "checkout.session.completed":
+			tier = tierPro
+		case "customer.subscription.deleted":
+			tier = tierFree
+		default:
+			j(w, http.StatusOK, map[string]any{"received": true, "ignored": ev.Type})
+			return
+		}
+		if !seen.add(ev.ID, clk.Now()) {
+			j(w, http.StatusOK, map[string]any{"received": true, "duplicate": true})
+			return
+		}
+
+		obj := ev.Data.Object
+		keyID := firstNonEmpty(obj.Metadata["api_key_id"], obj.ClientReferenceID)
+		if keyID == "" {
+			keyID, _ = keys.keyForCustomer(obj.Customer)
+		}
+		attrs := []any{"request_id", rid, "event_id", ev.ID, "event_type", ev.Type, "key_id", keyID, "tier", tier}
+		if keyID == "" {
+			slog.Warn("stripe event names no api key", attrs...)
+			j(w, http.StatusOK, map[string]any{"received": true, "ignored": "no api key on event"})
+			return
+		}
+		if err := keys.setTier(keyID, tier, obj.Customer); err != nil {
+			if errors.Is(err, errKeyNotFound) {
+				slog.Warn("stripe event for unknown api key", attrs...)
+				j(w, http.StatusOK, map[string]any{"received": true, "ignored": "unknown api key"})
+				return
+			}
+			seen.forget(ev.ID)
+			slog.Error("stripe tier update failed", append(attrs, "err", err)...)
+			writeError(w, http.StatusInternalServerError, codeInternal, "tier update failed")
+			return
+		}
+		slog.Info("api key tier changed", attrs...)
+		j(w, http.StatusOK, map[string]any{"received": true, "key_id": keyID, "tier": tier})
+	}
+}
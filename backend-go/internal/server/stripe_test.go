@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testStripeSecret = "whsec_test"
+
+// stripeHeader signs body at ts as Stripe would with testStripeSecret.
+func stripeHeader(ts time.Time, body string) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + t + ",v1=" + SignStripePayload([]byte(testStripeSecret), t, []byte(body))
+}
+
+func TestVerifyStripeSignature(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":"evt_1"}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := SignStripePayload([]byte(testStripeSecret), ts, body)
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+		ok     bool
+	}{
+		{"valid", "t=" + ts + ",v1=" + sig, body, true},
+		{"spaces around parts", "t=" + ts + ", v1=" + sig, body, true},
+		{"second v1 while rolling", "t=" + ts + ",v1=" + SignStripePayload([]byte("whsec_old"), ts, body) + ",v1=" + sig, body, true},
+		{"v0 ignored", "t=" + ts + ",v0=" + sig, body, false},
+		{"tampered body", "t=" + ts + ",v1=" + sig, []byte(`{"id":"evt_2"}`), false},
+		{"other secret", "t=" + ts + ",v1=" + SignStripePayload([]byte("whsec_other"), ts, body), body, false},
+		{"other timestamp", "t=" + strconv.FormatInt(now.Unix()-1, 10) + ",v1=" + sig, body, false},
+		{"too old", stripeHeader(now.Add(-6*time.Minute), string(body)), body, false},
+		{"just within tolerance", stripeHeader(now.Add(-5*time.Minute), string(body)), body, true},
+		{"from the future", stripeHeader(now.Add(6*time.Minute), string(body)), body, false},
+		{"no timestamp", "v1=" + sig, body, false},
+		{"not hex", "t=" + ts + ",v1=zz", body, false},
+		{"empty", "", body, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyStripeSignature([]byte(testStripeSecret), tt.header, tt.body, 5*time.Minute, now)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestStripeWebhook(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	file := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(file, []byte(`[{"key":"fk","id":"freebie"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := loadKeyStore("", file, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := stripeWebhookHandler([]byte(testStripeSecret), 5*time.Minute, keys, clk)
+	event := func(id, typ, object string) string {
+		return fmt.Sprintf(`{"id":%q,"type":%q,"data":{"object":%s}}`, id, typ, object)
+	}
+	checkout := event("evt_1", "checkout.session.completed", `{"customer":"cus_1","metadata":{"api_key_id":"freebie"}}`)
+	steps := []struct {
+		name   string
+		body   string
+		header string // "" signs body now
+		status int
+		tier   string // freebie's tier afterwards
+	}{
+		{"bad signature", checkout, "t=1,v1=00", http.StatusBadRequest, tierFree},
+		{"unknown type ignored", event("evt_0", "invoice.paid", `{"customer":"cus_1"}`), "", http.StatusOK, tierFree},
+		{"not an event", `{"type":"checkout.session.completed"}`, "", http.StatusBadRequest, tierFree},
+		{"checkout upgrades", checkout, "", http.StatusOK, tierPro},
+		{"unknown key ignored", event("evt_2", "customer.subscription.deleted", `{"metadata":{"api_key_id":"nobody"}}`), "", http.StatusOK, tierPro},
+		{"no key ignored", event("evt_3", "customer.subscription.deleted", `{}`), "", http.StatusOK, tierPro},
+		{"deletion by customer downgrades", event("evt_4", "customer.subscription.deleted", `{"customer":"cus_1"}`), "", http.StatusOK, tierFree},
+		{"retried checkout applied once", checkout, "", http.StatusOK, tierFree},
+		{"by client reference", event("evt_5", "checkout.session.completed", `{"client_reference_id":"freebie"}`), "", http.StatusOK, tierPro},
+	}
+	for _, st := range steps {
+		header := st.header
+		if header == "" {
+			header = stripeHeader(clk.Now(), st.body)
+		}
+		w := serve(h, "POST", "/go/webhooks/stripe", st.body, stripeSignatureHeader, header)
+		if w.Code != st.status {
+			t.Fatalf("%s: status %d, want %d: %s", st.name, w.Code, st.status, w.Body.String())
+		}
+		k, _ := keys.lookup("fk")
+		if k.tier != st.tier {
+			t.Fatalf("%s: tier %q, want %q", st.name, k.tier, st.tier)
+		}
+		clk.Advance(time.Minute)
+	}
+
+	// The change is in the key file, customer included, for the next start.
+	reloaded, err := loadKeyStore("", file, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := reloaded.lookup("fk"); k.tier != tierPro {
+		t.Fatalf("reloaded tier %q, want pro", k.tier)
+	}
+	if id, ok := reloaded.keyForCustomer("cus_1"); !ok || id != "freebie" {
+		t.Fatalf("reloaded customer maps to %q, %v", id, ok)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// Forced stub answers. TRANSLATE_MODE=stub is on its way out, but local frontends and staging
+// smoke tests still lean on the echo to check their plumbing without spending upstream calls.
+// With STUB_OPT_IN, a proxy-mode instance echoes a translate request carrying X-DHK-Stub: 1
+// instead of looking it up, and says so: "src": "stub", and a Warning header. The echo skips
+// the packs, cache, memory and upstream alike, so nothing it produces is stored anywhere.
+// Each one is logged and counted, to see who still needs the stub before it goes.
+
+const (
+	stubHeader  = "X-DHK-Stub"
+	stubWarning = `299 - "stub translation forced by X-DHK-Stub; the echo stub is deprecated and will be removed"`
+)
+
+type stubForcedKey struct{}
+
+// stubForced reports whether the request on ctx asked for the echo.
+func stubForced(ctx context.Context) bool {
+	return ctx.Value(stubForcedKey{}) != nil
+}
+
+// stubOptIn marks requests with X-DHK-Stub: 1 for the echo. It runs after auth, so the log line
+// names the key.
+func stubOptIn(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(stubHeader) != "1" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path := apiPath(r.URL.Path)
+		id, _ := identityFrom(r.Context())
+		metricStubForced.WithLabelValues(path).Inc()
+		slog.Warn("stub translation forced", "path", path, "key_id", id.KeyID)
+		w.Header().Add("Warning", stubWarning)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), stubForcedKey{}, true)))
+	})
+}
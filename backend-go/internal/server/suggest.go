@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Near-match suggestions. In pack_only mode a miss is a 501 NOT_COVERED; it comes with up to
+// three phrases the packs or the translation memory do cover that look like q, so a client can
+// offer "did you mean". Similarity is the Dice coefficient of the two phrases' character
+// trigrams. A trigram inverted index per direction is built when the packs load (and kept by the
+// translation memory as it changes), so a lookup reads a few posting lists, never every entry.
+
+const (
+	suggestLimit = 3
+	// suggestMaxCandidates bounds the entries one lookup scores, whatever the index size.
+	suggestMaxCandidates = 1000
+)
+
+// suggestion is one near match for a phrase nothing answered.
+type suggestion struct {
+	Q           string  `json:"q"`
+	Translation string  `json:"translation"`
+	Similarity  float64 `json:"similarity"` // 0..1
+	From        string  `json:"from"`       // pack file name, or "tm"
+}
+
+// notCoveredError is pack_only's refusal, with what looks like q.
+type notCoveredError struct {
+	Suggestions []suggestion
+}
+
+func (e *notCoveredError) Error() string {
+	return "no pack or cached translation for this input, and TRANSLATE_MODE=pack_only never calls the upstream"
+}
+
+// trigrams returns the sorted, distinct trigrams of s, lowercased and padded with a space at
+// each end so the first and last letters count.
+func trigrams(s string) []uint64 {
+	return runeTrigrams([]rune(" " + strings.ToLower(strings.Join(strings.Fields(s), " ")) + " "))
+}
+
+// runeTrigrams is the sorted, distinct trigrams of rs. A trigram is three runes packed into 63
+// bits.
+func runeTrigrams(rs []rune) []uint64 {
+	if len(rs) < 3 {
+		return nil
+	}
+	out := make([]uint64, 0, len(rs)-2)
+	for i := 0; i+2 < len(rs); i++ {
+		out = append(out, uint64(rs[i])<<42|uint64(rs[i+1])<<21|uint64(rs[i+2]))
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// dice is 2|a∩b| / (|a|+|b|) for sorted trigram sets.
+func dice(a, b []uint64) float64 {
+	if len(a)+len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			shared++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return 2 * float64(shared) / float64(len(a)+len(b))
+}
+
+type fuzzyDoc struct {
+	source, translation, from string
+	grams                     []uint64
+	deleted                   bool
+}
+
+// fuzzyIndex is one direction's phrases and the posting list of each trigram of their sources,
+// and of their translations for /go/packs/search. Deleted phrases stay in the posting lists and
+// are skipped, as do replaced translations' trigrams.
+type fuzzyIndex struct {
+	docs         []fuzzyDoc
+	postings     map[uint64][]int32
+	translations map[uint64][]int32
+	bySource     map[string]int32 // lowercased source to doc, for put and remove
+}
+
+func newFuzzyIndex() *fuzzyIndex {
+	return &fuzzyIndex{postings: map[uint64][]int32{}, translations: map[uint64][]int32{}, bySource: map[string]int32{}}
+}
+
+// put adds source, or replaces its translation.
+func (ix *fuzzyIndex) put(source, translation, from string) {
+	key := strings.ToLower(source)
+	if id, ok := ix.bySource[key]; ok {
+		d := &ix.docs[id]
+		if d.translation != translation {
+			ix.postTranslation(id, translation)
+		}
+		d.translation, d.from, d.deleted = translation, from, false
+		return
+	}
+	id := int32(len(ix.docs))
+	d := fuzzyDoc{source: source, translation: translation, from: from, grams: trigrams(source)}
+	ix.docs = append(ix.docs, d)
+	ix.bySource[key] = id
+	for _, g := range d.grams {
+		ix.postings[g] = append(ix.postings[g], id)
+	}
+	ix.postTranslation(id, translation)
+}
+
+func (ix *fuzzyIndex) postTranslation(id int32, translation string) {
+	for _, g := range trigrams(translation) {
+		if l := ix.translations[g]; len(l) == 0 || l[len(l)-1] != id {
+			ix.translations[g] = append(l, id)
+		}
+	}
+}
+
+func (ix *fuzzyIndex) remove(source string) {
+	if id, ok := ix.bySource[strings.ToLower(source)]; ok {
+		ix.docs[id].deleted = true
+	}
+}
+
+// search returns the phrases whose similarity to q's trigrams is at least min. A phrase that
+// reaches min shares at least need trigrams with q, so it is in one of the len(q)-need+1
+// shortest posting lists; only those are read, and at most suggestMaxCandidates phrases looked
+// at. One whose trigram count alone rules it out isn't scored.
+func (ix *fuzzyIndex) search(q []uint64, min float64) []suggestion {
+	lists := make([][]int32, 0, len(q))
+	for _, g := range q {
+		if l := ix.postings[g]; len(l) > 0 {
+			lists = append(lists, l)
+		}
+	}
+	need := max(int(math.Ceil(min*float64(len(q))/(2-min)-1e-9)), 1)
+	if len(lists) < need {
+		return nil
+	}
+	slices.SortFunc(lists, func(a, b []int32) int { return len(a) - len(b) })
+	shortest, longest := need, int(float64(len(q))*(2-min)/min+1e-9)
+	seen := make(map[int32]bool)
+	var out []suggestion
+	for _, l := range lists[:len(lists)-need+1] {
+		for _, id := range l {
+			if seen[id] {
+				continue
+			}
+			if len(seen) == suggestMaxCandidates {
+				return out
+			}
+			seen[id] = true
+			d := &ix.docs[id]
+			if d.deleted || len(d.grams) < shortest || len(d.grams) > longest {
+				continue
+			}
+			if s := dice(q, d.grams); s >= min {
+				out = append(out, suggestion{Q: d.source, Translation: d.translation, Similarity: s, From: d.from})
+			}
+		}
+	}
+	return out
+}
+
+// fuzzyIndexes is a fuzzyIndex per direction.
+type fuzzyIndexes map[langPair]*fuzzyIndex
+
+func (f fuzzyIndexes) put(p langPair, source, translation, from string) {
+	ix := f[p]
+	if ix == nil {
+		ix = newFuzzyIndex()
+		f[p] = ix
+	}
+	ix.put(source, translation, from)
+}
+
+// has reports whether source is indexed for p, deleted or not.
+func (f fuzzyIndexes) has(p langPair, source string) bool {
+	if ix := f[p]; ix != nil {
+		_, ok := ix.bySource[strings.ToLower(source)]
+		return ok
+	}
+	return false
+}
+
+func (f fuzzyIndexes) search(p langPair, q []uint64, min float64) []suggestion {
+	if ix := f[p]; ix != nil {
+		return ix.search(q, min)
+	}
+	return nil
+}
+
+// suggester finds suggestions for pack_only misses. packs and tm may be nil.
+type suggester struct {
+	packs         *packSet
+	tm            *translationMemory
+	minSimilarity float64 // SUGGEST_MIN_SIMILARITY
+	minLetters    int     // SUGGEST_MIN_CHARS: shorter input gets none, since almost anything is near it
+}
+
+// suggest returns the best suggestions for req, most similar first.
+func (s *suggester) suggest(req translateReq) []suggestion {
+	out := []suggestion{}
+	if s == nil {
+		return out
+	}
+	letters := 0
+	for _, r := range req.Q {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < s.minLetters {
+		return out
+	}
+	p, q := langPair{req.Src, req.Dst}, trigrams(req.Q)
+	if s.packs != nil {
+		ix := s.packs.current()
+		out = append(out, ix.fuzzy.search(p, q, s.minSimilarity)...)
+		if req.Extended {
+			out = append(out, ix.fuzzyExtended.search(p, q, s.minSimilarity)...)
+		}
+	}
+	if s.tm != nil {
+		out = append(out, s.tm.suggest(p, q, s.minSimilarity)...)
+	}
+	slices.SortFunc(out, func(a, b suggestion) int {
+		if a.Similarity != b.Similarity {
+			if a.Similarity > b.Similarity {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(strings.ToLower(a.Q), strings.ToLower(b.Q))
+	})
+	// The same phrase can be in a pack and the memory; the first, most similar, copy stays.
+	out = slices.CompactFunc(out, func(a, b suggestion) bool { return strings.EqualFold(a.Q, b.Q) })
+	if len(out) > suggestLimit {
+		out = out[:suggestLimit]
+	}
+	for i := range out {
+		out[i].Similarity = math.Round(out[i].Similarity*1000) / 1000
+	}
+	return out
+}
+
+// tmFuzzy is the translation memory's index, kept only when suggestions are on.
+type tmFuzzy struct {
+	mu  sync.RWMutex
+	idx fuzzyIndexes
+}
+
+// indexForSuggestions builds the memory's trigram index from every entry, and keeps it current
+// from then on; once built, later calls do nothing. The index is in place before the entries
+// are read, so what is learned or corrected meanwhile goes in first, and the older copy read
+// from the DB doesn't replace it.
+func (m *translationMemory) indexForSuggestions(ctx context.Context) error {
+	m.fuzzyMu.Lock()
+	defer m.fuzzyMu.Unlock()
+	if m.fuzzy.Load() != nil {
+		return nil
+	}
+	f := &tmFuzzy{idx: fuzzyIndexes{}}
+	m.fuzzy.Store(f)
+	err := m.export(ctx, func(e tmEntry) error {
+		p := langPair{e.Src, e.Dst}
+		f.mu.Lock()
+		if !f.idx.has(p, e.Source) {
+			f.idx.put(p, e.Source, e.Target, srcTM)
+		}
+		f.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		m.fuzzy.Store(nil)
+		return err
+	}
+	return nil
+}
+
+func (m *translationMemory) suggest(p langPair, q []uint64, min float64) []suggestion {
+	f := m.fuzzy.Load()
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.idx.search(p, q, min)
+}
+
+// indexed applies a change to the index, if there is one.
+func (m *translationMemory) indexed(fn func(fuzzyIndexes)) {
+	f := m.fuzzy.Load()
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f.idx)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// benchPack is a TSV pack of n distinct phrases of three made-up words each, and the phrases
+// in order.
+func benchPack(n int) (fstest.MapFS, []string) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	word := func() string {
+		w := make([]byte, 4+rnd.IntN(5))
+		for i := range w {
+			w[i] = "abdefghiklmnorstuvy"[rnd.IntN(19)]
+		}
+		return string(w)
+	}
+	var sb strings.Builder
+	seen := make(map[string]bool, n)
+	phrases := make([]string, 0, n)
+	for len(phrases) < n {
+		q := word() + " " + word() + " " + word()
+		if seen[q] {
+			continue
+		}
+		seen[q] = true
+		phrases = append(phrases, q)
+		sb.WriteString(q + "\tEN(" + q + ")\n")
+	}
+	return fstest.MapFS{"bench.dv-en.tsv": {Data: []byte(sb.String())}}, phrases
+}
+
+// BenchmarkPackSuggest loads a 100k-entry pack with its trigram index, and searches the index for
+// a misspelled phrase, so a lookup's cost can be seen not to grow with the pack.
+func BenchmarkPackSuggest(b *testing.B) {
+	const entries = 100_000
+	fsys, phrases := benchPack(entries)
+	def := langPair{"dv", "en"}
+	load := func(b *testing.B) *packIndex {
+		ix, err := loadPacks([]packSource{{fsys, packsExternal}}, def, keyFolding{}, true, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ix.indexForSuggestions()
+		return ix
+	}
+	b.Run("load", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if ix := load(b); ix.size() != entries {
+				b.Fatalf("%d entries, want %d", ix.size(), entries)
+			}
+		}
+	})
+
+	ix := load(b)
+	want := phrases[entries/2]
+	q := trigrams(want[:len(want)-1]) // the last letter dropped
+	b.Run("search", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			got := ix.fuzzy.search(def, q, 0.5)
+			if !slices.ContainsFunc(got, func(s suggestion) bool { return s.Q == want }) {
+				b.Fatalf("%q not suggested for its misspelling: %v", want, got)
+			}
+		}
+	})
+}
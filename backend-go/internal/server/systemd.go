@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// systemd integration, spoken directly so no dependency is needed: socket activation
+// (sd_listen_fds), readiness and stop notices (sd_notify) and the service watchdog. Each part is
+// a no-op unless systemd set up its environment, so the binary behaves the same elsewhere.
+
+// sdListenFDsStart is the first descriptor systemd passes, after stdin, stdout and stderr.
+const sdListenFDsStart = 3
+
+// systemdListener returns the socket systemd passed in, when LISTEN_PID names this process.
+// Only the first one is used. The variables are cleared so child processes don't take the
+// sockets for theirs. ok is false when the process wasn't socket-activated.
+func systemdListener() (ln net.Listener, ok bool, err error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, false, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, false, fmt.Errorf("systemd: LISTEN_FDS=%q passes no sockets", os.Getenv("LISTEN_FDS"))
+	}
+	for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(k)
+	}
+	if n > 1 {
+		slog.Warn("systemd passed more than one socket; using the first", "count", n)
+	}
+	for fd := sdListenFDsStart; fd < sdListenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+	}
+	f := os.NewFile(sdListenFDsStart, "systemd-socket")
+	defer f.Close() // FileListener holds its own copy of the descriptor
+	ln, err = net.FileListener(f)
+	if err != nil {
+		return nil, false, fmt.Errorf("systemd: socket %d: %w", sdListenFDsStart, err)
+	}
+	return ln, true, nil
+}
+
+// sdNotify sends state (READY=1, STOPPING=1, WATCHDOG=1, ...) to NOTIFY_SOCKET. It does nothing
+// when systemd isn't waiting for notices.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if strings.HasPrefix(addr, "@") { // abstract namespace
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	return nil
+}
+
+// sdWatchdogInterval returns how often to ping the watchdog: half of WATCHDOG_USEC, as
+// sd_watchdog_enabled advises. ok is false without a watchdog for this process.
+func sdWatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond / 2, true
+}
+
+// sdWatchdog pings the watchdog every interval for as long as healthy says so, until ctx ends.
+// A process that stops answering its health check stops pinging, and systemd restarts it.
+func sdWatchdog(ctx context.Context, interval time.Duration, healthy func(context.Context) bool) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		check, cancel := context.WithTimeout(ctx, interval)
+		ok := healthy(check)
+		cancel()
+		if !ok {
+			slog.Warn("health check failed; not pinging the systemd watchdog")
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			slog.Warn("systemd watchdog ping failed", "err", err)
+		}
+	}
+}
+
+// handlerHealthy reports whether h answers GET path with a 200 before ctx ends. It calls the
+// handler in-process, so the check sees a wedged handler without needing the listener.
+func handlerHealthy(h http.HandlerFunc, path string) func(context.Context) bool {
+	return func(ctx context.Context) bool {
+		done := make(chan int, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+			done <- rec.Code
+		}()
+		select {
+		case code := <-done:
+			return code == http.StatusOK
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSystemdListener passes a listening socket to a child test process as fd 3, the way
+// systemd does, and checks the child serves on it and clears the variables; in this process it
+// checks the environments that aren't socket activation.
+func TestSystemdListener(t *testing.T) {
+	if os.Getenv("SYSTEMD_LISTENER_CHILD") == "1" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		ln, ok, err := systemdListener()
+		if !ok || err != nil {
+			fmt.Printf("not activated: %v\n", err)
+			os.Exit(1)
+		}
+		if os.Getenv("LISTEN_PID") != "" || os.Getenv("LISTEN_FDS") != "" {
+			fmt.Println("LISTEN_* left set")
+			os.Exit(1)
+		}
+		http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			io.WriteString(w, "from fd 3")
+		}))
+		os.Exit(0)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	child := exec.Command(os.Args[0], "-test.run=^TestSystemdListener$")
+	child.Env = append(os.Environ(), "SYSTEMD_LISTENER_CHILD=1", "LISTEN_FDS=1")
+	child.ExtraFiles = []*os.File{f}
+	out := &strings.Builder{}
+	child.Stdout, child.Stderr = out, out
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	t.Cleanup(func() { child.Process.Kill(); child.Wait() })
+	res, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		child.Process.Kill()
+		child.Wait()
+		t.Fatalf("%v; child said:\n%s", err, out)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "from fd 3" {
+		t.Fatalf("answer %q through the passed socket", body)
+	}
+
+	for _, env := range []struct {
+		pid, fds string
+		ok       bool
+		err      bool
+	}{
+		{"", "", false, false},
+		{"1", "1", false, false}, // another process's sockets
+		{strconv.Itoa(os.Getpid()), "0", false, true},
+		{strconv.Itoa(os.Getpid()), "", false, true},
+	} {
+		t.Setenv("LISTEN_PID", env.pid)
+		t.Setenv("LISTEN_FDS", env.fds)
+		if _, ok, err := systemdListener(); ok != env.ok || (err != nil) != env.err {
+			t.Errorf("LISTEN_PID=%q LISTEN_FDS=%q: ok %v, err %v", env.pid, env.fds, ok, err)
+		}
+	}
+}
+
+// notifySocket listens where NOTIFY_SOCKET points for the test and returns the notices sent.
+func notifySocket(t *testing.T) chan string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	notices := make(chan string, 64)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			notices <- string(buf[:n])
+		}
+	}()
+	return notices
+}
+
+func nextNotice(t *testing.T, notices chan string) string {
+	t.Helper()
+	select {
+	case n := <-notices:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notice sent")
+		return ""
+	}
+}
+
+// TestSdNotify checks a server tells systemd READY=1 once it serves and STOPPING=1 when shutdown
+// begins, and that without NOTIFY_SOCKET nothing is sent.
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatalf("without NOTIFY_SOCKET: %v", err)
+	}
+
+	notices := notifySocket(t)
+	s := newTestServer(t, nil, Deps{})
+	s.notifyReady(context.Background())
+	if n := nextNotice(t, notices); n != "READY=1" {
+		t.Fatalf("notice %q after starting, want READY=1", n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Stop(ctx, StopCause{})
+	if n := nextNotice(t, notices); n != "STOPPING=1" {
+		t.Fatalf("notice %q at shutdown, want STOPPING=1", n)
+	}
+}
+
+// TestSdWatchdog checks the interval taken from WATCHDOG_USEC and that the watchdog is pinged
+// only while the health check passes.
+func TestSdWatchdog(t *testing.T) {
+	for _, env := range []struct {
+		usec, pid string
+		want      time.Duration // zero when there's no watchdog
+	}{
+		{"", "", 0},
+		{"0", "", 0},
+		{"4000000", "", 2 * time.Second},
+		{"4000000", strconv.Itoa(os.Getpid()), 2 * time.Second},
+		{"4000000", "1", 0}, // another process's watchdog
+	} {
+		t.Setenv("WATCHDOG_USEC", env.usec)
+		t.Setenv("WATCHDOG_PID", env.pid)
+		if got, ok := sdWatchdogInterval(); got != env.want || ok != (env.want > 0) {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: %v, %v; want %v", env.usec, env.pid, got, ok, env.want)
+		}
+	}
+
+	notices := notifySocket(t)
+	var healthy atomic.Bool
+	healthy.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sdWatchdog(ctx, 10*time.Millisecond, func(context.Context) bool { return healthy.Load() })
+	if n := nextNotice(t, notices); n != "WATCHDOG=1" {
+		t.Fatalf("notice %q while healthy, want WATCHDOG=1", n)
+	}
+	healthy.Store(false)
+	time.Sleep(30 * time.Millisecond) // a ping already under way may still land
+	for len(notices) > 0 {
+		<-notices
+	}
+	select {
+	case n := <-notices:
+		t.Fatalf("notice %q while unhealthy", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+	healthy.Store(true)
+	if n := nextNotice(t, notices); n != "WATCHDOG=1" {
+		t.Fatalf("notice %q once healthy again, want WATCHDOG=1", n)
+	}
+}
+
+func TestHandlerHealthy(t *testing.T) {
+	ok := handlerHealthy(func(w http.ResponseWriter, _ *http.Request) {}, "/go/health")
+	down := handlerHealthy(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) }, "/go/health")
+	wedged := handlerHealthy(func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done(); time.Sleep(time.Second) }, "/go/health")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if !ok(ctx) || down(ctx) || wedged(ctx) {
+		t.Fatalf("healthy: ok %v, 503 %v, wedged %v", ok(ctx), down(ctx), wedged(ctx))
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Throttling responses. The per-key and per-IP rate limiters and the daily quota all answer
+// through writeThrottled, so a client sees one 429 shape whichever said "slow down":
+//
+//	{"error": {"code": "RATE_LIMITED", "message": "...", "dimension": "key", "limit": 60,
+//	  "used": 60, "reset_at": "...", "retry_after": 2}}
+//
+// dimension is what ran out. The edge worker shows an upgrade prompt for key, quota and
+// concurrency, where a pro key would help, and a plain retry for ip.
+
+// Throttling dimensions.
+const (
+	dimensionKey         = "key"         // the API key's request rate
+	dimensionIP          = "ip"          // the client IP's request rate, for callers without a key
+	dimensionQuota       = "quota"       // the key's daily characters
+	dimensionConcurrency = "concurrency" // the key's requests in flight at once
+)
+
+var throttleDimensions = []string{dimensionKey, dimensionIP, dimensionQuota, dimensionConcurrency}
+
+// throttle is one refusal. Limit and Used are requests for the rate limiters (the bucket size
+// and what of it is spent) and the concurrency cap, and characters for the quota.
+type throttle struct {
+	Dimension  string
+	Limit      int64
+	Used       int64
+	Reset      time.Time     // when the limit is fully available again
+	RetryAfter time.Duration // when trying again can succeed
+}
+
+type retryAfterMaxKey struct{}
+
+// capRetryAfter puts ceil, a Server's RETRY_AFTER_MAX as a time.Duration, in each request's
+// context for retryAfterSeconds. The cap keeps a client honoring Retry-After from parking for
+// the rest of the day on a quota; reset_at still says when the limit really resets.
+func capRetryAfter(ceil *atomic.Int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), retryAfterMaxKey{}, ceil)))
+		})
+	}
+}
+
+// retryAfterSeconds is d in whole seconds, rounded up and held to the cap in ctx, if any.
+func retryAfterSeconds(ctx context.Context, d time.Duration) int {
+	secs := int(math.Ceil(d.Seconds()))
+	if ceil, _ := ctx.Value(retryAfterMaxKey{}).(*atomic.Int64); ceil != nil {
+		if m := time.Duration(ceil.Load()); m > 0 {
+			secs = min(secs, int(math.Ceil(m.Seconds())))
+		}
+	}
+	return max(secs, 1)
+}
+
+// writeThrottled answers 429 with t in the body and a numeric Retry-After. details are added as
+// with writeError.
+func writeThrottled(ctx context.Context, w http.ResponseWriter, code errorCode, msg string, t throttle, details ...any) {
+	retry := retryAfterSeconds(ctx, t.RetryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	details = append(details,
+		"dimension", t.Dimension,
+		"limit", t.Limit,
+		"used", t.Used,
+		"reset_at", t.Reset.UTC().Format(time.RFC3339),
+		"retry_after", retry,
+	)
+	writeError(w, http.StatusTooManyRequests, code, msg, details...)
+}
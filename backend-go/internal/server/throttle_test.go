@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestThrottleEnvelope runs a key out of its rate, an address out of the health limit and a key
+// out of its daily quota, and checks all three answer 429 with the same fields and a Retry-After
+// equal to the body's retry_after, held to RETRY_AFTER_MAX while reset_at stays exact.
+func TestThrottleEnvelope(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := newTestServer(t, map[string]string{
+		"RATE_LIMIT_PER_MIN":        "60",
+		"RATE_LIMIT_BURST":          "1",
+		"RATE_LIMIT_HEALTH_PER_MIN": "60",
+		"RATE_LIMIT_HEALTH_BURST":   "1",
+		"QUOTA_CHARS_PRO":           "6",
+		"RETRY_AFTER_MAX":           "30s",
+	}, Deps{Clock: clk}).Handler()
+
+	tests := []struct {
+		dimension     string
+		first, second string // targets: the one that spends the limit, then the one refused
+		key           string
+		code          errorCode
+		limit, used   int64
+		resetAt       string
+		retry         int
+	}{
+		{dimensionKey, "/go/translate?q=salaam", "/go/translate?q=salaam", testFreeKey, codeRateLimited, 1, 1, "2026-03-01T12:00:01Z", 1},
+		{dimensionIP, "/go/health", "/go/health", "", codeRateLimited, 1, 1, "2026-03-01T12:00:01Z", 1},
+		{dimensionQuota, "/go/translate?q=salaam", "/go/translate?q=kihineh", testProKey, codeQuotaExceeded, 6, 6, "2026-03-02T00:00:00Z", 30},
+	}
+	var shape []string
+	for _, tt := range tests {
+		t.Run(tt.dimension, func(t *testing.T) {
+			if w := serve(h, "GET", tt.first, "", "X-API-Key", tt.key); w.Code != http.StatusOK {
+				t.Fatalf("%s: status %d: %s", tt.first, w.Code, w.Body.String())
+			}
+			w := serve(h, "GET", tt.second, "", "X-API-Key", tt.key)
+			if w.Code != http.StatusTooManyRequests {
+				t.Fatalf("%s: status %d, want 429: %s", tt.second, w.Code, w.Body.String())
+			}
+			checkEnvelope(t, w)
+			var res struct {
+				Error map[string]any `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+			e := res.Error
+			if e["code"] != string(tt.code) || e["dimension"] != tt.dimension || e["limit"] != float64(tt.limit) || e["used"] != float64(tt.used) ||
+				e["reset_at"] != tt.resetAt || e["retry_after"] != float64(tt.retry) {
+				t.Fatalf("body %s; want %s on %s, limit %d, used %d, reset_at %s, retry_after %d", w.Body.String(), tt.code, tt.dimension, tt.limit, tt.used, tt.resetAt, tt.retry)
+			}
+			if got := w.Header().Get("Retry-After"); got != strconv.Itoa(tt.retry) {
+				t.Fatalf("Retry-After %q, want %d", got, tt.retry)
+			}
+			if tier, _ := e["tier"].(string); (tt.dimension == dimensionQuota) != (tier == tierPro) {
+				t.Fatalf("tier %q on a %s refusal; only the quota names the tier", tier, tt.dimension)
+			}
+			delete(e, "tier")
+			var keys []string
+			for k := range e {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			if shape == nil {
+				shape = keys
+			} else if !slices.Equal(keys, shape) {
+				t.Fatalf("fields %v, want %v as the other limiters send", keys, shape)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// timeoutMargin is kept back from a route's budget so the work under it (the upstream call
+// included) gives up before the response deadline, leaving time to write a proper error.
+const timeoutMargin = 250 * time.Millisecond
+
+// deadlineHeader is how long the caller will wait, in milliseconds. The edge worker sends what
+// is left of its own timeout, so the origin doesn't go on working for an answer nobody reads.
+const deadlineHeader = "X-Deadline-Ms"
+
+// Where a request's budget came from.
+const (
+	deadlineRoute  = "route"  // the route's timeout
+	deadlineCaller = "header" // X-Deadline-Ms
+)
+
+// requestDeadline is the budget routeTimeout gave a request, for the 504, the request log line
+// and the provenance.
+type requestDeadline struct {
+	MS     float64   `json:"ms"`
+	Source string    `json:"source"` // route or header
+	at     time.Time // when the handlers' context ends, the margin before the budget does
+}
+
+type deadlineCtxKey struct{}
+
+func deadlineFrom(ctx context.Context) (requestDeadline, bool) {
+	d, ok := ctx.Value(deadlineCtxKey{}).(requestDeadline)
+	return d, ok
+}
+
+// routeTimeout bounds a route group at d. Handlers see a context deadline of d less the margin;
+// the connection's write deadline is moved to d, which also lets routes outlast the server's
+// WriteTimeout. A handler that gives up without writing anything gets a JSON 504.
+//
+// A request with X-Deadline-Ms gets that budget instead, raised to floor and capped at d. An
+// absent or invalid header leaves d.
+func routeTimeout(d, floor time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			budget, source := d, deadlineRoute
+			if n, err := strconv.ParseInt(r.Header.Get(deadlineHeader), 10, 64); err == nil && n > 0 {
+				budget, source = min(max(time.Duration(n)*time.Millisecond, floor), d), deadlineCaller
+			}
+			margin := min(timeoutMargin, budget/10)
+			dl := requestDeadline{MS: ms(budget), Source: source, at: start.Add(budget - margin)}
+			if meta := requestMetaFrom(r.Context()); meta != nil {
+				meta.Deadline = &dl
+			}
+			_ = http.NewResponseController(w).SetWriteDeadline(start.Add(budget))
+			ctx, cancel := context.WithDeadline(context.WithValue(r.Context(), deadlineCtxKey{}, dl), dl.at)
+			defer cancel()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+			if ww.Status() == 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				writeTimeout(ctx, w)
+			}
+		})
+	}
+}
+
+// writeTimeout is the 504 for a request whose budget ran out: DEADLINE_EXCEEDED when the caller
+// set it, TIMEOUT when the route did.
+func writeTimeout(ctx context.Context, w http.ResponseWriter) {
+	if dl, ok := deadlineFrom(ctx); ok && dl.Source == deadlineCaller {
+		writeError(w, http.StatusGatewayTimeout, codeDeadlineExceeded, "the request's deadline passed before it could be answered", "deadline_ms", dl.MS)
+		return
+	}
+	writeError(w, http.StatusGatewayTimeout, codeTimeout, "request timed out")
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// volatileFields matches the request_id and ts members of a response body, with their comma.
+var volatileFields = regexp.MustCompile(`,?"(request_id|ts)":"[^"]*"`)
+
+// TestRouteTimeouts gives each route group a short budget from the env and checks single and
+// batch translation are cut off at their own, with a JSON 504 in both API versions and the
+// upstream given the budget less the margin, and that health and version run under theirs.
+func TestRouteTimeouts(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		told = map[string]int{} // X-Deadline-Ms by phrase
+	)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		ms, _ := strconv.Atoi(r.Header.Get(deadlineHeader))
+		mu.Lock()
+		told[r.URL.Query().Get("q")] = ms
+		mu.Unlock()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer up.Close()
+	h := newTestServer(t, map[string]string{
+		"UPSTREAM_URL":          up.URL,
+		"HEALTH_TIMEOUT":        "150ms",
+		"TRANSLATE_TIMEOUT":     "300ms",
+		"BATCH_TIMEOUT":         "900ms",
+		"UPSTREAM_MAX_ATTEMPTS": "1",
+		"UPSTREAM_TIMEOUT":      "10s",
+	}, Deps{}).Handler()
+
+	tests := []struct {
+		name, method, target, body, q string
+		budget                        time.Duration
+		status                        int
+		want                          string // the body, less its request_id or ts
+	}{
+		{"translate", "GET", "/go/translate?q=slow", "", "slow", 300 * time.Millisecond, http.StatusGatewayTimeout,
+			`{"error":{"code":"TIMEOUT","message":"request timed out"}}`},
+		{"v2 translate", "GET", "/go/v2/translate?q=slow+v2", "", "slow v2", 300 * time.Millisecond, http.StatusGatewayTimeout,
+			`{"error":{"code":"TIMEOUT","message":"request timed out","status":504}}`},
+		// A batch answers what it has once its budget is spent, each unfinished item with its error.
+		{"batch", "POST", "/go/translate/batch", `{"items":[{"id":"1","q":"slow batch"}]}`, "slow batch", 900 * time.Millisecond, http.StatusOK,
+			`{"count":1,"results":{"1":{"error":"upstream: timeout"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			w := serve(h, tt.method, tt.target, tt.body, "X-API-Key", testProKey, "Content-Type", "application/json")
+			took := time.Since(start)
+			ct := w.Header().Get("Content-Type")
+			body := volatileFields.ReplaceAllString(strings.TrimSpace(w.Body.String()), "")
+			if w.Code != tt.status || !strings.HasPrefix(ct, "application/json") || body != tt.want {
+				t.Fatalf("status %d, Content-Type %q: %s; want %d with %s", w.Code, ct, w.Body.String(), tt.status, tt.want)
+			}
+			margin := min(timeoutMargin, tt.budget/10)
+			mu.Lock()
+			left := time.Duration(told[tt.q]) * time.Millisecond
+			mu.Unlock()
+			if want := tt.budget - margin - upstreamDeadlineMargin; left > want || left < want-100*time.Millisecond {
+				t.Fatalf("upstream told %v in %s, want about %v", left, deadlineHeader, want)
+			}
+			if took < tt.budget-margin || took > tt.budget+500*time.Millisecond {
+				t.Fatalf("cut off after %v, want about %v", took, tt.budget)
+			}
+		})
+	}
+
+	for _, target := range []string{"/go/health", "/go/version"} {
+		mark := suiteLog.mark()
+		if w := serve(h, "GET", target, ""); w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", target, w.Code)
+		}
+		if logged := suiteLog.since(mark); !strings.Contains(logged, `"deadline_ms":150,"deadline_source":"route"`) {
+			t.Fatalf("%s not run under HEALTH_TIMEOUT:\n%s", target, logged)
+		}
+	}
+}
+
+// TestDeadlineHeader sends X-Deadline-Ms against an upstream with a simulated latency per
+// phrase: a deadline shorter than the latency gets a 504 DEADLINE_EXCEEDED carrying the budget,
+// one below DEADLINE_FLOOR is raised to it, one past the route's timeout is capped there, and an
+// absent or invalid header leaves the route's. The upstream is told the budget less both
+// margins, and the log line and the provenance say what the budget was and where it came from.
+func TestDeadlineHeader(t *testing.T) {
+	latency := map[string]time.Duration{
+		"slow call": time.Second, "floor fast": 100 * time.Millisecond, "floor slow": 300 * time.Millisecond,
+	}
+	var (
+		mu   sync.Mutex
+		told = map[string]int{} // X-Deadline-Ms by phrase
+	)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		q := r.URL.Query().Get("q")
+		ms, _ := strconv.Atoi(r.Header.Get(deadlineHeader))
+		mu.Lock()
+		told[q] = ms
+		mu.Unlock()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(latency[q]):
+		}
+		w.Write([]byte(`{"ok":true,"data":{"tgt":"T"}}`))
+	}))
+	defer up.Close()
+	h := newTestServer(t, map[string]string{
+		"UPSTREAM_URL":          up.URL,
+		"TRANSLATE_TIMEOUT":     "2s",
+		"DEADLINE_FLOOR":        "200ms",
+		"UPSTREAM_MAX_ATTEMPTS": "1",
+		"UPSTREAM_TIMEOUT":      "10s",
+	}, Deps{}).Handler()
+	translate := func(q string, headers ...string) (*httptest.ResponseRecorder, string, time.Duration) {
+		t.Helper()
+		mark := suiteLog.mark()
+		start := time.Now()
+		w := serve(h, "GET", "/go/translate?src=dv&dst=en&debug=1&q="+strings.ReplaceAll(q, " ", "+"), "", append([]string{"X-API-Key", testProKey}, headers...)...)
+		return w, suiteLog.since(mark), time.Since(start)
+	}
+	toldFor := func(q string) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		return time.Duration(told[q]) * time.Millisecond
+	}
+
+	// 400ms against a 1s upstream: cut off at 360ms, the upstream told 310ms.
+	w, logged, took := translate("slow call", deadlineHeader, "400")
+	body := volatileFields.ReplaceAllString(strings.TrimSpace(w.Body.String()), "")
+	if want := `{"error":{"code":"DEADLINE_EXCEEDED","deadline_ms":400,"message":"the request's deadline passed before it could be answered"}}`; w.Code != http.StatusGatewayTimeout || body != want {
+		t.Fatalf("400ms deadline, 1s upstream: status %d: %s; want 504 with %s", w.Code, w.Body.String(), want)
+	}
+	checkEnvelope(t, w)
+	if took < 360*time.Millisecond || took > 800*time.Millisecond {
+		t.Fatalf("400ms deadline answered after %v", took)
+	}
+	if left := toldFor("slow call"); left > 310*time.Millisecond || left < 250*time.Millisecond {
+		t.Fatalf("upstream told %v in %s, want about 310ms", left, deadlineHeader)
+	}
+	if !strings.Contains(logged, `"deadline_ms":400,"deadline_source":"header"`) {
+		t.Fatalf("the header's deadline wasn't logged:\n%s", logged)
+	}
+
+	// 5ms is raised to the 200ms floor: enough for a 100ms upstream, not for a 300ms one.
+	if w, _, _ := translate("floor fast", deadlineHeader, "5"); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"deadline":{"ms":200,"source":"header"}`) {
+		t.Fatalf("5ms deadline, 100ms upstream: status %d: %s; want it answered under the 200ms floor", w.Code, w.Body.String())
+	}
+	if w, _, _ := translate("floor slow", deadlineHeader, "5"); w.Code != http.StatusGatewayTimeout ||
+		!strings.Contains(w.Body.String(), `"code":"DEADLINE_EXCEEDED","deadline_ms":200`) {
+		t.Fatalf("5ms deadline, 300ms upstream: status %d: %s", w.Code, w.Body.String())
+	}
+
+	// A minute is capped at the 2s route timeout, still counted as the header's.
+	if w, logged, _ := translate("capped call", deadlineHeader, "60000"); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"deadline":{"ms":2000,"source":"header"}`) || !strings.Contains(logged, `"deadline_ms":2000,"deadline_source":"header"`) {
+		t.Fatalf("60000ms deadline: status %d: %s\n%s", w.Code, w.Body.String(), logged)
+	}
+	if left := toldFor("capped call"); left > 1750*time.Millisecond || left < 1650*time.Millisecond {
+		t.Fatalf("upstream told %v under a capped deadline, want about 1.75s", left)
+	}
+
+	for n, header := range [][]string{nil, {deadlineHeader, "abc"}, {deadlineHeader, "0"}, {deadlineHeader, "-50"}, {deadlineHeader, "1.5"}} {
+		w, logged, _ := translate(consonantWord(n), header...)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deadline":{"ms":2000,"source":"route"}`) ||
+			!strings.Contains(logged, `"deadline_ms":2000,"deadline_source":"route"`) {
+			t.Fatalf("%s %q: status %d: %s\n%s; want the route's 2s", deadlineHeader, header, w.Code, w.Body.String(), logged)
+		}
+	}
+}
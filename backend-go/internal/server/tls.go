@@ -0,0 +1,76 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsConfig is the baseline for serving HTTPS: TLS 1.2 or later, and for 1.2 only AEAD suites
+// with forward secrecy (1.3's suites aren't configurable and are all fine).
+func tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+}
+
+// tlsSetup decides how srv serves its listener: plain HTTP when no TLS variable is set, HTTPS
+// from TLS_CERT_FILE/TLS_KEY_FILE, or HTTPS with certificates from Let's Encrypt for
+// AUTOCERT_DOMAINS. In the last case it also returns the plain-HTTP server on AUTOCERT_HTTP_PORT
+// that answers ACME challenges and redirects everything else to HTTPS; it is nil otherwise.
+func tlsSetup(cfg Config, srv *http.Server) (serve func(net.Listener) error, redirect *http.Server) {
+	switch {
+	case cfg.TLSCertFile != "":
+		srv.TLSConfig = tlsConfig()
+		return func(ln net.Listener) error { return srv.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile) }, nil
+	case len(cfg.AutocertDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		srv.TLSConfig = tlsConfig()
+		srv.TLSConfig.GetCertificate = m.GetCertificate
+		srv.TLSConfig.NextProtos = append(srv.TLSConfig.NextProtos, acme.ALPNProto)
+		redirect = &http.Server{
+			Addr:              ":" + cfg.AutocertHTTPPort,
+			Handler:           m.HTTPHandler(redirectToHTTPS(cfg.Port)),
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       10 * time.Second,
+			WriteTimeout:      10 * time.Second,
+			IdleTimeout:       60 * time.Second,
+		}
+		return func(ln net.Listener) error { return srv.ServeTLS(ln, "", "") }, redirect
+	}
+	return srv.Serve, nil
+}
+
+// redirectToHTTPS sends every request to the same URL on https, at port unless it is 443.
+// 308 keeps the method and body, so a POST sent to http:// isn't silently turned into a GET.
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,463 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Translation memory. Every upstream translation is kept in SQLite (TM_DB_PATH), keyed by the
+// direction and the normalized source, and answers that source from then on instead of the
+// upstream. Unlike the cache, entries never expire: the memory is the record of what a phrase
+// translates to, and an admin corrects it under /go/admin/tm. A correction is served, flagged
+// src "tm_corrected", from the next request on. The memory sits below the cache, so a hit is
+// cached like an upstream answer, and a correction or delete drops the cached copies.
+
+// Result sources for memory hits.
+const (
+	srcTM          = "tm"
+	srcTMCorrected = "tm_corrected"
+)
+
+// tmEntry is one remembered translation.
+type tmEntry struct {
+	Src       string    `json:"src"`
+	Dst       string    `json:"dst"`
+	Source    string    `json:"q"`
+	Target    string    `json:"translation"`
+	Corrected bool      `json:"corrected"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// translationMemory is the store. def is the direction admin requests get without src and dst.
+// What the upstream translates is learned through a writeQueue; corrections and deletes are
+// written right away.
+type translationMemory struct {
+	db     *sql.DB
+	def    langPair
+	learns *writeQueue[tmLearn]
+	clock  Clock // stamps created_at and updated_at
+
+	fuzzy   atomic.Pointer[tmFuzzy] // nil until indexForSuggestions
+	fuzzyMu sync.Mutex              // held while building it
+}
+
+// tmLearn is a queued upstream translation.
+type tmLearn struct {
+	p              langPair
+	source, target string
+}
+
+func tmLearnKey(l tmLearn) string { return l.p.Src + "\x00" + l.p.Dst + "\x00" + l.source }
+
+// keepFirstLearn coalesces learns of a source as the store does: the first one stays.
+func keepFirstLearn(prev, _ tmLearn) tmLearn { return prev }
+
+// openTranslationMemory opens or creates the memory DB at path and starts its writer.
+func openTranslationMemory(path string, def langPair, writes writeQueueOpts, clk Clock) (*translationMemory, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate(db, "tm", clk); err != nil {
+		db.Close()
+		return nil, err
+	}
+	m := &translationMemory{db: db, def: def, clock: clk}
+	m.learns = newWriteQueue("tm", writes, tmLearnKey, keepFirstLearn, m.write)
+	return m, nil
+}
+
+// drain applies the queued learns, until ctx is done; see writeQueue.drain.
+func (m *translationMemory) drain(ctx context.Context) error { return m.learns.drain(ctx) }
+
+// Close applies the queued learns, unless a drain ran out of time, and closes the DB.
+func (m *translationMemory) Close() error {
+	m.learns.drain(context.Background())
+	return m.db.Close()
+}
+
+func (m *translationMemory) Ping(ctx context.Context) error { return m.db.PingContext(ctx) }
+
+const tmColumns = `src, dst, source, target, corrected, updated_by, created_at, updated_at`
+
+func scanTMEntry(row interface{ Scan(...any) error }) (tmEntry, error) {
+	var (
+		e                tmEntry
+		created, updated int64
+	)
+	err := row.Scan(&e.Src, &e.Dst, &e.Source, &e.Target, &e.Corrected, &e.UpdatedBy, &created, &updated)
+	e.CreatedAt, e.UpdatedAt = time.Unix(created, 0), time.Unix(updated, 0)
+	return e, err
+}
+
+// lookup finds source in direction p. It is one primary-key read.
+func (m *translationMemory) lookup(ctx context.Context, p langPair, source string) (tmEntry, bool, error) {
+	e, err := scanTMEntry(m.db.QueryRowContext(ctx,
+		`SELECT `+tmColumns+` FROM translation_memory WHERE src = ? AND dst = ? AND source = ?`,
+		p.Src, p.Dst, source))
+	if errors.Is(err, sql.ErrNoRows) {
+		return e, false, nil
+	}
+	return e, err == nil, err
+}
+
+// learn queues an upstream translation to keep. An existing entry, corrected or not, is left
+// alone. A learn still queued when the source is corrected or deleted doesn't undo that.
+func (m *translationMemory) learn(p langPair, source, target string) {
+	m.learns.enqueue(tmLearn{p, source, target})
+}
+
+// write applies one batch of learns in a transaction, and indexes the new entries once it commits.
+func (m *translationMemory) write(ls []tmLearn) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := m.clock.Now().Unix()
+	var added []tmLearn
+	for _, l := range ls {
+		res, err := tx.Exec(`INSERT INTO translation_memory (src, dst, source, target, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			l.p.Src, l.p.Dst, l.source, l.target, now, now)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added = append(added, l)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.indexed(func(f fuzzyIndexes) {
+		for _, l := range added {
+			f.put(l.p, l.source, l.target, srcTM)
+		}
+	})
+	return nil
+}
+
+// correct sets the target for source, adding the entry when the upstream never answered it.
+func (m *translationMemory) correct(ctx context.Context, p langPair, source, target, by string) (tmEntry, error) {
+	now := m.clock.Now().Unix()
+	e, err := scanTMEntry(m.db.QueryRowContext(ctx,
+		`INSERT INTO translation_memory (src, dst, source, target, corrected, updated_by, created_at, updated_at)
+			VALUES (?, ?, ?, ?, 1, ?, ?, ?)
+			ON CONFLICT DO UPDATE SET target = excluded.target, corrected = 1,
+				updated_by = excluded.updated_by, updated_at = excluded.updated_at
+			RETURNING `+tmColumns,
+		p.Src, p.Dst, source, target, by, now, now))
+	if err == nil {
+		m.indexed(func(f fuzzyIndexes) { f.put(p, source, target, srcTM) })
+	}
+	return e, err
+}
+
+// delete removes source, so the next request for it asks the upstream again.
+func (m *translationMemory) delete(ctx context.Context, p langPair, source string) (bool, error) {
+	res, err := m.db.ExecContext(ctx,
+		`DELETE FROM translation_memory WHERE src = ? AND dst = ? AND source = ?`, p.Src, p.Dst, source)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if n > 0 {
+		m.indexed(func(f fuzzyIndexes) {
+			if ix := f[p]; ix != nil {
+				ix.remove(source)
+			}
+		})
+	}
+	return n > 0, err
+}
+
+// tmFilter narrows list. Empty fields match everything.
+type tmFilter struct {
+	Pair      langPair
+	Contains  string    // substring of the source or the translation
+	Corrected bool      // only corrected entries
+	Since     time.Time // only entries created at or after it
+}
+
+func (f tmFilter) where() (string, []any) {
+	var (
+		conds []string
+		args  []any
+	)
+	if f.Pair.Src != "" {
+		conds, args = append(conds, "src = ?"), append(args, f.Pair.Src)
+	}
+	if f.Pair.Dst != "" {
+		conds, args = append(conds, "dst = ?"), append(args, f.Pair.Dst)
+	}
+	if f.Contains != "" {
+		like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Contains) + "%"
+		conds, args = append(conds, `(source LIKE ? ESCAPE '\' OR target LIKE ? ESCAPE '\')`), append(args, like, like)
+	}
+	if f.Corrected {
+		conds = append(conds, "corrected = 1")
+	}
+	if !f.Since.IsZero() {
+		conds, args = append(conds, "created_at >= ?"), append(args, f.Since.Unix())
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// list returns the page req asks for of entries matching f, newest first, and how many match.
+func (m *translationMemory) list(ctx context.Context, f tmFilter, req pageReq) ([]tmEntry, int, error) {
+	where, args := f.where()
+	var total int
+	if err := m.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM translation_memory`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	where, args = seekAfter(where, args, req, "src", "dst", "source")
+	rows, err := m.db.QueryContext(ctx,
+		`SELECT `+tmColumns+` FROM translation_memory`+where+`
+			ORDER BY created_at DESC, src DESC, dst DESC, source DESC LIMIT ?`,
+		append(args, req.Limit+1)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	entries := []tmEntry{}
+	for rows.Next() {
+		e, err := scanTMEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+// export calls fn for every entry in key order, reading a page at a time like sqliteCache.Export.
+func (m *translationMemory) export(ctx context.Context, fn func(tmEntry) error) error {
+	var after tmEntry
+	for {
+		rows, err := m.db.QueryContext(ctx,
+			`SELECT `+tmColumns+` FROM translation_memory WHERE (src, dst, source) > (?, ?, ?)
+				ORDER BY src, dst, source LIMIT ?`,
+			after.Src, after.Dst, after.Source, exportPage)
+		if err != nil {
+			return err
+		}
+		var page []tmEntry
+		for rows.Next() {
+			e, err := scanTMEntry(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			page = append(page, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, e := range page {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPage {
+			return nil
+		}
+		after = page[len(page)-1]
+	}
+}
+
+// tmTranslator answers from the memory and teaches it what next, the upstream, returns. N-best
+// requests pass straight through: the memory keeps one translation per phrase. The tier isn't
+// part of the key, so a phrase is learned once, whichever upstream answered it.
+type tmTranslator struct {
+	tm   *translationMemory
+	next Translator
+}
+
+func (t *tmTranslator) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	if req.NBest > 0 {
+		return t.next.Translate(ctx, req)
+	}
+	p := langPair{req.Src, req.Dst}
+	stage := startStage(ctx, "tm")
+	e, ok, err := t.tm.lookup(ctx, p, req.Q)
+	if err != nil && ctx.Err() == nil {
+		slog.Warn("tm read failed, falling through", "request_id", middleware.GetReqID(ctx), "err", err)
+	}
+	if ok {
+		metricTMHits.Inc()
+		res := translateResult{Translation: e.Target, Src: srcTM}
+		if e.Corrected {
+			res.Src = srcTMCorrected
+		}
+		stage.end(stageHit, res.Src)
+		return res, nil
+	}
+	stage.end(stageMiss, "")
+	res, err := t.next.Translate(ctx, req)
+	if err == nil && res.Src == "upstream" {
+		t.tm.learn(p, req.Q, res.Translation)
+	}
+	return res, err
+}
+
+// tmTarget reads the phrase an admin request names: q, src and dst from the query or body,
+// normalized the way translate requests are so it finds the same entry.
+func (m *translationMemory) tmTarget(q, src, dst string) (langPair, string, *httpError) {
+	source, ok := normalizeText(q)
+	if !ok {
+		return langPair{}, "", &httpError{http.StatusBadRequest, codeInvalidUTF8, "q is not valid UTF-8"}
+	}
+	if source == "" {
+		return langPair{}, "", &httpError{http.StatusBadRequest, codeMissingQuery, "missing field 'q'"}
+	}
+	p, err := resolvePair(src, dst, m.def)
+	if err != nil {
+		return langPair{}, "", &httpError{http.StatusBadRequest, codeUnsupportedPair, err.Error()}
+	}
+	return p, source, nil
+}
+
+// forgetCached drops the cached copies of source in both tiers' variants, so a change to the
+// memory is what the next request sees.
+func forgetCached(ctx context.Context, ct *cachedTranslator, p langPair, source string) {
+	for _, extended := range []bool{false, true} {
+		if _, err := ct.invalidate(ctx, translateReq{Q: source, Src: p.Src, Dst: p.Dst, Extended: extended}); err != nil {
+			slog.Warn("tm: cache invalidate failed", "request_id", middleware.GetReqID(ctx), "err", err)
+		}
+	}
+}
+
+// tmListHandler serves GET /go/admin/tm[?src=&dst=&q=&corrected=1&since=&limit=&cursor=],
+// newest first and paged (see paging.go). q matches a substring of the source or the
+// translation; total counts every entry the filters match.
+func tmListHandler(m *translationMemory, pg *pager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, herr := pg.readPage(r, "tm", "src", "dst", "q", "corrected")
+		if herr != nil {
+			herr.write(w)
+			return
+		}
+		q := r.URL.Query()
+		f := tmFilter{
+			Pair:      langPair{strings.ToLower(q.Get("src")), strings.ToLower(q.Get("dst"))},
+			Contains:  q.Get("q"),
+			Corrected: queryBool(q.Get("corrected")),
+			Since:     req.Since,
+		}
+		entries, total, err := m.list(r.Context(), f, req)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "tm read failed", "detail", err.Error())
+			return
+		}
+		entries, next := pageTail(pg, req, entries, func(e tmEntry) pageKey {
+			return pageKey{At: e.CreatedAt.UnixNano(), ID: []string{e.Src, e.Dst, e.Source}}
+		})
+		j(w, http.StatusOK, pageBody(map[string]any{"entries": entries, "count": len(entries), "total": total}, next))
+	}
+}
+
+// tmCorrectReq is the body of PUT /go/admin/tm.
+type tmCorrectReq struct {
+	Q           string `json:"q"`
+	Src         string `json:"src"`
+	Dst         string `json:"dst"`
+	Translation string `json:"translation"`
+}
+
+// tmCorrectHandler serves PUT /go/admin/tm: it overwrites the entry's translation, or adds one.
+func tmCorrectHandler(m *translationMemory, ct *cachedTranslator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body tmCorrectReq
+		if herr := decodeJSONBody(r, &body); herr != nil {
+			herr.write(w)
+			return
+		}
+		p, source, herr := m.tmTarget(body.Q, body.Src, body.Dst)
+		if herr != nil {
+			herr.write(w)
+			return
+		}
+		target, _ := normalizeText(body.Translation)
+		if target == "" {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "missing field 'translation'")
+			return
+		}
+		admin := adminFrom(r.Context())
+		e, err := m.correct(r.Context(), p, source, target, admin)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "tm save failed", "detail", err.Error())
+			return
+		}
+		forgetCached(r.Context(), ct, p, source)
+		auditParam(r.Context(), "direction", p.Src+"-"+p.Dst)
+		auditParam(r.Context(), "q", describeContent(source))
+		auditParam(r.Context(), "translation", describeContent(target))
+		slog.Info("tm entry corrected", "request_id", middleware.GetReqID(r.Context()), "admin_id", admin, "direction", p.Src+"-"+p.Dst)
+		j(w, http.StatusOK, e)
+	}
+}
+
+// tmDeleteHandler serves DELETE /go/admin/tm?q=[&src=&dst=].
+func tmDeleteHandler(m *translationMemory, ct *cachedTranslator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		p, source, herr := m.tmTarget(q.Get("q"), q.Get("src"), q.Get("dst"))
+		if herr != nil {
+			herr.write(w)
+			return
+		}
+		ok, err := m.delete(r.Context(), p, source)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "tm delete failed", "detail", err.Error())
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, codeNotFound, "no tm entry for this q and direction")
+			return
+		}
+		forgetCached(r.Context(), ct, p, source)
+		auditParam(r.Context(), "direction", p.Src+"-"+p.Dst)
+		slog.Info("tm entry deleted", "request_id", middleware.GetReqID(r.Context()), "admin_id", adminFrom(r.Context()), "direction", p.Src+"-"+p.Dst)
+		j(w, http.StatusOK, map[string]any{"deleted": true, "src": p.Src, "dst": p.Dst, "q": source})
+	}
+}
+
+// tmExportHandler serves GET /go/admin/tm/export: every entry as a JSON line, in key order, as
+// an artifact (artifacts.go).
+func tmExportHandler(m *translationMemory, arts *artifactStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		arts.serve(w, r, artifactSpec{
+			kind:        "tm",
+			owner:       artifactAdmin,
+			name:        "dhkalign-tm-" + m.clock.Now().UTC().Format("20060102T150405Z") + ".jsonl",
+			contentType: "application/x-ndjson",
+			write: func(ctx context.Context, w io.Writer) (int, error) {
+				enc := json.NewEncoder(w)
+				n := 0
+				err := m.export(ctx, func(e tmEntry) error {
+					n++
+					return enc.Encode(e)
+				})
+				return n, err
+			},
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testSpans installs, once, a tracer provider recording every span in memory, with the W3C
+// propagator SetupTracing installs. The package's tracer delegates to the first provider set.
+var testSpans = sync.OnceValue(func() *tracetest.SpanRecorder {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return rec
+})
+
+// spanAttr is span's value for key, or an invalid Value when it has none.
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// TestTraceparentToUpstream sends a translate request carrying a traceparent and checks its server
+// span continues that trace, the upstream call is a child span of it, the upstream is sent the
+// call's context, and the spans carry the route, cache outcome and upstream status.
+func TestTraceparentToUpstream(t *testing.T) {
+	rec := testSpans()
+	fake := &fakeUpstream{}
+	up := httptest.NewServer(fake)
+	defer up.Close()
+	h := newTestServer(t, map[string]string{"UPSTREAM_URL": up.URL}, Deps{}).Handler()
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		edgeID  = "00f067aa0ba902b7"
+	)
+	mark := len(rec.Ended()) // spans of earlier runs are left out
+	spansOf := func() (server []sdktrace.ReadOnlySpan, client []sdktrace.ReadOnlySpan) {
+		for _, s := range rec.Ended()[mark:] {
+			if s.SpanContext().TraceID().String() != traceID {
+				continue
+			}
+			switch s.SpanKind() {
+			case trace.SpanKindServer:
+				server = append(server, s)
+			case trace.SpanKindClient:
+				client = append(client, s)
+			}
+		}
+		return server, client
+	}
+
+	traceparent := "00-" + traceID + "-" + edgeID + "-01"
+	if w := serve(h, "GET", "/go/translate?q=traced", "", "X-API-Key", testProKey, "traceparent", traceparent); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	server, client := spansOf()
+	if len(server) != 1 || len(client) != 1 {
+		t.Fatalf("%d server and %d client spans in the trace, want one of each", len(server), len(client))
+	}
+	srv, call := server[0], client[0]
+	if got := srv.Parent().SpanID().String(); got != edgeID || !srv.Parent().IsRemote() {
+		t.Fatalf("server span's parent %s, want the edge's %s", got, edgeID)
+	}
+	if call.Parent().SpanID() != srv.SpanContext().SpanID() {
+		t.Fatal("upstream span isn't a child of the server span")
+	}
+	if srv.Name() != "GET /go/translate" || spanAttr(srv, "http.route").AsString() != "/go/translate" || spanAttr(srv, "http.response.status_code").AsInt64() != 200 {
+		t.Fatalf("server span %q with %v", srv.Name(), srv.Attributes())
+	}
+	if v := spanAttr(srv, "cache.hit"); v.Type() != attribute.BOOL || v.AsBool() {
+		t.Fatalf("cache.hit %v on a miss", v.Emit())
+	}
+	if got := spanAttr(call, "upstream.status").AsInt64(); got != 200 {
+		t.Fatalf("upstream.status %d, want 200: %v", got, call.Attributes())
+	}
+	fake.mu.Lock()
+	sent := fake.traceparent
+	fake.mu.Unlock()
+	if want := "00-" + traceID + "-" + call.SpanContext().SpanID().String() + "-01"; sent != want {
+		t.Fatalf("upstream sent traceparent %q, want the upstream span's %q", sent, want)
+	}
+
+	// A repeat is a cache hit, with no upstream call to trace.
+	serve(h, "GET", "/go/translate?q=traced", "", "X-API-Key", testProKey, "traceparent", traceparent)
+	server, client = spansOf()
+	if len(server) != 2 || len(client) != 1 {
+		t.Fatalf("%d server and %d client spans after a repeat, want 2 and 1", len(server), len(client))
+	}
+	if !spanAttr(server[1], "cache.hit").AsBool() {
+		t.Fatal("repeat's span not marked a cache hit")
+	}
+}
@@ -0,0 +1,453 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// translateReq is the input accepted by /go/translate, from the query string (GET) or a JSON or
+// form body (POST). Extended asks for the pro-tier upstream and packs; pro keys always get them.
+type translateReq struct {
+	Q        string `json:"q"`
+	Src      string `json:"src"`
+	Dst      string `json:"dst"`
+	Extended bool   `json:"extended"`
+	NBest    int    `json:"nbest"`    // alternatives wanted besides the best; capped at NBEST_MAX
+	Segments bool   `json:"segments"` // return the per-sentence parts too
+	// AllowBidi lets bidi embeddings, overrides and isolates through validation, for text that
+	// really needs them; they are still removed before translating.
+	AllowBidi bool `json:"allow_bidi"`
+	// Debug asks for the response's provenance: how each layer of the pipeline handled it.
+	Debug bool `json:"debug"`
+	// Digits is the digit script the input's figures come back in: latin, thaana (the
+	// Arabic-Indic digits), or as written when empty. See digits.go.
+	Digits string `json:"digits"`
+	// GlossaryHash identifies the caller's own glossary, set by the glossary layer to keep its
+	// cache entries apart; never taken from the client.
+	GlossaryHash string `json:"-"`
+}
+
+// translateHandler serves GET and POST /go/translate through one code path so behavior never
+// drifts. With debug set, X-Upstream-Attempts reports how many upstream calls the answer took and
+// X-Upstream which upstream gave it. Successful GETs carry an ETag and a Cache-Control max-age of
+// the result cache TTL the policy gives the caller's tier (ttl without one, none when it is off),
+// and a matching If-None-Match is answered with 304. A response with its provenance (debug in the
+// request, or every pro one with provPro) describes this call alone and is no-store.
+func translateHandler(svc *translateService, debug, provPro bool, policy cachePolicy, ttl time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		mt, ok := negotiate(r.Header.Get("Accept"), translateTypes...)
+		if !ok {
+			notAcceptable(w, translateTypes...)
+			return
+		}
+		if mt == mediaPlain {
+			pw := &plainErrors{ResponseWriter: w}
+			defer pw.finish()
+			w = pw
+		}
+		req, err := parseTranslateReq(r)
+		if err != nil {
+			writeTranslateError(r.Context(), w, err)
+			return
+		}
+		sel, err := parseFields(r.URL.Query(), translateFields)
+		if err != nil {
+			writeTranslateError(r.Context(), w, err)
+			return
+		}
+		ctx := r.Context()
+		var prov *provenance
+		if req.Debug || provPro && tierFrom(ctx) == tierPro {
+			ctx, prov = startProvenance(ctx, svc.clock)
+		}
+		res, err := svc.Translate(ctx, req)
+		if debug {
+			setAttemptsHeader(w, res, err)
+		}
+		if err != nil {
+			writeTranslateError(r.Context(), w, err)
+			return
+		}
+		out := map[string]any{
+			"translation":     res.Translation,
+			"src":             res.Src,
+			"detected_script": res.Script,
+			"src_lang":        res.Pair.Src,
+			"dst_lang":        res.Pair.Dst,
+			"ts":              svc.clock.Now().UTC().Format(time.RFC3339),
+		}
+		if len(res.Glossary) > 0 {
+			out["glossary"] = res.Glossary
+		}
+		if len(res.GlossaryClient) > 0 {
+			out["glossary_client"] = res.GlossaryClient
+		}
+		if res.Pack != "" {
+			out["pack"] = res.Pack
+		}
+		if res.Match != "" {
+			out["match"] = res.Match
+		}
+		if res.Confidence != nil {
+			out["confidence"] = *res.Confidence
+		}
+		if req.Segments {
+			out["segments"] = res.Segments
+		}
+		if req.NBest > 0 {
+			alts := res.Alternatives
+			if alts == nil {
+				alts = []alternative{}
+			}
+			out["alternatives"] = alts
+		}
+		if res.DetectedSrc != "" {
+			out["detected_src"] = res.DetectedSrc
+			out["detection_confidence"] = res.DetectionConfidence
+		}
+		if res.Cached {
+			age := int(svc.clock.Now().Sub(res.CachedAt).Seconds())
+			w.Header().Set("Age", strconv.Itoa(age))
+			out["cached"] = true
+			out["age"] = age
+			out["cached_at"] = res.CachedAt.UTC().Format(time.RFC3339)
+			if res.Stale {
+				out["stale"] = true
+			}
+		}
+		if prov != nil {
+			out["provenance"] = prov.view()
+			w.Header().Set("Cache-Control", "no-store")
+		} else if rule := policy.rule(cacheRouteTranslate, tierFrom(r.Context()), ttl); r.Method != http.MethodPost && !rule.Off && res.Src != "stub" {
+			tag := translationETag(svc.keys, req, res, mt, sel)
+			maxAge := rule.TTL
+			if res.Stale {
+				maxAge = 0 // already past its TTL here; let downstream caches revalidate too
+			}
+			setCacheHeaders(w, r, tag, maxAge, res.OwnGlossary)
+			if etagMatch(r.Header.Get("If-None-Match"), tag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		if mt == mediaPlain {
+			writePlain(w, http.StatusOK, res.Translation)
+			return
+		}
+		j(w, http.StatusOK, sel.apply(out))
+	}
+}
+
+// translateFields is every field a /go/translate response can have, for ?fields=.
+var translateFields = []string{
+	"translation", "src", "detected_script", "src_lang", "dst_lang", "ts", "glossary", "glossary_client", "pack", "match",
+	"confidence", "segments", "alternatives", "detected_src", "detection_confidence", "cached", "age", "cached_at",
+	"stale", "provenance",
+}
+
+// translateTypes are the representations /go/translate can produce; JSON is the default.
+var translateTypes = []string{mediaJSON, mediaPlain}
+
+// translationETag is a strong validator over the request, by its key in keys, and everything in the
+// response that can change between calls for it; ts and the cache age are left out. It is
+// computed before compression, so gzip and identity responses share it (compress weakens it).
+// Plain text gets its own tags, as does each ?fields= selection; JSON's are unchanged by
+// negotiation.
+func translationETag(keys keyFolding, req translateReq, res translateResult, mt string, sel fieldSelection) string {
+	h := sha256.New()
+	if mt != mediaJSON {
+		io.WriteString(h, mt)
+		h.Write([]byte{0})
+	}
+	if sel != nil {
+		io.WriteString(h, "fields:"+sel.String())
+		h.Write([]byte{0})
+	}
+	for _, part := range []string{keys.cacheKey(req), res.Pair.Src, res.Pair.Dst, res.Translation, res.Src, res.Script, res.Pack, res.DetectedSrc, res.Match} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	for _, g := range res.Glossary {
+		io.WriteString(h, g)
+		h.Write([]byte{0})
+	}
+	for _, g := range res.GlossaryClient {
+		io.WriteString(h, "client:"+g)
+		h.Write([]byte{0})
+	}
+	if res.Confidence != nil {
+		io.WriteString(h, strconv.FormatFloat(*res.Confidence, 'g', -1, 64))
+	}
+	h.Write([]byte{0})
+	if req.Segments {
+		io.WriteString(h, "segments")
+	}
+	h.Write([]byte{0})
+	for _, a := range res.Alternatives {
+		io.WriteString(h, a.Translation+"\x00"+strconv.FormatFloat(a.Confidence, 'g', -1, 64))
+		h.Write([]byte{0})
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// setCacheHeaders marks a translation cacheable for maxAge. Pro keys get extended results
+// without asking for them in the URL, and own is set for callers with a glossary of their own,
+// so those responses stay out of shared caches.
+func setCacheHeaders(w http.ResponseWriter, r *http.Request, tag string, maxAge time.Duration, own bool) {
+	scope := "public"
+	if own || tierFrom(r.Context()) == tierPro {
+		scope = "private"
+	}
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+}
+
+// etagMatch applies If-None-Match's weak comparison: "*" or any listed tag equal to tag once
+// W/ prefixes are dropped.
+func etagMatch(header, tag string) bool {
+	if header == "" {
+		return false
+	}
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func setAttemptsHeader(w http.ResponseWriter, res translateResult, err error) {
+	if res.Upstream != "" {
+		w.Header().Set("X-Upstream", res.Upstream)
+	}
+	if n := attemptsOf(res, err); n > 0 {
+		w.Header().Set("X-Upstream-Attempts", strconv.Itoa(n))
+	}
+}
+
+// attemptsOf is how many upstream calls res, or the failure err, took.
+func attemptsOf(res translateResult, err error) int {
+	var ue *upstreamError
+	if errors.As(err, &ue) {
+		return ue.Attempts
+	}
+	return res.Attempts
+}
+
+// writeTranslateError passes upstream 4xx through with their status, lists the supported pairs
+// with a 422 for an unsupported direction, answers an exhausted quota with 429 and an open
+// breaker with 503 (both with Retry-After), a route timeout with 504, an upstream body that broke
+// the schema with a 502 UPSTREAM_SCHEMA, and turns everything else into a 502. Nothing is written
+// once the client is gone; the request is recorded as a 499.
+func writeTranslateError(ctx context.Context, w http.ResponseWriter, err error) {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	var herr *httpError
+	if errors.As(err, &herr) {
+		herr.write(w)
+		return
+	}
+	var fe *fieldsError
+	if errors.As(err, &fe) {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fe.Error(), "valid_fields", fe.Valid)
+		return
+	}
+	var me *unsupportedMediaError
+	if errors.As(err, &me) {
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, me.Error(), "supported", me.Supported)
+		return
+	}
+	var se *scopeError
+	if errors.As(err, &se) {
+		se.write(w)
+		return
+	}
+	var pe *unsupportedPairError
+	if errors.As(err, &pe) {
+		writeError(w, http.StatusUnprocessableEntity, codeUnsupportedPair, pe.Error(), "supported", supportedPairs)
+		return
+	}
+	var qe *quotaError
+	if errors.As(err, &qe) {
+		writeThrottled(ctx, w, codeQuotaExceeded, qe.Error(), throttle{
+			Dimension:  dimensionQuota,
+			Limit:      qe.Limit,
+			Used:       qe.Used,
+			Reset:      qe.Reset,
+			RetryAfter: qe.RetryAfter,
+		}, "tier", qe.Tier)
+		return
+	}
+	var ie *InputError
+	if errors.As(err, &ie) {
+		writeError(w, http.StatusBadRequest, ie.Code, ie.Error(), "offset", ie.Offset)
+		return
+	}
+	var nc *notCoveredError
+	if errors.As(err, &nc) {
+		writeError(w, http.StatusNotImplemented, codeNotCovered, nc.Error(), "suggestions", nc.Suggestions)
+		return
+	}
+	var te *inputTooLongError
+	if errors.As(err, &te) {
+		writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, te.Error(),
+			"tier", te.Tier,
+			"limit_chars", te.Limit,
+			"input_chars", te.Length,
+		)
+		return
+	}
+	var open *breakerOpenError
+	if errors.As(err, &open) {
+		retry := int(math.Ceil(open.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeError(w, http.StatusServiceUnavailable, codeUpstreamDown, "upstream unavailable", "detail", err.Error(), "retry_after", retry)
+		return
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		writeTimeout(ctx, w)
+		return
+	}
+	var ue *upstreamError
+	if errors.As(err, &ue) && ue.clientError() {
+		details := []any{"upstream_status", ue.Status}
+		if ue.Cached {
+			details = append(details, "cached_error", true)
+		}
+		writeError(w, ue.Status, codeUpstreamRejected, ue.Msg, details...)
+		return
+	}
+	if ue != nil && ue.Schema {
+		writeError(w, http.StatusBadGateway, codeUpstreamSchema, "upstream response failed validation", "detail", ue.Msg, "upstream_status", ue.Status)
+		return
+	}
+	details := []any{"detail", err.Error()}
+	if ue != nil && ue.Status != 0 {
+		details = append(details, "upstream_status", ue.Status)
+	}
+	writeError(w, http.StatusBadGateway, codeUpstreamDown, "upstream unavailable", details...)
+}
+
+// mediaForm is for integrations that can only post a form. Multipart isn't supported.
+const mediaForm = "application/x-www-form-urlencoded"
+
+// translateBodyTypes are the body types POST /go/translate accepts.
+var translateBodyTypes = []string{mediaJSON, mediaForm}
+
+// unsupportedMediaError is a request body in a type the route doesn't read.
+type unsupportedMediaError struct {
+	Got       string
+	Supported []string
+}
+
+func (e *unsupportedMediaError) Error() string {
+	return fmt.Sprintf("content type %q is not supported; send %s", e.Got, strings.Join(e.Supported, " or "))
+}
+
+// parseTranslateReq reads a translateReq from the query string or, for POST, the JSON or form
+// body (size-limited by the limitBody middleware). Errors are for writeTranslateError.
+func parseTranslateReq(r *http.Request) (translateReq, error) {
+	var req translateReq
+	if r.Method != http.MethodPost {
+		req = translateReqFrom(r.URL.Query())
+		if strings.TrimSpace(req.Q) == "" {
+			return req, &httpError{http.StatusBadRequest, codeMissingQuery, "missing query param 'q'"}
+		}
+	} else {
+		ct := r.Header.Get("Content-Type")
+		mt, params, _ := mime.ParseMediaType(ct)
+		switch mt {
+		case mediaJSON:
+			if herr := decodeJSONBody(r, &req); herr != nil {
+				return req, herr
+			}
+		case mediaForm:
+			if cs, ok := params["charset"]; ok && !strings.EqualFold(cs, "utf-8") {
+				return req, &httpError{http.StatusUnsupportedMediaType, codeUnsupportedMedia, "form bodies must be UTF-8, not " + cs}
+			}
+			form, herr := readForm(r)
+			if herr != nil {
+				return req, herr
+			}
+			req = translateReqFrom(form)
+		default:
+			return req, &unsupportedMediaError{Got: ct, Supported: translateBodyTypes}
+		}
+		if strings.TrimSpace(req.Q) == "" {
+			return req, &httpError{http.StatusBadRequest, codeMissingQuery, "missing field 'q'"}
+		}
+	}
+	if req.NBest < 0 {
+		return req, &httpError{http.StatusBadRequest, codeBadRequest, "nbest must be a non-negative integer"}
+	}
+	return req, nil
+}
+
+// translateReqFrom reads the fields of a query string or form; nbest that isn't a number is
+// -1, which parseTranslateReq refuses.
+func translateReqFrom(v url.Values) translateReq {
+	req := translateReq{Q: v.Get("q"), Src: v.Get("src"), Dst: v.Get("dst"), Extended: queryBool(v.Get("extended")), Segments: queryBool(v.Get("segments")), AllowBidi: queryBool(v.Get("allow_bidi")), Debug: queryBool(v.Get("debug")), Digits: v.Get("digits")}
+	if s := v.Get("nbest"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			n = -1
+		}
+		req.NBest = n
+	}
+	return req
+}
+
+// readForm parses an urlencoded body. Only the body is read: a field in the URL's query string
+// doesn't count, as it doesn't for JSON bodies.
+func readForm(r *http.Request) (url.Values, *httpError) {
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &httpError{http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("request body too large (limit %d bytes)", tooLarge.Limit)}
+		}
+		return nil, &httpError{http.StatusBadRequest, codeBadRequest, "invalid form body"}
+	}
+	return r.PostForm, nil
+}
+
+// queryBool reads a boolean query flag: "1", "true" and "yes" are true.
+func queryBool(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// decodeJSONBody requires a JSON content type and decodes r.Body into v. Hitting the
+// limitBody cap surfaces as a 413.
+func decodeJSONBody(r *http.Request, v any) *httpError {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != "application/json" {
+		return &httpError{http.StatusUnsupportedMediaType, codeUnsupportedMedia, "content type must be application/json"}
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &httpError{http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("request body too large (limit %d bytes)", tooLarge.Limit)}
+		}
+		return &httpError{http.StatusBadRequest, codeBadRequest, "invalid JSON body"}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTranslatePostBody(t *testing.T) {
+	h := newTestServer(t, nil, Deps{}).Handler()
+	tests := []struct {
+		name   string
+		ctype  string
+		body   string
+		status int
+		code   errorCode
+	}{
+		{"valid", "application/json", `{"q":"kihineh","src":"dv","dst":"en"}`, http.StatusOK, ""},
+		{"charset", "application/json; charset=utf-8", `{"q":"kihineh"}`, http.StatusOK, ""},
+		{"malformed", "application/json", `{"q":`, http.StatusBadRequest, codeBadRequest},
+		{"not an object", "application/json", `["kihineh"]`, http.StatusBadRequest, codeBadRequest},
+		{"missing q", "application/json", `{"src":"dv"}`, http.StatusBadRequest, codeMissingQuery},
+		{"blank q", "application/json", `{"q":" \t\n"}`, http.StatusBadRequest, codeMissingQuery},
+		{"text body", "text/plain", `kihineh`, http.StatusUnsupportedMediaType, codeUnsupportedMedia},
+		{"too large", "application/json", `{"q":"` + strings.Repeat("x", 70<<10) + `"}`, http.StatusRequestEntityTooLarge, codePayloadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "POST", "/go/translate", tt.body, "X-API-Key", testProKey, "Content-Type", tt.ctype)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.code == "" {
+				return
+			}
+			var res struct {
+				Error struct {
+					Code errorCode `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Error.Code != tt.code {
+				t.Fatalf("error code %q (%v), want %s: %s", res.Error.Code, err, tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestTranslateFormBody posts urlencoded forms, percent-encoded Thaana among them, and checks
+// they answer as the same JSON body would, with the charset held to UTF-8, fields in the URL
+// ignored and any other body type refused with the two supported ones listed.
+func TestTranslateFormBody(t *testing.T) {
+	h := newTestServer(t, nil, Deps{}).Handler()
+	thaana := "q=" + url.QueryEscape("ކިހިނެއް") + "&src=dv&dst=en"
+	tests := []struct {
+		name    string
+		target  string
+		ctype   string
+		body    string
+		status  int
+		code    errorCode
+		message string
+	}{
+		{"thaana", "/go/translate", "application/x-www-form-urlencoded", thaana, http.StatusOK, "", ""},
+		{"charset", "/go/translate", "application/x-www-form-urlencoded; charset=UTF-8", thaana, http.StatusOK, "", ""},
+		{"other charset", "/go/translate", "application/x-www-form-urlencoded; charset=iso-8859-1", thaana, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "form bodies must be UTF-8, not iso-8859-1"},
+		{"q in the url", "/go/translate?q=kihineh", "application/x-www-form-urlencoded", "src=dv", http.StatusBadRequest, codeMissingQuery, "missing field 'q'"},
+		{"bad escape", "/go/translate", "application/x-www-form-urlencoded", "q=%zz", http.StatusBadRequest, codeBadRequest, "invalid form body"},
+		{"too large", "/go/translate", "application/x-www-form-urlencoded", "q=" + strings.Repeat("x", 70<<10), http.StatusRequestEntityTooLarge, codePayloadTooLarge, "request body too large (limit 65536 bytes)"},
+		{"multipart", "/go/translate", "multipart/form-data; boundary=x", "--x--", http.StatusUnsupportedMediaType, codeUnsupportedMedia,
+			`content type "multipart/form-data; boundary=x" is not supported; send application/json or application/x-www-form-urlencoded`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "POST", tt.target, tt.body, "X-API-Key", testProKey, "Content-Type", tt.ctype)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.code == "" {
+				var m map[string]any
+				json.Unmarshal(w.Body.Bytes(), &m)
+				if w.Header().Get("Content-Type") != "application/json" || m["translation"] != "EN(ކިހިނެއް)" || m["src_lang"] != "dv" || m["dst_lang"] != "en" {
+					t.Fatalf("answer %s", w.Body.String())
+				}
+				return
+			}
+			checkEnvelope(t, w)
+			var res struct {
+				Error struct {
+					Code      errorCode `json:"code"`
+					Message   string    `json:"message"`
+					Supported []string  `json:"supported"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if res.Error.Code != tt.code || res.Error.Message != tt.message {
+				t.Fatalf("error %s %q, want %s %q", res.Error.Code, res.Error.Message, tt.code, tt.message)
+			}
+			if tt.name == "multipart" && (len(res.Error.Supported) != 2 || res.Error.Supported[0] != mediaJSON || res.Error.Supported[1] != mediaForm) {
+				t.Fatalf("supported %v, want JSON and form", res.Error.Supported)
+			}
+		})
+	}
+}
+
+// TestTranslateGetMatchesPost round-trips a phrase through both methods of the one handler, and
+// both POST body types.
+func TestTranslateGetMatchesPost(t *testing.T) {
+	h := newTestServer(t, nil, Deps{}).Handler()
+	decode := func(w interface{ Bytes() []byte }) map[string]any {
+		t.Helper()
+		var m map[string]any
+		if err := json.Unmarshal(w.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		delete(m, "ts")
+		delete(m, "cached")
+		delete(m, "cached_at")
+		delete(m, "age")
+		return m
+	}
+	for _, q := range []string{"kihineh", "ކިހިނެއް", "where is the hospital"} {
+		t.Run(q, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"q": q})
+			post := serve(h, "POST", "/go/translate", string(body), "X-API-Key", testProKey, "Content-Type", "application/json")
+			form := serve(h, "POST", "/go/translate", "q="+url.QueryEscape(q), "X-API-Key", testProKey, "Content-Type", "application/x-www-form-urlencoded")
+			get := serve(h, "GET", "/go/translate?q="+url.QueryEscape(q), "", "X-API-Key", testProKey)
+			if post.Code != http.StatusOK || form.Code != http.StatusOK || get.Code != http.StatusOK {
+				t.Fatalf("POST %d, form POST %d, GET %d", post.Code, form.Code, get.Code)
+			}
+			pb, _ := json.Marshal(decode(post.Body))
+			fb, _ := json.Marshal(decode(form.Body))
+			gb, _ := json.Marshal(decode(get.Body))
+			if string(pb) != string(gb) || string(fb) != string(gb) {
+				t.Fatalf("POST %s\nform %s\nGET  %s", pb, fb, gb)
+			}
+		})
+	}
+}
+
+func TestTranslateETag(t *testing.T) {
+	h := newTestServer(t, map[string]string{"CACHE_TTL": "1h", "COMPRESS_MIN_BYTES": "0"}, Deps{}).Handler()
+	first := serve(h, "GET", "/go/translate?q=kihineh", "", "X-API-Key", testFreeKey)
+	tag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || !strings.HasPrefix(tag, `"`) {
+		t.Fatalf("status %d, ETag %q; want 200 with a strong tag", first.Code, tag)
+	}
+	if got := first.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Fatalf("Cache-Control %q, want public for an hour", got)
+	}
+	tests := []struct {
+		name        string
+		target      string
+		headers     []string
+		status      int
+		sameTag     bool
+		cacheHeader string
+	}{
+		{"replayed", "/go/translate?q=kihineh", []string{"If-None-Match", tag}, http.StatusNotModified, true, "public, max-age=3600"},
+		{"weak form", "/go/translate?q=kihineh", []string{"If-None-Match", "W/" + tag}, http.StatusNotModified, true, "public, max-age=3600"},
+		{"in a list", "/go/translate?q=kihineh", []string{"If-None-Match", `"other", ` + tag}, http.StatusNotModified, true, "public, max-age=3600"},
+		{"star", "/go/translate?q=kihineh", []string{"If-None-Match", "*"}, http.StatusNotModified, true, "public, max-age=3600"},
+		{"another tag", "/go/translate?q=kihineh", []string{"If-None-Match", `"other"`}, http.StatusOK, true, "public, max-age=3600"},
+		{"normalized spelling", "/go/translate?q=%20kihineh%20%20", []string{"If-None-Match", tag}, http.StatusNotModified, true, "public, max-age=3600"},
+		{"gzip", "/go/translate?q=kihineh", []string{"If-None-Match", tag, "Accept-Encoding", "gzip"}, http.StatusNotModified, true, "public, max-age=3600"},
+		{"other phrase", "/go/translate?q=salaam", []string{"If-None-Match", tag}, http.StatusOK, false, "public, max-age=3600"},
+		{"pro is private", "/go/translate?q=salaam", []string{"X-API-Key", testProKey}, http.StatusOK, false, "private, max-age=3600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := append([]string{"X-API-Key", testFreeKey}, tt.headers...)
+			w := serve(h, "GET", tt.target, "", headers...)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status == http.StatusNotModified && w.Body.Len() > 0 {
+				t.Fatalf("304 with a body: %q", w.Body.String())
+			}
+			got := strings.TrimPrefix(w.Header().Get("ETag"), "W/")
+			if (got == tag) != tt.sameTag {
+				t.Fatalf("ETag %q, first %q; want same %v", got, tag, tt.sameTag)
+			}
+			if cc := w.Header().Get("Cache-Control"); cc != tt.cacheHeader {
+				t.Fatalf("Cache-Control %q, want %q", cc, tt.cacheHeader)
+			}
+		})
+	}
+	t.Run("gzip body weakens the tag", func(t *testing.T) {
+		w := serve(h, "GET", "/go/translate?q=kihineh", "", "X-API-Key", testFreeKey, "Accept-Encoding", "gzip")
+		if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("ETag") != "W/"+tag {
+			t.Fatalf("encoding %q, ETag %q; want gzip under W/%s", w.Header().Get("Content-Encoding"), w.Header().Get("ETag"), tag)
+		}
+	})
+}
+
+func TestTranslateNotCacheable(t *testing.T) {
+	tests := []struct {
+		name   string
+		deps   Deps
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"post", Deps{}, "POST", "/go/translate", `{"q":"kihineh"}`, http.StatusOK},
+		{"error", Deps{}, "GET", "/go/translate", "", http.StatusBadRequest},
+		{"upstream failure", Deps{Upstream: &echoUpstream{err: &upstreamError{Status: 503, Msg: "down"}}}, "GET", "/go/translate?q=kihineh", "", http.StatusBadGateway},
+		{"stub", Deps{Upstream: stubTranslator{}}, "GET", "/go/translate?q=kihineh", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestServer(t, nil, tt.deps).Handler()
+			w := serve(h, tt.method, tt.target, tt.body, "X-API-Key", testFreeKey, "Content-Type", "application/json")
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tag, cc := w.Header().Get("ETag"), w.Header().Get("Cache-Control"); tag != "" || strings.Contains(cc, "max-age") {
+				t.Fatalf("ETag %q, Cache-Control %q on an uncacheable response", tag, cc)
+			}
+		})
+	}
+}
+
+// hangUpUpstream holds each translation until its context ends, announcing the phrase on
+// entered when the call starts and on canceled when it ends.
+type hangUpUpstream struct {
+	entered, canceled chan string
+}
+
+func (u *hangUpUpstream) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	u.entered <- req.Q
+	<-ctx.Done()
+	u.canceled <- req.Q
+	return translateResult{}, ctx.Err()
+}
+
+// TestClientHangUpCancelsUpstream sends requests over a real connection and hangs up once their
+// upstream calls have started: each call is canceled, nothing is answered, and the request is
+// counted as abandoned and recorded as a 499.
+func TestClientHangUpCancelsUpstream(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  string
+		method string
+		target string
+		body   string
+		calls  int
+	}{
+		{"translate", "/go/translate", "GET", "/go/translate?q=hang+up+single", "", 1},
+		{"batch", "/go/translate/batch", "POST", "/go/translate/batch", `{"items":[{"id":"a","q":"hang up first"},{"id":"b","q":"hang up second"}]}`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := &hangUpUpstream{entered: make(chan string, tt.calls), canceled: make(chan string, tt.calls)}
+			h := newTestServer(t, map[string]string{"KEY_CONCURRENCY_PRO": "0"}, Deps{Upstream: up}).Handler()
+			before := scrape(t, h)
+			ts := httptest.NewServer(h)
+			defer ts.Close()
+
+			ctx, hangUp := context.WithCancel(context.Background())
+			defer hangUp()
+			req, _ := http.NewRequestWithContext(ctx, tt.method, ts.URL+tt.target, strings.NewReader(tt.body))
+			req.Header.Set("X-API-Key", testProKey)
+			req.Header.Set("Content-Type", "application/json")
+			done := make(chan error, 1)
+			go func() {
+				res, err := ts.Client().Do(req)
+				if err == nil {
+					res.Body.Close()
+				}
+				done <- err
+			}()
+			for range tt.calls {
+				<-up.entered
+			}
+			hangUp()
+			if err := <-done; err == nil {
+				t.Fatal("the client got a response after hanging up")
+			}
+			for range tt.calls {
+				select {
+				case <-up.canceled:
+				case <-time.After(5 * time.Second):
+					t.Fatal("an upstream call outlived its client")
+				}
+			}
+			ts.Close() // waits for the handler to return and be recorded
+
+			after := scrape(t, h)
+			for _, m := range []struct {
+				metric string
+				labels []string
+			}{
+				{"dhk_go_http_requests_abandoned_total", []string{"route", tt.route}},
+				{"dhk_go_http_requests_total", []string{"route", tt.route, "status", "499"}},
+			} {
+				if got := after(m.metric, m.labels...) - before(m.metric, m.labels...); got != 1 {
+					t.Errorf("%s %v moved by %v, want 1", m.metric, m.labels, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+package server
+
+import (
+	"container/list"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Transliteration between Thaana and Malé Latin, the romanization on road signs and in
+// passports. Thaana writes every syllable as a consonant carrying a vowel sign (fili) or the
+// sukun (no vowel); a syllable starting with a vowel uses alifu as its carrier. Alifu with sukun
+// doubles the consonant after it (އައްސަލާމު → assalaamu) and is written h at the end of a word
+// (ކިހިނެއް → kihineh). Going back, a doubled consonant before a vowel and a word-final h after
+// a vowel become alifu with sukun again, so text in the usual spelling round-trips. What doesn't:
+// haa or shaviani with sukun at the end of a word (read back as alifu) and a consonant without
+// sukun before another (read back with one).
+
+//go:embed translit.json
+var defaultTranslitTable []byte
+
+// translitTable is the mapping, translit.json unless TRANSLIT_TABLE_FILE replaces it.
+type translitTable struct {
+	Alifu       string            `json:"alifu"`
+	Sukun       string            `json:"sukun"`
+	FinalH      string            `json:"final_alifu_sukun"`
+	Consonants  map[string]string `json:"consonants"`
+	Vowels      map[string]string `json:"vowels"`
+	Punctuation map[string]string `json:"punctuation"`
+
+	alifu, sukun rune
+	cons, fili   map[rune]string
+	punct        map[rune]string
+	latinCons    []latinForm // longest first, for greedy matching
+	latinVowels  []latinForm
+	latinPunct   map[rune]rune
+}
+
+type latinForm struct {
+	latin  string
+	thaana rune
+}
+
+func parseTranslitTable(b []byte) (*translitTable, error) {
+	var t translitTable
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	one := func(what, s string) (rune, error) {
+		r, n := utf8.DecodeRuneInString(s)
+		if n == 0 || n != len(s) {
+			return 0, fmt.Errorf("%s must be a single character, not %q", what, s)
+		}
+		return r, nil
+	}
+	var err error
+	if t.alifu, err = one("alifu", t.Alifu); err != nil {
+		return nil, err
+	}
+	if t.sukun, err = one("sukun", t.Sukun); err != nil {
+		return nil, err
+	}
+	seen := map[string]string{}
+	index := func(what string, in map[string]string) (map[rune]string, []latinForm, error) {
+		out := make(map[rune]string, len(in))
+		var forms []latinForm
+		for th, lat := range in {
+			r, err := one(what, th)
+			if err != nil {
+				return nil, nil, err
+			}
+			if lat == "" || lat != strings.ToLower(lat) {
+				return nil, nil, fmt.Errorf("%s %s: the Latin form must be lowercase and non-empty", what, th)
+			}
+			if prev, dup := seen[lat]; dup {
+				return nil, nil, fmt.Errorf("%s and %s are both written %q", prev, th, lat)
+			}
+			seen[lat] = th
+			out[r] = lat
+			forms = append(forms, latinForm{lat, r})
+		}
+		sort.Slice(forms, func(i, j int) bool {
+			if len(forms[i].latin) != len(forms[j].latin) {
+				return len(forms[i].latin) > len(forms[j].latin)
+			}
+			return forms[i].latin < forms[j].latin
+		})
+		return out, forms, nil
+	}
+	if t.cons, t.latinCons, err = index("consonant", t.Consonants); err != nil {
+		return nil, err
+	}
+	if t.fili, t.latinVowels, err = index("vowel", t.Vowels); err != nil {
+		return nil, err
+	}
+	t.punct, t.latinPunct = make(map[rune]string), make(map[rune]rune)
+	for th, lat := range t.Punctuation {
+		r, err := one("punctuation", th)
+		if err != nil {
+			return nil, err
+		}
+		l, err := one("punctuation", lat)
+		if err != nil {
+			return nil, err
+		}
+		t.punct[r], t.latinPunct[l] = lat, r
+	}
+	if t.FinalH == "" {
+		return nil, errors.New("final_alifu_sukun must be set")
+	}
+	return &t, nil
+}
+
+func mustParseTranslitTable(b []byte) *translitTable {
+	t, err := parseTranslitTable(b)
+	if err != nil {
+		panic("translit.json: " + err.Error())
+	}
+	return t
+}
+
+// loadTranslitTable reads a replacement table from path.
+func loadTranslitTable(path string) (*translitTable, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("TRANSLIT_TABLE_FILE: %w", err)
+	}
+	t, err := parseTranslitTable(b)
+	if err != nil {
+		return nil, fmt.Errorf("TRANSLIT_TABLE_FILE: %w", err)
+	}
+	return t, nil
+}
+
+// Transliteration directions.
+const (
+	dirThaanaLatin = "thaana-latin"
+	dirLatinThaana = "latin-thaana"
+)
+
+// transliteration is one conversion's outcome. Unmapped counts letters the table has no entry
+// for; they are passed through as they were.
+type transliteration struct {
+	Output    string   `json:"output"`
+	Direction string   `json:"direction"`
+	Notes     []string `json:"notes"`
+	Unmapped  int      `json:"unmapped"`
+}
+
+func (t *translitTable) convert(direction, s string) transliteration {
+	if direction == dirThaanaLatin {
+		return t.toLatin(s)
+	}
+	return t.toThaana(s)
+}
+
+func isThaana(r rune) bool { return r >= 0x0780 && r <= 0x07BF }
+
+func (t *translitTable) toLatin(s string) transliteration {
+	rs := []rune(s)
+	at := func(i int) rune {
+		if i < len(rs) {
+			return rs[i]
+		}
+		return 0
+	}
+	var b strings.Builder
+	out := transliteration{Direction: dirThaanaLatin}
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if r == t.alifu {
+			switch next := at(i + 1); {
+			case t.fili[next] != "":
+				b.WriteString(t.fili[next])
+				i++
+			case next == t.sukun:
+				i++
+				if c, ok := t.cons[at(i+1)]; ok {
+					b.WriteString(c) // doubles the next consonant
+				} else {
+					b.WriteString(t.FinalH)
+				}
+			default:
+				out.Unmapped++
+				b.WriteRune(r)
+			}
+			continue
+		}
+		if c, ok := t.cons[r]; ok {
+			b.WriteString(c)
+			if v, ok := t.fili[at(i+1)]; ok {
+				b.WriteString(v)
+				i++
+			} else if at(i+1) == t.sukun {
+				i++
+			}
+			continue
+		}
+		switch {
+		case t.fili[r] != "":
+			b.WriteString(t.fili[r]) // a fili without its consonant
+			out.Notes = appendNote(out.Notes, "a vowel sign without a consonant was written as a bare vowel")
+		case t.punct[r] != "":
+			b.WriteString(t.punct[r])
+		default:
+			if isThaana(r) {
+				out.Unmapped++
+			}
+			b.WriteRune(r)
+		}
+	}
+	out.Output = b.String()
+	return out
+}
+
+// matchLatin returns the longest form in forms starting at rs[i].
+func matchLatin(rs []rune, i int, forms []latinForm) (latinForm, int) {
+	for _, f := range forms {
+		n := utf8.RuneCountInString(f.latin)
+		if i+n <= len(rs) && string(rs[i:i+n]) == f.latin {
+			return f, n
+		}
+	}
+	return latinForm{}, 0
+}
+
+func (t *translitTable) toThaana(s string) transliteration {
+	rs := []rune(strings.ToLower(s))
+	out := transliteration{Direction: dirLatinThaana}
+	letter := func(i int) bool { return i >= 0 && i < len(rs) && unicode.IsLetter(rs[i]) }
+	// vowel matches a vowel at i, passing over a long vowel whose last letter would rather start
+	// the next syllable: "beya" is be-ya, not bey-a.
+	vowel := func(i int) (latinForm, int) {
+		for _, f := range t.latinVowels {
+			n := utf8.RuneCountInString(f.latin)
+			if i+n > len(rs) || string(rs[i:i+n]) != f.latin {
+				continue
+			}
+			if n > 1 {
+				if _, cn := matchLatin(rs, i+n-1, t.latinCons); cn == 1 {
+					if _, vn := matchLatin(rs, i+n, t.latinVowels); vn > 0 {
+						continue
+					}
+				}
+			}
+			return f, n
+		}
+		return latinForm{}, 0
+	}
+	var b strings.Builder
+	afterVowel := false
+	for i := 0; i < len(rs); {
+		if c, n := matchLatin(rs, i, t.latinCons); n > 0 {
+			// A doubled consonant before a vowel is alifu with sukun, then the consonant.
+			if c2, n2 := matchLatin(rs, i+n, t.latinCons); c2 == c && letter(i-1) {
+				if _, vn := vowel(i + n + n2); vn > 0 {
+					b.WriteRune(t.alifu)
+					b.WriteRune(t.sukun)
+					i += n
+					afterVowel = false
+					continue
+				}
+			}
+			// So is h at the end of a word, after a vowel.
+			if c.latin == t.FinalH && afterVowel && !letter(i+n) {
+				b.WriteRune(t.alifu)
+				b.WriteRune(t.sukun)
+				out.Notes = appendNote(out.Notes, "a word-final h was written as alifu with sukun")
+				i += n
+				afterVowel = false
+				continue
+			}
+			b.WriteRune(c.thaana)
+			if v, vn := vowel(i + n); vn > 0 {
+				b.WriteRune(v.thaana)
+				i += n + vn
+				afterVowel = true
+			} else {
+				b.WriteRune(t.sukun)
+				i += n
+				afterVowel = false
+			}
+			continue
+		}
+		if v, vn := vowel(i); vn > 0 {
+			b.WriteRune(t.alifu)
+			b.WriteRune(v.thaana)
+			i += vn
+			afterVowel = true
+			continue
+		}
+		r := rs[i]
+		if p, ok := t.latinPunct[r]; ok {
+			b.WriteRune(p)
+		} else {
+			if unicode.IsLetter(r) {
+				out.Unmapped++
+			}
+			b.WriteRune(r)
+		}
+		i++
+		afterVowel = false
+	}
+	out.Output = b.String()
+	return out
+}
+
+func appendNote(notes []string, note string) []string {
+	for _, n := range notes {
+		if n == note {
+			return notes
+		}
+	}
+	return append(notes, note)
+}
+
+// translitCache keeps recent conversions apart from the translation cache: they are cheap to
+// redo, need not expire (the table only changes at restart) and would only crowd translations
+// out. A CACHE_POLICY TTL for transliterate bounds them anyway.
+type translitCache struct {
+	mu    sync.Mutex
+	max   int
+	clock Clock
+	ll    *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+type translitEntry struct {
+	key      string
+	res      transliteration
+	storedAt time.Time
+}
+
+func newTranslitCache(max int, clk Clock) *translitCache {
+	return &translitCache{max: max, clock: clk, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns key's conversion if it is younger than ttl; 0 accepts any age.
+func (c *translitCache) get(key string, ttl time.Duration) (transliteration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return transliteration{}, false
+	}
+	e := el.Value.(*translitEntry)
+	if ttl > 0 && c.clock.Now().Sub(e.storedAt) > ttl {
+		return transliteration{}, false
+	}
+	c.ll.MoveToFront(el)
+	return e.res, true
+}
+
+func (c *translitCache) put(key string, res transliteration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*translitEntry)
+		e.res, e.storedAt = res, now
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&translitEntry{key: key, res: res, storedAt: now})
+	for c.ll.Len() > c.max {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*translitEntry).key)
+	}
+}
+
+// transliterator serves /go/transliterate.
+type transliterator struct {
+	table  *translitTable
+	cache  *translitCache // nil when TRANSLIT_CACHE_ENTRIES is 0
+	policy cachePolicy    // its transliterate rules; nil keeps entries until evicted
+	limits inputLimits
+}
+
+type transliterateReq struct {
+	Q         string `json:"q"`
+	Direction string `json:"direction"`
+}
+
+// handler serves GET and POST /go/transliterate. Without a direction, the script q is mostly
+// written in decides it. Input is normalized and held to the translate length limits.
+func (tl *transliterator) handler(w http.ResponseWriter, r *http.Request) {
+	var req transliterateReq
+	if r.Method == http.MethodPost {
+		if herr := decodeJSONBody(r, &req); herr != nil {
+			herr.write(w)
+			return
+		}
+	} else {
+		req = transliterateReq{Q: r.URL.Query().Get("q"), Direction: r.URL.Query().Get("direction")}
+	}
+	q, ok := normalizeText(req.Q)
+	switch {
+	case !ok:
+		writeError(w, http.StatusBadRequest, codeBadRequest, "q is not valid UTF-8")
+		return
+	case q == "":
+		writeError(w, http.StatusBadRequest, codeMissingQuery, "missing 'q'")
+		return
+	}
+	tier := tierFrom(r.Context())
+	if n := utf8.RuneCountInString(q); n > tl.limits.maxChars(tier) {
+		writeTranslateError(r.Context(), w, &inputTooLongError{Tier: tier, Limit: tl.limits.maxChars(tier), Length: n})
+		return
+	}
+	dir := strings.ToLower(strings.TrimSpace(req.Direction))
+	if dir == "" {
+		switch detectScript(q) {
+		case scriptThaana:
+			dir = dirThaanaLatin
+		case scriptLatin:
+			dir = dirLatinThaana
+		default:
+			writeError(w, http.StatusBadRequest, codeBadRequest, "can't tell which script q is in; pass direction")
+			return
+		}
+	}
+	if dir != dirThaanaLatin && dir != dirLatinThaana {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "direction must be "+dirThaanaLatin+" or "+dirLatinThaana)
+		return
+	}
+
+	key := dir + "\x00" + q
+	res, cached := transliteration{}, false
+	rule := tl.policy.rule(cacheRouteTransliterate, tier, 0)
+	useCache := tl.cache != nil && !rule.Off
+	if useCache {
+		res, cached = tl.cache.get(key, rule.TTL)
+	}
+	if !cached {
+		res = tl.table.convert(dir, q)
+		if res.Unmapped > 0 {
+			note := fmt.Sprintf("%d characters outside the mapping were passed through", res.Unmapped)
+			if res.Unmapped == 1 {
+				note = "1 character outside the mapping was passed through"
+			}
+			res.Notes = append(res.Notes, note)
+		}
+		if res.Notes == nil {
+			res.Notes = []string{}
+		}
+		if useCache {
+			tl.cache.put(key, res)
+		}
+	}
+	metricTransliterations.WithLabelValues(dir, fmt.Sprint(cached)).Inc()
+	j(w, http.StatusOK, map[string]any{
+		"output":    res.Output,
+		"direction": res.Direction,
+		"notes":     res.Notes,
+		"unmapped":  res.Unmapped,
+		"cached":    cached,
+	})
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestTransliterateWordlist converts every word in testdata/translit.tsv both ways.
+func TestTransliterateWordlist(t *testing.T) {
+	tb := mustParseTranslitTable(defaultTranslitTable)
+	f, err := os.Open("testdata/translit.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	n := 0
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cols := strings.Split(line, "\t")
+		if len(cols) < 2 || len(cols) > 3 {
+			t.Fatalf("malformed line %q", line)
+		}
+		n++
+		thaana, latin, back := cols[0], cols[1], cols[0]
+		if len(cols) == 3 {
+			back = cols[2]
+		}
+		t.Run(latin, func(t *testing.T) {
+			got := tb.convert(dirThaanaLatin, thaana)
+			if got.Output != latin || got.Unmapped != 0 {
+				t.Errorf("%s: %q with %d unmapped, want %q", thaana, got.Output, got.Unmapped, latin)
+			}
+			if got := tb.convert(dirLatinThaana, latin); got.Output != back || got.Unmapped != 0 {
+				t.Errorf("%s: %s with %d unmapped, want %s", latin, got.Output, got.Unmapped, back)
+			}
+		})
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n < 50 {
+		t.Fatalf("only %d reference words", n)
+	}
+}
+
+func TestTransliterate(t *testing.T) {
+	tb := mustParseTranslitTable(defaultTranslitTable)
+	tests := []struct {
+		name      string
+		direction string
+		in        string
+		out       string
+		notes     []string
+		unmapped  int
+	}{
+		{"sentence", dirThaanaLatin, "ކިހިނެއް ތިބެނީ؟", "kihineh thibenee?", nil, 0},
+		{"latin passes through", dirThaanaLatin, "OK ޝުކުރިއްޔާ", "OK sh'ukuriyyaa", nil, 0},
+		{"digits pass through", dirThaanaLatin, "ބަސް 12", "bas 12", nil, 0},
+		{"bare alifu unmapped", dirThaanaLatin, "އ", "އ", nil, 1},
+		{"unassigned thaana unmapped", dirThaanaLatin, "ބަ޲", "ba޲", nil, 1},
+		{"fili without a consonant", dirThaanaLatin, "ަބ", "ab", []string{"a vowel sign without a consonant was written as a bare vowel"}, 0},
+		{"case folded", dirLatinThaana, "Maale", "މާލެ", nil, 0},
+		{"final h", dirLatinThaana, "kihineh thibenee?", "ކިހިނެއް ތިބެނީ؟", []string{"a word-final h was written as alifu with sukun"}, 0},
+		{"h starting a word", dirLatinThaana, "hoonu", "ހޫނު", nil, 0},
+		{"doubled consonant", dirLatinThaana, "assalaamu", "އައްސަލާމު", nil, 0},
+		{"doubled at the start stays", dirLatinThaana, "ssa", "ސްސަ", nil, 0},
+		{"long vowel split before a syllable", dirLatinThaana, "beya", "ބެޔަ", nil, 0},
+		{"long vowel at the end", dirLatinThaana, "kaley", "ކަލޭ", nil, 0},
+		{"vowel first", dirLatinThaana, "aharen", "އަހަރެން", nil, 0},
+		{"unmapped letters", dirLatinThaana, "xcx", "xcx", nil, 3},
+		{"non-latin letters", dirLatinThaana, "привет", "привет", nil, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tb.convert(tt.direction, tt.in)
+			if got.Output != tt.out || got.Unmapped != tt.unmapped || !slices.Equal(got.Notes, tt.notes) || got.Direction != tt.direction {
+				t.Fatalf("%+v, want %q with %d unmapped and notes %q", got, tt.out, tt.unmapped, tt.notes)
+			}
+		})
+	}
+}
+
+func TestParseTranslitTable(t *testing.T) {
+	base := func(edit func(m map[string]any)) string {
+		m := map[string]any{
+			"alifu": "އ", "sukun": "ް", "final_alifu_sukun": "h",
+			"consonants":  map[string]string{"ހ": "h", "ބ": "b"},
+			"vowels":      map[string]string{"ަ": "a"},
+			"punctuation": map[string]string{"،": ","},
+		}
+		if edit != nil {
+			edit(m)
+		}
+		b, _ := json.Marshal(m)
+		return string(b)
+	}
+	tests := []struct {
+		name string
+		json string
+		ok   bool
+	}{
+		{"valid", base(nil), true},
+		{"built in", string(defaultTranslitTable), true},
+		{"alifu two letters", base(func(m map[string]any) { m["alifu"] = "އއ" }), false},
+		{"no sukun", base(func(m map[string]any) { delete(m, "sukun") }), false},
+		{"no final h", base(func(m map[string]any) { delete(m, "final_alifu_sukun") }), false},
+		{"uppercase latin", base(func(m map[string]any) { m["consonants"] = map[string]string{"ބ": "B"} }), false},
+		{"empty latin", base(func(m map[string]any) { m["vowels"] = map[string]string{"ަ": ""} }), false},
+		{"two letters one spelling", base(func(m map[string]any) { m["consonants"] = map[string]string{"ހ": "h", "ޙ": "h"} }), false},
+		{"consonant spelled as a vowel", base(func(m map[string]any) { m["consonants"] = map[string]string{"ހ": "a"} }), false},
+		{"long punctuation", base(func(m map[string]any) { m["punctuation"] = map[string]string{"،": ",,"} }), false},
+		{"not json", "{", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseTranslitTable([]byte(tt.json)); (err == nil) != tt.ok {
+				t.Fatalf("err %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTransliterateRoute(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "translit.json")
+	custom := strings.Replace(string(defaultTranslitTable), `"ށ": "sh"`, `"ށ": "x"`, 1)
+	if err := os.WriteFile(table, []byte(custom), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h := newTestServer(t, nil, Deps{}).Handler()
+	overridden := newTestServer(t, map[string]string{"TRANSLIT_TABLE_FILE": table}, Deps{}).Handler()
+	type result struct {
+		Output    string   `json:"output"`
+		Direction string   `json:"direction"`
+		Notes     []string `json:"notes"`
+		Unmapped  int      `json:"unmapped"`
+		Cached    bool     `json:"cached"`
+	}
+	tests := []struct {
+		name   string
+		h      http.Handler
+		method string
+		query  string
+		body   string
+		status int
+		want   result
+	}{
+		{"thaana detected", h, "GET", "q=ރަށް", "", http.StatusOK, result{Output: "rash", Direction: dirThaanaLatin, Notes: []string{}}},
+		{"cached", h, "GET", "q=ރަށް", "", http.StatusOK, result{Output: "rash", Direction: dirThaanaLatin, Notes: []string{}, Cached: true}},
+		{"other direction, own entry", h, "GET", "q=ރަށް&direction=latin-thaana", "", http.StatusOK, result{Output: "ރަށް", Direction: dirLatinThaana, Notes: []string{"2 characters outside the mapping were passed through"}, Unmapped: 2}},
+		{"latin detected", h, "POST", "", `{"q":"kihineh"}`, http.StatusOK, result{Output: "ކިހިނެއް", Direction: dirLatinThaana, Notes: []string{"a word-final h was written as alifu with sukun"}}},
+		{"unmapped noted", h, "GET", "q=ބަ޲&direction=THAANA-LATIN", "", http.StatusOK, result{Output: "ba޲", Direction: dirThaanaLatin, Notes: []string{"1 character outside the mapping was passed through"}, Unmapped: 1}},
+		{"table from the file", overridden, "GET", "q=ރަށް", "", http.StatusOK, result{Output: "rax", Direction: dirThaanaLatin, Notes: []string{}}},
+		{"missing q", h, "GET", "", "", http.StatusBadRequest, result{}},
+		{"bad direction", h, "GET", "q=rash&direction=up", "", http.StatusBadRequest, result{}},
+		{"no script", h, "GET", "q=1234", "", http.StatusBadRequest, result{}},
+		{"malformed body", h, "POST", "", `{"q":`, http.StatusBadRequest, result{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/go/transliterate"
+			if tt.query != "" {
+				v, _ := url.ParseQuery(tt.query)
+				target += "?" + v.Encode()
+			}
+			w := serve(tt.h, tt.method, target, tt.body, "X-API-Key", testProKey, "Content-Type", "application/json")
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				checkEnvelope(t, w)
+				return
+			}
+			var got result
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Output != tt.want.Output || got.Direction != tt.want.Direction || got.Unmapped != tt.want.Unmapped ||
+				got.Cached != tt.want.Cached || !slices.Equal(got.Notes, tt.want.Notes) || got.Notes == nil {
+				t.Fatalf("%+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Translator resolves a translate request; implemented by the stub and the upstream proxy.
+type Translator interface {
+	Translate(ctx context.Context, req translateReq) (translateResult, error)
+}
+
+// translateResult is what a Translator produces; handlers map it onto the response envelope.
+type translateResult struct {
+	Translation string
+	Src         string   // which layer answered ("stub", "upstream", "pack", "tm", "tm_corrected", "passthrough"; "mixed" across sentences)
+	Pack        string   // pack file name when Src is "pack"
+	Glossary    []string // glossary terms substituted into Translation
+	// GlossaryClient is the part of Glossary from the caller's own glossary; OwnGlossary is set
+	// when the caller has one, matched or not, which keeps the response out of shared caches.
+	GlossaryClient []string
+	OwnGlossary    bool
+	Cached         bool
+	CachedAt       time.Time
+	Stale          bool            // served from the cache past its TTL while it refreshes
+	Attempts       int             // upstream calls made for this result; 0 when none were needed
+	Upstream       string          // which of UPSTREAM_URLS answered; empty when none was called
+	Script         string          // detectScript of the normalized input, set by translateService
+	Pair           langPair        // resolved direction, set by translateService
+	Query          string          // the q this result was translated from, kept with cached copies
+	Match          string          // matchNormalized when answered for another spelling of q
+	Confidence     *float64        // 0..1 when the upstream or pack reports one
+	Alternatives   []alternative   // n-best runners-up, best first; only when the request asked
+	Segments       []segmentResult // per-sentence parts, when the request set Segments
+
+	// Set by translateService when the request omitted src.
+	DetectedSrc         string
+	DetectionConfidence float64
+}
+
+// alternative is one n-best runner-up to a translation.
+type alternative struct {
+	Translation string  `json:"translation"`
+	Confidence  float64 `json:"confidence"`
+}
+
+// matchNormalized marks a result found under q's canonicalKey rather than q as typed.
+const matchNormalized = "normalized"
+
+// stubTranslator echoes the input back (local dev, no upstream configured).
+type stubTranslator struct{}
+
+func (stubTranslator) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	startStage(ctx, "stub").end(stageOK, "")
+	return translateResult{Translation: req.Q, Src: "stub"}, nil
+}
+
+// packOnlyTranslator ends the chain in TRANSLATE_MODE=pack_only: whatever reaches it wasn't in
+// a pack or the cache, and is refused, with near matches, rather than sent anywhere.
+type packOnlyTranslator struct {
+	suggest *suggester
+}
+
+func (t packOnlyTranslator) Translate(ctx context.Context, req translateReq) (translateResult, error) {
+	startStage(ctx, "pack_only").end("refused", "")
+	return translateResult{}, &notCoveredError{Suggestions: t.suggest.suggest(req)}
+}
+
+// upstreamError describes a failed upstream call. Status is 0 when no response was received.
+type upstreamError struct {
+	Status   int
+	Msg      string
+	Attempts int
+	Cached   bool // replayed from the error cache rather than returned by this call
+	Schema   bool // a 2xx whose body broke the upstream schema; Msg says how
+}
+
+func (e *upstreamError) Error() string {
+	if e.Status == 0 {
+		return "upstream: " + e.Msg
+	}
+	return fmt.Sprintf("upstream: %d %s", e.Status, e.Msg)
+}
+
+// clientError reports whether the upstream rejected the request itself (4xx), which is passed through.
+func (e *upstreamError) clientError() bool { return e.Status >= 400 && e.Status < 500 }
+
+// retryable reports whether another attempt may succeed: no response at all, or a gateway-style 5xx
+// such as the backend returns while it is being redeployed.
+func (e *upstreamError) retryable() bool {
+	switch e.Status {
+	case 0, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// transportErrMsg reduces a transport error to a client-safe message (no internal hosts).
+func transportErrMsg(err error) string {
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	return "connection failed"
+}
+
+// upstreamClient proxies translate calls to the FastAPI backend.
+type upstreamClient struct {
+	endpoint *url.URL
+	extended *url.URL // pro-tier route; nil sends extended requests to endpoint too
+	health   *url.URL
+	client   *http.Client
+	retry    retryPolicy
+	strict   bool              // UPSTREAM_STRICT_SCHEMA: unknown response fields are a schema violation
+	timeouts *adaptiveTimeouts // nil without UPSTREAM_ADAPTIVE_TIMEOUT
+	sinks    metricSinks
+	clock    Clock
+}
+
+// retryPolicy bounds how a failed translate call is retried: up to MaxAttempts calls in total,
+// sleeping a random duration in [0, Base*2^n) between them (full jitter), capped at Max.
+type retryPolicy struct {
+	MaxAttempts int
+	Base        time.Duration
+	Max         time.Duration
+	jitter      func(n int64) int64                              // rand.Int63n
+	sleep       func(ctx context.Context, d time.Duration) error // returns early with ctx.Err()
+}
+
+// backoff returns the wait before retry number n (1-based).
+func (p retryPolicy) backoff(n int) time.Duration {
+	ceil := p.Base << (n - 1)
+	if ceil <= 0 || ceil > p.Max {
+		ceil = p.Max
+	}
+	return time.Duration(p.jitter(int64(ceil)) + 1)
+}
+
+// sleepOn is a retryPolicy sleep on clk.
+func sleepOn(clk Clock) func(ctx context.Context, d time.Duration) error {
+	return func(ctx context.Context, d time.Duration) error {
+		t := clk.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// newUpstreamClient builds a client for the FastAPI routes under base (e.g. https://backend.dhkalign.com),
+// making its calls with hc, the shared newUpstreamHTTPClient. maxAttempts of 1 disables retries.
+func newUpstreamClient(base *url.URL, hc *http.Client, maxAttempts int, retryBase time.Duration, clk Clock) *upstreamClient {
+	return &upstreamClient{
+		endpoint: base.JoinPath("translate"),
+		health:   base.JoinPath("health"),
+		client:   hc,
+		clock:    clk,
+		retry: retryPolicy{
+			MaxAttempts: maxAttempts,
+			Base:        retryBase,
+			Max:         2 * time.Second,
+			jitter:      rand.Int63n,
+			sleep:       sleepOn(clk),
+		},
+	}
+}
+
+// Translate calls the upstream with ctx, so a client disconnect cancels the outbound request.
+// Connection failures and 502/503/504 are retried with backoff; a retry is skipped when its wait
+// would outlast ctx's deadline, and cancellation stops retrying at once. The call runs in its own
+// client span and carries traceparent to the FastAPI side.
+func (u *upstreamClient) Translate(ctx context.Context, req translateReq) (res translateResult, err error) {
+	ctx, span := tracer.Start(ctx, "upstream.translate", trace.WithSpanKind(trace.SpanKindClient))
+	attempts := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("upstream.attempts", attempts))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	limit := adaptiveLimit(ctx) // first attempt only; retries get UPSTREAM_TIMEOUT
+	for {
+		attempts++
+		res, err = u.translateOnce(ctx, req, limit)
+		limit = 0
+		var ue *upstreamError
+		if err == nil || !errors.As(err, &ue) {
+			res.Attempts = attempts
+			return res, err
+		}
+		ue.Attempts = attempts
+		if !ue.retryable() || attempts >= u.retry.MaxAttempts || ctx.Err() != nil {
+			return res, err
+		}
+		wait := u.retry.backoff(attempts)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Sub(u.clock.Now()) < wait {
+			return res, err
+		}
+		if u.retry.sleep(ctx, wait) != nil {
+			return res, err
+		}
+		metricUpstreamRetries.Inc()
+	}
+}
+
+// upstreamDeadlineMargin is kept back from the time a call has left when the upstream is told
+// it in X-Deadline-Ms, for the answer's way back.
+const upstreamDeadlineMargin = 50 * time.Millisecond
+
+// setUpstreamDeadline tells the upstream, in X-Deadline-Ms, how long the call has, less
+// upstreamDeadlineMargin: the soonest of limit, ctx's deadline and the request's. The call runs
+// on a flight's context, which takes UPSTREAM_TIMEOUT rather than the request's deadline, so
+// the request's comes from its value on ctx; a shared call tells the upstream the deadline of
+// the request that started it. The route's margin is already off that one, so the upstream
+// gives up in time for the 504 to be written.
+func setUpstreamDeadline(ctx context.Context, hreq *http.Request, limit time.Duration, clk Clock) {
+	now := clk.Now()
+	var ends []time.Time
+	if limit > 0 {
+		ends = append(ends, now.Add(limit))
+	}
+	if at, ok := ctx.Deadline(); ok {
+		ends = append(ends, at)
+	}
+	if dl, ok := deadlineFrom(ctx); ok {
+		ends = append(ends, dl.at)
+	}
+	if len(ends) == 0 {
+		return
+	}
+	left := slices.MinFunc(ends, time.Time.Compare).Sub(now)
+	hreq.Header.Set(deadlineHeader, strconv.FormatInt(max(left-upstreamDeadlineMargin, time.Millisecond).Milliseconds(), 10))
+}
+
+// translateOnce makes a single upstream call, timing out after limit when it is set.
+func (u *upstreamClient) translateOnce(ctx context.Context, req translateReq, limit time.Duration) (translateResult, error) {
+	q := url.Values{"q": {req.Q}, "src_lang": {req.Src}, "tgt_lang": {req.Dst}}
+	if req.NBest > 0 {
+		q.Set("nbest", strconv.Itoa(req.NBest))
+	}
+	target := *u.endpoint
+	if req.Extended && u.extended != nil {
+		target = *u.extended
+	}
+	target.RawQuery = q.Encode()
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return translateResult{}, err
+	}
+	hreq.Header.Set("Accept", "application/json")
+	if id := middleware.GetReqID(ctx); id != "" {
+		hreq.Header.Set(middleware.RequestIDHeader, id)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(hreq.Header))
+	start := time.Now()
+	setUpstreamDeadline(ctx, hreq, limit, u.clock)
+	if limit > 0 {
+		actx, cancel := context.WithTimeout(ctx, limit)
+		defer cancel()
+		hreq = hreq.WithContext(actx)
+	}
+
+	resp, err := u.client.Do(hreq)
+	if err != nil {
+		if limit > 0 && ctx.Err() == nil && errors.Is(hreq.Context().Err(), context.DeadlineExceeded) {
+			u.timeouts.observe(req.Q, limit) // cut off by the adaptive limit: at least this slow
+		}
+		if errors.Is(context.Cause(ctx), errHedgeLost) {
+			return translateResult{}, &upstreamError{Msg: transportErrMsg(err)} // not an upstream fault
+		}
+		slog.Warn("upstream request failed", "request_id", middleware.GetReqID(ctx), "err", scrubURLError(err))
+		countUpstreamError(u.sinks, u.clock.Now(), u.endpoint.Host, transportErrorKind(err))
+		return translateResult{}, &upstreamError{Msg: transportErrMsg(err)}
+	}
+	defer drainClose(resp.Body)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("upstream.status", resp.StatusCode))
+
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if readErr == nil {
+		u.timeouts.observe(req.Q, time.Since(start))
+	}
+	out, decodeErr := decodeUpstreamResponse(body, u.strict)
+	if resp.StatusCode >= 400 {
+		msg := out.Error
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		countUpstreamError(u.sinks, u.clock.Now(), u.endpoint.Host, strconv.Itoa(resp.StatusCode/100)+"xx")
+		return translateResult{}, &upstreamError{Status: resp.StatusCode, Msg: msg}
+	}
+	if readErr != nil {
+		countUpstreamError(u.sinks, u.clock.Now(), u.endpoint.Host, "decode")
+		return translateResult{}, &upstreamError{Status: resp.StatusCode, Msg: "invalid upstream response"}
+	}
+	err = decodeErr
+	if err == nil {
+		err = out.validate(langPair{req.Src, req.Dst})
+	}
+	if err != nil {
+		countUpstreamError(u.sinks, u.clock.Now(), u.endpoint.Host, "schema")
+		slog.Warn("upstream response failed schema validation", "request_id", middleware.GetReqID(ctx),
+			"upstream", u.endpoint.Host, "status", resp.StatusCode, "reason", err, "body", describeUpstreamBody(body))
+		return translateResult{}, &upstreamError{Status: resp.StatusCode, Msg: err.Error(), Schema: true}
+	}
+	res := translateResult{Translation: out.Data.Tgt, Src: "upstream"}
+	if c := out.Data.Confidence; c != nil {
+		res.Confidence = unitScore(*c)
+	}
+	for _, a := range out.Data.Alternatives {
+		if a.Tgt != "" && a.Tgt != res.Translation && len(res.Alternatives) < req.NBest {
+			res.Alternatives = append(res.Alternatives, alternative{Translation: a.Tgt, Confidence: *unitScore(a.Confidence)})
+		}
+	}
+	return res, nil
+}
+
+// unitScore clamps an upstream score into [0, 1]; NaN counts as 0.
+func unitScore(c float64) *float64 {
+	if !(c > 0) {
+		c = 0
+	}
+	c = min(c, 1)
+	return &c
+}
+
+// Ping checks the FastAPI health route; any 2xx counts as reachable.
+func (u *upstreamClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(withUpstreamCall(ctx, upstreamCallHealth), http.MethodGet, u.health.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return errors.New(transportErrMsg(err))
+	}
+	drainClose(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("health returned %d", resp.StatusCode)
+	}
+	return nil
+}
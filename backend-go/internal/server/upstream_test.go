@@ -0,0 +1,419 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeUpstream is the FastAPI translate route, answering by q: "reject" a 404, "fail" a 500,
+// "slow" after a second, "hang" once the caller gives up; anything else is translated.
+type fakeUpstream struct {
+	mu          sync.Mutex
+	requestID   string // the X-Request-Id of the last translate call
+	traceparent string // and its traceparent
+	query       string
+	hung        chan struct{} // closed when a "hang" call arrives
+	gone        chan struct{} // closed when a "hang" call's context ends
+}
+
+func (f *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/health" { // the background poller's, which would race the call under test
+		w.Write([]byte(`{"ok":true}`))
+		return
+	}
+	f.mu.Lock()
+	f.requestID, f.traceparent, f.query = r.Header.Get("X-Request-Id"), r.Header.Get("traceparent"), r.URL.RawQuery
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	switch q.Get("q") {
+	case "reject":
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"ok":false,"error":"no such phrase"}`))
+	case "fail":
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"ok":false,"error":"database locked"}`))
+	case "slow":
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	case "hang":
+		close(f.hung)
+		<-r.Context().Done()
+		close(f.gone)
+	default:
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "data": map[string]string{
+			"tgt": "T(" + q.Get("q") + ")", "src_lang": q.Get("src_lang"), "tgt_lang": q.Get("tgt_lang"),
+		}})
+	}
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	fake := &fakeUpstream{hung: make(chan struct{}), gone: make(chan struct{})}
+	up := httptest.NewServer(fake)
+	defer up.Close()
+	h := newTestServer(t, map[string]string{
+		"UPSTREAM_URL":          up.URL,
+		"UPSTREAM_TIMEOUT":      "200ms",
+		"UPSTREAM_MAX_ATTEMPTS": "1",
+		"BREAKER_FAILURES":      "0",
+	}, Deps{}).Handler()
+	tests := []struct {
+		name        string
+		q           string
+		status      int
+		code        errorCode
+		translation string
+	}{
+		{"translated", "kihineh", http.StatusOK, "", "T(kihineh)"},
+		{"4xx passed through", "reject", http.StatusNotFound, codeUpstreamRejected, ""},
+		{"5xx", "fail", http.StatusBadGateway, codeUpstreamDown, ""},
+		{"timeout", "slow", http.StatusBadGateway, codeUpstreamDown, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := "test-" + tt.q
+			w := serve(h, "POST", "/go/translate", `{"q":"`+tt.q+`","src":"dv","dst":"en"}`,
+				"X-API-Key", testProKey, "Content-Type", "application/json", "X-Request-Id", id)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			fake.mu.Lock()
+			gotID, query := fake.requestID, fake.query
+			fake.mu.Unlock()
+			if gotID != id {
+				t.Fatalf("upstream saw X-Request-Id %q, want %q", gotID, id)
+			}
+			if want := "q=" + tt.q + "&src_lang=dv&tgt_lang=en"; query != want {
+				t.Fatalf("upstream query %q, want %q", query, want)
+			}
+			var res struct {
+				Translation string `json:"translation"`
+				Error       struct {
+					Code errorCode `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Translation != tt.translation || tt.code != "" && res.Error.Code != tt.code {
+				t.Fatalf("translation %q, code %q: %s", res.Translation, res.Error.Code, w.Body.String())
+			}
+		})
+	}
+	t.Run("client gone", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			r := httptest.NewRequest("GET", "/go/translate?q=hang&src=dv&dst=en", nil).WithContext(ctx)
+			r.Header.Set("X-API-Key", testProKey)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+		select {
+		case <-fake.hung:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the call never reached the upstream")
+		}
+		cancel()
+		select {
+		case <-fake.gone:
+		case <-time.After(150 * time.Millisecond): // well inside UPSTREAM_TIMEOUT
+			t.Fatal("upstream call outlived the client")
+		}
+		<-done
+	})
+}
+
+// scriptedUpstream answers its translate calls with statuses in turn, then 200s, counting them.
+type scriptedUpstream struct {
+	mu       sync.Mutex
+	statuses []int
+	calls    int
+}
+
+func (s *scriptedUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/translate") {
+		return
+	}
+	s.mu.Lock()
+	status := http.StatusOK
+	if s.calls < len(s.statuses) {
+		status = s.statuses[s.calls]
+	}
+	s.calls++
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		_, _ = w.Write([]byte(`{"ok":true,"data":{"tgt":"T"}}`))
+	}
+}
+
+func (s *scriptedUpstream) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// retryClient is a client for url that takes the longest backoff each time, waiting on clk.
+func retryClient(t *testing.T, rawURL string, maxAttempts int, clk Clock) *upstreamClient {
+	t.Helper()
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := newUpstreamClient(base, newUpstreamHTTPClient(time.Second, http.DefaultTransport, clk), maxAttempts, 100*time.Millisecond, clk)
+	u.retry.jitter = func(n int64) int64 { return n - 1 }
+	return u
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := retryPolicy{Base: 100 * time.Millisecond, Max: 2 * time.Second, jitter: func(n int64) int64 { return n - 1 }}
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, 1600 * time.Millisecond},
+		{6, 2 * time.Second},
+		{70, 2 * time.Second}, // the shift overflows
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.retry); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.retry, got, tt.want)
+		}
+	}
+	p.jitter = func(int64) int64 { return 0 }
+	if got := p.backoff(3); got != time.Nanosecond {
+		t.Errorf("backoff with no jitter = %v, want 1ns", got)
+	}
+}
+
+func TestUpstreamRetries(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	tests := []struct {
+		name        string
+		statuses    []int
+		maxAttempts int
+		calls       int
+		status      int // of the upstreamError; 0 is success
+	}{
+		{"ok", nil, 3, 1, 0},
+		{"503 then ok", []int{503}, 3, 2, 0},
+		{"502 and 504 then ok", []int{502, 504}, 3, 3, 0},
+		{"gives up after max attempts", []int{503, 503, 503, 503}, 3, 3, 503},
+		{"4xx is not retried", []int{404}, 3, 1, 404},
+		{"429 is not retried", []int{429}, 3, 1, 429},
+		{"500 is not retried", []int{500}, 3, 1, 500},
+		{"retries disabled", []int{503}, 1, 1, 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := &scriptedUpstream{statuses: tt.statuses}
+			srv := httptest.NewServer(up)
+			defer srv.Close()
+			u := retryClient(t, srv.URL, tt.maxAttempts, systemClock{})
+			u.retry.sleep = func(context.Context, time.Duration) error { return nil }
+			res, err := u.Translate(context.Background(), translateReq{Q: "x", Src: "dv", Dst: "en"})
+			if got := up.count(); got != tt.calls {
+				t.Fatalf("%d calls, want %d", got, tt.calls)
+			}
+			if got := attemptsOf(res, err); got != tt.calls {
+				t.Fatalf("attempts %d, want %d", got, tt.calls)
+			}
+			var ue *upstreamError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Fatalf("err %v, want success", err)
+			case tt.status != 0 && (!errors.As(err, &ue) || ue.Status != tt.status):
+				t.Fatalf("err %v, want status %d", err, tt.status)
+			}
+		})
+	}
+	t.Run("connection refused", func(t *testing.T) {
+		u := retryClient(t, closed.URL, 3, systemClock{})
+		u.retry.sleep = func(context.Context, time.Duration) error { return nil }
+		_, err := u.Translate(context.Background(), translateReq{Q: "x"})
+		if got := attemptsOf(translateResult{}, err); got != 3 {
+			t.Fatalf("attempts %d (%v), want 3", got, err)
+		}
+	})
+}
+
+func TestUpstreamBackoffOnTheClock(t *testing.T) {
+	up := &scriptedUpstream{statuses: []int{503, 503, 503}}
+	srv := httptest.NewServer(up)
+	defer srv.Close()
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	u := retryClient(t, srv.URL, 3, clk)
+	done := make(chan error, 1)
+	go func() {
+		_, err := u.Translate(context.Background(), translateReq{Q: "x"})
+		done <- err
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, wait := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		if err := clk.BlockUntil(ctx, 1); err != nil {
+			t.Fatalf("retry %d: no backoff started", i+1)
+		}
+		if got := up.count(); got != i+1 {
+			t.Fatalf("retry %d: %d calls before the backoff, want %d", i+1, got, i+1)
+		}
+		clk.Advance(wait - time.Millisecond)
+		if clk.Waiters() != 1 {
+			t.Fatalf("retry %d: backoff over before %v", i+1, wait)
+		}
+		clk.Advance(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		if got := attemptsOf(translateResult{}, err); got != 3 {
+			t.Fatalf("attempts %d (%v), want 3", got, err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Translate did not return after the last attempt")
+	}
+}
+
+func TestUpstreamRetryStopsOnCancel(t *testing.T) {
+	up := &scriptedUpstream{statuses: []int{503, 503, 503}}
+	srv := httptest.NewServer(up)
+	defer srv.Close()
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	u := retryClient(t, srv.URL, 3, clk)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := u.Translate(ctx, translateReq{Q: "x"})
+		done <- err
+	}()
+	wctx, wcancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer wcancel()
+	if err := clk.BlockUntil(wctx, 1); err != nil {
+		t.Fatal("no backoff started")
+	}
+	cancel() // the clock never moves: only the cancellation can end the wait
+	select {
+	case <-done:
+	case <-wctx.Done():
+		t.Fatal("Translate kept waiting after cancellation")
+	}
+	if got := up.count(); got != 1 {
+		t.Fatalf("%d calls, want 1", got)
+	}
+}
+
+func TestUpstreamRetryWithinDeadline(t *testing.T) {
+	up := &scriptedUpstream{statuses: []int{503, 503, 503}}
+	srv := httptest.NewServer(up)
+	defer srv.Close()
+	u := retryClient(t, srv.URL, 3, systemClock{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	u.Translate(ctx, translateReq{Q: "x"}) // the 100ms backoff would outlast the deadline
+	if got := up.count(); got != 1 || time.Since(start) > 50*time.Millisecond {
+		t.Fatalf("%d calls in %v, want 1 without waiting", got, time.Since(start))
+	}
+}
+
+func TestUpstreamAttemptsHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		debug string
+		want  string
+	}{
+		{"debug", "true", "2"},
+		{"no debug", "false", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(&scriptedUpstream{statuses: []int{502}})
+			defer srv.Close()
+			h := newTestServer(t, map[string]string{
+				"UPSTREAM_URL":        srv.URL,
+				"UPSTREAM_RETRY_BASE": "1ms",
+				"DEBUG_HEADERS":       tt.debug,
+			}, Deps{}).Handler()
+			w := serve(h, "GET", "/go/translate?q=hello", "", "X-API-Key", testProKey)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("X-Upstream-Attempts"); got != tt.want {
+				t.Fatalf("X-Upstream-Attempts %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPackOnlyMode points a pack_only server at a live upstream and checks it answers from its
+// packs, refuses the rest with 501 NOT_COVERED, reports its mode, and never calls the upstream,
+// not even to probe its health; and that proxy mode without an upstream fails to load.
+func TestPackOnlyMode(t *testing.T) {
+	var calls atomic.Int64
+	fake := &fakeUpstream{}
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fake.ServeHTTP(w, r)
+	}))
+	defer up.Close()
+	dir := t.TempDir()
+	pack := `{"src":"dv","dst":"en","source_text":"salaam","target_text":"hello"}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "greetings.jsonl"), []byte(pack), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mark := suiteLog.mark()
+	h := newTestServer(t, map[string]string{"TRANSLATE_MODE": "pack_only", "UPSTREAM_URL": up.URL, "PACK_DIR": dir}, Deps{}).Handler()
+	if logged := suiteLog.since(mark); !strings.Contains(logged, `"msg":"translate mode","mode":"pack_only"`) {
+		t.Fatalf("mode not logged at startup:\n%s", logged)
+	}
+
+	w := serve(h, "GET", "/go/translate?q=salaam&src=dv&dst=en", "", "X-API-Key", testProKey)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"translation":"hello"`) {
+		t.Fatalf("covered: status %d: %s", w.Code, w.Body.String())
+	}
+	w = serve(h, "GET", "/go/translate?q=kihineh&src=dv&dst=en", "", "X-API-Key", testProKey)
+	checkEnvelope(t, w)
+	var res struct {
+		Error struct {
+			Code    errorCode `json:"code"`
+			Message string    `json:"message"`
+		} `json:"error"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if w.Code != http.StatusNotImplemented || res.Error.Code != codeNotCovered || !strings.Contains(res.Error.Message, "TRANSLATE_MODE=pack_only never calls the upstream") {
+		t.Fatalf("not covered: status %d: %s", w.Code, w.Body.String())
+	}
+	serve(h, "GET", "/go/ready", "")
+	var v struct {
+		TranslateMode string `json:"translate_mode"`
+	}
+	if json.Unmarshal(serve(h, "GET", "/go/version", "").Body.Bytes(), &v); v.TranslateMode != "pack_only" {
+		t.Fatalf("/go/version translate_mode %q, want pack_only", v.TranslateMode)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Fatalf("%d calls reached the upstream in pack_only mode", n)
+	}
+
+	_, err := LoadConfig(func(k string) string { return map[string]string{"ENV": "development", "TRANSLATE_MODE": "proxy"}[k] })
+	if err == nil || !strings.Contains(err.Error(), "proxy needs UPSTREAM_URL or UPSTREAM_URLS") {
+		t.Fatalf("proxy without an upstream: err %v", err)
+	}
+}
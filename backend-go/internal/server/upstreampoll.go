@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// upstreamPoller probes the upstream health route in the background, so /go/ready and
+// /go/health?verbose=1 report its last known state instead of each adding a probe of their
+// own. It keeps the last few results, for a sense of flapping, and pauses while maintenance
+// mode is on, when the upstream is expected to be away.
+type upstreamPoller struct {
+	name     string
+	probe    func(ctx context.Context) error
+	interval time.Duration
+	timeout  time.Duration
+	maint    *maintenanceMode
+	clock    Clock
+
+	mu       sync.Mutex
+	window   []bool // ring of recent results, true = ok
+	next     int
+	polled   bool
+	lastErr  string
+	latency  time.Duration
+	polledAt time.Time
+	okAt     time.Time
+	failAt   time.Time
+}
+
+func newUpstreamPoller(name string, probe func(context.Context) error, interval, timeout time.Duration, window int, maint *maintenanceMode, clk Clock) *upstreamPoller {
+	return &upstreamPoller{name: name, probe: probe, interval: interval, timeout: timeout, maint: maint, clock: clk, window: make([]bool, 0, window)}
+}
+
+// run polls every interval until ctx is done, starting at once.
+func (p *upstreamPoller) run(ctx context.Context) {
+	t := p.clock.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		if !p.maint.state().Enabled {
+			p.poll(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+		}
+	}
+}
+
+func (p *upstreamPoller) poll(parent context.Context) error {
+	ctx, cancel := context.WithTimeout(parent, p.timeout)
+	defer cancel()
+	start := time.Now()
+	err := p.probe(ctx)
+	latency := time.Since(start)
+	if parent.Err() != nil {
+		return err // shutting down, or the caller left; says nothing about the upstream
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ok := err == nil
+	if p.polled && ok != p.lastOK() {
+		if ok {
+			slog.Info("upstream health recovered", "upstream", p.name, "latency", latency.String())
+		} else {
+			slog.Warn("upstream health check failing", "upstream", p.name, "err", err)
+		}
+	}
+	if len(p.window) < cap(p.window) {
+		p.window = append(p.window, ok)
+	} else {
+		p.window[p.next] = ok
+		p.next = (p.next + 1) % len(p.window)
+	}
+	p.polled, p.latency, p.polledAt = true, latency, p.clock.Now()
+	if ok {
+		p.okAt, p.lastErr = p.polledAt, ""
+		metricUpstreamUp.WithLabelValues(p.name).Set(1)
+	} else {
+		p.failAt, p.lastErr = p.polledAt, err.Error()
+		metricUpstreamUp.WithLabelValues(p.name).Set(0)
+	}
+	return err
+}
+
+// lastOK must be called with mu held, after at least one poll.
+func (p *upstreamPoller) lastOK() bool {
+	return p.window[(p.next+len(p.window)-1)%len(p.window)]
+}
+
+// Probe reports the last poll's outcome, as a dependency probe for /go/ready. Before the first
+// poll has finished it polls inline, so readiness never reports a state nobody observed.
+func (p *upstreamPoller) Probe(ctx context.Context) error {
+	p.mu.Lock()
+	polled, lastErr := p.polled, p.lastErr
+	p.mu.Unlock()
+	if !polled {
+		return p.poll(ctx)
+	}
+	if lastErr != "" {
+		return errors.New(lastErr)
+	}
+	return nil
+}
+
+// up reports whether the last poll passed; true before the first one, so a fresh member isn't
+// passed over on no evidence.
+func (p *upstreamPoller) up() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.polled || p.lastErr == ""
+}
+
+// okSince reports the time of the last successful poll, for the breaker.
+func (p *upstreamPoller) okSince() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.okAt
+}
+
+// status is the poller's view for /go/health?verbose=1.
+func (p *upstreamPoller) status() map[string]any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ok := 0
+	for _, r := range p.window {
+		if r {
+			ok++
+		}
+	}
+	out := map[string]any{
+		"interval_s": p.interval.Seconds(),
+		"paused":     p.maint.state().Enabled,
+		"window":     len(p.window),
+		"window_ok":  ok,
+	}
+	if !p.polled {
+		return out
+	}
+	out["ok"] = p.lastOK()
+	out["latency_ms"] = float64(p.latency.Microseconds()) / 1000
+	out["polled_at"] = p.polledAt.UTC().Format(time.RFC3339)
+	if !p.okAt.IsZero() {
+		out["last_ok_at"] = p.okAt.UTC().Format(time.RFC3339)
+	}
+	if !p.failAt.IsZero() {
+		out["last_failure_at"] = p.failAt.UTC().Format(time.RFC3339)
+	}
+	if p.lastErr != "" {
+		out["error"] = p.lastErr
+	}
+	return out
+}
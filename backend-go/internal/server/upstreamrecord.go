@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Upstream fixtures. With RECORD_UPSTREAM_DIR set, every exchange with the FastAPI upstream is
+// also written to that directory as a fixture: method, path, query, status and body, with no
+// headers and with credential-like query parameters dropped. REPLAY_UPSTREAM_DIR serves the
+// upstream from such a directory instead, offline, so local development and tests run against
+// responses the real backend gave rather than hand-written fakes. Both sit under the traced
+// client, so the timing, breaker and failover layers above see them as the network; neither is
+// allowed with ENV=production.
+
+// upstreamFixture is one recorded exchange, stored as <method>-<path>-<hash>.json. Recording the
+// same request again overwrites its file. LatencyMS is informational; replay answers at once.
+type upstreamFixture struct {
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Query       string          `json:"query,omitempty"` // normalized: keys sorted, credentials dropped
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"` // JSON bodies as they came…
+	Text        string          `json:"text,omitempty"` // …anything else as a string
+	LatencyMS   float64         `json:"latency_ms"`
+	RecordedAt  time.Time       `json:"recorded_at"`
+}
+
+// fixtureSecretParams are the query parameters never written to a fixture or matched on.
+var fixtureSecretParams = []string{"key", "token", "secret", "password", "signature", "sig", "auth"}
+
+// fixtureQuery normalizes a raw query for storing and matching: parameters sorted by name, and
+// any whose name contains one of fixtureSecretParams dropped.
+func fixtureQuery(raw string) string {
+	q, _ := url.ParseQuery(raw)
+	for name := range q {
+		n := strings.ToLower(name)
+		for _, s := range fixtureSecretParams {
+			if strings.Contains(n, s) {
+				q.Del(name)
+				break
+			}
+		}
+	}
+	return q.Encode()
+}
+
+// fixtureKey is what a request is matched on: method, path and normalized query. The host is
+// left out so fixtures recorded against one upstream replay under any UPSTREAM_URL.
+func fixtureKey(method, path, query string) string {
+	return strings.ToUpper(method) + " " + path + "?" + query
+}
+
+// fixtureName is the file a request's fixture is stored in.
+func fixtureName(key, method, path string) string {
+	slug := strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(path)), "-")
+	if slug == "" {
+		slug = "root"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return strings.ToLower(method) + "-" + slug + "-" + hex.EncodeToString(sum[:6]) + ".json"
+}
+
+// recordingTransport writes each upstream exchange that gets a response to dir. A fixture that
+// can't be written is logged and the response still reaches the caller.
+type recordingTransport struct {
+	next http.RoundTripper
+	dir  string
+}
+
+func newRecordingTransport(next http.RoundTripper, dir string) (*recordingTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &recordingTransport{next: next, dir: dir}, nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	drainClose(resp.Body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return resp, nil // the caller sees the short body; nothing worth recording
+	}
+	fx := upstreamFixture{
+		Method:      req.Method,
+		Path:        req.URL.Path,
+		Query:       fixtureQuery(req.URL.RawQuery),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		LatencyMS:   float64(time.Since(start).Microseconds()) / 1e3,
+		RecordedAt:  time.Now().UTC(),
+	}
+	if json.Valid(body) {
+		fx.Body = body
+	} else {
+		fx.Text = string(body)
+	}
+	if err := t.write(fx); err != nil {
+		slog.Warn("upstream fixture not written", "request_id", middleware.GetReqID(req.Context()), "dir", t.dir, "err", err)
+	}
+	return resp, nil
+}
+
+// write stores fx under its fixtureName, through a temporary file so a replay never reads half
+// of one.
+func (t *recordingTransport) write(fx upstreamFixture) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // keep queries' & readable
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(fx); err != nil {
+		return err
+	}
+	name := fixtureName(fixtureKey(fx.Method, fx.Path, fx.Query), fx.Method, fx.Path)
+	tmp, err := os.CreateTemp(t.dir, ".fixture-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(t.dir, name))
+}
+
+func (t *recordingTransport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// replayTransport answers upstream requests from a directory of fixtures. A request with no
+// fixture fails, and is logged at error level, rather than reaching any network.
+type replayTransport struct {
+	dir      string
+	fixtures map[string]upstreamFixture
+}
+
+// newReplayTransport loads every *.json fixture in dir; a malformed one, or a translate fixture
+// that breaks the upstream schema (strict as for live responses), fails the load.
+func newReplayTransport(dir string, strict bool) (*replayTransport, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	t := &replayTransport{dir: dir, fixtures: make(map[string]upstreamFixture, len(paths))}
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var fx upstreamFixture
+		if err := json.Unmarshal(b, &fx); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+		if fx.Method == "" || fx.Status == 0 {
+			return nil, fmt.Errorf("%s: method and status are required", filepath.Base(p))
+		}
+		if err := checkUpstreamFixture(fx, strict); err != nil {
+			return nil, fmt.Errorf("%s: upstream schema: %w", filepath.Base(p), err)
+		}
+		t.fixtures[fixtureKey(fx.Method, fx.Path, fixtureQuery(fx.Query))] = fx
+	}
+	slog.Info("replaying upstream fixtures", "dir", dir, "fixtures", len(t.fixtures))
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key := fixtureKey(req.Method, req.URL.Path, fixtureQuery(req.URL.RawQuery))
+	fx, ok := t.fixtures[key]
+	if !ok {
+		logged := fixtureKey(req.Method, req.URL.Path, describeQuery(fixtureQuery(req.URL.RawQuery)))
+		slog.Error("no upstream fixture for request", "request_id", middleware.GetReqID(req.Context()), "dir", t.dir, "request", logged)
+		return nil, fmt.Errorf("replay: no fixture in %s for %s", t.dir, logged)
+	}
+	body := []byte(fx.Body)
+	if fx.Body == nil {
+		body = []byte(fx.Text)
+	}
+	h := http.Header{}
+	if fx.ContentType != "" {
+		h.Set("Content-Type", fx.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fx.Status, http.StatusText(fx.Status)),
+		StatusCode:    fx.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
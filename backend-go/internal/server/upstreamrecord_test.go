@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRecordReplayRoundTrip records exchanges with a live upstream and replays them: a fixture
+// keeps no headers or credential parameters, its query is normalized, recording a request again
+// overwrites it, and replay matches on method, path and query whatever the host or the order.
+func TestRecordReplayRoundTrip(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.Write([]byte("ok"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "data": map[string]any{"tgt": "EN(" + r.URL.Query().Get("q") + ")"}})
+	}))
+	defer live.Close()
+	dir := t.TempDir()
+	rec, err := newRecordingTransport(http.DefaultTransport, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(rt http.RoundTripper, method, target string) (*http.Response, string, error) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		req.RequestURI = ""
+		req.Header.Set("Authorization", "Bearer header-secret")
+		res, err := rt.RoundTrip(req)
+		if err != nil {
+			return nil, "", err
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res, string(b), nil
+	}
+
+	liveBody := ""
+	for _, secret := range []string{"first-secret", "second-secret"} {
+		_, body, err := do(rec, "GET", live.URL+"/translate?tgt_lang=en&q=salaam&api_key="+secret+"&src_lang=dv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		liveBody = body
+	}
+	if _, _, err := do(rec, "GET", live.URL+"/health"); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("%d fixtures, want 2: the repeated request overwrites its own", len(files))
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"first-secret", "second-secret", "header-secret", "Authorization"} {
+			if strings.Contains(string(b), secret) {
+				t.Fatalf("%s keeps %q:\n%s", filepath.Base(f), secret, b)
+			}
+		}
+		var fx upstreamFixture
+		if err := json.Unmarshal(b, &fx); err != nil {
+			t.Fatal(err)
+		}
+		if fx.Path == "/translate" && fx.Query != "q=salaam&src_lang=dv&tgt_lang=en" {
+			t.Fatalf("query %q, want it sorted without api_key", fx.Query)
+		}
+	}
+
+	replay, err := newReplayTransport(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string // empty when there is no fixture to match
+		ctype  string
+	}{
+		{"translate", "GET", "http://upstream.replay/translate?src_lang=dv&q=salaam&tgt_lang=en", liveBody, "application/json"},
+		{"another credential", "GET", "http://elsewhere.test/translate?q=salaam&token=x&src_lang=dv&tgt_lang=en", liveBody, "application/json"},
+		{"text body", "GET", "http://upstream.replay/health", "ok", "text/plain; charset=utf-8"},
+		{"other phrase", "GET", "http://upstream.replay/translate?q=shukuriyyaa&src_lang=dv&tgt_lang=en", "", ""},
+		{"other method", "POST", "http://upstream.replay/translate?q=salaam&src_lang=dv&tgt_lang=en", "", ""},
+		{"other path", "GET", "http://upstream.replay/translate/extended?q=salaam&src_lang=dv&tgt_lang=en", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body, err := do(replay, tt.method, tt.target)
+			if tt.body == "" {
+				if err == nil || !strings.Contains(err.Error(), "no fixture") {
+					t.Fatalf("err %v, want no fixture", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if json.Valid([]byte(body)) { // stored indented, so compared as JSON
+				var got, want bytes.Buffer
+				json.Compact(&got, []byte(body))
+				json.Compact(&want, []byte(tt.body))
+				body, tt.body = got.String(), want.String()
+			}
+			if res.StatusCode != http.StatusOK || body != tt.body || res.Header.Get("Content-Type") != tt.ctype {
+				t.Fatalf("status %d, Content-Type %q, body %q; want 200, %q, %q", res.StatusCode, res.Header.Get("Content-Type"), body, tt.ctype, tt.body)
+			}
+		})
+	}
+}
+
+// TestReplayUpstreamFlows serves the main translate flows from the checked-in fixtures through a
+// server with REPLAY_UPSTREAM_DIR and no upstream at all.
+func TestReplayUpstreamFlows(t *testing.T) {
+	h := newTestServer(t, map[string]string{
+		"REPLAY_UPSTREAM_DIR":    upstreamFixtures,
+		"UPSTREAM_STRICT_SCHEMA": "1",
+		"UPSTREAM_MAX_ATTEMPTS":  "1",
+	}, Deps{}).Handler()
+	tests := []struct {
+		name        string
+		target      string
+		status      int
+		translation string
+		code        errorCode
+	}{
+		{"latin", "/go/translate?q=miadhu&src=latin&dst=en", http.StatusOK, "today", ""},
+		{"thaana", "/go/translate?q=%DE%8B%DE%A8%DE%88%DE%AC%DE%80%DE%A8", http.StatusOK, "Dhivehi", ""},
+		{"english", "/go/translate?q=good+morning&src=en&dst=dv", http.StatusOK, "ރަނގަޅު ހެނދުނެއް", ""},
+		{"nbest", "/go/translate?q=miadhu&src=latin&dst=en&nbest=2", http.StatusOK, "today", ""},
+		{"rejected", "/go/translate?q=unknownword&src=latin&dst=en", http.StatusNotFound, "", codeUpstreamRejected},
+		{"upstream down", "/go/translate?q=boom&src=latin&dst=en", http.StatusBadGateway, "", codeUpstreamDown},
+		{"no fixture", "/go/translate?q=never+recorded&src=latin&dst=en", http.StatusBadGateway, "", codeUpstreamDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, "GET", tt.target, "", "X-API-Key", testProKey)
+			var res struct {
+				Translation  string `json:"translation"`
+				Alternatives []any  `json:"alternatives"`
+				Error        struct {
+					Code errorCode `json:"code"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if w.Code != tt.status || res.Translation != tt.translation || res.Error.Code != tt.code {
+				t.Fatalf("status %d: %s; want %d with %q %s", w.Code, w.Body.String(), tt.status, tt.translation, tt.code)
+			}
+			if tt.name == "nbest" && len(res.Alternatives) != 2 {
+				t.Fatalf("%d alternatives, want the fixture's 2", len(res.Alternatives))
+			}
+		})
+	}
+}
+
+func TestRecordReplayConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  string // empty when the configuration loads
+	}{
+		{"record", map[string]string{"RECORD_UPSTREAM_DIR": dir, "UPSTREAM_URL": "http://127.0.0.1:8000"}, ""},
+		{"replay without an upstream", map[string]string{"REPLAY_UPSTREAM_DIR": dir}, ""},
+		{"both", map[string]string{"RECORD_UPSTREAM_DIR": dir, "REPLAY_UPSTREAM_DIR": dir}, "RECORD_UPSTREAM_DIR: cannot be combined with REPLAY_UPSTREAM_DIR"},
+		{"record in production", map[string]string{"ENV": "production", "RECORD_UPSTREAM_DIR": dir}, "RECORD_UPSTREAM_DIR: not allowed when ENV=production"},
+		{"replay in production", map[string]string{"ENV": "production", "REPLAY_UPSTREAM_DIR": dir}, "REPLAY_UPSTREAM_DIR: not allowed when ENV=production"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{"ENV": "development"}
+			for k, v := range tt.env {
+				vars[k] = v
+			}
+			_, err := LoadConfig(func(k string) string { return vars[k] })
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("err %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Upstream response schema. A 2xx from the FastAPI /translate routes must be the envelope below
+// with ok set, a non-empty tgt, and, where it names one, the direction that was asked for; a
+// body that isn't is a 502 UPSTREAM_SCHEMA, logged with as much of the body as LOG_CONTENT
+// allows, rather than a translation made of whatever decoded. UPSTREAM_STRICT_SCHEMA also
+// refuses fields the envelope doesn't know, to catch the backend changing shape before a field
+// this side relies on moves. Replayed fixtures are held to the same schema when they load, so
+// a re-recorded fixture directory that drifted fails there first.
+
+// upstreamResponse is the FastAPI envelope: {"ok": bool, "data": {...}, "error": "..."}.
+type upstreamResponse struct {
+	OK    bool                 `json:"ok"`
+	Error string               `json:"error"`
+	Data  *upstreamTranslation `json:"data"`
+}
+
+// upstreamTranslation is data in a translate response. Src, Pack and Source are informational.
+type upstreamTranslation struct {
+	Src          string                `json:"src"`
+	Tgt          string                `json:"tgt"`
+	SrcLang      string                `json:"src_lang"`
+	TgtLang      string                `json:"tgt_lang"`
+	Pack         string                `json:"pack"`
+	Source       string                `json:"source"` // db or gpt
+	Confidence   *float64              `json:"confidence"`
+	Alternatives []upstreamAlternative `json:"alternatives"`
+}
+
+type upstreamAlternative struct {
+	Tgt        string  `json:"tgt"`
+	Confidence float64 `json:"confidence"`
+}
+
+// decodeUpstreamResponse decodes body, refusing unknown fields when strict.
+func decodeUpstreamResponse(body []byte, strict bool) (upstreamResponse, error) {
+	var out upstreamResponse
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&out); err != nil {
+		return out, errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return out, nil
+}
+
+// validate checks a 2xx translate response to a request for want.
+func (r upstreamResponse) validate(want langPair) error {
+	switch d := r.Data; {
+	case !r.OK:
+		return errors.New("ok is not true")
+	case d == nil:
+		return errors.New("missing data")
+	case strings.TrimSpace(d.Tgt) == "":
+		return errors.New("missing data.tgt")
+	case d.SrcLang != "" && d.SrcLang != want.Src, d.TgtLang != "" && d.TgtLang != want.Dst:
+		return fmt.Errorf("answered %s→%s for %s→%s", orUnset(d.SrcLang), orUnset(d.TgtLang), want.Src, want.Dst)
+	}
+	for i, a := range r.Data.Alternatives {
+		if strings.TrimSpace(a.Tgt) == "" {
+			return fmt.Errorf("missing data.alternatives[%d].tgt", i)
+		}
+	}
+	return nil
+}
+
+func orUnset(s string) string {
+	if s == "" {
+		return "(unset)"
+	}
+	return s
+}
+
+// upstreamBodyLogBytes bounds the body in a schema violation's log line.
+const upstreamBodyLogBytes = 512
+
+// describeUpstreamBody is body for a log line. Under LOG_CONTENT=full it is the body, cut at
+// upstreamBodyLogBytes; otherwise JSON keeps its shape with every string through
+// describeContent, so the line shows which fields came back without the text in them.
+func describeUpstreamBody(body []byte) string {
+	if contentMode().mode == logContentFull {
+		return truncateBytes(string(body), upstreamBodyLogBytes)
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "non-JSON " + describeContent(string(body))
+	}
+	b, _ := json.Marshal(scrubStrings(v))
+	return truncateBytes(string(b), upstreamBodyLogBytes)
+}
+
+// scrubStrings replaces every string in a decoded JSON value with its describeContent.
+func scrubStrings(v any) any {
+	switch v := v.(type) {
+	case string:
+		return describeContent(v)
+	case []any:
+		for i := range v {
+			v[i] = scrubStrings(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = scrubStrings(v[k])
+		}
+	}
+	return v
+}
+
+// truncateBytes cuts s to at most n bytes, on a rune boundary, marking the cut.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+// checkUpstreamFixture holds a replayed 2xx translate fixture to the schema a live response
+// would be.
+func checkUpstreamFixture(fx upstreamFixture, strict bool) error {
+	if fx.Status/100 != 2 || !strings.HasPrefix(strings.TrimPrefix(fx.Path, "/"), "translate") {
+		return nil
+	}
+	body := []byte(fx.Body)
+	if fx.Body == nil {
+		body = []byte(fx.Text)
+	}
+	out, err := decodeUpstreamResponse(body, strict)
+	if err != nil {
+		return err
+	}
+	q, _ := url.ParseQuery(fx.Query)
+	return out.validate(langPair{q.Get("src_lang"), q.Get("tgt_lang")})
+}
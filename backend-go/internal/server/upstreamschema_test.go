@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// upstreamFixtures is the recorded exchanges REPLAY_UPSTREAM_DIR replays in development.
+const upstreamFixtures = "../../testdata/upstream"
+
+// TestUpstreamFixturesContract holds every recorded upstream exchange to the response schema,
+// strictly, so a re-recorded fixture whose envelope drifted fails here.
+func TestUpstreamFixturesContract(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(upstreamFixtures, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var translates int
+	for _, f := range files {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fx upstreamFixture
+			if err := json.Unmarshal(b, &fx); err != nil {
+				t.Fatal(err)
+			}
+			if err := checkUpstreamFixture(fx, true); err != nil {
+				t.Fatal(err)
+			}
+			if fx.Status/100 == 2 && strings.HasPrefix(fx.Path, "/translate") {
+				translates++
+			}
+		})
+	}
+	if translates == 0 {
+		t.Fatalf("no 2xx translate fixtures in %s", upstreamFixtures)
+	}
+}
+
+func TestUpstreamResponseSchema(t *testing.T) {
+	dvEN := langPair{"dv", "en"}
+	tests := []struct {
+		name   string
+		body   string
+		strict bool
+		err    string // empty when the body passes
+	}{
+		{"valid", `{"ok":true,"data":{"tgt":"hello","src_lang":"dv","tgt_lang":"en"}}`, true, ""},
+		{"no direction", `{"ok":true,"data":{"tgt":"hello"}}`, true, ""},
+		{"unknown field", `{"ok":true,"data":{"tgt":"hello","score":1}}`, false, ""},
+		{"unknown field strictly", `{"ok":true,"data":{"tgt":"hello","score":1}}`, true, `unknown field "score"`},
+		{"not ok", `{"ok":false,"error":"boom"}`, false, "ok is not true"},
+		{"no data", `{"ok":true}`, false, "missing data"},
+		{"empty tgt", `{"ok":true,"data":{"tgt":"  "}}`, false, "missing data.tgt"},
+		{"wrong direction", `{"ok":true,"data":{"tgt":"hello","src_lang":"en","tgt_lang":"dv"}}`, false, "answered en→dv for dv→en"},
+		{"empty alternative", `{"ok":true,"data":{"tgt":"hello","alternatives":[{"tgt":"hi"},{"tgt":""}]}}`, false, "missing data.alternatives[1].tgt"},
+		{"not JSON", `<html>502</html>`, false, "invalid character"},
+		{"wrong type", `{"ok":true,"data":{"tgt":7}}`, false, "cannot unmarshal number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := decodeUpstreamResponse([]byte(tt.body), tt.strict)
+			if err == nil {
+				err = out.validate(dvEN)
+			}
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// TestUpstreamSchemaViolation sends a translate to an upstream answering a broken envelope.
+func TestUpstreamSchemaViolation(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true,"data":{"tgt":""}}`))
+	}))
+	defer up.Close()
+	h := newTestServer(t, map[string]string{"UPSTREAM_URL": up.URL}, Deps{}).Handler()
+	w := serve(h, "POST", "/go/translate", `{"q":"kihineh"}`, "X-API-Key", testProKey, "Content-Type", "application/json")
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status %d, want 502: %s", w.Code, w.Body.String())
+	}
+	var res struct {
+		Error struct {
+			Code   errorCode `json:"code"`
+			Detail string    `json:"detail"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if e := res.Error; e.Code != codeUpstreamSchema || e.Detail != "missing data.tgt" {
+		t.Fatalf("code %s, detail %q", e.Code, e.Detail)
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Upstream call timing. Every call to a FastAPI upstream goes through the one client built by
+// newUpstreamHTTPClient, whose transport follows each request with httptrace and records how long
+// DNS, connecting, the TLS handshake, the first response byte and the whole exchange took, by
+// upstream host, before handing it to the shared outbound transport. Translate calls also feed a
+// five-minute window per host that /go/stats reports, so a regression can be put on the backend
+// or on this side without a Prometheus query.
+
+// Upstream call kinds, the call label on dhk_go_upstream_phase_seconds.
+const (
+	upstreamCallTranslate = "translate"
+	upstreamCallHealth    = "health"
+)
+
+// withUpstreamCall marks requests made with ctx as kind; unmarked ones are translate calls.
+func withUpstreamCall(ctx context.Context, kind string) context.Context {
+	return context.WithValue(ctx, upstreamCallCtxKey, kind)
+}
+
+// newUpstreamHTTPClient is the client every upstreamClient shares, over the outbound transport rt;
+// timeout bounds each request, and the hosts' windows are slotted by clk.
+func newUpstreamHTTPClient(timeout time.Duration, rt http.RoundTripper, clk Clock) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &tracedTransport{next: rt, clock: clk},
+	}
+}
+
+// tracedTransport times each round trip's phases and, for translate calls, feeds the host's window.
+type tracedTransport struct {
+	next  http.RoundTripper
+	clock Clock
+}
+
+func (t *tracedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	kind, _ := req.Context().Value(upstreamCallCtxKey).(string)
+	if kind == "" {
+		kind = upstreamCallTranslate
+	}
+	pt := &phaseTimer{host: req.URL.Host, kind: kind, clock: t.clock, start: time.Now()}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), pt.trace()))
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		pt.done()
+		return nil, err
+	}
+	resp.Body = &timedBody{ReadCloser: resp.Body, pt: pt}
+	return resp, nil
+}
+
+// CloseIdleConnections passes http.Client's call on to the transport underneath.
+func (t *tracedTransport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// phaseTimer collects one request's httptrace events. Dialing can run on another goroutine than
+// the caller, hence the lock.
+type phaseTimer struct {
+	host, kind string
+	clock      Clock // what the window slot is picked by; the phases are timed on the time package
+	start      time.Time
+
+	mu                   sync.Mutex
+	dnsStart, connStart  time.Time
+	tlsStart             time.Time
+	dns, connect, tlsDur time.Duration
+	finished             bool
+}
+
+func (p *phaseTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { p.mark(&p.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { p.since(&p.dnsStart, &p.dns) },
+		ConnectStart: func(string, string) {
+			p.mu.Lock()
+			if p.connStart.IsZero() { // the first of several dual-stack attempts
+				p.connStart = time.Now()
+			}
+			p.mu.Unlock()
+		},
+		ConnectDone:          func(_, _ string, err error) { p.since(&p.connStart, &p.connect) },
+		TLSHandshakeStart:    func() { p.mark(&p.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.since(&p.tlsStart, &p.tlsDur) },
+		GotFirstResponseByte: func() { p.observe("ttfb", time.Since(p.start)) },
+	}
+}
+
+func (p *phaseTimer) mark(at *time.Time) {
+	p.mu.Lock()
+	*at = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *phaseTimer) since(at *time.Time, d *time.Duration) {
+	p.mu.Lock()
+	if !at.IsZero() {
+		*d = time.Since(*at)
+	}
+	p.mu.Unlock()
+}
+
+func (p *phaseTimer) observe(phase string, d time.Duration) {
+	metricUpstreamPhase.WithLabelValues(p.host, p.kind, phase).Observe(d.Seconds())
+}
+
+// done records the phases that happened (a reused connection has no DNS, connect or TLS) and
+// the total, once, when the body is closed or the round trip fails.
+func (p *phaseTimer) done() {
+	p.mu.Lock()
+	if p.finished {
+		p.mu.Unlock()
+		return
+	}
+	p.finished = true
+	total := time.Since(p.start)
+	phases := map[string]time.Duration{"dns": p.dns, "connect": p.connect, "tls": p.tlsDur}
+	p.mu.Unlock()
+	for phase, d := range phases {
+		if d > 0 {
+			p.observe(phase, d)
+		}
+	}
+	p.observe("total", total)
+	if p.kind == upstreamCallTranslate {
+		upstreamWindowFor(p.host).call(p.clock.Now(), total)
+	}
+}
+
+// timedBody ends its request's timing when the caller is done reading.
+type timedBody struct {
+	io.ReadCloser
+	pt *phaseTimer
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.pt.done()
+	return err
+}
+
+// transportErrorKind sorts a failed round trip for dhk_go_upstream_errors_total.
+func transportErrorKind(err error) string {
+	var (
+		ne  net.Error
+		dns *net.DNSError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.As(err, &dns):
+		return "dns"
+	}
+	return "transport"
+}
+
+// upstreamWindowSlots is the window /go/stats summarizes, in one-minute slots.
+const upstreamWindowSlots = 5
+
+// upstreamWindow counts one host's translate calls, errors and latency over the last few
+// minutes, one slot per minute, reused as the minutes come round.
+type upstreamWindow struct {
+	mu    sync.Mutex
+	slots [upstreamWindowSlots]windowSlot
+}
+
+type windowSlot struct {
+	minute        int64
+	calls, errors uint64
+	latency       latencyHistogram
+}
+
+// upstreamWindowFor returns host's window, creating it on first use.
+func upstreamWindowFor(host string) *upstreamWindow {
+	if w, ok := stats.upstreams.Load(host); ok {
+		return w.(*upstreamWindow)
+	}
+	w, _ := stats.upstreams.LoadOrStore(host, new(upstreamWindow))
+	return w.(*upstreamWindow)
+}
+
+// slot returns the current minute's slot. The caller holds w.mu.
+func (w *upstreamWindow) slot(now time.Time) *windowSlot { return windowSlotAt(&w.slots, now) }
+
+// windowSlotAt returns now's minute in slots, clearing it if it last held an older minute.
+func windowSlotAt(slots *[upstreamWindowSlots]windowSlot, now time.Time) *windowSlot {
+	m := now.Unix() / 60
+	s := &slots[m%upstreamWindowSlots]
+	if s.minute != m {
+		*s = windowSlot{minute: m}
+	}
+	return s
+}
+
+// call counts a call of d that ended at now.
+func (w *upstreamWindow) call(now time.Time, d time.Duration) {
+	w.mu.Lock()
+	s := w.slot(now)
+	s.calls++
+	s.latency.observe(d)
+	w.mu.Unlock()
+}
+
+// failed counts a call that failed at now.
+func (w *upstreamWindow) failed(now time.Time) {
+	w.mu.Lock()
+	w.slot(now).errors++
+	w.mu.Unlock()
+}
+
+// summary is the window's call count, p50/p95 latency and share of calls that failed, as of now.
+func (w *upstreamWindow) summary(now time.Time) map[string]any {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	oldest := now.Unix()/60 - upstreamWindowSlots + 1
+	var (
+		merged        latencyHistogram
+		calls, errors uint64
+	)
+	for i := range w.slots {
+		s := &w.slots[i]
+		if s.minute < oldest {
+			continue
+		}
+		calls, errors = calls+s.calls, errors+s.errors
+		for b := range s.latency.buckets {
+			merged.buckets[b].Add(s.latency.buckets[b].Load())
+		}
+	}
+	p := merged.quantiles(0.50, 0.95)
+	rate := 0.0
+	if calls > 0 {
+		rate = min(float64(errors)/float64(calls), 1)
+	}
+	return map[string]any{
+		"window_s":   upstreamWindowSlots * 60,
+		"calls":      calls,
+		"errors":     errors,
+		"error_rate": rate,
+		"latency_ms": map[string]float64{"p50": p[0], "p95": p[1]},
+	}
+}
@@ -0,0 +1,569 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keyUsage is one API key's counters. Today's counters reset at UTC midnight, the month's on
+// the first of the month; totals never do.
+type keyUsage struct {
+	tier          atomic.Value // string; the tier last seen, which can change via the Stripe webhook
+	requestsToday atomic.Int64
+	charsToday    atomic.Int64
+	requestsMonth atomic.Int64
+	charsMonth    atomic.Int64
+	requestsTotal atomic.Int64
+	charsTotal    atomic.Int64
+	overageToday  atomic.Int64 // of charsToday, those past the quota
+	sharedToday   atomic.Int64 // every instance's chars today, as Redis last reported them
+}
+
+// usageMeter counts requests and translated characters per API key and enforces the daily
+// character quota of each tier. Counting is atomic in memory; run snapshots the counters to a
+// JSON file so a restart picks up where the last flush left off. With a store, every request
+// and translation is also recorded there for the daily reports. With shared limits the quotas
+// are enforced from Redis, across instances, and the counters here are this instance's share.
+//
+// A quota isn't a hard stop. A tier's grace lets its keys go that many percent past the quota,
+// the characters past it metered as overage, billed from the daily report; only beyond the
+// grace are translations refused. From warnAt percent of the quota on, responses say so (see
+// quotaWarnings), so a customer hears about it before the 429.
+type usageMeter struct {
+	quotas map[string]int64 // chars per UTC day by tier; 0 or missing means unlimited
+	grace  map[string]int64 // percent past the quota still served, by tier
+	warnAt int64            // percent of the quota from which responses carry X-Quota-Warning; 0 never
+	path   string           // snapshot file; empty keeps usage in memory only
+	store  *usageStore      // nil without USAGE_DB_PATH
+	shared *sharedLimits    // nil without CACHE_BACKEND=redis
+	clock  Clock
+
+	mu    sync.RWMutex
+	day   string // UTC date the today counters belong to
+	month string // UTC month the month counters belong to
+	keys  map[string]*keyUsage
+
+	dirty   atomic.Bool
+	flushMu sync.Mutex
+}
+
+// quotaError is returned when a translation would take a key past its daily quota.
+type quotaError struct {
+	Tier  string
+	Limit int64
+	Used  int64
+	Reset time.Time
+	// RetryAfter is the wait until Reset, by the meter's clock.
+	RetryAfter time.Duration
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("daily quota of %d characters exceeded for the %s tier", e.Limit, e.Tier)
+}
+
+func (m *usageMeter) quotaError(tier string, limit, used int64) *quotaError {
+	reset := m.nextReset()
+	return &quotaError{Tier: tier, Limit: limit, Used: used, Reset: reset, RetryAfter: reset.Sub(m.clock.Now())}
+}
+
+func newUsageMeter(path string, quotas map[string]int64, clk Clock) (*usageMeter, error) {
+	m := &usageMeter{quotas: quotas, path: path, clock: clk, keys: make(map[string]*keyUsage)}
+	m.day, m.month = m.today(), m.thisMonth()
+	if path != "" {
+		if err := m.load(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *usageMeter) today() string { return m.clock.Now().UTC().Format(time.DateOnly) }
+
+func (m *usageMeter) thisMonth() string { return m.clock.Now().UTC().Format("2006-01") }
+
+// nextReset is the next UTC midnight.
+func (m *usageMeter) nextReset() time.Time {
+	y, mo, d := m.clock.Now().UTC().Date()
+	return time.Date(y, mo, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// rollover zeroes today's counters once the UTC date has changed, and the month's once the
+// month has.
+func (m *usageMeter) rollover() {
+	today := m.today()
+	m.mu.RLock()
+	fresh := m.day == today
+	m.mu.RUnlock()
+	if fresh {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.day != today {
+		for _, u := range m.keys {
+			u.requestsToday.Store(0)
+			u.charsToday.Store(0)
+			u.overageToday.Store(0)
+			u.sharedToday.Store(0)
+		}
+		if month := m.thisMonth(); m.month != month {
+			for _, u := range m.keys {
+				u.requestsMonth.Store(0)
+				u.charsMonth.Store(0)
+			}
+			m.month = month
+		}
+		m.day = today
+		m.dirty.Store(true)
+	}
+}
+
+// get returns id's counters for today, creating them on first use.
+func (m *usageMeter) get(id identity) *keyUsage {
+	m.rollover()
+	m.mu.RLock()
+	u, ok := m.keys[id.KeyID]
+	m.mu.RUnlock()
+	if ok {
+		return u
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok = m.keys[id.KeyID]; !ok {
+		u = &keyUsage{}
+		u.tier.Store(id.Tier)
+		m.keys[id.KeyID] = u
+	}
+	return u
+}
+
+// request counts one API call for the caller; unauthenticated calls aren't metered.
+func (m *usageMeter) request(ctx context.Context) {
+	id, ok := identityFrom(ctx)
+	if !ok {
+		return
+	}
+	u := m.get(id)
+	u.tier.Store(id.Tier)
+	u.requestsToday.Add(1)
+	u.requestsMonth.Add(1)
+	u.requestsTotal.Add(1)
+	m.dirty.Store(true)
+	if m.store != nil {
+		m.store.add(usageEvent{At: m.clock.Now(), KeyID: id.KeyID, Tier: id.Tier, Requests: 1})
+	}
+}
+
+// translated records one translation for the caller's reports: the characters it was charged,
+// how many of them were overage, and whether it came from the cache or failed. Without a store
+// only the overage metric is counted.
+func (m *usageMeter) translated(ctx context.Context, chars, overage int64, cached bool, err error) {
+	id, ok := identityFrom(ctx)
+	if !ok {
+		return
+	}
+	if err == nil && overage > 0 {
+		metricQuotaOverage.WithLabelValues(id.Tier).Add(float64(overage))
+	}
+	if m.store == nil {
+		return
+	}
+	ev := usageEvent{At: m.clock.Now(), KeyID: id.KeyID, Tier: id.Tier, Translations: 1, Chars: chars, Overage: overage}
+	switch {
+	case err != nil:
+		ev.Errors, ev.Chars, ev.Overage = 1, 0, 0
+	case cached:
+		ev.CacheHits = 1
+	}
+	m.store.add(ev)
+}
+
+// hardLimit is where tier's keys are refused: the quota and its grace. 0 is unlimited.
+func (m *usageMeter) hardLimit(tier string) int64 {
+	limit := m.quotas[tier]
+	return limit + limit*m.grace[tier]/100
+}
+
+// overage is how much of a charge of n, which took the day's use to used, is past limit.
+func overage(used, n, limit int64) int64 {
+	if limit <= 0 || used <= limit {
+		return 0
+	}
+	return min(n, used-limit)
+}
+
+// reserve charges n characters against the caller's quota up front, so concurrent requests can't
+// overshoot it, and returns how many of them are overage. The returned release refunds them if
+// the translation then fails.
+func (m *usageMeter) reserve(ctx context.Context, n int64) (release func(), over int64, err error) {
+	id, ok := identityFrom(ctx)
+	if !ok {
+		return func() {}, 0, nil
+	}
+	u := m.get(id)
+	limit, hard := m.quotas[id.Tier], m.hardLimit(id.Tier)
+	if limit > 0 && m.shared.available("quota") {
+		charged, used, day, err := m.shared.reserve(id.KeyID, n, hard)
+		if err == nil {
+			if !charged {
+				return nil, 0, m.quotaError(id.Tier, limit, used)
+			}
+			over := overage(used, n, limit)
+			u.sharedToday.Store(used)
+			u.charsToday.Add(n)
+			u.charsMonth.Add(n)
+			u.charsTotal.Add(n)
+			u.overageToday.Add(over)
+			m.dirty.Store(true)
+			noteQuota(ctx, quotaStanding{Limit: limit, Hard: hard, Used: used, Reset: m.nextReset()})
+			return func() {
+				u.charsToday.Add(-n)
+				u.charsMonth.Add(-n)
+				u.charsTotal.Add(-n)
+				u.overageToday.Add(-over)
+				m.shared.refund(id.KeyID, day, n)
+			}, over, nil
+		}
+		m.shared.failed("quota", err)
+	}
+	used := u.charsToday.Add(n)
+	if limit > 0 && used > hard {
+		u.charsToday.Add(-n)
+		return nil, 0, m.quotaError(id.Tier, limit, used-n)
+	}
+	over = overage(used, n, limit)
+	u.charsMonth.Add(n)
+	u.charsTotal.Add(n)
+	u.overageToday.Add(over)
+	m.dirty.Store(true)
+	if limit > 0 {
+		noteQuota(ctx, quotaStanding{Limit: limit, Hard: hard, Used: used, Reset: m.nextReset()})
+	}
+	return func() {
+		u.charsToday.Add(-n)
+		u.charsMonth.Add(-n)
+		u.charsTotal.Add(-n)
+		u.overageToday.Add(-over)
+	}, over, nil
+}
+
+// exhausted reports a quotaError when the caller has no characters left today, grace included.
+func (m *usageMeter) exhausted(ctx context.Context) error {
+	id, ok := identityFrom(ctx)
+	if !ok {
+		return nil
+	}
+	limit, hard := m.quotas[id.Tier], m.hardLimit(id.Tier)
+	if limit > 0 && m.shared.available("quota") {
+		_, used, _, err := m.shared.reserve(id.KeyID, 0, hard)
+		if err == nil {
+			m.get(id).sharedToday.Store(used)
+			if used >= hard {
+				return m.quotaError(id.Tier, limit, used)
+			}
+			return nil
+		}
+		m.shared.failed("quota", err)
+	}
+	used := m.get(id).charsToday.Load()
+	if limit > 0 && used >= hard {
+		return m.quotaError(id.Tier, limit, used)
+	}
+	return nil
+}
+
+// quotaStanding is where a key stood against its quota after a charge.
+type quotaStanding struct {
+	Limit, Hard, Used int64
+	Reset             time.Time
+}
+
+// quotaNote is the furthest standing a request's charges reached, for quotaWarnings.
+type quotaNote struct {
+	mu sync.Mutex
+	s  quotaStanding
+}
+
+type quotaNoteKey struct{}
+
+// noteQuota records s on the request's note, if quotaWarnings put one on ctx. Concurrent
+// charges, a batch's items, keep the highest use.
+func noteQuota(ctx context.Context, s quotaStanding) {
+	n, _ := ctx.Value(quotaNoteKey{}).(*quotaNote)
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if s.Used > n.s.Used {
+		n.s = s
+	}
+	n.mu.Unlock()
+}
+
+// quotaWarnings adds X-Quota-* to a response whose request took its key past QUOTA_WARN_PERCENT
+// of the daily quota: X-Quota-Warning is "soft" up to the quota and "overage" past it, with the
+// characters left of the quota, those left of the grace, and when the quota resets (Unix
+// seconds, as X-RateLimit-Reset). They go out with the status, so a stream whose headers are
+// sent before it translates anything, and a WebSocket, don't get them.
+func (m *usageMeter) quotaWarnings(next http.Handler) http.Handler {
+	if m.warnAt <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		n := &quotaNote{}
+		ctx := context.WithValue(r.Context(), quotaNoteKey{}, n)
+		next.ServeHTTP(&quotaWriter{ResponseWriter: w, note: n, warnAt: m.warnAt}, r.WithContext(ctx))
+	})
+}
+
+// quotaWriter sets the quota warning headers when the status is written.
+type quotaWriter struct {
+	http.ResponseWriter
+	note   *quotaNote
+	warnAt int64
+	wrote  bool
+}
+
+func (w *quotaWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.wrote = true
+		w.note.mu.Lock()
+		s := w.note.s
+		w.note.mu.Unlock()
+		if s.Limit > 0 && s.Used*100 >= s.Limit*w.warnAt {
+			h := w.Header()
+			level := "soft"
+			if s.Used > s.Limit {
+				level = "overage"
+			}
+			h.Set("X-Quota-Warning", level)
+			h.Set("X-Quota-Remaining", strconv.FormatInt(max(s.Limit-s.Used, 0), 10))
+			h.Set("X-Quota-Grace-Remaining", strconv.FormatInt(max(s.Hard-max(s.Used, s.Limit), 0), 10))
+			h.Set("X-Quota-Reset", strconv.FormatInt(s.Reset.Unix(), 10))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *quotaWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *quotaWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// usageReport is one key's numbers as served by /go/usage and /go/admin/usage.
+type usageReport struct {
+	KeyID         string `json:"key_id"`
+	Tier          string `json:"tier"`
+	RequestsToday int64  `json:"requests_today"`
+	CharsToday    int64  `json:"chars_today"`
+	RequestsMonth int64  `json:"requests_month"`
+	CharsMonth    int64  `json:"chars_month"`
+	RequestsTotal int64  `json:"requests_total"`
+	CharsTotal    int64  `json:"chars_total"`
+	OverageToday  int64  `json:"overage_chars_today,omitempty"` // of chars_today, those past the quota
+	QuotaChars    int64  `json:"quota_chars,omitempty"`
+	GraceChars    int64  `json:"quota_grace_chars,omitempty"` // served past quota_chars before translations are refused
+}
+
+func (m *usageMeter) report(keyID string, u *keyUsage) usageReport {
+	tier, _ := u.tier.Load().(string)
+	return usageReport{
+		KeyID:         keyID,
+		Tier:          tier,
+		RequestsToday: u.requestsToday.Load(),
+		CharsToday:    u.charsToday.Load(),
+		RequestsMonth: u.requestsMonth.Load(),
+		CharsMonth:    u.charsMonth.Load(),
+		RequestsTotal: u.requestsTotal.Load(),
+		CharsTotal:    u.charsTotal.Load(),
+		OverageToday:  u.overageToday.Load(),
+		QuotaChars:    m.quotas[tier],
+		GraceChars:    m.hardLimit(tier) - m.quotas[tier],
+	}
+}
+
+// usageSnapshot is the on-disk form.
+type usageSnapshot struct {
+	Day     string        `json:"day"`
+	Month   string        `json:"month"`
+	SavedAt time.Time     `json:"saved_at"`
+	Keys    []usageReport `json:"keys"`
+}
+
+func (m *usageMeter) snapshot() usageSnapshot {
+	m.rollover()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s := usageSnapshot{Day: m.day, Month: m.month, SavedAt: m.clock.Now().UTC(), Keys: make([]usageReport, 0, len(m.keys))}
+	for id, u := range m.keys {
+		s.Keys = append(s.Keys, m.report(id, u))
+	}
+	sort.Slice(s.Keys, func(i, j int) bool { return s.Keys[i].KeyID < s.Keys[j].KeyID })
+	return s
+}
+
+func (m *usageMeter) load() error {
+	b, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("usage file: %w", err)
+	}
+	var s usageSnapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("usage file %s: %w", m.path, err)
+	}
+	for _, r := range s.Keys {
+		u := &keyUsage{}
+		u.tier.Store(r.Tier)
+		if s.Day == m.day {
+			u.requestsToday.Store(r.RequestsToday)
+			u.charsToday.Store(r.CharsToday)
+			u.overageToday.Store(r.OverageToday)
+		}
+		if s.Month == m.month {
+			u.requestsMonth.Store(r.RequestsMonth)
+			u.charsMonth.Store(r.CharsMonth)
+		}
+		u.requestsTotal.Store(r.RequestsTotal)
+		u.charsTotal.Store(r.CharsTotal)
+		m.keys[r.KeyID] = u
+	}
+	slog.Info("usage counters restored", "path", m.path, "keys", len(s.Keys), "day", s.Day)
+	return nil
+}
+
+// flush writes the snapshot file if anything changed since the last flush. The file is replaced
+// by rename, so a crash mid-write leaves the previous snapshot intact.
+func (m *usageMeter) flush() error {
+	if m.path == "" || !m.dirty.Swap(false) {
+		return nil
+	}
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+	b, err := json.Marshal(m.snapshot())
+	if err == nil {
+		tmp := m.path + ".tmp"
+		if err = os.WriteFile(tmp, b, 0o600); err == nil {
+			err = os.Rename(tmp, m.path)
+		}
+	}
+	if err != nil {
+		m.dirty.Store(true)
+		return fmt.Errorf("usage flush %s: %w", filepath.Base(m.path), err)
+	}
+	return nil
+}
+
+// run flushes every interval until ctx is done. Shutdown flushes once more after the HTTP server
+// has drained, so requests finishing during shutdown are kept.
+func (m *usageMeter) run(ctx context.Context, interval time.Duration) {
+	t := m.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			if err := m.flush(); err != nil {
+				slog.Error("usage flush failed", "err", err)
+			}
+		}
+	}
+}
+
+// keyLimits is what a key's tier allows, as GET /go/usage reports it. 0 is unlimited.
+type keyLimits struct {
+	RatePerMinute int   `json:"rate_limit_per_minute"`
+	RateBurst     int   `json:"rate_limit_burst"`
+	Concurrency   int   `json:"concurrency"` // translate requests in flight at once
+	QuotaChars    int64 `json:"quota_chars"`
+	GraceChars    int64 `json:"quota_grace_chars"`
+}
+
+// quotaLeft is what a key has left today. With shared limits the day's use is every
+// instance's, as Redis last reported it to this one.
+type quotaLeft struct {
+	Chars      int64 `json:"chars"`       // of the quota
+	GraceChars int64 `json:"grace_chars"` // past the quota, before translations are refused
+}
+
+// usageGlossary is the size of a key's glossary.
+type usageGlossary struct {
+	Terms     int       `json:"terms"`
+	MaxTerms  int       `json:"max_terms"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// remaining is what the key behind u has left of tier's quota today; nil when it is unlimited.
+func (m *usageMeter) remaining(tier string, u *keyUsage) *quotaLeft {
+	limit, hard := m.quotas[tier], m.hardLimit(tier)
+	if limit <= 0 {
+		return nil
+	}
+	used := max(u.charsToday.Load(), u.sharedToday.Load())
+	return &quotaLeft{Chars: max(limit-used, 0), GraceChars: max(hard-max(used, limit), 0)}
+}
+
+// usageHandler serves GET /go/usage: the calling key's own numbers and limits. Everything comes
+// from memory, so clients can poll it; with shared limits the counters are this instance's.
+func usageHandler(m *usageMeter, limiters tierLimiters, kc *keyConcurrency, glossaries *clientGlossaries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := identityFrom(r.Context())
+		if !ok {
+			writeError(w, http.StatusNotFound, codeNotFound, "usage is tracked per API key; none configured")
+			return
+		}
+		u := m.get(id)
+		limiter, _ := limiters.pick(r.Context())
+		lim := keyLimits{Concurrency: kc.limit(id.Tier), QuotaChars: m.quotas[id.Tier], GraceChars: m.hardLimit(id.Tier) - m.quotas[id.Tier]}
+		lim.RatePerMinute, lim.RateBurst = limiter.limits()
+		var gl *usageGlossary
+		if cg := glossaries.forCaller(r.Context()); cg != nil {
+			gl = &usageGlossary{Terms: len(cg.terms), MaxTerms: glossaries.maxTerms, UpdatedAt: cg.updated}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		j(w, http.StatusOK, map[string]any{
+			"usage":     m.report(id.KeyID, u),
+			"limits":    lim,
+			"remaining": m.remaining(id.Tier, u), // null when the tier has no quota
+			"reset_at":  m.nextReset().Format(time.RFC3339),
+			"scopes":    id.Scopes, // null when the key is unrestricted
+			"glossary":  gl,        // null without one
+		})
+	}
+}
+
+// adminUsageHandler serves GET /go/admin/usage: every key's numbers, and the translate requests
+// each key has in flight now.
+func adminUsageHandler(m *usageMeter, kc *keyConcurrency) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		s := m.snapshot()
+		j(w, http.StatusOK, map[string]any{
+			"day":       s.Day,
+			"reset_at":  m.nextReset().Format(time.RFC3339),
+			"keys":      s.Keys,
+			"in_flight": kc.snapshot(),
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuotaResetsAtUTCMidnight(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 31, 23, 59, 0, 0, time.UTC))
+	m, err := newUsageMeter("", map[string]int64{tierFree: 10}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withIdentity(context.Background(), identity{KeyID: "meter", Tier: tierFree})
+	steps := []struct {
+		name    string
+		advance time.Duration
+		chars   int64
+		retry   time.Duration // 0 is charged
+	}{
+		{"the whole quota", 0, 10, 0},
+		{"past it", 0, 1, time.Minute},
+		{"still today", 30 * time.Second, 1, 30 * time.Second},
+		{"after midnight", 30 * time.Second, 10, 0},
+		{"past the new day's", time.Hour, 1, 23 * time.Hour},
+	}
+	for _, st := range steps {
+		clk.Advance(st.advance)
+		_, _, err := m.reserve(ctx, st.chars)
+		var qe *quotaError
+		switch {
+		case st.retry == 0 && err != nil:
+			t.Fatalf("%s: %v, want charged", st.name, err)
+		case st.retry > 0 && (!errors.As(err, &qe) || qe.RetryAfter != st.retry):
+			t.Fatalf("%s: %v, want a quota error with retry after %v", st.name, err, st.retry)
+		}
+	}
+	// The month rolled over with the day.
+	if got := m.get(identity{KeyID: "meter", Tier: tierFree}).charsMonth.Load(); got != 10 {
+		t.Fatalf("%d chars this month, want 10", got)
+	}
+}
+
+// TestQuotaGrace walks a free and a pro key through the warning threshold, the quota and the
+// grace past it, checking the headers and enforcement at each step, then the overage in the
+// daily report and the fresh allowance after midnight.
+func TestQuotaGrace(t *testing.T) {
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := newTestServer(t, map[string]string{
+		"QUOTA_CHARS_FREE":     "20",
+		"QUOTA_GRACE_FREE":     "0",
+		"QUOTA_CHARS_PRO":      "100",
+		"QUOTA_GRACE_PRO":      "10",
+		"QUOTA_WARN_PERCENT":   "80",
+		"RATE_LIMIT_BURST":     "100",
+		"RATE_LIMIT_PRO_BURST": "100",
+		"USAGE_DB_PATH":        filepath.Join(t.TempDir(), "usage.db"),
+	}, Deps{Clock: clk}).Handler()
+	midnight := strconv.FormatInt(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC).Unix(), 10)
+	steps := []struct {
+		name    string
+		key     string
+		chars   int
+		advance time.Duration
+		status  int
+		warning string // X-Quota-Warning; "" is none
+		left    string // X-Quota-Remaining
+		grace   string // X-Quota-Grace-Remaining
+	}{
+		{"pro under the threshold", testProKey, 50, 0, http.StatusOK, "", "", ""},
+		{"pro at the threshold", testProKey, 30, 0, http.StatusOK, "soft", "20", "10"},
+		{"pro near the quota", testProKey, 15, 0, http.StatusOK, "soft", "5", "10"},
+		{"pro into the grace", testProKey, 10, 0, http.StatusOK, "overage", "0", "5"},
+		{"pro past the grace", testProKey, 10, 0, http.StatusTooManyRequests, "", "", ""},
+		{"pro to the end of the grace", testProKey, 5, 0, http.StatusOK, "overage", "0", "0"},
+		{"pro spent", testProKey, 1, 0, http.StatusTooManyRequests, "", "", ""},
+		{"free at its quota", testFreeKey, 20, 0, http.StatusOK, "soft", "0", "0"},
+		{"free has no grace", testFreeKey, 1, 0, http.StatusTooManyRequests, "", "", ""},
+		{"pro after midnight", testProKey, 10, 12 * time.Hour, http.StatusOK, "", "", ""},
+	}
+	for i, st := range steps {
+		clk.Advance(st.advance)
+		q := strings.Repeat(string(rune('a'+i)), st.chars)
+		w := serve(h, "GET", "/go/translate?q="+q, "", "X-API-Key", st.key)
+		if w.Code != st.status {
+			t.Fatalf("%s: status %d, want %d: %s", st.name, w.Code, st.status, w.Body.String())
+		}
+		if st.status != http.StatusOK {
+			checkEnvelope(t, w)
+			if !strings.Contains(w.Body.String(), string(codeQuotaExceeded)) {
+				t.Fatalf("%s: %s, want %s", st.name, w.Body.String(), codeQuotaExceeded)
+			}
+			continue
+		}
+		hd := w.Header()
+		if hd.Get("X-Quota-Warning") != st.warning || hd.Get("X-Quota-Remaining") != st.left || hd.Get("X-Quota-Grace-Remaining") != st.grace {
+			t.Fatalf("%s: warning %q remaining %q grace %q, want %q %q %q", st.name,
+				hd.Get("X-Quota-Warning"), hd.Get("X-Quota-Remaining"), hd.Get("X-Quota-Grace-Remaining"), st.warning, st.left, st.grace)
+		}
+		if reset := hd.Get("X-Quota-Reset"); st.warning != "" && reset != midnight {
+			t.Fatalf("%s: X-Quota-Reset %q, want %s", st.name, reset, midnight)
+		}
+	}
+
+	// The day's overage is billed from the report, which writes the queued events first.
+	want := map[string]usageDay{
+		"acme":    {Tier: tierPro, Characters: 110, Overage: 10},
+		"freebie": {Tier: tierFree, Characters: 20},
+	}
+	w := serve(h, "GET", "/go/admin/reports?from=2026-03-01&to=2026-03-01&format=json", "", "Authorization", "Bearer "+testAdmin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("report: status %d: %s", w.Code, w.Body.String())
+	}
+	var report struct {
+		Days []usageDay `json:"days"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]usageDay{}
+	for _, d := range report.Days {
+		got[d.KeyID] = usageDay{Tier: d.Tier, Characters: d.Characters, Overage: d.Overage}
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("report %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// usageStore keeps per-key usage in SQLite for reporting: a row per metered event, rolled up
+// into one row per key and UTC day. The usage meter's counters stay the source of truth for
+// quotas; this is the history finance reads. Events are queued and written by a single
+// background writer, as in sqliteCache, so requests never wait on disk.
+type usageStore struct {
+	db        *sql.DB
+	retention time.Duration // raw events older than this are pruned once rolled up
+	clock     Clock
+	events    chan usageEvent
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	rollupMu  sync.Mutex // one rollup at a time, from the janitor or a report
+}
+
+// usageEvent is one metered event: an API call (Requests), or a translation it made, with the
+// characters charged for it, how many of those were past the key's quota, and whether it was
+// answered from the cache or failed.
+type usageEvent struct {
+	At           time.Time
+	KeyID, Tier  string
+	Requests     int64
+	Translations int64
+	Chars        int64
+	Overage      int64
+	CacheHits    int64
+	Errors       int64
+
+	flushed chan struct{} // set on flush's marker instead of the counts; closed once written
+}
+
+// usageStoreOpts configures retention and how often the janitor rolls up.
+type usageStoreOpts struct {
+	Retention      time.Duration
+	RollupInterval time.Duration
+	Clock          Clock
+}
+
+// openUsageStore opens or creates the usage DB at path and starts the writer and janitor.
+func openUsageStore(path string, opts usageStoreOpts) (*usageStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate(db, "usage", opts.Clock); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &usageStore{
+		db:        db,
+		retention: opts.Retention,
+		clock:     opts.Clock,
+		events:    make(chan usageEvent, 4096),
+		stop:      make(chan struct{}),
+	}
+	s.wg.Add(2)
+	go s.writer()
+	go s.janitor(opts.RollupInterval)
+	return s, nil
+}
+
+// add queues ev; when the queue is full the event is dropped rather than blocking the request.
+func (s *usageStore) add(ev usageEvent) {
+	select {
+	case s.events <- ev:
+	default:
+		slog.Warn("usage db write queue full, dropping event", "key_id", ev.KeyID)
+	}
+}
+
+// flush returns once the events queued before it are written, or ctx is done.
+func (s *usageStore) flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case s.events <- usageEvent{flushed: done}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writer inserts queued events, as many as are waiting in one transaction, then releases the
+// flushes queued among them.
+func (s *usageStore) writer() {
+	defer s.wg.Done()
+	for ev := range s.events {
+		batch := []usageEvent{ev}
+	drain:
+		for len(batch) < 512 {
+			select {
+			case ev, ok := <-s.events:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, ev)
+			default:
+				break drain
+			}
+		}
+		if err := s.insert(batch); err != nil {
+			slog.Warn("usage db write failed", "events", len(batch), "err", err)
+		}
+		for _, ev := range batch {
+			if ev.flushed != nil {
+				close(ev.flushed)
+			}
+		}
+	}
+}
+
+func (s *usageStore) insert(batch []usageEvent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO usage_events (day, at, key_id, tier, requests, translations, chars, cache_hits, errors, overage)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, ev := range batch {
+		if ev.flushed != nil {
+			continue
+		}
+		at := ev.At.UTC()
+		if _, err := stmt.Exec(at.Format(time.DateOnly), at.UnixMilli(), ev.KeyID, ev.Tier,
+			ev.Requests, ev.Translations, ev.Chars, ev.CacheHits, ev.Errors, ev.Overage); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// rollup folds the events written since the last rollup into usage_daily, returning how many
+// day rows it wrote. A key's tier for the day is the one on its latest event.
+func (s *usageStore) rollup(ctx context.Context) (int64, error) {
+	s.rollupMu.Lock()
+	defer s.rollupMu.Unlock()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var lo, hi int64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM usage_events`).Scan(&hi); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE((SELECT last_event FROM usage_rollup WHERE id = 1), 0)`).Scan(&lo); err != nil {
+		return 0, err
+	}
+	if hi <= lo {
+		return 0, nil
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO usage_daily (day, key_id, tier, requests, translations, chars, cache_hits, errors, overage, rolled_at)
+		SELECT e.day, e.key_id,
+			(SELECT t.tier FROM usage_events t WHERE t.day = e.day AND t.key_id = e.key_id AND t.id <= ?1 ORDER BY t.id DESC LIMIT 1),
+			SUM(e.requests), SUM(e.translations), SUM(e.chars), SUM(e.cache_hits), SUM(e.errors), SUM(e.overage), ?3
+		FROM usage_events e
+		WHERE e.id <= ?1 AND e.day IN (SELECT DISTINCT day FROM usage_events WHERE id > ?2 AND id <= ?1)
+		GROUP BY e.day, e.key_id
+		ON CONFLICT(day, key_id) DO UPDATE SET tier = excluded.tier, requests = excluded.requests,
+			translations = excluded.translations, chars = excluded.chars, cache_hits = excluded.cache_hits,
+			errors = excluded.errors, overage = excluded.overage, rolled_at = excluded.rolled_at`,
+		hi, lo, s.clock.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO usage_rollup (id, last_event) VALUES (1, ?)
+		ON CONFLICT(id) DO UPDATE SET last_event = excluded.last_event`, hi); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// prune drops the raw events of days older than the retention window, keeping their daily rows.
+// A day with any event not yet rolled up is kept whole, so a later rollup never recomputes it
+// from part of its events.
+func (s *usageStore) prune(ctx context.Context) (int64, error) {
+	cutoff := s.clock.Now().UTC().Add(-s.retention).Format(time.DateOnly)
+	res, err := s.db.ExecContext(ctx, `DELETE FROM usage_events WHERE day < ?
+		AND day NOT IN (SELECT day FROM usage_events WHERE id > COALESCE((SELECT last_event FROM usage_rollup WHERE id = 1), 0))`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// janitor rolls up and then prunes every interval.
+func (s *usageStore) janitor(every time.Duration) {
+	defer s.wg.Done()
+	t := s.clock.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C():
+			s.maintain(context.Background())
+		}
+	}
+}
+
+func (s *usageStore) maintain(ctx context.Context) {
+	if _, err := s.rollup(ctx); err != nil {
+		slog.Warn("usage rollup failed", "err", err)
+		return
+	}
+	n, err := s.prune(ctx)
+	if err != nil {
+		slog.Warn("usage db prune failed", "err", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("usage db pruned raw events", "rows", n, "retention", s.retention.String())
+	}
+}
+
+// usageDay is one key's usage on one UTC day, as /go/admin/reports serves it.
+type usageDay struct {
+	Day           string  `json:"day"`
+	KeyID         string  `json:"key_id"`
+	Tier          string  `json:"tier"`
+	Requests      int64   `json:"requests"`
+	Translations  int64   `json:"translations"`
+	Characters    int64   `json:"characters"`
+	Overage       int64   `json:"overage_characters"` // of characters, those past the key's quota, within its grace
+	CacheHits     int64   `json:"cache_hits"`
+	CacheHitRatio float64 `json:"cache_hit_ratio"` // cache hits per translation
+	Errors        int64   `json:"errors"`
+}
+
+// days calls fn for every daily row from from to to inclusive, by day then key, a page at a
+// time so the one connection isn't held while the client reads.
+func (s *usageStore) days(ctx context.Context, from, to string, fn func(usageDay) error) error {
+	afterDay, afterKey := "", ""
+	for {
+		rows, err := s.db.QueryContext(ctx,
+			`SELECT day, key_id, tier, requests, translations, chars, overage, cache_hits, errors FROM usage_daily
+				WHERE day >= ? AND day <= ? AND (day, key_id) > (?, ?) ORDER BY day, key_id LIMIT ?`,
+			from, to, afterDay, afterKey, exportPage)
+		if err != nil {
+			return err
+		}
+		var page []usageDay
+		for rows.Next() {
+			var d usageDay
+			if err := rows.Scan(&d.Day, &d.KeyID, &d.Tier, &d.Requests, &d.Translations, &d.Characters, &d.Overage, &d.CacheHits, &d.Errors); err != nil {
+				rows.Close()
+				return err
+			}
+			if d.Translations > 0 {
+				d.CacheHitRatio = math.Round(float64(d.CacheHits)/float64(d.Translations)*1000) / 1000
+			}
+			page = append(page, d)
+			afterDay, afterKey = d.Day, d.KeyID
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, d := range page {
+			if err := fn(d); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPage {
+			return nil
+		}
+	}
+}
+
+func (s *usageStore) Ping(ctx context.Context) error { return s.db.PingContext(ctx) }
+
+// Close writes the queued events, rolls them up, and closes the DB.
+func (s *usageStore) Close() error {
+	close(s.stop)
+	close(s.events)
+	s.wg.Wait()
+	if _, err := s.rollup(context.Background()); err != nil {
+		slog.Warn("usage rollup failed", "err", err)
+	}
+	return s.db.Close()
+}
+
+// reportMaxDays bounds the range of one report.
+const reportMaxDays = 366
+
+// usageReportHandler serves GET /go/admin/reports?from=&to=&format=csv|json: each key's daily
+// usage over the UTC days from to to, inclusive. Days a key made no calls have no row. The
+// events queued so far are written and rolled up first, so today's rows are current. The report is an
+// artifact (artifacts.go), downloaded later when it is large.
+func usageReportHandler(s *usageStore, arts *artifactStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, ferr := time.Parse(time.DateOnly, q.Get("from"))
+		to, terr := time.Parse(time.DateOnly, q.Get("to"))
+		switch {
+		case ferr != nil || terr != nil:
+			writeError(w, http.StatusBadRequest, codeBadRequest, "from and to must be dates (YYYY-MM-DD)")
+			return
+		case to.Before(from):
+			writeError(w, http.StatusBadRequest, codeBadRequest, "to is before from")
+			return
+		case to.Sub(from) >= reportMaxDays*24*time.Hour:
+			writeError(w, http.StatusBadRequest, codeBadRequest, "a report covers at most "+strconv.Itoa(reportMaxDays)+" days")
+			return
+		}
+		format := q.Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "json" {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "format must be csv or json")
+			return
+		}
+		auditParam(r.Context(), "from", q.Get("from"))
+		auditParam(r.Context(), "to", q.Get("to"))
+
+		ctx, cancel := context.WithTimeout(r.Context(), exportMaxDuration)
+		defer cancel()
+		if err := s.flush(ctx); err != nil {
+			slog.Warn("usage db flush failed", "request_id", middleware.GetReqID(ctx), "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "usage report failed")
+			return
+		}
+		if _, err := s.rollup(ctx); err != nil {
+			slog.Warn("usage rollup failed", "request_id", middleware.GetReqID(ctx), "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "usage report failed")
+			return
+		}
+
+		fromDay, toDay := from.Format(time.DateOnly), to.Format(time.DateOnly)
+		spec := artifactSpec{
+			kind:        "usage",
+			owner:       artifactAdmin,
+			name:        "dhkalign-usage-" + fromDay + "-" + toDay + "." + format,
+			contentType: "application/json",
+		}
+		if format == "csv" {
+			spec.contentType = "text/csv; charset=utf-8"
+		}
+		spec.write = func(ctx context.Context, w io.Writer) (int, error) {
+			bw := bufio.NewWriter(w)
+			var write func(usageDay) error
+			var flush func() error
+			n := 0
+			if format == "csv" {
+				cw := csv.NewWriter(bw)
+				_ = cw.Write([]string{"day", "key_id", "tier", "requests", "translations", "characters", "cache_hits", "cache_hit_ratio", "errors", "overage_characters"})
+				write = func(d usageDay) error {
+					return cw.Write([]string{d.Day, d.KeyID, d.Tier, strconv.FormatInt(d.Requests, 10), strconv.FormatInt(d.Translations, 10),
+						strconv.FormatInt(d.Characters, 10), strconv.FormatInt(d.CacheHits, 10), strconv.FormatFloat(d.CacheHitRatio, 'f', -1, 64),
+						strconv.FormatInt(d.Errors, 10), strconv.FormatInt(d.Overage, 10)})
+				}
+				flush = func() error {
+					cw.Flush()
+					if err := cw.Error(); err != nil {
+						return err
+					}
+					return bw.Flush()
+				}
+			} else {
+				// {"from":…,"to":…,"days":[…]}, written as the rows are read.
+				head, _ := json.Marshal(map[string]string{"from": fromDay, "to": toDay})
+				bw.Write(head[:len(head)-1])
+				bw.WriteString(`,"days":[`)
+				write = func(d usageDay) error {
+					b, err := json.Marshal(d)
+					if err != nil {
+						return err
+					}
+					if n > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString("\n")
+					_, err = bw.Write(b)
+					return err
+				}
+				flush = bw.Flush
+			}
+			err := s.days(ctx, fromDay, toDay, func(d usageDay) error {
+				err := write(d)
+				n++
+				return err
+			})
+			if err == nil {
+				if format == "json" {
+					bw.WriteString("\n]}\n")
+				}
+				err = flush()
+			}
+			return n, err
+		}
+		arts.serve(w, r, spec)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// versionInfo is the /go/version payload.
+type versionInfo struct {
+	SHA       string     `json:"sha"`
+	BuildTime string     `json:"build_time"`
+	VCSDirty  bool       `json:"vcs_dirty"`
+	GoVersion string     `json:"go_version"`
+	Module    string     `json:"module"`
+	Packs     []packInfo `json:"packs,omitempty"`
+	PackCount *packCount `json:"pack_counts,omitempty"`
+
+	// What this instance is running with, set by versionHandler.
+	TranslateMode     string          `json:"translate_mode,omitempty"`
+	Features          []string        `json:"features,omitempty"`
+	Flags             map[string]bool `json:"flags,omitempty"` // runtime feature flags, as of this request
+	Cache             *versionCache   `json:"cache,omitempty"`
+	Upstreams         []string        `json:"upstreams,omitempty"` // hosts only
+	ConfigFingerprint string          `json:"config_fingerprint,omitempty"`
+}
+
+// packCount is the files and entries loaded from each origin. Entries are per file, so a phrase
+// a PACK_DIR file overrides counts in both.
+type packCount struct {
+	EmbeddedFiles   int `json:"embedded_files"`
+	EmbeddedEntries int `json:"embedded_entries"`
+	ExternalFiles   int `json:"external_files"`
+	ExternalEntries int `json:"external_entries"`
+}
+
+func countPacks(files []packInfo) *packCount {
+	c := &packCount{}
+	for _, f := range files {
+		if f.Origin == packsEmbedded {
+			c.EmbeddedFiles++
+			c.EmbeddedEntries += f.Entries
+		} else {
+			c.ExternalFiles++
+			c.ExternalEntries += f.Entries
+		}
+	}
+	return c
+}
+
+// versionCache is the cache part of /go/version.
+type versionCache struct {
+	Backend string `json:"backend"`
+	Entries int    `json:"entries"`
+	Error   string `json:"error,omitempty"`
+}
+
+// resolveVersion picks each field from the configured COMMIT_SHA/BUILD_TIME first, then the VCS
+// stamps embedded by the Go toolchain, then "dev".
+func resolveVersion(sha, buildTime string, info *debug.BuildInfo, ok bool) versionInfo {
+	v := versionInfo{GoVersion: runtime.Version()}
+	var rev, ts string
+	if ok {
+		v.GoVersion = info.GoVersion
+		v.Module = info.Main.Path
+		if info.Main.Version != "" {
+			v.Module += "@" + info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				rev = s.Value
+			case "vcs.time":
+				ts = s.Value
+			case "vcs.modified":
+				v.VCSDirty = s.Value == "true"
+			}
+		}
+	}
+	v.SHA = firstNonEmpty(sha, rev, "dev")
+	v.BuildTime = firstNonEmpty(buildTime, ts)
+	return v
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// buildVersion resolves this binary's version against the running toolchain's build info.
+func buildVersion(cfg Config) versionInfo {
+	info, ok := debug.ReadBuildInfo()
+	return resolveVersion(cfg.CommitSHA, cfg.BuildTime, info, ok)
+}
+
+// versionHandler serves build metadata, resolved once, and the live configuration's features,
+// upstream hosts and fingerprint, which a reload can change, with the currently loaded packs
+// (packs may be nil), the cache's size and the flags.
+func versionHandler(live *liveConfig, packs *packSet, ct *cachedTranslator, flags *featureFlags) http.HandlerFunc {
+	v := buildVersion(*live.current())
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.current()
+		out := v
+		out.TranslateMode = cfg.TranslateMode
+		out.Features = cfg.features()
+		out.ConfigFingerprint = cfg.fingerprint()
+		if cfg.TranslateMode == modeProxy {
+			for _, u := range cfg.UpstreamURLs {
+				out.Upstreams = append(out.Upstreams, u.Host)
+			}
+		}
+		out.Flags = flags.state()
+		if packs != nil {
+			out.Packs = packs.current().files
+			out.PackCount = countPacks(out.Packs)
+		}
+		st, err := ct.cache.Stats(r.Context())
+		out.Cache = &versionCache{Backend: st.Backend, Entries: st.Entries}
+		if err != nil {
+			out.Cache = &versionCache{Backend: cfg.CacheBackend, Error: err.Error()}
+		}
+		j(w, http.StatusOK, out)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+// TestResolveVersion checks each field's precedence: COMMIT_SHA and BUILD_TIME, then the VCS
+// stamps in the build info, then "dev".
+func TestResolveVersion(t *testing.T) {
+	stamped := &debug.BuildInfo{
+		GoVersion: "go1.22.5",
+		Main:      debug.Module{Path: "github.com/sartu01/dhkalign/backend-go", Version: "v1.4.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "abc123"},
+			{Key: "vcs.time", Value: "2026-03-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	devel := &debug.BuildInfo{GoVersion: "go1.22.5", Main: debug.Module{Path: "github.com/sartu01/dhkalign/backend-go"}}
+	tests := []struct {
+		name           string
+		sha, buildTime string
+		info           *debug.BuildInfo
+		ok             bool
+		want           versionInfo
+	}{
+		{"env over build info", "deadbeef", "2026-04-01T00:00:00Z", stamped, true, versionInfo{
+			SHA: "deadbeef", BuildTime: "2026-04-01T00:00:00Z", VCSDirty: true, GoVersion: "go1.22.5", Module: "github.com/sartu01/dhkalign/backend-go@v1.4.0",
+		}},
+		{"build info", "", "", stamped, true, versionInfo{
+			SHA: "abc123", BuildTime: "2026-03-01T12:00:00Z", VCSDirty: true, GoVersion: "go1.22.5", Module: "github.com/sartu01/dhkalign/backend-go@v1.4.0",
+		}},
+		{"env sha only", "deadbeef", "", stamped, true, versionInfo{
+			SHA: "deadbeef", BuildTime: "2026-03-01T12:00:00Z", VCSDirty: true, GoVersion: "go1.22.5", Module: "github.com/sartu01/dhkalign/backend-go@v1.4.0",
+		}},
+		{"no vcs stamps", "", "", devel, true, versionInfo{
+			SHA: "dev", GoVersion: "go1.22.5", Module: "github.com/sartu01/dhkalign/backend-go",
+		}},
+		{"no build info", "", "", nil, false, versionInfo{SHA: "dev", GoVersion: runtime.Version()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveVersion(tt.sha, tt.buildTime, tt.info, tt.ok)
+			if got.SHA != tt.want.SHA || got.BuildTime != tt.want.BuildTime || got.VCSDirty != tt.want.VCSDirty || got.GoVersion != tt.want.GoVersion || got.Module != tt.want.Module {
+				t.Fatalf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestVersionRoute checks /go/version reports COMMIT_SHA and BUILD_TIME with the build info's
+// go_version, vcs_dirty and module fields.
+func TestVersionRoute(t *testing.T) {
+	h := newTestServer(t, map[string]string{"COMMIT_SHA": "deadbeef", "BUILD_TIME": "2026-04-01T00:00:00Z"}, Deps{}).Handler()
+	w := serve(h, "GET", "/go/version", "")
+	var v map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if v["sha"] != "deadbeef" || v["build_time"] != "2026-04-01T00:00:00Z" || v["go_version"] != runtime.Version() {
+		t.Fatalf("sha %v, build_time %v, go_version %v", v["sha"], v["build_time"], v["go_version"])
+	}
+	for _, field := range []string{"vcs_dirty", "module"} {
+		if _, ok := v[field]; !ok {
+			t.Fatalf("no %s in %s", field, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Cache pre-warming. POST /go/admin/cache/warm takes a list of phrases and runs each through the
+// same pipeline as /go/translate (normalization, detection, packs, the cache), so the keys it
+// fills are the ones clients will look up. Being an admin route it is outside the client rate
+// limits, but not the upstream breaker: with the circuit open, misses fail fast while cached
+// phrases still report as such. A phrase already cached costs nothing, so a list can be sent
+// again safely.
+
+// warmOpts bounds one warm request.
+type warmOpts struct {
+	MaxItems     int
+	Workers      int
+	Timeout      time.Duration // the whole list's budget
+	MaxBodyBytes int64
+	Floor        time.Duration // DEADLINE_FLOOR, for an X-Deadline-Ms on the request
+}
+
+// warmItem is one phrase to warm. Extended warms the key pro callers use.
+type warmItem struct {
+	Q        string `json:"q"`
+	Src      string `json:"src"`
+	Dst      string `json:"dst"`
+	Extended bool   `json:"extended"`
+}
+
+const (
+	warmAlreadyCached = "already_cached"
+	warmWarmed        = "warmed"
+	warmFailed        = "failed"
+)
+
+// warmResult is one phrase's outcome. Index is its position in the request, since results come
+// in completion order; src and dst are the resolved direction.
+type warmResult struct {
+	Index  int    `json:"index"`
+	Q      string `json:"q"`
+	Src    string `json:"src,omitempty"`
+	Dst    string `json:"dst,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type warmCounts struct {
+	AlreadyCached int `json:"already_cached"`
+	Warmed        int `json:"warmed"`
+	Failed        int `json:"failed"`
+}
+
+func (c *warmCounts) add(status string) {
+	switch status {
+	case warmAlreadyCached:
+		c.AlreadyCached++
+	case warmWarmed:
+		c.Warmed++
+	default:
+		c.Failed++
+	}
+}
+
+// warmDone is the last NDJSON line: the totals, and "timeout" when the budget ran out.
+type warmDone struct {
+	Done bool `json:"done"`
+	warmCounts
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// cacheWarmHandler serves POST /go/admin/cache/warm. The body is a JSON array of
+// {"q", "src", "dst"[, "extended"]}. With Accept: application/x-ndjson each result is a line sent
+// as soon as it's done, then a warmDone line; otherwise the results come back together.
+func cacheWarmHandler(svc *translateService, opts warmOpts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var items []warmItem
+		if herr := decodeJSONBody(r, &items); herr != nil {
+			herr.write(w)
+			return
+		}
+		if len(items) == 0 {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "no phrases given")
+			return
+		}
+		if len(items) > opts.MaxItems {
+			writeError(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("too many phrases (max %d)", opts.MaxItems))
+			return
+		}
+		mt, ok := negotiate(r.Header.Get("Accept"), mediaJSON, "application/x-ndjson")
+		if !ok {
+			notAcceptable(w, mediaJSON, "application/x-ndjson")
+			return
+		}
+		auditParam(r.Context(), "phrases", len(items))
+
+		start := time.Now()
+		var counts warmCounts
+		results := warmPhrases(r.Context(), svc, items, opts.Workers)
+		if mt == "application/x-ndjson" {
+			rc := http.NewResponseController(w)
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			_ = rc.Flush()
+			enc := json.NewEncoder(w)
+			var writeErr error
+			for res := range results {
+				counts.add(res.Status)
+				if writeErr == nil {
+					if writeErr = enc.Encode(res); writeErr == nil {
+						writeErr = rc.Flush()
+					}
+				}
+			}
+			done := warmDone{Done: true, warmCounts: counts, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err := r.Context().Err(); err != nil {
+				done.Error = ctxErrMsg(err)
+			}
+			if writeErr == nil {
+				_ = enc.Encode(done)
+			}
+		} else {
+			out := make([]warmResult, len(items))
+			for res := range results {
+				counts.add(res.Status)
+				out[res.Index] = res
+			}
+			j(w, http.StatusOK, map[string]any{
+				"results":     out,
+				"counts":      counts,
+				"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			})
+		}
+		auditParam(r.Context(), "counts", counts)
+		slog.Info("cache warm", "admin_id", adminFrom(r.Context()), "phrases", len(items),
+			"warmed", counts.Warmed, "already_cached", counts.AlreadyCached, "failed", counts.Failed,
+			"duration", time.Since(start))
+	}
+}
+
+// warmPhrases translates items on at most workers goroutines and sends each result as it
+// finishes; the channel closes after the last. Items not started before ctx is done fail with
+// the context error. The caller must drain the channel.
+func warmPhrases(ctx context.Context, svc *translateService, items []warmItem, workers int) <-chan warmResult {
+	out := make(chan warmResult)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(workers, 1), len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				out <- warmPhrase(ctx, svc, i, items[i])
+			}
+		}()
+	}
+	go func() {
+		defer func() {
+			close(jobs)
+			wg.Wait()
+			close(out)
+		}()
+		for i := range items {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				for k := i; k < len(items); k++ {
+					out <- warmResult{Index: k, Q: items[k].Q, Status: warmFailed, Error: ctxErrMsg(ctx.Err())}
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// warmPhrase resolves one item the way a client's request would be. Pack matches never reach
+// the cache, so they count as already cached: there is nothing to warm.
+func warmPhrase(ctx context.Context, svc *translateService, i int, it warmItem) warmResult {
+	out := warmResult{Index: i, Q: it.Q}
+	res, err := svc.resolve(ctx, translateReq{Q: it.Q, Src: it.Src, Dst: it.Dst, Extended: it.Extended})
+	switch {
+	case err != nil:
+		out.Status, out.Error = warmFailed, err.Error()
+		return out
+	case res.Cached || res.Pack != "":
+		out.Status = warmAlreadyCached
+	default:
+		out.Status = warmWarmed
+	}
+	out.Src, out.Dst = res.Pair.Src, res.Pair.Dst
+	return out
+}
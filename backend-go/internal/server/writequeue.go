@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writeQueue is a store's background writer. Handlers enqueue writes without waiting; when the
+// queue is full the write is dropped and counted, so a slow disk costs cached copies, not
+// request latency or goroutines. One goroutine takes what has queued, up to a batch at a time,
+// coalesces the writes to the same key, and applies them in one transaction. drain stops the
+// queue and waits for what is left to land, until its deadline; what is still queued then is
+// dropped, so closing the store waits for one batch at most.
+type writeQueue[W any] struct {
+	name     string // the store, for metrics and logs
+	ch       chan W
+	maxBatch int
+	key      func(W) string       // writes with the same key coalesce
+	merge    func(prev, next W) W // into this
+	apply    func([]W) error      // one batch, atomically
+
+	mu      sync.RWMutex // held to send, and to close ch
+	closed  bool
+	abandon chan struct{} // closed when a drain runs out of time
+	once    sync.Once
+	done    chan struct{} // closed when run returns
+
+	counts writeCounts
+}
+
+// writeCounts is a queue's writes applied and dropped since it started, for the shutdown report.
+type writeCounts struct {
+	store            string
+	applied, dropped atomic.Int64
+}
+
+func (c *writeCounts) load() shutdownWrites {
+	return shutdownWrites{Flushed: c.applied.Load(), Dropped: c.dropped.Load()}
+}
+
+// writeQueueOpts is WRITE_QUEUE_SIZE and WRITE_BATCH_MAX.
+type writeQueueOpts struct {
+	Size  int
+	Batch int
+}
+
+func newWriteQueue[W any](name string, opts writeQueueOpts, key func(W) string, merge func(prev, next W) W, apply func([]W) error) *writeQueue[W] {
+	q := &writeQueue[W]{name: name, ch: make(chan W, opts.Size), maxBatch: opts.Batch, key: key, merge: merge, apply: apply,
+		abandon: make(chan struct{}), done: make(chan struct{})}
+	q.counts.store = name
+	go q.run()
+	return q
+}
+
+// enqueue queues w, reporting false when it was dropped: the queue is full or draining.
+func (q *writeQueue[W]) enqueue(w W) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	why := "closed"
+	if !q.closed {
+		select {
+		case q.ch <- w:
+			metricWriteQueueDepth.WithLabelValues(q.name).Set(float64(len(q.ch)))
+			return true
+		default:
+			why = "full"
+		}
+	}
+	metricWriteQueueDropped.WithLabelValues(q.name).Inc()
+	q.counts.dropped.Add(1)
+	slog.Warn("write queue "+why+", dropping write", "store", q.name)
+	return false
+}
+
+func (q *writeQueue[W]) run() {
+	defer close(q.done)
+	for w := range q.ch {
+		select {
+		case <-q.abandon:
+			dropped := 1 + len(q.ch)
+			metricWriteQueueDropped.WithLabelValues(q.name).Add(float64(dropped))
+			q.counts.dropped.Add(int64(dropped))
+			slog.Warn("write queue abandoned at shutdown", "store", q.name, "dropped", dropped)
+			return
+		default:
+		}
+		batch := []W{w}
+	more:
+		for len(batch) < q.maxBatch {
+			select {
+			case w, ok := <-q.ch:
+				if !ok {
+					break more
+				}
+				batch = append(batch, w)
+			default:
+				break more
+			}
+		}
+		metricWriteQueueDepth.WithLabelValues(q.name).Set(float64(len(q.ch)))
+		q.write(batch)
+	}
+}
+
+// write coalesces batch and applies it.
+func (q *writeQueue[W]) write(batch []W) {
+	writes := coalesce(batch, q.key, q.merge)
+	metricWriteBatchSize.WithLabelValues(q.name).Observe(float64(len(writes)))
+	start := time.Now()
+	err := q.apply(writes)
+	metricWriteSeconds.WithLabelValues(q.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		q.counts.dropped.Add(int64(len(batch)))
+		slog.Warn("write batch failed", "store", q.name, "writes", len(writes), "err", err)
+		return
+	}
+	q.counts.applied.Add(int64(len(batch)))
+}
+
+// coalesce merges the writes in batch with the same key, in the order their keys first appear.
+func coalesce[W any](batch []W, key func(W) string, merge func(prev, next W) W) []W {
+	at := make(map[string]int, len(batch))
+	out := batch[:0:0]
+	for _, w := range batch {
+		k := key(w)
+		if i, ok := at[k]; ok {
+			out[i] = merge(out[i], w)
+			continue
+		}
+		at[k] = len(out)
+		out = append(out, w)
+	}
+	return out
+}
+
+// drain stops taking writes and waits for the queued ones to be applied. When ctx ends first the
+// rest are dropped, and the error says how many were left. It is safe to call more than once.
+func (q *writeQueue[W]) drain(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.once.Do(func() { close(q.abandon) })
+		return fmt.Errorf("%s writes: %d still queued: %w", q.name, len(q.ch), ctx.Err())
+	}
+}
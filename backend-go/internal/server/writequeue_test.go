@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testWrite is a write of n to key; merging adds up n.
+type testWrite struct {
+	key string
+	n   int
+}
+
+func testWriteKey(w testWrite) string { return w.key }
+
+func mergeTestWrites(prev, next testWrite) testWrite { return testWrite{prev.key, prev.n + next.n} }
+
+// gatedStore records the batches applied to it. Each apply announces itself on entered, then
+// waits for gate, so a test can hold the writer inside a batch.
+type gatedStore struct {
+	entered chan struct{}
+	gate    chan struct{} // a send lets one batch through, closing lets every one
+
+	mu      sync.Mutex
+	batches [][]testWrite
+}
+
+func newGatedStore() *gatedStore {
+	return &gatedStore{entered: make(chan struct{}, 100), gate: make(chan struct{})}
+}
+
+func (s *gatedStore) apply(ws []testWrite) error {
+	s.entered <- struct{}{}
+	<-s.gate
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, slices.Clone(ws))
+	return nil
+}
+
+func (s *gatedStore) applied() [][]testWrite {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.batches)
+}
+
+// waitEntered waits for the writer to be inside a batch.
+func (s *gatedStore) waitEntered(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writer never started a batch")
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	tests := []struct {
+		name  string
+		batch []testWrite
+		want  []testWrite
+	}{
+		{"empty", nil, []testWrite{}},
+		{"distinct", []testWrite{{"a", 1}, {"b", 1}}, []testWrite{{"a", 1}, {"b", 1}}},
+		{"duplicates merged", []testWrite{{"a", 1}, {"a", 2}, {"a", 3}}, []testWrite{{"a", 6}}},
+		{"first appearance keeps the order", []testWrite{{"b", 1}, {"a", 1}, {"b", 1}, {"c", 1}, {"a", 1}}, []testWrite{{"b", 2}, {"a", 2}, {"c", 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := slices.Clone(tt.batch)
+			if got := coalesce(in, testWriteKey, mergeTestWrites); !slices.Equal(got, tt.want) {
+				t.Fatalf("coalesced %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(in, tt.batch) {
+				t.Fatalf("batch changed to %v", in)
+			}
+		})
+	}
+}
+
+// TestWriteQueueOverflow holds the writer in a batch, fills the queue behind it and checks the
+// writes past its size are dropped and counted without blocking.
+func TestWriteQueueOverflow(t *testing.T) {
+	store := newGatedStore()
+	q := newWriteQueue("test_overflow", writeQueueOpts{Size: 2, Batch: 10}, testWriteKey, mergeTestWrites, store.apply)
+	dropped := metricWriteQueueDropped.WithLabelValues("test_overflow")
+	before := testutil.ToFloat64(dropped)
+	steps := []struct {
+		key    string
+		queued bool
+	}{
+		{"held", true}, // taken by the writer, which waits in apply
+		{"a", true},
+		{"b", true},
+		{"c", false},
+		{"d", false},
+	}
+	for i, st := range steps {
+		start := time.Now()
+		if got := q.enqueue(testWrite{st.key, 1}); got != st.queued {
+			t.Fatalf("enqueue %s: %v, want %v", st.key, got, st.queued)
+		}
+		if took := time.Since(start); took > time.Second {
+			t.Fatalf("enqueue %s blocked for %v", st.key, took)
+		}
+		if i == 0 {
+			store.waitEntered(t)
+		}
+	}
+	if got := testutil.ToFloat64(dropped) - before; got != 2 {
+		t.Fatalf("%v drops counted, want 2", got)
+	}
+	if got := testutil.ToFloat64(metricWriteQueueDepth.WithLabelValues("test_overflow")); got != 2 {
+		t.Fatalf("queue depth %v, want 2", got)
+	}
+	close(store.gate)
+	if err := q.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.applied(); !slices.EqualFunc(got, [][]testWrite{{{"held", 1}}, {{"a", 1}, {"b", 1}}}, slices.Equal) {
+		t.Fatalf("batches %v", got)
+	}
+	if c := q.counts.load(); c.Flushed != 3 || c.Dropped != 2 {
+		t.Fatalf("counts %+v, want 3 flushed and 2 dropped", c)
+	}
+	if q.enqueue(testWrite{"late", 1}) || testutil.ToFloat64(dropped)-before != 3 {
+		t.Fatal("write after the drain queued or not counted")
+	}
+}
+
+// TestWriteQueueBatches queues writes behind a held batch and checks how they are split into
+// batches and coalesced.
+func TestWriteQueueBatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		batch   int
+		writes  string // keys, one write of 1 each
+		batches [][]testWrite
+	}{
+		{"duplicates coalesce", 10, "aabaca", [][]testWrite{{{"a", 4}, {"b", 1}, {"c", 1}}}},
+		{"batch max bounds a batch", 2, "abcde", [][]testWrite{{{"a", 1}, {"b", 1}}, {{"c", 1}, {"d", 1}}, {{"e", 1}}}},
+		{"coalesced within a batch only", 3, "aaaaa", [][]testWrite{{{"a", 3}}, {{"a", 2}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newGatedStore()
+			name := "test_batches_" + strconv.Itoa(tt.batch)
+			q := newWriteQueue(name, writeQueueOpts{Size: 100, Batch: tt.batch}, testWriteKey, mergeTestWrites, store.apply)
+			q.enqueue(testWrite{"held", 1})
+			store.waitEntered(t)
+			for _, k := range tt.writes {
+				q.enqueue(testWrite{string(k), 1})
+			}
+			close(store.gate)
+			if err := q.drain(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			want := append([][]testWrite{{{"held", 1}}}, tt.batches...)
+			if got := store.applied(); !slices.EqualFunc(got, want, slices.Equal) {
+				t.Fatalf("batches %v, want %v", got, want)
+			}
+			if c := q.counts.load(); c.Flushed != int64(1+len(tt.writes)) || c.Dropped != 0 {
+				t.Fatalf("counts %+v, want all %d flushed", c, 1+len(tt.writes))
+			}
+		})
+	}
+}
+
+// TestWriteQueueDrain flushes queues behind slow stores at shutdown: one slow store, let through
+// a batch at a time, finishes within the deadline with the drain waiting on every batch, and
+// one stuck in a batch past it has the rest dropped, and counted, so the shutdown waits no
+// longer.
+func TestWriteQueueDrain(t *testing.T) {
+	tests := []struct {
+		name     string
+		stuck    bool // the first batch waits until the drain gives up
+		deadline time.Duration
+		flushed  int64
+		dropped  int64
+	}{
+		{"slow store within the deadline", false, 5 * time.Second, 6, 0},
+		{"stuck store past the deadline", true, 50 * time.Millisecond, 1, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newGatedStore()
+			q := newWriteQueue("test_drain", writeQueueOpts{Size: 10, Batch: 2}, testWriteKey, mergeTestWrites, store.apply)
+			q.enqueue(testWrite{"held", 1})
+			store.waitEntered(t)
+			for _, k := range "abcde" {
+				q.enqueue(testWrite{string(k), 1})
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), tt.deadline)
+			defer cancel()
+			start := time.Now()
+			drained := make(chan error, 1)
+			go func() { drained <- q.drain(ctx) }()
+			if !tt.stuck {
+				// held, then a and b, c and d, and e, each batch let through once the last landed.
+				for i := range 4 {
+					if i > 0 {
+						store.waitEntered(t)
+					}
+					select {
+					case err := <-drained:
+						t.Fatalf("drain returned %v with batch %d still to apply", err, i+1)
+					default:
+					}
+					store.gate <- struct{}{}
+				}
+			}
+			err := <-drained
+			if took := time.Since(start); took > tt.deadline+time.Second {
+				t.Fatalf("drain took %v past a %v deadline", took, tt.deadline)
+			}
+			if tt.stuck {
+				if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "5 still queued") {
+					t.Fatalf("drain: %v, want the deadline with 5 still queued", err)
+				}
+				close(store.gate) // the batch in hand lands; the writer drops the rest
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			<-q.done
+			if c := q.counts.load(); c.Flushed != tt.flushed || c.Dropped != tt.dropped {
+				t.Fatalf("counts %+v, want %d flushed and %d dropped", c, tt.flushed, tt.dropped)
+			}
+			if err := q.drain(context.Background()); err != nil {
+				t.Fatalf("second drain: %v", err)
+			}
+		})
+	}
+}
+
+// TestSQLiteCacheDrain puts entries through the cache DB's queue, drains it as a shutdown would,
+// and checks the last put of each key landed.
+func TestSQLiteCacheDrain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	opts := sqliteCacheOpts{Retention: time.Hour, PruneInterval: time.Hour, VacuumInterval: time.Hour, Writes: writeQueueOpts{Size: 100, Batch: 8}, Clock: clk}
+	c, err := openSQLiteCache(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 30 {
+		key := "k" + strconv.Itoa(i%10)
+		c.Put(key, translateReq{Q: key}, translateResult{Translation: key + "-" + strconv.Itoa(i), Src: "upstream"})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = openSQLiteCache(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	for i := 20; i < 30; i++ {
+		key := "k" + strconv.Itoa(i%10)
+		res, _, ok := c.Get(context.Background(), key)
+		if want := key + "-" + strconv.Itoa(i); !ok || res.Translation != want {
+			t.Fatalf("%s: %q, %v; want %q", key, res.Translation, ok, want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// wsOpts bounds an interactive translation session.
+type wsOpts struct {
+	MaxMessageBytes int64
+	Debounce        time.Duration // quiet period before the latest input is translated
+	PingInterval    time.Duration
+	RatePerMin      int // messages per minute per connection
+	RateBurst       int
+	Origins         []string // CORS_ALLOWED_ORIGINS; empty allows same-origin only
+	Clock           Clock
+}
+
+// wsIn is a client message; Seq is echoed back so the client can match replies.
+type wsIn struct {
+	Seq int64  `json:"seq"`
+	Q   string `json:"q"`
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+	// AllowBidi is translateReq.AllowBidi for this message.
+	AllowBidi bool `json:"allow_bidi"`
+}
+
+type wsOut struct {
+	Seq         int64  `json:"seq"`
+	Translation string `json:"translation,omitempty"`
+	Src         string `json:"src,omitempty"`
+	Error       string `json:"error,omitempty"`
+	RetryAfter  int    `json:"retry_after,omitempty"`
+}
+
+// wsHandler upgrades /go/ws to a WebSocket for translate-as-you-type. Clients send
+// {"seq","q"} messages and get {"seq","translation"} replies. Input is debounced per connection:
+// only the newest message pending when the connection goes quiet is translated, and superseded
+// seqs get no reply. The session ends when the client leaves, stops answering pings, or
+// shutdown starts (close code 1001).
+func wsHandler(t Translator, opts wsOpts, sessions *sessionGroup) http.HandlerFunc {
+	accept := &websocket.AcceptOptions{}
+	for _, o := range opts.Origins {
+		if o == "*" {
+			accept.InsecureSkipVerify = true
+		} else if u, err := url.Parse(o); err == nil && u.Host != "" {
+			accept.OriginPatterns = append(accept.OriginPatterns, u.Host)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Plain requests get the usual error envelope; Accept answers the rarer handshake
+		// failures (bad version, refused origin) itself, in plain text.
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			w.Header().Set("Upgrade", "websocket")
+			writeError(w, http.StatusUpgradeRequired, codeBadRequest, "expected a WebSocket upgrade")
+			return
+		}
+		defer sessions.enter()()
+
+		// The server's read/write timeouts are already armed on the connection; a session
+		// lives for as long as it keeps answering pings.
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		c, err := websocket.Accept(w, r, accept)
+		if err != nil {
+			return // Accept has already written the error response
+		}
+		defer c.CloseNow()
+		c.SetReadLimit(opts.MaxMessageBytes)
+		metricWSConnections.Inc()
+		defer metricWSConnections.Dec()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		stop := context.AfterFunc(sessions.ctx, func() { c.Close(websocket.StatusGoingAway, "server shutting down") })
+		defer stop()
+
+		s := &wsSession{
+			conn:    c,
+			t:       t,
+			opts:    opts,
+			limiter: newRateLimiter(opts.RatePerMin, opts.RateBurst, time.Minute, opts.Clock),
+			wake:    make(chan struct{}, 1),
+		}
+		go s.keepAlive(ctx, cancel)
+		go s.translateLoop(ctx)
+		err = s.readLoop(ctx)
+		if websocket.CloseStatus(err) == -1 && !errors.Is(err, context.Canceled) {
+			slog.Debug("websocket session ended", "request_id", middleware.GetReqID(r.Context()), "err", err)
+		}
+	}
+}
+
+// wsSession is one connection's state. pending holds the newest untranslated input.
+type wsSession struct {
+	conn    *websocket.Conn
+	t       Translator
+	opts    wsOpts
+	limiter *rateLimiter
+
+	mu      sync.Mutex
+	pending *wsIn
+	wake    chan struct{}
+}
+
+func (s *wsSession) readLoop(ctx context.Context) error {
+	for {
+		_, data, err := s.conn.Read(ctx)
+		if err != nil {
+			return err
+		}
+		var in wsIn
+		if err := json.Unmarshal(data, &in); err != nil {
+			s.reply(ctx, wsOut{Error: "invalid JSON message"})
+			continue
+		}
+		if strings.TrimSpace(in.Q) == "" {
+			s.reply(ctx, wsOut{Seq: in.Seq, Error: "missing field 'q'"})
+			continue
+		}
+		if d := s.limiter.take(""); !d.Allowed {
+			s.reply(ctx, wsOut{Seq: in.Seq, Error: "rate limit exceeded", RetryAfter: int(math.Ceil(d.RetryAfter.Seconds()))})
+			continue
+		}
+		s.mu.Lock()
+		s.pending = &in
+		s.mu.Unlock()
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// translateLoop waits for input to settle for opts.Debounce, then translates whatever is newest.
+// Input arriving during a translation is picked up on the next pass.
+func (s *wsSession) translateLoop(ctx context.Context) {
+	timer := s.opts.Clock.NewTimer(s.opts.Debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		}
+		timer.Reset(s.opts.Debounce)
+	settle:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-s.wake:
+				if !timer.Stop() {
+					<-timer.C()
+				}
+				timer.Reset(s.opts.Debounce)
+			case <-timer.C():
+				break settle
+			}
+		}
+
+		s.mu.Lock()
+		in := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+		if in == nil {
+			continue
+		}
+		res, err := s.t.Translate(ctx, translateReq{Q: in.Q, Src: in.Src, Dst: in.Dst, AllowBidi: in.AllowBidi})
+		out := wsOut{Seq: in.Seq, Translation: res.Translation, Src: res.Src}
+		if err != nil {
+			out = wsOut{Seq: in.Seq, Error: err.Error()}
+		}
+		s.reply(ctx, out)
+	}
+}
+
+// keepAlive pings every opts.PingInterval and ends the session when a pong doesn't come back in time.
+func (s *wsSession) keepAlive(ctx context.Context, cancel context.CancelFunc) {
+	tick := s.opts.Clock.NewTicker(s.opts.PingInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C():
+			pctx, done := context.WithTimeout(ctx, s.opts.PingInterval)
+			err := s.conn.Ping(pctx)
+			done()
+			if err != nil {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func (s *wsSession) reply(ctx context.Context, out wsOut) {
+	if err := wsjson.Write(ctx, s.conn, out); err != nil && ctx.Err() == nil {
+		slog.Debug("websocket write failed", "request_id", middleware.GetReqID(ctx), "err", err)
+	}
+}
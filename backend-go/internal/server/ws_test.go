@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// wsTestServer serves a test server on a real listener, on a fake clock, returning the clock
+// and the /go/ws URL.
+func wsTestServer(t *testing.T, env map[string]string) (*Server, *FakeClock, string) {
+	t.Helper()
+	clk := NewFakeClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := newTestServer(t, env, Deps{Clock: clk})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.serveHTTP(ln)
+	return s, clk, "ws://" + ln.Addr().String() + "/go/ws"
+}
+
+func dialWS(t *testing.T, ctx context.Context, target string) *websocket.Conn {
+	t.Helper()
+	c, _, err := websocket.Dial(ctx, target+"?api_key="+testProKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.CloseNow() })
+	return c
+}
+
+func readWS(t *testing.T, ctx context.Context, c *websocket.Conn) wsOut {
+	t.Helper()
+	var out wsOut
+	if err := wsjson.Read(ctx, c, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// dialRawWS opens a WebSocket to target by hand, over a connection that never answers a ping.
+func dialRawWS(t *testing.T, target string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET %s?api_key=%s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", u.Path, testProKey, u.Host)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %d, want 101", resp.StatusCode)
+	}
+	return conn, br
+}
+
+func TestWSAuth(t *testing.T) {
+	_, _, target := wsTestServer(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tests := []struct {
+		name   string
+		query  string
+		header http.Header
+		status int
+	}{
+		{"no key", "", nil, http.StatusUnauthorized},
+		{"bad key", "?api_key=nope", nil, http.StatusUnauthorized},
+		{"query key", "?api_key=" + testProKey, nil, http.StatusSwitchingProtocols},
+		{"header key", "", http.Header{"X-Api-Key": {testFreeKey}}, http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, resp, err := websocket.Dial(ctx, target+tt.query, &websocket.DialOptions{HTTPHeader: tt.header})
+			if c != nil {
+				c.CloseNow()
+			}
+			if resp == nil {
+				t.Fatalf("no handshake response: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d (%v)", resp.StatusCode, tt.status, err)
+			}
+		})
+	}
+}
+
+func TestWSDebouncesToLatest(t *testing.T) {
+	_, clk, target := wsTestServer(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := dialWS(t, ctx, target)
+	for i, q := range []string{"h", "hel", "hello"} {
+		if err := wsjson.Write(ctx, c, wsIn{Seq: int64(i + 1), Q: q}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Messages are read in order and an empty one is answered at once, so by its reply the
+	// three before it are in.
+	wsjson.Write(ctx, c, wsIn{Seq: 10})
+	if out := readWS(t, ctx, c); out.Seq != 10 || out.Error == "" {
+		t.Fatalf("got %+v, want the error for seq 10", out)
+	}
+	replies := make(chan wsOut, 1)
+	go func() {
+		var out wsOut
+		wsjson.Read(ctx, c, &out)
+		replies <- out
+	}()
+	var out wsOut
+	for out.Seq == 0 {
+		select {
+		case out = <-replies:
+		case <-time.After(10 * time.Millisecond):
+			clk.Advance(150 * time.Millisecond) // WS_DEBOUNCE
+		}
+	}
+	if out.Seq != 3 || out.Translation != "EN(hello)" {
+		t.Fatalf("got %+v, want seq 3 translated", out)
+	}
+	// The superseded seqs get no reply: the next message is the answer to the one after.
+	wsjson.Write(ctx, c, wsIn{Seq: 11})
+	if out := readWS(t, ctx, c); out.Seq != 11 {
+		t.Fatalf("got %+v, want seq 11", out)
+	}
+}
+
+func TestWSLimits(t *testing.T) {
+	_, _, target := wsTestServer(t, map[string]string{"WS_RATE_LIMIT_BURST": "2", "WS_MAX_MESSAGE_BYTES": "64"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	t.Run("rate", func(t *testing.T) {
+		c := dialWS(t, ctx, target)
+		for seq := range int64(3) {
+			wsjson.Write(ctx, c, wsIn{Seq: seq + 1, Q: "hello"})
+		}
+		// The clock stands still, so nothing is translated and the refusal is the only reply.
+		out := readWS(t, ctx, c)
+		if out.Seq != 3 || out.Error != "rate limit exceeded" || out.RetryAfter < 1 {
+			t.Fatalf("got %+v, want seq 3 rate limited", out)
+		}
+	})
+	t.Run("message size", func(t *testing.T) {
+		c := dialWS(t, ctx, target)
+		wsjson.Write(ctx, c, wsIn{Seq: 1, Q: strings.Repeat("x", 100)})
+		_, _, err := c.Read(ctx)
+		if got := websocket.CloseStatus(err); got != websocket.StatusMessageTooBig {
+			t.Fatalf("close status %v (%v), want %v", got, err, websocket.StatusMessageTooBig)
+		}
+	})
+}
+
+func TestWSKeepAlive(t *testing.T) {
+	s, clk, target := wsTestServer(t, map[string]string{"WS_PING_INTERVAL": "100ms"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	base := testutil.ToFloat64(metricWSConnections)
+
+	// A session is counted before its keep-alive ticker is armed, so each waits for one more.
+	// A reading client answers the pings and stays connected: each tick's ping is answered
+	// while it waits on a reply, and one comes back after every tick.
+	armed := clk.Waiters()
+	answering := dialWS(t, ctx, target)
+	if err := clk.BlockUntil(ctx, armed+1); err != nil {
+		t.Fatal("the session never started")
+	}
+	var seq int64
+	tick := func() {
+		t.Helper()
+		clk.Advance(100 * time.Millisecond)
+		seq++
+		if err := wsjson.Write(ctx, answering, wsIn{Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+		if out := readWS(t, ctx, answering); out.Seq != seq {
+			t.Fatalf("got %+v, want the reply to seq %d", out, seq)
+		}
+	}
+	for range 5 {
+		tick()
+	}
+	if got := testutil.ToFloat64(metricWSConnections); got != base+1 {
+		t.Fatalf("%v connections after answered pings, want %v", got, base+1)
+	}
+
+	// One that never reads never pongs, and is dropped: the server hangs up once the ping
+	// after the next tick times out.
+	armed = clk.Waiters()
+	silent, br := dialRawWS(t, target)
+	if err := clk.BlockUntil(ctx, armed+1); err != nil {
+		t.Fatal("the session never started")
+	}
+	tick()
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		t.Fatalf("silent client not dropped: %v", err)
+	}
+	silent.Close()
+	tick()
+	// Stop waits for both sessions' handlers, so by its return neither is counted.
+	answering.CloseRead(ctx) // to answer Stop's close
+	s.Stop(ctx, StopCause{})
+	if got := testutil.ToFloat64(metricWSConnections); got != base {
+		t.Fatalf("%v connections after the silent client was dropped and Stop, want %v", got, base)
+	}
+}
+
+func TestWSClosedOnShutdown(t *testing.T) {
+	s, _, target := wsTestServer(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := dialWS(t, ctx, target)
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := c.Read(ctx)
+		read <- err
+	}()
+	if rep := s.Stop(ctx, StopCause{}); rep == nil {
+		t.Fatal("no shutdown report")
+	}
+	if got := websocket.CloseStatus(<-read); got != websocket.StatusGoingAway {
+		t.Fatalf("close status %v, want %v", got, websocket.StatusGoingAway)
+	}
+}
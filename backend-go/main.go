@@ -3,104 +3,106 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
-	"net/http"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
-	"strings"
-	"time"
+	"syscall"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sartu01/dhkalign/backend-go/internal/server"
 )
 
-// j writes JSON with status code.
-func j(w http.ResponseWriter, code int, v any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	_ = json.NewEncoder(w).Encode(v)
-}
-
-var startedAt = time.Now().UTC()
-
 func main() {
-	// Port from env (Fly/Heroku style)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Config is read and validated once; bad values abort startup with the full list.
+	set, err := server.LoadSettings(os.Args[1:], os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(server.ExitConfig)
 	}
-
-	// Router + essential middlewares (no CORS needed for server-to-server)
-	r := chi.NewRouter()
-	r.Use(
-		middleware.RequestID,
-		middleware.RealIP,
-		middleware.Recoverer,
-		middleware.Timeout(15*time.Second),
-	)
-
-	// Health and version endpoints (under /go/*)
-	r.Get("/go/health", func(w http.ResponseWriter, _ *http.Request) {
-		j(w, http.StatusOK, map[string]any{
-			"status": "ok",
-			"ts":     time.Now().UTC().Format(time.RFC3339),
-			"uptime": time.Since(startedAt).String(),
-		})
-	})
-
-	r.Get("/go/version", func(w http.ResponseWriter, _ *http.Request) {
-		sha := os.Getenv("COMMIT_SHA")
-		if sha == "" {
-			sha = "dev"
+	cfg := set.Config
+	if set.Validate {
+		fmt.Printf("configuration is valid (fingerprint %s)\n", set.Fingerprint())
+		return
+	}
+	// --migrate-dry-run and --migrate-only print each SQLite store's migration plan, and the
+	// second applies it, so a deploy can migrate ahead of starting the new build.
+	if set.MigrateOnly || set.MigrateDryRun {
+		if err := server.Migrate(cfg, set.MigrateDryRun, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-		build := os.Getenv("BUILD_TIME")
-		j(w, http.StatusOK, map[string]any{
-			"sha":        sha,
-			"build_time": build,
-		})
-	})
-
-	// Simple stub translate endpoint (echoes input; replace with real logic/proxy later)
-	r.Get("/go/translate", func(w http.ResponseWriter, r *http.Request) {
-		q := r.URL.Query().Get("q")
-		if strings.TrimSpace(q) == "" {
-			j(w, http.StatusBadRequest, map[string]any{"error": "missing query param 'q'"})
-			return
+		return
+	}
+	// --selftest builds everything and drives a canary through it, for CI or a deploy step:
+	// the JSON report goes to stdout, the logs to stderr, and a failure exits 1.
+	if set.SelfTest {
+		slog.SetDefault(server.NewLoggerTo(os.Stderr, cfg.LogLevel))
+		rep := server.SelfTest(context.Background(), cfg, server.Deps{Settings: &set})
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+		if !rep.OK {
+			os.Exit(1)
 		}
-		j(w, http.StatusOK, map[string]any{
-			"translation": q, // stub: echo
-			"src":         "stub",
-			"ts":          time.Now().UTC().Format(time.RFC3339),
-		})
-	})
+		return
+	}
+	slog.SetDefault(server.NewLogger(cfg.LogLevel))
+	slog.Info("config loaded", "env", cfg.Env)
 
-	// HTTP server with sane timeouts
-	srv := &http.Server{
-		Addr:              ":" + port,
-		Handler:           r,
-		ReadHeaderTimeout: 5 * time.Second,
-		ReadTimeout:       10 * time.Second,
-		WriteTimeout:      10 * time.Second,
-		IdleTimeout:       60 * time.Second,
+	// Tracing is a no-op unless the standard OTEL_EXPORTER_OTLP_* env vars are set.
+	shutdownTracing, err := server.SetupTracing(context.Background())
+	if err != nil {
+		fatal("tracing setup failed", "err", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	// Start server
-	go func() {
-		log.Printf("backend-go listening on :%s", port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("server error: %v", err)
-		}
-	}()
+	// Everything else is built from cfg: the stores, the upstream, the packs and the clock. The
+	// listener opens first and answers /go/health/live and /go/health/startup meanwhile, so a
+	// slow pack load isn't taken for a dead instance; a setup failure arrives on srv.Err.
+	bg, stop := context.WithCancel(context.Background())
+	defer stop()
+	srv, err := server.Boot(bg, cfg, server.Deps{Settings: &set})
+	if err != nil {
+		fatal("server start failed", "err", err)
+	}
 
-	// Graceful shutdown on SIGINT/SIGTERM
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	<-stop
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("graceful shutdown failed: %v", err)
-	} else {
-		log.Printf("shutdown complete")
+	// SIGHUP reloads the configuration. SIGINT and SIGTERM (Fly sends SIGTERM on deploy) shut
+	// down gracefully, waiting for in-flight requests up to SHUTDOWN_TIMEOUT; so does an error
+	// on srv.Err, a listener failing or the build.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM)
+	var cause server.StopCause
+	for cause.Signal == nil && cause.Err == nil {
+		select {
+		case s := <-signals:
+			if s == syscall.SIGHUP {
+				_ = srv.Reload("sighup") // an invalid configuration is logged and the running one kept
+				continue
+			}
+			cause.Signal = s
+		case err := <-srv.Err():
+			slog.Error("server error", "err", err)
+			cause.Err = err
+		}
 	}
+	slog.Info("shutdown started", "timeout", cfg.ShutdownTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	rep := srv.Stop(ctx, cause)
+	cancel()
+	stop()
+	shutdownTracing(context.Background())
+	// The report, logged by Stop, says which code this is: clean, a drain that ran out of time,
+	// a fatal error or a start that failed.
+	os.Exit(rep.ExitCode)
+}
+
+// fatal logs at error level and exits; used for startup failures.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(server.ExitStartupFailed)
 }